@@ -0,0 +1,179 @@
+package chain
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"go.sia.tech/core/types"
+)
+
+// Column names of the tables written by a CSVExporter. Columns may be added
+// to the end of a table in future versions, but existing columns will not be
+// removed, renamed, or reordered.
+var (
+	BlockColumns = []string{
+		"event", "height", "block_id", "parent_id", "timestamp", "miner_address", "transactions",
+	}
+	TransactionColumns = []string{
+		"event", "height", "block_id", "transaction_id", "index",
+		"siacoin_inputs", "siacoin_outputs", "siafund_inputs", "siafund_outputs",
+		"file_contracts", "file_contract_revisions", "file_contract_resolutions",
+		"attestations", "arbitrary_data_bytes", "miner_fee",
+	}
+	ElementColumns = []string{
+		"event", "height", "block_id", "element_type", "action", "element_id", "address", "value",
+	}
+)
+
+// Values of the event column.
+const (
+	ExportEventApply  = "apply"
+	ExportEventRevert = "revert"
+)
+
+// A CSVExporter flattens chain updates into CSV rows, suitable for loading into
+// data-science tools. It writes three tables, whose columns are given by
+// BlockColumns, TransactionColumns, and ElementColumns; the first row of each
+// table is a header.
+//
+// Every row records whether it originates from an applied or a reverted block.
+// Consumers that want the state of the best chain should delete the rows of a
+// block when it is reverted. Elements are recorded as created, spent, revised,
+// or resolved; values are in hastings (or siafunds), and timestamps are in
+// seconds since the Unix epoch.
+//
+// A CSVExporter implements Subscriber. Rows are buffered, and flushed when
+// mayCommit is set.
+type CSVExporter struct {
+	blocks   *csv.Writer
+	txns     *csv.Writer
+	elements *csv.Writer
+	header   bool
+}
+
+func (e *CSVExporter) writeHeaders() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	if err := e.blocks.Write(BlockColumns); err != nil {
+		return err
+	} else if err := e.txns.Write(TransactionColumns); err != nil {
+		return err
+	}
+	return e.elements.Write(ElementColumns)
+}
+
+func (e *CSVExporter) writeBlock(event string, b types.Block, sces []types.SiacoinElement, sfes []types.SiafundElement, fces []types.FileContractElement, spentSC []types.SiacoinElement, spentSF []types.SiafundElement, revised, resolved []types.FileContractElement) error {
+	if err := e.writeHeaders(); err != nil {
+		return err
+	}
+	height := strconv.FormatUint(b.Header.Height, 10)
+	bid := b.ID().String()
+	itoa := strconv.Itoa
+
+	err := e.blocks.Write([]string{
+		event, height, bid, b.Header.ParentID.String(),
+		strconv.FormatInt(b.Header.Timestamp.Unix(), 10),
+		b.Header.MinerAddress.String(), itoa(len(b.Transactions)),
+	})
+	if err != nil {
+		return err
+	}
+	for i, txn := range b.Transactions {
+		err := e.txns.Write([]string{
+			event, height, bid, txn.ID().String(), itoa(i),
+			itoa(len(txn.SiacoinInputs)), itoa(len(txn.SiacoinOutputs)),
+			itoa(len(txn.SiafundInputs)), itoa(len(txn.SiafundOutputs)),
+			itoa(len(txn.FileContracts)), itoa(len(txn.FileContractRevisions)),
+			itoa(len(txn.FileContractResolutions)), itoa(len(txn.Attestations)),
+			itoa(len(txn.ArbitraryData)), txn.MinerFee.ExactString(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	element := func(typ, action string, id types.ElementID, prefix string, addr types.Address, value string) error {
+		return e.elements.Write([]string{event, height, bid, typ, action, id.PrefixedString(prefix), addr.String(), value})
+	}
+	siacoin := func(action string, sces []types.SiacoinElement) error {
+		for _, sce := range sces {
+			if err := element("siacoin", action, sce.ID, types.SiacoinElementIDPrefix, sce.Address, sce.Value.ExactString()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	siafund := func(action string, sfes []types.SiafundElement) error {
+		for _, sfe := range sfes {
+			if err := element("siafund", action, sfe.ID, types.SiafundElementIDPrefix, sfe.Address, strconv.FormatUint(sfe.Value, 10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fileContract := func(action string, fces []types.FileContractElement) error {
+		for _, fce := range fces {
+			value := fce.RenterOutput.Value.Add(fce.HostOutput.Value)
+			if err := element("file_contract", action, fce.ID, types.FileContractElementIDPrefix, types.VoidAddress, value.ExactString()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := siacoin("created", sces); err != nil {
+		return err
+	} else if err := siacoin("spent", spentSC); err != nil {
+		return err
+	} else if err := siafund("created", sfes); err != nil {
+		return err
+	} else if err := siafund("spent", spentSF); err != nil {
+		return err
+	} else if err := fileContract("created", fces); err != nil {
+		return err
+	} else if err := fileContract("revised", revised); err != nil {
+		return err
+	}
+	return fileContract("resolved", resolved)
+}
+
+// ProcessChainApplyUpdate implements Subscriber.
+func (e *CSVExporter) ProcessChainApplyUpdate(cau *ApplyUpdate, mayCommit bool) error {
+	err := e.writeBlock(ExportEventApply, cau.Block, cau.NewSiacoinElements, cau.NewSiafundElements, cau.NewFileContracts,
+		cau.SpentSiacoins, cau.SpentSiafunds, cau.RevisedFileContracts, cau.ResolvedFileContracts)
+	if err != nil {
+		return err
+	} else if mayCommit {
+		return e.Flush()
+	}
+	return nil
+}
+
+// ProcessChainRevertUpdate implements Subscriber.
+func (e *CSVExporter) ProcessChainRevertUpdate(cru *RevertUpdate) error {
+	return e.writeBlock(ExportEventRevert, cru.Block, cru.NewSiacoinElements, cru.NewSiafundElements, cru.NewFileContracts,
+		cru.SpentSiacoins, cru.SpentSiafunds, cru.RevisedFileContracts, cru.ResolvedFileContracts)
+}
+
+// Flush writes any buffered rows to the underlying writers.
+func (e *CSVExporter) Flush() error {
+	for _, w := range []*csv.Writer{e.blocks, e.txns, e.elements} {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewCSVExporter returns a CSVExporter that writes the block, transaction, and
+// element tables to the provided writers.
+func NewCSVExporter(blocks, txns, elements io.Writer) *CSVExporter {
+	return &CSVExporter{
+		blocks:   csv.NewWriter(blocks),
+		txns:     csv.NewWriter(txns),
+		elements: csv.NewWriter(elements),
+	}
+}
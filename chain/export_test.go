@@ -0,0 +1,109 @@
+package chain_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/internal/chainutil"
+)
+
+func TestCSVExporter(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	cm := chain.NewManager(newTestStore(t, sim.Genesis), sim.Context)
+	defer cm.Close()
+	var blocks, txns, elements bytes.Buffer
+	e := chain.NewCSVExporter(&blocks, &txns, &elements)
+	if err := cm.AddSubscriber(e, cm.Tip()); err != nil {
+		t.Fatal(err)
+	}
+
+	// mine 3 blocks, then reorg to a longer fork from genesis
+	fork := sim.Fork()
+	for _, b := range sim.MineBlocks(3) {
+		if err := cm.AddTipBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fork.MineBlocks(2)
+	betterChain := fork.MineBlocks(2)
+	if _, err := cm.AddHeaders(chainutil.JustHeaders(fork.Chain)); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddBlocks(fork.Chain); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != betterChain[1].Index() {
+		t.Fatal("expected reorg")
+	} else if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(buf *bytes.Buffer, columns []string) [][]string {
+		t.Helper()
+		rows, err := csv.NewReader(buf).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(rows) == 0 || !reflect.DeepEqual(rows[0], columns) {
+			t.Fatal("missing or wrong header")
+		}
+		return rows[1:]
+	}
+
+	// replaying the block rows should yield the best chain
+	var chainIDs []string
+	for _, row := range read(&blocks, chain.BlockColumns) {
+		height, _ := strconv.Atoi(row[1])
+		switch row[0] {
+		case chain.ExportEventApply:
+			if height != len(chainIDs)+1 {
+				t.Fatal("applied block out of order")
+			}
+			chainIDs = append(chainIDs, row[2])
+		case chain.ExportEventRevert:
+			if height != len(chainIDs) || chainIDs[height-1] != row[2] {
+				t.Fatal("reverted block is not the tip")
+			}
+			chainIDs = chainIDs[:height-1]
+		default:
+			t.Fatal("unknown event", row[0])
+		}
+	}
+	if len(chainIDs) != len(fork.Chain) {
+		t.Fatal("wrong chain length", len(chainIDs))
+	}
+	for i, b := range fork.Chain {
+		if chainIDs[i] != b.ID().String() {
+			t.Fatal("wrong block at height", i+1)
+		}
+	}
+
+	// every transaction and element of the best chain should be present
+	txnRows := make(map[string]int)
+	for _, row := range read(&txns, chain.TransactionColumns) {
+		if row[0] == chain.ExportEventApply {
+			txnRows[row[3]]++
+		} else {
+			txnRows[row[3]]--
+		}
+	}
+	for _, b := range fork.Chain {
+		for _, txn := range b.Transactions {
+			if txnRows[txn.ID().String()] != 1 {
+				t.Fatal("missing transaction", txn.ID())
+			}
+		}
+	}
+	var created int
+	for _, row := range read(&elements, chain.ElementColumns) {
+		if len(row) != len(chain.ElementColumns) {
+			t.Fatal("wrong number of columns")
+		} else if row[0] == chain.ExportEventApply && row[4] == "created" {
+			created++
+		}
+	}
+	if created == 0 {
+		t.Fatal("no created elements")
+	}
+}
@@ -0,0 +1,179 @@
+package chain
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"go.sia.tech/core/types"
+)
+
+// A bloomFilter is a probabilistic set of hashes. Since its members are
+// already uniformly distributed, the filter derives its bit positions directly
+// from their bytes.
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+func (f *bloomFilter) positions(h types.Hash256, fn func(i uint64)) {
+	m := uint64(len(f.bits)) * 64
+	h1 := binary.LittleEndian.Uint64(h[:8])
+	h2 := binary.LittleEndian.Uint64(h[8:16]) | 1
+	for i := uint64(0); i < f.k; i++ {
+		fn((h1 + i*h2) % m)
+	}
+}
+
+func (f *bloomFilter) add(h types.Hash256) {
+	f.positions(h, func(i uint64) { f.bits[i/64] |= 1 << (i % 64) })
+}
+
+func (f *bloomFilter) mayContain(h types.Hash256) bool {
+	contains := true
+	f.positions(h, func(i uint64) { contains = contains && f.bits[i/64]&(1<<(i%64)) != 0 })
+	return contains
+}
+
+// newBloomFilter returns a filter with a false-positive rate of roughly 1% when
+// holding n items.
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	return &bloomFilter{
+		bits: make([]uint64, (n*10+63)/64),
+		k:    7,
+	}
+}
+
+// An IndexStore durably records the heights at which IDs appeared on the best
+// chain.
+type IndexStore interface {
+	// AddIDs records that the given IDs appeared in the block at index, and
+	// sets the tip of the store to index.
+	AddIDs(index types.ChainIndex, ids []types.Hash256) error
+	// RemoveIDs removes the given IDs, which appeared in a reverted block, and
+	// sets the tip of the store to the block's parent.
+	RemoveIDs(parent types.ChainIndex, ids []types.Hash256) error
+	// Height returns the height at which id appeared, if any.
+	Height(id types.Hash256) (uint64, bool, error)
+	// ForEach calls fn on every ID in the store.
+	ForEach(fn func(id types.Hash256)) error
+	// Tip returns the index of the most recent block processed by the store.
+	Tip() (types.ChainIndex, error)
+	// Flush durably commits all changes to the store.
+	Flush() error
+}
+
+// A MembershipIndex answers whether a transaction or element has appeared on
+// the best chain, and at what height. Queries for IDs that have never appeared
+// are usually answered by an in-memory bloom filter, without consulting the
+// store; all other queries are confirmed by the store.
+//
+// A MembershipIndex implements Subscriber, and must be subscribed to a
+// Manager (starting from the index returned by Tip) to stay up to date.
+type MembershipIndex struct {
+	mu     sync.Mutex
+	store  IndexStore
+	filter *bloomFilter
+}
+
+func elementKey(id types.ElementID) types.Hash256 {
+	// NOTE: ElementIDs often share their Source with a transaction ID, so we
+	// hash them to keep the two kinds of ID distinct
+	h := types.NewHasher()
+	id.EncodeTo(h.E)
+	return h.Sum()
+}
+
+// blockIDs returns the keys of the transactions and new elements in a block.
+func blockIDs(b types.Block, sces []types.SiacoinElement, sfes []types.SiafundElement, fces []types.FileContractElement) []types.Hash256 {
+	ids := make([]types.Hash256, 0, len(b.Transactions)+len(sces)+len(sfes)+len(fces))
+	for _, txn := range b.Transactions {
+		ids = append(ids, types.Hash256(txn.ID()))
+	}
+	for _, sce := range sces {
+		ids = append(ids, elementKey(sce.ID))
+	}
+	for _, sfe := range sfes {
+		ids = append(ids, elementKey(sfe.ID))
+	}
+	for _, fce := range fces {
+		ids = append(ids, elementKey(fce.ID))
+	}
+	return ids
+}
+
+// ProcessChainApplyUpdate implements Subscriber.
+func (idx *MembershipIndex) ProcessChainApplyUpdate(cau *ApplyUpdate, mayCommit bool) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	ids := blockIDs(cau.Block, cau.NewSiacoinElements, cau.NewSiafundElements, cau.NewFileContracts)
+	if err := idx.store.AddIDs(cau.Context.Index, ids); err != nil {
+		return fmt.Errorf("failed to add IDs: %w", err)
+	}
+	for _, id := range ids {
+		idx.filter.add(id)
+	}
+	if mayCommit {
+		return idx.store.Flush()
+	}
+	return nil
+}
+
+// ProcessChainRevertUpdate implements Subscriber.
+func (idx *MembershipIndex) ProcessChainRevertUpdate(cru *RevertUpdate) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	// NOTE: bloom filters do not support removal; reverted IDs will remain
+	// in the filter, but will be rejected by the store
+	ids := blockIDs(cru.Block, cru.NewSiacoinElements, cru.NewSiafundElements, cru.NewFileContracts)
+	if err := idx.store.RemoveIDs(cru.Block.Header.ParentIndex(), ids); err != nil {
+		return fmt.Errorf("failed to remove IDs: %w", err)
+	}
+	return nil
+}
+
+func (idx *MembershipIndex) height(key types.Hash256) (uint64, bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.filter.mayContain(key) {
+		return 0, false, nil
+	}
+	return idx.store.Height(key)
+}
+
+// TransactionHeight returns the height of the block containing the specified
+// transaction, if it is on the best chain.
+func (idx *MembershipIndex) TransactionHeight(id types.TransactionID) (uint64, bool, error) {
+	return idx.height(types.Hash256(id))
+}
+
+// ElementHeight returns the height of the block that created the specified
+// element, if it is on the best chain.
+func (idx *MembershipIndex) ElementHeight(id types.ElementID) (uint64, bool, error) {
+	return idx.height(elementKey(id))
+}
+
+// Tip returns the index of the most recent block processed by the index.
+func (idx *MembershipIndex) Tip() (types.ChainIndex, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.store.Tip()
+}
+
+// NewMembershipIndex returns a MembershipIndex backed by the provided store.
+// The capacity is the expected number of IDs in the index; exceeding it
+// increases the fraction of queries that must consult the store, but does not
+// affect correctness.
+func NewMembershipIndex(store IndexStore, capacity int) (*MembershipIndex, error) {
+	idx := &MembershipIndex{
+		store:  store,
+		filter: newBloomFilter(capacity),
+	}
+	if err := store.ForEach(idx.filter.add); err != nil {
+		return nil, fmt.Errorf("failed to load IDs from store: %w", err)
+	}
+	return idx, nil
+}
@@ -0,0 +1,94 @@
+package chain_test
+
+import (
+	"testing"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/internal/chainutil"
+	"go.sia.tech/core/types"
+)
+
+func TestMembershipIndex(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	cm := chain.NewManager(newTestStore(t, sim.Genesis), sim.Context)
+	defer cm.Close()
+	store := chainutil.NewEphemeralIndexStore(cm.Tip())
+	idx, err := chain.NewMembershipIndex(store, 1000)
+	if err != nil {
+		t.Fatal(err)
+	} else if tip, _ := idx.Tip(); tip != cm.Tip() {
+		t.Fatal("wrong initial tip")
+	} else if err := cm.AddSubscriber(idx, cm.Tip()); err != nil {
+		t.Fatal(err)
+	}
+
+	// mine 3 blocks, fork, then mine 3 more
+	sim.MineBlocks(3)
+	fork := sim.Fork()
+	sim.MineBlocks(3)
+	for _, b := range sim.Chain {
+		if err := cm.AddTipBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reorg to a longer fork
+	betterChain := fork.MineBlocks(5)
+	chainutil.FindBlockNonce(&betterChain[4].Header, types.HashRequiringWork(sim.Context.TotalWork))
+	if _, err := cm.AddHeaders(chainutil.JustHeaders(betterChain)); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddBlocks(betterChain); err != nil {
+		t.Fatal(err)
+	}
+	if tip, _ := idx.Tip(); tip != cm.Tip() {
+		t.Fatal("index did not follow the best chain")
+	}
+
+	checkTxns := func(blocks []types.Block, onChain bool) {
+		t.Helper()
+		for _, b := range blocks {
+			for _, txn := range b.Transactions {
+				height, ok, err := idx.TransactionHeight(txn.ID())
+				if err != nil {
+					t.Fatal(err)
+				} else if ok != onChain {
+					t.Fatalf("expected transaction %v on best chain = %v", txn.ID(), onChain)
+				} else if ok && height != b.Header.Height {
+					t.Fatalf("wrong height for transaction %v: expected %v, got %v", txn.ID(), b.Header.Height, height)
+				}
+			}
+		}
+	}
+	checkTxns(sim.Chain[:3], true)
+	checkTxns(sim.Chain[3:], false)
+	checkTxns(betterChain, true)
+
+	// check the elements created by the tip block
+	tip := betterChain[len(betterChain)-1]
+	parent, err := cm.ValidationContext(tip.Header.ParentIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sau := consensus.ApplyBlock(parent, tip)
+	if len(sau.NewSiacoinElements) == 0 {
+		t.Fatal("expected new siacoin elements")
+	}
+	for _, sce := range sau.NewSiacoinElements {
+		if height, ok, err := idx.ElementHeight(sce.ID); err != nil || !ok || height != tip.Header.Height {
+			t.Fatalf("element %v not indexed at height %v: %v %v %v", sce.ID, tip.Header.Height, height, ok, err)
+		}
+	}
+	if _, ok, _ := idx.ElementHeight(types.ElementID{Source: types.Hash256{1}}); ok {
+		t.Fatal("unknown element should not be indexed")
+	}
+
+	// a new index over the same store should load its IDs
+	idx2, err := chain.NewMembershipIndex(store, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := betterChain[0].Transactions[0].ID()
+	if height, ok, err := idx2.TransactionHeight(txid); err != nil || !ok || height != betterChain[0].Header.Height {
+		t.Fatal("reloaded index is missing transaction:", height, ok, err)
+	}
+}
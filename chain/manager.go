@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/events"
+	"go.sia.tech/core/merkle"
 	"go.sia.tech/core/types"
 )
 
@@ -59,6 +61,44 @@ type ManagerStore interface {
 	Close() error
 }
 
+// ManagerOptions configures a Manager. The zero value of each field selects
+// its default.
+type ManagerOptions struct {
+	// FlushInterval is the minimum amount of time between store flushes.
+	// Subscribers are only permitted to commit updates when the store has been
+	// flushed, so a longer interval trades durability for throughput. The
+	// default is one minute.
+	FlushInterval time.Duration
+
+	// Events, if non-nil, receives BlockApplied and ReorgDetected events.
+	// Like Subscribers, event handlers are called while the Manager's lock is
+	// held, and therefore must not call methods on the Manager.
+	Events *events.Bus
+
+	// Metrics, if non-nil, is attached to the contexts used to validate and
+	// apply blocks (see consensus.ValidationContext).
+	Metrics consensus.Metrics
+
+	// Profiler, if non-nil, is attached to the contexts used to validate and
+	// apply blocks, such that each ApplyUpdate carries a BlockProfile.
+	Profiler *consensus.Profiler
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts ManagerOptions) Validate() error {
+	if opts.FlushInterval < 0 {
+		return fmt.Errorf("negative flush interval (%v)", opts.FlushInterval)
+	}
+	return nil
+}
+
+func (opts ManagerOptions) withDefaults() ManagerOptions {
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = time.Minute
+	}
+	return opts
+}
+
 // A Manager tracks multiple blockchains and identifies the best valid
 // chain.
 type Manager struct {
@@ -66,7 +106,10 @@ type Manager struct {
 	vc          consensus.ValidationContext
 	chains      []*consensus.ScratchChain
 	subscribers []Subscriber
+	opts        ManagerOptions
 	lastFlush   time.Time
+	syncSamples []syncSample
+	reverts     uint64 // incremented whenever the tip is reverted
 
 	mu sync.Mutex
 }
@@ -168,6 +211,110 @@ func (m *Manager) HeadersForHistory(headers []types.BlockHeader, history []types
 	return headers, nil
 }
 
+// errBestChanged is returned by headerProof if a block of the best chain was
+// reverted while the proof was being built.
+var errBestChanged = errors.New("best chain changed")
+
+// historyBatchSize is the number of best chain indices read by headerProof
+// each time it acquires the Manager's lock.
+const historyBatchSize = 1000
+
+// HeaderProof returns a proof that the current tip is the tip of the best
+// chain. The store must contain every block since genesis.
+//
+// Since the proof requires the index of every block in the best chain, the
+// Manager's lock is only held for short batches of store reads. If the best
+// chain is reorganized in the meantime, the proof is rebuilt.
+func (m *Manager) HeaderProof() (consensus.HeaderProof, error) {
+	for i := 0; i < 3; i++ {
+		if hp, err := m.headerProof(); err != errBestChanged {
+			return hp, err
+		}
+	}
+	return consensus.HeaderProof{}, fmt.Errorf("couldn't build proof: %w", errBestChanged)
+}
+
+func (m *Manager) headerProof() (consensus.HeaderProof, error) {
+	m.mu.Lock()
+	tip, reverts := m.vc.Index, m.reverts
+	m.mu.Unlock()
+	if tip.Height == 0 {
+		return consensus.HeaderProof{}, errors.New("cannot prove genesis block")
+	}
+	// read calls fn with the Manager's lock held, provided that no blocks
+	// have been reverted since tip was retrieved; otherwise, the indices below
+	// tip may no longer be on the best chain
+	read := func(fn func() error) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.reverts != reverts {
+			return errBestChanged
+		}
+		return fn()
+	}
+	sample := func(index types.ChainIndex) (hs consensus.HeaderSample, err error) {
+		err = read(func() error {
+			c, err := m.store.Checkpoint(index)
+			if err != nil {
+				return fmt.Errorf("couldn't retrieve checkpoint %v: %w", index, err)
+			}
+			pc, err := m.store.Checkpoint(c.Block.Header.ParentIndex())
+			if err != nil {
+				return fmt.Errorf("couldn't retrieve parent checkpoint of %v: %w", index, err)
+			}
+			hs = consensus.HeaderSample{
+				Header:           c.Block.Header,
+				Parent:           pc.Context,
+				TransactionsHash: consensus.TransactionsHash(c.Block.Transactions),
+			}
+			return nil
+		})
+		return
+	}
+
+	var hp consensus.HeaderProof
+	var err error
+	if hp.Tip, err = sample(tip); err != nil {
+		return consensus.HeaderProof{}, err
+	}
+	history := make([]types.ChainIndex, tip.Height)
+	for start := 0; start < len(history); start += historyBatchSize {
+		err := read(func() (err error) {
+			for height := start; height < len(history) && height < start+historyBatchSize; height++ {
+				if history[height], err = m.store.BestIndex(uint64(height)); err != nil {
+					return fmt.Errorf("failed to get best index at %v: %w", height, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return consensus.HeaderProof{}, err
+		}
+	}
+	heights, err := consensus.HeaderProofHeights(hp.Tip.Header, hp.Tip.Parent.TotalWork, func(height uint64) (w types.Work, err error) {
+		err = read(func() error {
+			c, err := m.store.Checkpoint(history[height-1])
+			w = c.Context.TotalWork
+			return err
+		})
+		return
+	})
+	if err == errBestChanged {
+		return consensus.HeaderProof{}, err
+	} else if err != nil {
+		return consensus.HeaderProof{}, fmt.Errorf("failed to select samples: %w", err)
+	}
+	proofs := merkle.HistoryProofs(history, heights)
+	hp.Samples = make([]consensus.HeaderSample, len(heights))
+	for i, height := range heights {
+		if hp.Samples[i], err = sample(history[height]); err != nil {
+			return consensus.HeaderProof{}, err
+		}
+		hp.Samples[i].HistoryProof = proofs[i]
+	}
+	return hp, nil
+}
+
 // AddHeaders incorporates a chain of headers, using some or all of them to
 // extend a ScratchChain (or create a new one). If the incorporation of these
 // headers causes a ScratchChain to become the new (unvalidated) best chain,
@@ -241,6 +388,7 @@ func (m *Manager) AddHeaders(headers []types.BlockHeader) (*consensus.ScratchCha
 		if err != nil {
 			return nil, fmt.Errorf("could not load checkpoint %v: %w", base.Index(), err)
 		}
+		c.Context.Metrics, c.Context.Profiler = m.opts.Metrics, m.opts.Profiler
 		chain = consensus.NewScratchChain(c.Context)
 		m.chains = append(m.chains, chain)
 	}
@@ -311,6 +459,7 @@ func (m *Manager) AddBlocks(blocks []types.Block) (*consensus.ScratchChain, erro
 		}
 	}
 
+	m.recordSyncSample(chain.ValidTip().Height)
 	if chain.FullyValidated() {
 		m.discardChain(chain)
 	}
@@ -357,7 +506,7 @@ func (m *Manager) AddTipBlock(b types.Block) error {
 	m.vc = sau.Context
 
 	mayCommit := false
-	if time.Since(m.lastFlush) > time.Minute {
+	if time.Since(m.lastFlush) > m.opts.FlushInterval {
 		if err := m.store.Flush(); err != nil {
 			return fmt.Errorf("couldn't flush store: %w", err)
 		}
@@ -372,6 +521,7 @@ func (m *Manager) AddTipBlock(b types.Block) error {
 			return fmt.Errorf("subscriber %T: %w", s, err)
 		}
 	}
+	m.opts.Events.Publish(events.BlockApplied{Block: b, Context: sau.Context})
 	return nil
 }
 
@@ -387,6 +537,7 @@ func (m *Manager) revertTip() error {
 		return fmt.Errorf("failed to get checkpoint for parent %v: %w", b.Header.ParentIndex(), err)
 	}
 	vc := c.Context
+	vc.Metrics, vc.Profiler = m.opts.Metrics, m.opts.Profiler
 
 	sru := consensus.RevertBlock(vc, b)
 	update := RevertUpdate{sru, b}
@@ -400,6 +551,7 @@ func (m *Manager) revertTip() error {
 	}
 
 	m.vc = vc
+	m.reverts++
 	return nil
 }
 
@@ -415,10 +567,10 @@ func (m *Manager) applyTip(index types.ChainIndex) error {
 		return fmt.Errorf("couldn't update tip: %w", err)
 	}
 
-	// flush at most once per minute; if we haven't flushed, tell the subscriber
+	// flush at most once per interval; if we haven't flushed, tell the subscriber
 	// that it must not commit chain data to disk
 	mayCommit := false
-	if time.Since(m.lastFlush) > time.Minute {
+	if time.Since(m.lastFlush) > m.opts.FlushInterval {
 		if err := m.store.Flush(); err != nil {
 			return fmt.Errorf("couldn't flush store: %w", err)
 		}
@@ -433,6 +585,7 @@ func (m *Manager) applyTip(index types.ChainIndex) error {
 			return fmt.Errorf("subscriber %T: %w", s, err)
 		}
 	}
+	m.opts.Events.Publish(events.BlockApplied{Block: c.Block, Context: sau.Context})
 
 	m.vc = sau.Context
 	return nil
@@ -460,6 +613,7 @@ func (m *Manager) reorgTo(sc *consensus.ScratchChain) error {
 	}
 
 	// revert to branch point
+	oldTip := m.vc.Index
 	for m.vc.Index != base.Index() {
 		if err := m.revertTip(); err != nil {
 			return fmt.Errorf("couldn't revert block %v: %w", m.vc.Index, err)
@@ -479,6 +633,13 @@ func (m *Manager) reorgTo(sc *consensus.ScratchChain) error {
 		}
 	}
 
+	if oldTip != base.Index() {
+		m.opts.Events.Publish(events.ReorgDetected{
+			Base:   base.Index(),
+			OldTip: oldTip,
+			NewTip: m.vc.Index,
+		})
+	}
 	return nil
 }
 
@@ -539,48 +700,83 @@ func (m *Manager) reorgPath(a, b types.ChainIndex) (revert, apply []types.ChainI
 	return revert, apply, nil
 }
 
-// AddSubscriber subscribes s to m, ensuring that it will receive updates when
-// the best chain changes. If tip does not match the Manager's current tip, s is
-// updated accordingly.
-func (m *Manager) AddSubscriber(s Subscriber, tip types.ChainIndex) error {
+// UpdatesSince returns the updates that bring a subscriber at index to the
+// current tip: a sequence of reverts back to the best chain, followed by at
+// most max applies (or all of them, if max is negative). To continue, call
+// UpdatesSince again with the index of the last applied block.
+//
+// Subscribers that miss updates, e.g. because they were dropped from a full
+// event queue, can use UpdatesSince to recover.
+func (m *Manager) UpdatesSince(index types.ChainIndex, max int) ([]RevertUpdate, []ApplyUpdate, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.updatesSince(index, max)
+}
 
-	// reorg s to the current tip, if necessary
-	revert, apply, err := m.reorgPath(tip, m.vc.Index)
+func (m *Manager) updatesSince(index types.ChainIndex, max int) (rus []RevertUpdate, aus []ApplyUpdate, err error) {
+	revert, apply, err := m.reorgPath(index, m.vc.Index)
 	if err != nil {
-		return fmt.Errorf("failed to establish reorg path from %v to %v: %w", tip, m.vc.Index, err)
+		return nil, nil, fmt.Errorf("failed to establish reorg path from %v to %v: %w", index, m.vc.Index, err)
+	}
+	if max >= 0 && len(apply) > max {
+		apply = apply[:max]
 	}
 	for _, index := range revert {
 		c, err := m.store.Checkpoint(index)
 		if err != nil {
-			return fmt.Errorf("failed to get revert checkpoint %v: %w", index, err)
+			return nil, nil, fmt.Errorf("failed to get revert checkpoint %v: %w", index, err)
 		}
 		b := c.Block
 		c, err = m.store.Checkpoint(b.Header.ParentIndex())
 		if err != nil {
-			return fmt.Errorf("failed to get revert parent checkpoint %v: %w", b.Header.ParentIndex(), err)
-		}
-		sru := consensus.RevertBlock(c.Context, b)
-		if err := s.ProcessChainRevertUpdate(&RevertUpdate{sru, b}); err != nil {
-			return fmt.Errorf("failed to process revert update: %w", err)
+			return nil, nil, fmt.Errorf("failed to get revert parent checkpoint %v: %w", b.Header.ParentIndex(), err)
 		}
+		rus = append(rus, RevertUpdate{consensus.RevertBlock(c.Context, b), b})
 	}
 	for _, index := range apply {
 		c, err := m.store.Checkpoint(index)
 		if err != nil {
-			return fmt.Errorf("failed to get apply checkpoint %v: %w", index, err)
+			return nil, nil, fmt.Errorf("failed to get apply checkpoint %v: %w", index, err)
 		}
 		b := c.Block
 		c, err = m.store.Checkpoint(b.Header.ParentIndex())
 		if err != nil {
-			return fmt.Errorf("failed to get apply parent checkpoint %v: %w", b.Header.ParentIndex(), err)
+			return nil, nil, fmt.Errorf("failed to get apply parent checkpoint %v: %w", b.Header.ParentIndex(), err)
 		}
-		sau := consensus.ApplyBlock(c.Context, b)
-		shouldCommit := index == m.vc.Index
-		if err := s.ProcessChainApplyUpdate(&ApplyUpdate{sau, b}, shouldCommit); err != nil {
-			return fmt.Errorf("failed to process apply update: %w", err)
+		aus = append(aus, ApplyUpdate{consensus.ApplyBlock(c.Context, b), b})
+	}
+	return rus, aus, nil
+}
+
+// AddSubscriber subscribes s to m, ensuring that it will receive updates when
+// the best chain changes. If tip does not match the Manager's current tip, s is
+// updated accordingly.
+func (m *Manager) AddSubscriber(s Subscriber, tip types.ChainIndex) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// reorg s to the current tip, if necessary, in batches to bound memory
+	// usage
+	for {
+		rus, aus, err := m.updatesSince(tip, 100)
+		if err != nil {
+			return err
+		}
+		for i := range rus {
+			if err := s.ProcessChainRevertUpdate(&rus[i]); err != nil {
+				return fmt.Errorf("failed to process revert update: %w", err)
+			}
 		}
+		for i := range aus {
+			shouldCommit := aus[i].Context.Index == m.vc.Index
+			if err := s.ProcessChainApplyUpdate(&aus[i], shouldCommit); err != nil {
+				return fmt.Errorf("failed to process apply update: %w", err)
+			}
+		}
+		if len(aus) == 0 {
+			break
+		}
+		tip = aus[len(aus)-1].Context.Index
 	}
 	m.subscribers = append(m.subscribers, s)
 	return nil
@@ -640,11 +836,24 @@ func (m *Manager) Close() error {
 	return m.store.Close()
 }
 
-// NewManager returns a Manager initialized with the provided Store and context.
+// NewManager returns a Manager initialized with the provided Store and context,
+// using the default options.
 func NewManager(store ManagerStore, vc consensus.ValidationContext) *Manager {
+	m, _ := NewManagerWithOptions(store, vc, ManagerOptions{})
+	return m
+}
+
+// NewManagerWithOptions returns a Manager initialized with the provided Store,
+// context, and options.
+func NewManagerWithOptions(store ManagerStore, vc consensus.ValidationContext, opts ManagerOptions) (*Manager, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	vc.Metrics, vc.Profiler = opts.Metrics, opts.Profiler
 	return &Manager{
 		store:     store,
 		vc:        vc,
+		opts:      opts.withDefaults(),
 		lastFlush: time.Now(),
-	}
+	}, nil
 }
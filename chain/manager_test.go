@@ -1,11 +1,14 @@
 package chain_test
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
+	"time"
 
 	"go.sia.tech/core/chain"
 	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/events"
 	"go.sia.tech/core/internal/chainutil"
 	"go.sia.tech/core/types"
 )
@@ -103,4 +106,248 @@ func TestManager(t *testing.T) {
 	} else if !reflect.DeepEqual(hs2.applyHistory, []uint64{6, 7, 8, 9, 10, 11, 12, 13, 14, 15}) {
 		t.Fatal("10 blocks should have been applied:", hs2.applyHistory)
 	}
+
+	// fetch the same updates in batches
+	var hs3 historySubscriber
+	rus, aus, err := cm.UpdatesSince(subTip, 4)
+	for err == nil && len(rus)+len(aus) > 0 {
+		for i := range rus {
+			hs3.ProcessChainRevertUpdate(&rus[i])
+		}
+		for i := range aus {
+			hs3.ProcessChainApplyUpdate(&aus[i], false)
+		}
+		if len(aus) == 0 {
+			break
+		}
+		rus, aus, err = cm.UpdatesSince(aus[len(aus)-1].Context.Index, 4)
+	}
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(hs3, hs2) {
+		t.Fatal("batched updates do not match:", hs3)
+	}
+}
+
+func TestManagerEvents(t *testing.T) {
+	sim := chainutil.NewChainSim()
+
+	var bus events.Bus
+	var applied []uint64
+	var reorgs []events.ReorgDetected
+	bus.Subscribe(func(e events.Event) {
+		switch e := e.(type) {
+		case events.BlockApplied:
+			applied = append(applied, e.Context.Index.Height)
+		case events.ReorgDetected:
+			reorgs = append(reorgs, e)
+		}
+	}, events.TopicBlockApplied, events.TopicReorgDetected)
+
+	cm, err := chain.NewManagerWithOptions(newTestStore(t, sim.Genesis), sim.Context, chain.ManagerOptions{Events: &bus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.Close()
+
+	sim.MineBlocks(3)
+	fork := sim.Fork()
+	sim.MineBlocks(2)
+	for _, b := range sim.Chain {
+		if err := cm.AddTipBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(applied, []uint64{1, 2, 3, 4, 5}) {
+		t.Fatal("wrong BlockApplied events:", applied)
+	} else if len(reorgs) != 0 {
+		t.Fatal("no reorg should have been published")
+	}
+
+	oldTip := cm.Tip()
+	betterChain := fork.MineBlocks(4)
+	chainutil.FindBlockNonce(&betterChain[3].Header, types.HashRequiringWork(sim.Context.TotalWork))
+	applied = nil
+	if _, err := cm.AddHeaders(chainutil.JustHeaders(betterChain)); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddBlocks(betterChain); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []uint64{4, 5, 6, 7}) {
+		t.Fatal("wrong BlockApplied events:", applied)
+	} else if len(reorgs) != 1 {
+		t.Fatal("expected one reorg, got", len(reorgs))
+	}
+	// the reorg happens as soon as the fork has more work than the old tip;
+	// subsequent blocks are applied normally
+	exp := events.ReorgDetected{
+		Base:   sim.Chain[2].Index(),
+		OldTip: oldTip,
+		NewTip: betterChain[2].Index(),
+	}
+	if reorgs[0] != exp {
+		t.Fatalf("wrong reorg event: expected %v, got %v", exp, reorgs[0])
+	} else if reorgs[0].Depth() != 2 {
+		t.Fatal("wrong reorg depth:", reorgs[0].Depth())
+	}
+}
+
+type countingMetrics struct {
+	consensus.NopMetrics
+	updates int
+}
+
+func (m *countingMetrics) AccumulatorUpdated(time.Duration) { m.updates++ }
+
+type profileSubscriber struct {
+	profiles []*consensus.BlockProfile
+}
+
+func (ps *profileSubscriber) ProcessChainApplyUpdate(cau *chain.ApplyUpdate, _ bool) error {
+	ps.profiles = append(ps.profiles, cau.Profile)
+	return nil
+}
+
+func (ps *profileSubscriber) ProcessChainRevertUpdate(*chain.RevertUpdate) error { return nil }
+
+func TestManagerMetrics(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	var m1, m2 countingMetrics
+	cm1, err := chain.NewManagerWithOptions(newTestStore(t, sim.Genesis), sim.Context, chain.ManagerOptions{Metrics: &m1, Profiler: new(consensus.Profiler)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm1.Close()
+	var ps1, ps2 profileSubscriber
+	if err := cm1.AddSubscriber(&ps1, cm1.Tip()); err != nil {
+		t.Fatal(err)
+	}
+	cm2, err := chain.NewManagerWithOptions(newTestStore(t, sim.Genesis), sim.Context, chain.ManagerOptions{Metrics: &m2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm2.Close()
+	if err := cm2.AddSubscriber(&ps2, cm2.Tip()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range sim.MineBlocks(3) {
+		if err := cm1.AddTipBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cm2.AddTipBlock(sim.Chain[0]); err != nil {
+		t.Fatal(err)
+	}
+	if m1.updates != 3 || m2.updates != 1 {
+		t.Fatalf("expected 3 and 1 updates, got %v and %v", m1.updates, m2.updates)
+	}
+	for _, p := range ps1.profiles {
+		if p == nil || p.Accumulator == 0 {
+			t.Fatal("expected profile with accumulator time, got", p)
+		}
+	}
+	if len(ps1.profiles) != 3 || len(ps2.profiles) != 1 || ps2.profiles[0] != nil {
+		t.Fatal("profiles attached to wrong updates")
+	}
+}
+
+func TestHeaderProof(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	store := newTestStore(t, sim.Genesis)
+	cm := chain.NewManager(store, sim.Context)
+	defer cm.Close()
+
+	for _, b := range sim.MineBlocks(200) {
+		if err := cm.AddTipBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hp, err := cm.HeaderProof()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := consensus.VerifyHeaderProof(hp); err != nil {
+		t.Fatal(err)
+	} else if len(hp.Samples) >= 200 {
+		t.Fatal("proof should not contain every header:", len(hp.Samples))
+	} else if hp.Samples[0].Header.ParentID != sim.Genesis.Block.ID() {
+		t.Fatal("proof not anchored to genesis")
+	}
+
+	// the proof should survive a round trip, but a proof claiming more samples
+	// than any valid proof contains should be rejected before allocating them
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	hp.EncodeTo(e)
+	e.Flush()
+	var hp2 consensus.HeaderProof
+	d := types.NewBufDecoder(buf.Bytes())
+	if hp2.DecodeFrom(d); d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	var buf2 bytes.Buffer
+	e2 := types.NewEncoder(&buf2)
+	hp2.EncodeTo(e2)
+	e2.Flush()
+	if !bytes.Equal(buf2.Bytes(), buf.Bytes()) {
+		t.Fatal("proof did not survive round trip")
+	}
+	buf.Reset()
+	hp.Tip.EncodeTo(e)
+	e.WritePrefix(1000)
+	e.Flush()
+	buf.Write(make([]byte, 1<<20))
+	d = types.NewBufDecoder(buf.Bytes())
+	if hp2.DecodeFrom(d); d.Err() == nil {
+		t.Fatal("expected proof with too many samples to be rejected")
+	}
+
+	// tampering with any sample should invalidate the proof
+	hp.Samples[3].Header.Nonce += consensus.NonceFactor
+	if err := consensus.VerifyHeaderProof(hp); err == nil {
+		t.Fatal("expected tampered proof to be rejected")
+	}
+	hp.Samples[3].Header.Nonce -= consensus.NonceFactor
+	hp.Samples = hp.Samples[1:]
+	if err := consensus.VerifyHeaderProof(hp); err == nil {
+		t.Fatal("expected proof with missing sample to be rejected")
+	}
+}
+
+func TestHeaderProofForged(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	store := newTestStore(t, sim.Genesis)
+
+	// forge a chain that is mined at the genesis difficulty, but claims that
+	// each block contributed 1000 times as much work
+	vc := sim.Genesis.Context
+	for i := 0; i < 200; i++ {
+		b := types.Block{
+			Header: types.BlockHeader{
+				Height:    vc.Index.Height + 1,
+				ParentID:  vc.Index.ID,
+				Timestamp: sim.Genesis.Block.Header.Timestamp.Add(time.Duration(i+1) * time.Second),
+			},
+		}
+		b.Header.Commitment = vc.Commitment(b.Header.MinerAddress, b.Transactions)
+		chainutil.FindBlockNonce(&b.Header, types.HashRequiringWork(vc.Difficulty))
+		vc = consensus.ApplyBlock(vc, b).Context
+		vc.TotalWork = vc.TotalWork.Add(vc.Difficulty.Mul64(999))
+		if err := store.AddCheckpoint(consensus.Checkpoint{Block: b, Context: vc}); err != nil {
+			t.Fatal(err)
+		} else if err := store.ExtendBest(b.Index()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cm := chain.NewManager(store, vc)
+	defer cm.Close()
+
+	// the samples cannot cover the claimed work
+	hp, err := cm.HeaderProof()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := consensus.VerifyHeaderProof(hp); err == nil {
+		t.Fatal("expected forged proof to be rejected")
+	}
 }
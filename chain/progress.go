@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"time"
+)
+
+// maxSyncSamples is the number of recent AddBlocks calls used to estimate the
+// sync rate.
+const maxSyncSamples = 32
+
+type syncSample struct {
+	timestamp time.Time
+	height    uint64
+}
+
+// SyncProgress estimates how far the Manager is from the tip of the network.
+type SyncProgress struct {
+	// Height is the height of the current (validated) tip.
+	Height uint64
+	// HeaderHeight is the height of the best known header chain. It is at
+	// least Height.
+	HeaderHeight uint64
+	// EstimatedHeight is the estimated height of the network, extrapolated
+	// from the timestamp of the best known header. It is at least
+	// HeaderHeight.
+	EstimatedHeight uint64
+	// AverageBlockTime is the average time between recent blocks of the best
+	// known header chain.
+	AverageBlockTime time.Duration
+	// BlocksPerSecond is the rate at which blocks were recently downloaded and
+	// validated via AddBlocks. It is zero if too few blocks have been added to
+	// estimate the rate.
+	BlocksPerSecond float64
+	// ETA is the estimated time remaining until the Manager is synced. It is
+	// zero if the Manager is synced or BlocksPerSecond is zero.
+	ETA time.Duration
+}
+
+// Remaining returns the estimated number of blocks remaining until the Manager
+// is synced. It is zero if Height is at least EstimatedHeight, e.g. because the
+// estimate lags behind a freshly validated tip.
+func (sp SyncProgress) Remaining() uint64 {
+	if sp.Height >= sp.EstimatedHeight {
+		return 0
+	}
+	return sp.EstimatedHeight - sp.Height
+}
+
+// Synced returns true if the Manager is believed to be synced.
+func (sp SyncProgress) Synced() bool {
+	return sp.Remaining() == 0
+}
+
+// Fraction returns the fraction of the estimated height that has been
+// validated, between 0 and 1.
+func (sp SyncProgress) Fraction() float64 {
+	if sp.Height >= sp.EstimatedHeight {
+		return 1
+	}
+	return float64(sp.Height) / float64(sp.EstimatedHeight)
+}
+
+func (m *Manager) recordSyncSample(height uint64) {
+	m.syncSamples = append(m.syncSamples, syncSample{time.Now(), height})
+	if len(m.syncSamples) > maxSyncSamples {
+		m.syncSamples = m.syncSamples[1:]
+	}
+}
+
+func (m *Manager) syncRate() float64 {
+	if len(m.syncSamples) < 2 {
+		return 0
+	}
+	first, last := m.syncSamples[0], m.syncSamples[len(m.syncSamples)-1]
+	elapsed := last.timestamp.Sub(first.timestamp).Seconds()
+	if last.height <= first.height || elapsed <= 0 {
+		return 0
+	}
+	return float64(last.height-first.height) / elapsed
+}
+
+// SyncProgress returns an estimate of the Manager's progress towards the tip
+// of the network, suitable for displaying to users.
+//
+// The network height is extrapolated from the timestamp of the best known
+// header and the average time between recent blocks. Since gaps of up to two
+// block intervals are common, a header less than two intervals old is assumed
+// to be the tip of the network.
+func (m *Manager) SyncProgress() SyncProgress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hvc := m.vc
+	for _, sc := range m.chains {
+		if sc.TotalWork().Cmp(hvc.TotalWork) > 0 {
+			hvc = sc.HeaderContext()
+		}
+	}
+	sp := SyncProgress{
+		Height:           m.vc.Index.Height,
+		HeaderHeight:     hvc.Index.Height,
+		EstimatedHeight:  hvc.Index.Height,
+		AverageBlockTime: hvc.AverageBlockTime(),
+		BlocksPerSecond:  m.syncRate(),
+	}
+	if elapsed := time.Since(hvc.TipTimestamp()); elapsed >= 2*sp.AverageBlockTime {
+		sp.EstimatedHeight += uint64(elapsed/sp.AverageBlockTime) - 1
+	}
+	if sp.BlocksPerSecond > 0 {
+		sp.ETA = time.Duration(float64(sp.Remaining()) / sp.BlocksPerSecond * float64(time.Second))
+	}
+	return sp
+}
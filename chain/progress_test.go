@@ -0,0 +1,54 @@
+package chain_test
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/internal/chainutil"
+)
+
+func TestSyncProgress(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	store := newTestStore(t, sim.Genesis)
+	cm := chain.NewManager(store, sim.Context)
+	defer cm.Close()
+
+	blocks := sim.MineBlocks(20)
+	if _, err := cm.AddHeaders(chainutil.JustHeaders(blocks)); err != nil {
+		t.Fatal(err)
+	}
+	sp := cm.SyncProgress()
+	switch {
+	case sp.Height != 0 || sp.HeaderHeight != 20:
+		t.Fatalf("wrong heights: %+v", sp)
+	case sp.AverageBlockTime != time.Second:
+		t.Fatalf("expected average block time of 1s, got %v", sp.AverageBlockTime)
+	case sp.EstimatedHeight <= sp.HeaderHeight || sp.Synced():
+		// the simulated chain's timestamps are far in the past
+		t.Fatalf("expected estimated height to exceed header height: %+v", sp)
+	case sp.BlocksPerSecond != 0 || sp.ETA != 0:
+		t.Fatalf("expected unknown rate before adding blocks: %+v", sp)
+	}
+
+	for i := 0; i < len(blocks); i += 5 {
+		if _, err := cm.AddBlocks(blocks[i : i+5]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sp = cm.SyncProgress()
+	switch {
+	case sp.Height != 20 || sp.HeaderHeight != 20:
+		t.Fatalf("wrong heights: %+v", sp)
+	case sp.BlocksPerSecond <= 0 || sp.ETA <= 0:
+		t.Fatalf("expected rate and ETA to be estimated: %+v", sp)
+	case sp.Fraction() <= 0 || sp.Fraction() >= 1:
+		t.Fatalf("expected fraction between 0 and 1, got %v", sp.Fraction())
+	}
+
+	// a tip beyond the estimated height must not underflow
+	sp = chain.SyncProgress{Height: 21, EstimatedHeight: 20, BlocksPerSecond: 1}
+	if sp.Remaining() != 0 || !sp.Synced() || sp.Fraction() != 1 {
+		t.Fatalf("expected synced progress: %+v", sp)
+	}
+}
@@ -0,0 +1,61 @@
+package consensus
+
+import (
+	"go.sia.tech/core/types"
+)
+
+// A CommitmentBuilder computes the commitment hash for a child block whose
+// transaction set changes over time, such as a miner's block template. The
+// parent context is hashed once, and each transaction is hashed only when it
+// is added, so updating the commitment after adding or removing a transaction
+// costs time proportional to the number of transactions, rather than to their
+// total size.
+type CommitmentBuilder struct {
+	ctxHash   types.Hash256
+	minerAddr types.Address
+	txids     []types.TransactionID
+}
+
+// SetMinerAddress sets the miner address used in the commitment.
+func (cb *CommitmentBuilder) SetMinerAddress(addr types.Address) {
+	cb.minerAddr = addr
+}
+
+// AddTransaction appends txn to the transaction set.
+func (cb *CommitmentBuilder) AddTransaction(txn types.Transaction) {
+	cb.txids = append(cb.txids, txn.ID())
+}
+
+// RemoveTransaction removes the transaction with the given ID from the
+// transaction set, preserving the order of the remaining transactions. It
+// reports whether the transaction was present.
+func (cb *CommitmentBuilder) RemoveTransaction(txid types.TransactionID) bool {
+	for i := range cb.txids {
+		if cb.txids[i] == txid {
+			cb.txids = append(cb.txids[:i], cb.txids[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Commitment returns the commitment hash for the current miner address and
+// transaction set. It is equivalent to calling vc.Commitment with the same
+// arguments.
+func (cb *CommitmentBuilder) Commitment() types.Hash256 {
+	return commitmentHash(cb.ctxHash, cb.minerAddr, transactionsHash(cb.txids))
+}
+
+// NewCommitmentBuilder returns a CommitmentBuilder for a child block of vc,
+// initialized with the given miner address and transactions.
+func NewCommitmentBuilder(vc ValidationContext, minerAddr types.Address, txns []types.Transaction) *CommitmentBuilder {
+	cb := &CommitmentBuilder{
+		ctxHash:   vc.contextHash(),
+		minerAddr: minerAddr,
+		txids:     make([]types.TransactionID, len(txns)),
+	}
+	for i, txn := range txns {
+		cb.txids[i] = txn.ID()
+	}
+	return cb
+}
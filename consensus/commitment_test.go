@@ -0,0 +1,62 @@
+package consensus
+
+import (
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestCommitmentBuilder(t *testing.T) {
+	pubkey, _ := testingKeypair(0)
+	addr := types.StandardAddress(pubkey)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{Address: addr, Value: types.Siacoins(1)})
+	vc := GenesisUpdate(genesis, testingDifficulty).Context
+
+	txns := make([]types.Transaction, 4)
+	for i := range txns {
+		txns[i].ArbitraryData = []byte{byte(i)}
+	}
+	cb := NewCommitmentBuilder(vc, types.VoidAddress, txns[:2])
+	if cb.Commitment() != vc.Commitment(types.VoidAddress, txns[:2]) {
+		t.Fatal("initial commitment mismatch")
+	}
+	cb.SetMinerAddress(addr)
+	cb.AddTransaction(txns[2])
+	cb.AddTransaction(txns[3])
+	if cb.Commitment() != vc.Commitment(addr, txns) {
+		t.Fatal("commitment mismatch after adding transactions")
+	}
+	if !cb.RemoveTransaction(txns[1].ID()) {
+		t.Fatal("transaction should be present")
+	} else if cb.RemoveTransaction(txns[1].ID()) {
+		t.Fatal("transaction should not be present")
+	}
+	remaining := []types.Transaction{txns[0], txns[2], txns[3]}
+	if cb.Commitment() != vc.Commitment(addr, remaining) {
+		t.Fatal("commitment mismatch after removing transaction")
+	}
+}
+
+func BenchmarkCommitmentBuilder(b *testing.B) {
+	genesis := genesisWithSiacoinOutputs()
+	vc := GenesisUpdate(genesis, testingDifficulty).Context
+	txns := make([]types.Transaction, 1000)
+	for i := range txns {
+		txns[i].ArbitraryData = make([]byte, 1000)
+		txns[i].ArbitraryData[0] = byte(i)
+	}
+	b.Run("Commitment", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = vc.Commitment(types.VoidAddress, txns)
+		}
+	})
+	b.Run("Builder", func(b *testing.B) {
+		cb := NewCommitmentBuilder(vc, types.VoidAddress, txns[:len(txns)-1])
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cb.AddTransaction(txns[len(txns)-1])
+			_ = cb.Commitment()
+			cb.RemoveTransaction(txns[len(txns)-1].ID())
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+var updateVectors = flag.Bool("update", false, "update test vectors in testdata")
+
+type sigHashVector struct {
+	Object  string `json:"object"`
+	ChainID string `json:"chainID"`
+	Encoded string `json:"encoded"`
+	SigHash string `json:"sigHash"`
+}
+
+func encodeHex(v types.EncoderTo) string {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	v.EncodeTo(e)
+	e.Flush()
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func TestSigHashVectors(t *testing.T) {
+	pubkey, _ := testingKeypair(0)
+	addr := types.StandardAddress(pubkey)
+	id := func(b byte) types.ElementID {
+		return types.ElementID{Source: types.Hash256{b}, Index: uint64(b)}
+	}
+	fc := types.FileContract{
+		Filesize:        4096,
+		FileMerkleRoot:  types.Hash256{1, 2, 3},
+		WindowStart:     100,
+		WindowEnd:       200,
+		RenterOutput:    types.SiacoinOutput{Address: addr, Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Address: addr, Value: types.Siacoins(20)},
+		MissedHostValue: types.Siacoins(5),
+		RenterPublicKey: pubkey,
+		HostPublicKey:   pubkey,
+		RevisionNumber:  7,
+	}
+	renewal := types.FileContractRenewal{
+		FinalRevision:   fc,
+		InitialRevision: fc,
+		RenterRollover:  types.Siacoins(1),
+		HostRollover:    types.Siacoins(2),
+	}
+	renewal.FinalRevision.RevisionNumber = types.MaxRevisionNumber
+	attestation := types.Attestation{
+		PublicKey: pubkey,
+		Key:       "foo",
+		Value:     []byte("bar"),
+	}
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      types.SiacoinElement{StateElement: types.StateElement{ID: id(1)}},
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(3)}},
+		SiafundInputs: []types.SiafundInput{{
+			Parent:      types.SiafundElement{StateElement: types.StateElement{ID: id(2)}},
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiafundOutputs: []types.SiafundOutput{{Address: addr, Value: 4}},
+		FileContracts:  []types.FileContract{fc},
+		FileContractRevisions: []types.FileContractRevision{{
+			Parent:   types.FileContractElement{StateElement: types.StateElement{ID: id(3)}},
+			Revision: fc,
+		}},
+		FileContractResolutions: []types.FileContractResolution{{
+			Parent:  types.FileContractElement{StateElement: types.StateElement{ID: id(4)}},
+			Renewal: renewal,
+		}},
+		Attestations:         []types.Attestation{attestation},
+		ArbitraryData:        []byte("baz"),
+		NewFoundationAddress: addr,
+		MinerFee:             types.Siacoins(1),
+	}
+
+	partial := txn.DeepCopy()
+	partial.SiacoinInputs[0].SigHash = types.SigHashAnyoneCanPay | types.SigHashSingle
+
+	vc := ValidationContext{ChainID: types.HashBytes([]byte("testnet"))}
+	chainID := vc.ChainID.String()
+	vectors := []sigHashVector{
+		{types.DomainTransactionInput, chainID, encodeHex(txn), vc.InputSigHash(txn).String()},
+		{types.DomainTransactionInputPartial, chainID, encodeHex(partial), vc.SiacoinInputSigHash(partial, 0).String()},
+		{types.DomainFileContract, chainID, encodeHex(fc), vc.ContractSigHash(fc).String()},
+		{types.DomainFileContractRenewal, chainID, encodeHex(renewal), vc.RenewalSigHash(renewal).String()},
+		{types.DomainAttestation, chainID, encodeHex(attestation), vc.AttestationSigHash(attestation).String()},
+	}
+
+	path := filepath.Join("testdata", "sighashes.json")
+	if *updateVectors {
+		js, _ := json.MarshalIndent(vectors, "", "\t")
+		if err := os.WriteFile(path, append(js, '\n'), 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	js, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var golden []sigHashVector
+	if err := json.Unmarshal(js, &golden); err != nil {
+		t.Fatal(err)
+	} else if len(golden) != len(vectors) {
+		t.Fatalf("expected %v vectors, got %v", len(vectors), len(golden))
+	}
+	for i := range vectors {
+		if vectors[i] != golden[i] {
+			t.Errorf("%v: sighash does not match test vector", vectors[i].Object)
+		}
+	}
+	for _, v := range vectors {
+		var found bool
+		for _, d := range types.SigningDomains() {
+			found = found || d.Prefix == v.Object
+		}
+		if !found {
+			t.Errorf("%v is missing from SigningDomains", v.Object)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// ErrNoFraud is returned by FraudProof when a block does not contain a
+// transaction that is invalid in isolation.
+var ErrNoFraud = errors.New("block does not contain an invalid transaction")
+
+// A Rule identifies one of the checks performed by ValidateTransaction. Rules
+// are listed in the order in which they are checked.
+type Rule uint8
+
+// Transaction validity rules.
+const (
+	RuleStateProofs Rule = iota + 1
+	RuleHistoryProofs
+	RuleCurrencyValues
+	RuleTimeLocks
+	RuleOutputsEqualInputs
+	RuleFoundationUpdate
+	RuleFileContracts
+	RuleFileContractRevisions
+	RuleFileContractResolutions
+	RuleAttestations
+	RuleSpendPolicies
+
+	numRules = iota
+)
+
+// String implements fmt.Stringer.
+func (r Rule) String() string {
+	switch r {
+	case RuleStateProofs:
+		return "state proofs"
+	case RuleHistoryProofs:
+		return "history proofs"
+	case RuleCurrencyValues:
+		return "currency values"
+	case RuleTimeLocks:
+		return "time locks"
+	case RuleOutputsEqualInputs:
+		return "outputs equal inputs"
+	case RuleFoundationUpdate:
+		return "foundation update"
+	case RuleFileContracts:
+		return "file contracts"
+	case RuleFileContractRevisions:
+		return "file contract revisions"
+	case RuleFileContractResolutions:
+		return "file contract resolutions"
+	case RuleAttestations:
+		return "attestations"
+	case RuleSpendPolicies:
+		return "spend policies"
+	default:
+		return fmt.Sprintf("Rule(%d)", uint8(r))
+	}
+}
+
+func (vc *ValidationContext) checkRule(r Rule, txn types.Transaction) error {
+	switch r {
+	case RuleStateProofs:
+		return vc.validStateProofs(txn)
+	case RuleHistoryProofs:
+		return vc.validHistoryProofs(txn)
+	case RuleCurrencyValues:
+		return vc.validCurrencyValues(txn)
+	case RuleTimeLocks:
+		return vc.validTimeLocks(txn)
+	case RuleOutputsEqualInputs:
+		return vc.outputsEqualInputs(txn)
+	case RuleFoundationUpdate:
+		return vc.validFoundationUpdate(txn)
+	case RuleFileContracts:
+		return vc.validFileContracts(txn)
+	case RuleFileContractRevisions:
+		return vc.validFileContractRevisions(txn)
+	case RuleFileContractResolutions:
+		return vc.validFileContractResolutions(txn)
+	case RuleAttestations:
+		return vc.validAttestations(txn)
+	case RuleSpendPolicies:
+		return vc.validSpendPolicies(txn)
+	default:
+		return fmt.Errorf("unknown rule %v", r)
+	}
+}
+
+// A FraudProof demonstrates that a block is invalid without requiring the
+// verifier to download the full block. It contains the block header, the IDs
+// of every transaction in the block (which are needed to recompute the header
+// commitment), and the offending transaction itself. Since transactions carry
+// the accumulator proofs for their parent elements, the proof can be checked
+// by anyone holding the block's parent ValidationContext.
+type FraudProof struct {
+	Header           types.BlockHeader
+	TransactionIDs   []types.TransactionID
+	TransactionIndex int
+	Transaction      types.Transaction
+	Rule             Rule
+}
+
+// EncodeTo implements types.EncoderTo.
+func (fp FraudProof) EncodeTo(e *types.Encoder) {
+	fp.Header.EncodeTo(e)
+	e.WritePrefix(len(fp.TransactionIDs))
+	for _, txid := range fp.TransactionIDs {
+		txid.EncodeTo(e)
+	}
+	e.WritePrefix(fp.TransactionIndex)
+	fp.Transaction.EncodeTo(e)
+	e.WriteUint8(uint8(fp.Rule))
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (fp *FraudProof) DecodeFrom(d *types.Decoder) {
+	fp.Header.DecodeFrom(d)
+	fp.TransactionIDs = make([]types.TransactionID, d.ReadSlicePrefix(32))
+	for i := range fp.TransactionIDs {
+		fp.TransactionIDs[i].DecodeFrom(d)
+	}
+	fp.TransactionIndex = d.ReadPrefix()
+	fp.Transaction.DecodeFrom(d)
+	fp.Rule = Rule(d.ReadUint8())
+}
+
+// FraudProof returns a proof that b is invalid in the context of vc. If no
+// transaction in b is invalid in isolation (e.g. because b is only invalid
+// due to a double-spend spanning multiple transactions), ErrNoFraud is
+// returned.
+func (vc *ValidationContext) FraudProof(b types.Block) (FraudProof, error) {
+	fp := FraudProof{
+		Header:         b.Header,
+		TransactionIDs: make([]types.TransactionID, len(b.Transactions)),
+	}
+	for i, txn := range b.Transactions {
+		fp.TransactionIDs[i] = txn.ID()
+	}
+	for i, txn := range b.Transactions {
+		for r := Rule(1); r <= numRules; r++ {
+			if vc.checkRule(r, txn) != nil {
+				fp.TransactionIndex = i
+				fp.Transaction = txn
+				fp.Rule = r
+				return fp, nil
+			}
+		}
+	}
+	return FraudProof{}, ErrNoFraud
+}
+
+// VerifyFraudProof checks that fp proves the invalidity of a child block of
+// vc. A proof is valid if the header extends vc, the transaction is committed
+// to by the header, the transaction satisfies every rule preceding fp.Rule,
+// and the transaction violates fp.Rule.
+func (vc *ValidationContext) VerifyFraudProof(fp FraudProof) error {
+	h := fp.Header
+	switch {
+	case h.ParentID != vc.Index.ID || h.Height != vc.Index.Height+1:
+		return errors.New("header does not extend validation context")
+	case fp.TransactionIndex < 0 || fp.TransactionIndex >= len(fp.TransactionIDs):
+		return errors.New("transaction index out of range")
+	case fp.Transaction.ID() != fp.TransactionIDs[fp.TransactionIndex]:
+		return errors.New("transaction does not match committed ID")
+	case vc.commitmentFromIDs(h.MinerAddress, fp.TransactionIDs) != h.Commitment:
+		return errors.New("commitment hash does not match header")
+	case fp.Rule == 0 || fp.Rule > numRules:
+		return fmt.Errorf("unknown rule %v", fp.Rule)
+	}
+	for r := Rule(1); r < fp.Rule; r++ {
+		if err := vc.checkRule(r, fp.Transaction); err != nil {
+			return fmt.Errorf("transaction violates earlier rule (%v): %w", r, err)
+		}
+	}
+	if vc.checkRule(fp.Rule, fp.Transaction) == nil {
+		return fmt.Errorf("transaction does not violate rule (%v)", fp.Rule)
+	}
+	return nil
+}
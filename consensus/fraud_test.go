@@ -0,0 +1,128 @@
+package consensus
+
+import (
+	"bytes"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestFraudProof(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(10),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty)
+	vc := sau.Context
+
+	validTxn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.StandardAddress(pubkey),
+			Value:   types.Siacoins(10),
+		}},
+	}
+	signAllInputs(&validTxn, vc, privkey)
+
+	// create an invalid transaction that mints coins
+	invalidTxn := validTxn.DeepCopy()
+	invalidTxn.SiacoinOutputs[0].Value = types.Siacoins(20)
+	signAllInputs(&invalidTxn, vc, privkey)
+
+	minerAddr := types.VoidAddress
+	buildBlock := func(txns ...types.Transaction) types.Block {
+		return types.Block{
+			Header: types.BlockHeader{
+				Height:       vc.Index.Height + 1,
+				ParentID:     vc.Index.ID,
+				MinerAddress: minerAddr,
+				Commitment:   vc.Commitment(minerAddr, txns),
+			},
+			Transactions: txns,
+		}
+	}
+
+	// a valid block should not produce a fraud proof
+	if _, err := vc.FraudProof(buildBlock(validTxn)); err != ErrNoFraud {
+		t.Fatal("expected ErrNoFraud, got", err)
+	}
+
+	b := buildBlock(types.Transaction{ArbitraryData: []byte("foo")}, invalidTxn)
+	fp, err := vc.FraudProof(b)
+	if err != nil {
+		t.Fatal(err)
+	} else if fp.TransactionIndex != 1 || fp.Rule != RuleOutputsEqualInputs {
+		t.Fatalf("wrong proof: index %v, rule %v", fp.TransactionIndex, fp.Rule)
+	}
+
+	// round-trip the proof through the encoding
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	fp.EncodeTo(e)
+	e.Flush()
+	var decoded FraudProof
+	d := types.NewBufDecoder(buf.Bytes())
+	decoded.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if err := vc.VerifyFraudProof(decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// corrupt the proof in various ways
+	tests := []struct {
+		desc    string
+		corrupt func(fp *FraudProof)
+	}{
+		{
+			"wrong parent",
+			func(fp *FraudProof) { fp.Header.ParentID[0] ^= 1 },
+		},
+		{
+			"wrong commitment",
+			func(fp *FraudProof) { fp.TransactionIDs[0][0] ^= 1 },
+		},
+		{
+			"wrong transaction",
+			func(fp *FraudProof) { fp.Transaction = validTxn },
+		},
+		{
+			"index out of range",
+			func(fp *FraudProof) { fp.TransactionIndex = 2 },
+		},
+		{
+			"rule not violated",
+			func(fp *FraudProof) { fp.Rule = RuleSpendPolicies },
+		},
+		{
+			"unknown rule",
+			func(fp *FraudProof) { fp.Rule = numRules + 1 },
+		},
+	}
+	for _, test := range tests {
+		corrupt := fp
+		corrupt.TransactionIDs = append([]types.TransactionID(nil), fp.TransactionIDs...)
+		test.corrupt(&corrupt)
+		if err := vc.VerifyFraudProof(corrupt); err == nil {
+			t.Errorf("%v: expected error", test.desc)
+		}
+	}
+
+	// a proof claiming a later rule should be rejected if an earlier rule is
+	// violated
+	badSig := invalidTxn.DeepCopy()
+	badSig.SiacoinInputs[0].Signatures[0][0] ^= 1
+	b = buildBlock(badSig)
+	fp, err = vc.FraudProof(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp.Rule = RuleSpendPolicies
+	if err := vc.VerifyFraudProof(fp); err == nil {
+		t.Fatal("expected proof with incorrect rule to be rejected")
+	}
+}
@@ -0,0 +1,251 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"sort"
+
+	"go.sia.tech/core/types"
+)
+
+// headerSamplesPerBucket is the number of headers sampled from each
+// exponentially-sized bucket of the chain when constructing a HeaderProof.
+const headerSamplesPerBucket = 4
+
+// maxHeaderProofSamples is the largest number of samples that a valid
+// HeaderProof can contain: one for each work point, plus the genesis block.
+const maxHeaderProofSamples = 64*headerSamplesPerBucket + 1
+
+// A HeaderSample is a block header along with the data necessary to verify its
+// proof-of-work and commitment in isolation.
+type HeaderSample struct {
+	Header           types.BlockHeader
+	Parent           ValidationContext
+	TransactionsHash types.Hash256
+	HistoryProof     []types.Hash256
+}
+
+// EncodeTo implements types.EncoderTo.
+func (hs HeaderSample) EncodeTo(e *types.Encoder) {
+	hs.Header.EncodeTo(e)
+	hs.Parent.EncodeTo(e)
+	hs.TransactionsHash.EncodeTo(e)
+	e.WritePrefix(len(hs.HistoryProof))
+	for _, p := range hs.HistoryProof {
+		p.EncodeTo(e)
+	}
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (hs *HeaderSample) DecodeFrom(d *types.Decoder) {
+	hs.Header.DecodeFrom(d)
+	hs.Parent.DecodeFrom(d)
+	hs.TransactionsHash.DecodeFrom(d)
+	hs.HistoryProof = make([]types.Hash256, d.ReadSlicePrefix(32))
+	for i := range hs.HistoryProof {
+		hs.HistoryProof[i].DecodeFrom(d)
+	}
+}
+
+func (hs HeaderSample) verify() error {
+	h := hs.Header
+	switch {
+	case h.Height != hs.Parent.Index.Height+1:
+		return errors.New("wrong height")
+	case h.ParentID != hs.Parent.Index.ID:
+		return errors.New("wrong parent ID")
+	case h.Nonce%NonceFactor != 0:
+		return errors.New("nonce is not divisible by required factor")
+	case types.WorkRequiredForHash(h.ID()).Cmp(hs.Parent.Difficulty) < 0:
+		return errors.New("insufficient work")
+	case hs.Parent.commitmentFromHash(h.MinerAddress, hs.TransactionsHash) != h.Commitment:
+		return errors.New("commitment hash does not match header")
+	}
+	return nil
+}
+
+// A HeaderProof is a FlyClient-style proof that a block is the tip of a chain
+// with a given amount of work. Rather than every header in the chain, it
+// contains a logarithmic number of headers sampled pseudorandomly by
+// cumulative work (with a bias towards recent blocks), each of which is proven
+// to be part of the tip's history.
+type HeaderProof struct {
+	Tip     HeaderSample
+	Samples []HeaderSample
+}
+
+// EncodeTo implements types.EncoderTo.
+func (hp HeaderProof) EncodeTo(e *types.Encoder) {
+	hp.Tip.EncodeTo(e)
+	e.WritePrefix(len(hp.Samples))
+	for _, s := range hp.Samples {
+		s.EncodeTo(e)
+	}
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (hp *HeaderProof) DecodeFrom(d *types.Decoder) {
+	hp.Tip.DecodeFrom(d)
+	hp.Samples = make([]HeaderSample, d.ReadPrefixMax(maxHeaderProofSamples))
+	for i := range hp.Samples {
+		hp.Samples[i].DecodeFrom(d)
+	}
+}
+
+// headerProofWork returns the points of cumulative work that must be covered
+// by the samples of a HeaderProof for tip, whose parent has accumulated
+// totalWork. The points are derived from the tip's ID, so a prover cannot
+// choose which blocks to reveal, and are sorted in ascending order. The first
+// point is always zero, anchoring the proof to the genesis block. If the chain
+// is short enough that every block must be sampled, headerProofWork returns
+// nil.
+//
+// Sampling by work rather than by height binds each sample's difficulty to the
+// work it claims: a block covers the work interval [TotalWork, TotalWork +
+// Difficulty) of its parent, so a chain that inflates its total work without
+// performing it leaves gaps that no sample can cover.
+func headerProofWork(tip types.BlockHeader, totalWork types.Work) []types.Work {
+	if tip.Height <= 1 {
+		return nil
+	}
+	// we sample distances from the tip, dividing them into buckets
+	// [total/2^(i+1), total/2^i) so that recent blocks are sampled more densely
+	// than old blocks
+	n := tip.Height - 1
+	numBuckets := bits.Len64(n)
+	if numSamples := uint64(numBuckets * headerSamplesPerBucket); numSamples >= n {
+		return nil
+	}
+
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
+	tipID := tip.ID()
+	total := totalWork.Big()
+	points := []types.Work{{}}
+	for i := 0; i < numBuckets*headerSamplesPerBucket; i++ {
+		h.Reset()
+		h.E.WriteString("sia/headerproof")
+		tipID.EncodeTo(h.E)
+		h.E.WriteUint64(uint64(i))
+		seed := h.Sum()
+
+		bucket := i / headerSamplesPerBucket
+		lo := new(big.Int).Rsh(total, uint(numBuckets-bucket))
+		hi := new(big.Int).Rsh(total, uint(numBuckets-bucket-1))
+		dist := new(big.Int).Set(lo)
+		if width := new(big.Int).Sub(hi, lo); width.Sign() > 0 {
+			dist.Add(dist, width.Mod(new(big.Int).SetBytes(seed[:]), width))
+		}
+		if dist.Sign() == 0 {
+			dist.SetUint64(1)
+		}
+		w, _ := types.WorkFromBig(dist.Sub(total, dist)) // dist <= total
+		points = append(points, w)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Cmp(points[j]) < 0 })
+	dedup := points[:1]
+	for _, w := range points[1:] {
+		if w != dedup[len(dedup)-1] {
+			dedup = append(dedup, w)
+		}
+	}
+	return dedup
+}
+
+// HeaderProofHeights returns the heights of the blocks that must be sampled in
+// a HeaderProof for tip, whose parent has accumulated totalWork. parentWork
+// must return the total work of the parent of the block at the given height in
+// the tip's chain. Height 1 is always included, anchoring the proof to the
+// genesis block.
+func HeaderProofHeights(tip types.BlockHeader, totalWork types.Work, parentWork func(height uint64) (types.Work, error)) ([]uint64, error) {
+	if tip.Height <= 1 {
+		return nil, nil
+	}
+	points := headerProofWork(tip, totalWork)
+	if points == nil {
+		heights := make([]uint64, tip.Height-1)
+		for i := range heights {
+			heights[i] = uint64(i) + 1
+		}
+		return heights, nil
+	}
+	// find the block whose work interval contains each point, i.e. the last
+	// block whose parent has accumulated no more than the point
+	var heights []uint64
+	var err error
+	for _, w := range points {
+		i := sort.Search(int(tip.Height-1), func(i int) bool {
+			pw, perr := parentWork(uint64(i) + 1)
+			if perr != nil && err == nil {
+				err = perr
+			}
+			return pw.Cmp(w) > 0
+		})
+		if err != nil {
+			return nil, err
+		} else if i == 0 {
+			return nil, fmt.Errorf("no block covers work %v", w)
+		}
+		if height := uint64(i); len(heights) == 0 || heights[len(heights)-1] != height {
+			heights = append(heights, height)
+		}
+	}
+	return heights, nil
+}
+
+// VerifyHeaderProof verifies that hp demonstrates a chain ending in
+// hp.Tip.Header with hp.Tip.Parent.TotalWork accumulated work (excluding the
+// tip itself). The caller should additionally check that the parent of the
+// first sample is the expected genesis block.
+func VerifyHeaderProof(hp HeaderProof) error {
+	if err := hp.Tip.verify(); err != nil {
+		return fmt.Errorf("invalid tip: %w", err)
+	}
+	totalWork := hp.Tip.Parent.TotalWork
+	points := headerProofWork(hp.Tip.Header, totalWork)
+	if points == nil && hp.Tip.Header.Height > 1 && uint64(len(hp.Samples)) != hp.Tip.Header.Height-1 {
+		return fmt.Errorf("wrong number of samples: expected %v, got %v", hp.Tip.Header.Height-1, len(hp.Samples))
+	}
+	history := &hp.Tip.Parent.History
+	var prevHeight uint64
+	var prevEnd types.Work
+	var next int // index of the next point to cover
+	for i, s := range hp.Samples {
+		start := s.Parent.TotalWork
+		end := start.Add(s.Parent.Difficulty)
+		switch {
+		case s.Header.Height <= prevHeight || s.Header.Height >= hp.Tip.Header.Height:
+			return fmt.Errorf("sample %v has out-of-order height %v", i, s.Header.Height)
+		case points == nil && s.Header.Height != uint64(i)+1:
+			return fmt.Errorf("sample %v has wrong height: expected %v, got %v", i, i+1, s.Header.Height)
+		case end.Cmp(start) <= 0 || end.Cmp(totalWork) > 0:
+			return fmt.Errorf("sample %v has inconsistent difficulty", i)
+		case start.Cmp(prevEnd) < 0:
+			return fmt.Errorf("sample %v overlaps the work of sample %v", i, i-1)
+		case s.Header.Height == prevHeight+1 && start != prevEnd:
+			return fmt.Errorf("sample %v does not extend the work of its parent", i)
+		case s.Header.Height == hp.Tip.Header.Height-1 && end != totalWork:
+			return fmt.Errorf("sample %v does not extend to the work of the tip", i)
+		}
+		if err := s.verify(); err != nil {
+			return fmt.Errorf("sample %v is invalid: %w", i, err)
+		} else if !history.Contains(s.Header.Index(), s.HistoryProof) {
+			return fmt.Errorf("sample %v is not present in tip history", i)
+		}
+		if points != nil {
+			if next == len(points) || start.Cmp(points[next]) > 0 || end.Cmp(points[next]) <= 0 {
+				return fmt.Errorf("sample %v does not cover the required work", i)
+			}
+			for next < len(points) && end.Cmp(points[next]) > 0 {
+				next++
+			}
+		}
+		prevHeight, prevEnd = s.Header.Height, end
+	}
+	if next != len(points) {
+		return fmt.Errorf("proof is missing samples for %v work points", len(points)-next)
+	}
+	return nil
+}
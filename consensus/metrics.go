@@ -0,0 +1,44 @@
+package consensus
+
+import "time"
+
+// Metrics receives instrumentation data from the consensus package. It is
+// attached to a ValidationContext via its Metrics field. All methods must be
+// safe for concurrent use and should return quickly, as they are called
+// synchronously during validation.
+type Metrics interface {
+	// SignaturesVerified is called after the spend policies of a transaction
+	// have been checked. n is the number of signatures in the transaction.
+	SignaturesVerified(n int, d time.Duration)
+	// ProofsVerified is called after the state and history proofs of a
+	// transaction have been checked. n is the number of proofs.
+	ProofsVerified(n int, d time.Duration)
+	// AccumulatorUpdated is called after ApplyBlock or RevertBlock has
+	// updated the state and history accumulators.
+	AccumulatorUpdated(d time.Duration)
+	// ElementsCreated is called by ApplyBlock with the number of siacoin,
+	// siafund, and file contract elements created by the block.
+	ElementsCreated(n int)
+	// ElementsSpent is called by ApplyBlock with the number of siacoin and
+	// siafund elements spent and file contracts resolved by the block.
+	ElementsSpent(n int)
+}
+
+// NopMetrics implements Metrics by discarding all data. It can be embedded to
+// implement a subset of the Metrics interface.
+type NopMetrics struct{}
+
+// SignaturesVerified implements Metrics.
+func (NopMetrics) SignaturesVerified(int, time.Duration) {}
+
+// ProofsVerified implements Metrics.
+func (NopMetrics) ProofsVerified(int, time.Duration) {}
+
+// AccumulatorUpdated implements Metrics.
+func (NopMetrics) AccumulatorUpdated(time.Duration) {}
+
+// ElementsCreated implements Metrics.
+func (NopMetrics) ElementsCreated(int) {}
+
+// ElementsSpent implements Metrics.
+func (NopMetrics) ElementsSpent(int) {}
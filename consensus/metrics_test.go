@@ -0,0 +1,156 @@
+package consensus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+type recordingMetrics struct {
+	mu         sync.Mutex
+	sigs       int
+	proofs     int
+	accUpdates int
+	created    int
+	spent      int
+}
+
+func (m *recordingMetrics) SignaturesVerified(n int, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sigs += n
+}
+
+func (m *recordingMetrics) ProofsVerified(n int, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proofs += n
+}
+
+func (m *recordingMetrics) AccumulatorUpdated(time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accUpdates++
+}
+
+func (m *recordingMetrics) ElementsCreated(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.created += n
+}
+
+func (m *recordingMetrics) ElementsSpent(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spent += n
+}
+
+func TestMetrics(t *testing.T) {
+	m := new(recordingMetrics)
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(10),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty)
+	vc := sau.Context
+	vc.Metrics = m
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.StandardAddress(pubkey), Value: types.Siacoins(4)},
+			{Address: types.StandardAddress(pubkey), Value: types.Siacoins(6)},
+		},
+	}
+	signAllInputs(&txn, vc, privkey)
+	if err := vc.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if m.sigs != 1 || m.proofs != 1 {
+		t.Fatalf("unexpected validation metrics: %+v", m)
+	}
+
+	b := types.Block{
+		Header: types.BlockHeader{
+			Height:   1,
+			ParentID: genesis.ID(),
+		},
+		Transactions: []types.Transaction{txn},
+	}
+	au := ApplyBlock(vc, b)
+	if m.accUpdates != 1 || m.created != 3 || m.spent != 1 {
+		t.Fatalf("unexpected apply metrics: %+v", m)
+	} else if au.Context.Metrics != m {
+		t.Fatal("child context did not inherit metrics")
+	}
+	RevertBlock(vc, b)
+	if m.accUpdates != 2 {
+		t.Fatalf("unexpected revert metrics: %+v", m)
+	}
+
+	// contexts without metrics, or with their own, should not record to m
+	other := new(recordingMetrics)
+	ApplyBlock(sau.Context, b)
+	vc.Metrics = other
+	ApplyBlock(vc, b)
+	if m.accUpdates != 2 || other.accUpdates != 1 {
+		t.Fatal("metrics recorded to the wrong sink")
+	}
+}
+
+func TestProfiling(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(10),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty)
+	if sau.Profile != nil {
+		t.Fatal("profile attached while profiling disabled")
+	}
+
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{
+			{Address: types.StandardAddress(pubkey), Value: types.Siacoins(10)},
+		},
+	}
+	signAllInputs(&txn, sau.Context, privkey)
+	b := mineBlock(sau.Context, genesis, txn)
+
+	vc := sau.Context
+	vc.Profiler = new(Profiler)
+	if err := vc.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	au := ApplyBlock(vc, b)
+	if p := au.Profile; p == nil {
+		t.Fatal("no profile attached")
+	} else if p.Signatures == 0 || p.Accumulator == 0 {
+		t.Fatal("phases not recorded:", p)
+	} else if p.Total() < p.Signatures+p.Accumulator {
+		t.Fatal("wrong total:", p)
+	}
+
+	// blocks that were not validated only report the accumulator update
+	b2 := mineBlock(au.Context, b)
+	if p := ApplyBlock(au.Context, b2).Profile; p == nil || p.Accumulator == 0 || p.Signatures != 0 {
+		t.Fatal("unexpected profile for unvalidated block:", p)
+	}
+
+	// profiles are not shared between Profilers
+	vc.Profiler = new(Profiler)
+	if p := ApplyBlock(vc, b).Profile; p == nil || p.Signatures != 0 {
+		t.Fatal("profile shared between profilers:", p)
+	} else if ApplyBlock(sau.Context, b).Profile != nil {
+		t.Fatal("profile attached without a profiler")
+	}
+}
@@ -0,0 +1,45 @@
+package consensus
+
+import "go.sia.tech/core/types"
+
+// Network contains the consensus parameters that may differ between networks,
+// such as mainnet and testnets. A network's parameters are fixed by its genesis
+// block and carried in each ValidationContext.
+type Network struct {
+	// MaturityDelay is the number of blocks after which block rewards,
+	// Foundation subsidies, siafund claims, and contract resolutions become
+	// spendable. See (*ValidationContext).MaturityHeight.
+	MaturityDelay uint64 `json:"maturityDelay"`
+	// MinProofWindow is the minimum length, in blocks, of a file contract's
+	// proof window, i.e. WindowEnd - WindowStart.
+	MinProofWindow uint64 `json:"minProofWindow"`
+}
+
+// MainnetNetwork contains the parameters of the Sia mainnet.
+var MainnetNetwork = Network{
+	MaturityDelay:  blocksPerDay,
+	MinProofWindow: 1,
+}
+
+// EncodeTo implements types.EncoderTo.
+func (n Network) EncodeTo(e *types.Encoder) {
+	e.WriteUint64(n.MaturityDelay)
+	e.WriteUint64(n.MinProofWindow)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (n *Network) DecodeFrom(d *types.Decoder) {
+	n.MaturityDelay = d.ReadUint64()
+	n.MinProofWindow = d.ReadUint64()
+}
+
+// GenesisUpdate returns the ApplyUpdate for the genesis block b of a chain
+// using n's parameters. The ID of b is used as the chain ID.
+func (n Network) GenesisUpdate(b types.Block, initialDifficulty types.Work) ApplyUpdate {
+	return ApplyBlock(ValidationContext{
+		ChainID:          types.Hash256(b.ID()),
+		Network:          n,
+		Difficulty:       initialDifficulty,
+		GenesisTimestamp: b.Header.Timestamp,
+	}, b)
+}
@@ -0,0 +1,56 @@
+//go:build paranoid
+// +build paranoid
+
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+func encodeBlock(b types.Block) []byte {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	b.Header.EncodeTo(e)
+	e.WritePrefix(len(b.Transactions))
+	for i := range b.Transactions {
+		b.Transactions[i].EncodeTo(e)
+	}
+	e.Flush()
+	return buf.Bytes()
+}
+
+// checkCanonicalEncoding asserts that b survives an encoding round-trip with
+// its header ID, transaction IDs, and commitment unchanged. Any mismatch
+// indicates that the decoder accepted a non-canonical encoding, or that the
+// encoder and decoder disagree.
+func checkCanonicalEncoding(vc ValidationContext, b types.Block) {
+	enc := encodeBlock(b)
+
+	var b2 types.Block
+	d := types.NewBufDecoder(enc)
+	b2.Header.DecodeFrom(d)
+	b2.Transactions = make([]types.Transaction, d.ReadPrefix())
+	for i := range b2.Transactions {
+		b2.Transactions[i].DecodeFrom(d)
+	}
+	if err := d.Err(); err != nil {
+		panic(fmt.Sprintf("paranoid: could not decode block %v: %v", b.Index(), err))
+	}
+	for i := range b.Transactions {
+		if b.Transactions[i].ID() != b2.Transactions[i].ID() {
+			panic(fmt.Sprintf("paranoid: transaction %v changed ID after round-trip", i))
+		}
+	}
+	if b.ID() != b2.ID() {
+		panic(fmt.Sprintf("paranoid: block %v changed ID after round-trip", b.Index()))
+	}
+	if b.Header.Height > 0 && vc.Commitment(b.Header.MinerAddress, b.Transactions) != vc.Commitment(b2.Header.MinerAddress, b2.Transactions) {
+		panic(fmt.Sprintf("paranoid: block %v changed commitment after round-trip", b.Index()))
+	}
+	if !bytes.Equal(enc, encodeBlock(b2)) {
+		panic(fmt.Sprintf("paranoid: block %v re-encoded differently", b.Index()))
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !paranoid
+// +build !paranoid
+
+package consensus
+
+import "go.sia.tech/core/types"
+
+// checkCanonicalEncoding is a no-op unless the paranoid build tag is set.
+func checkCanonicalEncoding(ValidationContext, types.Block) {}
@@ -0,0 +1,74 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// A BlockProfile records the time spent in each phase of validating and
+// applying a block.
+type BlockProfile struct {
+	Header     time.Duration
+	Commitment time.Duration
+	Proofs     time.Duration
+	Signatures time.Duration
+	// Other is the time spent on all other transaction checks, e.g. for
+	// double-spends and valid file contract revisions.
+	Other       time.Duration
+	Accumulator time.Duration
+}
+
+// Total returns the total time spent on the block.
+func (p BlockProfile) Total() time.Duration {
+	return p.Header + p.Commitment + p.Proofs + p.Signatures + p.Other + p.Accumulator
+}
+
+// String implements fmt.Stringer.
+func (p BlockProfile) String() string {
+	return fmt.Sprintf("total %v (header %v, commitment %v, proofs %v, signatures %v, other %v, accumulator %v)",
+		p.Total(), p.Header, p.Commitment, p.Proofs, p.Signatures, p.Other, p.Accumulator)
+}
+
+// maxValidationProfiles is the number of validation profiles retained by a
+// Profiler for ApplyBlock.
+const maxValidationProfiles = 1000
+
+// A Profiler records BlockProfiles. It is attached to a ValidationContext via
+// its Profiler field, and is safe for concurrent use. The zero value is ready
+// for use.
+//
+// While a Profiler is attached, ValidateBlock records the time spent in each
+// phase of validation, and ApplyBlock attaches a BlockProfile to its
+// ApplyUpdate, comprising the validation phases of the block (if it was
+// validated recently with the same Profiler) and the time spent updating the
+// accumulators.
+type Profiler struct {
+	mu       sync.Mutex
+	profiles map[types.BlockID]BlockProfile
+	order    []types.BlockID
+}
+
+func (pr *Profiler) storeValidationProfile(id types.BlockID, p BlockProfile) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if pr.profiles == nil {
+		pr.profiles = make(map[types.BlockID]BlockProfile)
+	}
+	if _, ok := pr.profiles[id]; !ok {
+		pr.order = append(pr.order, id)
+	}
+	pr.profiles[id] = p
+	if len(pr.order) > maxValidationProfiles {
+		delete(pr.profiles, pr.order[0])
+		pr.order = pr.order[1:]
+	}
+}
+
+func (pr *Profiler) validationProfile(id types.BlockID) BlockProfile {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.profiles[id]
+}
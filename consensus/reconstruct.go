@@ -0,0 +1,29 @@
+package consensus
+
+import (
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// ReconstructContext returns the ValidationContext at the given height, i.e.
+// the context used to validate the child of the block at that height. It
+// starts from the context of c and applies blocks, which must be the
+// consecutive descendants of c.Block, until the desired height is reached.
+// Blocks beyond that height are ignored. The blocks are assumed to be fully
+// validated; only their linkage is checked.
+func ReconstructContext(c Checkpoint, blocks []types.Block, height uint64) (ValidationContext, error) {
+	vc := c.Context
+	if height < vc.Index.Height {
+		return ValidationContext{}, fmt.Errorf("height %v precedes checkpoint at height %v", height, vc.Index.Height)
+	} else if n := height - vc.Index.Height; n > uint64(len(blocks)) {
+		return ValidationContext{}, fmt.Errorf("need %v blocks to reach height %v, but only %v were provided", n, height, len(blocks))
+	}
+	for _, b := range blocks[:height-vc.Index.Height] {
+		if b.Header.ParentIndex() != vc.Index {
+			return ValidationContext{}, fmt.Errorf("block %v is not a child of %v", b.Index(), vc.Index)
+		}
+		vc = ApplyBlock(vc, b).Context
+	}
+	return vc, nil
+}
@@ -0,0 +1,56 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestReconstructContext(t *testing.T) {
+	genesis := genesisWithSiacoinOutputs()
+	vc := GenesisUpdate(genesis, testingDifficulty).Context
+	checkpoint := Checkpoint{Block: genesis, Context: vc}
+
+	var blocks []types.Block
+	contexts := []ValidationContext{vc}
+	parent := genesis
+	for i := 0; i < 10; i++ {
+		b := types.Block{
+			Header: types.BlockHeader{
+				Height:    parent.Header.Height + 1,
+				ParentID:  parent.ID(),
+				Timestamp: parent.Header.Timestamp.Add(time.Duration(i) * time.Minute),
+			},
+		}
+		vc = ApplyBlock(vc, b).Context
+		blocks = append(blocks, b)
+		contexts = append(contexts, vc)
+		parent = b
+	}
+
+	for height := range contexts {
+		rvc, err := ReconstructContext(checkpoint, blocks, uint64(height))
+		if err != nil {
+			t.Fatal(err)
+		} else if rvc.contextHash() != contexts[height].contextHash() {
+			t.Fatalf("context at height %v does not match", height)
+		}
+	}
+
+	// reconstruct from a later checkpoint
+	later := Checkpoint{Block: blocks[4], Context: contexts[5]}
+	if rvc, err := ReconstructContext(later, blocks[5:], 8); err != nil {
+		t.Fatal(err)
+	} else if rvc.contextHash() != contexts[8].contextHash() {
+		t.Fatal("context at height 8 does not match")
+	}
+
+	if _, err := ReconstructContext(later, blocks[5:], 3); err == nil {
+		t.Fatal("expected error for height before checkpoint")
+	} else if _, err := ReconstructContext(checkpoint, blocks, 11); err == nil {
+		t.Fatal("expected error for insufficient blocks")
+	} else if _, err := ReconstructContext(later, blocks[6:], 8); err == nil {
+		t.Fatal("expected error for unlinked blocks")
+	}
+}
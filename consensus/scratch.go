@@ -15,6 +15,33 @@ type ScratchChain struct {
 	hvc ValidationContext
 	// for validating transactions
 	tvc ValidationContext
+
+	headerLimit int
+}
+
+// SetHeaderLimit caps the number of validated headers retained in memory. Once
+// more than 2n headers have been validated, all but the most recent n are
+// discarded, and Base advances accordingly. Since discarded headers can no
+// longer be retrieved from the chain, their blocks must be available elsewhere
+// (e.g. in a chain.ManagerStore). A limit of zero, the default, retains all
+// headers.
+func (sc *ScratchChain) SetHeaderLimit(n int) {
+	sc.headerLimit = n
+	sc.prune()
+}
+
+func (sc *ScratchChain) prune() {
+	if sc.headerLimit <= 0 {
+		return
+	}
+	// prune in batches so that the cost of copying is amortized
+	validated := int(sc.tvc.Index.Height - sc.base.Height)
+	if validated < 2*sc.headerLimit {
+		return
+	}
+	drop := validated - sc.headerLimit
+	sc.base = sc.headers[drop-1].Index()
+	sc.headers = append([]types.BlockHeader(nil), sc.headers[drop:]...)
 }
 
 // AppendHeader validates the supplied header and appends it to the chain.
@@ -38,6 +65,7 @@ func (sc *ScratchChain) ApplyBlock(b types.Block) (Checkpoint, error) {
 		return Checkpoint{}, err
 	}
 	sc.tvc = ApplyBlock(sc.tvc, b).Context
+	sc.prune()
 	return Checkpoint{
 		Block:   b,
 		Context: sc.tvc,
@@ -51,7 +79,7 @@ func (sc *ScratchChain) Index(height uint64) types.ChainIndex {
 }
 
 // Base returns the base of the header chain, i.e. the parent of the first
-// header.
+// retained header.
 func (sc *ScratchChain) Base() types.ChainIndex {
 	return sc.base
 }
@@ -83,6 +111,13 @@ func (sc *ScratchChain) FullyValidated() bool {
 	return sc.tvc.Index == sc.hvc.Index
 }
 
+// HeaderContext returns the validation context of the tip of the header chain.
+// Only its header-related fields (e.g. Index, PrevTimestamps, and Difficulty)
+// are meaningful; its accumulators are not updated as headers are appended.
+func (sc *ScratchChain) HeaderContext() ValidationContext {
+	return sc.hvc
+}
+
 // TotalWork returns the total work of the header chain.
 func (sc *ScratchChain) TotalWork() types.Work {
 	return sc.hvc.TotalWork
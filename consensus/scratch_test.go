@@ -201,3 +201,57 @@ func TestScratchChainDifficultyAdjustment(t *testing.T) {
 	}
 	vc = ApplyBlock(vc, b).Context
 }
+
+func TestScratchChainHeaderLimit(t *testing.T) {
+	genesis := genesisWithSiacoinOutputs()
+	vc := GenesisUpdate(genesis, testingDifficulty).Context
+	sc := NewScratchChain(vc)
+	sc.SetHeaderLimit(4)
+
+	var blocks []types.Block
+	parent := genesis
+	for i := 0; i < 20; i++ {
+		b := mineBlock(vc, parent)
+		vc = ApplyBlock(vc, b).Context
+		blocks = append(blocks, b)
+		parent = b
+		if err := sc.AppendHeader(b.Header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, b := range blocks[:15] {
+		if _, err := sc.ApplyBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		// at most 2*limit validated headers should be retained
+		if validated := sc.ValidTip().Height - sc.Base().Height; validated >= 2*4 {
+			t.Fatalf("retained %v validated headers after applying block %v", validated, i)
+		}
+	}
+
+	// retained headers should still be accessible
+	if sc.Tip() != blocks[19].Index() {
+		t.Fatal("wrong tip")
+	}
+	for _, b := range blocks {
+		want := b.Header.Height > sc.Base().Height
+		if sc.Contains(b.Index()) != want {
+			t.Fatalf("Contains(%v) should be %v", b.Index(), want)
+		}
+		if want && sc.Index(b.Header.Height) != b.Index() {
+			t.Fatalf("wrong index at height %v", b.Header.Height)
+		}
+	}
+	if len(sc.Unvalidated()) != 5 || sc.UnvalidatedBase() != blocks[15].Index() {
+		t.Fatal("wrong unvalidated headers")
+	}
+	for _, b := range blocks[15:] {
+		if _, err := sc.ApplyBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !sc.FullyValidated() {
+		t.Fatal("chain should be fully validated")
+	}
+}
@@ -0,0 +1,57 @@
+package consensus
+
+import (
+	"math/big"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// SimulateDifficulty returns the difficulty following each block in a chain
+// whose genesis block has the given timestamp and initial difficulty, and
+// whose subsequent blocks have the given timestamps. It uses the same
+// difficulty adjustment algorithm as ApplyBlock.
+func SimulateDifficulty(initialDifficulty types.Work, genesisTimestamp time.Time, timestamps []time.Time) []types.Work {
+	vc := ValidationContext{
+		Difficulty:       initialDifficulty,
+		GenesisTimestamp: genesisTimestamp,
+	}
+	applyHeader(&vc, types.BlockHeader{Timestamp: genesisTimestamp})
+	difficulties := make([]types.Work, len(timestamps))
+	for i, ts := range timestamps {
+		applyHeader(&vc, types.BlockHeader{Height: uint64(i) + 1, Timestamp: ts})
+		difficulties[i] = vc.Difficulty
+	}
+	return difficulties
+}
+
+// SimulateHashrate is like SimulateDifficulty, but rather than block
+// timestamps, it takes the network hashrate (in hashes per second) while each
+// block was mined. Each block is assumed to be found after exactly the
+// expected number of hashes, i.e. the current difficulty. The resulting block
+// timestamps are returned alongside the difficulties.
+func SimulateHashrate(initialDifficulty types.Work, genesisTimestamp time.Time, hashrates []uint64) ([]time.Time, []types.Work) {
+	vc := ValidationContext{
+		Difficulty:       initialDifficulty,
+		GenesisTimestamp: genesisTimestamp,
+	}
+	applyHeader(&vc, types.BlockHeader{Timestamp: genesisTimestamp})
+	timestamps := make([]time.Time, len(hashrates))
+	difficulties := make([]types.Work, len(hashrates))
+	ts := genesisTimestamp
+	for i, rate := range hashrates {
+		if rate == 0 {
+			rate = 1
+		}
+		expected := vc.Difficulty.Div64(rate)
+		secs := new(big.Int).SetBytes(expected.NumHashes[:])
+		if !secs.IsInt64() || secs.Int64() > int64(1<<33) {
+			secs.SetInt64(1 << 33) // roughly 272 years; avoids time.Duration overflow
+		}
+		ts = ts.Add(time.Duration(secs.Int64()) * time.Second)
+		applyHeader(&vc, types.BlockHeader{Height: uint64(i) + 1, Timestamp: ts})
+		timestamps[i] = ts
+		difficulties[i] = vc.Difficulty
+	}
+	return timestamps, difficulties
+}
@@ -0,0 +1,60 @@
+package consensus
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestSimulateDifficulty(t *testing.T) {
+	genesis := time.Unix(734600000, 0)
+	initial := types.Work{NumHashes: [32]byte{30: 1}}
+
+	// blocks arriving exactly on schedule should keep the difficulty roughly
+	// constant
+	timestamps := make([]time.Time, 100)
+	for i := range timestamps {
+		timestamps[i] = genesis.Add(time.Duration(i+1) * BlockInterval)
+	}
+	diffs := SimulateDifficulty(initial, genesis, timestamps)
+	if len(diffs) != len(timestamps) {
+		t.Fatal("wrong number of difficulties")
+	}
+
+	// the simulation should match ApplyBlock
+	vc := GenesisUpdate(types.Block{Header: types.BlockHeader{Timestamp: genesis}}, initial).Context
+	for i, ts := range timestamps[:10] {
+		vc = ApplyBlock(vc, types.Block{Header: types.BlockHeader{
+			Height:    uint64(i) + 1,
+			ParentID:  vc.Index.ID,
+			Timestamp: ts,
+		}}).Context
+		if vc.Difficulty != diffs[i] {
+			t.Fatalf("simulated difficulty diverges from ApplyBlock at height %v", i+1)
+		}
+	}
+
+	// a hashrate-driven simulation should produce blocks spaced by the
+	// expected solve time and match SimulateDifficulty
+	hashrates := []uint64{100, 200, 400, 800, 0}
+	ts, hd := SimulateHashrate(initial, genesis, hashrates)
+	if !reflect.DeepEqual(SimulateDifficulty(initial, genesis, ts), hd) {
+		t.Fatal("SimulateHashrate diverges from SimulateDifficulty")
+	}
+	prev, prevDiff := genesis, initial
+	for i := range ts {
+		rate := hashrates[i]
+		if rate == 0 {
+			rate = 1
+		}
+		expected := prevDiff.Div64(rate)
+		secs := new(big.Int).SetBytes(expected.NumHashes[:]).Int64()
+		if got := ts[i].Sub(prev); got != time.Duration(secs)*time.Second {
+			t.Fatalf("block %v: expected solve time of %vs, got %v", i, secs, got)
+		}
+		prev, prevDiff = ts[i], hd[i]
+	}
+}
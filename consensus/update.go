@@ -1,8 +1,11 @@
 package consensus
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
+	"go.sia.tech/core/internal/assert"
 	"go.sia.tech/core/merkle"
 	"go.sia.tech/core/types"
 )
@@ -77,7 +80,13 @@ func applyHeader(vc *ValidationContext, h types.BlockHeader) {
 	vc.TotalWork = vc.TotalWork.Add(vc.Difficulty)
 	parentTimestamp := vc.PrevTimestamps[vc.numTimestamps()-1]
 	vc.OakTime, vc.OakWork = updateOakTotals(vc.OakTime, h.Timestamp.Sub(parentTimestamp), vc.OakWork, vc.Difficulty)
+	prevDifficulty := vc.Difficulty
 	vc.Difficulty = adjustDifficulty(vc.Difficulty, h.Height, h.Timestamp.Sub(vc.GenesisTimestamp), vc.OakTime, vc.OakWork)
+	if assert.Enabled {
+		maxAdjust := prevDifficulty.Div64(250)
+		assert.That(vc.Difficulty.Cmp(prevDifficulty.Sub(maxAdjust)) >= 0 && vc.Difficulty.Cmp(prevDifficulty.Add(maxAdjust)) <= 0,
+			"difficulty %v at height %v outside clamp of %v", vc.Difficulty, h.Height, prevDifficulty)
+	}
 	if vc.numTimestamps() < len(vc.PrevTimestamps) {
 		vc.PrevTimestamps[vc.numTimestamps()] = h.Timestamp
 	} else {
@@ -235,6 +244,13 @@ func createdInBlock(vc ValidationContext, b types.Block) (sces []types.SiacoinEl
 	return
 }
 
+func blockAttestations(b types.Block) (as []types.Attestation) {
+	for _, txn := range b.Transactions {
+		as = append(as, txn.Attestations...)
+	}
+	return
+}
+
 // A ApplyUpdate reflects the changes to consensus state resulting from the
 // application of a block.
 type ApplyUpdate struct {
@@ -249,6 +265,11 @@ type ApplyUpdate struct {
 	NewSiacoinElements    []types.SiacoinElement
 	NewSiafundElements    []types.SiafundElement
 	NewFileContracts      []types.FileContractElement
+	// Attestations are the attestations in the block, in order.
+	Attestations []types.Attestation
+
+	// Profile is set only if the parent context has a Profiler.
+	Profile *BlockProfile
 }
 
 // SiacoinElementWasSpent returns true if the given SiacoinElement was spent.
@@ -284,15 +305,19 @@ func (au *ApplyUpdate) FileContractElementWasResolved(fce types.FileContractElem
 // ApplyBlock integrates a block into the current consensus state, producing an
 // ApplyUpdate detailing the resulting changes. The block is assumed to be fully
 // validated.
+//
+//core:consensus
 func ApplyBlock(vc ValidationContext, b types.Block) (au ApplyUpdate) {
 	if vc.Index.Height > 0 && vc.Index != b.Header.ParentIndex() {
 		panic("consensus: cannot apply non-child block")
 	}
+	checkCanonicalEncoding(vc, b)
 
 	// update elements
 	var updated, created []merkle.ElementLeaf
 	au.SpentSiacoins, au.SpentSiafunds, au.RevisedFileContracts, au.ResolvedFileContracts, updated = updatedInBlock(vc, b, true)
 	au.NewSiacoinElements, au.NewSiafundElements, au.NewFileContracts = createdInBlock(vc, b)
+	au.Attestations = blockAttestations(b)
 	spent := make(map[types.ElementID]bool)
 	for _, txn := range b.Transactions {
 		for _, in := range txn.SiacoinInputs {
@@ -310,7 +335,32 @@ func ApplyBlock(vc ValidationContext, b types.Block) (au ApplyUpdate) {
 	for _, fce := range au.NewFileContracts {
 		created = append(created, merkle.FileContractLeaf(fce, spent[fce.ID]))
 	}
+	m := vc.Metrics
+	profile := vc.Profiler != nil
+	var start time.Time
+	if m != nil || profile {
+		start = time.Now()
+	}
+	if m != nil {
+		m.ElementsSpent(len(au.SpentSiacoins) + len(au.SpentSiafunds) + len(au.ResolvedFileContracts))
+		m.ElementsCreated(len(created))
+	}
+	prevLeaves := vc.State.NumLeaves
 	au.ElementApplyUpdate = vc.State.ApplyBlock(updated, created)
+	assert.That(vc.State.NumLeaves == prevLeaves+uint64(len(created)),
+		"accumulator has %v leaves after adding %v to %v", vc.State.NumLeaves, len(created), prevLeaves)
+	au.HistoryApplyUpdate = vc.History.ApplyBlock(b.Index())
+	if m != nil || profile {
+		d := time.Since(start)
+		if m != nil {
+			m.AccumulatorUpdated(d)
+		}
+		if profile {
+			p := vc.Profiler.validationProfile(b.ID())
+			p.Accumulator = d
+			au.Profile = &p
+		}
+	}
 	for i := range au.NewSiacoinElements {
 		au.NewSiacoinElements[i].StateElement = created[0].StateElement
 		created = created[1:]
@@ -324,9 +374,6 @@ func ApplyBlock(vc ValidationContext, b types.Block) (au ApplyUpdate) {
 		created = created[1:]
 	}
 
-	// update history
-	au.HistoryApplyUpdate = vc.History.ApplyBlock(b.Index())
-
 	// update context
 	applyHeader(&vc, b.Header)
 	for _, txn := range b.Transactions {
@@ -342,12 +389,13 @@ func ApplyBlock(vc ValidationContext, b types.Block) (au ApplyUpdate) {
 	return
 }
 
-// GenesisUpdate returns the ApplyUpdate for the genesis block b.
+// GenesisUpdate returns the ApplyUpdate for the genesis block b of a chain
+// using the mainnet parameters. The ID of b is used as the chain ID. It is
+// equivalent to MainnetNetwork.GenesisUpdate(b, initialDifficulty).
+//
+//core:consensus
 func GenesisUpdate(b types.Block, initialDifficulty types.Work) ApplyUpdate {
-	return ApplyBlock(ValidationContext{
-		Difficulty:       initialDifficulty,
-		GenesisTimestamp: b.Header.Timestamp,
-	}, b)
+	return MainnetNetwork.GenesisUpdate(b, initialDifficulty)
 }
 
 // A RevertUpdate reflects the changes to consensus state resulting from the
@@ -364,6 +412,8 @@ type RevertUpdate struct {
 	NewSiacoinElements    []types.SiacoinElement
 	NewSiafundElements    []types.SiafundElement
 	NewFileContracts      []types.FileContractElement
+	// Attestations are the attestations in the reverted block, in order.
+	Attestations []types.Attestation
 }
 
 // SiacoinElementWasRemoved returns true if the specified SiacoinElement was
@@ -386,6 +436,8 @@ func (ru *RevertUpdate) FileContractElementWasRemoved(fce types.FileContractElem
 
 // RevertBlock produces a RevertUpdate from a block and the ValidationContext
 // prior to that block.
+//
+//core:consensus
 func RevertBlock(vc ValidationContext, b types.Block) (ru RevertUpdate) {
 	if b.Header.Height == 0 {
 		panic("consensus: cannot revert genesis block")
@@ -393,11 +445,62 @@ func RevertBlock(vc ValidationContext, b types.Block) (ru RevertUpdate) {
 		panic("consensus: cannot revert non-child block")
 	}
 
+	m := vc.Metrics
+	var start time.Time
+	if m != nil {
+		start = time.Now()
+	}
 	ru.Context = vc
 	ru.HistoryRevertUpdate = ru.Context.History.RevertBlock(b.Index())
 	var updated []merkle.ElementLeaf
 	ru.SpentSiacoins, ru.SpentSiafunds, ru.RevisedFileContracts, ru.ResolvedFileContracts, updated = updatedInBlock(vc, b, false)
 	ru.NewSiacoinElements, ru.NewSiafundElements, ru.NewFileContracts = createdInBlock(vc, b)
+	ru.Attestations = blockAttestations(b)
 	ru.ElementRevertUpdate = ru.Context.State.RevertBlock(updated)
+	if m != nil {
+		m.AccumulatorUpdated(time.Since(start))
+	}
 	return
 }
+
+func writeElements(sb *strings.Builder, heading string, n int, elem func(int) interface{}) {
+	if n == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "  %v:\n", heading)
+	for i := 0; i < n; i++ {
+		s := strings.TrimSuffix(types.Sprint(elem(i)), "\n")
+		sb.WriteString("    " + strings.ReplaceAll(s, "\n", "\n    ") + "\n")
+	}
+}
+
+// The ApplyUpdate and RevertUpdate types have identical element fields, so
+// they share a String implementation.
+func sprintUpdate(heading string, sces []types.SiacoinElement, sfes []types.SiafundElement, revised, resolved []types.FileContractElement, newSCEs []types.SiacoinElement, newSFEs []types.SiafundElement, newFCEs []types.FileContractElement) string {
+	var sb strings.Builder
+	sb.WriteString(heading + "\n")
+	writeElements(&sb, "spent siacoins", len(sces), func(i int) interface{} { return sces[i] })
+	writeElements(&sb, "spent siafunds", len(sfes), func(i int) interface{} { return sfes[i] })
+	writeElements(&sb, "revised file contracts", len(revised), func(i int) interface{} { return revised[i] })
+	writeElements(&sb, "resolved file contracts", len(resolved), func(i int) interface{} { return resolved[i] })
+	writeElements(&sb, "new siacoins", len(newSCEs), func(i int) interface{} { return newSCEs[i] })
+	writeElements(&sb, "new siafunds", len(newSFEs), func(i int) interface{} { return newSFEs[i] })
+	writeElements(&sb, "new file contracts", len(newFCEs), func(i int) interface{} { return newFCEs[i] })
+	return sb.String()
+}
+
+// String implements fmt.Stringer, returning a readable, multi-line dump of
+// the update's elements. See types.Sprint.
+func (au ApplyUpdate) String() string {
+	return sprintUpdate(fmt.Sprintf("apply update (now at %v)", au.Context.Index),
+		au.SpentSiacoins, au.SpentSiafunds, au.RevisedFileContracts, au.ResolvedFileContracts,
+		au.NewSiacoinElements, au.NewSiafundElements, au.NewFileContracts)
+}
+
+// String implements fmt.Stringer, returning a readable, multi-line dump of
+// the update's elements. See types.Sprint.
+func (ru RevertUpdate) String() string {
+	return sprintUpdate(fmt.Sprintf("revert update (now at %v)", ru.Context.Index),
+		ru.SpentSiacoins, ru.SpentSiafunds, ru.RevisedFileContracts, ru.ResolvedFileContracts,
+		ru.NewSiacoinElements, ru.NewSiafundElements, ru.NewFileContracts)
+}
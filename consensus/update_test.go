@@ -268,6 +268,40 @@ func TestRevertBlock(t *testing.T) {
 	}
 }
 
+func TestAttestationUpdates(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs()
+	sau := GenesisUpdate(genesis, testingDifficulty)
+	vc := sau.Context
+
+	announce := func(addr string) types.Attestation {
+		a := types.Attestation{
+			PublicKey: pubkey,
+			Key:       types.HostAnnouncementKey,
+			Value:     []byte(addr),
+		}
+		a.Signature = privkey.SignHash(vc.AttestationSigHash(a))
+		return a
+	}
+	b := types.Block{
+		Header: types.BlockHeader{Height: 1, ParentID: genesis.ID()},
+		Transactions: []types.Transaction{
+			{Attestations: []types.Attestation{announce("foo.com:9982")}},
+			{},
+			{Attestations: []types.Attestation{announce("bar.com:9982"), announce("baz.com:9982")}},
+		},
+	}
+	var exp []types.Attestation
+	for _, txn := range b.Transactions {
+		exp = append(exp, txn.Attestations...)
+	}
+	if au := ApplyBlock(vc, b); !reflect.DeepEqual(au.Attestations, exp) {
+		t.Fatal("wrong attestations in ApplyUpdate:", au.Attestations)
+	} else if ru := RevertBlock(vc, b); !reflect.DeepEqual(ru.Attestations, exp) {
+		t.Fatal("wrong attestations in RevertUpdate:", ru.Attestations)
+	}
+}
+
 func TestSiafunds(t *testing.T) {
 	pubkey, privkey := testingKeypair(0)
 	b := types.Block{
@@ -1,4 +1,12 @@
 // Package consensus implements the Sia consensus algorithms.
+//
+// When built with the paranoid tag (go test -tags paranoid), ApplyBlock
+// additionally asserts that each block survives an encoding round-trip with its
+// IDs and commitment unchanged, that the accumulator grows by exactly the number
+// of new leaves, and that the difficulty adjustment stays within its clamp;
+// likewise, ValidateBlock asserts that each transaction it accepts conserves
+// its outputs. Failed assertions panic. This is intended for development and
+// fuzzing; it adds significant overhead.
 package consensus
 
 import (
@@ -10,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"go.sia.tech/core/internal/assert"
 	"go.sia.tech/core/merkle"
 	"go.sia.tech/core/types"
 )
@@ -23,6 +32,10 @@ const (
 
 	// NonceFactor is the factor by which all block nonces must be divisible.
 	NonceFactor = 1009
+
+	// APIVersion is incremented whenever the signature of a consensus-critical
+	// declaration (one annotated with //core:consensus) changes.
+	APIVersion = 3
 )
 
 var (
@@ -45,8 +58,14 @@ var (
 var hasherPool = &sync.Pool{New: func() interface{} { return types.NewHasher() }}
 
 // ValidationContext contains the necessary context to fully validate a block.
+//
+//core:consensus
 type ValidationContext struct {
-	Index types.ChainIndex `json:"index"`
+	// ChainID identifies the network. It is mixed into all signature hashes,
+	// preventing signatures from being replayed on other networks.
+	ChainID types.Hash256    `json:"chainID"`
+	Network Network          `json:"network"`
+	Index   types.ChainIndex `json:"index"`
 
 	State          merkle.ElementAccumulator `json:"state"`
 	History        merkle.HistoryAccumulator `json:"history"`
@@ -60,10 +79,21 @@ type ValidationContext struct {
 
 	SiafundPool       types.Currency `json:"siafundPool"`
 	FoundationAddress types.Address  `json:"foundationAddress"`
+
+	// Metrics, if non-nil, receives instrumentation data when validating
+	// transactions and blocks against this context, and from ApplyBlock and
+	// RevertBlock. It is not encoded, and is inherited by child contexts.
+	Metrics Metrics `json:"-"`
+	// Profiler, if non-nil, records the time spent validating and applying
+	// blocks against this context. Like Metrics, it is not encoded, and is
+	// inherited by child contexts.
+	Profiler *Profiler `json:"-"`
 }
 
 // EncodeTo implements types.EncoderTo.
 func (vc ValidationContext) EncodeTo(e *types.Encoder) {
+	vc.ChainID.EncodeTo(e)
+	vc.Network.EncodeTo(e)
 	vc.Index.EncodeTo(e)
 	vc.State.EncodeTo(e)
 	vc.History.EncodeTo(e)
@@ -81,6 +111,8 @@ func (vc ValidationContext) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements types.DecoderFrom.
 func (vc *ValidationContext) DecodeFrom(d *types.Decoder) {
+	vc.ChainID.DecodeFrom(d)
+	vc.Network.DecodeFrom(d)
 	vc.Index.DecodeFrom(d)
 	vc.State.DecodeFrom(d)
 	vc.History.DecodeFrom(d)
@@ -97,6 +129,8 @@ func (vc *ValidationContext) DecodeFrom(d *types.Decoder) {
 }
 
 // BlockReward returns the reward for mining a child block.
+//
+//core:consensus
 func (vc *ValidationContext) BlockReward() types.Currency {
 	const initialCoinbase = 300000
 	const minimumCoinbase = 30000
@@ -118,11 +152,16 @@ func (vc *ValidationContext) BlockReward() types.Currency {
 // any transaction that depend on *those* transactions, and so on). Adding a
 // timelock does not completely eliminate this issue -- after all, reorgs can be
 // arbitrarily deep -- but it does make it highly unlikely to occur in practice.
+// The length of the timelock is given by vc.Network.MaturityDelay.
+//
+//core:consensus
 func (vc *ValidationContext) MaturityHeight() uint64 {
-	return (vc.Index.Height + 1) + 144
+	return (vc.Index.Height + 1) + vc.Network.MaturityDelay
 }
 
 // FoundationSubsidy returns the Foundation subsidy value for the child block.
+//
+//core:consensus
 func (vc *ValidationContext) FoundationSubsidy() types.Currency {
 	foundationSubsidyPerBlock := types.Siacoins(30000)
 	initialfoundationSubsidy := foundationSubsidyPerBlock.Mul64(blocksPerYear)
@@ -137,11 +176,15 @@ func (vc *ValidationContext) FoundationSubsidy() types.Currency {
 }
 
 // MaxBlockWeight is the maximum "weight" of a valid child block.
+//
+//core:consensus
 func (vc *ValidationContext) MaxBlockWeight() uint64 {
 	return 2_000_000
 }
 
 // TransactionWeight computes the weight of a txn.
+//
+//core:consensus
 func (vc *ValidationContext) TransactionWeight(txn types.Transaction) uint64 {
 	storage := types.EncodedLen(txn)
 
@@ -159,6 +202,8 @@ func (vc *ValidationContext) TransactionWeight(txn types.Transaction) uint64 {
 }
 
 // BlockWeight computes the combined weight of a block's txns.
+//
+//core:consensus
 func (vc *ValidationContext) BlockWeight(txns []types.Transaction) uint64 {
 	var weight uint64
 	for _, txn := range txns {
@@ -168,6 +213,8 @@ func (vc *ValidationContext) BlockWeight(txns []types.Transaction) uint64 {
 }
 
 // FileContractTax computes the tax levied on a given contract.
+//
+//core:consensus
 func (vc *ValidationContext) FileContractTax(fc types.FileContract) types.Currency {
 	sum := fc.RenterOutput.Value.Add(fc.HostOutput.Value)
 	tax := sum.Div64(25) // 4%
@@ -179,6 +226,8 @@ func (vc *ValidationContext) FileContractTax(fc types.FileContract) types.Curren
 
 // StorageProofSegmentIndex returns the segment index used when computing or
 // validating a storage proof.
+//
+//core:consensus
 func (vc *ValidationContext) StorageProofSegmentIndex(filesize uint64, windowStart types.ChainIndex, fcid types.ElementID) uint64 {
 	const segmentSize = uint64(len(types.StorageProof{}.DataSegment))
 	if filesize <= segmentSize {
@@ -204,24 +253,56 @@ func (vc *ValidationContext) StorageProofSegmentIndex(filesize uint64, windowSta
 }
 
 // Commitment computes the commitment hash for a child block.
+//
+//core:consensus
 func (vc *ValidationContext) Commitment(minerAddr types.Address, txns []types.Transaction) types.Hash256 {
+	return vc.commitmentFromHash(minerAddr, TransactionsHash(txns))
+}
+
+func (vc *ValidationContext) commitmentFromIDs(minerAddr types.Address, txids []types.TransactionID) types.Hash256 {
+	return vc.commitmentFromHash(minerAddr, transactionsHash(txids))
+}
+
+func transactionsHash(txids []types.TransactionID) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
+	h.E.WritePrefix(len(txids))
+	for _, txid := range txids {
+		txid.EncodeTo(h.E)
+	}
+	return h.Sum()
+}
 
-	// hash the context
-	vc.EncodeTo(h.E)
-	ctxHash := h.Sum()
+// TransactionsHash returns the hash of the IDs of txns, as used in the block
+// commitment.
+//
+//core:consensus
+func TransactionsHash(txns []types.Transaction) types.Hash256 {
+	return transactionsHash(transactionIDs(txns))
+}
 
-	// hash the transactions
-	h.Reset()
-	h.E.WritePrefix(len(txns))
-	for _, txn := range txns {
-		txn.ID().EncodeTo(h.E)
+// transactionIDs computes the IDs of txns. Validating a block requires each ID
+// several times, so they are computed once and passed to each check.
+func transactionIDs(txns []types.Transaction) []types.TransactionID {
+	txids := make([]types.TransactionID, len(txns))
+	for i := range txns {
+		txids[i] = txns[i].ID()
 	}
-	txnsHash := h.Sum()
+	return txids
+}
+
+func (vc *ValidationContext) contextHash() types.Hash256 {
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	vc.EncodeTo(h.E)
+	return h.Sum()
+}
 
-	// concatenate the hashes and the miner address
+func commitmentHash(ctxHash types.Hash256, minerAddr types.Address, txnsHash types.Hash256) types.Hash256 {
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
 	h.Reset()
 	h.E.WriteString("sia/commitment")
 	ctxHash.EncodeTo(h.E)
@@ -230,14 +311,21 @@ func (vc *ValidationContext) Commitment(minerAddr types.Address, txns []types.Tr
 	return h.Sum()
 }
 
+func (vc *ValidationContext) commitmentFromHash(minerAddr types.Address, txnsHash types.Hash256) types.Hash256 {
+	return commitmentHash(vc.contextHash(), minerAddr, txnsHash)
+}
+
 // InputSigHash returns the hash that must be signed for each transaction input.
+//
+//core:consensus
 func (vc *ValidationContext) InputSigHash(txn types.Transaction) types.Hash256 {
 	// NOTE: This currently covers exactly the same fields as txn.ID(), and for
 	// similar reasons.
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
-	h.E.WriteString("sia/sig/transactioninput")
+	h.E.WriteString(types.DomainTransactionInput)
+	vc.ChainID.EncodeTo(h.E)
 	h.E.WritePrefix(len(txn.SiacoinInputs))
 	for _, in := range txn.SiacoinInputs {
 		in.Parent.ID.EncodeTo(h.E)
@@ -254,37 +342,116 @@ func (vc *ValidationContext) InputSigHash(txn types.Transaction) types.Hash256 {
 	for _, out := range txn.SiafundOutputs {
 		out.EncodeTo(h.E)
 	}
-	h.E.WritePrefix(len(txn.FileContracts))
+	writeSigHashContracts(h.E, txn)
+	txn.MinerFee.EncodeTo(h.E)
+	return h.Sum()
+}
+
+// writeSigHashContracts writes the fields following the inputs and outputs in
+// the input sighash, excluding the miner fee.
+func writeSigHashContracts(e *types.Encoder, txn types.Transaction) {
+	e.WritePrefix(len(txn.FileContracts))
 	for _, fc := range txn.FileContracts {
-		fc.EncodeTo(h.E)
+		fc.EncodeTo(e)
 	}
-	h.E.WritePrefix(len(txn.FileContractRevisions))
+	e.WritePrefix(len(txn.FileContractRevisions))
 	for _, fcr := range txn.FileContractRevisions {
-		fcr.Parent.ID.EncodeTo(h.E)
-		fcr.Revision.EncodeTo(h.E)
+		fcr.Parent.ID.EncodeTo(e)
+		fcr.Revision.EncodeTo(e)
 	}
-	h.E.WritePrefix(len(txn.FileContractResolutions))
+	e.WritePrefix(len(txn.FileContractResolutions))
 	for _, fcr := range txn.FileContractResolutions {
-		fcr.Parent.ID.EncodeTo(h.E)
-		fcr.Renewal.EncodeTo(h.E)
-		fcr.StorageProof.WindowStart.EncodeTo(h.E)
-		fcr.Finalization.EncodeTo(h.E)
+		fcr.Parent.ID.EncodeTo(e)
+		fcr.Renewal.EncodeTo(e)
+		fcr.StorageProof.WindowStart.EncodeTo(e)
+		fcr.Finalization.EncodeTo(e)
 	}
 	for _, a := range txn.Attestations {
-		a.EncodeTo(h.E)
+		a.EncodeTo(e)
+	}
+	e.WriteBytes(txn.ArbitraryData)
+	txn.NewFoundationAddress.EncodeTo(e)
+}
+
+// SiacoinInputSigHash returns the hash that must be signed for the siacoin
+// input at index i of txn, according to the input's SigHash flags. If the
+// flags are SigHashAll, this is the same as InputSigHash.
+//
+//core:consensus
+func (vc *ValidationContext) SiacoinInputSigHash(txn types.Transaction, i int) types.Hash256 {
+	return vc.partialSigHash(txn, txn.SiacoinInputs[i].SigHash, false, i)
+}
+
+// SiafundInputSigHash returns the hash that must be signed for the siafund
+// input at index i of txn, according to the input's SigHash flags. If the
+// flags are SigHashAll, this is the same as InputSigHash.
+//
+//core:consensus
+func (vc *ValidationContext) SiafundInputSigHash(txn types.Transaction, i int) types.Hash256 {
+	return vc.partialSigHash(txn, txn.SiafundInputs[i].SigHash, true, i)
+}
+
+func (vc *ValidationContext) partialSigHash(txn types.Transaction, flags types.SigHashFlags, siafund bool, i int) types.Hash256 {
+	if flags == types.SigHashAll {
+		return vc.InputSigHash(txn)
+	}
+	h := hasherPool.Get().(*types.Hasher)
+	defer hasherPool.Put(h)
+	h.Reset()
+	h.E.WriteString(types.DomainTransactionInputPartial)
+	vc.ChainID.EncodeTo(h.E)
+	h.E.WriteUint8(uint8(flags))
+	if flags&types.SigHashAnyoneCanPay != 0 {
+		h.E.WriteBool(siafund)
+		if siafund {
+			txn.SiafundInputs[i].Parent.ID.EncodeTo(h.E)
+		} else {
+			txn.SiacoinInputs[i].Parent.ID.EncodeTo(h.E)
+		}
+	} else {
+		h.E.WritePrefix(len(txn.SiacoinInputs))
+		for _, in := range txn.SiacoinInputs {
+			in.Parent.ID.EncodeTo(h.E)
+		}
+		h.E.WritePrefix(len(txn.SiafundInputs))
+		for _, in := range txn.SiafundInputs {
+			in.Parent.ID.EncodeTo(h.E)
+		}
+	}
+	if flags&types.SigHashSingle != 0 {
+		// NOTE: validation rejects inputs without a corresponding output
+		h.E.WriteBool(siafund)
+		if siafund && i < len(txn.SiafundOutputs) {
+			txn.SiafundOutputs[i].EncodeTo(h.E)
+		} else if !siafund && i < len(txn.SiacoinOutputs) {
+			txn.SiacoinOutputs[i].EncodeTo(h.E)
+		}
+	} else {
+		h.E.WritePrefix(len(txn.SiacoinOutputs))
+		for _, out := range txn.SiacoinOutputs {
+			out.EncodeTo(h.E)
+		}
+		h.E.WritePrefix(len(txn.SiafundOutputs))
+		for _, out := range txn.SiafundOutputs {
+			out.EncodeTo(h.E)
+		}
+	}
+	writeSigHashContracts(h.E, txn)
+	if flags&types.SigHashAnyoneCanPay == 0 {
+		txn.MinerFee.EncodeTo(h.E)
 	}
-	h.E.WriteBytes(txn.ArbitraryData)
-	txn.NewFoundationAddress.EncodeTo(h.E)
-	txn.MinerFee.EncodeTo(h.E)
 	return h.Sum()
 }
 
 // ContractSigHash returns the hash that must be signed for a file contract revision.
+//
+//core:consensus
 func (vc *ValidationContext) ContractSigHash(fc types.FileContract) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
-	h.E.WriteString("sia/sig/filecontract")
+	h.E.WriteString(types.DomainFileContract)
+	vc.ChainID.EncodeTo(h.E)
 	h.E.WriteUint64(fc.Filesize)
 	fc.FileMerkleRoot.EncodeTo(h.E)
 	h.E.WriteUint64(fc.WindowStart)
@@ -299,11 +466,14 @@ func (vc *ValidationContext) ContractSigHash(fc types.FileContract) types.Hash25
 }
 
 // RenewalSigHash returns the hash that must be signed for a file contract renewal.
+//
+//core:consensus
 func (vc *ValidationContext) RenewalSigHash(fcr types.FileContractRenewal) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
-	h.E.WriteString("sia/sig/filecontractrenewal")
+	h.E.WriteString(types.DomainFileContractRenewal)
+	vc.ChainID.EncodeTo(h.E)
 	fcr.FinalRevision.EncodeTo(h.E)
 	fcr.InitialRevision.EncodeTo(h.E)
 	fcr.RenterRollover.EncodeTo(h.E)
@@ -312,17 +482,76 @@ func (vc *ValidationContext) RenewalSigHash(fcr types.FileContractRenewal) types
 }
 
 // AttestationSigHash returns the hash that must be signed for an attestation.
+//
+//core:consensus
 func (vc *ValidationContext) AttestationSigHash(a types.Attestation) types.Hash256 {
 	h := hasherPool.Get().(*types.Hasher)
 	defer hasherPool.Put(h)
 	h.Reset()
-	h.E.WriteString("sia/sig/attestation")
+	h.E.WriteString(types.DomainAttestation)
+	vc.ChainID.EncodeTo(h.E)
 	a.PublicKey.EncodeTo(h.E)
 	h.E.WriteString(a.Key)
 	h.E.WriteBytes(a.Value)
 	return h.Sum()
 }
 
+// TipTimestamp returns the timestamp of the block at vc.Index.
+func (vc *ValidationContext) TipTimestamp() time.Time {
+	return vc.PrevTimestamps[vc.numTimestamps()-1]
+}
+
+// AverageBlockTime returns the average time between the most recent blocks, or
+// BlockInterval if there are too few blocks to compute an average.
+func (vc *ValidationContext) AverageBlockTime() time.Duration {
+	n := vc.numTimestamps()
+	if n < 2 {
+		return BlockInterval
+	}
+	avg := vc.PrevTimestamps[n-1].Sub(vc.PrevTimestamps[0]) / time.Duration(n-1)
+	if avg <= 0 {
+		return BlockInterval
+	}
+	return avg
+}
+
+// medianHeight2 returns twice the height of the block whose timestamp is
+// (approximately) the median timestamp. It is doubled because, with an even
+// number of timestamps, the median falls halfway between two blocks.
+func (vc *ValidationContext) medianHeight2() int64 {
+	return int64(2*vc.Index.Height) - int64(vc.numTimestamps()-1)
+}
+
+// EstimatedTime returns the estimated wall-clock time of the block at the given
+// height. The estimate is anchored to the median timestamp of recent blocks
+// rather than the tip, which miners can skew, and assumes that blocks are
+// found every BlockInterval. Since it depends only on chain data, all
+// implementations compute the same estimate.
+func (vc *ValidationContext) EstimatedTime(height uint64) time.Time {
+	return vc.medianTimestamp().Add(time.Duration(int64(2*height)-vc.medianHeight2()) * BlockInterval / 2)
+}
+
+// EstimatedHeight returns the estimated height of the block at the given
+// wall-clock time. It is the inverse of EstimatedTime.
+func (vc *ValidationContext) EstimatedHeight(t time.Time) uint64 {
+	// estimated time elapsed since height 0
+	elapsed := time.Duration(vc.medianHeight2())*BlockInterval/2 + t.Sub(vc.medianTimestamp())
+	if elapsed < 0 {
+		return 0
+	}
+	return uint64(elapsed / BlockInterval)
+}
+
+// BlocksInDuration returns the number of blocks expected to be found in d,
+// rounded up. For example, a contract lasting 13 weeks should span
+// BlocksInDuration(13 * 7 * 24 * time.Hour) blocks.
+func BlocksInDuration(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64((d + BlockInterval - 1) / BlockInterval)
+}
+
 func (vc *ValidationContext) numTimestamps() int {
 	if vc.Index.Height+1 < uint64(len(vc.PrevTimestamps)) {
 		return int(vc.Index.Height + 1)
@@ -446,6 +675,8 @@ func (vc *ValidationContext) validateContract(fc types.FileContract) error {
 		return fmt.Errorf("has proof window (%v-%v) that ends in the past", fc.WindowStart, fc.WindowEnd)
 	case fc.WindowEnd <= fc.WindowStart:
 		return fmt.Errorf("has proof window (%v-%v) that ends before it begins", fc.WindowStart, fc.WindowEnd)
+	case fc.WindowEnd-fc.WindowStart < vc.Network.MinProofWindow:
+		return fmt.Errorf("has proof window (%v-%v) shorter than minimum of %v blocks", fc.WindowStart, fc.WindowEnd, vc.Network.MinProofWindow)
 	case fc.MissedHostValue.Cmp(fc.HostOutput.Value) > 0:
 		return fmt.Errorf("has missed host value (%v SC) exceeding valid host value (%v SC)", fc.MissedHostValue, fc.HostOutput.Value)
 	case fc.TotalCollateral.Cmp(fc.HostOutput.Value) > 0:
@@ -474,6 +705,8 @@ func (vc *ValidationContext) validateRevision(cur, rev types.FileContract) error
 		return fmt.Errorf("has proof window (%v-%v) that ends in the past", rev.WindowStart, rev.WindowEnd)
 	case rev.WindowEnd <= rev.WindowStart:
 		return fmt.Errorf("has proof window (%v - %v) that ends before it begins", rev.WindowStart, rev.WindowEnd)
+	case rev.WindowEnd-rev.WindowStart < vc.Network.MinProofWindow:
+		return fmt.Errorf("has proof window (%v - %v) shorter than minimum of %v blocks", rev.WindowStart, rev.WindowEnd, vc.Network.MinProofWindow)
 	}
 
 	// verify signatures
@@ -697,8 +930,8 @@ func (vc *ValidationContext) validFoundationUpdate(txn types.Transaction) error
 }
 
 func (vc *ValidationContext) validSpendPolicies(txn types.Transaction) error {
-	sigHash := vc.InputSigHash(txn)
-	verifyPolicy := func(p types.SpendPolicy, sigs []types.Signature) error {
+	var fullSigHash *types.Hash256 // computed on first use
+	verifyPolicy := func(p types.SpendPolicy, sigHash types.Hash256, sigs []types.Signature, preimages [][32]byte) error {
 		var verify func(types.SpendPolicy) error
 		verify = func(p types.SpendPolicy) error {
 			switch p := p.(type) {
@@ -715,6 +948,16 @@ func (vc *ValidationContext) validSpendPolicies(txn types.Transaction) error {
 					}
 				}
 				return errors.New("no signatures matching pubkey")
+			case types.PolicyHash:
+				for i := range preimages {
+					if types.HashBytes(preimages[i][:]) == types.Hash256(p) {
+						preimages = preimages[i+1:]
+						return nil
+					}
+				}
+				return errors.New("no preimage matching hash")
+			case types.PolicyOpaque:
+				return errors.New("opaque policy cannot be satisfied")
 			case types.PolicyThreshold:
 				for i := 0; i < len(p.Of) && p.N > 0 && len(p.Of[i:]) >= int(p.N); i++ {
 					if verify(p.Of[i]) == nil {
@@ -743,17 +986,40 @@ func (vc *ValidationContext) validSpendPolicies(txn types.Transaction) error {
 		return verify(p)
 	}
 
+	validFlags := func(flags types.SigHashFlags, i, numOutputs int) error {
+		if !flags.Valid() {
+			return fmt.Errorf("invalid sighash flags (%v)", flags)
+		} else if flags&types.SigHashSingle != 0 && i >= numOutputs {
+			return errors.New("SigHashSingle requires a corresponding output")
+		}
+		return nil
+	}
+	sigHash := func(flags types.SigHashFlags, siafund bool, i int) types.Hash256 {
+		if flags == types.SigHashAll {
+			if fullSigHash == nil {
+				h := vc.InputSigHash(txn)
+				fullSigHash = &h
+			}
+			return *fullSigHash
+		}
+		return vc.partialSigHash(txn, flags, siafund, i)
+	}
+
 	for i, in := range txn.SiacoinInputs {
 		if types.PolicyAddress(in.SpendPolicy) != in.Parent.Address {
 			return fmt.Errorf("siacoin input %v claims incorrect policy for parent address", i)
-		} else if err := verifyPolicy(in.SpendPolicy, in.Signatures); err != nil {
+		} else if err := validFlags(in.SigHash, i, len(txn.SiacoinOutputs)); err != nil {
+			return fmt.Errorf("siacoin input %v: %w", i, err)
+		} else if err := verifyPolicy(in.SpendPolicy, sigHash(in.SigHash, false, i), in.Signatures, in.Preimages); err != nil {
 			return fmt.Errorf("siacoin input %v failed to satisfy spend policy: %w", i, err)
 		}
 	}
 	for i, in := range txn.SiafundInputs {
 		if types.PolicyAddress(in.SpendPolicy) != in.Parent.Address {
 			return fmt.Errorf("siafund input %v claims incorrect policy for parent address", i)
-		} else if err := verifyPolicy(in.SpendPolicy, in.Signatures); err != nil {
+		} else if err := validFlags(in.SigHash, i, len(txn.SiafundOutputs)); err != nil {
+			return fmt.Errorf("siafund input %v: %w", i, err)
+		} else if err := verifyPolicy(in.SpendPolicy, sigHash(in.SigHash, true, i), in.Signatures, in.Preimages); err != nil {
 			return fmt.Errorf("siafund input %v failed to satisfy spend policy: %w", i, err)
 		}
 	}
@@ -762,14 +1028,37 @@ func (vc *ValidationContext) validSpendPolicies(txn types.Transaction) error {
 
 // ValidateTransaction partially validates txn for inclusion in a child block.
 // It does not validate ephemeral outputs.
+//
+//core:consensus
 func (vc *ValidationContext) ValidateTransaction(txn types.Transaction) error {
+	return vc.validateTransaction(txn, nil)
+}
+
+func (vc *ValidationContext) validateTransaction(txn types.Transaction, p *BlockProfile) error {
+	m := vc.Metrics
+	timed := m != nil || p != nil
+
 	// check proofs first; that way, subsequent checks can assume that all
 	// parent StateElements are valid
+	var start time.Time
+	if timed {
+		start = time.Now()
+	}
 	if err := vc.validStateProofs(txn); err != nil {
 		return err
 	} else if err := vc.validHistoryProofs(txn); err != nil {
 		return err
 	}
+	if timed {
+		d := time.Since(start)
+		if m != nil {
+			m.ProofsVerified(numProofs(txn), d)
+		}
+		if p != nil {
+			p.Proofs += d
+		}
+		start = time.Now()
+	}
 
 	if err := vc.validCurrencyValues(txn); err != nil {
 		return err
@@ -787,13 +1076,60 @@ func (vc *ValidationContext) ValidateTransaction(txn types.Transaction) error {
 		return err
 	} else if err := vc.validAttestations(txn); err != nil {
 		return err
-	} else if err := vc.validSpendPolicies(txn); err != nil {
+	}
+
+	if timed {
+		if p != nil {
+			p.Other += time.Since(start)
+		}
+		start = time.Now()
+	}
+	if err := vc.validSpendPolicies(txn); err != nil {
 		return err
 	}
+	if timed {
+		d := time.Since(start)
+		if m != nil {
+			m.SignaturesVerified(numSignatures(txn), d)
+		}
+		if p != nil {
+			p.Signatures += d
+		}
+	}
 	return nil
 }
 
-func (vc *ValidationContext) validEphemeralOutputs(txns []types.Transaction) error {
+func numProofs(txn types.Transaction) (n int) {
+	for _, in := range txn.SiacoinInputs {
+		if in.Parent.LeafIndex != types.EphemeralLeafIndex {
+			n++
+		}
+	}
+	n += len(txn.SiafundInputs)
+	n += len(txn.FileContractRevisions)
+	for _, fcr := range txn.FileContractResolutions {
+		n++
+		if fcr.HasStorageProof() {
+			n++
+		}
+	}
+	return
+}
+
+func numSignatures(txn types.Transaction) (n int) {
+	for _, in := range txn.SiacoinInputs {
+		n += len(in.Signatures)
+	}
+	for _, in := range txn.SiafundInputs {
+		n += len(in.Signatures)
+	}
+	return
+}
+
+// validEphemeralOutputs checks that each ephemeral output spent in txns is
+// created earlier in txns. If txids is nil, the IDs of txns are computed as
+// needed.
+func (vc *ValidationContext) validEphemeralOutputs(txns []types.Transaction, txids []types.TransactionID) error {
 	// skip this check if no ephemeral outputs are present
 	for _, txn := range txns {
 		for _, in := range txn.SiacoinInputs {
@@ -805,9 +1141,12 @@ func (vc *ValidationContext) validEphemeralOutputs(txns []types.Transaction) err
 	return nil
 
 validate:
+	if txids == nil {
+		txids = transactionIDs(txns)
+	}
 	available := make(map[types.ElementID]types.SiacoinOutput)
 	for txnIndex, txn := range txns {
-		txid := txn.ID()
+		txid := txids[txnIndex]
 		var index uint64
 		nextID := func() types.ElementID {
 			id := types.ElementID{
@@ -876,34 +1215,82 @@ func (vc *ValidationContext) noDoubleContractUpdates(txns []types.Transaction) e
 }
 
 // ValidateTransactionSet validates txns in their corresponding validation context.
+//
+//core:consensus
 func (vc *ValidationContext) ValidateTransactionSet(txns []types.Transaction) error {
+	return vc.validateTransactionSet(txns, nil, nil)
+}
+
+func (vc *ValidationContext) validateTransactionSet(txns []types.Transaction, txids []types.TransactionID, p *BlockProfile) error {
+	var start time.Time
+	if p != nil {
+		start = time.Now()
+	}
 	if vc.BlockWeight(txns) > vc.MaxBlockWeight() {
 		return ErrOverweight
-	} else if err := vc.validEphemeralOutputs(txns); err != nil {
+	} else if err := vc.validEphemeralOutputs(txns, txids); err != nil {
 		return err
 	} else if err := vc.noDoubleSpends(txns); err != nil {
 		return err
 	} else if err := vc.noDoubleContractUpdates(txns); err != nil {
 		return err
 	}
+	if p != nil {
+		p.Other += time.Since(start)
+	}
 	for i, txn := range txns {
-		if err := vc.ValidateTransaction(txn); err != nil {
+		if err := vc.validateTransaction(txn, p); err != nil {
 			return fmt.Errorf("transaction %v is invalid: %w", i, err)
 		}
 	}
 	return nil
 }
 
-// ValidateBlock validates b in the context of vc.
+// ValidateBlock validates b in the context of vc. If vc has a Profiler, the time
+// spent in each phase of validation is recorded.
+//
+//core:consensus
 func (vc *ValidationContext) ValidateBlock(b types.Block) error {
+	if vc.Profiler == nil {
+		return vc.validateBlock(b, nil)
+	}
+	var p BlockProfile
+	if err := vc.validateBlock(b, &p); err != nil {
+		return err
+	}
+	vc.Profiler.storeValidationProfile(b.ID(), p)
+	return nil
+}
+
+func (vc *ValidationContext) validateBlock(b types.Block, p *BlockProfile) error {
+	var start time.Time
+	if p != nil {
+		start = time.Now()
+	}
 	h := b.Header
 	if err := vc.validateHeader(h); err != nil {
 		return err
-	} else if vc.Commitment(h.MinerAddress, b.Transactions) != h.Commitment {
+	}
+	if p != nil {
+		p.Header = time.Since(start)
+		start = time.Now()
+	}
+	txids := transactionIDs(b.Transactions)
+	if vc.commitmentFromIDs(h.MinerAddress, txids) != h.Commitment {
 		return errors.New("commitment hash does not match header")
-	} else if err := vc.ValidateTransactionSet(b.Transactions); err != nil {
+	}
+	if p != nil {
+		p.Commitment = time.Since(start)
+	}
+	if err := vc.validateTransactionSet(b.Transactions, txids, p); err != nil {
 		return err
 	}
+	if assert.Enabled {
+		for i, txn := range b.Transactions {
+			err := vc.outputsEqualInputs(txn)
+			assert.That(err == nil, "accepted transaction %v in block %v does not conserve outputs: %v", i, b.Index(), err)
+		}
+	}
 	return nil
 }
 
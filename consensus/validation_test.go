@@ -1,6 +1,7 @@
 package consensus
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 	"reflect"
@@ -52,22 +53,58 @@ func TestBlockRewardValue(t *testing.T) {
 		height uint64
 		exp    string
 	}{
-		{0, "300000"},
-		{1, "299999"},
-		{100000, "200000"},
-		{269999, "30001"},
-		{270000, "30000"},
-		{270001, "30000"},
-		{1e6, "30000"},
+		{0, "300000 SC"},
+		{1, "299999 SC"},
+		{100000, "200000 SC"},
+		{269999, "30001 SC"},
+		{270000, "30000 SC"},
+		{270001, "30000 SC"},
+		{1e6, "30000 SC"},
 	}
 	for _, test := range tests {
 		got := reward(test.height)
-		if got.String() != test.exp {
+		if got.FormatUnit("SC") != test.exp {
 			t.Errorf("expected %v, got %v", test.exp, got)
 		}
 	}
 }
 
+func TestEstimatedTime(t *testing.T) {
+	genesis := time.Unix(1e9, 0).UTC()
+	context := func(tip uint64) ValidationContext {
+		vc := ValidationContext{Index: types.ChainIndex{Height: tip}}
+		for i := 0; i < vc.numTimestamps(); i++ {
+			// skew the tip timestamp, which should not affect the estimate
+			height := tip + 1 - uint64(vc.numTimestamps()-i)
+			vc.PrevTimestamps[i] = genesis.Add(time.Duration(height) * BlockInterval)
+		}
+		vc.PrevTimestamps[vc.numTimestamps()-1] = vc.PrevTimestamps[vc.numTimestamps()-1].Add(time.Hour)
+		return vc
+	}
+	for _, tip := range []uint64{2, 3, 10, 1000} {
+		vc := context(tip)
+		for _, height := range []uint64{0, 1, tip, tip + 1, tip + BlocksInDuration(13*7*24*time.Hour)} {
+			est := vc.EstimatedTime(height)
+			if exp := genesis.Add(time.Duration(height) * BlockInterval); !est.Equal(exp) {
+				t.Fatalf("tip %v: expected height %v at %v, got %v", tip, height, exp, est)
+			} else if h := vc.EstimatedHeight(est); h != height {
+				t.Fatalf("tip %v: expected height %v, got %v", tip, height, h)
+			} else if h := vc.EstimatedHeight(est.Add(BlockInterval - time.Second)); h != height {
+				t.Fatalf("tip %v: expected height %v, got %v", tip, height, h)
+			}
+		}
+		if h := vc.EstimatedHeight(genesis.Add(-time.Hour)); h != 0 {
+			t.Fatalf("expected height 0 before genesis, got %v", h)
+		}
+	}
+
+	if n := BlocksInDuration(13 * 7 * 24 * time.Hour); n != 13*7*blocksPerDay {
+		t.Fatalf("expected %v blocks in 13 weeks, got %v", 13*7*blocksPerDay, n)
+	} else if n := BlocksInDuration(BlockInterval + 1); n != 2 {
+		t.Fatalf("expected partial blocks to round up, got %v", n)
+	}
+}
+
 func TestEphemeralOutputs(t *testing.T) {
 	pubkey, privkey := testingKeypair(0)
 	sau := GenesisUpdate(genesisWithSiacoinOutputs(types.SiacoinOutput{
@@ -705,11 +742,35 @@ func TestValidateSpendPolicy(t *testing.T) {
 		return pubkey
 	}
 
+	preimage := [32]byte{1, 2, 3}
+	htlc := types.PolicyThreshold{
+		N: 1,
+		Of: []types.SpendPolicy{
+			// claim with preimage
+			types.PolicyThreshold{
+				N: 2,
+				Of: []types.SpendPolicy{
+					types.PolicyPublicKey(pubkey(0)),
+					types.PolicyHash(types.HashBytes(preimage[:])),
+				},
+			},
+			// refund after timeout
+			types.PolicyThreshold{
+				N: 2,
+				Of: []types.SpendPolicy{
+					types.PolicyPublicKey(pubkey(1)),
+					types.PolicyAbove(150),
+				},
+			},
+		},
+	}
+
 	tests := []struct {
-		desc    string
-		policy  types.SpendPolicy
-		sign    func(sigHash types.Hash256) []types.Signature
-		wantErr bool
+		desc      string
+		policy    types.SpendPolicy
+		sign      func(sigHash types.Hash256) []types.Signature
+		preimages [][32]byte
+		wantErr   bool
 	}{
 		{
 			desc: "not enough signatures",
@@ -908,6 +969,75 @@ func TestValidateSpendPolicy(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			desc:      "valid preimage",
+			policy:    types.PolicyHash(types.HashBytes(preimage[:])),
+			sign:      func(types.Hash256) []types.Signature { return nil },
+			preimages: [][32]byte{preimage},
+			wantErr:   false,
+		},
+		{
+			desc:      "invalid preimage",
+			policy:    types.PolicyHash(types.HashBytes(preimage[:])),
+			sign:      func(types.Hash256) []types.Signature { return nil },
+			preimages: [][32]byte{{4, 5, 6}},
+			wantErr:   true,
+		},
+		{
+			desc:   "htlc claim",
+			policy: htlc,
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return []types.Signature{privkey(0).SignHash(sigHash)}
+			},
+			preimages: [][32]byte{preimage},
+			wantErr:   false,
+		},
+		{
+			desc:   "htlc claim without preimage",
+			policy: htlc,
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return []types.Signature{privkey(0).SignHash(sigHash)}
+			},
+			wantErr: true,
+		},
+		{
+			desc: "htlc claim with hidden refund",
+			policy: types.PolicyThreshold{
+				N: 1,
+				Of: []types.SpendPolicy{
+					htlc.Of[0],
+					types.PolicyOpaque(types.PolicyAddress(htlc.Of[1])),
+				},
+			},
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return []types.Signature{privkey(0).SignHash(sigHash)}
+			},
+			preimages: [][32]byte{preimage},
+			wantErr:   false,
+		},
+		{
+			desc: "htlc claim with hidden claim",
+			policy: types.PolicyThreshold{
+				N: 1,
+				Of: []types.SpendPolicy{
+					types.PolicyOpaque(types.PolicyAddress(htlc.Of[0])),
+					htlc.Of[1],
+				},
+			},
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return []types.Signature{privkey(0).SignHash(sigHash)}
+			},
+			preimages: [][32]byte{preimage},
+			wantErr:   true,
+		},
+		{
+			desc:   "htlc refund before timeout",
+			policy: htlc,
+			sign: func(sigHash types.Hash256) []types.Signature {
+				return []types.Signature{privkey(1).SignHash(sigHash)}
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -923,12 +1053,113 @@ func TestValidateSpendPolicy(t *testing.T) {
 		}
 		sigHash := vc.InputSigHash(txn)
 		txn.SiacoinInputs[0].Signatures = tt.sign(sigHash)
+		txn.SiacoinInputs[0].Preimages = tt.preimages
 		if err := vc.validSpendPolicies(txn); (err != nil) != tt.wantErr {
 			t.Fatalf("case %q failed: %v", tt.desc, err)
 		}
 	}
 }
 
+func TestValidateNestedSpendPolicy(t *testing.T) {
+	vc := ValidationContext{
+		Index: types.ChainIndex{Height: 100},
+	}
+	keys := make([]types.PublicKey, 5)
+	privs := make([]types.PrivateKey, 5)
+	for i := range keys {
+		keys[i], privs[i] = testingKeypair(uint64(i))
+	}
+
+	validate := func(p types.SpendPolicy, signers []int) error {
+		txn := types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{{
+				Parent: types.SiacoinElement{
+					SiacoinOutput: types.SiacoinOutput{
+						Address: types.PolicyAddress(p),
+					},
+				},
+				SpendPolicy: p,
+			}},
+		}
+		sigHash := vc.InputSigHash(txn)
+		for _, i := range signers {
+			txn.SiacoinInputs[0].Signatures = append(txn.SiacoinInputs[0].Signatures, privs[i].SignHash(sigHash))
+		}
+		return vc.validSpendPolicies(txn)
+	}
+
+	// 3-of-5 multisig: every subset of three signers, in key order, should
+	// succeed; every subset of two should fail
+	multisig := types.MultisigPolicy(3, keys...)
+	for a := 0; a < len(keys); a++ {
+		for b := a + 1; b < len(keys); b++ {
+			if err := validate(multisig, []int{a, b}); err == nil {
+				t.Fatalf("2 signatures (%v, %v) should not satisfy 3-of-5", a, b)
+			}
+			for c := b + 1; c < len(keys); c++ {
+				if err := validate(multisig, []int{a, b, c}); err != nil {
+					t.Fatalf("3 signatures (%v, %v, %v) should satisfy 3-of-5: %v", a, b, c, err)
+				}
+			}
+		}
+	}
+	if err := validate(multisig, []int{2, 1, 0}); err == nil {
+		t.Fatal("out-of-order signatures should not satisfy multisig")
+	}
+
+	// deeply nested: each level requires either key i or the next level down
+	policy := types.SpendPolicy(types.PolicyPublicKey(keys[len(keys)-1]))
+	for i := len(keys) - 2; i >= 0; i-- {
+		policy = types.AnyOf(
+			types.AllOf(types.PolicyPublicKey(keys[i]), types.PolicyAbove(50)),
+			policy,
+		)
+	}
+	for i := range keys {
+		if err := validate(policy, []int{i}); err != nil {
+			t.Fatalf("key %v should satisfy nested policy: %v", i, err)
+		}
+	}
+	if sigs, _, ok := types.PolicyCost(policy); !ok || sigs != 1 {
+		t.Fatal("wrong cost for nested policy:", sigs, ok)
+	}
+
+	// nesting multisigs: 2-of-(2-of-3, 1-of-2, pk)
+	nested := types.PolicyThreshold{
+		N: 2,
+		Of: []types.SpendPolicy{
+			types.MultisigPolicy(2, keys[0], keys[1], keys[2]),
+			types.MultisigPolicy(1, keys[3], keys[4]),
+			types.PolicyPublicKey(keys[0]),
+		},
+	}
+	tests := []struct {
+		signers []int
+		valid   bool
+	}{
+		{[]int{0, 1, 3}, true},
+		{[]int{1, 2, 4}, true},
+		{[]int{0, 1, 0}, true},
+		{[]int{0, 1}, false},
+		{[]int{3, 4}, false},
+		// signatures are consumed greedily, in policy order: the first
+		// multisig consumes key 0's signature, leaving none for the third
+		// branch
+		{[]int{0, 4}, false},
+		// ...and when searching for key 0's signature, the first multisig
+		// skips past key 4's
+		{[]int{4, 0}, false},
+	}
+	for _, tt := range tests {
+		if err := validate(nested, tt.signers); (err == nil) != tt.valid {
+			t.Errorf("signers %v: expected valid = %v, got %v", tt.signers, tt.valid, err)
+		}
+	}
+	if sigs, _, ok := types.PolicyCost(nested); !ok || sigs != 2 {
+		t.Fatal("wrong cost for nested multisig:", sigs, ok)
+	}
+}
+
 func TestValidateTransactionSet(t *testing.T) {
 	pubkey, privkey := testingKeypair(0)
 	genesisBlock := genesisWithSiacoinOutputs(types.SiacoinOutput{
@@ -1372,3 +1603,168 @@ func TestNoDoubleContractUpdates(t *testing.T) {
 		}
 	}
 }
+
+func TestReplayProtection(t *testing.T) {
+	pubkey, privkey := testingKeypair(0)
+	genesis := genesisWithSiacoinOutputs(types.SiacoinOutput{
+		Address: types.StandardAddress(pubkey),
+		Value:   types.Siacoins(1),
+	})
+	sau := GenesisUpdate(genesis, testingDifficulty)
+	if sau.Context.ChainID != types.Hash256(genesis.ID()) {
+		t.Fatal("chain ID should default to genesis ID")
+	}
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sau.NewSiacoinElements[1],
+			SpendPolicy: types.PolicyPublicKey(pubkey),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   types.Siacoins(1),
+		}},
+	}
+
+	// sign the transaction for a different network
+	otherNetwork := sau.Context
+	otherNetwork.ChainID = types.HashBytes([]byte("testnet"))
+	signAllInputs(&txn, otherNetwork, privkey)
+	if err := otherNetwork.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	} else if err := sau.Context.ValidateTransaction(txn); err == nil {
+		t.Fatal("transaction signed for another network should be rejected")
+	}
+}
+
+func TestNetworkParameters(t *testing.T) {
+	testnet := Network{MaturityDelay: 2, MinProofWindow: 10}
+	genesis := genesisWithSiacoinOutputs()
+	mainnetVC := GenesisUpdate(genesis, testingDifficulty).Context
+	sau := testnet.GenesisUpdate(genesis, testingDifficulty)
+	vc := sau.Context
+	if vc.Network != testnet {
+		t.Fatal("network parameters not set by genesis")
+	} else if sau.NewSiacoinElements[0].MaturityHeight != 1+testnet.MaturityDelay {
+		t.Fatal("wrong maturity height for genesis block reward:", sau.NewSiacoinElements[0].MaturityHeight)
+	} else if vc.MaturityHeight() != vc.Index.Height+1+testnet.MaturityDelay {
+		t.Fatal("wrong maturity height:", vc.MaturityHeight())
+	} else if mainnetVC.MaturityHeight() != mainnetVC.Index.Height+1+MainnetNetwork.MaturityDelay {
+		t.Fatal("wrong mainnet maturity height:", mainnetVC.MaturityHeight())
+	}
+
+	// parameters should survive encoding
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	vc.EncodeTo(e)
+	e.Flush()
+	var vc2 ValidationContext
+	d := types.NewBufDecoder(buf.Bytes())
+	vc2.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if vc2.Network != testnet {
+		t.Fatal("network parameters did not survive encoding")
+	}
+
+	renterPubkey, renterPrivkey := testingKeypair(0)
+	hostPubkey, hostPrivkey := testingKeypair(1)
+	signedContract := func(vc ValidationContext, windowStart, windowEnd uint64) types.FileContract {
+		fc := types.FileContract{
+			WindowStart:     windowStart,
+			WindowEnd:       windowEnd,
+			RenterPublicKey: renterPubkey,
+			HostPublicKey:   hostPubkey,
+		}
+		sigHash := vc.ContractSigHash(fc)
+		fc.RenterSignature = renterPrivkey.SignHash(sigHash)
+		fc.HostSignature = hostPrivkey.SignHash(sigHash)
+		return fc
+	}
+	if err := mainnetVC.validateContract(signedContract(mainnetVC, 5, 10)); err != nil {
+		t.Fatal("short proof window should be valid on mainnet:", err)
+	} else if err := vc.validateContract(signedContract(vc, 5, 10)); err == nil || !strings.Contains(err.Error(), "shorter than minimum") {
+		t.Fatal("expected short proof window to be rejected, got", err)
+	} else if err := vc.validateContract(signedContract(vc, 5, 15)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSigHashFlags(t *testing.T) {
+	alice, alicePriv := testingKeypair(1)
+	bob, bobPriv := testingKeypair(2)
+	carol, carolPriv := testingKeypair(3)
+	sau := GenesisUpdate(genesisWithSiacoinOutputs(
+		types.SiacoinOutput{Address: types.StandardAddress(alice), Value: types.Siacoins(5)},
+		types.SiacoinOutput{Address: types.StandardAddress(bob), Value: types.Siacoins(7)},
+		types.SiacoinOutput{Address: types.StandardAddress(carol), Value: types.Siacoins(1)},
+	), testingDifficulty)
+	vc := sau.Context
+	input := func(i int, pk types.PublicKey, flags types.SigHashFlags) types.SiacoinInput {
+		return types.SiacoinInput{
+			Parent:      sau.NewSiacoinElements[i],
+			SpendPolicy: types.PolicyPublicKey(pk),
+			SigHash:     flags,
+		}
+	}
+	sign := func(txn *types.Transaction, i int, priv types.PrivateKey) {
+		txn.SiacoinInputs[i].Signatures = []types.Signature{priv.SignHash(vc.SiacoinInputSigHash(*txn, i))}
+	}
+	const flags = types.SigHashAnyoneCanPay | types.SigHashSingle
+
+	// alice and bob each contribute an input and output, without seeing each
+	// other's; then carol bumps the fee
+	txn := types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input(1, alice, flags)},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.StandardAddress(alice), Value: types.Siacoins(5)}},
+	}
+	sign(&txn, 0, alicePriv)
+	if err := vc.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+	txn.SiacoinInputs = append(txn.SiacoinInputs, input(2, bob, flags))
+	txn.SiacoinOutputs = append(txn.SiacoinOutputs, types.SiacoinOutput{Address: types.StandardAddress(bob), Value: types.Siacoins(6)})
+	txn.MinerFee = types.Siacoins(1)
+	sign(&txn, 1, bobPriv)
+	if err := vc.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+	txn.SiacoinInputs = append(txn.SiacoinInputs, input(3, carol, types.SigHashAll))
+	txn.MinerFee = types.Siacoins(2)
+	sign(&txn, 2, carolPriv)
+	if err := vc.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the signed output is still covered
+	tampered := txn.DeepCopy()
+	tampered.SiacoinOutputs[0].Value = types.Siacoins(4)
+	tampered.SiacoinOutputs[1].Value = types.Siacoins(7)
+	if err := vc.ValidateTransaction(tampered); err == nil {
+		t.Fatal("accepted transaction with modified output")
+	}
+
+	// with the default flags, adding an input invalidates the signature
+	txn = types.Transaction{
+		SiacoinInputs:  []types.SiacoinInput{input(1, alice, types.SigHashAll)},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.StandardAddress(alice), Value: types.Siacoins(5)}},
+	}
+	sign(&txn, 0, alicePriv)
+	txn.SiacoinInputs = append(txn.SiacoinInputs, input(3, carol, types.SigHashAll))
+	txn.MinerFee = types.Siacoins(1)
+	sign(&txn, 1, carolPriv)
+	if err := vc.ValidateTransaction(txn); err == nil {
+		t.Fatal("accepted transaction with added input")
+	}
+
+	// invalid flags
+	for _, f := range []types.SigHashFlags{4, types.SigHashSingle} {
+		txn = types.Transaction{
+			SiacoinInputs: []types.SiacoinInput{input(1, alice, f)},
+			MinerFee:      types.Siacoins(5),
+		}
+		sign(&txn, 0, alicePriv)
+		if err := vc.ValidateTransaction(txn); err == nil {
+			t.Fatalf("accepted transaction with flags %v", f)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Package events provides a bus for notifications that cut across subsystems.
+//
+// Components such as the chain manager publish events to a Bus supplied by the
+// embedding application; consumers such as metrics collectors, alerting, and
+// user interfaces subscribe to the topics they care about, rather than
+// registering separate callbacks with each component.
+package events
+
+import (
+	"sync"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A Topic identifies a kind of event.
+type Topic string
+
+// Topics published by core subsystems.
+const (
+	TopicBlockApplied  Topic = "blockApplied"
+	TopicReorgDetected Topic = "reorgDetected"
+
+	// TopicGap is delivered to queued subscribers regardless of the topics
+	// they subscribed to.
+	TopicGap Topic = "gap"
+)
+
+// An Event is a notification published to a Bus.
+type Event interface {
+	Topic() Topic
+}
+
+// BlockApplied is published when a block is added to the best chain.
+type BlockApplied struct {
+	Block types.Block
+	// Context is the ValidationContext after the block was applied.
+	Context consensus.ValidationContext
+}
+
+// ReorgDetected is published when the best chain switches to a different
+// branch. It is published after the blocks of the new branch have been
+// applied; each of those blocks is also published as a BlockApplied event.
+type ReorgDetected struct {
+	// Base is the most recent block shared by both branches.
+	Base   types.ChainIndex
+	OldTip types.ChainIndex
+	NewTip types.ChainIndex
+}
+
+// Depth returns the number of blocks that were reverted.
+func (e ReorgDetected) Depth() uint64 {
+	return e.OldTip.Height - e.Base.Height
+}
+
+// Gap is delivered to a queued subscriber in place of events that were dropped
+// because its queue was full. Subscribers to chain events can recover the
+// missing updates via (*chain.Manager).UpdatesSince.
+type Gap struct {
+	// Dropped is the number of events that were dropped.
+	Dropped int
+	// Disconnected indicates that the subscription was removed because its
+	// queue was full. It is the last event the subscriber will receive.
+	Disconnected bool
+}
+
+// Topic implements Event.
+func (BlockApplied) Topic() Topic { return TopicBlockApplied }
+
+// Topic implements Event.
+func (ReorgDetected) Topic() Topic { return TopicReorgDetected }
+
+// Topic implements Event.
+func (Gap) Topic() Topic { return TopicGap }
+
+// An OverflowPolicy determines what happens when an event is published to a
+// subscriber whose queue is full.
+type OverflowPolicy int
+
+// Overflow policies.
+const (
+	// OverflowBlock blocks the publisher until the queue has space.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDrop discards the event. Once the queue has space again, the
+	// subscriber receives a Gap reporting how many events were dropped.
+	OverflowDrop
+	// OverflowDisconnect removes the subscription. The subscriber receives
+	// the events already in its queue, followed by a Gap with Disconnected
+	// set.
+	OverflowDisconnect
+)
+
+// QueueOptions configures a queued subscription.
+type QueueOptions struct {
+	// Size is the maximum number of undelivered events. The default is 64.
+	Size     int
+	Overflow OverflowPolicy
+}
+
+type queue struct {
+	fn       func(Event)
+	ch       chan Event
+	overflow OverflowPolicy
+	done     chan struct{}
+	close    sync.Once
+	unsub    func()
+
+	mu           sync.Mutex
+	dropped      int
+	disconnected bool
+}
+
+func (q *queue) push(e Event) {
+	switch q.overflow {
+	case OverflowBlock:
+		select {
+		case q.ch <- e:
+		case <-q.done:
+		}
+	case OverflowDrop:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.dropped > 0 {
+			select {
+			case q.ch <- Gap{Dropped: q.dropped}:
+				q.dropped = 0
+			default:
+				q.dropped++
+				return
+			}
+		}
+		select {
+		case q.ch <- e:
+		default:
+			q.dropped++
+		}
+	case OverflowDisconnect:
+		select {
+		case q.ch <- e:
+		default:
+			q.mu.Lock()
+			q.disconnected = true
+			q.mu.Unlock()
+			q.unsub()
+		}
+	}
+}
+
+func (q *queue) stop() {
+	q.close.Do(func() { close(q.done) })
+}
+
+func (q *queue) run() {
+	for {
+		select {
+		case e := <-q.ch:
+			q.fn(e)
+		case <-q.done:
+			q.mu.Lock()
+			disconnected := q.disconnected
+			q.mu.Unlock()
+			if !disconnected {
+				return
+			}
+			for {
+				select {
+				case e := <-q.ch:
+					q.fn(e)
+				default:
+					q.fn(Gap{Disconnected: true})
+					return
+				}
+			}
+		}
+	}
+}
+
+type subscription struct {
+	id     int
+	fn     func(Event)
+	topics map[Topic]bool // nil means all topics
+	q      *queue         // nil for synchronous subscriptions
+}
+
+// A Bus delivers published events to subscribers. By default, events are
+// delivered synchronously, in the order they were published, so handlers
+// should return quickly. Handlers that may be slow should use SubscribeQueued
+// instead, which delivers events from a bounded queue on a separate goroutine.
+//
+// The zero value is ready for use. Publishing to a nil Bus is a no-op, so
+// components may hold a nil *Bus when the application does not supply one.
+type Bus struct {
+	mu   sync.Mutex
+	subs []subscription
+	next int
+}
+
+// Subscribe registers fn to be called with each event published to one of the
+// specified topics. If no topics are specified, fn is called for every event.
+// The returned function removes the subscription.
+func (b *Bus) Subscribe(fn func(Event), topics ...Topic) (unsubscribe func()) {
+	return b.subscribe(fn, nil, topics)
+}
+
+// SubscribeQueued is like Subscribe, but fn is called on a separate goroutine,
+// and events are buffered in a queue configured by opts. If the queue is full
+// when an event is published, the event is handled according to
+// opts.Overflow. In addition to the specified topics, fn receives Gap events.
+//
+// After the returned function is called, fn may still receive events that
+// were already queued.
+func (b *Bus) SubscribeQueued(fn func(Event), opts QueueOptions, topics ...Topic) (unsubscribe func()) {
+	if opts.Size == 0 {
+		opts.Size = 64
+	} else if opts.Size < 0 {
+		panic("negative queue size") // developer error
+	}
+	q := &queue{
+		fn:       fn,
+		ch:       make(chan Event, opts.Size),
+		overflow: opts.Overflow,
+		done:     make(chan struct{}),
+	}
+	remove := b.subscribe(fn, q, topics)
+	q.unsub = func() {
+		remove()
+		q.stop()
+	}
+	go q.run()
+	return q.unsub
+}
+
+func (b *Bus) subscribe(fn func(Event), q *queue, topics []Topic) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := subscription{id: b.next, fn: fn, q: q}
+	b.next++
+	if len(topics) > 0 {
+		s.topics = make(map[Topic]bool, len(topics))
+		for _, t := range topics {
+			s.topics[t] = true
+		}
+	}
+	b.subs = append(b.subs, s)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i := range b.subs {
+			if b.subs[i].id == s.id {
+				b.subs = append(b.subs[:i:i], b.subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Publish delivers e to each subscriber of its topic. If a queued subscriber
+// with the OverflowBlock policy has a full queue, Publish blocks until the
+// queue has space.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	// deliver in subscription order, without holding the lock, so that
+	// handlers may publish or (un)subscribe
+	b.mu.Lock()
+	fns := make([]func(Event), 0, len(b.subs))
+	for _, s := range b.subs {
+		if s.topics == nil || s.topics[e.Topic()] {
+			if s.q != nil {
+				fns = append(fns, s.q.push)
+			} else {
+				fns = append(fns, s.fn)
+			}
+		}
+	}
+	b.mu.Unlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestBus(t *testing.T) {
+	var b Bus
+	var all, reorgs []Event
+	unsubAll := b.Subscribe(func(e Event) { all = append(all, e) })
+	b.Subscribe(func(e Event) { reorgs = append(reorgs, e) }, TopicReorgDetected)
+
+	reorg := ReorgDetected{OldTip: types.ChainIndex{Height: 2}, NewTip: types.ChainIndex{Height: 3}}
+	applied := BlockApplied{Block: types.Block{Header: types.BlockHeader{Height: 3}}}
+	b.Publish(reorg)
+	b.Publish(applied)
+	if !reflect.DeepEqual(all, []Event{reorg, applied}) {
+		t.Fatal("unfiltered subscriber should receive every event:", all)
+	} else if !reflect.DeepEqual(reorgs, []Event{reorg}) {
+		t.Fatal("filtered subscriber should only receive its topic:", reorgs)
+	}
+
+	unsubAll()
+	b.Publish(reorg)
+	if len(all) != 2 {
+		t.Fatal("unsubscribed handler was called")
+	} else if len(reorgs) != 2 {
+		t.Fatal("remaining subscriber was not called")
+	}
+
+	// handlers may publish
+	var nested int
+	b.Subscribe(func(e Event) {
+		if _, ok := e.(ReorgDetected); ok {
+			b.Publish(BlockApplied{})
+		} else {
+			nested++
+		}
+	}, TopicReorgDetected, TopicBlockApplied)
+	b.Publish(reorg)
+	if nested != 1 {
+		t.Fatal("nested event was not delivered")
+	}
+
+	// publishing to a nil bus is a no-op
+	var nb *Bus
+	nb.Publish(reorg)
+}
+
+func TestSubscribeQueued(t *testing.T) {
+	reorg := ReorgDetected{OldTip: types.ChainIndex{Height: 2}, NewTip: types.ChainIndex{Height: 3}}
+
+	// subscribe a handler that blocks until released, then fill its queue
+	subscribe := func(b *Bus, overflow OverflowPolicy) (chan struct{}, chan Event) {
+		release := make(chan struct{})
+		recv := make(chan Event, 100)
+		b.SubscribeQueued(func(e Event) {
+			<-release
+			recv <- e
+		}, QueueOptions{Size: 2, Overflow: overflow}, TopicReorgDetected)
+		return release, recv
+	}
+	collect := func(recv chan Event, n int) []Event {
+		var es []Event
+		for i := 0; i < n; i++ {
+			select {
+			case e := <-recv:
+				es = append(es, e)
+			case <-time.After(time.Second):
+				t.Fatal("timed out waiting for event")
+			}
+		}
+		return es
+	}
+
+	// drop: the handler holds one event and the queue holds two more, so the
+	// remaining events should be reported as a gap
+	var b Bus
+	release, recv := subscribe(&b, OverflowDrop)
+	b.Publish(reorg)
+	time.Sleep(10 * time.Millisecond) // wait for handler to dequeue
+	for i := 0; i < 5; i++ {
+		b.Publish(reorg)
+	}
+	close(release)
+	collect(recv, 3)
+	b.Publish(reorg)
+	if es := collect(recv, 2); es[0] != (Gap{Dropped: 3}) || es[1] != reorg {
+		t.Fatal("expected gap followed by event, got", es)
+	}
+
+	// disconnect: the subscriber should receive the queued events, then a
+	// final gap
+	b = Bus{}
+	release, recv = subscribe(&b, OverflowDisconnect)
+	b.Publish(reorg)
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		b.Publish(reorg)
+	}
+	close(release)
+	if es := collect(recv, 4); es[3] != (Gap{Disconnected: true}) {
+		t.Fatal("expected disconnection, got", es)
+	}
+	b.Publish(reorg)
+	select {
+	case e := <-recv:
+		t.Fatal("disconnected subscriber received", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// block: the publisher should wait for the subscriber
+	b = Bus{}
+	release, recv = subscribe(&b, OverflowBlock)
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 4; i++ {
+			b.Publish(reorg)
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+		t.Fatal("publisher should have blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-published
+	if es := collect(recv, 4); es[3] != reorg {
+		t.Fatal("expected all events to be delivered, got", es)
+	}
+}
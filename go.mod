@@ -3,6 +3,7 @@ module go.sia.tech/core
 go 1.16
 
 require (
+	github.com/klauspost/compress v1.13.6
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
 	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1
 	lukechampine.com/frand v1.4.2
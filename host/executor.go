@@ -8,6 +8,7 @@ import (
 	"io"
 
 	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/merkle"
 	"go.sia.tech/core/net/rhp"
 	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
@@ -15,6 +16,10 @@ import (
 
 const (
 	blocksPerYear = 144 * 365
+
+	// contractRootCacheHeight is the height of the smallest subtree of sector
+	// roots cached when computing a contract's Merkle root.
+	contractRootCacheHeight = 4
 )
 
 // A ProgramExecutor executes an MDM program in the context of the current
@@ -24,7 +29,7 @@ type ProgramExecutor struct {
 
 	newFileSize   uint64
 	newMerkleRoot types.Hash256
-	newRoots      []types.Hash256
+	newRoots      *merkle.CachedTree
 
 	// gainedSectors counts the number of references a sector has gained
 	// through append or update instructions. When a program is reverted all
@@ -101,8 +106,8 @@ func (pe *ProgramExecutor) executeAppendSector(root types.Hash256, sector *[rhp.
 	}
 
 	// update the program's state
-	pe.newRoots = append(pe.newRoots, root)
-	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
+	pe.newRoots.Append(root)
+	pe.newMerkleRoot = pe.newRoots.Root()
 	pe.newFileSize += rhp.SectorSize
 	pe.gainedSectors[root]++
 	// TODO: calculate optional proof.
@@ -116,10 +121,10 @@ func (pe *ProgramExecutor) executeUpdateSector(offset uint64, data []byte, requi
 	}
 
 	index := offset / rhp.SectorSize
-	if index >= uint64(len(pe.newRoots)) {
+	if index >= uint64(pe.newRoots.Len()) {
 		return nil, fmt.Errorf("offset out of range: %d", index)
 	}
-	existingRoot := pe.newRoots[index]
+	existingRoot := pe.newRoots.Leaf(index)
 	offset %= rhp.SectorSize
 
 	// update the sector in the sector store.
@@ -128,8 +133,8 @@ func (pe *ProgramExecutor) executeUpdateSector(offset uint64, data []byte, requi
 		return nil, fmt.Errorf("failed to update sector: %w", err)
 	}
 	// update the program state
-	pe.newRoots[index] = updatedRoot
-	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
+	pe.newRoots.Update(index, updatedRoot)
+	pe.newMerkleRoot = pe.newRoots.Root()
 	pe.gainedSectors[updatedRoot]++
 	pe.removedSectors[existingRoot]++
 	// TODO: calculate optional proof.
@@ -140,17 +145,17 @@ func (pe *ProgramExecutor) executeUpdateSector(offset uint64, data []byte, requi
 func (pe *ProgramExecutor) executeDropSectors(dropped uint64, requiresProof bool) ([]types.Hash256, error) {
 	if err := pe.payForExecution(rhp.DropSectorsCost(pe.settings, dropped)); err != nil {
 		return nil, fmt.Errorf("failed to pay instruction cost: %w", err)
-	} else if uint64(len(pe.newRoots)) < dropped {
+	} else if uint64(pe.newRoots.Len()) < dropped {
 		return nil, errors.New("dropped sector index out of range")
 	}
 
 	// get the roots of the sectors to be dropped.
-	i := len(pe.newRoots) - int(dropped)
-	droppedRoots := pe.newRoots[i:]
+	i := pe.newRoots.Len() - int(dropped)
+	droppedRoots := append([]types.Hash256(nil), pe.newRoots.Leaves()[i:]...)
 	// update the program's contract state
-	pe.newRoots = pe.newRoots[:i]
-	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
-	pe.newFileSize = uint64(len(pe.newRoots)) * rhp.SectorSize
+	pe.newRoots.Truncate(uint64(i))
+	pe.newMerkleRoot = pe.newRoots.Root()
+	pe.newFileSize = uint64(pe.newRoots.Len()) * rhp.SectorSize
 	// remove a reference of each dropped sector.
 	for _, root := range droppedRoots {
 		pe.removedSectors[root]++
@@ -163,18 +168,18 @@ func (pe *ProgramExecutor) executeDropSectors(dropped uint64, requiresProof bool
 func (pe *ProgramExecutor) executeSwapSectors(indexA, indexB uint64, requiresProof bool) ([]types.Hash256, error) {
 	if err := pe.payForExecution(rhp.SwapSectorCost(pe.settings)); err != nil {
 		return nil, fmt.Errorf("failed to pay instruction cost: %w", err)
-	} else if indexA >= uint64(len(pe.newRoots)) {
+	} else if indexA >= uint64(pe.newRoots.Len()) {
 		return nil, fmt.Errorf("sector 1 index out of range %v", indexA)
-	} else if indexB >= uint64(len(pe.newRoots)) {
+	} else if indexB >= uint64(pe.newRoots.Len()) {
 		return nil, fmt.Errorf("sector 2 index out of range %v", indexB)
 	}
 
 	// swap the sector roots.
-	pe.newRoots[indexA], pe.newRoots[indexB] = pe.newRoots[indexB], pe.newRoots[indexA]
+	pe.newRoots.Swap(indexA, indexB)
 	// update the program's contract state
-	pe.newMerkleRoot = rhp.MetaRoot(pe.newRoots)
-	pe.newRoots[indexA].EncodeTo(pe.encoder)
-	pe.newRoots[indexA].EncodeTo(pe.encoder)
+	pe.newMerkleRoot = pe.newRoots.Root()
+	pe.newRoots.Leaf(indexA).EncodeTo(pe.encoder)
+	pe.newRoots.Leaf(indexA).EncodeTo(pe.encoder)
 
 	// TODO: calculate optional proof.
 	return nil, nil
@@ -212,15 +217,15 @@ func (pe *ProgramExecutor) executeContractRevision() error {
 
 // executeSectorRoots returns the current sector roots of the program executor.
 func (pe *ProgramExecutor) executeSectorRoots() error {
-	if err := pe.payForExecution(rhp.SectorRootsCost(pe.settings, uint64(len(pe.newRoots)))); err != nil {
+	if err := pe.payForExecution(rhp.SectorRootsCost(pe.settings, uint64(pe.newRoots.Len()))); err != nil {
 		return fmt.Errorf("failed to pay instruction cost: %w", err)
 	} else if pe.contract.ID == (types.ElementID{}) {
 		return errors.New("no contract revision set")
 	}
 
 	// write the sector roots to the encoder.
-	pe.encoder.WritePrefix(len(pe.newRoots))
-	for _, root := range pe.newRoots {
+	pe.encoder.WritePrefix(pe.newRoots.Len())
+	for _, root := range pe.newRoots.Leaves() {
 		root.EncodeTo(pe.encoder)
 	}
 	return nil
@@ -269,8 +274,8 @@ func (pe *ProgramExecutor) SetContract(contract rhp.Contract) error {
 	if err != nil {
 		return fmt.Errorf("failed to get contract roots: %w", err)
 	}
-	pe.newMerkleRoot = rhp.MetaRoot(roots)
-	pe.newRoots = append([]types.Hash256(nil), roots...)
+	pe.newRoots = merkle.NewCachedTree(roots, contractRootCacheHeight)
+	pe.newMerkleRoot = pe.newRoots.Root()
 	return nil
 }
 
@@ -294,6 +299,10 @@ func (pe *ProgramExecutor) ExecuteInstruction(r io.Reader, w io.Writer, instruct
 	pe.encoder.Flush()
 	pe.output.Reset()
 
+	if rhp.StoresData(instruction) && !pe.settings.Mode.AcceptsWrites() {
+		return rhp.ErrHostReadOnly
+	}
+
 	proof, err := func() ([]types.Hash256, error) {
 		switch instr := instruction.(type) {
 		case *rhp.InstrAppendSector:
@@ -356,11 +365,11 @@ func (pe *ProgramExecutor) ExecuteInstruction(r io.Reader, w io.Writer, instruct
 			}
 
 			index := offset / rhp.SectorSize
-			if index >= uint64(len(pe.newRoots)) {
+			if index >= uint64(pe.newRoots.Len()) {
 				return nil, fmt.Errorf("offset out of range: %d", index)
 			}
 
-			root := pe.newRoots[index]
+			root := pe.newRoots.Leaf(index)
 			offset %= rhp.SectorSize
 			return pe.executeReadSector(root, offset, length, instr.ProofRequired)
 		case *rhp.InstrSwapSector:
@@ -462,7 +471,7 @@ func (pe *ProgramExecutor) FinalizeContract(req rhp.RPCFinalizeProgramRequest) (
 
 	if err := pe.contracts.Revise(pe.contract); err != nil {
 		return rhp.Contract{}, fmt.Errorf("failed to revise contract: %w", err)
-	} else if err := pe.contracts.SetRoots(pe.contract.ID, pe.newRoots); err != nil {
+	} else if err := pe.contracts.SetRoots(pe.contract.ID, pe.newRoots.Leaves()); err != nil {
 		return rhp.Contract{}, fmt.Errorf("failed to set new roots: %w", err)
 	}
 	return pe.contract, nil
@@ -521,6 +530,7 @@ func NewExecutor(priv types.PrivateKey, ss SectorStore, cm ContractManager, rm *
 		contracts: cm,
 		vc:        vc,
 
+		newRoots:       merkle.NewCachedTree(nil, contractRootCacheHeight),
 		gainedSectors:  make(map[types.Hash256]uint64),
 		removedSectors: make(map[types.Hash256]uint64),
 	}
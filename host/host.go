@@ -106,6 +106,11 @@ type (
 		SetRoots(types.ElementID, []types.Hash256) error
 	}
 
+	// A ChainManager reports the current state of the blockchain.
+	ChainManager interface {
+		TipContext() consensus.ValidationContext
+	}
+
 	// A SettingsReporter returns the host's current settings.
 	SettingsReporter interface {
 		Settings() rhp.HostSettings
@@ -0,0 +1,655 @@
+package host
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// maxLockTimeout is the longest a renter may wait to acquire a contract lock.
+const maxLockTimeout = time.Minute
+
+// A Server implements the host's side of the renter-host protocol. It handles
+// RPC dispatch, contract locking, revision validation, and proof construction,
+// delegating storage, pricing, and funding decisions to its dependencies.
+type Server struct {
+	privkey   types.PrivateKey
+	chain     ChainManager
+	sectors   SectorStore
+	contracts ContractStore
+	settings  SettingsReporter
+	wallet    Wallet
+	tpool     TransactionPool
+
+	interceptors []rpc.Interceptor
+
+	mu    sync.Mutex
+	locks map[types.ElementID]chan struct{}
+}
+
+// maxReadLength is the maximum total length of the sections requested in a
+// single Read RPC. The sections are buffered in memory until the renter's
+// payment has been committed.
+const maxReadLength = 64 * rhp.SectorSize
+
+// errInternal is reported to the renter in place of an internalError.
+var errInternal = errors.New("host encountered an internal error")
+
+// An internalError is a failure of the host's own storage. Its details are
+// visible to interceptors, but are not disclosed to the renter.
+type internalError struct {
+	err error
+}
+
+func (e internalError) Error() string { return e.err.Error() }
+func (e internalError) Unwrap() error { return e.err }
+
+// A serverSession tracks the contract locked by a renter during a Session.
+type serverSession struct {
+	sess     *rhp.Session
+	contract *rhp.Contract
+}
+
+// lockContract locks the contract with the given ID, waiting up to timeout for
+// any existing lock to be released.
+func (s *Server) lockContract(id types.ElementID, timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		s.mu.Lock()
+		ch, ok := s.locks[id]
+		if !ok {
+			s.locks[id] = make(chan struct{})
+			s.mu.Unlock()
+			return true
+		}
+		s.mu.Unlock()
+		select {
+		case <-ch:
+		case <-t.C:
+			return false
+		}
+	}
+}
+
+// unlockContract releases the lock on the contract with the given ID.
+func (s *Server) unlockContract(id types.ElementID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.locks[id]; ok {
+		close(ch)
+		delete(s.locks, id)
+	}
+}
+
+// readSector reads the full sector with the given root.
+func (s *Server) readSector(root types.Hash256) (*[rhp.SectorSize]byte, error) {
+	var buf bytes.Buffer
+	if n, err := s.sectors.Read(root, &buf, 0, rhp.SectorSize); err != nil {
+		return nil, internalError{fmt.Errorf("failed to read sector %v: %w", root, err)}
+	} else if n != rhp.SectorSize {
+		return nil, internalError{fmt.Errorf("failed to read sector %v: read %v bytes", root, n)}
+	}
+	var sector [rhp.SectorSize]byte
+	copy(sector[:], buf.Bytes())
+	return &sector, nil
+}
+
+// reviseContract returns a revision of fc with the revision number and outputs
+// requested by the renter.
+func reviseContract(fc types.FileContract, revisionNumber uint64, outputs rhp.ContractOutputs) types.FileContract {
+	fc.RevisionNumber = revisionNumber
+	fc.RenterOutput.Value = outputs.RenterValue
+	fc.HostOutput.Value = outputs.HostValue
+	fc.MissedHostValue = outputs.MissedHostValue
+	return fc
+}
+
+// signRevision verifies the renter's signature of rev and adds the host's
+// signature.
+func (s *Server) signRevision(rev *types.FileContract, renterSig types.Signature) error {
+	vc := s.chain.TipContext()
+	hash := vc.ContractSigHash(*rev)
+	if !rev.RenterPublicKey.VerifyHash(hash, renterSig) {
+		return fmt.Errorf("renter %w", rhp.ErrInvalidSignature)
+	}
+	rev.RenterSignature = renterSig
+	rev.HostSignature = s.privkey.SignHash(hash)
+	return nil
+}
+
+// commitRevision stores a revision of the renter's locked contract.
+func (s *Server) commitRevision(ss *serverSession, rev types.FileContract) error {
+	c := rhp.Contract{ID: ss.contract.ID, Revision: rev}
+	if err := s.contracts.Revise(c); err != nil {
+		return internalError{fmt.Errorf("failed to revise contract: %w", err)}
+	}
+	ss.contract.Revision = rev
+	return nil
+}
+
+func (s *Server) handleSettings(ss *serverSession, stream io.ReadWriter) error {
+	settings := s.settings.Settings()
+	return ss.sess.WriteResponse(stream, &settings)
+}
+
+func (s *Server) handleLock(ss *serverSession, stream io.ReadWriter) error {
+	var req rhp.RPCLockRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	} else if ss.contract != nil {
+		return rhp.ErrContractAlreadyLocked
+	}
+	c, err := s.contracts.Get(req.ContractID)
+	if err != nil {
+		return fmt.Errorf("failed to get contract: %w", err)
+	} else if !ss.sess.VerifyChallenge(req.Signature, c.Revision.RenterPublicKey) {
+		return errors.New("challenge signature is invalid")
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	if timeout > maxLockTimeout {
+		timeout = maxLockTimeout
+	}
+	var challenge [16]byte
+	types.ReadEntropy(challenge[:])
+	ss.sess.SetChallenge(challenge)
+	if !s.lockContract(c.ID, timeout) {
+		return ss.sess.WriteResponse(stream, &rhp.RPCLockResponse{NewChallenge: challenge})
+	}
+	// reload the contract, since it may have been revised while we waited
+	if c, err = s.contracts.Get(req.ContractID); err != nil {
+		s.unlockContract(req.ContractID)
+		return fmt.Errorf("failed to get contract: %w", err)
+	}
+	ss.contract = &c
+	return ss.sess.WriteResponse(stream, &rhp.RPCLockResponse{
+		Acquired:     true,
+		NewChallenge: challenge,
+		Revision: types.FileContractRevision{
+			Parent:   types.FileContractElement{StateElement: types.StateElement{ID: c.ID}},
+			Revision: c.Revision,
+		},
+	})
+}
+
+func (s *Server) handleUnlock(ss *serverSession, stream io.ReadWriter) error {
+	if ss.contract == nil {
+		return rhp.ErrNoContractLocked
+	}
+	s.unlockContract(ss.contract.ID)
+	ss.contract = nil
+	return nil
+}
+
+func (s *Server) handleFormContract(ss *serverSession, stream io.ReadWriter) (err error) {
+	var req rhp.RPCFormContractRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	}
+	vc := s.chain.TipContext()
+	settings := s.settings.Settings()
+	fc := req.Contract
+	switch {
+	case !settings.AcceptingContracts:
+		return errors.New("host is not accepting contracts")
+	case fc.HostPublicKey != s.privkey.PublicKey():
+		return errors.New("contract has a different host key")
+	case !fc.RenterPublicKey.VerifyHash(vc.ContractSigHash(fc), fc.RenterSignature):
+		return fmt.Errorf("renter contract %w", rhp.ErrInvalidSignature)
+	}
+	if err := rhp.ValidateContractFormation(fc, vc.Index.Height, settings); err != nil {
+		return fmt.Errorf("invalid contract: %w", err)
+	}
+
+	// the host funds its collateral
+	txn := types.Transaction{
+		SiacoinInputs:  req.Inputs,
+		SiacoinOutputs: req.Outputs,
+		FileContracts:  []types.FileContract{fc},
+		MinerFee:       req.MinerFee,
+	}
+	renterInputs, renterOutputs := len(req.Inputs), len(req.Outputs)
+	var toSign []types.ElementID
+	if !fc.TotalCollateral.IsZero() {
+		var discard func()
+		toSign, discard, err = s.wallet.FundTransaction(&txn, fc.TotalCollateral, nil)
+		if err != nil {
+			return fmt.Errorf("failed to fund transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				discard()
+			}
+		}()
+	}
+	txn.FileContracts[0].HostSignature = s.privkey.SignHash(vc.ContractSigHash(fc))
+	additions := &rhp.RPCFormContractHostAdditions{
+		Inputs:            txn.SiacoinInputs[renterInputs:],
+		Outputs:           txn.SiacoinOutputs[renterOutputs:],
+		ContractSignature: txn.FileContracts[0].HostSignature,
+	}
+	if err := ss.sess.WriteResponse(stream, additions); err != nil {
+		return err
+	}
+
+	var renterSigs rhp.RPCContractSignatures
+	if err := ss.sess.ReadResponse(stream, &renterSigs); err != nil {
+		return err
+	} else if len(renterSigs.SiacoinInputSignatures) != renterInputs {
+		return fmt.Errorf("renter sent signatures for %v inputs, expected %v", len(renterSigs.SiacoinInputSignatures), renterInputs)
+	}
+	for i, sigs := range renterSigs.SiacoinInputSignatures {
+		txn.SiacoinInputs[i].Signatures = sigs
+	}
+	if err := s.wallet.SignTransaction(vc, &txn, toSign); err != nil {
+		return fmt.Errorf("failed to sign transaction: %w", err)
+	} else if err := s.tpool.AddTransaction(txn); err != nil {
+		return fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+	c := rhp.Contract{ID: txn.FileContractID(0), Revision: txn.FileContracts[0]}
+	if err := s.contracts.Add(c, txn); err != nil {
+		return fmt.Errorf("failed to add contract: %w", err)
+	}
+
+	hostSigs := &rhp.RPCContractSignatures{SiacoinInputSignatures: make([][]types.Signature, len(txn.SiacoinInputs)-renterInputs)}
+	for i := range hostSigs.SiacoinInputSignatures {
+		hostSigs.SiacoinInputSignatures[i] = txn.SiacoinInputs[renterInputs+i].Signatures
+	}
+	return ss.sess.WriteResponse(stream, hostSigs)
+}
+
+func (s *Server) handleRenewContract(ss *serverSession, stream io.ReadWriter) (err error) {
+	var req rhp.RPCRenewContractRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	} else if ss.contract == nil {
+		return rhp.ErrNoContractLocked
+	}
+	vc := s.chain.TipContext()
+	settings := s.settings.Settings()
+	current := ss.contract.Revision
+	r := req.Resolution.Renewal
+	switch {
+	case !settings.AcceptingContracts:
+		return errors.New("host is not accepting contracts")
+	case req.Resolution.Parent.ID != ss.contract.ID:
+		return errors.New("resolution does not renew the locked contract")
+	case !current.RenterPublicKey.VerifyHash(vc.ContractSigHash(r.FinalRevision), r.FinalRevision.RenterSignature):
+		return fmt.Errorf("renter finalization %w", rhp.ErrInvalidSignature)
+	case !current.RenterPublicKey.VerifyHash(vc.ContractSigHash(r.InitialRevision), r.InitialRevision.RenterSignature):
+		return fmt.Errorf("renter initial revision %w", rhp.ErrInvalidSignature)
+	}
+	if err := rhp.ValidateContractFinalization(current, r.FinalRevision); err != nil {
+		return fmt.Errorf("invalid finalization: %w", err)
+	} else if err := rhp.ValidateContractRenewal(current, r.InitialRevision, vc.Index.Height, settings); err != nil {
+		return fmt.Errorf("invalid renewal: %w", err)
+	}
+
+	// the host funds its share of the new contract, less whatever it can roll
+	// over from the old one
+	hostCost := r.InitialRevision.HostOutput.Value.Sub(settings.ContractFee)
+	r.HostRollover = r.FinalRevision.HostOutput.Value
+	if hostCost.Cmp(r.HostRollover) < 0 {
+		r.HostRollover = hostCost
+	}
+	txn := types.Transaction{
+		SiacoinInputs:           req.Inputs,
+		SiacoinOutputs:          req.Outputs,
+		FileContractResolutions: []types.FileContractResolution{{Parent: req.Resolution.Parent}},
+		MinerFee:                req.MinerFee,
+	}
+	renterInputs, renterOutputs := len(req.Inputs), len(req.Outputs)
+	var toSign []types.ElementID
+	if fund := hostCost.Sub(r.HostRollover); !fund.IsZero() {
+		var discard func()
+		toSign, discard, err = s.wallet.FundTransaction(&txn, fund, nil)
+		if err != nil {
+			return fmt.Errorf("failed to fund transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				discard()
+			}
+		}()
+	}
+	r.FinalRevision.HostSignature = s.privkey.SignHash(vc.ContractSigHash(r.FinalRevision))
+	r.InitialRevision.HostSignature = s.privkey.SignHash(vc.ContractSigHash(r.InitialRevision))
+	r.HostSignature = s.privkey.SignHash(vc.RenewalSigHash(r))
+	additions := &rhp.RPCRenewContractHostAdditions{
+		Inputs:                txn.SiacoinInputs[renterInputs:],
+		Outputs:               txn.SiacoinOutputs[renterOutputs:],
+		HostRollover:          r.HostRollover,
+		FinalizationSignature: r.FinalRevision.HostSignature,
+		InitialSignature:      r.InitialRevision.HostSignature,
+		RenewalSignature:      r.HostSignature,
+	}
+	if err := ss.sess.WriteResponse(stream, additions); err != nil {
+		return err
+	}
+
+	var renterSigs rhp.RPCRenewContractRenterSignatures
+	if err := ss.sess.ReadResponse(stream, &renterSigs); err != nil {
+		return err
+	} else if len(renterSigs.SiacoinInputSignatures) != renterInputs {
+		return fmt.Errorf("renter sent signatures for %v inputs, expected %v", len(renterSigs.SiacoinInputSignatures), renterInputs)
+	} else if !current.RenterPublicKey.VerifyHash(vc.RenewalSigHash(r), renterSigs.RenewalSignature) {
+		return fmt.Errorf("renter renewal %w", rhp.ErrInvalidSignature)
+	}
+	r.RenterSignature = renterSigs.RenewalSignature
+	txn.FileContractResolutions[0].Renewal = r
+	for i, sigs := range renterSigs.SiacoinInputSignatures {
+		txn.SiacoinInputs[i].Signatures = sigs
+	}
+	if len(toSign) > 0 {
+		if err := s.wallet.SignTransaction(vc, &txn, toSign); err != nil {
+			return fmt.Errorf("failed to sign transaction: %w", err)
+		}
+	}
+	if err := s.tpool.AddTransaction(txn); err != nil {
+		return fmt.Errorf("failed to broadcast transaction: %w", err)
+	}
+
+	// the renewed contract inherits the sectors of the old one
+	roots, err := s.contracts.Roots(ss.contract.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get contract roots: %w", err)
+	}
+	renewed := rhp.Contract{ID: txn.FileContractID(len(txn.FileContracts)), Revision: r.InitialRevision}
+	if err := s.contracts.Add(renewed, txn); err != nil {
+		return fmt.Errorf("failed to add contract: %w", err)
+	} else if err := s.contracts.SetRoots(renewed.ID, roots); err != nil {
+		return fmt.Errorf("failed to set contract roots: %w", err)
+	} else if err := s.commitRevision(ss, r.FinalRevision); err != nil {
+		return err
+	}
+
+	hostSigs := &rhp.RPCContractSignatures{SiacoinInputSignatures: make([][]types.Signature, len(txn.SiacoinInputs)-renterInputs)}
+	for i := range hostSigs.SiacoinInputSignatures {
+		hostSigs.SiacoinInputSignatures[i] = txn.SiacoinInputs[renterInputs+i].Signatures
+	}
+	return ss.sess.WriteResponse(stream, hostSigs)
+}
+
+func (s *Server) handleRead(ss *serverSession, stream io.ReadWriter) error {
+	var req rhp.RPCReadRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	} else if ss.contract == nil {
+		return rhp.ErrNoContractLocked
+	} else if len(req.Sections) == 0 {
+		return errors.New("no sections requested")
+	}
+	var total uint64
+	for i, sec := range req.Sections {
+		if sec.Length == 0 || sec.Offset%64 != 0 || sec.Length%64 != 0 || sec.Offset > rhp.SectorSize || sec.Length > rhp.SectorSize-sec.Offset {
+			return fmt.Errorf("section %v is out of bounds or misaligned", i)
+		} else if total += sec.Length; total > maxReadLength {
+			return fmt.Errorf("sections exceed maximum read length (%v bytes)", maxReadLength)
+		}
+	}
+
+	// validate the payment, and read the requested sections, before
+	// accepting the payment and sending any data
+	current := ss.contract.Revision
+	rev := reviseContract(current, req.NewRevisionNumber, req.NewOutputs)
+	if err := rhp.ValidatePaymentRevision(current, rev, rhp.RPCReadCost(s.settings.Settings(), req.Sections)); err != nil {
+		return fmt.Errorf("invalid payment revision: %w", err)
+	} else if err := s.signRevision(&rev, req.Signature); err != nil {
+		return err
+	}
+	resps := make([]rhp.RPCReadResponse, len(req.Sections))
+	for i, sec := range req.Sections {
+		sector, err := s.readSector(sec.MerkleRoot)
+		if err != nil {
+			return err
+		}
+		resps[i].Data = sector[sec.Offset:][:sec.Length]
+		if req.MerkleProof {
+			if resps[i].MerkleProof, err = rhp.BuildSectorRangeProof(sector, sec.Offset, sec.Length); err != nil {
+				return internalError{fmt.Errorf("failed to build proof: %w", err)}
+			}
+		}
+	}
+	if err := s.commitRevision(ss, rev); err != nil {
+		return err
+	}
+	// the host's signature accompanies the final section
+	resps[len(resps)-1].Signature = rev.HostSignature
+	for i := range resps {
+		if err := ss.sess.WriteResponse(stream, &resps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleWrite(ss *serverSession, stream io.ReadWriter) (err error) {
+	var req rhp.RPCWriteRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	} else if ss.contract == nil {
+		return rhp.ErrNoContractLocked
+	}
+	settings := s.settings.Settings()
+	current := ss.contract.Revision
+	oldRoots, err := s.contracts.Roots(ss.contract.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get contract roots: %w", err)
+	}
+
+	// apply the actions, tracking sector references so that they can be
+	// released if the RPC fails, or once it succeeds
+	var gained, removed []types.Hash256
+	defer func() {
+		if err != nil {
+			removed = gained
+		}
+		for _, root := range removed {
+			s.sectors.Delete(root, 1)
+		}
+	}()
+	roots := append([]types.Hash256(nil), oldRoots...)
+	for i, action := range req.Actions {
+		switch action.Type {
+		case rhp.RPCWriteActionAppend:
+			if len(action.Data) != rhp.SectorSize {
+				return fmt.Errorf("action %v: sector has %v bytes, expected %v", i, len(action.Data), rhp.SectorSize)
+			}
+			sector := new([rhp.SectorSize]byte)
+			copy(sector[:], action.Data)
+			root := rhp.SectorRoot(sector)
+			if err := s.sectors.Add(root, sector); err != nil {
+				return fmt.Errorf("action %v: failed to add sector: %w", i, err)
+			}
+			gained = append(gained, root)
+			roots = append(roots, root)
+		case rhp.RPCWriteActionTrim:
+			if action.A > uint64(len(roots)) {
+				return fmt.Errorf("action %v: cannot trim %v sectors from %v", i, action.A, len(roots))
+			}
+			n := uint64(len(roots)) - action.A
+			removed = append(removed, roots[n:]...)
+			roots = roots[:n]
+		case rhp.RPCWriteActionSwap:
+			if action.A >= uint64(len(roots)) || action.B >= uint64(len(roots)) {
+				return fmt.Errorf("action %v: swap indices (%v, %v) out of bounds", i, action.A, action.B)
+			}
+			roots[action.A], roots[action.B] = roots[action.B], roots[action.A]
+		case rhp.RPCWriteActionUpdate:
+			if action.A >= uint64(len(roots)) {
+				return fmt.Errorf("action %v: update index %v out of bounds", i, action.A)
+			}
+			root, err := s.sectors.Update(roots[action.A], action.B, action.Data)
+			if err != nil {
+				return fmt.Errorf("action %v: failed to update sector: %w", i, err)
+			}
+			gained = append(gained, root)
+			removed = append(removed, roots[action.A])
+			roots[action.A] = root
+		default:
+			return fmt.Errorf("action %v: unknown type %q", i, action.Type)
+		}
+	}
+
+	proof := rhp.RPCWriteMerkleProof{NewMerkleRoot: rhp.MetaRoot(roots)}
+	if req.MerkleProof {
+		if proof, err = rhp.BuildWriteProof(oldRoots, roots, req.Actions); err != nil {
+			return fmt.Errorf("failed to build proof: %w", err)
+		} else if err := ss.sess.WriteResponse(stream, &proof); err != nil {
+			return err
+		}
+	}
+
+	var duration uint64
+	if current.WindowStart > settings.BlockHeight {
+		duration = current.WindowStart - settings.BlockHeight
+	}
+	cost, collateral := rhp.RPCWriteCost(settings, duration, req.Actions)
+	rev := reviseContract(current, req.NewRevisionNumber, req.NewOutputs)
+	rev.Filesize = uint64(len(roots)) * rhp.SectorSize
+	rev.FileMerkleRoot = proof.NewMerkleRoot
+	if err := rhp.ValidateWriteRevision(current, rev, rev.Filesize, rev.FileMerkleRoot, cost, collateral); err != nil {
+		return fmt.Errorf("invalid write revision: %w", err)
+	}
+
+	var renterSig rhp.RPCWriteResponse
+	if err := ss.sess.ReadResponse(stream, &renterSig); err != nil {
+		return err
+	} else if err := s.signRevision(&rev, renterSig.Signature); err != nil {
+		return err
+	} else if err := s.contracts.SetRoots(ss.contract.ID, roots); err != nil {
+		return fmt.Errorf("failed to set contract roots: %w", err)
+	} else if err := s.commitRevision(ss, rev); err != nil {
+		return err
+	}
+	return ss.sess.WriteResponse(stream, &rhp.RPCWriteResponse{Signature: rev.HostSignature})
+}
+
+func (s *Server) handleSectorRoots(ss *serverSession, stream io.ReadWriter) error {
+	var req rhp.RPCSectorRootsRequest
+	if err := ss.sess.Limits.ReadRequest(stream, &req); err != nil {
+		return err
+	} else if ss.contract == nil {
+		return rhp.ErrNoContractLocked
+	}
+	roots, err := s.contracts.Roots(ss.contract.ID)
+	if err != nil {
+		return fmt.Errorf("failed to get contract roots: %w", err)
+	} else if req.RootOffset > uint64(len(roots)) || req.NumRoots > uint64(len(roots))-req.RootOffset {
+		return fmt.Errorf("requested roots [%v, %v) exceed contract's %v roots", req.RootOffset, req.RootOffset+req.NumRoots, len(roots))
+	}
+
+	current := ss.contract.Revision
+	rev := reviseContract(current, req.NewRevisionNumber, req.NewOutputs)
+	if err := rhp.ValidatePaymentRevision(current, rev, rhp.RPCSectorRootsCost(s.settings.Settings(), req.NumRoots)); err != nil {
+		return fmt.Errorf("invalid payment revision: %w", err)
+	} else if err := s.signRevision(&rev, req.Signature); err != nil {
+		return err
+	}
+	resp := &rhp.RPCSectorRootsResponse{
+		Signature:   rev.HostSignature,
+		SectorRoots: roots[req.RootOffset:][:req.NumRoots],
+	}
+	if resp.MerkleProof, err = rhp.BuildSectorRootsProof(roots, req.RootOffset, req.NumRoots); err != nil {
+		return fmt.Errorf("failed to build proof: %w", err)
+	} else if err := s.commitRevision(ss, rev); err != nil {
+		return err
+	}
+	return ss.sess.WriteResponse(stream, resp)
+}
+
+// handleStream reads an RPC ID from stream and calls the corresponding handler,
+// wrapped by the Server's interceptors, reporting any error to the renter.
+func (s *Server) handleStream(ss *serverSession, stream *mux.Stream) {
+	id, err := rpc.ReadID(stream)
+	if err != nil {
+		return
+	}
+	h := func(rw io.ReadWriter) error {
+		switch id {
+		case rhp.RPCSettingsID:
+			return s.handleSettings(ss, rw)
+		case rhp.RPCLockID:
+			return s.handleLock(ss, rw)
+		case rhp.RPCUnlockID:
+			return s.handleUnlock(ss, rw)
+		case rhp.RPCFormContractID:
+			return s.handleFormContract(ss, rw)
+		case rhp.RPCRenewContractID:
+			return s.handleRenewContract(ss, rw)
+		case rhp.RPCReadID:
+			return s.handleRead(ss, rw)
+		case rhp.RPCWriteID:
+			return s.handleWrite(ss, rw)
+		case rhp.RPCSectorRootsID:
+			return s.handleSectorRoots(ss, rw)
+		default:
+			return fmt.Errorf("unknown RPC ID %q", id)
+		}
+	}
+	for i := len(s.interceptors) - 1; i >= 0; i-- {
+		h = s.interceptors[i](id, h)
+	}
+	if err := h(stream); err != nil {
+		var ie internalError
+		if errors.As(err, &ie) {
+			err = errInternal
+		}
+		// the stream may already be unusable, so this is best-effort
+		ss.sess.WriteResponseErr(stream, err)
+	}
+}
+
+// Use appends interceptors to the Server's chain, such that the first
+// interceptor is outermost. Each interceptor wraps the handler for an RPC after
+// its ID has been read from the stream. Use must not be called concurrently
+// with Serve.
+func (s *Server) Use(interceptors ...rpc.Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// Serve handles RPCs on sess until the session is closed, releasing any
+// contract locked by the renter when it returns. Failed RPCs are reported to
+// the renter and do not end the session.
+func (s *Server) Serve(sess *rhp.Session) error {
+	ss := &serverSession{sess: sess}
+	defer func() {
+		if ss.contract != nil {
+			s.unlockContract(ss.contract.ID)
+		}
+	}()
+	for {
+		stream, err := sess.AcceptStream()
+		if errors.Is(err, mux.ErrPeerClosedConn) || errors.Is(err, mux.ErrClosedConn) || errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		s.handleStream(ss, stream)
+		stream.Close()
+	}
+}
+
+// NewServer returns a Server that handles RPCs on behalf of the host with the
+// given private key.
+func NewServer(priv types.PrivateKey, cm ChainManager, ss SectorStore, cs ContractStore, sr SettingsReporter, w Wallet, tp TransactionPool) *Server {
+	return &Server{
+		privkey:   priv,
+		chain:     cm,
+		sectors:   ss,
+		contracts: cs,
+		settings:  sr,
+		wallet:    w,
+		tpool:     tp,
+		locks:     make(map[types.ElementID]chan struct{}),
+	}
+}
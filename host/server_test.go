@@ -0,0 +1,419 @@
+package host
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+type stubChainManager struct {
+	vc consensus.ValidationContext
+}
+
+func (cm stubChainManager) TipContext() consensus.ValidationContext { return cm.vc }
+
+type stubSettingsReporter struct {
+	settings rhp.HostSettings
+}
+
+func (sr stubSettingsReporter) Settings() rhp.HostSettings { return sr.settings }
+
+type ephemeralSectorStore struct {
+	mu      sync.Mutex
+	sectors map[types.Hash256]*[rhp.SectorSize]byte
+	refs    map[types.Hash256]uint64
+}
+
+func (es *ephemeralSectorStore) Add(root types.Hash256, sector *[rhp.SectorSize]byte) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.sectors[root] = sector
+	es.refs[root]++
+	return nil
+}
+
+func (es *ephemeralSectorStore) Delete(root types.Hash256, references uint64) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.refs[root] <= references {
+		delete(es.sectors, root)
+		delete(es.refs, root)
+	} else {
+		es.refs[root] -= references
+	}
+	return nil
+}
+
+func (es *ephemeralSectorStore) Exists(root types.Hash256) (bool, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	_, ok := es.sectors[root]
+	return ok, nil
+}
+
+func (es *ephemeralSectorStore) Read(root types.Hash256, w io.Writer, offset, length uint64) (uint64, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	sector, ok := es.sectors[root]
+	if !ok {
+		return 0, errors.New("sector not found")
+	}
+	n, err := w.Write(sector[offset:][:length])
+	return uint64(n), err
+}
+
+func (es *ephemeralSectorStore) Update(root types.Hash256, offset uint64, data []byte) (types.Hash256, error) {
+	es.mu.Lock()
+	sector, ok := es.sectors[root]
+	es.mu.Unlock()
+	if !ok {
+		return types.Hash256{}, errors.New("sector not found")
+	}
+	updated := *sector
+	copy(updated[offset:], data)
+	newRoot := rhp.SectorRoot(&updated)
+	return newRoot, es.Add(newRoot, &updated)
+}
+
+func newEphemeralSectorStore() *ephemeralSectorStore {
+	return &ephemeralSectorStore{
+		sectors: make(map[types.Hash256]*[rhp.SectorSize]byte),
+		refs:    make(map[types.Hash256]uint64),
+	}
+}
+
+type ephemeralContractStore struct {
+	mu        sync.Mutex
+	contracts map[types.ElementID]rhp.Contract
+	roots     map[types.ElementID][]types.Hash256
+}
+
+func (es *ephemeralContractStore) ProcessChainApplyUpdate(*chain.ApplyUpdate, bool) error { return nil }
+func (es *ephemeralContractStore) ProcessChainRevertUpdate(*chain.RevertUpdate) error     { return nil }
+
+func (es *ephemeralContractStore) Exists(id types.ElementID) bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	_, ok := es.contracts[id]
+	return ok
+}
+
+func (es *ephemeralContractStore) Get(id types.ElementID) (rhp.Contract, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	c, ok := es.contracts[id]
+	if !ok {
+		return rhp.Contract{}, errors.New("contract not found")
+	}
+	return c, nil
+}
+
+func (es *ephemeralContractStore) Add(c rhp.Contract, txn types.Transaction) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.contracts[c.ID] = c
+	return nil
+}
+
+func (es *ephemeralContractStore) Revise(c rhp.Contract) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if _, ok := es.contracts[c.ID]; !ok {
+		return errors.New("contract not found")
+	}
+	es.contracts[c.ID] = c
+	return nil
+}
+
+func (es *ephemeralContractStore) Roots(id types.ElementID) ([]types.Hash256, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return append([]types.Hash256(nil), es.roots[id]...), nil
+}
+
+func (es *ephemeralContractStore) SetRoots(id types.ElementID, roots []types.Hash256) error {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.roots[id] = append([]types.Hash256(nil), roots...)
+	return nil
+}
+
+func newEphemeralContractStore() *ephemeralContractStore {
+	return &ephemeralContractStore{
+		contracts: make(map[types.ElementID]rhp.Contract),
+		roots:     make(map[types.ElementID][]types.Hash256),
+	}
+}
+
+// stubWallet funds transactions with fabricated inputs of exactly the
+// requested amount.
+type stubWallet struct {
+	key types.PrivateKey
+}
+
+func (w *stubWallet) Address() types.Address {
+	return types.StandardAddress(w.key.PublicKey())
+}
+
+func (w *stubWallet) SpendPolicy(addr types.Address) (types.SpendPolicy, bool) {
+	return types.PolicyPublicKey(w.key.PublicKey()), addr == w.Address()
+}
+
+func (w *stubWallet) FundTransaction(txn *types.Transaction, amount types.Currency, pool []types.Transaction) ([]types.ElementID, func(), error) {
+	var id types.ElementID
+	types.ReadEntropy(id.Source[:])
+	txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+		Parent: types.SiacoinElement{
+			StateElement:  types.StateElement{ID: id},
+			SiacoinOutput: types.SiacoinOutput{Value: amount, Address: w.Address()},
+		},
+		SpendPolicy: types.PolicyPublicKey(w.key.PublicKey()),
+	})
+	return []types.ElementID{id}, func() {}, nil
+}
+
+func (w *stubWallet) SignTransaction(vc consensus.ValidationContext, txn *types.Transaction, toSign []types.ElementID) error {
+	sigHash := vc.InputSigHash(*txn)
+	for _, id := range toSign {
+		for i := range txn.SiacoinInputs {
+			if txn.SiacoinInputs[i].Parent.ID == id {
+				txn.SiacoinInputs[i].Signatures = []types.Signature{w.key.SignHash(sigHash)}
+			}
+		}
+	}
+	return nil
+}
+
+type stubTransactionPool struct {
+	mu   sync.Mutex
+	txns []types.Transaction
+}
+
+func (tp *stubTransactionPool) AddTransaction(txn types.Transaction) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.txns = append(tp.txns, txn)
+	return nil
+}
+
+func (tp *stubTransactionPool) RecommendedFee() types.Currency { return types.ZeroCurrency }
+
+func TestServer(t *testing.T) {
+	var vc consensus.ValidationContext
+	hostKey := types.GeneratePrivateKey()
+	renterKey := types.GeneratePrivateKey()
+	hostWallet := &stubWallet{key: hostKey}
+	renterWallet := &stubWallet{key: renterKey}
+	settings := rhp.HostSettings{
+		AcceptingContracts:     true,
+		Address:                hostWallet.Address(),
+		Version:                "1.0.0",
+		SectorSize:             rhp.SectorSize,
+		TotalStorage:           100,
+		RemainingStorage:       100,
+		WindowSize:             10,
+		MaxDuration:            1000,
+		ContractFee:            types.Siacoins(1),
+		MaxCollateral:          types.Siacoins(100),
+		Collateral:             types.NewCurrency64(1),
+		StoragePrice:           types.NewCurrency64(1),
+		UploadBandwidthPrice:   types.NewCurrency64(1),
+		DownloadBandwidthPrice: types.NewCurrency64(1),
+	}
+	sectors := newEphemeralSectorStore()
+	contracts := newEphemeralContractStore()
+	tpool := new(stubTransactionPool)
+	srv := NewServer(hostKey, stubChainManager{vc}, sectors, contracts, stubSettingsReporter{settings}, hostWallet, tpool)
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sess, err := rhp.AcceptSession(conn, hostKey)
+				if err != nil {
+					return
+				}
+				defer sess.Close()
+				srv.Serve(sess)
+			}()
+		}
+	}()
+	dial := func() *rhp.Session {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sess, err := rhp.DialSession(conn, hostKey.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess
+	}
+	sess := dial()
+	defer sess.Close()
+	ctx := context.Background()
+
+	if s, err := sess.Settings(); err != nil {
+		t.Fatal(err)
+	} else if s.ContractFee != settings.ContractFee {
+		t.Fatal("wrong settings")
+	}
+
+	// form a contract
+	collateral := types.Siacoins(5)
+	fc := types.FileContract{
+		WindowStart:     100,
+		WindowEnd:       200,
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10), Address: renterWallet.Address()},
+		HostOutput:      types.SiacoinOutput{Value: settings.ContractFee.Add(collateral), Address: settings.Address},
+		MissedHostValue: settings.ContractFee.Add(collateral),
+		TotalCollateral: collateral,
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   hostKey.PublicKey(),
+	}
+	c, txn, err := sess.FormContract(ctx, vc, fc, renterKey, settings, types.ZeroCurrency, renterWallet)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := c.ValidateSignatures(vc); err != nil {
+		t.Fatal(err)
+	} else if len(txn.SiacoinInputs) != 2 {
+		t.Fatal("expected renter and host inputs, got", len(txn.SiacoinInputs))
+	}
+	for i, in := range txn.SiacoinInputs {
+		if len(in.Signatures) != 1 || !types.PublicKey(in.SpendPolicy.(types.PolicyPublicKey)).VerifyHash(vc.InputSigHash(txn), in.Signatures[0]) {
+			t.Fatalf("input %v is not signed", i)
+		}
+	}
+	if hc, err := contracts.Get(c.ID); err != nil || hc != c {
+		t.Fatal("host did not store contract", err)
+	} else if len(tpool.txns) != 1 {
+		t.Fatal("host did not broadcast contract transaction")
+	}
+
+	// lock the contract; other sessions should be unable to acquire it
+	if _, err := sess.Lock(ctx, vc, c.ID, renterKey, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	other := dial()
+	defer other.Close()
+	if _, err := other.Lock(ctx, vc, c.ID, renterKey, 10*time.Millisecond); !errors.Is(err, rpc.ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+
+	// upload two sectors, then read them back
+	var sector1, sector2 [rhp.SectorSize]byte
+	types.ReadEntropy(sector1[:256])
+	types.ReadEntropy(sector2[:256])
+	root1, root2 := rhp.SectorRoot(&sector1), rhp.SectorRoot(&sector2)
+	actions := []rhp.RPCWriteAction{
+		{Type: rhp.RPCWriteActionAppend, Data: sector1[:]},
+		{Type: rhp.RPCWriteActionAppend, Data: sector2[:]},
+	}
+	if err := sess.Write(ctx, settings, actions, nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	sections := []rhp.RPCReadRequestSection{{MerkleRoot: root2, Offset: 64, Length: 128}}
+	if err := sess.Read(ctx, settings, sections, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), sector2[64:192]) {
+		t.Fatal("read returned wrong data")
+	}
+	if roots, err := sess.SectorRoots(ctx, settings, 0, 2); err != nil {
+		t.Fatal(err)
+	} else if len(roots) != 2 || roots[0] != root1 || roots[1] != root2 {
+		t.Fatal("wrong sector roots")
+	}
+
+	// update the first sector, swap it to the end, and trim it
+	updated := sector1
+	copy(updated[64:], []byte("hello, world!"))
+	updatedRoot := rhp.SectorRoot(&updated)
+	actions = []rhp.RPCWriteAction{
+		{Type: rhp.RPCWriteActionUpdate, A: 0, B: 64, Data: []byte("hello, world!")},
+		{Type: rhp.RPCWriteActionSwap, A: 0, B: 1},
+		{Type: rhp.RPCWriteActionTrim, A: 1},
+	}
+	if err := sess.Write(ctx, settings, actions, []types.Hash256{updatedRoot}); err != nil {
+		t.Fatal(err)
+	} else if roots, _ := contracts.Roots(c.ID); len(roots) != 1 || roots[0] != root2 {
+		t.Fatal("host has wrong roots after write")
+	} else if ok, _ := sectors.Exists(updatedRoot); ok {
+		t.Fatal("trimmed sector should have been deleted")
+	}
+	locked, _ := sess.LockedContract()
+	if hc, _ := contracts.Get(c.ID); hc != locked {
+		t.Fatal("host and renter revisions differ")
+	}
+
+	// an invalid payment should be rejected without affecting the contract
+	cheap := settings
+	cheap.DownloadBandwidthPrice = types.ZeroCurrency
+	if err := sess.Read(ctx, cheap, sections, io.Discard); err == nil {
+		t.Fatal("expected underpayment to be rejected")
+	} else if hc, _ := contracts.Get(c.ID); hc != locked {
+		t.Fatal("host revised contract despite invalid payment")
+	}
+
+	// a failure to read a sector should be reported generically, without
+	// accepting the payment
+	missing := []rhp.RPCReadRequestSection{sections[0], {MerkleRoot: root1, Offset: 0, Length: 64}}
+	if err := sess.Read(ctx, settings, missing, io.Discard); err == nil || err.Error() != "response error: "+errInternal.Error() {
+		t.Fatal("expected internal error, got", err)
+	} else if hc, _ := contracts.Get(c.ID); hc != locked {
+		t.Fatal("host revised contract despite failed read")
+	}
+
+	// renew the contract
+	parent := types.FileContractElement{StateElement: types.StateElement{ID: c.ID}, FileContract: locked.Revision}
+	renewal := locked.Revision
+	renewal.RevisionNumber = 0
+	renewal.WindowStart, renewal.WindowEnd = 300, 400
+	renewal.RenterOutput.Value = types.Siacoins(10)
+	renewal.HostOutput.Value = settings.ContractFee.Add(collateral)
+	renewal.MissedHostValue = renewal.HostOutput.Value
+	renewal.TotalCollateral = collateral
+	renewed, txn, err := sess.RenewContract(ctx, vc, parent, renewal, settings, types.ZeroCurrency, renterWallet)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := renewed.ValidateSignatures(vc); err != nil {
+		t.Fatal(err)
+	} else if hc, err := contracts.Get(renewed.ID); err != nil || hc != renewed {
+		t.Fatal("host did not store renewed contract", err)
+	} else if roots, _ := contracts.Roots(renewed.ID); len(roots) != 1 || roots[0] != root2 {
+		t.Fatal("renewed contract should inherit roots")
+	} else if final, _ := contracts.Get(c.ID); final.Revision.RevisionNumber != types.MaxRevisionNumber {
+		t.Fatal("old contract was not finalized")
+	}
+	r := txn.FileContractResolutions[0].Renewal
+	if !hostKey.PublicKey().VerifyHash(vc.RenewalSigHash(r), r.HostSignature) || !renterKey.PublicKey().VerifyHash(vc.RenewalSigHash(r), r.RenterSignature) {
+		t.Fatal("renewal is not fully signed")
+	}
+
+	// once unlocked, the contract can be locked by another session
+	if err := sess.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	} else if _, err := other.Lock(ctx, vc, c.ID, renterKey, time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
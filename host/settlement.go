@@ -0,0 +1,175 @@
+package host
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+var (
+	// ErrUpdateTooLarge is returned by (*SettlementBuilder).Transactions when
+	// a single revision or resolution cannot fit in a transaction.
+	ErrUpdateTooLarge = errors.New("contract update exceeds the maximum transaction weight")
+)
+
+// A SettlementBuilder batches file contract revisions and resolutions into as
+// few transactions as possible. Batching amortizes the cost of funding each
+// transaction, which dominates the fee of a transaction containing only a
+// single revision or resolution.
+//
+// The transactions produced by the builder are unfunded and unsigned; each has
+// its MinerFee set, and must be funded with exactly that amount, e.g. via
+// Wallet.FundTransaction, before being signed and broadcast.
+type SettlementBuilder struct {
+	vc      consensus.ValidationContext
+	feeRate types.Currency
+	budget  types.Currency
+
+	// MaxWeight is the maximum weight of each transaction, including the
+	// weight reserved for funding it. It defaults to a tenth of the maximum
+	// block weight, so that settlement transactions can share blocks with
+	// other transactions.
+	MaxWeight uint64
+
+	fundingWeight uint64
+	revisions     []types.FileContractRevision
+	resolutions   []types.FileContractResolution
+}
+
+// AddRevision adds a file contract revision to the batch.
+func (sb *SettlementBuilder) AddRevision(rev types.FileContractRevision) {
+	sb.revisions = append(sb.revisions, rev)
+}
+
+// AddResolution adds a file contract resolution to the batch.
+func (sb *SettlementBuilder) AddResolution(res types.FileContractResolution) {
+	sb.resolutions = append(sb.resolutions, res)
+}
+
+// IncreaseBudget increases the total fee that the builder may spend.
+func (sb *SettlementBuilder) IncreaseBudget(amount types.Currency) {
+	sb.budget = sb.budget.Add(amount)
+}
+
+// Pending returns the number of revisions and resolutions that have not yet
+// been included in a transaction.
+func (sb *SettlementBuilder) Pending() int {
+	return len(sb.revisions) + len(sb.resolutions)
+}
+
+// Transactions batches the pending revisions and resolutions into
+// transactions. Resolutions are included before revisions, and within each
+// kind, contracts whose proof windows end soonest are included first.
+//
+// Each transaction's fee is its weight, plus the weight reserved for funding
+// it, multiplied by the builder's fee rate. If the total fee would exceed the
+// builder's budget, the remaining revisions and resolutions are left pending,
+// and can be included in a later call after calling IncreaseBudget.
+//
+// A revision or resolution that exceeds MaxWeight on its own can never be
+// included, so it is dropped rather than left pending. In that case,
+// Transactions returns the transactions built from the other updates, along
+// with an error wrapping ErrUpdateTooLarge that identifies the dropped
+// contracts.
+func (sb *SettlementBuilder) Transactions() ([]types.Transaction, error) {
+	seen := make(map[types.ElementID]bool)
+	for _, rev := range sb.revisions {
+		if seen[rev.Parent.ID] {
+			return nil, fmt.Errorf("contract %v is updated more than once", rev.Parent.ID)
+		}
+		seen[rev.Parent.ID] = true
+	}
+	for _, res := range sb.resolutions {
+		if seen[res.Parent.ID] {
+			return nil, fmt.Errorf("contract %v is updated more than once", res.Parent.ID)
+		}
+		seen[res.Parent.ID] = true
+	}
+	sort.SliceStable(sb.resolutions, func(i, j int) bool {
+		return sb.resolutions[i].Parent.WindowEnd < sb.resolutions[j].Parent.WindowEnd
+	})
+	sort.SliceStable(sb.revisions, func(i, j int) bool {
+		return sb.revisions[i].Parent.WindowEnd < sb.revisions[j].Parent.WindowEnd
+	})
+
+	fee := func(txn types.Transaction) types.Currency {
+		return sb.feeRate.Mul64(sb.vc.TransactionWeight(txn) + sb.fundingWeight)
+	}
+	var txns []types.Transaction
+	finish := func(txn types.Transaction) {
+		txn.MinerFee = fee(txn)
+		sb.budget = sb.budget.Sub(txn.MinerFee)
+		txns = append(txns, txn)
+	}
+
+	var dropped []types.ElementID
+	var txn types.Transaction
+	for sb.Pending() > 0 {
+		// NOTE: the full slice expressions force append to copy, leaving txn
+		// unmodified
+		candidate := txn
+		if len(sb.resolutions) > 0 {
+			candidate.FileContractResolutions = append(txn.FileContractResolutions[:len(txn.FileContractResolutions):len(txn.FileContractResolutions)], sb.resolutions[0])
+		} else {
+			candidate.FileContractRevisions = append(txn.FileContractRevisions[:len(txn.FileContractRevisions):len(txn.FileContractRevisions)], sb.revisions[0])
+		}
+		empty := len(txn.FileContractResolutions)+len(txn.FileContractRevisions) == 0
+		if sb.vc.TransactionWeight(candidate)+sb.fundingWeight > sb.MaxWeight {
+			if empty {
+				if len(sb.resolutions) > 0 {
+					dropped = append(dropped, sb.resolutions[0].Parent.ID)
+					sb.resolutions = sb.resolutions[1:]
+				} else {
+					dropped = append(dropped, sb.revisions[0].Parent.ID)
+					sb.revisions = sb.revisions[1:]
+				}
+				continue
+			}
+			finish(txn)
+			txn = types.Transaction{}
+			continue
+		} else if fee(candidate).Cmp(sb.budget) > 0 {
+			break
+		}
+		txn = candidate
+		if len(sb.resolutions) > 0 {
+			sb.resolutions = sb.resolutions[1:]
+		} else {
+			sb.revisions = sb.revisions[1:]
+		}
+	}
+	if len(txn.FileContractResolutions)+len(txn.FileContractRevisions) > 0 {
+		finish(txn)
+	}
+	if len(dropped) > 0 {
+		return txns, fmt.Errorf("%w (%v): dropped updates to contracts %v", ErrUpdateTooLarge, sb.MaxWeight, dropped)
+	}
+	return txns, nil
+}
+
+// NewSettlementBuilder returns a SettlementBuilder for transactions that will
+// be validated under vc. The fee rate is specified per unit of transaction
+// weight, and the budget caps the total fee paid across all transactions.
+func NewSettlementBuilder(vc consensus.ValidationContext, feeRate, budget types.Currency) *SettlementBuilder {
+	// reserve enough weight for a siacoin input with a maximal Merkle proof
+	// and a single signature, plus a change output
+	funding := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      types.SiacoinElement{StateElement: types.StateElement{MerkleProof: make([]types.Hash256, 64)}},
+			SpendPolicy: types.PolicyPublicKey{},
+			Signatures:  make([]types.Signature, 1),
+		}},
+		SiacoinOutputs: make([]types.SiacoinOutput, 1),
+		MinerFee:       types.NewCurrency(^uint64(0), ^uint64(0)),
+	}
+	return &SettlementBuilder{
+		vc:            vc,
+		feeRate:       feeRate,
+		budget:        budget,
+		MaxWeight:     vc.MaxBlockWeight() / 10,
+		fundingWeight: vc.TransactionWeight(funding) - vc.TransactionWeight(types.Transaction{}),
+	}
+}
@@ -0,0 +1,101 @@
+package host
+
+import (
+	"errors"
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestSettlementBuilder(t *testing.T) {
+	vc := consensus.GenesisUpdate(types.Block{}, types.Work{NumHashes: [32]byte{31: 1}}).Context
+	contract := func(i int) types.FileContractElement {
+		return types.FileContractElement{
+			StateElement: types.StateElement{
+				ID:          types.ElementID{Index: uint64(i)},
+				MerkleProof: make([]types.Hash256, 20),
+			},
+			FileContract: types.FileContract{WindowEnd: uint64(1000 - i)},
+		}
+	}
+
+	feeRate := types.NewCurrency64(10)
+	sb := NewSettlementBuilder(vc, feeRate, types.Siacoins(1))
+	const n = 1000
+	for i := 0; i < n/2; i++ {
+		sb.AddResolution(types.FileContractResolution{Parent: contract(i)})
+	}
+	for i := n / 2; i < n; i++ {
+		sb.AddRevision(types.FileContractRevision{Parent: contract(i), Revision: contract(i).FileContract})
+	}
+	txns, err := sb.Transactions()
+	if err != nil {
+		t.Fatal(err)
+	} else if sb.Pending() != 0 {
+		t.Fatal("expected all updates to be included, but", sb.Pending(), "are pending")
+	} else if len(txns) >= n/10 {
+		t.Fatal("expected updates to be batched, got", len(txns), "transactions")
+	}
+	var updates int
+	for _, txn := range txns {
+		updates += len(txn.FileContractResolutions) + len(txn.FileContractRevisions)
+		if w := vc.TransactionWeight(txn); w > sb.MaxWeight {
+			t.Fatal("transaction exceeds maximum weight:", w)
+		} else if txn.MinerFee.Cmp(feeRate.Mul64(w)) <= 0 {
+			t.Fatal("fee does not include funding overhead")
+		}
+	}
+	if updates != n {
+		t.Fatal("expected", n, "updates, got", updates)
+	}
+	// contracts expiring soonest should be resolved first
+	res := txns[0].FileContractResolutions
+	if res[0].Parent.WindowEnd > res[1].Parent.WindowEnd {
+		t.Fatal("resolutions not ordered by expiration")
+	}
+
+	// a small budget should leave updates pending
+	sb = NewSettlementBuilder(vc, feeRate, feeRate.Mul64(sb.MaxWeight/2))
+	for i := 0; i < n; i++ {
+		sb.AddResolution(types.FileContractResolution{Parent: contract(i)})
+	}
+	txns, err = sb.Transactions()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(txns) != 1 || sb.Pending() == 0 {
+		t.Fatal("expected budget to limit batch, got", len(txns), "transactions and", sb.Pending(), "pending")
+	} else if txns[0].MinerFee.Cmp(feeRate.Mul64(sb.MaxWeight/2)) > 0 {
+		t.Fatal("fee exceeds budget")
+	}
+	sb.IncreaseBudget(types.Siacoins(1))
+	if txns, err := sb.Transactions(); err != nil {
+		t.Fatal(err)
+	} else if len(txns) == 0 || sb.Pending() != 0 {
+		t.Fatal("expected remaining updates to be included after increasing budget")
+	}
+
+	// updating the same contract twice is an error
+	sb = NewSettlementBuilder(vc, feeRate, types.Siacoins(1))
+	sb.AddResolution(types.FileContractResolution{Parent: contract(0)})
+	sb.AddRevision(types.FileContractRevision{Parent: contract(0)})
+	if _, err := sb.Transactions(); err == nil {
+		t.Fatal("expected error for duplicate contract")
+	}
+
+	// an update too large for any transaction should be dropped, without
+	// blocking the others
+	sb = NewSettlementBuilder(vc, feeRate, types.Siacoins(1))
+	huge := contract(0)
+	huge.MerkleProof = make([]types.Hash256, sb.MaxWeight/32)
+	sb.AddResolution(types.FileContractResolution{Parent: huge})
+	sb.AddResolution(types.FileContractResolution{Parent: contract(1)})
+	txns, err = sb.Transactions()
+	if !errors.Is(err, ErrUpdateTooLarge) {
+		t.Fatal("expected ErrUpdateTooLarge, got", err)
+	} else if len(txns) != 1 || len(txns[0].FileContractResolutions) != 1 || txns[0].FileContractResolutions[0].Parent.ID != contract(1).ID {
+		t.Fatal("expected remaining update to be included")
+	} else if sb.Pending() != 0 {
+		t.Fatal("expected oversized update to be dropped, but", sb.Pending(), "are pending")
+	}
+}
@@ -0,0 +1,11 @@
+// Package assert provides runtime invariant checks. Assertions are only
+// enforced when built with the paranoid tag (go test -tags paranoid);
+// otherwise they compile to nothing.
+//
+// Arguments to That are evaluated even when assertions are disabled, so
+// expensive checks should be guarded by Enabled:
+//
+//	if assert.Enabled {
+//		assert.That(expensiveCheck(), "expensive check failed")
+//	}
+package assert
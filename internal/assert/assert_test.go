@@ -0,0 +1,14 @@
+package assert
+
+import "testing"
+
+func TestThat(t *testing.T) {
+	That(true, "should not panic")
+
+	defer func() {
+		if r := recover(); (r != nil) != Enabled {
+			t.Fatalf("expected panic: %v, got %v", Enabled, r)
+		}
+	}()
+	That(false, "invariant %v violated", 1)
+}
@@ -0,0 +1,10 @@
+//go:build !paranoid
+// +build !paranoid
+
+package assert
+
+// Enabled reports whether assertions are enforced.
+const Enabled = false
+
+// That is a no-op unless the paranoid build tag is set.
+func That(cond bool, format string, args ...interface{}) {}
@@ -0,0 +1,16 @@
+//go:build paranoid
+// +build paranoid
+
+package assert
+
+import "fmt"
+
+// Enabled reports whether assertions are enforced.
+const Enabled = true
+
+// That panics with the formatted message if cond is false.
+func That(cond bool, format string, args ...interface{}) {
+	if !cond {
+		panic("assertion failed: " + fmt.Sprintf(format, args...))
+	}
+}
@@ -76,6 +76,59 @@ func NewEphemeralStore(c consensus.Checkpoint) *EphemeralStore {
 	}
 }
 
+// EphemeralIndexStore implements chain.IndexStore in memory.
+type EphemeralIndexStore struct {
+	heights map[types.Hash256]uint64
+	tip     types.ChainIndex
+}
+
+// AddIDs implements chain.IndexStore.
+func (s *EphemeralIndexStore) AddIDs(index types.ChainIndex, ids []types.Hash256) error {
+	for _, id := range ids {
+		s.heights[id] = index.Height
+	}
+	s.tip = index
+	return nil
+}
+
+// RemoveIDs implements chain.IndexStore.
+func (s *EphemeralIndexStore) RemoveIDs(parent types.ChainIndex, ids []types.Hash256) error {
+	for _, id := range ids {
+		delete(s.heights, id)
+	}
+	s.tip = parent
+	return nil
+}
+
+// Height implements chain.IndexStore.
+func (s *EphemeralIndexStore) Height(id types.Hash256) (uint64, bool, error) {
+	height, ok := s.heights[id]
+	return height, ok, nil
+}
+
+// ForEach implements chain.IndexStore.
+func (s *EphemeralIndexStore) ForEach(fn func(id types.Hash256)) error {
+	for id := range s.heights {
+		fn(id)
+	}
+	return nil
+}
+
+// Tip implements chain.IndexStore.
+func (s *EphemeralIndexStore) Tip() (types.ChainIndex, error) { return s.tip, nil }
+
+// Flush implements chain.IndexStore.
+func (s *EphemeralIndexStore) Flush() error { return nil }
+
+// NewEphemeralIndexStore returns an in-memory chain.IndexStore whose tip is
+// the provided index.
+func NewEphemeralIndexStore(tip types.ChainIndex) *EphemeralIndexStore {
+	return &EphemeralIndexStore{
+		heights: make(map[types.Hash256]uint64),
+		tip:     tip,
+	}
+}
+
 type metadata struct {
 	indexSize int64
 	entrySize int64
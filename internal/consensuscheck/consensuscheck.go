@@ -0,0 +1,243 @@
+// Package consensuscheck enforces the //core:consensus annotation, which marks
+// declarations whose behavior is consensus-critical: changing them may cause
+// nodes to disagree about the validity of blocks. Annotated declarations may
+// only appear in CriticalPackages, and the signatures of exported annotated
+// declarations can be listed so that changes to them are caught in review.
+package consensuscheck
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Marker is the comment that annotates a consensus-critical declaration. It
+// must appear in the declaration's doc comment.
+const Marker = "//core:consensus"
+
+// CriticalPackages are the packages, relative to the module root, that may
+// contain consensus-critical code.
+var CriticalPackages = []string{"consensus", "merkle", "types"}
+
+// A Decl is an annotated declaration.
+type Decl struct {
+	Package   string // relative to the module root
+	Name      string // e.g. "ValidationContext.ValidateBlock"
+	Signature string
+	Pos       token.Position
+}
+
+// String implements fmt.Stringer.
+func (d Decl) String() string {
+	return d.Package + ": " + d.Signature
+}
+
+func isCritical(pkg string) bool {
+	for _, p := range CriticalPackages {
+		if p == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// stripNames returns a copy of fl without parameter names, so that renaming a
+// parameter does not change a signature.
+func stripNames(fl *ast.FieldList) *ast.FieldList {
+	if fl == nil {
+		return nil
+	}
+	stripped := &ast.FieldList{}
+	for _, f := range fl.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			stripped.List = append(stripped.List, &ast.Field{Type: f.Type})
+		}
+	}
+	return stripped
+}
+
+func recvName(fd *ast.FuncDecl) string {
+	if fd.Recv == nil || len(fd.Recv.List) == 0 {
+		return ""
+	}
+	t := fd.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+func signature(fset *token.FileSet, node ast.Node) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, fset, node); err != nil {
+		panic(err) // should never happen
+	}
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+func hasMarker(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if c.Text == Marker {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFile returns the annotated declarations in f, and an error if f contains
+// a marker that is not attached to a declaration.
+func checkFile(fset *token.FileSet, pkg string, f *ast.File) ([]Decl, error) {
+	attached := make(map[*ast.Comment]bool)
+	attach := func(doc *ast.CommentGroup) {
+		if doc != nil {
+			for _, c := range doc.List {
+				attached[c] = true
+			}
+		}
+	}
+
+	var decls []Decl
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if !hasMarker(d.Doc) {
+				continue
+			}
+			attach(d.Doc)
+			name := d.Name.Name
+			if recv := recvName(d); recv != "" {
+				name = recv + "." + name
+			}
+			fn := &ast.FuncDecl{
+				Recv: stripNames(d.Recv),
+				Name: d.Name,
+				Type: &ast.FuncType{
+					Params:  stripNames(d.Type.Params),
+					Results: stripNames(d.Type.Results),
+				},
+			}
+			decls = append(decls, Decl{
+				Package:   pkg,
+				Name:      name,
+				Signature: signature(fset, fn),
+				Pos:       fset.Position(d.Pos()),
+			})
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				doc := ts.Doc
+				if doc == nil && len(d.Specs) == 1 {
+					doc = d.Doc
+				}
+				if !hasMarker(doc) {
+					continue
+				}
+				attach(doc)
+				// field comments are not part of the signature
+				ast.Inspect(ts.Type, func(n ast.Node) bool {
+					if f, ok := n.(*ast.Field); ok {
+						f.Doc, f.Comment = nil, nil
+					}
+					return true
+				})
+				decls = append(decls, Decl{
+					Package:   pkg,
+					Name:      ts.Name.Name,
+					Signature: "type " + signature(fset, &ast.TypeSpec{Name: ts.Name, Type: ts.Type}),
+					Pos:       fset.Position(ts.Pos()),
+				})
+			}
+		}
+	}
+
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if c.Text == Marker && !attached[c] {
+				return nil, fmt.Errorf("%v: %v is not attached to a function or type declaration", fset.Position(c.Pos()), Marker)
+			}
+		}
+	}
+	if len(decls) > 0 && !isCritical(pkg) {
+		return nil, fmt.Errorf("%v: %v used outside of a consensus-critical package", decls[0].Pos, Marker)
+	}
+	return decls, nil
+}
+
+// Check parses the non-test Go files of the module rooted at dir, returning
+// the annotated declarations, sorted by package and name. It returns an error
+// if a marker is detached from a declaration or appears outside
+// CriticalPackages.
+func Check(dir string) ([]Decl, error) {
+	fset := token.NewFileSet()
+	var decls []Decl
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		} else if info.IsDir() {
+			if path != dir && (strings.HasPrefix(info.Name(), ".") || info.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		pkg, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		fileDecls, err := checkFile(fset, filepath.ToSlash(pkg), f)
+		if err != nil {
+			return err
+		}
+		decls = append(decls, fileDecls...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(decls, func(i, j int) bool {
+		if decls[i].Package != decls[j].Package {
+			return decls[i].Package < decls[j].Package
+		}
+		return decls[i].Name < decls[j].Name
+	})
+	return decls, nil
+}
+
+// API returns the signatures of the exported declarations in decls, one per
+// line, suitable for comparing against a golden file.
+func API(decls []Decl) string {
+	var sb strings.Builder
+	for _, d := range decls {
+		if !ast.IsExported(d.Name[strings.LastIndexByte(d.Name, '.')+1:]) {
+			continue
+		}
+		sb.WriteString(d.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
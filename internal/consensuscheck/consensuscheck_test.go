@@ -0,0 +1,112 @@
+package consensuscheck
+
+import (
+	"flag"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/consensus"
+)
+
+var updateAPI = flag.Bool("update", false, "update testdata/api.txt")
+
+func TestCheckFile(t *testing.T) {
+	tests := []struct {
+		pkg   string
+		src   string
+		decls []string
+		err   string
+	}{
+		{
+			pkg: "consensus",
+			src: `package consensus
+// Foo does a thing.
+//
+//core:consensus
+func (f *Foo) Foo(a, b int, c string) (err error) { return nil }
+
+//core:consensus
+type Bar struct{ X int }
+
+func Baz() {}
+`,
+			decls: []string{"func (*Foo) Foo(int, int, string) error", "type Bar struct{ X int }"},
+		},
+		{
+			pkg: "consensus",
+			src: `package consensus
+//core:consensus
+
+func Foo() {}
+`,
+			err: "not attached",
+		},
+		{
+			pkg: "wallet",
+			src: `package wallet
+//core:consensus
+func Foo() {}
+`,
+			err: "outside of a consensus-critical package",
+		},
+	}
+	for _, test := range tests {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, "test.go", test.src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decls, err := checkFile(fset, test.pkg, f)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		} else if len(decls) != len(test.decls) {
+			t.Fatalf("expected %v decls, got %v", len(test.decls), len(decls))
+		}
+		for i := range decls {
+			if decls[i].Signature != test.decls[i] {
+				t.Errorf("expected signature %q, got %q", test.decls[i], decls[i].Signature)
+			}
+		}
+	}
+}
+
+// TestAPICompat fails if the signature of an exported consensus-critical
+// declaration changes without consensus.APIVersion being incremented. After
+// incrementing it, run go test -update to regenerate testdata/api.txt.
+func TestAPICompat(t *testing.T) {
+	decls, err := Check(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := fmt.Sprintf("version %v\n%v", consensus.APIVersion, API(decls))
+
+	path := filepath.Join("testdata", "api.txt")
+	if *updateAPI {
+		if err := os.WriteFile(path, []byte(api), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(golden) == api {
+		return
+	}
+	var goldenVersion int
+	fmt.Sscanf(string(golden), "version %d", &goldenVersion)
+	if goldenVersion == consensus.APIVersion {
+		t.Fatalf("consensus-critical API changed; increment consensus.APIVersion and run go test -update\n\nexpected:\n%v\ngot:\n%v", string(golden), api)
+	}
+	t.Fatal("consensus.APIVersion changed; run go test -update")
+}
@@ -151,7 +151,7 @@ func (acc *Accumulator) UnmarshalJSON(b []byte) error {
 	acc.NumLeaves = v.NumLeaves
 	for i := range acc.Trees {
 		if acc.hasTreeAtHeight(i) {
-			acc.Trees[i] = v.Trees[i]
+			acc.Trees[i] = v.Trees[0]
 			v.Trees = v.Trees[1:]
 		}
 	}
@@ -453,6 +453,41 @@ func (acc *HistoryAccumulator) ApplyBlock(index types.ChainIndex) (hau HistoryAp
 	return
 }
 
+// HistoryProofs computes history proofs for the indexes at the specified
+// heights. history must contain every index in the chain, beginning with the
+// genesis block; the resulting proofs are valid for the HistoryAccumulator
+// containing exactly those indexes.
+func HistoryProofs(history []types.ChainIndex, heights []uint64) [][]types.Hash256 {
+	// compute every level of the tree(s); nodes that would span multiple
+	// trees are never produced, since the leaves remaining after the last
+	// full node at a given level always number fewer than 2^level
+	levels := [][]types.Hash256{make([]types.Hash256, len(history))}
+	for i, index := range history {
+		levels[0][i] = historyLeafHash(index)
+	}
+	for prev := levels[0]; len(prev) > 1; prev = levels[len(levels)-1] {
+		level := make([]types.Hash256, len(prev)/2)
+		for i := range level {
+			level[i] = NodeHash(prev[2*i], prev[2*i+1])
+		}
+		levels = append(levels, level)
+	}
+
+	numLeaves := uint64(len(history))
+	proofs := make([][]types.Hash256, len(heights))
+	for i, height := range heights {
+		if height >= numLeaves {
+			panic("merkle: history proof height exceeds number of leaves") // developer error
+		}
+		proof := make([]types.Hash256, mergeHeight(height, numLeaves)-1)
+		for j := range proof {
+			proof[j] = levels[j][(height>>j)^1]
+		}
+		proofs[i] = proof
+	}
+	return proofs
+}
+
 // RevertBlock produces a HistoryRevertUpdate from a ChainIndex.
 func (acc *HistoryAccumulator) RevertBlock(index types.ChainIndex) HistoryRevertUpdate {
 	return HistoryRevertUpdate{index}
@@ -325,6 +325,28 @@ func TestHistoryAccumulator(t *testing.T) {
 	}
 }
 
+func TestHistoryProofs(t *testing.T) {
+	blocks := make([]types.ChainIndex, 37)
+	for i := range blocks {
+		blocks[i].Height = uint64(i)
+		frand.Read(blocks[i].ID[:])
+	}
+	for n := 1; n <= len(blocks); n++ {
+		var acc HistoryAccumulator
+		heights := make([]uint64, n)
+		for i, index := range blocks[:n] {
+			acc.ApplyBlock(index)
+			heights[i] = uint64(i)
+		}
+		proofs := HistoryProofs(blocks[:n], heights)
+		for i, index := range blocks[:n] {
+			if !acc.Contains(index, proofs[i]) {
+				t.Fatalf("invalid proof for block %v of %v", i, n)
+			}
+		}
+	}
+}
+
 func TestMultiproof(t *testing.T) {
 	outputs := make([]types.SiacoinElement, 8)
 	leaves := make([]types.Hash256, len(outputs))
@@ -394,6 +416,71 @@ func TestMultiproof(t *testing.T) {
 	}
 }
 
+func TestBuildMultiproof(t *testing.T) {
+	// 13 leaves, so that the accumulator contains trees of height 3, 2, and 0
+	leaves := make([]ElementLeaf, 13)
+	for i := range leaves {
+		var sce types.SiacoinElement
+		sce.ID.Index = uint64(i)
+		leaves[i] = SiacoinLeaf(sce, i%2 == 0)
+	}
+	var acc ElementAccumulator
+	acc.addLeaves(leaves)
+
+	for _, subset := range [][]int{
+		{0},
+		{12},
+		{3, 4, 5},
+		{11, 0, 7, 8},
+		{1, 1, 9},
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	} {
+		var ls []ElementLeaf
+		var individual int
+		for _, i := range subset {
+			ls = append(ls, leaves[i])
+			individual += len(leaves[i].MerkleProof)
+		}
+		proof := BuildMultiproof(ls)
+		if len(proof) > individual {
+			t.Errorf("multiproof for %v (%v hashes) larger than individual proofs (%v hashes)", subset, len(proof), individual)
+		}
+		// strip the individual proofs; they should not be needed
+		for i := range ls {
+			ls[i].MerkleProof = nil
+		}
+		if !VerifyMultiproof(acc.Accumulator, ls, proof) {
+			t.Fatalf("multiproof for %v did not verify", subset)
+		}
+
+		// tampering with the leaves or proof should cause verification to fail
+		bad := append([]ElementLeaf(nil), ls...)
+		bad[0].Spent = !bad[0].Spent
+		if VerifyMultiproof(acc.Accumulator, bad, proof) {
+			t.Fatalf("multiproof for %v verified with modified leaf", subset)
+		}
+		if len(proof) > 0 {
+			badProof := append([]types.Hash256(nil), proof...)
+			badProof[0][0] ^= 1
+			if VerifyMultiproof(acc.Accumulator, ls, badProof) {
+				t.Fatalf("multiproof for %v verified with modified proof", subset)
+			} else if VerifyMultiproof(acc.Accumulator, ls, proof[1:]) {
+				t.Fatalf("multiproof for %v verified with truncated proof", subset)
+			}
+		}
+		if VerifyMultiproof(acc.Accumulator, ls, append(proof, types.Hash256{})) {
+			t.Fatalf("multiproof for %v verified with extra proof hash", subset)
+		}
+	}
+
+	// leaves outside the accumulator are rejected
+	outside := leaves[0]
+	outside.LeafIndex = acc.NumLeaves
+	if VerifyMultiproof(acc.Accumulator, []ElementLeaf{outside}, nil) {
+		t.Fatal("multiproof verified for leaf outside accumulator")
+	}
+}
+
 func BenchmarkSiacoinLeafHash(b *testing.B) {
 	var o types.SiacoinElement
 	for i := 0; i < b.N; i++ {
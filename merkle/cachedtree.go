@@ -0,0 +1,175 @@
+package merkle
+
+import (
+	"math/bits"
+
+	"go.sia.tech/core/types"
+)
+
+// A CachedTree maintains the Merkle root of an ordered list of leaf hashes,
+// such as the sector roots of a file contract. It caches the roots of complete
+// subtrees, so that appending, updating, or truncating leaves only requires
+// rehashing O(log n) nodes, rather than the entire tree.
+//
+// Only subtrees of at least 2^cacheHeight leaves are cached, bounding the
+// memory used by the cache to roughly 2/2^cacheHeight times that of the leaves
+// themselves. In exchange, computing the root of an uncached subtree requires
+// up to 2^cacheHeight hashes.
+type CachedTree struct {
+	leaves      []types.Hash256
+	cacheHeight int
+	// levels[i] holds the roots of the complete subtrees of height
+	// cacheHeight+i, in order.
+	levels [][]types.Hash256
+}
+
+// perfectRoot returns the root of a perfect tree containing the given leaves.
+func perfectRoot(leaves []types.Hash256) types.Hash256 {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	mid := len(leaves) / 2
+	return NodeHash(perfectRoot(leaves[:mid]), perfectRoot(leaves[mid:]))
+}
+
+// subtreeRoot returns the root of the perfect subtree of the given height
+// whose leftmost leaf is at index start.
+func (t *CachedTree) subtreeRoot(start uint64, height int) types.Hash256 {
+	if height >= t.cacheHeight {
+		return t.levels[height-t.cacheHeight][start>>height]
+	}
+	return perfectRoot(t.leaves[start:][:1<<height])
+}
+
+// recache recomputes the cached subtree roots containing leaf i, creating
+// them if they were completed by the leaf.
+func (t *CachedTree) recache(i uint64) {
+	chunk := uint64(1) << t.cacheHeight
+	start := i &^ (chunk - 1)
+	if start+chunk > uint64(len(t.leaves)) {
+		return // incomplete
+	}
+	root := perfectRoot(t.leaves[start:][:chunk])
+	j := start >> t.cacheHeight
+	for level := 0; ; level++ {
+		if level == len(t.levels) {
+			t.levels = append(t.levels, nil)
+		}
+		if j < uint64(len(t.levels[level])) {
+			t.levels[level][j] = root
+		} else {
+			t.levels[level] = append(t.levels[level], root)
+		}
+		// move to the parent, if it is complete
+		sibling := j ^ 1
+		if sibling >= uint64(len(t.levels[level])) {
+			return
+		}
+		if j&1 == 0 {
+			root = NodeHash(root, t.levels[level][sibling])
+		} else {
+			root = NodeHash(t.levels[level][sibling], root)
+		}
+		j >>= 1
+	}
+}
+
+// Len returns the number of leaves in the tree.
+func (t *CachedTree) Len() int { return len(t.leaves) }
+
+// Leaf returns the leaf at index i.
+func (t *CachedTree) Leaf(i uint64) types.Hash256 { return t.leaves[i] }
+
+// Leaves returns the leaves of the tree. The returned slice must not be
+// modified.
+func (t *CachedTree) Leaves() []types.Hash256 { return t.leaves }
+
+// Append appends a leaf to the tree.
+func (t *CachedTree) Append(h types.Hash256) {
+	t.leaves = append(t.leaves, h)
+	t.recache(uint64(len(t.leaves) - 1))
+}
+
+// Update replaces the leaf at index i.
+func (t *CachedTree) Update(i uint64, h types.Hash256) {
+	t.leaves[i] = h
+	t.recache(i)
+}
+
+// Swap swaps the leaves at indices i and j.
+func (t *CachedTree) Swap(i, j uint64) {
+	t.leaves[i], t.leaves[j] = t.leaves[j], t.leaves[i]
+	t.recache(i)
+	t.recache(j)
+}
+
+// Truncate removes all but the first n leaves from the tree.
+func (t *CachedTree) Truncate(n uint64) {
+	if n > uint64(len(t.leaves)) {
+		panic("cannot truncate to more leaves than the tree contains") // developer error
+	}
+	t.leaves = t.leaves[:n]
+	for i := range t.levels {
+		t.levels[i] = t.levels[i][:n>>(t.cacheHeight+i)]
+	}
+}
+
+// Root returns the Merkle root of the tree.
+func (t *CachedTree) Root() types.Hash256 {
+	// the tree comprises one perfect subtree for each set bit of its leaf
+	// count, largest first; merge them from smallest to largest
+	n := uint64(len(t.leaves))
+	if n == 0 {
+		return types.Hash256{}
+	}
+	height := bits.TrailingZeros64(n)
+	start := clearBits(n, height+1)
+	root := t.subtreeRoot(start, height)
+	for height++; height < 64; height++ {
+		if n&(1<<height) != 0 {
+			start = clearBits(n, height+1)
+			root = NodeHash(t.subtreeRoot(start, height), root)
+		}
+	}
+	return root
+}
+
+// RangeProof returns a proof that the leaves in [start, end) are present in
+// the tree. The proof consists of the roots of the largest aligned subtrees to
+// the left of the range, in order, followed by those to the right.
+func (t *CachedTree) RangeProof(start, end uint64) []types.Hash256 {
+	if start >= end || end > uint64(len(t.leaves)) {
+		panic("invalid proof range") // developer error
+	}
+	var proof []types.Hash256
+	appendSubtrees := func(i, j uint64) {
+		for i < j {
+			// largest aligned subtree starting at i that does not pass j
+			height := bits.TrailingZeros64(i)
+			if max := bits.Len64(j-i) - 1; height > max {
+				height = max
+			}
+			proof = append(proof, t.subtreeRoot(i, height))
+			i += 1 << height
+		}
+	}
+	appendSubtrees(0, start)
+	appendSubtrees(end, uint64(len(t.leaves)))
+	return proof
+}
+
+// NewCachedTree returns a CachedTree containing the given leaves, caching
+// subtrees of at least 2^cacheHeight leaves.
+func NewCachedTree(leaves []types.Hash256, cacheHeight int) *CachedTree {
+	if cacheHeight < 0 || cacheHeight > 63 {
+		panic("invalid cache height") // developer error
+	}
+	t := &CachedTree{
+		leaves:      make([]types.Hash256, 0, len(leaves)),
+		cacheHeight: cacheHeight,
+	}
+	for _, h := range leaves {
+		t.Append(h)
+	}
+	return t
+}
@@ -0,0 +1,95 @@
+package merkle_test
+
+import (
+	"math/bits"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/merkle"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/types"
+
+	"lukechampine.com/frand"
+)
+
+func TestCachedTree(t *testing.T) {
+	for _, cacheHeight := range []int{0, 1, 4} {
+		var leaves []types.Hash256
+		tree := merkle.NewCachedTree(nil, cacheHeight)
+		check := func(op string) {
+			t.Helper()
+			if tree.Len() != len(leaves) || !reflect.DeepEqual(tree.Leaves(), leaves) && len(leaves) > 0 {
+				t.Fatalf("cacheHeight %v: leaves mismatch after %v", cacheHeight, op)
+			} else if tree.Root() != rhp.MetaRoot(leaves) {
+				t.Fatalf("cacheHeight %v: root mismatch after %v (%v leaves)", cacheHeight, op, len(leaves))
+			}
+		}
+
+		check("init")
+		for i := 0; i < 300; i++ {
+			switch r := frand.Intn(10); {
+			case r < 6 || len(leaves) == 0:
+				h := frand.Entropy256()
+				leaves = append(leaves, h)
+				tree.Append(h)
+				check("append")
+			case r < 8:
+				i := frand.Uint64n(uint64(len(leaves)))
+				h := frand.Entropy256()
+				leaves[i] = h
+				tree.Update(i, h)
+				check("update")
+			case r < 9:
+				i, j := frand.Uint64n(uint64(len(leaves))), frand.Uint64n(uint64(len(leaves)))
+				leaves[i], leaves[j] = leaves[j], leaves[i]
+				tree.Swap(i, j)
+				check("swap")
+			default:
+				n := frand.Uint64n(uint64(len(leaves)))
+				leaves = leaves[:n]
+				tree.Truncate(n)
+				check("truncate")
+			}
+		}
+
+		// a freshly-constructed tree should be equivalent
+		if merkle.NewCachedTree(leaves, cacheHeight).Root() != tree.Root() {
+			t.Fatal("NewCachedTree root mismatch")
+		}
+
+		// check range proofs against a naive implementation
+		if len(leaves) == 0 {
+			continue
+		}
+		naiveProof := func(start, end uint64) (proof []types.Hash256) {
+			add := func(i, j uint64) {
+				for i < j {
+					size := uint64(1) << (bits.Len64(j-i) - 1)
+					if tz := uint64(1) << bits.TrailingZeros64(i); i != 0 && tz < size {
+						size = tz
+					}
+					proof = append(proof, rhp.MetaRoot(leaves[i:i+size]))
+					i += size
+				}
+			}
+			add(0, start)
+			add(end, uint64(len(leaves)))
+			return
+		}
+		for i := 0; i < 20; i++ {
+			start := frand.Uint64n(uint64(len(leaves)))
+			end := start + 1 + frand.Uint64n(uint64(len(leaves))-start)
+			if !reflect.DeepEqual(tree.RangeProof(start, end), naiveProof(start, end)) {
+				t.Fatalf("range proof mismatch for [%v, %v)", start, end)
+			}
+		}
+	}
+}
+
+func BenchmarkCachedTreeAppend(b *testing.B) {
+	tree := merkle.NewCachedTree(nil, 4)
+	for i := 0; i < b.N; i++ {
+		tree.Append(types.Hash256{})
+		_ = tree.Root()
+	}
+}
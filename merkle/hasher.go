@@ -0,0 +1,99 @@
+package merkle
+
+import (
+	"go.sia.tech/core/internal/blake2b"
+	"go.sia.tech/core/types"
+)
+
+// A Hasher computes the leaf and node hashes of sector and accumulator Merkle
+// trees. Implementations must be safe for concurrent use, and must produce
+// the same hashes as DefaultHasher.
+type Hasher interface {
+	// SumLeaf computes the leaf hash of a single 64-byte leaf.
+	SumLeaf(leaf [64]byte) types.Hash256
+	// SumLeaves computes the leaf hashes of four leaves.
+	SumLeaves(leaves [4][64]byte) [4][32]byte
+	// SumPair computes the Merkle root of a pair of node hashes.
+	SumPair(left, right types.Hash256) types.Hash256
+	// SumNodes computes the Merkle roots of four pairs of node hashes.
+	SumNodes(nodes [8][32]byte) [4][32]byte
+}
+
+type blake2bHasher struct{}
+
+func (blake2bHasher) SumLeaf(leaf [64]byte) types.Hash256 { return blake2b.SumLeaf(&leaf) }
+
+func (blake2bHasher) SumLeaves(leaves [4][64]byte) (outs [4][32]byte) {
+	blake2b.SumLeaves(&outs, &leaves)
+	return
+}
+
+func (blake2bHasher) SumPair(left, right types.Hash256) types.Hash256 {
+	return blake2b.SumPair(left, right)
+}
+
+func (blake2bHasher) SumNodes(nodes [8][32]byte) (outs [4][32]byte) {
+	blake2b.SumNodes(&outs, &nodes)
+	return
+}
+
+// DefaultHasher is the built-in BLAKE2b Hasher.
+var DefaultHasher Hasher = blake2bHasher{}
+
+// hasher is the Hasher in use; when it is DefaultHasher, the helpers below
+// call package blake2b directly, avoiding the copies (and, for pointer
+// arguments, heap allocations) of an interface call.
+var (
+	hasher    = DefaultHasher
+	isDefault = true
+)
+
+// SetHasher replaces the Hasher used by this package and by sector Merkle root
+// computations. A nil Hasher restores DefaultHasher. SetHasher is not safe for
+// concurrent use, and should only be called during initialization, before any
+// hashing takes place.
+func SetHasher(h Hasher) {
+	if h == nil {
+		h = DefaultHasher
+	}
+	hasher, isDefault = h, h == DefaultHasher
+}
+
+// CurrentHasher returns the Hasher currently in use.
+func CurrentHasher() Hasher { return hasher }
+
+// NodeHash computes the Merkle root of a pair of node hashes.
+func NodeHash(left, right types.Hash256) types.Hash256 {
+	if isDefault {
+		return blake2b.SumPair(left, right)
+	}
+	return hasher.SumPair(left, right)
+}
+
+// NodeHashes computes the Merkle roots of four pairs of node hashes, storing
+// the results in outs.
+func NodeHashes(outs *[4][32]byte, nodes *[8][32]byte) {
+	if isDefault {
+		blake2b.SumNodes(outs, nodes)
+		return
+	}
+	*outs = hasher.SumNodes(*nodes)
+}
+
+// LeafHash computes the Merkle leaf hash of a single 64-byte leaf.
+func LeafHash(leaf *[64]byte) types.Hash256 {
+	if isDefault {
+		return blake2b.SumLeaf(leaf)
+	}
+	return hasher.SumLeaf(*leaf)
+}
+
+// LeafHashes computes the Merkle leaf hashes of four 64-byte leaves, storing
+// the results in outs.
+func LeafHashes(outs *[4][32]byte, leaves *[4][64]byte) {
+	if isDefault {
+		blake2b.SumLeaves(outs, leaves)
+		return
+	}
+	*outs = hasher.SumLeaves(*leaves)
+}
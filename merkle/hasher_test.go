@@ -0,0 +1,65 @@
+package merkle_test
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"go.sia.tech/core/merkle"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/types"
+
+	"lukechampine.com/frand"
+)
+
+type countingHasher struct {
+	merkle.Hasher
+	calls int64
+}
+
+func (h *countingHasher) SumLeaf(leaf [64]byte) types.Hash256 {
+	atomic.AddInt64(&h.calls, 1)
+	return h.Hasher.SumLeaf(leaf)
+}
+
+func (h *countingHasher) SumLeaves(leaves [4][64]byte) [4][32]byte {
+	atomic.AddInt64(&h.calls, 1)
+	return h.Hasher.SumLeaves(leaves)
+}
+
+func (h *countingHasher) SumPair(left, right types.Hash256) types.Hash256 {
+	atomic.AddInt64(&h.calls, 1)
+	return h.Hasher.SumPair(left, right)
+}
+
+func (h *countingHasher) SumNodes(nodes [8][32]byte) [4][32]byte {
+	atomic.AddInt64(&h.calls, 1)
+	return h.Hasher.SumNodes(nodes)
+}
+
+func TestSetHasher(t *testing.T) {
+	var sector [rhp.SectorSize]byte
+	frand.Read(sector[:256])
+	roots := []types.Hash256{frand.Entropy256(), frand.Entropy256(), frand.Entropy256()}
+	expSector, expMeta := rhp.SectorRoot(&sector), rhp.MetaRoot(roots)
+
+	h := &countingHasher{Hasher: merkle.DefaultHasher}
+	merkle.SetHasher(h)
+	defer merkle.SetHasher(nil)
+	if merkle.CurrentHasher() != h {
+		t.Fatal("hasher was not replaced")
+	}
+	if rhp.SectorRoot(&sector) != expSector {
+		t.Fatal("sector root mismatch")
+	} else if rhp.MetaRoot(roots) != expMeta {
+		t.Fatal("meta root mismatch")
+	} else if merkle.NodeHash(roots[0], roots[1]) != merkle.DefaultHasher.SumPair(roots[0], roots[1]) {
+		t.Fatal("node hash mismatch")
+	} else if h.calls == 0 {
+		t.Fatal("custom hasher was not used")
+	}
+
+	merkle.SetHasher(nil)
+	if merkle.CurrentHasher() != merkle.DefaultHasher {
+		t.Fatal("default hasher was not restored")
+	}
+}
@@ -3,7 +3,6 @@ package merkle
 import (
 	"math/bits"
 
-	"go.sia.tech/core/internal/blake2b"
 	"go.sia.tech/core/types"
 )
 
@@ -20,11 +19,6 @@ func clearBits(x uint64, n int) uint64 { return x &^ (1<<n - 1) }
 // trailingOnes returns the number of trailing one bits in x.
 func trailingOnes(x uint64) int { return bits.TrailingZeros64(x + 1) }
 
-// NodeHash computes the Merkle root of a pair of node hashes.
-func NodeHash(left, right types.Hash256) types.Hash256 {
-	return blake2b.SumPair(left, right)
-}
-
 // ProofRoot returns the Merkle root derived from the supplied leaf hash and
 // Merkle proof.
 func ProofRoot(leafHash types.Hash256, leafIndex uint64, proof []types.Hash256) types.Hash256 {
@@ -2,6 +2,8 @@ package merkle
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"math/bits"
 	"sort"
 
@@ -13,27 +15,34 @@ func splitLeaves(ls []ElementLeaf, mid uint64) (left, right []ElementLeaf) {
 	return ls[:split], ls[split:]
 }
 
-func leavesByTree(txns []types.Transaction) [64][]ElementLeaf {
-	var trees [64][]ElementLeaf
-	addLeaf := func(l ElementLeaf) {
-		trees[len(l.MerkleProof)] = append(trees[len(l.MerkleProof)], l)
-	}
+func transactionLeaves(txns []types.Transaction) (leaves []ElementLeaf) {
 	for _, txn := range txns {
 		for _, in := range txn.SiacoinInputs {
 			if in.Parent.LeafIndex != types.EphemeralLeafIndex {
-				addLeaf(SiacoinLeaf(in.Parent, false))
+				leaves = append(leaves, SiacoinLeaf(in.Parent, false))
 			}
 		}
 		for _, in := range txn.SiafundInputs {
-			addLeaf(SiafundLeaf(in.Parent, false))
+			leaves = append(leaves, SiafundLeaf(in.Parent, false))
 		}
 		for _, rev := range txn.FileContractRevisions {
-			addLeaf(FileContractLeaf(rev.Parent, false))
+			leaves = append(leaves, FileContractLeaf(rev.Parent, false))
 		}
 		for _, res := range txn.FileContractResolutions {
-			addLeaf(FileContractLeaf(res.Parent, false))
+			leaves = append(leaves, FileContractLeaf(res.Parent, false))
 		}
 	}
+	return
+}
+
+// sortLeaves groups leaves by the height of the tree containing them, as
+// reported by height, and sorts each group by leaf index.
+func sortLeaves(leaves []ElementLeaf, height func(ElementLeaf) int) [64][]ElementLeaf {
+	var trees [64][]ElementLeaf
+	for _, l := range leaves {
+		h := height(l)
+		trees[h] = append(trees[h], l)
+	}
 	for _, leaves := range trees {
 		sort.Slice(leaves, func(i, j int) bool {
 			return leaves[i].LeafIndex < leaves[j].LeafIndex
@@ -42,6 +51,10 @@ func leavesByTree(txns []types.Transaction) [64][]ElementLeaf {
 	return trees
 }
 
+func leavesByTree(txns []types.Transaction) [64][]ElementLeaf {
+	return sortLeaves(transactionLeaves(txns), func(l ElementLeaf) int { return len(l.MerkleProof) })
+}
+
 // MultiproofSize computes the size of a multiproof for the given transactions.
 func MultiproofSize(txns []types.Transaction) int {
 	var proofSize func(i, j uint64, leaves []ElementLeaf) int
@@ -70,7 +83,16 @@ func MultiproofSize(txns []types.Transaction) int {
 }
 
 // ComputeMultiproof computes a single Merkle proof for all inputs in txns.
-func ComputeMultiproof(txns []types.Transaction) (proof []types.Hash256) {
+func ComputeMultiproof(txns []types.Transaction) []types.Hash256 {
+	return BuildMultiproof(transactionLeaves(txns))
+}
+
+// BuildMultiproof computes a single Merkle proof for an arbitrary set of
+// leaves, each of which must have a valid proof against the same accumulator.
+// Proof nodes shared by multiple leaves, or derivable from the leaves
+// themselves, are included only once or omitted entirely; the result is thus
+// considerably smaller than the leaves' individual proofs.
+func BuildMultiproof(leaves []ElementLeaf) (proof []types.Hash256) {
 	var visit func(i, j uint64, leaves []ElementLeaf)
 	visit = func(i, j uint64, leaves []ElementLeaf) {
 		height := bits.TrailingZeros64(j - i)
@@ -91,7 +113,8 @@ func ComputeMultiproof(txns []types.Transaction) (proof []types.Hash256) {
 		}
 	}
 
-	for height, leaves := range leavesByTree(txns) {
+	trees := sortLeaves(leaves, func(l ElementLeaf) int { return len(l.MerkleProof) })
+	for height, leaves := range trees {
 		if len(leaves) == 0 {
 			continue
 		}
@@ -102,6 +125,65 @@ func ComputeMultiproof(txns []types.Transaction) (proof []types.Hash256) {
 	return
 }
 
+// VerifyMultiproof returns true if proof, as computed by BuildMultiproof,
+// proves that acc contains each of the supplied leaves. The leaves' own Merkle
+// proofs are ignored.
+func VerifyMultiproof(acc Accumulator, leaves []ElementLeaf, proof []types.Hash256) bool {
+	for _, l := range leaves {
+		if l.LeafIndex >= acc.NumLeaves {
+			return false
+		}
+	}
+	var root func(i, j uint64, leaves []ElementLeaf) (types.Hash256, bool)
+	root = func(i, j uint64, leaves []ElementLeaf) (types.Hash256, bool) {
+		height := bits.TrailingZeros64(j - i)
+		if len(leaves) == 0 {
+			if len(proof) == 0 {
+				return types.Hash256{}, false
+			}
+			h := proof[0]
+			proof = proof[1:]
+			return h, true
+		} else if height == 0 {
+			// the same leaf may appear more than once, but it must not
+			// conflict with itself
+			h := leaves[0].Hash()
+			for _, l := range leaves[1:] {
+				if l.Hash() != h {
+					return types.Hash256{}, false
+				}
+			}
+			return h, true
+		}
+		mid := (i + j) / 2
+		left, right := splitLeaves(leaves, mid)
+		leftRoot, ok := root(i, mid, left)
+		if !ok {
+			return types.Hash256{}, false
+		}
+		rightRoot, ok := root(mid, j, right)
+		if !ok {
+			return types.Hash256{}, false
+		}
+		return NodeHash(leftRoot, rightRoot), true
+	}
+
+	// a leaf belongs to the tree at the height of the highest bit in which its
+	// index differs from the number of leaves
+	trees := sortLeaves(leaves, func(l ElementLeaf) int { return mergeHeight(l.LeafIndex, acc.NumLeaves) - 1 })
+	for height, leaves := range trees {
+		if len(leaves) == 0 {
+			continue
+		}
+		start := clearBits(leaves[0].LeafIndex, height+1)
+		end := start + 1<<height
+		if r, ok := root(start, end, leaves); !ok || r != acc.Trees[height] {
+			return false
+		}
+	}
+	return len(proof) == 0
+}
+
 // ExpandMultiproof restores all of the proofs with txns using the supplied
 // multiproof, which must be valid. The len of each proof must be the correct
 // size.
@@ -159,7 +241,7 @@ func (b CompressedBlock) EncodeTo(e *types.Encoder) {
 // DecodeFrom implements types.DecoderFrom.
 func (b *CompressedBlock) DecodeFrom(d *types.Decoder) {
 	b.Header.DecodeFrom(d)
-	b.Transactions = make([]types.Transaction, d.ReadPrefix())
+	b.Transactions = make([]types.Transaction, d.ReadSlicePrefix(minCompressedTransactionLen))
 	for i := range b.Transactions {
 		(*compressedTransaction)(&b.Transactions[i]).DecodeFrom(d)
 	}
@@ -175,8 +257,93 @@ func (b *CompressedBlock) DecodeFrom(d *types.Decoder) {
 	ExpandMultiproof(b.Transactions, proof)
 }
 
+// A CompressedTransaction encodes a transaction in compressed form by merging
+// its individual Merkle proofs into a single multiproof. All of the proofs
+// must be valid against the same accumulator.
+type CompressedTransaction types.Transaction
+
+// EncodeTo implements types.EncoderTo.
+func (txn CompressedTransaction) EncodeTo(e *types.Encoder) {
+	CompressedTransactionSet{types.Transaction(txn)}.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (txn *CompressedTransaction) DecodeFrom(d *types.Decoder) {
+	var txns CompressedTransactionSet
+	txns.DecodeFrom(d)
+	if d.Err() != nil {
+		return
+	} else if len(txns) != 1 {
+		d.SetErr(fmt.Errorf("expected 1 transaction, got %v", len(txns)))
+		return
+	}
+	*txn = CompressedTransaction(txns[0])
+}
+
+// A CompressedTransactionSet encodes a set of transactions, e.g. a transaction
+// and its dependencies, in compressed form by merging all of their Merkle
+// proofs into a single multiproof. All of the proofs must be valid against the
+// same accumulator.
+type CompressedTransactionSet []types.Transaction
+
+// EncodeTo implements types.EncoderTo.
+func (txns CompressedTransactionSet) EncodeTo(e *types.Encoder) {
+	e.WritePrefix(len(txns))
+	for _, txn := range txns {
+		(compressedTransaction)(txn).EncodeTo(e)
+	}
+	for _, p := range ComputeMultiproof(txns) {
+		p.EncodeTo(e)
+	}
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (txns *CompressedTransactionSet) DecodeFrom(d *types.Decoder) {
+	*txns = make([]types.Transaction, d.ReadSlicePrefix(minCompressedTransactionLen))
+	for i := range *txns {
+		(*compressedTransaction)(&(*txns)[i]).DecodeFrom(d)
+	}
+	// MultiproofSize will panic on invalid inputs, so return early if we've
+	// already encountered an error
+	if d.Err() != nil {
+		return
+	}
+	proof := make([]types.Hash256, MultiproofSize(*txns))
+	for i := range proof {
+		proof[i].DecodeFrom(d)
+	}
+	ExpandMultiproof(*txns, proof)
+}
+
+// EncodeBlockCompressed writes b to w as a zstd-compressed CompressedBlock,
+// suitable for relaying blocks or storing them on disk.
+func EncodeBlockCompressed(w io.Writer, b types.Block) error {
+	return types.EncodeCompressed(w, CompressedBlock(b))
+}
+
+// DecodeBlockCompressed reads a block written by EncodeBlockCompressed. The
+// decompressed block may not exceed maxLen bytes.
+func DecodeBlockCompressed(r io.Reader, maxLen int) (types.Block, error) {
+	var b CompressedBlock
+	err := types.DecodeCompressed(r, &b, maxLen)
+	return types.Block(b), err
+}
+
 // helper types for compressed encoding
 
+// minimum encoded lengths, used to bound allocations when decoding
+var (
+	minCompressedTransactionLen  = 8 // field bitmask
+	minSiacoinInputLen           = types.EncodedLen(compressedSiacoinInput{SpendPolicy: types.PolicyThreshold{}})
+	siacoinOutputLen             = types.EncodedLen(types.SiacoinOutput{})
+	minSiafundInputLen           = types.EncodedLen(compressedSiafundInput{SpendPolicy: types.PolicyThreshold{}})
+	siafundOutputLen             = types.EncodedLen(types.SiafundOutput{})
+	fileContractLen              = types.EncodedLen(types.FileContract{})
+	minFileContractRevisionLen   = types.EncodedLen(compressedFileContractRevision{})
+	minFileContractResolutionLen = types.EncodedLen(compressedFileContractElement{})
+	minAttestationLen            = types.EncodedLen(types.Attestation{})
+)
+
 type compressedStateElement types.StateElement
 
 func (se compressedStateElement) EncodeTo(e *types.Encoder) {
@@ -188,9 +355,10 @@ func (se compressedStateElement) EncodeTo(e *types.Encoder) {
 func (se *compressedStateElement) DecodeFrom(d *types.Decoder) {
 	se.ID.DecodeFrom(d)
 	se.LeafIndex = d.ReadUint64()
-	se.MerkleProof = make([]types.Hash256, d.ReadPrefix()) // omit proof data
-	if len(se.MerkleProof) >= 64 {
+	if n := d.ReadUint64(); n >= 64 {
 		d.SetErr(errors.New("impossibly-large MerkleProof"))
+	} else {
+		se.MerkleProof = make([]types.Hash256, n) // omit proof data
 	}
 }
 
@@ -217,15 +385,25 @@ func (in compressedSiacoinInput) EncodeTo(e *types.Encoder) {
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
 	}
+	e.WritePrefix(len(in.Preimages))
+	for _, p := range in.Preimages {
+		e.Write(p[:])
+	}
+	e.WriteUint8(uint8(in.SigHash))
 }
 
 func (in *compressedSiacoinInput) DecodeFrom(d *types.Decoder) {
 	(*compressedSiacoinElement)(&in.Parent).DecodeFrom(d)
 	in.SpendPolicy = d.ReadPolicy()
-	in.Signatures = make([]types.Signature, d.ReadPrefix())
+	in.Signatures = make([]types.Signature, d.ReadSlicePrefix(64))
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
 	}
+	in.Preimages = make([][32]byte, d.ReadSlicePrefix(32))
+	for i := range in.Preimages {
+		d.Read(in.Preimages[i][:])
+	}
+	in.SigHash = types.SigHashFlags(d.ReadUint8())
 }
 
 type compressedSiafundElement types.SiafundElement
@@ -252,16 +430,26 @@ func (in compressedSiafundInput) EncodeTo(e *types.Encoder) {
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
 	}
+	e.WritePrefix(len(in.Preimages))
+	for _, p := range in.Preimages {
+		e.Write(p[:])
+	}
+	e.WriteUint8(uint8(in.SigHash))
 }
 
 func (in *compressedSiafundInput) DecodeFrom(d *types.Decoder) {
 	(*compressedSiafundElement)(&in.Parent).DecodeFrom(d)
 	in.ClaimAddress.DecodeFrom(d)
 	in.SpendPolicy = d.ReadPolicy()
-	in.Signatures = make([]types.Signature, d.ReadPrefix())
+	in.Signatures = make([]types.Signature, d.ReadSlicePrefix(64))
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
 	}
+	in.Preimages = make([][32]byte, d.ReadSlicePrefix(32))
+	for i := range in.Preimages {
+		d.Read(in.Preimages[i][:])
+	}
+	in.SigHash = types.SigHashFlags(d.ReadUint8())
 }
 
 type compressedFileContractElement types.FileContractElement
@@ -388,49 +576,49 @@ func (txn *compressedTransaction) DecodeFrom(d *types.Decoder) {
 	fields := d.ReadUint64()
 
 	if fields&(1<<0) != 0 {
-		txn.SiacoinInputs = make([]types.SiacoinInput, d.ReadPrefix())
+		txn.SiacoinInputs = make([]types.SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 		for i := range txn.SiacoinInputs {
 			(*compressedSiacoinInput)(&txn.SiacoinInputs[i]).DecodeFrom(d)
 		}
 	}
 	if fields&(1<<1) != 0 {
-		txn.SiacoinOutputs = make([]types.SiacoinOutput, d.ReadPrefix())
+		txn.SiacoinOutputs = make([]types.SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 		for i := range txn.SiacoinOutputs {
 			txn.SiacoinOutputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<2) != 0 {
-		txn.SiafundInputs = make([]types.SiafundInput, d.ReadPrefix())
+		txn.SiafundInputs = make([]types.SiafundInput, d.ReadSlicePrefix(minSiafundInputLen))
 		for i := range txn.SiafundInputs {
 			(*compressedSiafundInput)(&txn.SiafundInputs[i]).DecodeFrom(d)
 		}
 	}
 	if fields&(1<<3) != 0 {
-		txn.SiafundOutputs = make([]types.SiafundOutput, d.ReadPrefix())
+		txn.SiafundOutputs = make([]types.SiafundOutput, d.ReadSlicePrefix(siafundOutputLen))
 		for i := range txn.SiafundOutputs {
 			txn.SiafundOutputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<4) != 0 {
-		txn.FileContracts = make([]types.FileContract, d.ReadPrefix())
+		txn.FileContracts = make([]types.FileContract, d.ReadSlicePrefix(fileContractLen))
 		for i := range txn.FileContracts {
 			txn.FileContracts[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<5) != 0 {
-		txn.FileContractRevisions = make([]types.FileContractRevision, d.ReadPrefix())
+		txn.FileContractRevisions = make([]types.FileContractRevision, d.ReadSlicePrefix(minFileContractRevisionLen))
 		for i := range txn.FileContractRevisions {
 			(*compressedFileContractRevision)(&txn.FileContractRevisions[i]).DecodeFrom(d)
 		}
 	}
 	if fields&(1<<6) != 0 {
-		txn.FileContractResolutions = make([]types.FileContractResolution, d.ReadPrefix())
+		txn.FileContractResolutions = make([]types.FileContractResolution, d.ReadSlicePrefix(minFileContractResolutionLen))
 		for i := range txn.FileContractResolutions {
 			(*compressedFileContractResolution)(&txn.FileContractResolutions[i]).DecodeFrom(d)
 		}
 	}
 	if fields&(1<<7) != 0 {
-		txn.Attestations = make([]types.Attestation, d.ReadPrefix())
+		txn.Attestations = make([]types.Attestation, d.ReadSlicePrefix(minAttestationLen))
 		for i := range txn.Attestations {
 			txn.Attestations[i].DecodeFrom(d)
 		}
@@ -2,6 +2,7 @@ package merkle_test
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"math"
 	"math/rand"
@@ -125,3 +126,142 @@ func TestBlockCompression(t *testing.T) {
 		t.Errorf("simulated block compression ratio: expected <%.3g, got %.3g", 0.9, r)
 	}
 }
+
+func TestEncodeBlockCompressed(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	sim.MineBlocks(10)
+	// roundtrip through the uncompressed encoding first, so that empty slices
+	// are normalized
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	merkle.CompressedBlock(sim.MineBlock()).EncodeTo(e)
+	e.Flush()
+	var b types.Block
+	if err := types.DecodeFromStrict(buf.Bytes(), (*merkle.CompressedBlock)(&b)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	if err := merkle.EncodeBlockCompressed(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	n := types.EncodedLen(merkle.CompressedBlock(b))
+	if buf.Len() >= n {
+		t.Fatalf("expected compressed block to be smaller than %v bytes, got %v", n, buf.Len())
+	}
+	read, err := merkle.DecodeBlockCompressed(bytes.NewReader(buf.Bytes()), n)
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(b, read) {
+		t.Fatal("block did not survive roundtrip")
+	}
+
+	if _, err := merkle.DecodeBlockCompressed(bytes.NewReader(buf.Bytes()), n-1); !errors.Is(err, types.ErrCompressedTooLarge) {
+		t.Fatalf("expected %v, got %v", types.ErrCompressedTooLarge, err)
+	}
+}
+
+func TestEncodeBlockCompressedLarge(t *testing.T) {
+	// blocks larger than the decoder's default window must still roundtrip
+	for _, size := range []int{200 << 10, 3 << 20} {
+		data := make([]byte, size)
+		types.ReadEntropy(data[:size/2])
+		b := types.Block{
+			Transactions: []types.Transaction{{ArbitraryData: data}},
+		}
+		var buf bytes.Buffer
+		if err := merkle.EncodeBlockCompressed(&buf, b); err != nil {
+			t.Fatal(err)
+		}
+		n := types.EncodedLen(merkle.CompressedBlock(b))
+		read, err := merkle.DecodeBlockCompressed(bytes.NewReader(buf.Bytes()), n)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(read.Transactions[0].ArbitraryData, data) {
+			t.Fatal("block did not survive roundtrip")
+		}
+		if _, err := merkle.DecodeBlockCompressed(bytes.NewReader(buf.Bytes()), n-1); !errors.Is(err, types.ErrCompressedTooLarge) {
+			t.Fatalf("expected %v, got %v", types.ErrCompressedTooLarge, err)
+		}
+	}
+}
+
+func TestCompressedTransactionSet(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	sim.MineBlocks(10)
+	// roundtrip through the uncompressed encoding first, so that empty slices
+	// are normalized
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	merkle.CompressedBlock(sim.MineBlock()).EncodeTo(e)
+	e.Flush()
+	var b types.Block
+	if err := types.DecodeFromStrict(buf.Bytes(), (*merkle.CompressedBlock)(&b)); err != nil {
+		t.Fatal(err)
+	} else if len(b.Transactions) < 2 {
+		t.Fatal("expected multiple transactions")
+	}
+
+	txns := merkle.CompressedTransactionSet(b.Transactions)
+	var txns2 merkle.CompressedTransactionSet
+	if err := types.DecodeFromStrict(encode(txns), &txns2); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(txns, txns2) {
+		t.Fatal("transaction set did not survive roundtrip")
+	}
+	var uncompressed int
+	for _, txn := range txns {
+		uncompressed += types.EncodedLen(txn)
+	}
+	if n := len(encode(txns)); n >= uncompressed {
+		t.Errorf("expected compressed set to be smaller than %v bytes, got %v", uncompressed, n)
+	}
+
+	txn := merkle.CompressedTransaction(b.Transactions[0])
+	var txn2 merkle.CompressedTransaction
+	if err := types.DecodeFromStrict(encode(txn), &txn2); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(txn, txn2) {
+		t.Fatal("transaction did not survive roundtrip")
+	}
+	// a set of more than one transaction is not a valid CompressedTransaction
+	if err := types.DecodeFromStrict(encode(txns), &txn2); err == nil {
+		t.Fatal("expected error decoding transaction set as single transaction")
+	}
+}
+
+func TestCompressedDecodeBounds(t *testing.T) {
+	// sets and transactions claiming more elements than could possibly fit in
+	// the message should be rejected before anything is allocated
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	e.WritePrefix(1000)
+	e.Write(make([]byte, 1000))
+	e.Flush()
+	var txns merkle.CompressedTransactionSet
+	if err := types.DecodeFromStrict(buf.Bytes(), &txns); err == nil {
+		t.Fatal("expected invalid set prefix to be rejected")
+	} else if len(txns) != 0 {
+		t.Fatal("expected no transactions to be allocated")
+	}
+
+	buf.Reset()
+	e.WritePrefix(1)
+	e.WriteUint64(1 << 5) // FileContractRevisions
+	e.WritePrefix(1000)
+	e.Write(make([]byte, 1000))
+	e.Flush()
+	if err := types.DecodeFromStrict(buf.Bytes(), &txns); err == nil {
+		t.Fatal("expected invalid revisions prefix to be rejected")
+	} else if len(txns) == 1 && len(txns[0].FileContractRevisions) != 0 {
+		t.Fatal("expected no revisions to be allocated")
+	}
+}
+
+func encode(v types.EncoderTo) []byte {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	v.EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
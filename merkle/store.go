@@ -0,0 +1,87 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go.sia.tech/core/types"
+)
+
+// accumulatorStoreVersion is the version of the FileAccumulatorStore format.
+const accumulatorStoreVersion = 1
+
+// ErrNoAccumulators is returned by LoadAccumulators if no state has been saved.
+var ErrNoAccumulators = errors.New("no saved accumulator state")
+
+// An AccumulatorStore persists the element and history accumulators of a
+// chain, allowing them to be restored after a restart without replaying the
+// chain.
+type AccumulatorStore interface {
+	// SaveAccumulators durably records the accumulators as of the given
+	// index, replacing any previously saved state.
+	SaveAccumulators(index types.ChainIndex, ea ElementAccumulator, ha HistoryAccumulator) error
+	// LoadAccumulators returns the most recently saved accumulators, or
+	// ErrNoAccumulators if none have been saved.
+	LoadAccumulators() (types.ChainIndex, ElementAccumulator, HistoryAccumulator, error)
+}
+
+// A FileAccumulatorStore is an AccumulatorStore backed by a single file, which
+// is replaced atomically on each save.
+type FileAccumulatorStore struct {
+	path string
+}
+
+// SaveAccumulators implements AccumulatorStore.
+func (fs *FileAccumulatorStore) SaveAccumulators(index types.ChainIndex, ea ElementAccumulator, ha HistoryAccumulator) error {
+	f, err := os.OpenFile(fs.path+"_tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to open tmp file: %w", err)
+	}
+	defer f.Close()
+	e := types.NewEncoder(f)
+	e.WriteUint8(accumulatorStoreVersion)
+	index.EncodeTo(e)
+	ea.EncodeTo(e)
+	ha.EncodeTo(e)
+	if err := e.Flush(); err != nil {
+		return fmt.Errorf("failed to write tmp file: %w", err)
+	} else if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync tmp file: %w", err)
+	} else if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close tmp file: %w", err)
+	} else if err := os.Rename(fs.path+"_tmp", fs.path); err != nil {
+		return fmt.Errorf("failed to rename tmp file: %w", err)
+	}
+	return nil
+}
+
+// LoadAccumulators implements AccumulatorStore.
+func (fs *FileAccumulatorStore) LoadAccumulators() (index types.ChainIndex, ea ElementAccumulator, ha HistoryAccumulator, err error) {
+	buf, err := os.ReadFile(fs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		err = ErrNoAccumulators
+		return
+	} else if err != nil {
+		err = fmt.Errorf("failed to read accumulator file: %w", err)
+		return
+	}
+	d := types.NewBufDecoder(buf)
+	if v := d.ReadUint8(); d.Err() == nil && v != accumulatorStoreVersion {
+		err = fmt.Errorf("unsupported accumulator file version (%v)", v)
+		return
+	}
+	index.DecodeFrom(d)
+	ea.DecodeFrom(d)
+	ha.DecodeFrom(d)
+	if err = d.Err(); err != nil {
+		err = fmt.Errorf("failed to decode accumulators: %w", err)
+	}
+	return
+}
+
+// NewFileAccumulatorStore returns a FileAccumulatorStore that stores
+// accumulators in the specified file.
+func NewFileAccumulatorStore(path string) *FileAccumulatorStore {
+	return &FileAccumulatorStore{path: path}
+}
@@ -0,0 +1,202 @@
+// Package mobile provides a flattened API over core functionality that is
+// compatible with gomobile. All values cross the API boundary as strings (for
+// human-readable values such as addresses and currency amounts) or as byte
+// slices containing the standard binary encoding of the corresponding core
+// type.
+package mobile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/types"
+	"go.sia.tech/core/wallet"
+)
+
+func encode(v types.EncoderTo) []byte {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	v.EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
+
+func decode(b []byte, v types.DecoderFrom) error {
+	return types.DecodeFromStrict(b, v)
+}
+
+func privateKeyFromSeed(seed []byte) (types.PrivateKey, error) {
+	if len(seed) != 32 {
+		return nil, fmt.Errorf("seed must be 32 bytes, got %v", len(seed))
+	}
+	var s [32]byte
+	copy(s[:], seed)
+	priv := types.NewPrivateKeyFromSeed(s)
+	for i := range s {
+		s[i] = 0
+	}
+	return priv, nil
+}
+
+// GenerateSeed returns a new random 32-byte seed.
+func GenerateSeed() []byte {
+	seed := types.Entropy256()
+	return seed[:]
+}
+
+// PublicKey returns the ed25519 public key derived from seed.
+func PublicKey(seed []byte) ([]byte, error) {
+	priv, err := privateKeyFromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+	pk := priv.PublicKey()
+	return pk[:], nil
+}
+
+// StandardAddress returns the address of the standard spend policy for the
+// given ed25519 public key.
+func StandardAddress(pubkey []byte) (string, error) {
+	if len(pubkey) != len(types.PublicKey{}) {
+		return "", fmt.Errorf("public key must be %v bytes, got %v", len(types.PublicKey{}), len(pubkey))
+	}
+	var pk types.PublicKey
+	copy(pk[:], pubkey)
+	return types.StandardAddress(pk).String(), nil
+}
+
+// FormatSiacoins formats an amount of hastings, given as a base-10 integer
+// string, as a human-readable amount with units, e.g. "1.5 SC".
+func FormatSiacoins(hastings string) (string, error) {
+	c, err := types.ParseCurrency(hastings)
+	if err != nil {
+		return "", err
+	}
+	return c.String(), nil
+}
+
+// TransactionID returns the ID of the encoded transaction.
+func TransactionID(txn []byte) (string, error) {
+	var t types.Transaction
+	if err := decode(txn, &t); err != nil {
+		return "", err
+	}
+	return t.ID().String(), nil
+}
+
+// SignTransaction signs each input of the encoded transaction with the key
+// derived from seed, and returns the encoded signed transaction. vc must be the
+// encoded ValidationContext in which the transaction will be validated. An
+// error is returned if any input's spend policy cannot be satisfied by the
+// key.
+func SignTransaction(vc, txn, seed []byte) ([]byte, error) {
+	var c consensus.ValidationContext
+	var t types.Transaction
+	if err := decode(vc, &c); err != nil {
+		return nil, fmt.Errorf("invalid validation context: %w", err)
+	} else if err := decode(txn, &t); err != nil {
+		return nil, fmt.Errorf("invalid transaction: %w", err)
+	}
+	priv, err := privateKeyFromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+	keys := map[types.PublicKey]types.PrivateKey{priv.PublicKey(): priv}
+	if err := wallet.SignTransaction(c, &t, keys); err != nil {
+		return nil, err
+	}
+	return encode(t), nil
+}
+
+// VerifySiacoinElement reports whether the encoded SiacoinElement is present
+// and unspent in the state accumulator of the encoded ValidationContext.
+func VerifySiacoinElement(vc, sce []byte) (bool, error) {
+	var c consensus.ValidationContext
+	var e types.SiacoinElement
+	if err := decode(vc, &c); err != nil {
+		return false, fmt.Errorf("invalid validation context: %w", err)
+	} else if err := decode(sce, &e); err != nil {
+		return false, fmt.Errorf("invalid siacoin element: %w", err)
+	}
+	return c.State.ContainsUnspentSiacoinElement(e), nil
+}
+
+// SectorRoot returns the Merkle root of the given sector, which must be
+// exactly rhp.SectorSize bytes. Renters use this to verify sectors downloaded
+// from hosts.
+func SectorRoot(sector []byte) (string, error) {
+	if len(sector) != rhp.SectorSize {
+		return "", fmt.Errorf("sector must be %v bytes, got %v", rhp.SectorSize, len(sector))
+	}
+	s := new([rhp.SectorSize]byte)
+	copy(s[:], sector)
+	return rhp.SectorRoot(s).String(), nil
+}
+
+// readSectorTimeout bounds the time spent by ReadSector.
+const readSectorTimeout = 2 * time.Minute
+
+// ReadSector downloads length bytes, starting at offset, of the sector with the
+// given root from the host at netAddress, and returns them after verifying them
+// against root. The host is paid with a revision of the contract with the given
+// ID, whose renter key is derived from seed; vc must be the encoded
+// ValidationContext in which the contract's signatures are valid. offset and
+// length must be multiples of 64 bytes.
+func ReadSector(netAddress string, hostKey, vc []byte, contractID string, seed []byte, root string, offset, length int64) ([]byte, error) {
+	var c consensus.ValidationContext
+	var pk types.PublicKey
+	var r types.Hash256
+	if len(hostKey) != len(pk) {
+		return nil, fmt.Errorf("host key must be %v bytes, got %v", len(pk), len(hostKey))
+	} else if err := decode(vc, &c); err != nil {
+		return nil, fmt.Errorf("invalid validation context: %w", err)
+	} else if err := r.UnmarshalText([]byte(root)); err != nil {
+		return nil, fmt.Errorf("invalid sector root: %w", err)
+	} else if offset < 0 || length <= 0 || offset+length > rhp.SectorSize {
+		return nil, fmt.Errorf("invalid section [%v, %v)", offset, offset+length)
+	}
+	copy(pk[:], hostKey)
+	id, err := types.ParseElementID(contractID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract ID: %w", err)
+	}
+	priv, err := privateKeyFromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), readSectorTimeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", netAddress)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	sess, err := rhp.DialSessionContext(ctx, conn, pk, rhp.SessionOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+	settings, err := sess.Settings()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't fetch host settings: %w", err)
+	} else if _, err := sess.Lock(ctx, c, id, priv, 10*time.Second); err != nil {
+		return nil, fmt.Errorf("couldn't lock contract: %w", err)
+	}
+	defer sess.Unlock(ctx)
+	var buf bytes.Buffer
+	sections := []rhp.RPCReadRequestSection{{MerkleRoot: r, Offset: uint64(offset), Length: uint64(length)}}
+	if err := sess.Read(ctx, settings, sections, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
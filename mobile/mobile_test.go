@@ -0,0 +1,163 @@
+package mobile
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rhp/rhptest"
+	"go.sia.tech/core/types"
+)
+
+func TestSignTransaction(t *testing.T) {
+	seed := GenerateSeed()
+	pubkey, err := PublicKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrStr, err := StandardAddress(pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := types.ParseAddress(addrStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	genesis := types.Block{
+		Header: types.BlockHeader{Timestamp: time.Unix(734600000, 0)},
+		Transactions: []types.Transaction{{
+			SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: types.Siacoins(1)}},
+		}},
+	}
+	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 1}})
+	vc := encode(sau.Context)
+	sce := sau.NewSiacoinElements[1]
+	if ok, err := VerifySiacoinElement(vc, encode(sce)); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("expected element to be present in accumulator")
+	}
+
+	var pk types.PublicKey
+	copy(pk[:], pubkey)
+	txn := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      sce,
+			SpendPolicy: types.PolicyPublicKey(pk),
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Address: types.VoidAddress, Value: sce.Value}},
+	}
+	signed, err := SignTransaction(vc, encode(txn), seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signedTxn types.Transaction
+	if err := decode(signed, &signedTxn); err != nil {
+		t.Fatal(err)
+	} else if err := sau.Context.ValidateTransaction(signedTxn); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := TransactionID(signed); err != nil {
+		t.Fatal(err)
+	} else if id != signedTxn.ID().String() {
+		t.Fatal("transaction ID mismatch")
+	}
+
+	// signing with the wrong key should fail
+	if _, err := SignTransaction(vc, encode(txn), GenerateSeed()); err == nil {
+		t.Fatal("expected error when signing with unrelated key")
+	}
+}
+
+func TestReadSector(t *testing.T) {
+	settings := rhp.HostSettings{
+		AcceptingContracts:     true,
+		Version:                "1.0.0",
+		SectorSize:             rhp.SectorSize,
+		TotalStorage:           100,
+		RemainingStorage:       100,
+		WindowSize:             10,
+		MaxDuration:            1000,
+		MaxCollateral:          types.Siacoins(100),
+		Collateral:             types.NewCurrency64(1),
+		StoragePrice:           types.NewCurrency64(1),
+		UploadBandwidthPrice:   types.NewCurrency64(1),
+		DownloadBandwidthPrice: types.NewCurrency64(1),
+	}
+	var vc consensus.ValidationContext
+	seed := GenerateSeed()
+	renterKey, err := privateKeyFromSeed(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey := types.GeneratePrivateKey()
+	fc := types.FileContract{
+		WindowStart:     100,
+		WindowEnd:       200,
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Value: types.Siacoins(5)},
+		MissedHostValue: types.Siacoins(5),
+		TotalCollateral: types.Siacoins(5),
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   hostKey.PublicKey(),
+	}
+	hash := vc.ContractSigHash(fc)
+	fc.RenterSignature = renterKey.SignHash(hash)
+	fc.HostSignature = hostKey.SignHash(hash)
+	c := rhp.Contract{ID: types.ElementID{Index: 1}, Revision: fc}
+
+	h := rhptest.NewHost(hostKey, vc, settings, rhptest.Persona{})
+	defer h.Close()
+	if err := h.AddContract(c); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go h.Serve(l)
+
+	// upload a sector
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := rhp.DialSession(conn, h.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var sector [rhp.SectorSize]byte
+	types.ReadEntropy(sector[:256])
+	if _, err := sess.Lock(ctx, vc, c.ID, renterKey, time.Second); err != nil {
+		t.Fatal(err)
+	} else if err := sess.Write(ctx, settings, []rhp.RPCWriteAction{{Type: rhp.RPCWriteActionAppend, Data: sector[:]}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sess.Close()
+
+	root, err := SectorRoot(sector[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := h.PublicKey()
+	data, err := ReadSector(l.Addr().String(), pk[:], encode(vc), c.ID.String(), seed, root, 64, 128)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, sector[64:192]) {
+		t.Fatal("read returned wrong data")
+	}
+
+	// reading a sector the host does not have should fail
+	var missing [rhp.SectorSize]byte
+	root, _ = SectorRoot(missing[:])
+	if _, err := ReadSector(l.Addr().String(), pk[:], encode(vc), c.ID.String(), seed, root, 0, 64); err == nil {
+		t.Fatal("expected error reading missing sector")
+	}
+}
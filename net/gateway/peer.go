@@ -4,11 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"go.sia.tech/core/net/mux"
 	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
-	"lukechampine.com/frand"
 )
 
 const protocolVersion = 1
@@ -21,7 +21,7 @@ type UniqueID [8]byte
 
 // GenerateUniqueID returns a random UniqueID.
 func GenerateUniqueID() (id UniqueID) {
-	frand.Read(id[:])
+	types.ReadEntropy(id[:])
 	return
 }
 
@@ -53,16 +53,58 @@ func (h *rpcHeader) MaxLen() int {
 	return 1024 // arbitrary
 }
 
+// Options configures a gateway Session. The zero value of each field selects
+// its default.
+type Options struct {
+	// Limits is copied to the Session's Limits field.
+	Limits rpc.Limits
+	// HandshakeTimeout bounds the time spent establishing the Session. The
+	// default is one minute.
+	HandshakeTimeout time.Duration
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts Options) Validate() error {
+	if err := opts.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
+	} else if opts.HandshakeTimeout < 0 {
+		return fmt.Errorf("negative handshake timeout (%v)", opts.HandshakeTimeout)
+	}
+	return nil
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = time.Minute
+	}
+	return opts
+}
+
 // A Session is an ongoing exchange of RPCs via the gateway protocol.
 type Session struct {
 	*mux.Mux
 	RemoteAddr string
 	RemoteID   UniqueID
+	// Limits is applied to objects read with s.Limits.ReadRequest, etc.
+	Limits rpc.Limits
 }
 
 // DialSession initiates the gateway handshake with a peer, establishing a
-// Session.
-func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Session, err error) {
+// Session with the default options.
+func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (*Session, error) {
+	return DialSessionWithOptions(conn, genesisID, uid, Options{})
+}
+
+// DialSessionWithOptions initiates the gateway handshake with a peer,
+// establishing a Session with the provided options.
+func DialSessionWithOptions(conn net.Conn, genesisID types.BlockID, uid UniqueID, opts Options) (_ *Session, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+	conn.SetDeadline(time.Now().Add(opts.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
 	m, err := mux.DialAnonymous(conn)
 	if err != nil {
 		return nil, err
@@ -103,12 +145,26 @@ func DialSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Sessi
 		Mux:        m,
 		RemoteAddr: conn.RemoteAddr().String(),
 		RemoteID:   peerHeader.UniqueID,
+		Limits:     opts.Limits,
 	}, nil
 }
 
 // AcceptSession reciprocates the gateway handshake with a peer, establishing a
-// Session.
-func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Session, err error) {
+// Session with the default options.
+func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (*Session, error) {
+	return AcceptSessionWithOptions(conn, genesisID, uid, Options{})
+}
+
+// AcceptSessionWithOptions reciprocates the gateway handshake with a peer,
+// establishing a Session with the provided options.
+func AcceptSessionWithOptions(conn net.Conn, genesisID types.BlockID, uid UniqueID, opts Options) (_ *Session, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+	conn.SetDeadline(time.Now().Add(opts.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
 	m, err := mux.AcceptAnonymous(conn)
 	if err != nil {
 		return nil, err
@@ -149,5 +205,6 @@ func AcceptSession(conn net.Conn, genesisID types.BlockID, uid UniqueID) (_ *Ses
 		Mux:        m,
 		RemoteAddr: conn.RemoteAddr().String(),
 		RemoteID:   peerHeader.UniqueID,
+		Limits:     opts.Limits,
 	}, nil
 }
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"testing"
+	"time"
 
 	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
@@ -91,3 +92,22 @@ func TestHandshake(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHandshakeOptions(t *testing.T) {
+	genesisID := (&types.Block{}).ID()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	if _, err := DialSessionWithOptions(c1, genesisID, UniqueID{1}, Options{HandshakeTimeout: -1}); err == nil {
+		t.Fatal("expected invalid options to be rejected")
+	}
+
+	// the peer never responds, so the handshake should time out
+	start := time.Now()
+	if _, err := DialSessionWithOptions(c1, genesisID, UniqueID{1}, Options{HandshakeTimeout: 50 * time.Millisecond}); err == nil {
+		t.Fatal("expected handshake to time out")
+	} else if time.Since(start) > time.Second {
+		t.Fatal("handshake did not respect timeout")
+	}
+}
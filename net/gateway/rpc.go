@@ -9,12 +9,15 @@ import (
 	"go.sia.tech/core/types"
 )
 
-const defaultMaxLen = 10e3
-const largeMaxLen = 1e6
-
 // MaxRPCPeersLen is the maximum number of peers that RPCPeers can return.
 const MaxRPCPeersLen = 100
 
+// encoded lengths, used to bound allocations when decoding
+var (
+	chainIndexLen  = types.EncodedLen(types.ChainIndex{})
+	blockHeaderLen = types.EncodedLen(types.BlockHeader{})
+)
+
 // RPC IDs
 var (
 	RPCPeersID      = rpc.NewSpecifier("Peers")
@@ -35,7 +38,8 @@ type (
 		History []types.ChainIndex
 	}
 
-	// RPCHeadersResponse contains the response data for the Headers RPC.
+	// RPCHeadersResponse contains the response data for the Headers RPC. The
+	// headers must form a chain; they are encoded as types.CompactHeaders.
 	RPCHeadersResponse struct {
 		Headers []types.BlockHeader
 	}
@@ -112,33 +116,27 @@ func (r *RPCHeadersRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCHeadersRequest) DecodeFrom(d *types.Decoder) {
-	r.History = make([]types.ChainIndex, d.ReadPrefix())
+	r.History = make([]types.ChainIndex, d.ReadSlicePrefix(chainIndexLen))
 	for i := range r.History {
 		r.History[i].DecodeFrom(d)
 	}
 }
 
 // MaxLen implements rpc.Object.
-func (RPCHeadersRequest) MaxLen() int { return defaultMaxLen }
+func (RPCHeadersRequest) MaxLen() int { return rpc.DefaultMaxLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCHeadersResponse) EncodeTo(e *types.Encoder) {
-	e.WritePrefix(len(r.Headers))
-	for i := range r.Headers {
-		r.Headers[i].EncodeTo(e)
-	}
+	types.CompactHeaders(r.Headers).EncodeTo(e)
 }
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCHeadersResponse) DecodeFrom(d *types.Decoder) {
-	r.Headers = make([]types.BlockHeader, d.ReadPrefix())
-	for i := range r.Headers {
-		r.Headers[i].DecodeFrom(d)
-	}
+	(*types.CompactHeaders)(&r.Headers).DecodeFrom(d)
 }
 
 // MaxLen implements rpc.Object.
-func (RPCHeadersResponse) MaxLen() int { return largeMaxLen }
+func (RPCHeadersResponse) MaxLen() int { return rpc.LargeMaxLen }
 
 // RPCPeersResponse contains the response data for the Peers RPC.
 type RPCPeersResponse []string
@@ -153,7 +151,7 @@ func (r *RPCPeersResponse) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCPeersResponse) DecodeFrom(d *types.Decoder) {
-	*r = make([]string, d.ReadPrefix())
+	*r = make([]string, d.ReadPrefixMax(MaxRPCPeersLen))
 	for i := range *r {
 		(*r)[i] = d.ReadString()
 	}
@@ -175,14 +173,14 @@ func (r *RPCBlocksRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCBlocksRequest) DecodeFrom(d *types.Decoder) {
-	r.Blocks = make([]types.ChainIndex, d.ReadPrefix())
+	r.Blocks = make([]types.ChainIndex, d.ReadSlicePrefix(chainIndexLen))
 	for i := range r.Blocks {
 		r.Blocks[i].DecodeFrom(d)
 	}
 }
 
 // MaxLen implements rpc.Object.
-func (RPCBlocksRequest) MaxLen() int { return defaultMaxLen }
+func (RPCBlocksRequest) MaxLen() int { return rpc.DefaultMaxLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCBlocksResponse) EncodeTo(e *types.Encoder) {
@@ -194,7 +192,7 @@ func (r *RPCBlocksResponse) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCBlocksResponse) DecodeFrom(d *types.Decoder) {
-	r.Blocks = make([]types.Block, d.ReadPrefix())
+	r.Blocks = make([]types.Block, d.ReadSlicePrefix(blockHeaderLen+8))
 	for i := range r.Blocks {
 		(*merkle.CompressedBlock)(&r.Blocks[i]).DecodeFrom(d)
 	}
@@ -231,7 +229,7 @@ func (r *RPCCheckpointResponse) DecodeFrom(d *types.Decoder) {
 }
 
 // MaxLen implements rpc.Object.
-func (RPCCheckpointResponse) MaxLen() int { return largeMaxLen }
+func (RPCCheckpointResponse) MaxLen() int { return rpc.LargeMaxLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCRelayBlockRequest) EncodeTo(e *types.Encoder) {
@@ -244,7 +242,7 @@ func (r *RPCRelayBlockRequest) DecodeFrom(d *types.Decoder) {
 }
 
 // MaxLen implements rpc.Object.
-func (RPCRelayBlockRequest) MaxLen() int { return defaultMaxLen }
+func (RPCRelayBlockRequest) MaxLen() int { return rpc.DefaultMaxLen }
 
 // EncodeTo implements rpc.Object.
 func (r *RPCRelayTxnRequest) EncodeTo(e *types.Encoder) {
@@ -258,11 +256,11 @@ func (r *RPCRelayTxnRequest) EncodeTo(e *types.Encoder) {
 // DecodeFrom implements rpc.Object.
 func (r *RPCRelayTxnRequest) DecodeFrom(d *types.Decoder) {
 	r.Transaction.DecodeFrom(d)
-	r.DependsOn = make([]types.Transaction, d.ReadPrefix())
+	r.DependsOn = make([]types.Transaction, d.ReadSlicePrefix(8))
 	for i := range r.DependsOn {
 		r.DependsOn[i].DecodeFrom(d)
 	}
 }
 
 // MaxLen implements rpc.Object.
-func (RPCRelayTxnRequest) MaxLen() int { return defaultMaxLen }
+func (RPCRelayTxnRequest) MaxLen() int { return rpc.DefaultMaxLen }
@@ -24,6 +24,8 @@ const (
 )
 
 func generateX25519KeyPair() (xsk, xpk [32]byte) {
+	// transport keys and nonces deliberately bypass types.ReadEntropy, so that
+	// an injected entropy source can never weaken a live connection
 	frand.Read(xsk[:])
 	curve25519.ScalarBaseMult(&xpk, &xsk)
 	return
@@ -55,10 +57,16 @@ func decryptInPlace(buf []byte, aead cipher.AEAD) ([]byte, error) {
 	return aead.Open(ciphertext[:0], nonce, ciphertext, nil)
 }
 
+// encryptedFrameSize returns the size of an encrypted frame with the given
+// payload length, which is padded to a packet boundary.
+func encryptedFrameSize(length int, packetSize int) int {
+	numPackets := (encryptedHeaderSize + (length + chachaOverhead) + (packetSize - 1)) / packetSize
+	return numPackets * packetSize
+}
+
 func encryptFrame(buf []byte, h frameHeader, payload []byte, packetSize int, aead cipher.AEAD) []byte {
 	// pad frame to packet boundary
-	numPackets := (encryptedHeaderSize + (len(payload) + chachaOverhead) + (packetSize - 1)) / packetSize
-	frame := buf[:numPackets*packetSize]
+	frame := buf[:encryptedFrameSize(len(payload), packetSize)]
 	// encode + encrypt header
 	encodeFrameHeader(frame[chachaPoly1305NonceSize:][:frameHeaderSize], h)
 	encryptInPlace(frame[:encryptedHeaderSize], aead)
@@ -85,8 +93,7 @@ func readEncryptedFrame(r io.Reader, buf []byte, packetSize int, aead cipher.AEA
 	if err != nil {
 		return frameHeader{}, nil, fmt.Errorf("could not decrypt header: %w", err)
 	}
-	numPackets := (encryptedHeaderSize + (int(h.length) + chachaOverhead) + (packetSize - 1)) / packetSize
-	paddedSize := numPackets*packetSize - encryptedHeaderSize
+	paddedSize := encryptedFrameSize(int(h.length), packetSize) - encryptedHeaderSize
 	if h.length > uint32(len(buf)) || paddedSize > len(buf) {
 		return frameHeader{}, nil, errors.New("peer sent too-large frame")
 	}
@@ -102,7 +109,7 @@ func readEncryptedFrame(r io.Reader, buf []byte, packetSize int, aead cipher.AEA
 	return h, payload[:h.length], nil
 }
 
-func initiateEncryptionHandshake(conn net.Conn, theirKey ed25519.PublicKey) (cipher.AEAD, error) {
+func initiateEncryptionHandshake(conn net.Conn, theirKey ed25519.PublicKey) ([32]byte, error) {
 	xsk, xpk := generateX25519KeyPair()
 
 	// write request
@@ -117,19 +124,19 @@ func initiateEncryptionHandshake(conn net.Conn, theirKey ed25519.PublicKey) (cip
 	binary.LittleEndian.PutUint64(payload[32:], 1) // number of ciphers we're offering
 	copy(payload[40:], cipherChaCha20Poly1305)
 	if _, err := conn.Write(frameBuf); err != nil {
-		return nil, fmt.Errorf("could not write establish encryption frame: %w", err)
+		return [32]byte{}, fmt.Errorf("could not write establish encryption frame: %w", err)
 	}
 
 	// read response
 	h, payload, err := readFrame(conn, buf)
 	if err != nil {
-		return nil, err
+		return [32]byte{}, err
 	} else if h.id != idEstablishEncryption {
-		return nil, errors.New("invalid handshake ID")
+		return [32]byte{}, errors.New("invalid handshake ID")
 	} else if h.length < 32+64+16 {
-		return nil, errors.New("handshake payload is too short")
+		return [32]byte{}, errors.New("handshake payload is too short")
 	} else if string(payload[32+64:]) != cipherChaCha20Poly1305 {
-		return nil, errors.New("invalid cipher selected")
+		return [32]byte{}, errors.New("invalid cipher selected")
 	}
 	var rxpk [32]byte
 	copy(rxpk[:], payload[:32])
@@ -138,33 +145,29 @@ func initiateEncryptionHandshake(conn net.Conn, theirKey ed25519.PublicKey) (cip
 	// verify signature
 	sigHash := blake2b.Sum256(append(rxpk[:], xpk[:]...))
 	if !ed25519.Verify(theirKey, sigHash[:], sig) {
-		return nil, errors.New("invalid signature")
+		return [32]byte{}, errors.New("invalid signature")
 	}
 
 	// derive encryption key
 	cipherKey, err := deriveSharedSecret(xsk, rxpk)
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive shared secret: %w", err)
-	}
-	cipher, err := chacha20poly1305.New(cipherKey[:])
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return [32]byte{}, fmt.Errorf("failed to derive shared secret: %w", err)
 	}
-	return cipher, nil
+	return cipherKey, nil
 }
 
-func acceptEncryptionHandshake(conn net.Conn, ourKey ed25519.PrivateKey) (cipher.AEAD, error) {
+func acceptEncryptionHandshake(conn net.Conn, ourKey ed25519.PrivateKey) ([32]byte, error) {
 	xsk, xpk := generateX25519KeyPair()
 
 	// read request
 	buf := make([]byte, 1024) // large enough to hold many ciphers
 	h, payload, err := readFrame(conn, buf)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read handshake frame: %w", err)
+		return [32]byte{}, fmt.Errorf("failed to read handshake frame: %w", err)
 	} else if h.id != idEstablishEncryption {
-		return nil, errors.New("invalid handshake ID")
+		return [32]byte{}, errors.New("invalid handshake ID")
 	} else if h.length < 8+32+16 {
-		return nil, errors.New("handshake payload is too short")
+		return [32]byte{}, errors.New("handshake payload is too short")
 	}
 
 	// parse pubkey
@@ -173,14 +176,14 @@ func acceptEncryptionHandshake(conn net.Conn, ourKey ed25519.PrivateKey) (cipher
 	// select cipher
 	numCiphers := binary.LittleEndian.Uint64(payload[32:])
 	if uint64(h.length-40)/16 < numCiphers {
-		return nil, errors.New("invalid cipher encoding")
+		return [32]byte{}, errors.New("invalid cipher encoding")
 	}
 	var supportsChaCha bool
 	for i := uint64(0); i < numCiphers; i++ {
 		supportsChaCha = supportsChaCha || string(payload[40+16*i:][:16]) == cipherChaCha20Poly1305
 	}
 	if !supportsChaCha {
-		return nil, errors.New("no cipher overlap")
+		return [32]byte{}, errors.New("no cipher overlap")
 	}
 
 	// write response
@@ -196,18 +199,53 @@ func acceptEncryptionHandshake(conn net.Conn, ourKey ed25519.PrivateKey) (cipher
 	copy(payload[32:96], sig)
 	copy(payload[96:], cipherChaCha20Poly1305)
 	if _, err := conn.Write(frameBuf); err != nil {
-		return nil, fmt.Errorf("failed to write accept handshake frame: %w", err)
+		return [32]byte{}, fmt.Errorf("failed to write accept handshake frame: %w", err)
 	}
 
 	// derive encryption key
 	cipherKey, err := deriveSharedSecret(xsk, rxpk)
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive secret: %w", err)
+		return [32]byte{}, fmt.Errorf("failed to derive secret: %w", err)
 	}
-	cipher, err := chacha20poly1305.New(cipherKey[:])
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	return cipherKey, nil
+}
+
+// deriveKey derives a subkey from key, separated by domain.
+func deriveKey(domain string, key [32]byte) [32]byte {
+	buf := make([]byte, 0, len(domain)+len(key))
+	buf = append(append(buf, domain...), key[:]...)
+	return blake2b.Sum256(buf)
+}
+
+// A cipherState encrypts or decrypts the frames sent in one direction of a
+// Mux, replacing its key after every rekeyInterval bytes. Since both peers see
+// the same sequence of frames, they rekey in lockstep without any additional
+// messages.
+type cipherState struct {
+	key           [32]byte
+	aead          cipher.AEAD
+	n             int64 // bytes processed with the current key
+	rekeyInterval int64
+}
+
+func (cs *cipherState) setKey(key [32]byte) {
+	cs.key = key
+	cs.aead, _ = chacha20poly1305.New(key[:]) // no error possible with a 32-byte key
+	cs.n = 0
+}
+
+// advance records that a frame of n bytes was processed, rekeying if
+// necessary. The new key is derived from the old one, so compromising a key
+// does not reveal earlier traffic.
+func (cs *cipherState) advance(n int) {
+	cs.n += int64(n)
+	if cs.rekeyInterval > 0 && cs.n >= cs.rekeyInterval {
+		cs.setKey(deriveKey("sia/mux/rekey", cs.key))
 	}
+}
 
-	return cipher, nil
+func newCipherState(key [32]byte, rekeyInterval int64) cipherState {
+	cs := cipherState{rekeyInterval: rekeyInterval}
+	cs.setKey(key)
+	return cs
 }
@@ -0,0 +1,13 @@
+package mux
+
+import (
+	"errors"
+	"io"
+)
+
+// isConnCloseError returns true if the error is from the peer closing the
+// connection early. Under js/wasm, connections are provided by the host
+// environment (e.g. a WebSocket) and do not surface syscall errors.
+func isConnCloseError(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe)
+}
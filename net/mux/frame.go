@@ -11,9 +11,10 @@ import (
 )
 
 const (
-	flagFirst = 1 << iota // first frame in stream
-	flagLast              // stream is being closed gracefully
-	flagError             // stream is being closed due to an error
+	flagFirst        = 1 << iota // first frame in stream
+	flagLast                     // stream is being closed gracefully
+	flagError                    // stream is being closed due to an error
+	flagWindowUpdate             // payload is an increase to the peer's send window
 )
 
 const (
@@ -65,36 +66,52 @@ func readFrame(r io.Reader, buf []byte) (frameHeader, []byte, error) {
 	return h, payload, nil
 }
 
-var ourVersion = []byte{1}
+// protocol versions; each version includes the features of those before it
+const (
+	versionBase        = 1
+	versionRekey       = 2 // adds rekeying
+	versionFlowControl = 3 // adds per-stream flow control
+)
 
-func initiateVersionHandshake(conn net.Conn) error {
+// initiateVersionHandshake proposes a protocol version, returning the version
+// selected by the peer, which may be lower.
+func initiateVersionHandshake(conn net.Conn, ourVersion uint8) (uint8, error) {
 	theirVersion := make([]byte, 1)
-	if _, err := conn.Write(ourVersion); err != nil {
-		return fmt.Errorf("could not write our version: %w", err)
+	if _, err := conn.Write([]byte{ourVersion}); err != nil {
+		return 0, fmt.Errorf("could not write our version: %w", err)
 	} else if _, err := io.ReadFull(conn, theirVersion); err != nil {
-		return fmt.Errorf("could not read peer version: %w", err)
-	} else if theirVersion[0] != 1 {
-		return errors.New("bad version")
+		return 0, fmt.Errorf("could not read peer version: %w", err)
+	} else if theirVersion[0] < versionBase || theirVersion[0] > ourVersion {
+		return 0, errors.New("bad version")
 	}
-	return nil
+	return theirVersion[0], nil
 }
 
-func acceptVersionHandshake(conn net.Conn) error {
+// acceptVersionHandshake selects the highest protocol version supported by
+// both peers.
+func acceptVersionHandshake(conn net.Conn) (uint8, error) {
 	theirVersion := make([]byte, 1)
 	if _, err := io.ReadFull(conn, theirVersion); err != nil {
-		return fmt.Errorf("could not read peer version: %w", err)
-	} else if _, err := conn.Write(ourVersion); err != nil {
-		return fmt.Errorf("could not write our version: %w", err)
-	} else if theirVersion[0] != 1 {
-		return errors.New("bad version")
+		return 0, fmt.Errorf("could not read peer version: %w", err)
+	}
+	version := theirVersion[0]
+	if version > versionFlowControl {
+		version = versionFlowControl
+	}
+	if _, err := conn.Write([]byte{version}); err != nil {
+		return 0, fmt.Errorf("could not write our version: %w", err)
+	} else if version < versionBase {
+		return 0, errors.New("bad version")
 	}
-	return nil
+	return version, nil
 }
 
 type connSettings struct {
 	RequestedPacketSize int
 	MaxFrameSizePackets int
 	MaxTimeout          time.Duration
+	RekeyInterval       int64 // bytes; zero if rekeying is disabled
+	StreamWindow        int64 // bytes; zero if flow control is disabled
 }
 
 func (cs connSettings) maxFrameSize() int {
@@ -112,25 +129,49 @@ var defaultConnSettings = connSettings{
 }
 
 const settingsFrameSize = 1024
-const connSettingsSize = 24
+
+// connSettingsSize returns the size of the encoded connSettings for the given
+// protocol version.
+func connSettingsSize(version uint8) int {
+	switch {
+	case version >= versionFlowControl:
+		return 40
+	case version >= versionRekey:
+		return 32
+	default:
+		return 24
+	}
+}
 
 func encodeConnSettings(buf []byte, cs connSettings) {
 	binary.LittleEndian.PutUint64(buf[0:], uint64(cs.RequestedPacketSize))
 	binary.LittleEndian.PutUint64(buf[8:], uint64(cs.MaxFrameSizePackets))
 	binary.LittleEndian.PutUint64(buf[16:], uint64(cs.MaxTimeout.Seconds()))
+	if len(buf) >= 32 {
+		binary.LittleEndian.PutUint64(buf[24:], uint64(cs.RekeyInterval))
+	}
+	if len(buf) >= 40 {
+		binary.LittleEndian.PutUint64(buf[32:], uint64(cs.StreamWindow))
+	}
 }
 
 func decodeConnSettings(buf []byte) (cs connSettings) {
 	cs.RequestedPacketSize = int(binary.LittleEndian.Uint64(buf[0:]))
 	cs.MaxFrameSizePackets = int(binary.LittleEndian.Uint64(buf[8:]))
 	cs.MaxTimeout = time.Second * time.Duration(binary.LittleEndian.Uint64(buf[16:]))
+	if len(buf) >= 32 {
+		cs.RekeyInterval = int64(binary.LittleEndian.Uint64(buf[24:]))
+	}
+	if len(buf) >= 40 {
+		cs.StreamWindow = int64(binary.LittleEndian.Uint64(buf[32:]))
+	}
 	return
 }
 
-func initiateSettingsHandshake(conn net.Conn, ours connSettings, aead cipher.AEAD) (connSettings, error) {
+func initiateSettingsHandshake(conn net.Conn, ours connSettings, version uint8, aead cipher.AEAD) (connSettings, error) {
 	// encode + write request
 	frameBuf := make([]byte, settingsFrameSize)
-	payload := make([]byte, connSettingsSize)
+	payload := make([]byte, connSettingsSize(version))
 	encodeConnSettings(payload, ours)
 	frame := encryptFrame(frameBuf, frameHeader{
 		id:     idUpdateSettings,
@@ -145,14 +186,14 @@ func initiateSettingsHandshake(conn net.Conn, ours connSettings, aead cipher.AEA
 		return connSettings{}, err
 	} else if h.id != idUpdateSettings {
 		return connSettings{}, errors.New("invalid settings ID")
-	} else if h.length != connSettingsSize {
+	} else if h.length != uint32(connSettingsSize(version)) {
 		return connSettings{}, errors.New("invalid settings payload")
 	}
 	theirs := decodeConnSettings(payload)
 	return mergeSettings(ours, theirs)
 }
 
-func acceptSettingsHandshake(conn net.Conn, ours connSettings, aead cipher.AEAD) (connSettings, error) {
+func acceptSettingsHandshake(conn net.Conn, ours connSettings, version uint8, aead cipher.AEAD) (connSettings, error) {
 	// read + decode request
 	frameBuf := make([]byte, settingsFrameSize)
 	h, payload, err := readEncryptedFrame(conn, frameBuf, settingsFrameSize, aead)
@@ -160,12 +201,12 @@ func acceptSettingsHandshake(conn net.Conn, ours connSettings, aead cipher.AEAD)
 		return connSettings{}, err
 	} else if h.id != idUpdateSettings {
 		return connSettings{}, errors.New("invalid settings ID")
-	} else if h.length != connSettingsSize {
+	} else if h.length != uint32(connSettingsSize(version)) {
 		return connSettings{}, errors.New("invalid settings payload")
 	}
 	theirs := decodeConnSettings(payload)
 	// encode + write response
-	payload = make([]byte, connSettingsSize)
+	payload = make([]byte, connSettingsSize(version))
 	encodeConnSettings(payload, ours)
 	frame := encryptFrame(frameBuf, frameHeader{
 		id:     idUpdateSettings,
@@ -189,6 +230,12 @@ func mergeSettings(ours, theirs connSettings) (connSettings, error) {
 	if theirs.MaxTimeout < merged.MaxTimeout {
 		merged.MaxTimeout = theirs.MaxTimeout
 	}
+	if theirs.RekeyInterval < merged.RekeyInterval {
+		merged.RekeyInterval = theirs.RekeyInterval
+	}
+	if theirs.StreamWindow < merged.StreamWindow {
+		merged.StreamWindow = theirs.StreamWindow
+	}
 	// enforce minimums and maximums
 	switch {
 	case merged.RequestedPacketSize < 1220:
@@ -199,6 +246,10 @@ func mergeSettings(ours, theirs connSettings) (connSettings, error) {
 		return connSettings{}, errors.New("maximum frame size is too large")
 	case merged.MaxTimeout < 2*time.Minute:
 		return connSettings{}, errors.New("maximum timeout is too short")
+	case ours.RekeyInterval != 0 && merged.RekeyInterval < minRekeyInterval:
+		return connSettings{}, errors.New("rekey interval is too short")
+	case ours.StreamWindow != 0 && merged.StreamWindow < minStreamWindow:
+		return connSettings{}, errors.New("stream window is too small")
 	}
 	return merged, nil
 }
@@ -2,8 +2,8 @@ package mux
 
 import (
 	"bytes"
-	"crypto/cipher"
 	"crypto/ed25519"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -19,13 +19,103 @@ var (
 	ErrClosedStream     = errors.New("stream was gracefully closed")
 	ErrPeerClosedStream = errors.New("peer closed stream gracefully")
 	ErrPeerClosedConn   = errors.New("peer closed underlying connection")
+	ErrPeerTimedOut     = errors.New("peer did not send anything within the idle timeout")
 )
 
+// minRekeyInterval is the smallest rekey interval that a Mux will accept, and
+// defaultRekeyInterval is the interval offered by accepting peers that do not
+// specify one.
+const (
+	minRekeyInterval     = 1 << 20 // 1 MiB
+	defaultRekeyInterval = 1 << 30 // 1 GiB
+)
+
+// minStreamWindow is the smallest stream window that a Mux will accept, and
+// defaultStreamWindow is the window offered by accepting peers that do not
+// specify one.
+const (
+	minStreamWindow     = 1 << 16 // 64 KiB
+	defaultStreamWindow = 1 << 20 // 1 MiB
+)
+
+// Options configures a Mux. The zero value of each field selects its default.
+type Options struct {
+	// RekeyInterval is the number of bytes that may be sent in each direction
+	// before the key for that direction is replaced.
+	//
+	// Like flow control, rekeying must be requested by the dialing peer: a
+	// nonzero RekeyInterval (or StreamWindow) causes Dial to request it, which
+	// older peers reject. An accepting peer always grants it, using a default
+	// interval of 1 GiB. The peers use the smaller of their intervals; the
+	// minimum is 1 MiB.
+	RekeyInterval int64
+	// Transcript, if set, receives an authenticated record of every frame
+	// sent or received after the handshake; see ReadTranscript.
+	Transcript io.Writer
+	// StreamWindow is the number of bytes that each Stream may buffer before
+	// the peer must wait for it to be Read. Without flow control, a single
+	// unread Stream blocks all other Streams on the Mux.
+	//
+	// Flow control must be requested by the dialing peer: a nonzero
+	// StreamWindow causes Dial to request it, which older peers reject. An
+	// accepting peer always grants it, using a default window of 1 MiB. The
+	// peers use the smaller of their windows; the minimum is 64 KiB.
+	StreamWindow int64
+	// KeepaliveInterval is the maximum time that may elapse between frames
+	// sent by the Mux; if no other frame is sent, an empty keepalive frame is
+	// sent instead. Shorter intervals keep NAT mappings alive on idle
+	// connections. The default (and maximum) is 75% of the negotiated
+	// timeout, i.e. 15 minutes.
+	KeepaliveInterval time.Duration
+	// IdleTimeout, if set, causes the Mux to be closed with ErrPeerTimedOut if
+	// no frame is received from the peer within the timeout. It should
+	// comfortably exceed the peer's KeepaliveInterval.
+	IdleTimeout time.Duration
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts Options) Validate() error {
+	if opts.RekeyInterval != 0 && opts.RekeyInterval < minRekeyInterval {
+		return fmt.Errorf("rekey interval is too short (%v < %v bytes)", opts.RekeyInterval, minRekeyInterval)
+	} else if opts.StreamWindow != 0 && opts.StreamWindow < minStreamWindow {
+		return fmt.Errorf("stream window is too small (%v < %v bytes)", opts.StreamWindow, minStreamWindow)
+	} else if opts.KeepaliveInterval < 0 {
+		return fmt.Errorf("negative keepalive interval (%v)", opts.KeepaliveInterval)
+	} else if opts.IdleTimeout < 0 {
+		return fmt.Errorf("negative idle timeout (%v)", opts.IdleTimeout)
+	}
+	return nil
+}
+
+func (opts Options) settings(version uint8) connSettings {
+	settings := defaultConnSettings
+	if version >= versionRekey {
+		settings.RekeyInterval = defaultRekeyInterval
+		if opts.RekeyInterval != 0 {
+			settings.RekeyInterval = opts.RekeyInterval
+		}
+	}
+	if version >= versionFlowControl {
+		settings.StreamWindow = defaultStreamWindow
+		if opts.StreamWindow != 0 {
+			settings.StreamWindow = opts.StreamWindow
+		}
+	}
+	return settings
+}
+
 // A Mux multiplexes multiple duplex Streams onto a single net.Conn.
 type Mux struct {
-	conn     net.Conn
-	aead     cipher.AEAD
-	settings connSettings
+	conn       net.Conn
+	settings   connSettings
+	send       cipherState // used only by writeLoop
+	recv       cipherState // used only by readLoop
+	transcript *transcript
+	// transcriptKey is derived from the handshake secret; the secret itself
+	// is not retained, so that rekeying provides forward secrecy
+	transcriptKey [32]byte
+	keepalive     time.Duration
+	idle          time.Duration // zero if disabled
 
 	// all subsequent fields are guarded by mu
 	mu      sync.Mutex
@@ -69,25 +159,30 @@ func (m *Mux) setErr(err error) error {
 	return err
 }
 
+// expired reports whether the deadline returned by fn, if any, has passed.
+func expired(fn func() time.Time) bool {
+	if fn == nil {
+		return false
+	}
+	deadline := fn()
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
+
 // bufferFrame blocks until it can store its frame in the m.write struct. It
-// returns early with an error if m.err is set or if the deadline expires.
-func (m *Mux) bufferFrame(h frameHeader, payload []byte, deadline time.Time) error {
+// returns early with an error if m.err is set or if the deadline returned by
+// the (optional) deadline function expires. The deadline is re-evaluated each
+// time m.write.cond is signaled, so that it may be changed while bufferFrame
+// is waiting.
+func (m *Mux) bufferFrame(h frameHeader, payload []byte, deadline func() time.Time) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	if !deadline.IsZero() {
-		if !time.Now().Before(deadline) {
-			return os.ErrDeadlineExceeded
-		}
-		timer := time.AfterFunc(time.Until(deadline), m.write.cond.Broadcast) // nice
-		defer timer.Stop()
-	}
 	// wait for current frame to be consumed
-	for m.write.header.id != 0 && m.err == nil && (deadline.IsZero() || time.Now().Before(deadline)) {
+	for m.write.header.id != 0 && m.err == nil && !expired(deadline) {
 		m.write.cond.Wait()
 	}
 	if m.err != nil {
 		return m.err
-	} else if !deadline.IsZero() && !time.Now().Before(deadline) {
+	} else if expired(deadline) {
 		return os.ErrDeadlineExceeded
 	}
 	// queue our frame and wake the writeLoop
@@ -106,9 +201,7 @@ func (m *Mux) bufferFrame(h frameHeader, payload []byte, deadline time.Time) err
 // up the next bufferFrame call (if any). It also handles keepalives.
 func (m *Mux) writeLoop() {
 	// wake cond whenever a keepalive is due
-	//
-	// NOTE: we send a keepalive when 75% of the MaxTimeout has elapsed
-	keepaliveInterval := m.settings.MaxTimeout - m.settings.MaxTimeout/4
+	keepaliveInterval := m.keepalive
 	nextKeepalive := time.Now().Add(keepaliveInterval)
 	timer := time.AfterFunc(keepaliveInterval, m.cond.Broadcast)
 	defer timer.Stop()
@@ -132,7 +225,8 @@ func (m *Mux) writeLoop() {
 		if h.id == 0 {
 			h, payload = frameHeader{id: idKeepalive}, nil
 		}
-		frame := encryptFrame(writeBuf, h, payload, m.settings.RequestedPacketSize, m.aead)
+		frame := encryptFrame(writeBuf, h, payload, m.settings.RequestedPacketSize, m.send.aead)
+		m.send.advance(len(frame))
 		m.mu.Unlock()
 
 		// reset keepalive timer
@@ -144,6 +238,9 @@ func (m *Mux) writeLoop() {
 		if _, err := m.conn.Write(frame); err != nil {
 			m.setErr(err)
 			return
+		} else if err := m.transcript.record(true, h, payload); err != nil {
+			m.setErr(err)
+			return
 		}
 
 		// clear the payload and wake at most one bufferFrame call
@@ -162,12 +259,26 @@ func (m *Mux) writeLoop() {
 func (m *Mux) readLoop() {
 	var curStream *Stream // saves a lock acquisition + map lookup in the common case
 	buf := make([]byte, m.settings.maxFrameSize())
+	// close the Mux if the peer is silent for too long
+	var idleTimer *time.Timer
+	if m.idle != 0 {
+		idleTimer = time.AfterFunc(m.idle, func() { m.setErr(ErrPeerTimedOut) })
+		defer idleTimer.Stop()
+	}
 	for {
-		h, payload, err := readEncryptedFrame(m.conn, buf, m.settings.RequestedPacketSize, m.aead)
+		h, payload, err := readEncryptedFrame(m.conn, buf, m.settings.RequestedPacketSize, m.recv.aead)
 		if err != nil {
 			m.setErr(err)
 			return
 		}
+		if idleTimer != nil {
+			idleTimer.Reset(m.idle)
+		}
+		m.recv.advance(encryptedFrameSize(len(payload), m.settings.RequestedPacketSize))
+		if err := m.transcript.record(false, h, payload); err != nil {
+			m.setErr(err)
+			return
+		}
 		switch h.id {
 		case idErrorBadInit, idEstablishEncryption, idUpdateSettings:
 			// peer is behaving weirdly; after initialization, we shouldn't
@@ -198,6 +309,7 @@ func (m *Mux) readLoop() {
 						needAccept:  true,
 						cond:        sync.Cond{L: new(sync.Mutex)},
 						established: true,
+						sendWindow:  m.settings.StreamWindow,
 					}
 					m.streams[h.id] = curStream
 					m.cond.Broadcast() // wake (*Mux).AcceptStream
@@ -258,6 +370,7 @@ func (m *Mux) DialStream() (*Stream, error) {
 		needAccept:  false,
 		cond:        sync.Cond{L: new(sync.Mutex)},
 		established: false,
+		sendWindow:  m.settings.StreamWindow,
 	}
 	m.nextID += 2
 	m.streams[s.id] = s
@@ -265,13 +378,25 @@ func (m *Mux) DialStream() (*Stream, error) {
 }
 
 // newMux initializes a Mux and spawns its readLoop and writeLoop goroutines.
-func newMux(conn net.Conn, aead cipher.AEAD, settings connSettings) *Mux {
+func newMux(conn net.Conn, key [32]byte, settings connSettings, opts Options) *Mux {
 	m := &Mux{
-		conn:     conn,
-		aead:     aead,
-		settings: settings,
-		streams:  make(map[uint32]*Stream),
-		nextID:   1 << 8, // avoid collisions with reserved IDs
+		conn:          conn,
+		settings:      settings,
+		idle:          opts.IdleTimeout,
+		send:          newCipherState(key, settings.RekeyInterval),
+		recv:          newCipherState(key, settings.RekeyInterval),
+		transcriptKey: deriveKey("sia/mux/transcript", key),
+		streams:       make(map[uint32]*Stream),
+		nextID:        1 << 8, // avoid collisions with reserved IDs
+	}
+	// NOTE: by default, we send a keepalive when 75% of the MaxTimeout has
+	// elapsed
+	m.keepalive = settings.MaxTimeout - settings.MaxTimeout/4
+	if opts.KeepaliveInterval != 0 && opts.KeepaliveInterval < m.keepalive {
+		m.keepalive = opts.KeepaliveInterval
+	}
+	if opts.Transcript != nil {
+		m.transcript = &transcript{w: opts.Transcript, key: m.TranscriptKey()}
 	}
 	// both conds use the same mutex
 	m.cond.L = &m.mu
@@ -283,38 +408,74 @@ func newMux(conn net.Conn, aead cipher.AEAD, settings connSettings) *Mux {
 
 // Dial initiates a mux protocol handshake on the provided conn.
 func Dial(conn net.Conn, theirKey ed25519.PublicKey) (*Mux, error) {
-	if err := initiateVersionHandshake(conn); err != nil {
+	return DialWithOptions(conn, theirKey, Options{})
+}
+
+// DialWithOptions is like Dial, but configures the Mux with the provided
+// options.
+func DialWithOptions(conn net.Conn, theirKey ed25519.PublicKey, opts Options) (*Mux, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	ourVersion := uint8(versionBase)
+	if opts.StreamWindow != 0 {
+		ourVersion = versionFlowControl
+	} else if opts.RekeyInterval != 0 {
+		ourVersion = versionRekey
+	}
+	version, err := initiateVersionHandshake(conn, ourVersion)
+	if err != nil {
 		return nil, fmt.Errorf("version handshake failed: %w", err)
 	}
-	aead, err := initiateEncryptionHandshake(conn, theirKey)
+	key, err := initiateEncryptionHandshake(conn, theirKey)
 	if err != nil {
 		return nil, fmt.Errorf("encryption handshake failed: %w", err)
 	}
-	settings, err := initiateSettingsHandshake(conn, defaultConnSettings, aead)
+	hs := newCipherState(key, 0)
+	settings, err := initiateSettingsHandshake(conn, opts.settings(version), version, hs.aead)
 	if err != nil {
 		return nil, fmt.Errorf("settings handshake failed: %w", err)
 	}
-	return newMux(conn, aead, settings), nil
+	return newMux(conn, key, settings, opts), nil
 }
 
 // Accept reciprocates a mux protocol handshake on the provided conn.
 func Accept(conn net.Conn, ourKey ed25519.PrivateKey) (*Mux, error) {
-	if err := acceptVersionHandshake(conn); err != nil {
+	return AcceptWithOptions(conn, ourKey, Options{})
+}
+
+// AcceptWithOptions is like Accept, but configures the Mux with the provided
+// options.
+func AcceptWithOptions(conn net.Conn, ourKey ed25519.PrivateKey, opts Options) (*Mux, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	version, err := acceptVersionHandshake(conn)
+	if err != nil {
 		return nil, fmt.Errorf("version handshake failed: %w", err)
 	}
-	aead, err := acceptEncryptionHandshake(conn, ourKey)
+	key, err := acceptEncryptionHandshake(conn, ourKey)
 	if err != nil {
 		return nil, fmt.Errorf("encryption handshake failed: %w", err)
 	}
-	settings, err := acceptSettingsHandshake(conn, defaultConnSettings, aead)
+	hs := newCipherState(key, 0)
+	settings, err := acceptSettingsHandshake(conn, opts.settings(version), version, hs.aead)
 	if err != nil {
 		return nil, fmt.Errorf("settings handshake failed: %w", err)
 	}
-	m := newMux(conn, aead, settings)
+	m := newMux(conn, key, settings, opts)
 	m.nextID++ // avoid collisions with Dialing peer
 	return m, nil
 }
 
+// TranscriptKey returns the key used to authenticate the Mux's transcript. It
+// is derived from the secret established during the handshake, so both peers
+// derive the same key; it can be disclosed to an auditor without revealing the
+// keys used to encrypt the connection.
+func (m *Mux) TranscriptKey() [32]byte {
+	return m.transcriptKey
+}
+
 var anonPrivkey = ed25519.NewKeyFromSeed(make([]byte, 32))
 var anonPubkey = anonPrivkey.Public().(ed25519.PublicKey)
 
@@ -340,6 +501,13 @@ type Stream struct {
 	err         error
 	readBuf     []byte
 	rd, wd      time.Time // deadlines
+
+	// wake pending calls when their deadline expires
+	rdTimer, wdTimer *time.Timer
+
+	// used only with flow control
+	sendWindow int64 // bytes the peer is prepared to buffer
+	unacked    int64 // bytes Read since the last window update
 }
 
 // LocalAddr returns the underlying connection's LocalAddr.
@@ -348,44 +516,85 @@ func (s *Stream) LocalAddr() net.Addr { return s.m.conn.LocalAddr() }
 // RemoteAddr returns the underlying connection's RemoteAddr.
 func (s *Stream) RemoteAddr() net.Addr { return s.m.conn.RemoteAddr() }
 
+// resetDeadlineTimer stops timer and, if t is non-zero, returns a new timer
+// that calls fn at t.
+func resetDeadlineTimer(timer *time.Timer, t time.Time, fn func()) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), fn)
+}
+
 // SetDeadline sets the read and write deadlines associated with the Stream. It
 // is equivalent to calling both SetReadDeadline and SetWriteDeadline.
-//
-// This implementation does not entirely conform to the net.Conn interface:
-// setting a new deadline does not affect pending Read or Write calls, only
-// future calls.
 func (s *Stream) SetDeadline(t time.Time) error {
 	s.SetReadDeadline(t)
 	s.SetWriteDeadline(t)
 	return nil
 }
 
-// SetReadDeadline sets the read deadline associated with the Stream.
-//
-// This implementation does not entirely conform to the net.Conn interface:
-// setting a new deadline does not affect pending Read calls, only future calls.
+// SetReadDeadline sets the read deadline associated with the Stream. As with
+// net.Conn, the deadline applies to pending Read calls as well as future ones;
+// setting a deadline in the past interrupts them.
 func (s *Stream) SetReadDeadline(t time.Time) error {
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 	s.rd = t
+	s.rdTimer = resetDeadlineTimer(s.rdTimer, t, s.cond.Broadcast)
+	s.cond.Broadcast() // wake Read and consumeFrame
 	return nil
 }
 
-// SetWriteDeadline sets the write deadline associated with the Stream.
-//
-// This implementation does not entirely conform to the net.Conn interface:
-// setting a new deadline does not affect pending Write calls, only future
-// calls.
+// SetWriteDeadline sets the write deadline associated with the Stream. As with
+// net.Conn, the deadline applies to pending Write calls as well as future
+// ones; setting a deadline in the past interrupts them.
 func (s *Stream) SetWriteDeadline(t time.Time) error {
 	s.cond.L.Lock()
-	defer s.cond.L.Unlock()
 	s.wd = t
+	s.wdTimer = resetDeadlineTimer(s.wdTimer, t, s.wakeWriters)
+	s.cond.L.Unlock()
+	s.wakeWriters()
 	return nil
 }
 
-// consumeFrame stores a frame in s.readBuf and waits for it to be consumed by
-// (*Stream).Read calls.
+// writeDeadline returns the Stream's current write deadline.
+func (s *Stream) writeDeadline() time.Time {
+	s.cond.L.Lock()
+	defer s.cond.L.Unlock()
+	return s.wd
+}
+
+// wakeWriters wakes any Write calls waiting for send window or for their
+// frame to be buffered, so that they observe a new or expired deadline.
+func (s *Stream) wakeWriters() {
+	s.cond.L.Lock()
+	s.cond.Broadcast()
+	s.cond.L.Unlock()
+	// NOTE: m.mu must not be acquired while holding s.cond.L
+	s.m.mu.Lock()
+	s.m.write.cond.Broadcast()
+	s.m.mu.Unlock()
+}
+
+// consumeFrame stores a frame in s.readBuf. Without flow control, it waits
+// for the frame to be consumed by (*Stream).Read calls; with flow control, the
+// peer's send window guarantees that s.readBuf remains bounded, so it returns
+// immediately.
 func (s *Stream) consumeFrame(h frameHeader, payload []byte) {
+	if h.flags&flagWindowUpdate != 0 {
+		if s.m.settings.StreamWindow == 0 || len(payload) != 4 {
+			s.m.setErr(errors.New("peer sent invalid window update"))
+			return
+		}
+		s.cond.L.Lock()
+		s.sendWindow += int64(binary.LittleEndian.Uint32(payload))
+		s.cond.Broadcast() // wake Write
+		s.cond.L.Unlock()
+		return
+	}
 	if h.flags&flagLast != 0 {
 		// stream is closing; set s.err
 		err := ErrPeerClosedStream
@@ -403,6 +612,20 @@ func (s *Stream) consumeFrame(h frameHeader, payload []byte) {
 		s.m.mu.Unlock()
 		return
 	}
+	if window := s.m.settings.StreamWindow; window != 0 {
+		s.cond.L.Lock()
+		exceeded := int64(len(s.readBuf)+len(payload)) > window
+		if !exceeded && s.err == nil {
+			s.readBuf = append(s.readBuf, payload...)
+			s.cond.Broadcast() // wake Read
+		}
+		s.cond.L.Unlock()
+		if exceeded {
+			s.m.setErr(errors.New("peer exceeded stream window"))
+		}
+		return
+	}
+
 	// set payload and wait for it to be consumed
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
@@ -413,25 +636,45 @@ func (s *Stream) consumeFrame(h frameHeader, payload []byte) {
 	}
 }
 
+// writeWindowUpdate grants the peer n additional bytes of send window.
+func (s *Stream) writeWindowUpdate(n int64) error {
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, uint32(n))
+	h := frameHeader{
+		id:     s.id,
+		length: uint32(len(payload)),
+		flags:  flagWindowUpdate,
+	}
+	return s.m.bufferFrame(h, payload, nil)
+}
+
 // Read reads data from the Stream.
 func (s *Stream) Read(p []byte) (int, error) {
+	// once half the window has been consumed, replenish it; this is deferred
+	// first so that it runs after s.cond.L is released
+	var update int64
+	defer func() {
+		if update > 0 {
+			s.writeWindowUpdate(update) // any error is sticky, so ignore it here
+		}
+	}()
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 	if !s.established {
 		// developer error: peer doesn't know this Stream exists yet
 		panic("mux: Read called before Write on newly-Dialed Stream")
 	}
-	if !s.rd.IsZero() {
-		if !time.Now().Before(s.rd) {
-			return 0, os.ErrDeadlineExceeded
-		}
-		timer := time.AfterFunc(time.Until(s.rd), s.cond.Broadcast)
-		defer timer.Stop()
+	if !s.rd.IsZero() && !time.Now().Before(s.rd) {
+		return 0, os.ErrDeadlineExceeded
 	}
+	// NOTE: s.rdTimer wakes us if the deadline expires while we wait
 	for len(s.readBuf) == 0 && s.err == nil && (s.rd.IsZero() || time.Now().Before(s.rd)) {
 		s.cond.Wait()
 	}
-	if s.err != nil {
+	if s.err == ErrPeerClosedStream && len(s.readBuf) > 0 {
+		// with flow control, data may still be buffered after the peer closes
+		// the stream; return it before signaling EOF
+	} else if s.err != nil {
 		if s.err == ErrPeerClosedStream {
 			return 0, io.EOF
 		}
@@ -442,33 +685,60 @@ func (s *Stream) Read(p []byte) (int, error) {
 	n := copy(p, s.readBuf)
 	s.readBuf = s.readBuf[n:]
 	s.cond.Broadcast() // wake consumeFrame
+	if window := s.m.settings.StreamWindow; window != 0 {
+		s.unacked += int64(n)
+		if s.unacked >= window/2 {
+			update, s.unacked = s.unacked, 0
+		}
+	}
 	return n, nil
 }
 
 // Write writes data to the Stream.
 func (s *Stream) Write(p []byte) (int, error) {
+	flowControl := s.m.settings.StreamWindow != 0
 	buf := bytes.NewBuffer(p)
 	for buf.Len() > 0 {
-		// check for error
+		// check for error, and wait for send window if necessary
+		//
+		// NOTE: s.wdTimer wakes us if the deadline expires while we wait
 		s.cond.L.Lock()
+		for flowControl && s.sendWindow == 0 && s.err == nil && (s.wd.IsZero() || time.Now().Before(s.wd)) {
+			s.cond.Wait()
+		}
 		err := s.err
+		if err == nil && flowControl && s.sendWindow == 0 {
+			err = os.ErrDeadlineExceeded
+		}
+		n := s.m.settings.maxPayloadSize()
+		if flowControl && int64(n) > s.sendWindow {
+			n = int(s.sendWindow)
+		}
 		var flags uint16
-		if err == nil && !s.established {
-			flags = flagFirst
-			s.established = true
+		if err == nil {
+			if !s.established {
+				flags = flagFirst
+				s.established = true
+			}
+			if flowControl {
+				if n > buf.Len() {
+					n = buf.Len()
+				}
+				s.sendWindow -= int64(n)
+			}
 		}
 		s.cond.L.Unlock()
 		if err != nil {
 			return len(p) - buf.Len(), err
 		}
 		// write next frame's worth of data
-		payload := buf.Next(s.m.settings.maxPayloadSize())
+		payload := buf.Next(n)
 		h := frameHeader{
 			id:     s.id,
 			length: uint32(len(payload)),
 			flags:  flags,
 		}
-		if err := s.m.bufferFrame(h, payload, s.wd); err != nil {
+		if err := s.m.bufferFrame(h, payload, s.writeDeadline); err != nil {
 			return len(p) - buf.Len(), err
 		}
 	}
@@ -495,7 +765,7 @@ func (s *Stream) Close() error {
 		id:    s.id,
 		flags: flagLast,
 	}
-	err := s.m.bufferFrame(h, nil, s.wd)
+	err := s.m.bufferFrame(h, nil, s.writeDeadline)
 	if err != nil && err != ErrPeerClosedStream {
 		return err
 	}
@@ -1,7 +1,10 @@
 package mux
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"crypto/ed25519"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -309,6 +312,211 @@ func TestDeadline(t *testing.T) {
 	}
 }
 
+func TestFlowControl(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	bulk := make([]byte, 4*minStreamWindow)
+	for i := range bulk {
+		bulk[i] = byte(i)
+	}
+
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			m, err := Accept(conn, serverKey)
+			if err != nil {
+				return err
+			}
+			defer m.Close()
+			// leave the bulk stream unread until the echo stream is done
+			bs, err := m.AcceptStream()
+			if err != nil {
+				return err
+			}
+			es, err := m.AcceptStream()
+			if err != nil {
+				return err
+			}
+			buf := make([]byte, 5)
+			if _, err := io.ReadFull(es, buf); err != nil {
+				return err
+			} else if _, err := es.Write(buf); err != nil {
+				return err
+			}
+			es.Close()
+			// the bulk data, including that buffered before the peer closed
+			// the stream, should arrive intact
+			received, err := io.ReadAll(bs)
+			if err != nil {
+				return err
+			} else if !bytes.Equal(received, bulk) {
+				return errors.New("bulk data was corrupted")
+			}
+			return bs.Close()
+		}()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DialWithOptions(conn, serverKey.Public().(ed25519.PublicKey), Options{StreamWindow: minStreamWindow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.settings.StreamWindow != minStreamWindow {
+		t.Fatal("peers did not use the smaller stream window")
+	}
+
+	// fill the bulk stream's window; the remainder of the write must block
+	bs, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := bs.Write(bulk); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("expected write to exceed deadline, got", err)
+	} else if n != minStreamWindow {
+		t.Fatalf("expected %v bytes to be written, got %v", minStreamWindow, n)
+	}
+	bs.SetWriteDeadline(time.Time{})
+	writeCh := make(chan error, 1)
+	go func() {
+		_, err := bs.Write(bulk[minStreamWindow:])
+		if err == nil {
+			err = bs.Close()
+		}
+		writeCh <- err
+	}()
+
+	// other streams should not be blocked by the bulk stream
+	es, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if _, err := es.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(es, buf); err != nil {
+		t.Fatal(err)
+	} else if string(buf) != "hello" {
+		t.Fatal("bad echo")
+	}
+	es.Close()
+
+	if err := <-writeCh; err != nil {
+		t.Fatal(err)
+	} else if err := <-serverCh; err != nil && err != ErrPeerClosedStream {
+		t.Fatal(err)
+	}
+
+	// dialing without a window disables flow control
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		m, err := Accept(conn, serverKey)
+		if err == nil {
+			m.Close()
+		}
+	}()
+	conn, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Dial(conn, serverKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m2.Close()
+	if m2.settings.StreamWindow != 0 {
+		t.Fatal("flow control should be disabled")
+	}
+}
+
+func TestDeadlineInterrupt(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		m, err := Accept(conn, serverKey)
+		if err != nil {
+			return
+		}
+		defer m.Close()
+		// accept the stream, but never respond
+		s, err := m.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, s)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := Dial(conn, serverKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pending Read with no deadline should be interrupted by a new deadline
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal("expected os.ErrDeadlineExceeded, got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Read was not interrupted")
+	}
+
+	// extending the deadline should allow subsequent Reads to proceed
+	s.SetReadDeadline(time.Time{})
+	go func() {
+		_, err := s.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	s.SetReadDeadline(time.Now())
+	if err := <-readErr; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatal("expected os.ErrDeadlineExceeded, got", err)
+	}
+}
+
 func BenchmarkMux(b *testing.B) {
 	for _, numStreams := range []int{1, 2, 10, 100, 500, 1000} {
 		b.Run(fmt.Sprint(numStreams), func(b *testing.B) {
@@ -440,3 +648,296 @@ func BenchmarkConn(b *testing.B) {
 		}
 	}
 }
+
+func TestRekeyAndTranscript(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	data := make([]byte, 4*minRekeyInterval)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var transcript bytes.Buffer
+	serverCh := make(chan *Mux, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		m, err := AcceptWithOptions(conn, serverKey, Options{Transcript: &transcript})
+		if err != nil {
+			return
+		}
+		s, err := m.AcceptStream()
+		if err != nil {
+			return
+		}
+		io.CopyN(s, s, int64(len(data)))
+		s.Close()
+		serverCh <- m
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DialWithOptions(conn, serverKey.Public().(ed25519.PublicKey), Options{RekeyInterval: minRekeyInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	initialKey := m.send.key
+	s, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(data[:1]); err != nil {
+		t.Fatal(err)
+	}
+	go s.Write(data[1:])
+	echoed := make([]byte, len(data))
+	if _, err := io.ReadFull(s, echoed); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(echoed, data) {
+		t.Fatal("data was corrupted")
+	}
+	s.Close()
+	sm := <-serverCh
+	sm.Close()
+	if m.settings.RekeyInterval != minRekeyInterval {
+		t.Fatal("peers did not use the smaller rekey interval")
+	} else if m.send.key == initialKey || m.recv.key == initialKey {
+		t.Fatal("keys were not rotated")
+	} else if m.TranscriptKey() != sm.TranscriptKey() {
+		t.Fatal("peers derived different transcript keys")
+	}
+
+	// the transcript should contain everything the server received
+	records, err := ReadTranscript(bytes.NewReader(transcript.Bytes()), sm.TranscriptKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []byte
+	for _, r := range records {
+		if !r.Outgoing {
+			received = append(received, r.Payload...)
+		}
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("transcript does not match received data")
+	}
+
+	// tampering should be detected
+	tampered := append([]byte(nil), transcript.Bytes()...)
+	tampered[100] ^= 1
+	if _, err := ReadTranscript(bytes.NewReader(tampered), sm.TranscriptKey()); !errors.Is(err, ErrInvalidTranscript) {
+		t.Fatalf("expected %v, got %v", ErrInvalidTranscript, err)
+	}
+}
+
+func TestKeepaliveIdleTimeout(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// connect returns the server side of a Mux with the given idle timeout;
+	// the client side sends keepalives at the given interval
+	connect := func(keepalive, idle time.Duration) (*Mux, *Mux) {
+		serverCh := make(chan *Mux, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				serverCh <- nil
+				return
+			}
+			m, _ := AcceptWithOptions(conn, serverKey, Options{IdleTimeout: idle})
+			serverCh <- m
+		}()
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cm, err := DialWithOptions(conn, serverKey.Public().(ed25519.PublicKey), Options{KeepaliveInterval: keepalive})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm := <-serverCh
+		if sm == nil {
+			t.Fatal("server handshake failed")
+		}
+		return sm, cm
+	}
+
+	// keepalives prevent the idle timeout from expiring; the keepalive interval
+	// is a small fraction of the idle timeout, so that slow runners (e.g. wasm)
+	// do not miss the deadline
+	const idle = 500 * time.Millisecond
+	sm, cm := connect(idle/50, idle)
+	time.Sleep(3 * idle)
+	s, err := cm.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if ss, err := sm.AcceptStream(); err != nil {
+		t.Fatal("expected connection to survive, got", err)
+	} else {
+		ss.Close()
+	}
+	sm.Close()
+	cm.Close()
+
+	// a silent peer is detected, and pending calls are interrupted
+	sm, cm = connect(0, 100*time.Millisecond)
+	defer cm.Close()
+	start := time.Now()
+	if _, err := sm.AcceptStream(); err != ErrPeerTimedOut {
+		t.Fatal("expected ErrPeerTimedOut, got", err)
+	} else if time.Since(start) > 5*time.Second {
+		t.Fatal("idle timeout was not enforced promptly")
+	}
+	if err := sm.Close(); err != ErrPeerTimedOut {
+		t.Fatal("expected Close to report ErrPeerTimedOut, got", err)
+	}
+}
+
+// baselineSettings encodes cs as the 24-byte settings payload of a version 1
+// peer.
+func baselineSettings(cs connSettings) []byte {
+	buf := make([]byte, 24)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(cs.RequestedPacketSize))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(cs.MaxFrameSizePackets))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(cs.MaxTimeout.Seconds()))
+	return buf
+}
+
+// baselineHandshake conducts one side of the version 1 handshake, as a peer
+// predating version negotiation would, returning the connection's cipher.
+func baselineHandshake(conn net.Conn, dial bool, key ed25519.PrivateKey) (cipher.AEAD, error) {
+	theirVersion := make([]byte, 1)
+	if dial {
+		if _, err := conn.Write([]byte{1}); err != nil {
+			return nil, err
+		} else if _, err := io.ReadFull(conn, theirVersion); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := io.ReadFull(conn, theirVersion); err != nil {
+			return nil, err
+		} else if _, err := conn.Write([]byte{1}); err != nil {
+			return nil, err
+		}
+	}
+	if theirVersion[0] != 1 {
+		return nil, fmt.Errorf("bad version %v", theirVersion[0])
+	}
+
+	var cipherKey [32]byte
+	var err error
+	if dial {
+		cipherKey, err = initiateEncryptionHandshake(conn, key.Public().(ed25519.PublicKey))
+	} else {
+		cipherKey, err = acceptEncryptionHandshake(conn, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(cipherKey[:])
+	if err != nil {
+		return nil, err
+	}
+
+	frameBuf := make([]byte, settingsFrameSize)
+	writeSettings := func() error {
+		frame := encryptFrame(frameBuf, frameHeader{id: idUpdateSettings, length: 24}, baselineSettings(defaultConnSettings), settingsFrameSize, aead)
+		_, err := conn.Write(frame)
+		return err
+	}
+	if dial {
+		if err := writeSettings(); err != nil {
+			return nil, err
+		}
+	}
+	if h, _, err := readEncryptedFrame(conn, frameBuf, settingsFrameSize, aead); err != nil {
+		return nil, err
+	} else if h.id != idUpdateSettings || h.length != 24 {
+		return nil, fmt.Errorf("invalid settings frame (id %v, length %v)", h.id, h.length)
+	}
+	if !dial {
+		if err := writeSettings(); err != nil {
+			return nil, err
+		}
+	}
+	return aead, nil
+}
+
+func TestBaselineInterop(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+	// readHello reads the first frame sent by m from a baseline peer
+	readHello := func(conn net.Conn, aead cipher.AEAD) error {
+		buf := make([]byte, defaultConnSettings.maxFrameSize())
+		_, payload, err := readEncryptedFrame(conn, buf, defaultConnSettings.RequestedPacketSize, aead)
+		if err != nil {
+			return err
+		} else if string(payload) != "hello" {
+			return fmt.Errorf("expected hello, got %q", payload)
+		}
+		return nil
+	}
+	sendHello := func(m *Mux) error {
+		if m.settings.RekeyInterval != 0 || m.settings.StreamWindow != 0 {
+			return errors.New("version 1 connection should not enable rekeying or flow control")
+		}
+		s, err := m.DialStream()
+		if err != nil {
+			return err
+		}
+		_, err = s.Write([]byte("hello"))
+		return err
+	}
+
+	for _, dial := range []bool{true, false} {
+		c1, c2 := net.Pipe()
+		peerCh := make(chan error, 1)
+		go func() {
+			c2.SetDeadline(time.Now().Add(5 * time.Second))
+			err := func() error {
+				aead, err := baselineHandshake(c2, !dial, serverKey)
+				if err != nil {
+					return err
+				}
+				return readHello(c2, aead)
+			}()
+			if err != nil {
+				c2.Close() // unblock m
+			}
+			peerCh <- err
+		}()
+
+		var m *Mux
+		var err error
+		if dial {
+			m, err = Dial(c1, serverKey.Public().(ed25519.PublicKey))
+		} else {
+			m, err = Accept(c1, serverKey)
+		}
+		if err != nil {
+			t.Fatal(err, <-peerCh)
+		} else if err := sendHello(m); err != nil {
+			t.Fatal(err)
+		} else if err := <-peerCh; err != nil {
+			t.Fatal(err)
+		}
+		m.Close()
+		c2.Close()
+	}
+}
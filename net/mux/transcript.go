@@ -0,0 +1,102 @@
+package mux
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrInvalidTranscript is returned by ReadTranscript if a record was modified,
+// reordered, or removed from before the last record. Records removed from the
+// end of a transcript are not detected.
+var ErrInvalidTranscript = errors.New("transcript record has invalid MAC")
+
+// A TranscriptRecord is a frame sent or received by a Mux.
+type TranscriptRecord struct {
+	Outgoing bool
+	StreamID uint32
+	Flags    uint16
+	Payload  []byte
+}
+
+// A transcript writes a record of each frame, followed by a MAC of the record
+// and the previous MAC. Chaining the MACs ensures that records cannot be
+// reordered or removed without detection, except by truncating the transcript
+// after a record.
+type transcript struct {
+	mu  sync.Mutex
+	w   io.Writer
+	key [32]byte
+	mac [32]byte
+}
+
+func transcriptMAC(key, prev [32]byte, record []byte) (mac [32]byte) {
+	h, _ := blake2b.New256(key[:]) // no error possible with a 32-byte key
+	h.Write(prev[:])
+	h.Write(record)
+	h.Sum(mac[:0])
+	return
+}
+
+// record appends a record of a frame to the transcript. It is a no-op if t is
+// nil or the frame is a keepalive.
+func (t *transcript) record(outgoing bool, h frameHeader, payload []byte) error {
+	if t == nil || h.id == idKeepalive {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	buf := make([]byte, 1+frameHeaderSize, 1+frameHeaderSize+len(payload)+len(t.mac))
+	if outgoing {
+		buf[0] = 1
+	}
+	h.length = uint32(len(payload))
+	encodeFrameHeader(buf[1:], h)
+	buf = append(buf, payload...)
+	t.mac = transcriptMAC(t.key, t.mac, buf)
+	buf = append(buf, t.mac[:]...)
+	if _, err := t.w.Write(buf); err != nil {
+		return fmt.Errorf("could not write transcript: %w", err)
+	}
+	return nil
+}
+
+// ReadTranscript reads and authenticates the records of a transcript written by
+// a Mux with the given TranscriptKey. A truncated transcript authenticates as
+// the records preceding the truncation; callers that must detect this should
+// compare the number of records against a count obtained elsewhere.
+func ReadTranscript(r io.Reader, key [32]byte) ([]TranscriptRecord, error) {
+	var records []TranscriptRecord
+	var prev [32]byte
+	for {
+		buf := make([]byte, 1+frameHeaderSize)
+		if _, err := io.ReadFull(r, buf); err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read record header: %w", err)
+		}
+		h := decodeFrameHeader(buf[1:])
+		if h.length > uint32(defaultConnSettings.maxPayloadSize()) {
+			return nil, fmt.Errorf("record payload is too large (%v bytes)", h.length)
+		}
+		buf = append(buf, make([]byte, int(h.length)+len(prev))...)
+		if _, err := io.ReadFull(r, buf[1+frameHeaderSize:]); err != nil {
+			return nil, fmt.Errorf("could not read record: %w", err)
+		}
+		record, mac := buf[:len(buf)-len(prev)], buf[len(buf)-len(prev):]
+		prev = transcriptMAC(key, prev, record)
+		if subtle.ConstantTimeCompare(prev[:], mac) != 1 {
+			return nil, ErrInvalidTranscript
+		}
+		records = append(records, TranscriptRecord{
+			Outgoing: buf[0] == 1,
+			StreamID: h.id,
+			Flags:    h.flags,
+			Payload:  record[1+frameHeaderSize:],
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// Errors returned when verifying ephemeral account payments.
+var (
+	ErrWithdrawalExpired    = errors.New("withdrawal message has expired")
+	ErrWithdrawalExpiryFar  = errors.New("withdrawal message expires too far in the future")
+	ErrWithdrawalInvalidSig = errors.New("withdrawal message has an invalid signature")
+	ErrReceiptInvalidSig    = errors.New("receipt has an invalid signature")
+)
+
+// NewPayByEphemeralAccountRequest returns a request, signed by the account
+// key, to withdraw amount from the account. The message is given a random
+// nonce, so that the host can reject replays.
+func NewPayByEphemeralAccountRequest(account types.PrivateKey, expiry uint64, amount types.Currency, priority uint64) PayByEphemeralAccountRequest {
+	req := PayByEphemeralAccountRequest{
+		Message: WithdrawalMessage{
+			AccountID: account.PublicKey(),
+			Expiry:    expiry,
+			Amount:    amount,
+		},
+		Priority: priority,
+	}
+	types.ReadEntropy(req.Message.Nonce[:])
+	req.Signature = account.SignHash(req.Message.SigHash())
+	return req
+}
+
+// Verify returns an error if the request was not signed by its account key,
+// or if its expiry is not within (currentHeight, currentHeight+maxExpiry].
+// Bounding the expiry bounds the number of message hashes that a host must
+// remember in order to reject replays; the hash (i.e. the message's SigHash)
+// should be passed to EphemeralAccountStore.Debit as its request ID.
+func (req *PayByEphemeralAccountRequest) Verify(currentHeight, maxExpiry uint64) error {
+	switch {
+	case req.Message.Expiry <= currentHeight:
+		return fmt.Errorf("%w (expiry %v, current height %v)", ErrWithdrawalExpired, req.Message.Expiry, currentHeight)
+	case req.Message.Expiry-currentHeight > maxExpiry:
+		return fmt.Errorf("%w (expiry %v, current height %v)", ErrWithdrawalExpiryFar, req.Message.Expiry, currentHeight)
+	case !req.Message.AccountID.VerifyHash(req.Message.SigHash(), req.Signature):
+		return ErrWithdrawalInvalidSig
+	}
+	return nil
+}
+
+// NewRPCFundAccountResponse returns a response to the FundAccount RPC,
+// including a receipt for the deposit signed by the host.
+func NewRPCFundAccountResponse(host types.PrivateKey, receipt Receipt, balance types.Currency) RPCFundAccountResponse {
+	receipt.Host = host.PublicKey()
+	return RPCFundAccountResponse{
+		Balance:   balance,
+		Receipt:   receipt,
+		Signature: host.SignHash(receipt.SigHash()),
+	}
+}
+
+// VerifyReceipt returns an error if the response's receipt was not signed by
+// the given host, or is for a different account.
+func (resp *RPCFundAccountResponse) VerifyReceipt(host, account types.PublicKey) error {
+	switch {
+	case resp.Receipt.Host != host:
+		return fmt.Errorf("receipt is for a different host (%v)", resp.Receipt.Host)
+	case resp.Receipt.Account != account:
+		return fmt.Errorf("receipt is for a different account (%v)", resp.Receipt.Account)
+	case !host.VerifyHash(resp.Receipt.SigHash(), resp.Signature):
+		return ErrReceiptInvalidSig
+	}
+	return nil
+}
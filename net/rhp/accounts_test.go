@@ -0,0 +1,48 @@
+package rhp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestPayByEphemeralAccount(t *testing.T) {
+	account := types.GeneratePrivateKey()
+	req := NewPayByEphemeralAccountRequest(account, 110, types.Siacoins(1), 0)
+	if err := req.Verify(100, 20); err != nil {
+		t.Fatal(err)
+	} else if err := req.Verify(110, 20); !errors.Is(err, ErrWithdrawalExpired) {
+		t.Fatalf("expected %v, got %v", ErrWithdrawalExpired, err)
+	} else if err := req.Verify(80, 20); !errors.Is(err, ErrWithdrawalExpiryFar) {
+		t.Fatalf("expected %v, got %v", ErrWithdrawalExpiryFar, err)
+	}
+	if other := NewPayByEphemeralAccountRequest(account, 110, types.Siacoins(1), 0); other.Message.SigHash() == req.Message.SigHash() {
+		t.Fatal("identical withdrawals should have distinct hashes")
+	}
+	forged := req
+	forged.Message.Amount = types.Siacoins(2)
+	if err := forged.Verify(100, 20); !errors.Is(err, ErrWithdrawalInvalidSig) {
+		t.Fatalf("expected %v, got %v", ErrWithdrawalInvalidSig, err)
+	}
+
+	host := types.GeneratePrivateKey()
+	receipt := Receipt{
+		Account:   account.PublicKey(),
+		Amount:    types.Siacoins(1),
+		Timestamp: time.Now(),
+	}
+	resp := NewRPCFundAccountResponse(host, receipt, types.Siacoins(3))
+	if err := resp.VerifyReceipt(host.PublicKey(), account.PublicKey()); err != nil {
+		t.Fatal(err)
+	} else if err := resp.VerifyReceipt(account.PublicKey(), account.PublicKey()); err == nil {
+		t.Fatal("expected receipt from wrong host to be rejected")
+	} else if err := resp.VerifyReceipt(host.PublicKey(), host.PublicKey()); err == nil {
+		t.Fatal("expected receipt for wrong account to be rejected")
+	}
+	resp.Receipt.Amount = types.Siacoins(100)
+	if err := resp.VerifyReceipt(host.PublicKey(), account.PublicKey()); !errors.Is(err, ErrReceiptInvalidSig) {
+		t.Fatalf("expected %v, got %v", ErrReceiptInvalidSig, err)
+	}
+}
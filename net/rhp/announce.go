@@ -0,0 +1,98 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// MaxNetAddressLen is the maximum length of an announced network address.
+const MaxNetAddressLen = 256
+
+// A HostAnnouncement associates a host's public key with the network address
+// at which it can be reached.
+//
+// Announcements are published on-chain as attestations whose Key is
+// types.HostAnnouncementKey and whose Value is the host's network address, in
+// "host:port" form. Since attestations are signed, and their signatures are
+// checked by consensus, hostdb implementations need only parse the
+// attestations of each block to discover hosts.
+type HostAnnouncement struct {
+	PublicKey  types.PublicKey `json:"publicKey"`
+	NetAddress string          `json:"netAddress"`
+}
+
+// ValidateNetAddress returns an error if addr is not a valid announced network
+// address.
+func ValidateNetAddress(addr string) error {
+	if len(addr) > MaxNetAddressLen {
+		return fmt.Errorf("network address is too long (%v > %v bytes)", len(addr), MaxNetAddressLen)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid network address: %w", err)
+	} else if host == "" {
+		return errors.New("network address is missing a host")
+	} else if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// NewHostAnnouncement returns a signed attestation announcing the host's
+// network address.
+func NewHostAnnouncement(vc consensus.ValidationContext, priv types.PrivateKey, netAddress string) (types.Attestation, error) {
+	if err := ValidateNetAddress(netAddress); err != nil {
+		return types.Attestation{}, err
+	}
+	a := types.Attestation{
+		PublicKey: priv.PublicKey(),
+		Key:       types.HostAnnouncementKey,
+		Value:     []byte(netAddress),
+	}
+	a.Signature = priv.SignHash(vc.AttestationSigHash(a))
+	return a, nil
+}
+
+// ParseHostAnnouncement parses a host announcement from an attestation. It
+// returns false if the attestation is not a well-formed host announcement. The
+// attestation's signature is not checked; see VerifyHostAnnouncement.
+func ParseHostAnnouncement(a types.Attestation) (HostAnnouncement, bool) {
+	if a.Key != types.HostAnnouncementKey || ValidateNetAddress(string(a.Value)) != nil {
+		return HostAnnouncement{}, false
+	}
+	return HostAnnouncement{
+		PublicKey:  a.PublicKey,
+		NetAddress: string(a.Value),
+	}, true
+}
+
+// VerifyHostAnnouncement parses a host announcement from an attestation and
+// verifies its signature. It is intended for attestations that have not been
+// validated by consensus, e.g. those in unconfirmed transactions.
+func VerifyHostAnnouncement(vc consensus.ValidationContext, a types.Attestation) (HostAnnouncement, error) {
+	ha, ok := ParseHostAnnouncement(a)
+	if !ok {
+		return HostAnnouncement{}, errors.New("attestation is not a valid host announcement")
+	} else if !a.PublicKey.VerifyHash(vc.AttestationSigHash(a), a.Signature) {
+		return HostAnnouncement{}, errors.New("host announcement has invalid signature")
+	}
+	return ha, nil
+}
+
+// AppliedHostAnnouncements returns the host announcements in an applied block,
+// in order. If a host announces more than once, each announcement supersedes
+// the previous one.
+func AppliedHostAnnouncements(au consensus.ApplyUpdate) []HostAnnouncement {
+	var has []HostAnnouncement
+	for _, a := range au.Attestations {
+		if ha, ok := ParseHostAnnouncement(a); ok {
+			has = append(has, ha)
+		}
+	}
+	return has
+}
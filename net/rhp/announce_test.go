@@ -0,0 +1,43 @@
+package rhp
+
+import (
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestHostAnnouncement(t *testing.T) {
+	vc := consensus.ValidationContext{ChainID: types.HashBytes([]byte("testnet"))}
+	priv := types.GeneratePrivateKey()
+
+	for _, addr := range []string{"", "foo.com", ":9982", "foo.com:0", "foo.com:99999", "foo.com:http"} {
+		if _, err := NewHostAnnouncement(vc, priv, addr); err == nil {
+			t.Errorf("expected %q to be rejected", addr)
+		}
+	}
+
+	a, err := NewHostAnnouncement(vc, priv, "foo.com:9982")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ha, err := VerifyHostAnnouncement(vc, a)
+	if err != nil {
+		t.Fatal(err)
+	} else if ha.PublicKey != priv.PublicKey() || ha.NetAddress != "foo.com:9982" {
+		t.Fatal("wrong announcement", ha)
+	}
+
+	// announcements signed for a different chain should be rejected
+	otherVC := consensus.ValidationContext{ChainID: types.HashBytes([]byte("mainnet"))}
+	if _, err := VerifyHostAnnouncement(otherVC, a); err == nil {
+		t.Fatal("expected announcement for another chain to be rejected")
+	}
+
+	other := types.Attestation{PublicKey: priv.PublicKey(), Key: "foo", Value: []byte("bar.com:9982")}
+	malformed := types.Attestation{PublicKey: priv.PublicKey(), Key: types.HostAnnouncementKey, Value: []byte("bar.com")}
+	au := consensus.ApplyUpdate{Attestations: []types.Attestation{other, a, malformed}}
+	if has := AppliedHostAnnouncements(au); len(has) != 1 || has[0] != ha {
+		t.Fatal("wrong announcements in update", has)
+	}
+}
@@ -0,0 +1,124 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// Errors returned when verifying a key certificate.
+var (
+	ErrCertificateWrongIdentity = errors.New("key certificate is for a different host")
+	ErrCertificateInvalidSig    = errors.New("key certificate has an invalid signature")
+	ErrCertificateNotValid      = errors.New("key certificate is not valid at this time")
+)
+
+// A KeyCertificate delegates a host's identity to an operational key for a
+// limited time. The identity key, which appears in the host's contracts and
+// announcements, can then be kept offline (e.g. in an HSM), while the
+// operational key is used to authenticate sessions. If the operational key is
+// compromised or must be migrated, the host simply certifies a new one; its
+// contracts and reputation, which are tied to the identity key, are
+// unaffected.
+type KeyCertificate struct {
+	IdentityKey    types.PublicKey
+	OperationalKey types.PublicKey
+	ValidFrom      time.Time
+	ValidUntil     time.Time
+	Signature      types.Signature
+}
+
+// NewKeyCertificate returns a certificate, signed by identity, that is valid
+// for the given window.
+func NewKeyCertificate(identity types.PrivateKey, operational types.PublicKey, validFrom, validUntil time.Time) KeyCertificate {
+	c := KeyCertificate{
+		IdentityKey:    identity.PublicKey(),
+		OperationalKey: operational,
+		ValidFrom:      validFrom,
+		ValidUntil:     validUntil,
+	}
+	c.Signature = identity.SignHash(c.SigHash())
+	return c
+}
+
+// SigHash returns the hash of the certificate that is signed by the identity
+// key.
+func (c *KeyCertificate) SigHash() types.Hash256 {
+	h := types.NewHasher()
+	h.E.WriteString(types.DomainRHPKeyCertificate)
+	c.IdentityKey.EncodeTo(h.E)
+	c.OperationalKey.EncodeTo(h.E)
+	h.E.WriteTime(c.ValidFrom)
+	h.E.WriteTime(c.ValidUntil)
+	return h.Sum()
+}
+
+// Verify returns an error if c was not signed by identity or is not valid at
+// the given time.
+func (c *KeyCertificate) Verify(identity types.PublicKey, now time.Time) error {
+	switch {
+	case c.IdentityKey != identity:
+		return ErrCertificateWrongIdentity
+	case now.Before(c.ValidFrom) || !now.Before(c.ValidUntil):
+		return fmt.Errorf("%w (valid from %v until %v)", ErrCertificateNotValid, c.ValidFrom, c.ValidUntil)
+	case !identity.VerifyHash(c.SigHash(), c.Signature):
+		return ErrCertificateInvalidSig
+	}
+	return nil
+}
+
+// EncodeTo implements types.EncoderTo.
+func (c *KeyCertificate) EncodeTo(e *types.Encoder) {
+	c.IdentityKey.EncodeTo(e)
+	c.OperationalKey.EncodeTo(e)
+	e.WriteTime(c.ValidFrom)
+	e.WriteTime(c.ValidUntil)
+	c.Signature.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (c *KeyCertificate) DecodeFrom(d *types.Decoder) {
+	c.IdentityKey.DecodeFrom(d)
+	c.OperationalKey.DecodeFrom(d)
+	c.ValidFrom = d.ReadTime()
+	c.ValidUntil = d.ReadTime()
+	c.Signature.DecodeFrom(d)
+}
+
+// MaxLen implements rpc.Object.
+func (c *KeyCertificate) MaxLen() int {
+	return 32 + 32 + 8 + 8 + 64
+}
+
+// NewKeyCertificateAnnouncement returns an attestation, signed by the host's
+// identity key, that publishes c on-chain so that renters can discover the
+// host's current operational key.
+func NewKeyCertificateAnnouncement(vc consensus.ValidationContext, identity types.PrivateKey, c KeyCertificate) types.Attestation {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	c.EncodeTo(e)
+	e.Flush()
+	a := types.Attestation{
+		PublicKey: identity.PublicKey(),
+		Key:       types.HostKeyCertificateKey,
+		Value:     buf.Bytes(),
+	}
+	a.Signature = identity.SignHash(vc.AttestationSigHash(a))
+	return a
+}
+
+// ParseKeyCertificateAnnouncement parses a key certificate from an
+// attestation. It returns false if the attestation is not a well-formed
+// certificate announcement. The certificate itself is not verified; renters
+// should call Verify before using it.
+func ParseKeyCertificateAnnouncement(a types.Attestation) (KeyCertificate, bool) {
+	var c KeyCertificate
+	if a.Key != types.HostKeyCertificateKey || types.DecodeFromStrict(a.Value, &c) != nil || c.IdentityKey != a.PublicKey {
+		return KeyCertificate{}, false
+	}
+	return c, true
+}
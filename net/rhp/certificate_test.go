@@ -0,0 +1,97 @@
+package rhp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestKeyCertificate(t *testing.T) {
+	identity, operational := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	now := time.Now()
+	c := NewKeyCertificate(identity, operational.PublicKey(), now.Add(-time.Hour), now.Add(time.Hour))
+	if err := c.Verify(identity.PublicKey(), now); err != nil {
+		t.Fatal(err)
+	} else if err := c.Verify(operational.PublicKey(), now); !errors.Is(err, ErrCertificateWrongIdentity) {
+		t.Fatalf("expected %v, got %v", ErrCertificateWrongIdentity, err)
+	} else if err := c.Verify(identity.PublicKey(), now.Add(time.Hour)); !errors.Is(err, ErrCertificateNotValid) {
+		t.Fatalf("expected %v, got %v", ErrCertificateNotValid, err)
+	} else if err := c.Verify(identity.PublicKey(), now.Add(-2*time.Hour)); !errors.Is(err, ErrCertificateNotValid) {
+		t.Fatalf("expected %v, got %v", ErrCertificateNotValid, err)
+	}
+	forged := c
+	forged.OperationalKey = types.GeneratePrivateKey().PublicKey()
+	if err := forged.Verify(identity.PublicKey(), now); !errors.Is(err, ErrCertificateInvalidSig) {
+		t.Fatalf("expected %v, got %v", ErrCertificateInvalidSig, err)
+	}
+
+	vc := consensus.ValidationContext{}
+	a := NewKeyCertificateAnnouncement(vc, identity, c)
+	if parsed, ok := ParseKeyCertificateAnnouncement(a); !ok {
+		t.Fatal("failed to parse certificate announcement")
+	} else if parsed.SigHash() != c.SigHash() || parsed.Signature != c.Signature {
+		t.Fatal("wrong certificate parsed")
+	} else if !a.PublicKey.VerifyHash(vc.AttestationSigHash(a), a.Signature) {
+		t.Fatal("invalid attestation signature")
+	}
+	a.PublicKey = operational.PublicKey()
+	if _, ok := ParseKeyCertificateAnnouncement(a); ok {
+		t.Fatal("expected certificate announced by another key to be rejected")
+	}
+}
+
+func TestSessionKeyCertificate(t *testing.T) {
+	identity, operational := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if sess, err := AcceptSession(conn, operational); err == nil {
+					sess.Close()
+				}
+			}()
+		}
+	}()
+	dial := func(c *KeyCertificate) error {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		sess, err := DialSessionWithOptions(conn, identity.PublicKey(), SessionOptions{
+			HandshakeTimeout: 5 * time.Second,
+			Certificate:      c,
+		})
+		if err != nil {
+			return err
+		}
+		return sess.Close()
+	}
+
+	now := time.Now()
+	c := NewKeyCertificate(identity, operational.PublicKey(), now.Add(-time.Hour), now.Add(time.Hour))
+	if err := dial(&c); err != nil {
+		t.Fatal(err)
+	}
+	// without a certificate, the host's operational key does not match
+	if err := dial(nil); err == nil {
+		t.Fatal("expected handshake to fail without certificate")
+	}
+	expired := NewKeyCertificate(identity, operational.PublicKey(), now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if err := dial(&expired); !errors.Is(err, ErrCertificateNotValid) {
+		t.Fatalf("expected %v, got %v", ErrCertificateNotValid, err)
+	}
+}
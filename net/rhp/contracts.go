@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
 )
 
@@ -43,8 +44,8 @@ func (c *Contract) DecodeFrom(dec *types.Decoder) {
 }
 
 // MaxLen implements rpc.Object.
-func (c *Contract) MaxLen() uint64 {
-	return 10e3
+func (c *Contract) MaxLen() int {
+	return rpc.DefaultMaxLen
 }
 
 // PaymentRevision returns a new file contract revision with the specified
@@ -72,6 +73,23 @@ func FinalizeProgramRevision(fc types.FileContract, burn types.Currency) (types.
 	return fc, nil
 }
 
+// WriteRevision returns a new file contract revision with cost moved from the
+// renter's output to the host's valid output, and collateral removed from the
+// host's missed output, as required after a Write RPC. The revision number is
+// incremented; the caller must set the new filesize and Merkle root.
+func WriteRevision(fc types.FileContract, cost, collateral types.Currency) (types.FileContract, error) {
+	if fc.RenterOutput.Value.Cmp(cost) < 0 {
+		return fc, errors.New("insufficient funds")
+	} else if fc.MissedHostValue.Cmp(collateral) < 0 {
+		return fc, errors.New("insufficient collateral")
+	}
+	fc.RevisionNumber++
+	fc.RenterOutput.Value = fc.RenterOutput.Value.Sub(cost)
+	fc.HostOutput.Value = fc.HostOutput.Value.Add(cost)
+	fc.MissedHostValue = fc.MissedHostValue.Sub(collateral)
+	return fc, nil
+}
+
 // ValidateContractFormation verifies that the new contract is valid given the
 // host's settings.
 func ValidateContractFormation(fc types.FileContract, currentHeight uint64, settings HostSettings) error {
@@ -214,6 +232,36 @@ func ValidateProgramRevision(current, revision types.FileContract, additionalSto
 	return nil
 }
 
+// ValidateWriteRevision verifies that a revision following a Write RPC
+// transfers at least cost from the renter to the host, burns at most collateral
+// from the host's missed output, and sets the given filesize and Merkle root.
+// Signatures are not validated.
+func ValidateWriteRevision(current, revision types.FileContract, filesize uint64, root types.Hash256, cost, collateral types.Currency) error {
+	if err := validateStdRevision(current, revision); err != nil {
+		return err
+	}
+	switch {
+	case revision.Filesize != filesize:
+		return errors.New("revision has incorrect filesize")
+	case revision.FileMerkleRoot != root:
+		return errors.New("revision has incorrect Merkle root")
+	case revision.RenterOutput.Value.Cmp(current.RenterOutput.Value) > 0:
+		return errors.New("renter output value must not increase")
+	}
+	payment := current.RenterOutput.Value.Sub(revision.RenterOutput.Value)
+	switch {
+	case payment.Cmp(cost) < 0:
+		return fmt.Errorf("insufficient payment (%v < %v)", payment, cost)
+	case revision.HostOutput.Value != current.HostOutput.Value.Add(payment):
+		return errors.New("host output value should increase by the payment")
+	case revision.MissedHostValue.Cmp(current.MissedHostValue) > 0:
+		return errors.New("host missed output value must not increase")
+	case current.MissedHostValue.Sub(revision.MissedHostValue).Cmp(collateral) > 0:
+		return errors.New("revision burns excessive collateral")
+	}
+	return nil
+}
+
 // ValidatePaymentRevision verifies that a payment revision is valid and the
 // amount is properly deducted from both renter outputs and added to both host
 // outputs. Signatures are not validated.
@@ -240,3 +288,80 @@ func ValidatePaymentRevision(current, revision types.FileContract, amount types.
 	}
 	return nil
 }
+
+// A ReconcileAction describes how a renter should proceed after comparing its
+// latest revision of a contract with the host's.
+type ReconcileAction uint8
+
+const (
+	// ReconcileInSync indicates that the renter and host agree on the latest
+	// revision.
+	ReconcileInSync ReconcileAction = iota
+	// ReconcileReissue indicates that the host never received the renter's
+	// pending revision, e.g. because the connection was lost mid-Write. The
+	// renter should re-issue the RPC that produced it.
+	ReconcileReissue
+	// ReconcileAdopt indicates that the host holds a newer revision, signed by
+	// both parties, which the renter should adopt. If the host's revision
+	// matches the renter's pending revision, the RPC that produced it
+	// succeeded.
+	ReconcileAdopt
+	// ReconcileRestore indicates that the renter holds a newer revision than
+	// the host, which the host should restore.
+	ReconcileRestore
+)
+
+func (a ReconcileAction) String() string {
+	switch a {
+	case ReconcileInSync:
+		return "in sync"
+	case ReconcileReissue:
+		return "reissue"
+	case ReconcileAdopt:
+		return "adopt"
+	case ReconcileRestore:
+		return "restore"
+	default:
+		return fmt.Sprintf("ReconcileAction(%d)", uint8(a))
+	}
+}
+
+// ReconcileRevision compares the renter's latest revision of a contract, ours,
+// with the host's, theirs, as reported after reconnecting. If the renter was
+// waiting on the host's signature for a new revision when the connection was
+// lost, that revision should be passed as pending; otherwise pending should be
+// nil. ReconcileRevision returns the revision that the renter should use going
+// forward, along with the action the renter should take.
+func ReconcileRevision(vc consensus.ValidationContext, ours Contract, pending *types.FileContract, theirs Contract) (Contract, ReconcileAction, error) {
+	if ours.ID != theirs.ID {
+		return Contract{}, 0, errors.New("host reported a different contract")
+	} else if err := theirs.ValidateSignatures(vc); err != nil {
+		return Contract{}, 0, fmt.Errorf("host reported an invalid revision: %w", err)
+	}
+	switch {
+	case theirs.Revision.RevisionNumber < ours.Revision.RevisionNumber:
+		return ours, ReconcileRestore, nil
+	case theirs.Revision.RevisionNumber > ours.Revision.RevisionNumber:
+		return theirs, ReconcileAdopt, nil
+	case theirs.Revision != ours.Revision:
+		return Contract{}, 0, errors.New("host reported a conflicting revision with the same revision number")
+	case pending != nil && pending.RevisionNumber > ours.Revision.RevisionNumber:
+		return ours, ReconcileReissue, nil
+	}
+	return ours, ReconcileInSync, nil
+}
+
+// ValidateRestoredRevision verifies that a revision presented by a renter
+// during reconciliation may replace the host's current revision: it must
+// revise the same contract, be signed by both parties, and have a higher
+// revision number.
+func ValidateRestoredRevision(vc consensus.ValidationContext, current, restored Contract) error {
+	if current.ID != restored.ID {
+		return errors.New("contract ID must not change")
+	} else if err := restored.ValidateSignatures(vc); err != nil {
+		return err
+	} else if err := validateStdRevision(current.Revision, restored.Revision); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package rhp
+
+import (
+	"testing"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestReconcileRevision(t *testing.T) {
+	var vc consensus.ValidationContext
+	renterKey := types.NewPrivateKeyFromSeed([32]byte{1})
+	hostKey := types.NewPrivateKeyFromSeed([32]byte{2})
+	sign := func(fc types.FileContract) types.FileContract {
+		hash := vc.ContractSigHash(fc)
+		fc.RenterSignature = renterKey.SignHash(hash)
+		fc.HostSignature = hostKey.SignHash(hash)
+		return fc
+	}
+	fc := types.FileContract{
+		WindowStart:     10,
+		WindowEnd:       20,
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   hostKey.PublicKey(),
+		RevisionNumber:  1,
+	}
+	id := types.ElementID{Index: 1}
+	ours := Contract{ID: id, Revision: sign(fc)}
+	fc.RevisionNumber++
+	fc.Filesize = SectorSize
+	pending := fc
+	newer := Contract{ID: id, Revision: sign(pending)}
+
+	tests := []struct {
+		desc    string
+		pending *types.FileContract
+		theirs  Contract
+		action  ReconcileAction
+		result  Contract
+	}{
+		{"in sync", nil, ours, ReconcileInSync, ours},
+		{"lost write", &pending, ours, ReconcileReissue, ours},
+		{"applied write", &pending, newer, ReconcileAdopt, newer},
+		{"missed revision", nil, newer, ReconcileAdopt, newer},
+	}
+	for _, test := range tests {
+		result, action, err := ReconcileRevision(vc, ours, test.pending, test.theirs)
+		if err != nil {
+			t.Fatalf("%v: %v", test.desc, err)
+		} else if action != test.action {
+			t.Fatalf("%v: expected %v, got %v", test.desc, test.action, action)
+		} else if result != test.result {
+			t.Fatalf("%v: wrong resulting revision", test.desc)
+		}
+	}
+
+	// host lost the latest revision and should restore the renter's
+	if result, action, err := ReconcileRevision(vc, newer, nil, ours); err != nil {
+		t.Fatal(err)
+	} else if action != ReconcileRestore || result != newer {
+		t.Fatal("expected host to restore renter's revision, got", action)
+	} else if err := ValidateRestoredRevision(vc, ours, newer); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateRestoredRevision(vc, newer, ours); err == nil {
+		t.Fatal("host should not restore an older revision")
+	}
+
+	// invalid or conflicting revisions should be rejected
+	forged := newer
+	forged.Revision.HostOutput.Value = types.Siacoins(1)
+	conflicting := Contract{ID: id, Revision: forged.Revision}
+	conflicting.Revision.RevisionNumber = ours.Revision.RevisionNumber
+	conflicting.Revision = sign(conflicting.Revision)
+	if _, _, err := ReconcileRevision(vc, ours, nil, forged); err == nil {
+		t.Fatal("expected error for invalid signatures")
+	} else if _, _, err := ReconcileRevision(vc, ours, nil, conflicting); err == nil {
+		t.Fatal("expected error for conflicting revision")
+	} else if _, _, err := ReconcileRevision(vc, ours, nil, Contract{ID: types.ElementID{Index: 2}, Revision: ours.Revision}); err == nil {
+		t.Fatal("expected error for different contract")
+	} else if err := ValidateRestoredRevision(vc, ours, forged); err == nil {
+		t.Fatal("host should not restore a revision with invalid signatures")
+	}
+}
@@ -0,0 +1,211 @@
+package rhp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/types"
+)
+
+// ErrDialerClosed is returned by a Dialer after it has been closed.
+var ErrDialerClosed = errors.New("dialer was closed")
+
+// A Dialer maintains a Session with each host, establishing them on demand and
+// re-establishing them when they fail. Since a Session multiplexes any number
+// of streams, a single Session per host suffices for concurrent RPCs.
+//
+// Consecutive failures to reach a host are followed by an exponentially
+// increasing backoff, during which further attempts to reach the host wait
+// rather than dialing.
+type Dialer struct {
+	// DialContext establishes the connection underlying each Session. The
+	// default is (*net.Dialer).DialContext. Other transports can be used by
+	// returning their connection as a net.Conn, e.g. via websocket.Dial.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Options configures each Session. Its Certificate is ignored, since
+	// certificates are specific to each host.
+	Options SessionOptions
+	// MinBackoff and MaxBackoff bound the delay after a failed attempt to
+	// reach a host. The defaults are 100 milliseconds and 1 minute.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the maximum number of attempts made to reach a host
+	// within a single call. The default is 3.
+	MaxAttempts int
+
+	mu     sync.Mutex
+	hosts  map[types.PublicKey]*dialerHost
+	closed bool
+}
+
+// dialerHost tracks the Session and backoff state for a single host.
+type dialerHost struct {
+	sem      chan struct{} // held while using the fields below
+	sess     *Session
+	failures int
+	retryAt  time.Time
+}
+
+func (d *Dialer) backoff(failures int) time.Duration {
+	minBackoff, maxBackoff := d.MinBackoff, d.MaxBackoff
+	if minBackoff == 0 {
+		minBackoff = 100 * time.Millisecond
+	}
+	if maxBackoff == 0 {
+		maxBackoff = time.Minute
+	}
+	b := minBackoff
+	for i := 1; i < failures && b < maxBackoff; i++ {
+		b *= 2
+	}
+	if b > maxBackoff {
+		b = maxBackoff
+	}
+	return b
+}
+
+func (d *Dialer) host(hostKey types.PublicKey) (*dialerHost, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, ErrDialerClosed
+	}
+	if d.hosts == nil {
+		d.hosts = make(map[types.PublicKey]*dialerHost)
+	}
+	h, ok := d.hosts[hostKey]
+	if !ok {
+		h = &dialerHost{sem: make(chan struct{}, 1)}
+		d.hosts[hostKey] = h
+	}
+	return h, nil
+}
+
+func (d *Dialer) dial(ctx context.Context, hostKey types.PublicKey, addr string) (*Session, error) {
+	dialContext := d.DialContext
+	if dialContext == nil {
+		dialContext = (&net.Dialer{}).DialContext
+	}
+	conn, err := dialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	opts := d.Options
+	opts.Certificate = nil
+	sess, err := DialSessionContext(ctx, conn, hostKey, opts)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// session returns the Session for the host, establishing it if necessary. If
+// stale is non-nil and is the host's current Session, it is discarded.
+func (d *Dialer) session(ctx context.Context, hostKey types.PublicKey, addr string, stale *Session) (*Session, error) {
+	h, err := d.host(hostKey)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case h.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-h.sem }()
+
+	if h.sess != nil && h.sess == stale {
+		h.sess.Close()
+		h.sess = nil
+	}
+	if h.sess != nil {
+		return h.sess, nil
+	}
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	for attempt := 1; ; attempt++ {
+		if wait := time.Until(h.retryAt); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+		sess, err := d.dial(ctx, hostKey, addr)
+		if err == nil {
+			d.mu.Lock()
+			closed := d.closed
+			d.mu.Unlock()
+			if closed {
+				sess.Close()
+				return nil, ErrDialerClosed
+			}
+			h.sess = sess
+			h.failures = 0
+			h.retryAt = time.Time{}
+			return sess, nil
+		} else if ctx.Err() != nil {
+			return nil, err
+		}
+		h.failures++
+		h.retryAt = time.Now().Add(d.backoff(h.failures))
+		if attempt >= maxAttempts {
+			return nil, fmt.Errorf("couldn't reach host after %v attempts: %w", attempt, err)
+		}
+	}
+}
+
+// Session returns the Session for the host with the given key, establishing a
+// new one at addr if necessary. The Session remains owned by the Dialer, and
+// must not be closed by the caller.
+func (d *Dialer) Session(ctx context.Context, hostKey types.PublicKey, addr string) (*Session, error) {
+	return d.session(ctx, hostKey, addr, nil)
+}
+
+// DialStream opens a new stream on the Session for the host with the given
+// key. If the existing Session has failed, it is replaced.
+func (d *Dialer) DialStream(ctx context.Context, hostKey types.PublicKey, addr string) (*mux.Stream, error) {
+	sess, err := d.session(ctx, hostKey, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	s, err := sess.DialStream()
+	if err == nil {
+		return s, nil
+	}
+	// the Session has failed; replace it
+	sess, err = d.session(ctx, hostKey, addr, sess)
+	if err != nil {
+		return nil, err
+	}
+	return sess.DialStream()
+}
+
+// Close closes all of the Dialer's Sessions. Subsequent calls to Session and
+// DialStream return ErrDialerClosed.
+func (d *Dialer) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	hosts := d.hosts
+	d.hosts = nil
+	d.mu.Unlock()
+	for _, h := range hosts {
+		h.sem <- struct{}{}
+		if h.sess != nil {
+			h.sess.Close()
+			h.sess = nil
+		}
+		<-h.sem
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package rhp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestDialer(t *testing.T) {
+	hostPrivKey := types.GeneratePrivateKey()
+	hostPubKey := hostPrivKey.PublicKey()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var mu sync.Mutex
+	var hostSessions []*Session
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sess, err := AcceptSession(conn, hostPrivKey)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			mu.Lock()
+			hostSessions = append(hostSessions, sess)
+			mu.Unlock()
+			go func() {
+				for {
+					s, err := sess.AcceptStream()
+					if err != nil {
+						return
+					}
+					s.Close()
+				}
+			}()
+		}
+	}()
+
+	var dials, failures int
+	d := &Dialer{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dials++
+			if failures > 0 {
+				failures--
+				return nil, errors.New("connection refused")
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+		MinBackoff: 20 * time.Millisecond,
+	}
+	defer d.Close()
+	ctx := context.Background()
+	addr := l.Addr().String()
+
+	// streams share a single Session
+	for i := 0; i < 3; i++ {
+		s, err := d.DialStream(ctx, hostPubKey, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	}
+	if dials != 1 {
+		t.Fatal("expected 1 dial, got", dials)
+	}
+
+	// a failed Session is replaced
+	mu.Lock()
+	hostSessions[0].Close()
+	mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if s, err := d.DialStream(ctx, hostPubKey, addr); err != nil {
+		t.Fatal(err)
+	} else {
+		s.Close()
+	}
+	if dials != 2 {
+		t.Fatal("expected Session to be re-established, got", dials, "dials")
+	}
+
+	// failed dials are retried with backoff
+	old, _ := d.Session(ctx, hostPubKey, addr)
+	old.Close()
+	time.Sleep(100 * time.Millisecond)
+	dials, failures = 0, 2
+	start := time.Now()
+	if s, err := d.DialStream(ctx, hostPubKey, addr); err != nil {
+		t.Fatal(err)
+	} else {
+		s.Close()
+	}
+	if dials != 3 {
+		t.Fatal("expected 3 dials, got", dials)
+	} else if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Fatal("dials were not delayed by backoff:", elapsed)
+	}
+
+	// after MaxAttempts failures, an error is returned
+	old, _ = d.Session(ctx, hostPubKey, addr)
+	old.Close()
+	time.Sleep(100 * time.Millisecond)
+	dials, failures = 0, 10
+	if _, err := d.DialStream(ctx, hostPubKey, addr); err == nil {
+		t.Fatal("expected error after repeated failures")
+	} else if dials != 3 {
+		t.Fatal("expected 3 dials, got", dials)
+	}
+
+	// a canceled context aborts the backoff
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.DialStream(cctx, hostPubKey, addr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	}
+
+	d.Close()
+	if _, err := d.DialStream(ctx, hostPubKey, addr); !errors.Is(err, ErrDialerClosed) {
+		t.Fatal("expected ErrDialerClosed, got", err)
+	}
+}
@@ -0,0 +1,181 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// A HostMode indicates which RPCs a host is currently serving. Hosts that are
+// upgrading or migrating can stop accepting new data without going offline, so
+// that renters can continue to download from them in the meantime.
+type HostMode uint8
+
+// Host modes.
+const (
+	// HostModeNormal indicates that the host is serving all RPCs.
+	HostModeNormal HostMode = iota
+	// HostModeReadOnly indicates that the host is serving reads, but is not
+	// storing new data or forming or renewing contracts. It is expected to
+	// return to HostModeNormal.
+	HostModeReadOnly
+	// HostModeDraining is like HostModeReadOnly, but the host is expected to go
+	// offline, e.g. for an upgrade, once in-progress work has completed.
+	// Renters should finish their downloads promptly, and should not wait for
+	// the host to resume accepting writes.
+	HostModeDraining
+)
+
+// AcceptsWrites returns true if a host in mode m stores new data.
+func (m HostMode) AcceptsWrites() bool {
+	return m == HostModeNormal
+}
+
+// AcceptsReads returns true if a host in mode m serves existing data. Unknown
+// modes are assumed not to.
+func (m HostMode) AcceptsReads() bool {
+	return m <= HostModeDraining
+}
+
+// String implements fmt.Stringer.
+func (m HostMode) String() string {
+	switch m {
+	case HostModeNormal:
+		return "normal"
+	case HostModeReadOnly:
+		return "read-only"
+	case HostModeDraining:
+		return "draining"
+	default:
+		return fmt.Sprintf("HostMode(%d)", uint8(m))
+	}
+}
+
+// ErrorTypeReadOnly is the rpc.Error type returned by hosts that reject an RPC
+// because they are not accepting writes.
+var ErrorTypeReadOnly = rpc.NewSpecifier("HostReadOnly")
+
+// ErrHostReadOnly is returned by hosts in HostModeReadOnly or HostModeDraining
+// in response to an RPC or instruction that would store new data.
+var ErrHostReadOnly = &rpc.Error{
+	Type:        ErrorTypeReadOnly,
+	Description: "host is not accepting writes",
+}
+
+// IsReadOnlyError returns true if err was caused by the host rejecting a write
+// because it is not accepting writes.
+func IsReadOnlyError(err error) bool {
+	var re *rpc.Error
+	return errors.As(err, &re) && re.Type == ErrorTypeReadOnly
+}
+
+// StoresData returns true if the instruction stores new data on the host, and
+// would therefore be rejected by a host that is not accepting writes.
+func StoresData(instr Instruction) bool {
+	switch instr.(type) {
+	case *InstrAppendSector, *InstrUpdateSector, *InstrUpdateRegistry:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProgramStoresData returns true if any of the program's instructions store
+// new data on the host.
+func ProgramStoresData(instrs []Instruction) bool {
+	for _, instr := range instrs {
+		if StoresData(instr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostModes tracks the modes of a renter's hosts, so that writes can be routed
+// away from hosts that are not accepting them while reads continue. Modes are
+// learned from host settings, settings notifications, and RPC errors. Hosts
+// that have not been seen are assumed to be in HostModeNormal. The zero value
+// is ready to use, and HostModes is safe for concurrent use.
+type HostModes struct {
+	mu    sync.Mutex
+	modes map[types.PublicKey]HostMode
+}
+
+func (hm *HostModes) set(host types.PublicKey, mode HostMode) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	if hm.modes == nil {
+		hm.modes = make(map[types.PublicKey]HostMode)
+	}
+	if mode == HostModeNormal {
+		delete(hm.modes, host)
+	} else {
+		hm.modes[host] = mode
+	}
+}
+
+// UpdateSettings records the mode advertised in the host's settings.
+func (hm *HostModes) UpdateSettings(host types.PublicKey, settings HostSettings) {
+	hm.set(host, settings.Mode)
+}
+
+// HandleNotification records the mode implied by a settings notification. A
+// NotifyMaintenance notification marks the host as draining.
+func (hm *HostModes) HandleNotification(host types.PublicKey, n SettingsNotification) {
+	switch n.Type {
+	case NotifySettingsChange:
+		hm.set(host, n.Settings.Mode)
+	case NotifyMaintenance:
+		hm.set(host, HostModeDraining)
+	}
+}
+
+// HandleError marks the host as read-only if err indicates that it rejected a
+// write, unless it is already known to be draining. It returns true if the
+// mode was updated, in which case the write should be retried on another host.
+func (hm *HostModes) HandleError(host types.PublicKey, err error) bool {
+	if !IsReadOnlyError(err) {
+		return false
+	}
+	if hm.Mode(host) == HostModeNormal {
+		hm.set(host, HostModeReadOnly)
+	}
+	return true
+}
+
+// Mode returns the last known mode of the host.
+func (hm *HostModes) Mode(host types.PublicKey) HostMode {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.modes[host]
+}
+
+func (hm *HostModes) filter(hosts []types.PublicKey, fn func(HostMode) bool) []types.PublicKey {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	var filtered []types.PublicKey
+	for _, host := range hosts {
+		if fn(hm.modes[host]) {
+			filtered = append(filtered, host)
+		}
+	}
+	return filtered
+}
+
+// Writable returns the subset of hosts that are accepting writes, preserving
+// their order.
+func (hm *HostModes) Writable(hosts []types.PublicKey) []types.PublicKey {
+	return hm.filter(hosts, HostMode.AcceptsWrites)
+}
+
+// Readable returns the subset of hosts that are serving reads, preserving their
+// order. Hosts that are draining are ordered after all other hosts, so that
+// downloads prefer hosts that will remain online.
+func (hm *HostModes) Readable(hosts []types.PublicKey) []types.PublicKey {
+	preferred := hm.filter(hosts, func(m HostMode) bool { return m.AcceptsReads() && m != HostModeDraining })
+	draining := hm.filter(hosts, func(m HostMode) bool { return m == HostModeDraining })
+	return append(preferred, draining...)
+}
@@ -0,0 +1,82 @@
+package rhp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+func TestHostModeSettings(t *testing.T) {
+	settings := HostSettings{Version: "1.0.0", Mode: HostModeDraining}
+	var buf bytes.Buffer
+	if err := rpc.WriteObject(&buf, &settings); err != nil {
+		t.Fatal(err)
+	}
+	var decoded HostSettings
+	if err := rpc.ReadObject(&buf, &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded.Mode != HostModeDraining {
+		t.Fatalf("expected mode %v, got %v", HostModeDraining, decoded.Mode)
+	}
+}
+
+func TestReadOnlyError(t *testing.T) {
+	var buf bytes.Buffer
+	if err := rpc.WriteResponseErr(&buf, ErrHostReadOnly); err != nil {
+		t.Fatal(err)
+	}
+	var resp RPCLockResponse
+	err := rpc.ReadResponse(&buf, &resp)
+	if !IsReadOnlyError(err) {
+		t.Fatalf("expected read-only error, got %v", err)
+	} else if !IsReadOnlyError(fmt.Errorf("append failed: %w", err)) {
+		t.Fatal("expected wrapped read-only error to be detected")
+	} else if IsReadOnlyError(errors.New(ErrHostReadOnly.Description)) {
+		t.Fatal("expected plain error not to be detected")
+	}
+
+	if !ProgramStoresData([]Instruction{&InstrReadSector{}, &InstrAppendSector{}}) {
+		t.Fatal("expected program with append to store data")
+	} else if ProgramStoresData([]Instruction{&InstrReadSector{}, &InstrHasSector{}}) {
+		t.Fatal("expected read-only program not to store data")
+	}
+}
+
+func TestHostModes(t *testing.T) {
+	hosts := make([]types.PublicKey, 4)
+	for i := range hosts {
+		hosts[i][0] = byte(i)
+	}
+
+	var hm HostModes
+	if w := hm.Writable(hosts); !reflect.DeepEqual(w, hosts) {
+		t.Fatal("expected all hosts to be writable", w)
+	}
+
+	hm.UpdateSettings(hosts[0], HostSettings{Mode: HostModeReadOnly})
+	hm.HandleNotification(hosts[1], SettingsNotification{Type: NotifyMaintenance})
+	if !hm.HandleError(hosts[2], fmt.Errorf("append failed: %w", ErrHostReadOnly)) {
+		t.Fatal("expected read-only error to be handled")
+	} else if hm.HandleError(hosts[3], errors.New("timeout")) {
+		t.Fatal("expected unrelated error to be ignored")
+	}
+
+	if w := hm.Writable(hosts); !reflect.DeepEqual(w, hosts[3:]) {
+		t.Fatal("expected only the last host to be writable", w)
+	}
+	want := []types.PublicKey{hosts[0], hosts[2], hosts[3], hosts[1]}
+	if r := hm.Readable(hosts); !reflect.DeepEqual(r, want) {
+		t.Fatal("expected draining host to be ordered last", r)
+	}
+
+	// a settings change back to normal should restore writes
+	hm.HandleNotification(hosts[1], SettingsNotification{Type: NotifySettingsChange})
+	if m := hm.Mode(hosts[1]); m != HostModeNormal {
+		t.Fatalf("expected mode %v, got %v", HostModeNormal, m)
+	}
+}
@@ -1,12 +1,17 @@
 package rhp
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"math/bits"
+	"runtime"
+	"sort"
+	"sync"
 	"unsafe"
 
-	"go.sia.tech/core/internal/blake2b"
+	"go.sia.tech/core/merkle"
 	"go.sia.tech/core/types"
 )
 
@@ -21,8 +26,8 @@ const (
 // A proofAccumulator is a specialized accumulator for building and verifying
 // Merkle proofs.
 type proofAccumulator struct {
-	trees     [17]types.Hash256 // log2(leavesPerSector) = 16
-	numLeaves uint32
+	trees     [64]types.Hash256
+	numLeaves uint64
 }
 
 func (pa *proofAccumulator) hasNodeAtHeight(height int) bool {
@@ -32,21 +37,21 @@ func (pa *proofAccumulator) hasNodeAtHeight(height int) bool {
 func (pa *proofAccumulator) insertNode(h types.Hash256, height int) {
 	i := height
 	for ; pa.hasNodeAtHeight(i); i++ {
-		h = blake2b.SumPair(pa.trees[i], h)
+		h = merkle.NodeHash(pa.trees[i], h)
 	}
 	pa.trees[i] = h
 	pa.numLeaves += 1 << height
 }
 
 func (pa *proofAccumulator) root() types.Hash256 {
-	i := bits.TrailingZeros32(pa.numLeaves)
-	if i == 32 {
+	i := bits.TrailingZeros64(pa.numLeaves)
+	if i == 64 {
 		return types.Hash256{}
 	}
 	root := pa.trees[i]
 	for i++; i < len(pa.trees); i++ {
 		if pa.hasNodeAtHeight(i) {
-			root = blake2b.SumPair(pa.trees[i], root)
+			root = merkle.NodeHash(pa.trees[i], root)
 		}
 	}
 	return root
@@ -96,11 +101,11 @@ func (sa *sectorAccumulator) appendLeaves(leaves []byte) {
 	}
 	rem := len(leaves) % (leafSize * 4)
 	for i := 0; i < len(leaves)-rem; i += leafSize * 4 {
-		blake2b.SumLeaves(&sa.nodeBuf, (*[4][64]byte)(unsafe.Pointer(&leaves[i])))
+		merkle.LeafHashes(&sa.nodeBuf, (*[4][64]byte)(unsafe.Pointer(&leaves[i])))
 		sa.mergeNodeBuf()
 	}
 	for i := len(leaves) - rem; i < len(leaves); i += leafSize {
-		sa.appendNode(blake2b.SumLeaf((*[64]byte)(unsafe.Pointer(&leaves[i]))))
+		sa.appendNode(merkle.LeafHash((*[64]byte)(unsafe.Pointer(&leaves[i]))))
 	}
 }
 
@@ -110,7 +115,7 @@ func (sa *sectorAccumulator) mergeNodeBuf() {
 	nodes := &sa.nodeBuf
 	i := len(sa.trees) - 1
 	for ; sa.hasNodeAtHeight(i); i-- {
-		blake2b.SumNodes(&sa.trees[i], (*[8][32]byte)(unsafe.Pointer(&sa.trees[i])))
+		merkle.NodeHashes(&sa.trees[i], (*[8][32]byte)(unsafe.Pointer(&sa.trees[i])))
 		nodes = &sa.trees[i]
 	}
 	sa.trees[i] = *nodes
@@ -128,8 +133,8 @@ func (sa *sectorAccumulator) root() types.Hash256 {
 		// that would make root non-idempotent
 		in := (*[8][32]byte)(unsafe.Pointer(&[2][4][32]byte{0: nodes}))
 		out := (*[4][32]byte)(unsafe.Pointer(in))
-		blake2b.SumNodes(out, in)
-		blake2b.SumNodes(out, in)
+		merkle.NodeHashes(out, in)
+		merkle.NodeHashes(out, in)
 		return out[0]
 	}
 
@@ -142,13 +147,13 @@ func (sa *sectorAccumulator) root() types.Hash256 {
 	case 1:
 		root = sa.nodeBuf[0]
 	case 2:
-		root = blake2b.SumPair(sa.nodeBuf[0], sa.nodeBuf[1])
+		root = merkle.NodeHash(sa.nodeBuf[0], sa.nodeBuf[1])
 	case 3:
-		root = blake2b.SumPair(blake2b.SumPair(sa.nodeBuf[0], sa.nodeBuf[1]), sa.nodeBuf[2])
+		root = merkle.NodeHash(merkle.NodeHash(sa.nodeBuf[0], sa.nodeBuf[1]), sa.nodeBuf[2])
 	}
 	for ; i >= 0; i-- {
 		if sa.hasNodeAtHeight(i) {
-			root = blake2b.SumPair(root4(sa.trees[i]), root)
+			root = merkle.NodeHash(root4(sa.trees[i]), root)
 		}
 	}
 	return root
@@ -161,6 +166,110 @@ func SectorRoot(sector *[SectorSize]byte) types.Hash256 {
 	return sa.root()
 }
 
+// maxSectorRootWorkers caps the number of goroutines used by
+// SectorRootParallel. Beyond this point, the subtrees are too small for the
+// additional parallelism to outweigh its overhead.
+const maxSectorRootWorkers = 16
+
+// SectorRootParallel computes the Merkle root of a sector, splitting the work
+// across multiple goroutines. It is equivalent to SectorRoot, which it calls
+// directly when GOMAXPROCS is 1.
+func SectorRootParallel(sector *[SectorSize]byte) types.Hash256 {
+	// the sector is split into a power-of-two number of chunks, each of which
+	// forms a perfect subtree
+	n := runtime.GOMAXPROCS(0)
+	if n > maxSectorRootWorkers {
+		n = maxSectorRootWorkers
+	}
+	n = 1 << (bits.Len(uint(n)) - 1)
+	if n == 1 {
+		return SectorRoot(sector)
+	}
+
+	roots := make([]types.Hash256, n)
+	chunkSize := SectorSize / n
+	var wg sync.WaitGroup
+	for i := range roots {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var sa sectorAccumulator
+			sa.appendLeaves(sector[i*chunkSize:][:chunkSize])
+			roots[i] = sa.root()
+		}(i)
+	}
+	wg.Wait()
+	for len(roots) > 1 {
+		for i := range roots[:len(roots)/2] {
+			roots[i] = merkle.NodeHash(roots[2*i], roots[2*i+1])
+		}
+		roots = roots[:len(roots)/2]
+	}
+	return roots[0]
+}
+
+// ErrSectorOverflow is returned by (*SectorHasher).Write if more than
+// SectorSize bytes are written.
+var ErrSectorOverflow = errors.New("write exceeds sector size")
+
+// A SectorHasher computes the Merkle root of a sector incrementally, as its
+// data is written, so that the sector does not need to be held in memory.
+type SectorHasher struct {
+	sa       sectorAccumulator
+	buf      [leafSize * 4]byte
+	buffered int
+	written  int
+}
+
+// Write implements io.Writer.
+func (sh *SectorHasher) Write(p []byte) (int, error) {
+	if len(p) > SectorSize-sh.written {
+		return 0, ErrSectorOverflow
+	}
+	sh.written += len(p)
+	n := len(p)
+	// top up the buffer first, so that leaves are always appended in groups
+	// of four
+	if sh.buffered > 0 {
+		c := copy(sh.buf[sh.buffered:], p)
+		sh.buffered += c
+		p = p[c:]
+		if sh.buffered < len(sh.buf) {
+			return n, nil
+		}
+		sh.sa.appendLeaves(sh.buf[:])
+		sh.buffered = 0
+	}
+	rem := len(p) % len(sh.buf)
+	sh.sa.appendLeaves(p[:len(p)-rem])
+	sh.buffered = copy(sh.buf[:], p[len(p)-rem:])
+	return n, nil
+}
+
+// Sum returns the Merkle root of the data written so far. If a full sector has
+// been written, this is equal to its SectorRoot; otherwise, the final leaf is
+// padded with zeros. Sum does not change the state of the hasher.
+func (sh *SectorHasher) Sum() types.Hash256 {
+	sa := sh.sa
+	if sh.buffered > 0 {
+		padded := (sh.buffered + leafSize - 1) / leafSize * leafSize
+		var buf [leafSize * 4]byte
+		copy(buf[:], sh.buf[:sh.buffered])
+		sa.appendLeaves(buf[:padded])
+	}
+	return sa.root()
+}
+
+// Reset resets the hasher to its initial state.
+func (sh *SectorHasher) Reset() {
+	*sh = SectorHasher{}
+}
+
+// NewSectorHasher returns a SectorHasher with no data written.
+func NewSectorHasher() *SectorHasher {
+	return new(SectorHasher)
+}
+
 // MetaRoot calculates the root of a set of existing Merkle roots.
 func MetaRoot(roots []types.Hash256) types.Hash256 {
 	// sectorAccumulator is only designed to store one sector's worth of leaves,
@@ -175,7 +284,359 @@ func MetaRoot(roots []types.Hash256) types.Hash256 {
 	}
 	// split at largest power of two
 	split := 1 << (bits.Len(uint(len(roots)-1)) - 1)
-	return blake2b.SumPair(MetaRoot(roots[:split]), MetaRoot(roots[split:]))
+	return merkle.NodeHash(MetaRoot(roots[:split]), MetaRoot(roots[split:]))
+}
+
+// nextSubtreeSize returns the size of the largest subtree that starts at leaf
+// index start and does not extend past end.
+func nextSubtreeSize(start, end uint64) uint64 {
+	ideal := bits.TrailingZeros64(start)
+	max := bits.Len64(end-start) - 1
+	if ideal > max {
+		return 1 << max
+	}
+	return 1 << ideal
+}
+
+// validateProofRange returns the leaf range corresponding to a range of
+// sector bytes. Like the sections of RPCRead, the range must be non-empty and
+// aligned to leaf boundaries.
+func validateProofRange(offset, length uint64) (start, end uint64, err error) {
+	if length == 0 {
+		return 0, 0, errors.New("proof range is empty")
+	} else if offset%leafSize != 0 || length%leafSize != 0 {
+		return 0, 0, fmt.Errorf("proof range must be aligned to %v-byte segments", leafSize)
+	} else if offset > SectorSize || length > SectorSize-offset {
+		return 0, 0, errors.New("proof range exceeds sector size")
+	}
+	return offset / leafSize, (offset + length) / leafSize, nil
+}
+
+// BuildSectorRangeProof constructs a proof that sector[offset:offset+length] is
+// part of the sector. The proof consists of the roots of the subtrees to the
+// left of the range, followed by those to the right, each in order.
+func BuildSectorRangeProof(sector *[SectorSize]byte, offset, length uint64) ([]types.Hash256, error) {
+	start, end, err := validateProofRange(offset, length)
+	if err != nil {
+		return nil, err
+	}
+	var proof []types.Hash256
+	subtreeRoot := func(i, j uint64) types.Hash256 {
+		var sa sectorAccumulator
+		sa.appendLeaves(sector[i*leafSize : j*leafSize])
+		return sa.root()
+	}
+	for i := uint64(0); i < start; {
+		j := i + nextSubtreeSize(i, start)
+		proof = append(proof, subtreeRoot(i, j))
+		i = j
+	}
+	for i := end; i < leavesPerSector; {
+		j := i + nextSubtreeSize(i, leavesPerSector)
+		proof = append(proof, subtreeRoot(i, j))
+		i = j
+	}
+	return proof, nil
+}
+
+// rangeProofRoot computes the Merkle root of a tree with numLeaves leaves,
+// given a proof of the leaves in [start, end). insertLeaves must insert the
+// hashes of those leaves into the accumulator, in order. The proof consists of
+// the roots of the subtrees to the left of the range, followed by those to the
+// right, each in order.
+func rangeProofRoot(numLeaves, start, end uint64, insertLeaves func(*proofAccumulator), proof []types.Hash256) (types.Hash256, bool) {
+	var pa proofAccumulator
+	insertSubtrees := func(i, j uint64) bool {
+		for i < j {
+			if len(proof) == 0 {
+				return false
+			}
+			size := nextSubtreeSize(i, j)
+			pa.insertNode(proof[0], bits.TrailingZeros64(size))
+			proof = proof[1:]
+			i += size
+		}
+		return true
+	}
+	if !insertSubtrees(0, start) {
+		return types.Hash256{}, false
+	}
+	insertLeaves(&pa)
+	if !insertSubtrees(end, numLeaves) || len(proof) != 0 {
+		return types.Hash256{}, false
+	}
+	return pa.root(), true
+}
+
+// VerifySectorRangeProof verifies a proof, produced by BuildSectorRangeProof,
+// that data is located at the specified offset within the sector with the
+// given root.
+func VerifySectorRangeProof(root types.Hash256, data []byte, offset uint64, proof []types.Hash256) bool {
+	start, end, err := validateProofRange(offset, uint64(len(data)))
+	if err != nil {
+		return false
+	}
+	proofRoot, ok := rangeProofRoot(leavesPerSector, start, end, func(pa *proofAccumulator) {
+		for i := 0; i < len(data); i += leafSize {
+			pa.insertNode(merkle.LeafHash((*[leafSize]byte)(unsafe.Pointer(&data[i]))), 0)
+		}
+	}, proof)
+	return ok && proofRoot == root
+}
+
+// BuildSectorRootsProof constructs the proof sent in an RPCSectorRootsResponse:
+// a proof that roots[offset:offset+numRoots] are part of the contract whose
+// sector roots are roots.
+func BuildSectorRootsProof(roots []types.Hash256, offset, numRoots uint64) ([]types.Hash256, error) {
+	if numRoots == 0 {
+		return nil, errors.New("proof range is empty")
+	} else if offset > uint64(len(roots)) || numRoots > uint64(len(roots))-offset {
+		return nil, errors.New("proof range exceeds number of sectors")
+	}
+	var proof []types.Hash256
+	appendSubtrees := func(i, j uint64) {
+		for i < j {
+			end := i + nextSubtreeSize(i, j)
+			proof = append(proof, MetaRoot(roots[i:end]))
+			i = end
+		}
+	}
+	appendSubtrees(0, offset)
+	appendSubtrees(offset+numRoots, uint64(len(roots)))
+	return proof, nil
+}
+
+// VerifySectorRootsProof verifies a proof, produced by BuildSectorRootsProof,
+// that roots are the sector roots at the specified offset within a contract
+// with the given Merkle root and number of sectors. Renters should use it to
+// check the SectorRoots and MerkleProof of an RPCSectorRootsResponse against
+// the RootOffset of their request.
+func VerifySectorRootsProof(root types.Hash256, numSectors, offset uint64, roots, proof []types.Hash256) bool {
+	if len(roots) == 0 || offset > numSectors || uint64(len(roots)) > numSectors-offset {
+		return false
+	}
+	proofRoot, ok := rangeProofRoot(numSectors, offset, offset+uint64(len(roots)), func(pa *proofAccumulator) {
+		for _, h := range roots {
+			pa.insertNode(h, 0)
+		}
+	}, proof)
+	return ok && proofRoot == root
+}
+
+// writeActionIndices returns the sorted indices of the sector roots modified
+// by actions, along with the resulting number of sectors. Every trimmed or
+// appended index is included, so the unmodified roots all lie below the
+// smallest number of sectors reached; hence, they form the same subtrees in
+// both the old and new Merkle trees.
+func writeActionIndices(actions []RPCWriteAction, numSectors uint64) ([]uint64, uint64, error) {
+	changed := make(map[uint64]struct{})
+	n := numSectors
+	for i, action := range actions {
+		switch action.Type {
+		case RPCWriteActionAppend:
+			changed[n] = struct{}{}
+			n++
+		case RPCWriteActionTrim:
+			if action.A > n {
+				return nil, 0, fmt.Errorf("action %v: cannot trim %v sectors from %v", i, action.A, n)
+			}
+			for j := n - action.A; j < n; j++ {
+				changed[j] = struct{}{}
+			}
+			n -= action.A
+		case RPCWriteActionSwap:
+			if action.A >= n || action.B >= n {
+				return nil, 0, fmt.Errorf("action %v: swap indices (%v, %v) out of bounds", i, action.A, action.B)
+			}
+			changed[action.A] = struct{}{}
+			changed[action.B] = struct{}{}
+		case RPCWriteActionUpdate:
+			if action.A >= n {
+				return nil, 0, fmt.Errorf("action %v: update index %v out of bounds", i, action.A)
+			}
+			changed[action.A] = struct{}{}
+		default:
+			return nil, 0, fmt.Errorf("action %v: unknown type %q", i, action.Type)
+		}
+	}
+	indices := make([]uint64, 0, len(changed))
+	for i := range changed {
+		indices = append(indices, i)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices, n, nil
+}
+
+// diffProofRoot computes the root of a tree of numLeaves leaves from the
+// leaves at the specified (sorted) indices and the roots of the subtrees
+// between them. Indices beyond numLeaves are ignored. It returns false if the
+// number of subtree roots is incorrect.
+func diffProofRoot(numLeaves uint64, indices []uint64, leaves map[uint64]types.Hash256, subtrees []types.Hash256) (types.Hash256, bool) {
+	var pa proofAccumulator
+	insertGap := func(i, j uint64) bool {
+		for i < j {
+			if len(subtrees) == 0 {
+				return false
+			}
+			size := nextSubtreeSize(i, j)
+			pa.insertNode(subtrees[0], bits.TrailingZeros64(size))
+			subtrees = subtrees[1:]
+			i += size
+		}
+		return true
+	}
+	var i uint64
+	for _, j := range indices {
+		if j >= numLeaves {
+			break
+		} else if !insertGap(i, j) {
+			return types.Hash256{}, false
+		}
+		pa.insertNode(leaves[j], 0)
+		i = j + 1
+	}
+	if !insertGap(i, numLeaves) || len(subtrees) != 0 {
+		return types.Hash256{}, false
+	}
+	return pa.root(), true
+}
+
+// BuildWriteProof constructs the proof sent by the host in response to a Write
+// RPC, given the contract's sector roots before and after applying actions.
+// Trimming many sectors produces a correspondingly large proof, since the
+// trimmed roots are included as leaf hashes.
+func BuildWriteProof(oldRoots, newRoots []types.Hash256, actions []RPCWriteAction) (RPCWriteMerkleProof, error) {
+	indices, newNumSectors, err := writeActionIndices(actions, uint64(len(oldRoots)))
+	if err != nil {
+		return RPCWriteMerkleProof{}, err
+	} else if newNumSectors != uint64(len(newRoots)) {
+		return RPCWriteMerkleProof{}, fmt.Errorf("actions result in %v sectors, but %v new roots were supplied", newNumSectors, len(newRoots))
+	}
+	var proof RPCWriteMerkleProof
+	var i uint64
+	for _, j := range indices {
+		if j >= uint64(len(oldRoots)) {
+			break
+		}
+		for i < j {
+			end := i + nextSubtreeSize(i, j)
+			proof.OldSubtreeHashes = append(proof.OldSubtreeHashes, MetaRoot(oldRoots[i:end]))
+			i = end
+		}
+		proof.OldLeafHashes = append(proof.OldLeafHashes, oldRoots[j])
+		i = j + 1
+	}
+	for i < uint64(len(oldRoots)) {
+		end := i + nextSubtreeSize(i, uint64(len(oldRoots)))
+		proof.OldSubtreeHashes = append(proof.OldSubtreeHashes, MetaRoot(oldRoots[i:end]))
+		i = end
+	}
+	proof.NewMerkleRoot = MetaRoot(newRoots)
+	return proof, nil
+}
+
+// VerifyWriteProof verifies the proof sent by the host in response to a Write
+// RPC, checking that it is consistent with oldRoot and that applying actions to
+// the contract produces proof.NewMerkleRoot. The new root of an updated sector
+// depends on its prior contents, which the host does not send; updatedRoots
+// must therefore contain the new root of each Update action, in order.
+func VerifyWriteProof(oldRoot types.Hash256, oldNumSectors uint64, actions []RPCWriteAction, updatedRoots []types.Hash256, proof RPCWriteMerkleProof) error {
+	indices, newNumSectors, err := writeActionIndices(actions, oldNumSectors)
+	if err != nil {
+		return err
+	}
+
+	// verify the old root
+	leaves := make(map[uint64]types.Hash256)
+	for _, i := range indices {
+		if i >= oldNumSectors {
+			break
+		} else if len(proof.OldLeafHashes) == 0 {
+			return errors.New("proof has too few leaf hashes")
+		}
+		leaves[i] = proof.OldLeafHashes[0]
+		proof.OldLeafHashes = proof.OldLeafHashes[1:]
+	}
+	if len(proof.OldLeafHashes) != 0 {
+		return errors.New("proof has too many leaf hashes")
+	} else if root, ok := diffProofRoot(oldNumSectors, indices, leaves, proof.OldSubtreeHashes); !ok {
+		return errors.New("proof has wrong number of subtree hashes")
+	} else if root != oldRoot {
+		return errors.New("proof does not match old root")
+	}
+
+	// apply the actions to the leaves and verify the new root
+	n := oldNumSectors
+	for i, action := range actions {
+		switch action.Type {
+		case RPCWriteActionAppend:
+			if len(action.Data) != SectorSize {
+				return fmt.Errorf("action %v: appended data must be exactly one sector", i)
+			}
+			leaves[n] = SectorRoot((*[SectorSize]byte)(unsafe.Pointer(&action.Data[0])))
+			n++
+		case RPCWriteActionTrim:
+			n -= action.A
+		case RPCWriteActionSwap:
+			leaves[action.A], leaves[action.B] = leaves[action.B], leaves[action.A]
+		case RPCWriteActionUpdate:
+			if len(updatedRoots) == 0 {
+				return fmt.Errorf("action %v: missing updated root", i)
+			}
+			leaves[action.A] = updatedRoots[0]
+			updatedRoots = updatedRoots[1:]
+		}
+	}
+	if len(updatedRoots) != 0 {
+		return errors.New("more updated roots than Update actions")
+	} else if root, _ := diffProofRoot(newNumSectors, indices, leaves, proof.OldSubtreeHashes); root != proof.NewMerkleRoot {
+		return errors.New("actions do not produce new root")
+	}
+	return nil
+}
+
+// A RootStack computes the Merkle root of a stream of sector roots, such as
+// those of a contract, using memory logarithmic in the number of roots.
+type RootStack struct {
+	pa proofAccumulator
+}
+
+// AppendRoot appends a sector root to the stack.
+func (rs *RootStack) AppendRoot(h types.Hash256) {
+	rs.pa.insertNode(h, 0)
+}
+
+// NumRoots returns the number of roots appended to the stack.
+func (rs *RootStack) NumRoots() uint64 {
+	return rs.pa.numLeaves
+}
+
+// Root returns the Merkle root of the roots appended so far. It is equivalent
+// to calling MetaRoot on those roots.
+func (rs *RootStack) Root() types.Hash256 {
+	return rs.pa.root()
+}
+
+// Reset empties the stack.
+func (rs *RootStack) Reset() {
+	rs.pa = proofAccumulator{}
+}
+
+// ReaderRoot reads a stream of concatenated sector roots from r until EOF,
+// returning their Merkle root and number. A trailing partial root is an
+// error.
+func ReaderRoot(r io.Reader) (types.Hash256, uint64, error) {
+	br := bufio.NewReader(r)
+	var rs RootStack
+	var h types.Hash256
+	for {
+		if _, err := io.ReadFull(br, h[:]); err == io.EOF {
+			return rs.Root(), rs.NumRoots(), nil
+		} else if err != nil {
+			return types.Hash256{}, 0, fmt.Errorf("failed to read root %v: %w", rs.NumRoots(), err)
+		}
+		rs.AppendRoot(h)
+	}
 }
 
 // ReadSector reads a single sector from the reader and calculates its root.
@@ -2,8 +2,12 @@ package rhp
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"math/bits"
+	"runtime"
 	"testing"
+	"unsafe"
 
 	"go.sia.tech/core/types"
 	"golang.org/x/crypto/blake2b"
@@ -71,6 +75,59 @@ func TestSectorRoot(t *testing.T) {
 	}
 }
 
+func TestSectorRootParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	var sector [SectorSize]byte
+	for _, procs := range []int{1, 2, 3, 8, 64} {
+		runtime.GOMAXPROCS(procs)
+		frand.Read(sector[:])
+		if SectorRootParallel(&sector) != SectorRoot(&sector) {
+			t.Errorf("SectorRootParallel does not match SectorRoot with GOMAXPROCS=%v", procs)
+		}
+	}
+}
+
+func TestSectorHasher(t *testing.T) {
+	var sector [SectorSize]byte
+	frand.Read(sector[:])
+	root := SectorRoot(&sector)
+
+	sh := NewSectorHasher()
+	for _, chunkSize := range []int{1, 63, 64, 100, 256, 4096, 1 << 20, SectorSize} {
+		sh.Reset()
+		for i := 0; i < SectorSize; i += chunkSize {
+			end := i + chunkSize
+			if end > SectorSize {
+				end = SectorSize
+			}
+			if n, err := sh.Write(sector[i:end]); err != nil {
+				t.Fatal(err)
+			} else if n != end-i {
+				t.Fatalf("expected to write %v bytes, wrote %v", end-i, n)
+			}
+			if chunkSize >= 4096 {
+				_ = sh.Sum() // should not affect the final root
+			}
+		}
+		if sh.Sum() != root {
+			t.Errorf("SectorHasher root does not match SectorRoot with chunk size %v", chunkSize)
+		}
+	}
+
+	if _, err := sh.Write([]byte{0}); !errors.Is(err, ErrSectorOverflow) {
+		t.Fatal("expected ErrSectorOverflow, got", err)
+	}
+
+	// partial data is zero-padded to a whole number of leaves
+	sh.Reset()
+	sh.Write(sector[:100])
+	var padded [128]byte
+	copy(padded[:], sector[:100])
+	if sh.Sum() != MetaRoot([]types.Hash256{leafHash(padded[:64]), leafHash(padded[64:])}) {
+		t.Error("wrong root for partial sector")
+	}
+}
+
 func BenchmarkSectorRoot(b *testing.B) {
 	b.ReportAllocs()
 	var sector [SectorSize]byte
@@ -80,6 +137,15 @@ func BenchmarkSectorRoot(b *testing.B) {
 	}
 }
 
+func BenchmarkSectorRootParallel(b *testing.B) {
+	b.ReportAllocs()
+	var sector [SectorSize]byte
+	b.SetBytes(SectorSize)
+	for i := 0; i < b.N; i++ {
+		_ = SectorRootParallel(&sector)
+	}
+}
+
 func TestMetaRoot(t *testing.T) {
 	// test some known roots
 	if MetaRoot(nil) != (types.Hash256{}) {
@@ -194,6 +260,234 @@ func TestProofAccumulator(t *testing.T) {
 	}
 }
 
+func TestSectorRangeProof(t *testing.T) {
+	var sector [SectorSize]byte
+	frand.Read(sector[:])
+	root := SectorRoot(&sector)
+
+	ranges := [][2]uint64{
+		{0, leafSize},
+		{0, SectorSize},
+		{SectorSize - leafSize, leafSize},
+		{leafSize * 3, leafSize * 5},
+		{1 << 20, 1 << 12},
+	}
+	for i := 0; i < 10; i++ {
+		start := frand.Uint64n(leavesPerSector)
+		n := frand.Uint64n(leavesPerSector-start) + 1
+		ranges = append(ranges, [2]uint64{start * leafSize, n * leafSize})
+	}
+	for _, r := range ranges {
+		offset, length := r[0], r[1]
+		proof, err := BuildSectorRangeProof(&sector, offset, length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := sector[offset:][:length]
+		if !VerifySectorRangeProof(root, data, offset, proof) {
+			t.Fatalf("valid proof for range [%v, %v) rejected", offset, offset+length)
+		}
+
+		// any modification should invalidate the proof
+		bad := append([]byte(nil), data...)
+		bad[frand.Intn(len(bad))] ^= 1
+		if VerifySectorRangeProof(root, bad, offset, proof) {
+			t.Fatal("proof accepted for modified data")
+		}
+		if len(proof) > 0 {
+			if VerifySectorRangeProof(root, data, offset, proof[1:]) {
+				t.Fatal("truncated proof accepted")
+			}
+			badProof := append([]types.Hash256(nil), proof...)
+			badProof[len(badProof)-1][0] ^= 1
+			if VerifySectorRangeProof(root, data, offset, badProof) {
+				t.Fatal("modified proof accepted")
+			}
+		}
+		if VerifySectorRangeProof(root, data, offset, append(proof, types.Hash256{})) {
+			t.Fatal("proof with extra hash accepted")
+		}
+	}
+
+	// invalid ranges
+	for _, r := range [][2]uint64{
+		{0, 0},
+		{1, leafSize},
+		{0, leafSize + 1},
+		{SectorSize, leafSize},
+		{SectorSize - leafSize, 2 * leafSize},
+	} {
+		if _, err := BuildSectorRangeProof(&sector, r[0], r[1]); err == nil {
+			t.Errorf("expected error for range [%v, %v)", r[0], r[0]+r[1])
+		}
+	}
+}
+
+func TestSectorRootsProof(t *testing.T) {
+	roots := make([]types.Hash256, 37)
+	for i := range roots {
+		roots[i] = frand.Entropy256()
+	}
+	root := MetaRoot(roots)
+	numSectors := uint64(len(roots))
+
+	for offset := uint64(0); offset < numSectors; offset++ {
+		for n := uint64(1); offset+n <= numSectors; n++ {
+			proof, err := BuildSectorRootsProof(roots, offset, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sub := roots[offset:][:n]
+			if !VerifySectorRootsProof(root, numSectors, offset, sub, proof) {
+				t.Fatalf("valid proof for range [%v, %v) rejected", offset, offset+n)
+			}
+			// proofs against the wrong root, offset, or roots must fail
+			if VerifySectorRootsProof(types.Hash256{}, numSectors, offset, sub, proof) {
+				t.Fatal("proof accepted for wrong root")
+			} else if offset > 0 && VerifySectorRootsProof(root, numSectors, offset-1, sub, proof) {
+				t.Fatal("proof accepted for wrong offset")
+			}
+			bad := append([]types.Hash256(nil), sub...)
+			bad[frand.Intn(len(bad))][0] ^= 1
+			if VerifySectorRootsProof(root, numSectors, offset, bad, proof) {
+				t.Fatal("proof accepted for modified roots")
+			} else if VerifySectorRootsProof(root, numSectors, offset, sub, append(proof, types.Hash256{})) {
+				t.Fatal("proof with extra hash accepted")
+			}
+		}
+	}
+
+	if _, err := BuildSectorRootsProof(roots, 0, 0); err == nil {
+		t.Error("expected error for empty range")
+	} else if _, err := BuildSectorRootsProof(roots, 30, 8); err == nil {
+		t.Error("expected error for out-of-bounds range")
+	} else if VerifySectorRootsProof(root, numSectors, 0, nil, nil) {
+		t.Error("empty proof accepted")
+	}
+}
+
+func TestWriteProof(t *testing.T) {
+	oldRoots := make([]types.Hash256, 37)
+	for i := range oldRoots {
+		oldRoots[i] = frand.Entropy256()
+	}
+	oldRoot := MetaRoot(oldRoots)
+	sector := make([]byte, SectorSize)
+	frand.Read(sector[:64])
+
+	// applyActions applies actions to a copy of roots, as the host would
+	applyActions := func(roots []types.Hash256, actions []RPCWriteAction, updatedRoots []types.Hash256) []types.Hash256 {
+		roots = append([]types.Hash256(nil), roots...)
+		for _, action := range actions {
+			switch action.Type {
+			case RPCWriteActionAppend:
+				roots = append(roots, SectorRoot((*[SectorSize]byte)(unsafe.Pointer(&sector[0]))))
+			case RPCWriteActionTrim:
+				roots = roots[:uint64(len(roots))-action.A]
+			case RPCWriteActionSwap:
+				roots[action.A], roots[action.B] = roots[action.B], roots[action.A]
+			case RPCWriteActionUpdate:
+				roots[action.A] = updatedRoots[0]
+				updatedRoots = updatedRoots[1:]
+			}
+		}
+		return roots
+	}
+
+	tests := []struct {
+		actions      []RPCWriteAction
+		updatedRoots []types.Hash256
+	}{
+		{[]RPCWriteAction{{Type: RPCWriteActionAppend, Data: sector}}, nil},
+		{[]RPCWriteAction{{Type: RPCWriteActionSwap, A: 3, B: 30}, {Type: RPCWriteActionUpdate, A: 17}}, []types.Hash256{{1}}},
+		{[]RPCWriteAction{{Type: RPCWriteActionTrim, A: 5}, {Type: RPCWriteActionAppend, Data: sector}}, nil},
+		{[]RPCWriteAction{{Type: RPCWriteActionSwap, A: 0, B: 36}, {Type: RPCWriteActionTrim, A: 1}}, nil},
+		{[]RPCWriteAction{{Type: RPCWriteActionTrim, A: 37}}, nil},
+		{[]RPCWriteAction{{Type: RPCWriteActionUpdate, A: 0}, {Type: RPCWriteActionUpdate, A: 0}}, []types.Hash256{{1}, {2}}},
+	}
+	for i, test := range tests {
+		newRoots := applyActions(oldRoots, test.actions, test.updatedRoots)
+		proof, err := BuildWriteProof(oldRoots, newRoots, test.actions)
+		if err != nil {
+			t.Fatal(err)
+		} else if proof.NewMerkleRoot != MetaRoot(newRoots) {
+			t.Fatalf("test %v: wrong new root", i)
+		} else if len(proof.OldSubtreeHashes)+len(proof.OldLeafHashes) > len(oldRoots) {
+			t.Errorf("test %v: proof (%v hashes) is larger than the sector roots", i, len(proof.OldSubtreeHashes)+len(proof.OldLeafHashes))
+		}
+		if err := VerifyWriteProof(oldRoot, uint64(len(oldRoots)), test.actions, test.updatedRoots, proof); err != nil {
+			t.Fatalf("test %v: valid proof rejected: %v", i, err)
+		}
+
+		// a dishonest host should be caught
+		if err := VerifyWriteProof(types.Hash256{1}, uint64(len(oldRoots)), test.actions, test.updatedRoots, proof); err == nil {
+			t.Fatalf("test %v: proof accepted for wrong old root", i)
+		}
+		bad := proof
+		bad.NewMerkleRoot[0] ^= 1
+		if err := VerifyWriteProof(oldRoot, uint64(len(oldRoots)), test.actions, test.updatedRoots, bad); err == nil {
+			t.Fatalf("test %v: proof accepted for wrong new root", i)
+		}
+		if len(proof.OldLeafHashes) > 0 {
+			bad = proof
+			bad.OldLeafHashes = append([]types.Hash256(nil), proof.OldLeafHashes...)
+			bad.OldLeafHashes[0][0] ^= 1
+			if err := VerifyWriteProof(oldRoot, uint64(len(oldRoots)), test.actions, test.updatedRoots, bad); err == nil {
+				t.Fatalf("test %v: proof accepted with modified leaf hash", i)
+			}
+		}
+		if len(proof.OldSubtreeHashes) > 0 {
+			bad = proof
+			bad.OldSubtreeHashes = proof.OldSubtreeHashes[1:]
+			if err := VerifyWriteProof(oldRoot, uint64(len(oldRoots)), test.actions, test.updatedRoots, bad); err == nil {
+				t.Fatalf("test %v: proof accepted with missing subtree hash", i)
+			}
+		}
+	}
+
+	// invalid actions
+	for _, actions := range [][]RPCWriteAction{
+		{{Type: RPCWriteActionTrim, A: 38}},
+		{{Type: RPCWriteActionSwap, A: 0, B: 37}},
+		{{Type: RPCWriteActionUpdate, A: 37}},
+		{{Type: RPCWriteActionTrim, A: 1}, {Type: RPCWriteActionUpdate, A: 36}},
+	} {
+		if _, err := BuildWriteProof(oldRoots, oldRoots, actions); err == nil {
+			t.Errorf("expected error for actions %v", actions)
+		}
+	}
+}
+
+func TestRootStack(t *testing.T) {
+	roots := make([]types.Hash256, 300)
+	var rs RootStack
+	if rs.Root() != MetaRoot(nil) {
+		t.Fatal("wrong root for empty stack")
+	}
+	for i := range roots {
+		roots[i] = frand.Entropy256()
+		rs.AppendRoot(roots[i])
+		if rs.NumRoots() != uint64(i+1) {
+			t.Fatalf("expected %v roots, got %v", i+1, rs.NumRoots())
+		} else if rs.Root() != MetaRoot(roots[:i+1]) {
+			t.Fatalf("root mismatch after %v roots", i+1)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, root := range roots {
+		buf.Write(root[:])
+	}
+	if root, n, err := ReaderRoot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if n != uint64(len(roots)) || root != MetaRoot(roots) {
+		t.Fatal("ReaderRoot mismatch")
+	}
+	if _, _, err := ReaderRoot(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+}
+
 func TestReadSector(t *testing.T) {
 	var expected [SectorSize]byte
 	frand.Read(expected[:256])
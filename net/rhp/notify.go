@@ -0,0 +1,94 @@
+package rhp
+
+import (
+	"errors"
+	"time"
+
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// RPCSettingsNotificationID is the ID of the host-initiated settings
+// notification.
+var RPCSettingsNotificationID = rpc.NewSpecifier("NotifySettings")
+
+// Types of settings notifications.
+var (
+	// NotifySettingsChange indicates that the host's settings will change.
+	NotifySettingsChange = rpc.NewSpecifier("SettingsChange")
+	// NotifyMaintenance indicates that the host is entering maintenance mode,
+	// and will stop accepting RPCs.
+	NotifyMaintenance = rpc.NewSpecifier("Maintenance")
+)
+
+// A SettingsNotification warns a renter that subsequent RPCs may fail, so
+// that it can finish or pause its work (e.g. an upload) gracefully.
+type SettingsNotification struct {
+	Type rpc.Specifier
+	// EffectiveAt is the time at which the change takes effect.
+	EffectiveAt time.Time
+	// Settings are the host's new settings. They are only meaningful for
+	// NotifySettingsChange.
+	Settings HostSettings
+	// Reason is an optional human-readable explanation.
+	Reason string
+}
+
+// EncodeTo implements types.EncoderTo.
+func (n *SettingsNotification) EncodeTo(e *types.Encoder) {
+	n.Type.EncodeTo(e)
+	e.WriteTime(n.EffectiveAt)
+	n.Settings.EncodeTo(e)
+	e.WriteString(n.Reason)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (n *SettingsNotification) DecodeFrom(d *types.Decoder) {
+	n.Type.DecodeFrom(d)
+	n.EffectiveAt = d.ReadTime()
+	n.Settings.DecodeFrom(d)
+	n.Reason = d.ReadString()
+}
+
+// MaxLen implements rpc.Object.
+func (n *SettingsNotification) MaxLen() int {
+	return 16 + 8 + n.Settings.MaxLen() + 8 + 1024
+}
+
+// Notify sends a settings notification to the renter. It is intended for use
+// by hosts; the notification is sent on a new stream, concurrently with any
+// RPCs in progress, and is not acknowledged. Renters that do not call
+// HandleNotifications ignore it.
+func (s *Session) Notify(n SettingsNotification) error {
+	stream, err := s.DialStream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(30 * time.Second))
+	return rpc.WriteRequest(stream, RPCSettingsNotificationID, &n)
+}
+
+// HandleNotifications calls fn with each settings notification sent by the
+// host, until the session is closed. It is intended for use by renters, and
+// should be called in a separate goroutine. Streams opened by the host for any
+// other purpose are closed without being read.
+func (s *Session) HandleNotifications(fn func(SettingsNotification)) error {
+	for {
+		stream, err := s.AcceptStream()
+		if errors.Is(err, mux.ErrClosedConn) || errors.Is(err, mux.ErrPeerClosedConn) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		stream.SetDeadline(time.Now().Add(30 * time.Second))
+		var n SettingsNotification
+		if id, err := rpc.ReadID(stream); err == nil && id == RPCSettingsNotificationID {
+			if err := s.Limits.ReadRequest(stream, &n); err == nil {
+				fn(n)
+			}
+		}
+		stream.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package rhp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// Errors returned when verifying or looking up a price table.
+var (
+	ErrPriceTableInvalidSig = errors.New("price table has an invalid signature")
+	ErrPriceTableExpired    = errors.New("price table has expired")
+	// ErrPriceTableNotFound is sent to the renter when an RPC references a
+	// price table that the host did not issue, or that has expired.
+	ErrPriceTableNotFound = &rpc.Error{Type: rpc.NewSpecifier("NoPriceTable"), Description: "unknown or expired price table"}
+)
+
+// A PriceTable is a set of host settings, signed by the host and identified by
+// a unique ID. Renters obtain a PriceTable via the PriceTable RPC, and
+// reference its UID in subsequent RPCs; the host must charge those RPCs
+// according to the referenced table, which is valid until
+// Settings.ValidUntil. This prevents the host from changing its prices
+// mid-session, and since the table is signed, a renter that is overcharged
+// can prove it.
+type PriceTable struct {
+	UID       SettingsID
+	Settings  HostSettings
+	Signature types.Signature
+}
+
+// NewPriceTable returns a price table for the given settings, signed by host
+// and assigned a random UID.
+func NewPriceTable(host types.PrivateKey, settings HostSettings) PriceTable {
+	pt := PriceTable{Settings: settings}
+	types.ReadEntropy(pt.UID[:])
+	pt.Signature = host.SignHash(pt.SigHash())
+	return pt
+}
+
+// SigHash returns the hash of the price table that is signed by the host.
+func (pt *PriceTable) SigHash() types.Hash256 {
+	h := types.NewHasher()
+	h.E.WriteString(types.DomainRHPPriceTable)
+	pt.UID.EncodeTo(h.E)
+	pt.Settings.EncodeTo(h.E)
+	return h.Sum()
+}
+
+// Verify returns an error if pt was not signed by host, has expired as of the
+// given time, or contains invalid settings.
+func (pt *PriceTable) Verify(host types.PublicKey, now time.Time) error {
+	switch {
+	case !now.Before(pt.Settings.ValidUntil):
+		return fmt.Errorf("%w (valid until %v)", ErrPriceTableExpired, pt.Settings.ValidUntil)
+	case !host.VerifyHash(pt.SigHash(), pt.Signature):
+		return ErrPriceTableInvalidSig
+	}
+	return pt.Settings.Validate()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (pt *PriceTable) EncodeTo(e *types.Encoder) {
+	pt.UID.EncodeTo(e)
+	pt.Settings.EncodeTo(e)
+	pt.Signature.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (pt *PriceTable) DecodeFrom(d *types.Decoder) {
+	pt.UID.DecodeFrom(d)
+	pt.Settings.DecodeFrom(d)
+	pt.Signature.DecodeFrom(d)
+}
+
+// MaxLen implements rpc.Object.
+func (pt *PriceTable) MaxLen() int {
+	return 16 + pt.Settings.MaxLen() + 64
+}
+
+// A PriceTableStore tracks the unexpired price tables issued by a host.
+type PriceTableStore struct {
+	mu     sync.Mutex
+	tables map[SettingsID]PriceTable
+}
+
+// Add adds pt to the store, removing any expired tables.
+func (pts *PriceTableStore) Add(pt PriceTable) {
+	pts.mu.Lock()
+	defer pts.mu.Unlock()
+	if pts.tables == nil {
+		pts.tables = make(map[SettingsID]PriceTable)
+	}
+	now := time.Now()
+	for uid, t := range pts.tables {
+		if !now.Before(t.Settings.ValidUntil) {
+			delete(pts.tables, uid)
+		}
+	}
+	pts.tables[pt.UID] = pt
+}
+
+// Get returns the price table with the given UID, if it is valid at the given
+// time.
+func (pts *PriceTableStore) Get(uid SettingsID, now time.Time) (PriceTable, error) {
+	pts.mu.Lock()
+	defer pts.mu.Unlock()
+	pt, ok := pts.tables[uid]
+	if !ok || !now.Before(pt.Settings.ValidUntil) {
+		return PriceTable{}, ErrPriceTableNotFound
+	}
+	return pt, nil
+}
+
+// ReadPriceTable reads the UID of a price table from r, as written by a renter
+// at the beginning of an RPC request, and returns the corresponding table.
+func (pts *PriceTableStore) ReadPriceTable(r io.Reader, now time.Time) (PriceTable, error) {
+	var uid SettingsID
+	if err := rpc.ReadObject(r, &uid); err != nil {
+		return PriceTable{}, fmt.Errorf("couldn't read price table UID: %w", err)
+	}
+	return pts.Get(uid, now)
+}
+
+// PriceTable requests a signed price table from the host via the PriceTable
+// RPC.
+func (s *Session) PriceTable(hostKey types.PublicKey) (PriceTable, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.PriceTableContext(ctx, hostKey)
+}
+
+// PriceTableContext is like PriceTable, but aborts the RPC if ctx is canceled
+// or its deadline passes. The table is verified against hostKey before it is
+// returned. To reference the table in a subsequent RPC, write its UID (via
+// rpc.WriteObject) immediately after the RPC ID.
+func (s *Session) PriceTableContext(ctx context.Context, hostKey types.PublicKey) (PriceTable, error) {
+	stream, err := s.DialStream()
+	if err != nil {
+		return PriceTable{}, err
+	}
+	defer stream.Close()
+	var pt PriceTable
+	c := rpc.Client{
+		Limits:              s.Limits,
+		CompressedResponses: s.Protocol.HasFeature(FeatureCompression),
+	}
+	if err := c.CallContext(ctx, stream, RPCPriceTableID, nil, &pt); err != nil {
+		return PriceTable{}, err
+	} else if err := pt.Verify(hostKey, time.Now()); err != nil {
+		return PriceTable{}, fmt.Errorf("host sent invalid price table: %w", err)
+	}
+	return pt, nil
+}
@@ -0,0 +1,132 @@
+package rhp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+func TestPriceTable(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	now := time.Now()
+	settings := HostSettings{Version: "1.0.0", SectorSize: SectorSize, ValidUntil: now.Add(time.Minute).Truncate(time.Second).UTC()}
+	pt := NewPriceTable(hostKey, settings)
+	if err := pt.Verify(hostKey.PublicKey(), now); err != nil {
+		t.Fatal(err)
+	} else if err := pt.Verify(types.GeneratePrivateKey().PublicKey(), now); !errors.Is(err, ErrPriceTableInvalidSig) {
+		t.Fatal("expected invalid signature, got", err)
+	} else if err := pt.Verify(hostKey.PublicKey(), now.Add(time.Hour)); !errors.Is(err, ErrPriceTableExpired) {
+		t.Fatal("expected expired table, got", err)
+	}
+	// the signature must cover the prices
+	tampered := pt
+	tampered.Settings.StoragePrice = types.Siacoins(1)
+	if err := tampered.Verify(hostKey.PublicKey(), now); !errors.Is(err, ErrPriceTableInvalidSig) {
+		t.Fatal("expected invalid signature, got", err)
+	}
+
+	var store PriceTableStore
+	expired := NewPriceTable(hostKey, HostSettings{ValidUntil: now.Add(-time.Second)})
+	store.Add(expired)
+	store.Add(pt)
+	if _, err := store.Get(expired.UID, now); !errors.Is(err, ErrPriceTableNotFound) {
+		t.Fatal("expected expired table to be missing, got", err)
+	} else if got, err := store.Get(pt.UID, now); err != nil || got.UID != pt.UID {
+		t.Fatal("expected table to be found", err)
+	} else if _, err := store.Get(pt.UID, now.Add(time.Hour)); !errors.Is(err, ErrPriceTableNotFound) {
+		t.Fatal("expected table to expire, got", err)
+	}
+}
+
+func TestSessionPriceTable(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	settings := HostSettings{Version: "1.0.0", SectorSize: SectorSize, ValidUntil: time.Now().Add(time.Minute).Truncate(time.Second).UTC()}
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var store PriceTableStore
+	hostErr := make(chan error, 1)
+	go func() {
+		hostErr <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sess, err := AcceptSession(conn, hostKey)
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+			for i := 0; i < 3; i++ {
+				stream, err := sess.AcceptStream()
+				if err != nil {
+					return err
+				}
+				id, err := rpc.ReadID(stream)
+				if err != nil {
+					return err
+				}
+				switch id {
+				case RPCPriceTableID:
+					pt := NewPriceTable(hostKey, settings)
+					store.Add(pt)
+					err = sess.WriteResponse(stream, &pt)
+				case RPCAccountBalanceID:
+					if _, err = store.ReadPriceTable(stream, time.Now()); err != nil {
+						err = sess.WriteResponseErr(stream, err)
+						break
+					}
+					err = sess.WriteResponse(stream, &RPCAccountBalanceResponse{Balance: types.Siacoins(1)})
+				}
+				stream.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := DialSession(conn, hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	pt, err := sess.PriceTable(hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance := func(uid SettingsID) error {
+		stream, err := sess.DialStream()
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		if err := rpc.WriteRequest(stream, RPCAccountBalanceID, &uid); err != nil {
+			return err
+		}
+		var resp RPCAccountBalanceResponse
+		return sess.ReadResponse(stream, &resp)
+	}
+	if err := balance(pt.UID); err != nil {
+		t.Fatal(err)
+	} else if err := balance(SettingsID{1}); !errors.Is(err, ErrPriceTableNotFound) {
+		t.Fatal("expected unknown price table to be rejected, got", err)
+	} else if err := <-hostErr; err != nil {
+		t.Fatal(err)
+	}
+}
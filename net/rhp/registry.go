@@ -185,3 +185,138 @@ func ValidateRegistryUpdate(old, update RegistryValue, hostID types.Hash256) err
 
 	return nil
 }
+
+// ErrRegistryValueSuperseded is returned by
+// (*RPCRegistryUpdateResponse).Verify when the host rejected an update because
+// it already stores an entry that supersedes it.
+var ErrRegistryValueSuperseded = errors.New("registry update was superseded by an existing entry")
+
+// NewRegistryValue returns a registry value signed by priv.
+func NewRegistryValue(priv types.PrivateKey, tweak types.Hash256, data []byte, revision uint64, typ uint8) RegistryValue {
+	value := RegistryValue{
+		Tweak:     tweak,
+		Data:      data,
+		Revision:  revision,
+		Type:      typ,
+		PublicKey: priv.PublicKey(),
+	}
+	value.Signature = priv.SignHash(value.Hash())
+	return value
+}
+
+// RPCRegistryReadRequest requests the registry value stored under the key
+// derived from PublicKey and Tweak.
+type RPCRegistryReadRequest struct {
+	PublicKey types.PublicKey
+	Tweak     types.Hash256
+}
+
+// Key returns the registry key of the requested value.
+func (r *RPCRegistryReadRequest) Key() types.Hash256 {
+	return RegistryKey(r.PublicKey, r.Tweak)
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCRegistryReadRequest) MaxLen() int {
+	return 32 + 32
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCRegistryReadRequest) EncodeTo(e *types.Encoder) {
+	r.PublicKey.EncodeTo(e)
+	r.Tweak.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCRegistryReadRequest) DecodeFrom(d *types.Decoder) {
+	r.PublicKey.DecodeFrom(d)
+	r.Tweak.DecodeFrom(d)
+}
+
+// RPCRegistryReadResponse contains the requested registry value.
+type RPCRegistryReadResponse struct {
+	Value RegistryValue
+}
+
+// Verify returns an error if the response does not contain a valid value for
+// the requested key.
+func (r *RPCRegistryReadResponse) Verify(req RPCRegistryReadRequest) error {
+	if r.Value.PublicKey != req.PublicKey || r.Value.Tweak != req.Tweak {
+		return errors.New("registry value has wrong key")
+	}
+	return ValidateRegistryEntry(r.Value)
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCRegistryReadResponse) MaxLen() int {
+	return r.Value.MaxLen()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCRegistryReadResponse) EncodeTo(e *types.Encoder) {
+	r.Value.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCRegistryReadResponse) DecodeFrom(d *types.Decoder) {
+	r.Value.DecodeFrom(d)
+}
+
+// RPCRegistryUpdateRequest requests that the host store a new registry value.
+type RPCRegistryUpdateRequest struct {
+	Value RegistryValue
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCRegistryUpdateRequest) MaxLen() int {
+	return r.Value.MaxLen()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCRegistryUpdateRequest) EncodeTo(e *types.Encoder) {
+	r.Value.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCRegistryUpdateRequest) DecodeFrom(d *types.Decoder) {
+	r.Value.DecodeFrom(d)
+}
+
+// RPCRegistryUpdateResponse contains the value stored by the host after an
+// update: either the updated value, or the existing value that superseded it.
+type RPCRegistryUpdateResponse struct {
+	Value RegistryValue
+}
+
+// Verify returns nil if the host accepted the update, and
+// ErrRegistryValueSuperseded if the host holds a valid value that supersedes
+// it. Any other response is invalid.
+func (r *RPCRegistryUpdateResponse) Verify(update RegistryValue, hostID types.Hash256) error {
+	switch {
+	case r.Value.PublicKey != update.PublicKey || r.Value.Tweak != update.Tweak:
+		return errors.New("registry value has wrong key")
+	case r.Value.Hash() == update.Hash() && r.Value.Signature == update.Signature:
+		return nil
+	}
+	if err := ValidateRegistryEntry(r.Value); err != nil {
+		return err
+	} else if err := ValidateRegistryUpdate(update, r.Value, hostID); err != nil {
+		return fmt.Errorf("existing registry value does not supersede update: %w", err)
+	}
+	return ErrRegistryValueSuperseded
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCRegistryUpdateResponse) MaxLen() int {
+	return r.Value.MaxLen()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCRegistryUpdateResponse) EncodeTo(e *types.Encoder) {
+	r.Value.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCRegistryUpdateResponse) DecodeFrom(d *types.Decoder) {
+	r.Value.DecodeFrom(d)
+}
@@ -2,6 +2,7 @@ package rhp
 
 import (
 	"encoding/hex"
+	"errors"
 	"testing"
 
 	"go.sia.tech/core/types"
@@ -82,3 +83,43 @@ func TestRegistryKeyCompat(t *testing.T) {
 		}
 	}
 }
+
+func TestRegistryRPCVerify(t *testing.T) {
+	priv := types.GeneratePrivateKey()
+	hostID := RegistryHostID(types.GeneratePrivateKey().PublicKey())
+	tweak := types.HashBytes([]byte("tweak"))
+	value := NewRegistryValue(priv, tweak, []byte("foo"), 1, EntryTypeArbitrary)
+
+	req := RPCRegistryReadRequest{PublicKey: priv.PublicKey(), Tweak: tweak}
+	if req.Key() != value.Key() {
+		t.Fatal("request key does not match value key")
+	}
+	readResp := RPCRegistryReadResponse{Value: value}
+	if err := readResp.Verify(req); err != nil {
+		t.Fatal(err)
+	}
+	readResp.Value.Data = []byte("bar")
+	if err := readResp.Verify(req); err == nil {
+		t.Fatal("expected tampered value to be rejected")
+	}
+	readResp.Value = NewRegistryValue(priv, types.Hash256{}, []byte("foo"), 1, EntryTypeArbitrary)
+	if err := readResp.Verify(req); err == nil {
+		t.Fatal("expected value with wrong tweak to be rejected")
+	}
+
+	// accepted update
+	updateResp := RPCRegistryUpdateResponse{Value: value}
+	if err := updateResp.Verify(value, hostID); err != nil {
+		t.Fatal(err)
+	}
+	// superseded by a higher revision
+	updateResp.Value = NewRegistryValue(priv, tweak, []byte("foo"), 2, EntryTypeArbitrary)
+	if err := updateResp.Verify(value, hostID); !errors.Is(err, ErrRegistryValueSuperseded) {
+		t.Fatal("expected update to be superseded, got", err)
+	}
+	// a lower revision does not supersede the update
+	updated := NewRegistryValue(priv, tweak, []byte("foo"), 3, EntryTypeArbitrary)
+	if err := updateResp.Verify(updated, hostID); err == nil || errors.Is(err, ErrRegistryValueSuperseded) {
+		t.Fatal("expected stale value to be rejected, got", err)
+	}
+}
@@ -0,0 +1,539 @@
+package rhp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// Errors returned by the renter's side of the contract RPCs.
+var (
+	ErrNoContractLocked      = errors.New("no contract is locked")
+	ErrContractAlreadyLocked = errors.New("a contract is already locked")
+)
+
+// A Wallet funds and signs the renter's inputs to contract transactions. Any
+// host.Wallet satisfies it.
+type Wallet interface {
+	// FundTransaction adds inputs worth at least amount to txn, along with a
+	// change output if necessary. It returns the IDs of the added inputs and a
+	// function that releases them if the transaction is abandoned.
+	FundTransaction(txn *types.Transaction, amount types.Currency, pool []types.Transaction) ([]types.ElementID, func(), error)
+	// SignTransaction signs the inputs of txn with the given IDs.
+	SignTransaction(vc consensus.ValidationContext, txn *types.Transaction, toSign []types.ElementID) error
+}
+
+// A lockedContract is the renter's view of the contract locked by Lock.
+type lockedContract struct {
+	contract Contract
+	key      types.PrivateKey
+	vc       consensus.ValidationContext
+}
+
+func (lc *lockedContract) sign(fc types.FileContract) types.Signature {
+	return lc.key.SignHash(lc.vc.ContractSigHash(fc))
+}
+
+func (lc *lockedContract) verifyHost(fc types.FileContract, sig types.Signature) error {
+	if !fc.HostPublicKey.VerifyHash(lc.vc.ContractSigHash(fc), sig) {
+		return fmt.Errorf("host revision %w", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func contractOutputs(fc types.FileContract) ContractOutputs {
+	return ContractOutputs{
+		RenterValue:     fc.RenterOutput.Value,
+		HostValue:       fc.HostOutput.Value,
+		MissedHostValue: fc.MissedHostValue,
+	}
+}
+
+// RPCReadCost returns the cost of downloading the given sections via the Read
+// RPC.
+func RPCReadCost(settings HostSettings, sections []RPCReadRequestSection) (cost types.Currency) {
+	for _, sec := range sections {
+		cost = cost.Add(ReadCost(settings, sec.Length).BaseCost).Add(settings.DownloadBandwidthPrice.Mul64(sec.Length))
+	}
+	return
+}
+
+// RPCSectorRootsCost returns the cost of downloading numRoots sector roots via
+// the SectorRoots RPC.
+func RPCSectorRootsCost(settings HostSettings, numRoots uint64) types.Currency {
+	return SectorRootsCost(settings, numRoots).BaseCost.Add(settings.DownloadBandwidthPrice.Mul64(32 * numRoots))
+}
+
+// RPCWriteCost returns the cost of applying actions via the Write RPC to a
+// contract with the given remaining duration, along with the additional
+// collateral that the host must risk.
+func RPCWriteCost(settings HostSettings, duration uint64, actions []RPCWriteAction) (cost, collateral types.Currency) {
+	for _, action := range actions {
+		var usage ResourceUsage
+		switch action.Type {
+		case RPCWriteActionAppend:
+			usage = AppendSectorCost(settings, duration)
+		case RPCWriteActionTrim:
+			usage = DropSectorsCost(settings, action.A)
+		case RPCWriteActionSwap:
+			usage = SwapSectorCost(settings)
+		case RPCWriteActionUpdate:
+			usage = UpdateSectorCost(settings, uint64(len(action.Data)))
+		}
+		cost = cost.Add(usage.BaseCost).Add(usage.StorageCost).Add(settings.UploadBandwidthPrice.Mul64(uint64(len(action.Data))))
+		collateral = collateral.Add(usage.AdditionalCollateral)
+	}
+	return
+}
+
+// withStream calls fn with a new stream bound to ctx.
+func (s *Session) withStream(ctx context.Context, fn func(stream *mux.Stream) error) (err error) {
+	stream, err := s.DialStream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stop := rpc.WithContext(ctx, stream)
+	defer func() {
+		if ctxErr := stop(); err != nil && ctxErr != nil {
+			err = fmt.Errorf("%w (%v)", ctxErr, err)
+		}
+	}()
+	return fn(stream)
+}
+
+// Lock locks the contract with the given ID, allowing the host up to timeout to
+// acquire it, and returns its latest revision. The renter proves that it
+// controls the contract by signing the Session's challenge with key, which
+// must be the contract's renter key. Only one contract may be locked at a
+// time; Read, Write, SectorRoots, and RenewContract operate on the locked
+// contract, signing revisions with key.
+func (s *Session) Lock(ctx context.Context, vc consensus.ValidationContext, id types.ElementID, key types.PrivateKey, timeout time.Duration) (Contract, error) {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	if s.locked != nil {
+		return Contract{}, ErrContractAlreadyLocked
+	}
+
+	req := &RPCLockRequest{
+		ContractID: id,
+		Signature:  s.SignChallenge(key),
+		Timeout:    uint64(timeout.Milliseconds()),
+	}
+	var resp RPCLockResponse
+	err := s.withStream(ctx, func(stream *mux.Stream) error {
+		if err := rpc.WriteRequest(stream, RPCLockID, req); err != nil {
+			return err
+		}
+		return s.ReadResponse(stream, &resp)
+	})
+	if err != nil {
+		return Contract{}, err
+	}
+	s.SetChallenge(resp.NewChallenge)
+
+	c := Contract{ID: resp.Revision.Parent.ID, Revision: resp.Revision.Revision}
+	switch {
+	case !resp.Acquired:
+		return Contract{}, rpc.ErrLocked
+	case c.ID != id:
+		return Contract{}, fmt.Errorf("host locked the wrong contract (%v)", c.ID)
+	case c.Revision.RenterPublicKey != key.PublicKey():
+		return Contract{}, errors.New("contract has a different renter key")
+	}
+	if err := c.ValidateSignatures(vc); err != nil {
+		return Contract{}, fmt.Errorf("host sent invalid revision: %w", err)
+	}
+	s.locked = &lockedContract{contract: c, key: key, vc: vc}
+	return c, nil
+}
+
+// Unlock releases the locked contract.
+func (s *Session) Unlock(ctx context.Context) error {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	if s.locked == nil {
+		return ErrNoContractLocked
+	}
+	s.locked = nil
+	return s.withStream(ctx, func(stream *mux.Stream) error {
+		return rpc.WriteRequest(stream, RPCUnlockID, nil)
+	})
+}
+
+// LockedContract returns the latest revision of the locked contract, if any.
+func (s *Session) LockedContract() (Contract, bool) {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	if s.locked == nil {
+		return Contract{}, false
+	}
+	return s.locked.contract, true
+}
+
+// FormContract forms a new contract with the host. The renter signs fc with
+// key and funds its side of the contract (the renter output, contract fee,
+// tax, and minerFee) with w; the host funds its collateral. The returned
+// transaction is fully signed and ready to broadcast.
+func (s *Session) FormContract(ctx context.Context, vc consensus.ValidationContext, fc types.FileContract, key types.PrivateKey, settings HostSettings, minerFee types.Currency, w Wallet) (_ Contract, _ types.Transaction, err error) {
+	if fc.RenterPublicKey != key.PublicKey() {
+		return Contract{}, types.Transaction{}, errors.New("contract has a different renter key")
+	} else if err := ValidateContractFormation(fc, vc.Index.Height, settings); err != nil {
+		return Contract{}, types.Transaction{}, fmt.Errorf("invalid contract: %w", err)
+	}
+	fc.RenterSignature = key.SignHash(vc.ContractSigHash(fc))
+
+	txn := types.Transaction{
+		FileContracts: []types.FileContract{fc},
+		MinerFee:      minerFee,
+	}
+	cost := fc.RenterOutput.Value.Add(settings.ContractFee).Add(vc.FileContractTax(fc)).Add(minerFee)
+	toSign, discard, err := w.FundTransaction(&txn, cost, nil)
+	if err != nil {
+		return Contract{}, types.Transaction{}, fmt.Errorf("couldn't fund transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			discard()
+		}
+	}()
+	renterInputs := len(txn.SiacoinInputs)
+
+	err = s.withStream(ctx, func(stream *mux.Stream) error {
+		req := &RPCFormContractRequest{
+			Inputs:   txn.SiacoinInputs,
+			Outputs:  txn.SiacoinOutputs,
+			MinerFee: minerFee,
+			Contract: fc,
+		}
+		if err := rpc.WriteRequest(stream, RPCFormContractID, req); err != nil {
+			return err
+		}
+		var additions RPCFormContractHostAdditions
+		if err := s.ReadResponse(stream, &additions); err != nil {
+			return err
+		} else if !fc.HostPublicKey.VerifyHash(vc.ContractSigHash(fc), additions.ContractSignature) {
+			return fmt.Errorf("host contract %w", ErrInvalidSignature)
+		}
+		txn.FileContracts[0].HostSignature = additions.ContractSignature
+		txn.SiacoinInputs = append(txn.SiacoinInputs, additions.Inputs...)
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, additions.Outputs...)
+
+		if err := w.SignTransaction(vc, &txn, toSign); err != nil {
+			return fmt.Errorf("couldn't sign transaction: %w", err)
+		}
+		renterSigs := &RPCContractSignatures{SiacoinInputSignatures: make([][]types.Signature, renterInputs)}
+		for i := range renterSigs.SiacoinInputSignatures {
+			renterSigs.SiacoinInputSignatures[i] = txn.SiacoinInputs[i].Signatures
+		}
+		if err := s.WriteResponse(stream, renterSigs); err != nil {
+			return err
+		}
+		var hostSigs RPCContractSignatures
+		if err := s.ReadResponse(stream, &hostSigs); err != nil {
+			return err
+		} else if len(hostSigs.SiacoinInputSignatures) != len(additions.Inputs) {
+			return fmt.Errorf("host sent signatures for %v inputs, expected %v", len(hostSigs.SiacoinInputSignatures), len(additions.Inputs))
+		}
+		for i, sigs := range hostSigs.SiacoinInputSignatures {
+			txn.SiacoinInputs[renterInputs+i].Signatures = sigs
+		}
+		return nil
+	})
+	if err != nil {
+		return Contract{}, types.Transaction{}, err
+	}
+	return Contract{ID: txn.FileContractID(0), Revision: txn.FileContracts[0]}, txn, nil
+}
+
+// RenewContract renews the locked contract, whose current state in the chain
+// is parent. The locked contract is finalized, and as much of the renter's
+// remaining funds as possible are rolled over into renewal, the initial
+// revision of the new contract; w funds any remainder of the renter's side. On
+// success, the locked contract's revision is replaced by its final revision.
+// The returned transaction is fully signed and ready to broadcast.
+func (s *Session) RenewContract(ctx context.Context, vc consensus.ValidationContext, parent types.FileContractElement, renewal types.FileContract, settings HostSettings, minerFee types.Currency, w Wallet) (_ Contract, _ types.Transaction, err error) {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	lc := s.locked
+	if lc == nil {
+		return Contract{}, types.Transaction{}, ErrNoContractLocked
+	}
+	old := lc.contract.Revision
+	if parent.ID != lc.contract.ID {
+		return Contract{}, types.Transaction{}, errors.New("parent is not the locked contract")
+	} else if err := ValidateContractRenewal(old, renewal, vc.Index.Height, settings); err != nil {
+		return Contract{}, types.Transaction{}, fmt.Errorf("invalid renewal: %w", err)
+	}
+
+	final := old
+	final.RevisionNumber = types.MaxRevisionNumber
+	final.RenterSignature = lc.key.SignHash(vc.ContractSigHash(final))
+	renewal.RenterSignature = lc.key.SignHash(vc.ContractSigHash(renewal))
+	renterRollover := final.RenterOutput.Value
+	if renewal.RenterOutput.Value.Cmp(renterRollover) < 0 {
+		renterRollover = renewal.RenterOutput.Value
+	}
+	txn := types.Transaction{
+		FileContractResolutions: []types.FileContractResolution{{
+			Parent: parent,
+			Renewal: types.FileContractRenewal{
+				FinalRevision:   final,
+				InitialRevision: renewal,
+				RenterRollover:  renterRollover,
+			},
+		}},
+		MinerFee: minerFee,
+	}
+	var toSign []types.ElementID
+	if cost := renewal.RenterOutput.Value.Add(settings.ContractFee).Add(vc.FileContractTax(renewal)).Add(minerFee).Sub(renterRollover); !cost.IsZero() {
+		var discard func()
+		toSign, discard, err = w.FundTransaction(&txn, cost, nil)
+		if err != nil {
+			return Contract{}, types.Transaction{}, fmt.Errorf("couldn't fund transaction: %w", err)
+		}
+		defer func() {
+			if err != nil {
+				discard()
+			}
+		}()
+	}
+	renterInputs := len(txn.SiacoinInputs)
+
+	err = s.withStream(ctx, func(stream *mux.Stream) error {
+		req := &RPCRenewContractRequest{
+			Inputs:     txn.SiacoinInputs,
+			Outputs:    txn.SiacoinOutputs,
+			MinerFee:   minerFee,
+			Resolution: txn.FileContractResolutions[0],
+		}
+		if err := rpc.WriteRequest(stream, RPCRenewContractID, req); err != nil {
+			return err
+		}
+		var additions RPCRenewContractHostAdditions
+		if err := s.ReadResponse(stream, &additions); err != nil {
+			return err
+		}
+		r := &txn.FileContractResolutions[0].Renewal
+		r.HostRollover = additions.HostRollover
+		r.FinalRevision.HostSignature = additions.FinalizationSignature
+		r.InitialRevision.HostSignature = additions.InitialSignature
+		r.HostSignature = additions.RenewalSignature
+		hostKey := old.HostPublicKey
+		switch {
+		case !hostKey.VerifyHash(vc.ContractSigHash(r.FinalRevision), r.FinalRevision.HostSignature):
+			return fmt.Errorf("host finalization %w", ErrInvalidSignature)
+		case !hostKey.VerifyHash(vc.ContractSigHash(r.InitialRevision), r.InitialRevision.HostSignature):
+			return fmt.Errorf("host initial revision %w", ErrInvalidSignature)
+		case !hostKey.VerifyHash(vc.RenewalSigHash(*r), r.HostSignature):
+			return fmt.Errorf("host renewal %w", ErrInvalidSignature)
+		}
+		r.RenterSignature = lc.key.SignHash(vc.RenewalSigHash(*r))
+		txn.SiacoinInputs = append(txn.SiacoinInputs, additions.Inputs...)
+		txn.SiacoinOutputs = append(txn.SiacoinOutputs, additions.Outputs...)
+
+		if len(toSign) > 0 {
+			if err := w.SignTransaction(vc, &txn, toSign); err != nil {
+				return fmt.Errorf("couldn't sign transaction: %w", err)
+			}
+		}
+		renterSigs := &RPCRenewContractRenterSignatures{
+			SiacoinInputSignatures: make([][]types.Signature, renterInputs),
+			RenewalSignature:       r.RenterSignature,
+		}
+		for i := range renterSigs.SiacoinInputSignatures {
+			renterSigs.SiacoinInputSignatures[i] = txn.SiacoinInputs[i].Signatures
+		}
+		if err := s.WriteResponse(stream, renterSigs); err != nil {
+			return err
+		}
+		var hostSigs RPCContractSignatures
+		if err := s.ReadResponse(stream, &hostSigs); err != nil {
+			return err
+		} else if len(hostSigs.SiacoinInputSignatures) != len(additions.Inputs) {
+			return fmt.Errorf("host sent signatures for %v inputs, expected %v", len(hostSigs.SiacoinInputSignatures), len(additions.Inputs))
+		}
+		for i, sigs := range hostSigs.SiacoinInputSignatures {
+			txn.SiacoinInputs[renterInputs+i].Signatures = sigs
+		}
+		return nil
+	})
+	if err != nil {
+		return Contract{}, types.Transaction{}, err
+	}
+	r := txn.FileContractResolutions[0].Renewal
+	lc.contract.Revision = r.FinalRevision
+	// the renewed contract is created after any new contracts in the
+	// transaction, and is thus assigned the next contract ID
+	return Contract{ID: txn.FileContractID(len(txn.FileContracts)), Revision: r.InitialRevision}, txn, nil
+}
+
+// Read downloads the given sections of sectors stored under the locked
+// contract, writing them to w in order, and pays for them with a revision of
+// the contract. Each section must be aligned to 64-byte segments, and is
+// verified against the root of its sector before it is written to w.
+func (s *Session) Read(ctx context.Context, settings HostSettings, sections []RPCReadRequestSection, w io.Writer) error {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	lc := s.locked
+	if lc == nil {
+		return ErrNoContractLocked
+	} else if len(sections) == 0 {
+		return errors.New("no sections requested")
+	}
+	rev, err := PaymentRevision(lc.contract.Revision, RPCReadCost(settings, sections))
+	if err != nil {
+		return err
+	}
+	rev.RenterSignature = lc.sign(rev)
+
+	err = s.withStream(ctx, func(stream *mux.Stream) error {
+		req := &RPCReadRequest{
+			Sections:          sections,
+			MerkleProof:       true,
+			NewRevisionNumber: rev.RevisionNumber,
+			NewOutputs:        contractOutputs(rev),
+			Signature:         rev.RenterSignature,
+		}
+		if err := rpc.WriteRequest(stream, RPCReadID, req); err != nil {
+			return err
+		}
+		// the host's signature accompanies the final section
+		var resp RPCReadResponse
+		for i, sec := range sections {
+			if err := s.ReadResponse(stream, &resp); err != nil {
+				return err
+			} else if uint64(len(resp.Data)) != sec.Length {
+				return fmt.Errorf("host sent %v bytes for section %v, expected %v", len(resp.Data), i, sec.Length)
+			} else if !VerifySectorRangeProof(sec.MerkleRoot, resp.Data, sec.Offset, resp.MerkleProof) {
+				return fmt.Errorf("host sent invalid proof for section %v", i)
+			} else if _, err := w.Write(resp.Data); err != nil {
+				return err
+			}
+		}
+		rev.HostSignature = resp.Signature
+		return lc.verifyHost(rev, rev.HostSignature)
+	})
+	if err != nil {
+		return err
+	}
+	lc.contract.Revision = rev
+	return nil
+}
+
+// Write applies actions to the sectors stored under the locked contract, and
+// pays for them with a revision of the contract that commits to the resulting
+// sector roots. The host proves that the new roots were derived from the
+// contract's existing roots; since the new root of an updated sector depends
+// on its prior contents, updatedRoots must contain the new root of each sector
+// modified by an Update action, in order.
+func (s *Session) Write(ctx context.Context, settings HostSettings, actions []RPCWriteAction, updatedRoots []types.Hash256) error {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	lc := s.locked
+	if lc == nil {
+		return ErrNoContractLocked
+	}
+	current := lc.contract.Revision
+	oldNumSectors := current.Filesize / SectorSize
+	_, newNumSectors, err := writeActionIndices(actions, oldNumSectors)
+	if err != nil {
+		return err
+	}
+	var duration uint64
+	if current.WindowStart > settings.BlockHeight {
+		duration = current.WindowStart - settings.BlockHeight
+	}
+	cost, collateral := RPCWriteCost(settings, duration, actions)
+	rev, err := WriteRevision(current, cost, collateral)
+	if err != nil {
+		return err
+	}
+
+	err = s.withStream(ctx, func(stream *mux.Stream) error {
+		req := &RPCWriteRequest{
+			Actions:           actions,
+			MerkleProof:       true,
+			NewRevisionNumber: rev.RevisionNumber,
+			NewOutputs:        contractOutputs(rev),
+		}
+		if err := rpc.WriteRequest(stream, RPCWriteID, req); err != nil {
+			return err
+		}
+		var proof RPCWriteMerkleProof
+		if err := s.ReadResponse(stream, &proof); err != nil {
+			return err
+		} else if err := VerifyWriteProof(current.FileMerkleRoot, oldNumSectors, actions, updatedRoots, proof); err != nil {
+			return fmt.Errorf("host sent invalid write proof: %w", err)
+		}
+		rev.Filesize = newNumSectors * SectorSize
+		rev.FileMerkleRoot = proof.NewMerkleRoot
+		rev.RenterSignature = lc.sign(rev)
+		if err := s.WriteResponse(stream, &RPCWriteResponse{Signature: rev.RenterSignature}); err != nil {
+			return err
+		}
+		var resp RPCWriteResponse
+		if err := s.ReadResponse(stream, &resp); err != nil {
+			return err
+		}
+		rev.HostSignature = resp.Signature
+		return lc.verifyHost(rev, rev.HostSignature)
+	})
+	if err != nil {
+		return err
+	}
+	lc.contract.Revision = rev
+	return nil
+}
+
+// SectorRoots downloads numRoots sector roots of the locked contract, starting
+// at offset, and pays for them with a revision of the contract. The roots are
+// verified against the contract's Merkle root.
+func (s *Session) SectorRoots(ctx context.Context, settings HostSettings, offset, numRoots uint64) ([]types.Hash256, error) {
+	s.renterMu.Lock()
+	defer s.renterMu.Unlock()
+	lc := s.locked
+	if lc == nil {
+		return nil, ErrNoContractLocked
+	}
+	current := lc.contract.Revision
+	rev, err := PaymentRevision(current, RPCSectorRootsCost(settings, numRoots))
+	if err != nil {
+		return nil, err
+	}
+	rev.RenterSignature = lc.sign(rev)
+
+	var resp RPCSectorRootsResponse
+	err = s.withStream(ctx, func(stream *mux.Stream) error {
+		req := &RPCSectorRootsRequest{
+			RootOffset:        offset,
+			NumRoots:          numRoots,
+			NewRevisionNumber: rev.RevisionNumber,
+			NewOutputs:        contractOutputs(rev),
+			Signature:         rev.RenterSignature,
+		}
+		if err := rpc.WriteRequest(stream, RPCSectorRootsID, req); err != nil {
+			return err
+		} else if err := s.ReadResponse(stream, &resp); err != nil {
+			return err
+		} else if uint64(len(resp.SectorRoots)) != numRoots {
+			return fmt.Errorf("host sent %v roots, expected %v", len(resp.SectorRoots), numRoots)
+		} else if !VerifySectorRootsProof(current.FileMerkleRoot, current.Filesize/SectorSize, offset, resp.SectorRoots, resp.MerkleProof) {
+			return errors.New("host sent invalid sector roots proof")
+		}
+		rev.HostSignature = resp.Signature
+		return lc.verifyHost(rev, rev.HostSignature)
+	})
+	if err != nil {
+		return nil, err
+	}
+	lc.contract.Revision = rev
+	return resp.SectorRoots, nil
+}
@@ -0,0 +1,282 @@
+package rhp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+func TestWriteRevision(t *testing.T) {
+	fc := types.FileContract{
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Value: types.Siacoins(5)},
+		MissedHostValue: types.Siacoins(5),
+	}
+	cost, collateral := types.Siacoins(2), types.Siacoins(1)
+	rev, err := WriteRevision(fc, cost, collateral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev.Filesize = SectorSize
+	rev.FileMerkleRoot = types.Hash256{1}
+	if err := ValidateWriteRevision(fc, rev, SectorSize, types.Hash256{1}, cost, collateral); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateWriteRevision(fc, rev, SectorSize, types.Hash256{1}, cost.Add(types.Siacoins(1)), collateral); err == nil {
+		t.Fatal("expected insufficient payment to be rejected")
+	} else if err := ValidateWriteRevision(fc, rev, SectorSize, types.Hash256{1}, cost, types.ZeroCurrency); err == nil {
+		t.Fatal("expected excessive collateral burn to be rejected")
+	} else if err := ValidateWriteRevision(fc, rev, 0, types.Hash256{1}, cost, collateral); err == nil {
+		t.Fatal("expected incorrect filesize to be rejected")
+	}
+	if _, err := WriteRevision(fc, types.Siacoins(11), collateral); err == nil {
+		t.Fatal("expected insufficient funds")
+	} else if _, err := WriteRevision(fc, cost, types.Siacoins(6)); err == nil {
+		t.Fatal("expected insufficient collateral")
+	}
+}
+
+// serveRenterRPCs handles the contract RPCs issued by TestSessionContract,
+// storing appended sectors in memory.
+func serveRenterRPCs(sess *Session, vc consensus.ValidationContext, hostKey types.PrivateKey, c Contract) error {
+	var roots []types.Hash256
+	sectors := make(map[types.Hash256]*[SectorSize]byte)
+	revise := func(rev types.FileContract, num uint64, outputs ContractOutputs) types.FileContract {
+		rev.RevisionNumber = num
+		rev.RenterOutput.Value = outputs.RenterValue
+		rev.HostOutput.Value = outputs.HostValue
+		rev.MissedHostValue = outputs.MissedHostValue
+		return rev
+	}
+	sign := func(rev *types.FileContract, renterSig types.Signature) error {
+		hash := vc.ContractSigHash(*rev)
+		if !rev.RenterPublicKey.VerifyHash(hash, renterSig) {
+			return ErrInvalidSignature
+		}
+		rev.RenterSignature = renterSig
+		rev.HostSignature = hostKey.SignHash(hash)
+		return nil
+	}
+
+	for {
+		stream, err := sess.AcceptStream()
+		if err != nil {
+			return err
+		}
+		id, err := rpc.ReadID(stream)
+		if err != nil {
+			return err
+		}
+		switch id {
+		case RPCLockID:
+			var req RPCLockRequest
+			if err = sess.Limits.ReadRequest(stream, &req); err != nil {
+				break
+			} else if !sess.VerifyChallenge(req.Signature, c.Revision.RenterPublicKey) {
+				err = errors.New("invalid challenge signature")
+				break
+			}
+			var challenge [16]byte
+			types.ReadEntropy(challenge[:])
+			sess.SetChallenge(challenge)
+			err = sess.WriteResponse(stream, &RPCLockResponse{
+				Acquired:     true,
+				NewChallenge: challenge,
+				Revision:     types.FileContractRevision{Parent: types.FileContractElement{StateElement: types.StateElement{ID: c.ID}}, Revision: c.Revision},
+			})
+
+		case RPCWriteID:
+			var req RPCWriteRequest
+			if err = sess.Limits.ReadRequest(stream, &req); err != nil {
+				break
+			}
+			newRoots := append([]types.Hash256(nil), roots...)
+			for _, action := range req.Actions {
+				var sector [SectorSize]byte
+				copy(sector[:], action.Data)
+				root := SectorRoot(&sector)
+				sectors[root] = &sector
+				newRoots = append(newRoots, root)
+			}
+			var proof RPCWriteMerkleProof
+			if proof, err = BuildWriteProof(roots, newRoots, req.Actions); err != nil {
+				break
+			} else if err = sess.WriteResponse(stream, &proof); err != nil {
+				break
+			}
+			rev := revise(c.Revision, req.NewRevisionNumber, req.NewOutputs)
+			rev.Filesize = uint64(len(newRoots)) * SectorSize
+			rev.FileMerkleRoot = proof.NewMerkleRoot
+			var renterSig RPCWriteResponse
+			if err = sess.ReadResponse(stream, &renterSig); err != nil {
+				break
+			} else if err = ValidateWriteRevision(c.Revision, rev, rev.Filesize, rev.FileMerkleRoot, types.ZeroCurrency, types.ZeroCurrency); err != nil {
+				break
+			} else if err = sign(&rev, renterSig.Signature); err != nil {
+				break
+			}
+			c.Revision, roots = rev, newRoots
+			err = sess.WriteResponse(stream, &RPCWriteResponse{Signature: rev.HostSignature})
+
+		case RPCReadID:
+			var req RPCReadRequest
+			if err = sess.Limits.ReadRequest(stream, &req); err != nil {
+				break
+			}
+			rev := revise(c.Revision, req.NewRevisionNumber, req.NewOutputs)
+			if err = sign(&rev, req.Signature); err != nil {
+				break
+			}
+			c.Revision = rev
+			for i, sec := range req.Sections {
+				sector := sectors[sec.MerkleRoot]
+				resp := &RPCReadResponse{Data: sector[sec.Offset:][:sec.Length]}
+				if resp.MerkleProof, err = BuildSectorRangeProof(sector, sec.Offset, sec.Length); err != nil {
+					break
+				}
+				if i == len(req.Sections)-1 {
+					resp.Signature = rev.HostSignature
+				}
+				if err = sess.WriteResponse(stream, resp); err != nil {
+					break
+				}
+			}
+
+		case RPCSectorRootsID:
+			var req RPCSectorRootsRequest
+			if err = sess.Limits.ReadRequest(stream, &req); err != nil {
+				break
+			}
+			rev := revise(c.Revision, req.NewRevisionNumber, req.NewOutputs)
+			if err = sign(&rev, req.Signature); err != nil {
+				break
+			}
+			c.Revision = rev
+			resp := &RPCSectorRootsResponse{
+				Signature:   rev.HostSignature,
+				SectorRoots: roots[req.RootOffset:][:req.NumRoots],
+			}
+			if resp.MerkleProof, err = BuildSectorRootsProof(roots, req.RootOffset, req.NumRoots); err != nil {
+				break
+			}
+			err = sess.WriteResponse(stream, resp)
+
+		case RPCUnlockID:
+			stream.Close()
+			return nil
+		}
+		stream.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestSessionContract(t *testing.T) {
+	var vc consensus.ValidationContext
+	renterKey := types.GeneratePrivateKey()
+	hostKey := types.GeneratePrivateKey()
+	fc := types.FileContract{
+		WindowStart:     100,
+		WindowEnd:       200,
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Value: types.Siacoins(10)},
+		MissedHostValue: types.Siacoins(10),
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   hostKey.PublicKey(),
+	}
+	hash := vc.ContractSigHash(fc)
+	fc.RenterSignature = renterKey.SignHash(hash)
+	fc.HostSignature = hostKey.SignHash(hash)
+	c := Contract{ID: types.ElementID{Index: 1}, Revision: fc}
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	hostErr := make(chan error, 1)
+	go func() {
+		hostErr <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sess, err := AcceptSession(conn, hostKey)
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+			return serveRenterRPCs(sess, vc, hostKey, c)
+		}()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := DialSession(conn, hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	ctx := context.Background()
+	// zero prices, so that the in-test host need not compute costs
+	settings := HostSettings{BlockHeight: 10}
+
+	if err := sess.Write(ctx, settings, nil, nil); !errors.Is(err, ErrNoContractLocked) {
+		t.Fatal("expected ErrNoContractLocked, got", err)
+	}
+	if locked, err := sess.Lock(ctx, vc, c.ID, renterKey, time.Second); err != nil {
+		t.Fatal(err)
+	} else if locked != c {
+		t.Fatal("locked contract does not match")
+	} else if _, err := sess.Lock(ctx, vc, c.ID, renterKey, time.Second); !errors.Is(err, ErrContractAlreadyLocked) {
+		t.Fatal("expected ErrContractAlreadyLocked, got", err)
+	}
+
+	var sector [SectorSize]byte
+	types.ReadEntropy(sector[:256])
+	root := SectorRoot(&sector)
+	actions := []RPCWriteAction{{Type: RPCWriteActionAppend, Data: sector[:]}}
+	if err := sess.Write(ctx, settings, actions, nil); err != nil {
+		t.Fatal(err)
+	} else if locked, _ := sess.LockedContract(); locked.Revision.Filesize != SectorSize || locked.Revision.FileMerkleRoot != MetaRoot([]types.Hash256{root}) {
+		t.Fatal("revision does not reflect the write")
+	}
+
+	var buf bytes.Buffer
+	sections := []RPCReadRequestSection{{MerkleRoot: root, Offset: 64, Length: 128}, {MerkleRoot: root, Offset: 0, Length: 64}}
+	if err := sess.Read(ctx, settings, sections, &buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), append(append([]byte(nil), sector[64:192]...), sector[:64]...)) {
+		t.Fatal("read returned wrong data")
+	}
+
+	if roots, err := sess.SectorRoots(ctx, settings, 0, 1); err != nil {
+		t.Fatal(err)
+	} else if len(roots) != 1 || roots[0] != root {
+		t.Fatal("wrong sector roots")
+	}
+
+	if locked, _ := sess.LockedContract(); locked.Revision.RevisionNumber != 3 {
+		t.Fatal("expected three revisions, got", locked.Revision.RevisionNumber)
+	} else if err := locked.ValidateSignatures(vc); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	} else if _, ok := sess.LockedContract(); ok {
+		t.Fatal("contract should be unlocked")
+	} else if err := <-hostErr; err != nil {
+		t.Fatal(err)
+	}
+}
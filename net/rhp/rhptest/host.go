@@ -0,0 +1,393 @@
+// Package rhptest provides hosts that deviate from the renter-host protocol in
+// configurable ways, for testing renter implementations against misbehaving
+// peers.
+package rhptest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/host"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// ErrStalled is returned by a stream intercepted by a Host when it was stalled
+// by its Persona and the Host was subsequently closed.
+var ErrStalled = errors.New("rhptest: host stalled")
+
+// A Persona describes the ways in which a Host deviates from the protocol. The
+// zero value is an honest host.
+type Persona struct {
+	// WithholdProofs causes the host to omit the Merkle proofs requested by
+	// the renter in the Read and Write RPCs.
+	WithholdProofs bool
+	// CorruptData causes the host to flip a bit in each segment of the sector
+	// data it serves. The accompanying proofs are built from the corrupted
+	// data.
+	CorruptData bool
+	// StallAfter, if non-zero, causes the host to stop writing Read responses
+	// after the given number of bytes, until the Host is closed.
+	StallAfter int
+	// Overcharge, if greater than one, multiplies the bandwidth and storage
+	// prices that the host charges. The host's settings report the multiplied
+	// prices, so a renter that fetched them beforehand will be asked to pay
+	// more than it was quoted.
+	Overcharge uint64
+}
+
+// A Host is a renter-host protocol host, backed by host.Server and in-memory
+// stores, whose behavior is controlled by a Persona. Its stores and settings
+// can also back other host implementations, which apply the Persona to their
+// RPCs by passing each RPC stream through Intercept. It cannot fund contracts,
+// so contracts must be added with AddContract rather than formed via RPC.
+type Host struct {
+	priv      types.PrivateKey
+	settings  rhp.HostSettings
+	server    *host.Server
+	sectors   *sectorStore
+	contracts *contractStore
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	persona Persona
+}
+
+// Persona returns the Host's current Persona.
+func (h *Host) Persona() Persona {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.persona
+}
+
+// SetPersona changes the Host's Persona, taking effect on the next RPC.
+func (h *Host) SetPersona(p Persona) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.persona = p
+}
+
+// PublicKey returns the Host's public key.
+func (h *Host) PublicKey() types.PublicKey {
+	return h.priv.PublicKey()
+}
+
+// Settings implements host.SettingsReporter, applying the Persona's
+// Overcharge to the Host's base settings.
+func (h *Host) Settings() rhp.HostSettings {
+	s := h.settings
+	if n := h.Persona().Overcharge; n > 1 {
+		s.DownloadBandwidthPrice = s.DownloadBandwidthPrice.Mul64(n)
+		s.UploadBandwidthPrice = s.UploadBandwidthPrice.Mul64(n)
+		s.StoragePrice = s.StoragePrice.Mul64(n)
+	}
+	return s
+}
+
+// SectorStore returns the Host's sector store. Sectors read from the store are
+// corrupted if the Persona calls for it.
+func (h *Host) SectorStore() host.SectorStore {
+	return h.sectors
+}
+
+// ContractStore returns the Host's contract store.
+func (h *Host) ContractStore() host.ContractStore {
+	return h.contracts
+}
+
+// AddContract adds c to the Host's contracts, as if it had been formed via
+// RPC.
+func (h *Host) AddContract(c rhp.Contract) error {
+	return h.contracts.Add(c, types.Transaction{})
+}
+
+// Contract returns the Host's latest revision of the contract with the given
+// ID.
+func (h *Host) Contract(id types.ElementID) (rhp.Contract, error) {
+	return h.contracts.Get(id)
+}
+
+// ServeConn conducts the host's side of a Session on conn, handling RPCs
+// until the Session is closed.
+func (h *Host) ServeConn(conn net.Conn) error {
+	sess, err := rhp.AcceptSession(conn, h.priv)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	return h.server.Serve(sess)
+}
+
+// Serve accepts connections from l and serves a Session on each, until l is
+// closed.
+func (h *Host) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			h.ServeConn(conn)
+		}()
+	}
+}
+
+// Close releases any RPCs stalled by the Host's Persona.
+func (h *Host) Close() error {
+	h.closeOnce.Do(func() { close(h.closed) })
+	return nil
+}
+
+// Intercept applies the Host's Persona to the stream of the RPC with the
+// given ID, whose ID has already been read from rw. The host implementation
+// should handle the RPC using the returned stream in place of rw.
+func (h *Host) Intercept(id rpc.Specifier, rw io.ReadWriter) (io.ReadWriter, error) {
+	p := h.Persona()
+	if p.StallAfter > 0 && id == rhp.RPCReadID {
+		rw = readWriter{rw, &stallWriter{w: rw, n: p.StallAfter, stall: h.closed}}
+	}
+	if p.WithholdProofs && (id == rhp.RPCReadID || id == rhp.RPCWriteID) {
+		// clear the request's MerkleProof flag before the host sees it
+		var req rpc.Object
+		var merkleProof *bool
+		switch id {
+		case rhp.RPCReadID:
+			r := new(rhp.RPCReadRequest)
+			req, merkleProof = r, &r.MerkleProof
+		case rhp.RPCWriteID:
+			r := new(rhp.RPCWriteRequest)
+			req, merkleProof = r, &r.MerkleProof
+		}
+		if err := rpc.ReadRequest(rw, req); err != nil {
+			return nil, err
+		}
+		*merkleProof = false
+		var buf bytes.Buffer
+		if err := rpc.WriteObject(&buf, req); err != nil {
+			return nil, err
+		}
+		rw = readWriter{io.MultiReader(&buf, rw), rw}
+	}
+	return rw, nil
+}
+
+// intercept is an rpc.Interceptor that applies the Host's Persona to an RPC.
+func (h *Host) intercept(id rpc.Specifier, next rpc.Handler) rpc.Handler {
+	return func(rw io.ReadWriter) error {
+		rw, err := h.Intercept(id, rw)
+		if err != nil {
+			return err
+		}
+		return next(rw)
+	}
+}
+
+// NewHost returns a Host with the given key, base settings, and Persona. The
+// Host validates revisions against vc.
+func NewHost(priv types.PrivateKey, vc consensus.ValidationContext, settings rhp.HostSettings, p Persona) *Host {
+	h := &Host{
+		priv:      priv,
+		settings:  settings,
+		contracts: newContractStore(),
+		closed:    make(chan struct{}),
+		persona:   p,
+	}
+	h.sectors = &sectorStore{
+		h:       h,
+		sectors: make(map[types.Hash256]*[rhp.SectorSize]byte),
+		refs:    make(map[types.Hash256]uint64),
+	}
+	h.server = host.NewServer(priv, chainManager{vc}, h.sectors, h.contracts, h, noWallet{}, noTransactionPool{})
+	h.server.Use(h.intercept)
+	return h
+}
+
+// A readWriter combines a separate io.Reader and io.Writer.
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+// A stallWriter writes n bytes to w, then blocks until stall is closed.
+type stallWriter struct {
+	w     io.Writer
+	n     int
+	stall <-chan struct{}
+}
+
+func (sw *stallWriter) Write(p []byte) (int, error) {
+	if len(p) <= sw.n {
+		sw.n -= len(p)
+		return sw.w.Write(p)
+	}
+	n, err := sw.w.Write(p[:sw.n])
+	sw.n = 0
+	if err != nil {
+		return n, err
+	}
+	<-sw.stall
+	return n, ErrStalled
+}
+
+type chainManager struct {
+	vc consensus.ValidationContext
+}
+
+func (cm chainManager) TipContext() consensus.ValidationContext { return cm.vc }
+
+// sectorStore is an in-memory host.SectorStore that applies its Host's
+// Persona to the sectors it reads.
+type sectorStore struct {
+	h       *Host
+	mu      sync.Mutex
+	sectors map[types.Hash256]*[rhp.SectorSize]byte
+	refs    map[types.Hash256]uint64
+}
+
+func (ss *sectorStore) Add(root types.Hash256, sector *[rhp.SectorSize]byte) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.sectors[root] = sector
+	ss.refs[root]++
+	return nil
+}
+
+func (ss *sectorStore) Delete(root types.Hash256, references uint64) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.refs[root] <= references {
+		delete(ss.sectors, root)
+		delete(ss.refs, root)
+	} else {
+		ss.refs[root] -= references
+	}
+	return nil
+}
+
+func (ss *sectorStore) Exists(root types.Hash256) (bool, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	_, ok := ss.sectors[root]
+	return ok, nil
+}
+
+func (ss *sectorStore) Read(root types.Hash256, w io.Writer, offset, length uint64) (uint64, error) {
+	ss.mu.Lock()
+	sector, ok := ss.sectors[root]
+	ss.mu.Unlock()
+	if !ok {
+		return 0, errors.New("sector not found")
+	}
+	data := append([]byte(nil), sector[offset:][:length]...)
+	if ss.h.Persona().CorruptData {
+		for i := 0; i < len(data); i += 64 {
+			data[i] ^= 1
+		}
+	}
+	n, err := w.Write(data)
+	return uint64(n), err
+}
+
+func (ss *sectorStore) Update(root types.Hash256, offset uint64, data []byte) (types.Hash256, error) {
+	ss.mu.Lock()
+	sector, ok := ss.sectors[root]
+	ss.mu.Unlock()
+	if !ok {
+		return types.Hash256{}, errors.New("sector not found")
+	}
+	updated := *sector
+	copy(updated[offset:], data)
+	newRoot := rhp.SectorRoot(&updated)
+	return newRoot, ss.Add(newRoot, &updated)
+}
+
+// contractStore is an in-memory host.ContractStore.
+type contractStore struct {
+	mu        sync.Mutex
+	contracts map[types.ElementID]rhp.Contract
+	roots     map[types.ElementID][]types.Hash256
+}
+
+func (cs *contractStore) ProcessChainApplyUpdate(*chain.ApplyUpdate, bool) error { return nil }
+func (cs *contractStore) ProcessChainRevertUpdate(*chain.RevertUpdate) error     { return nil }
+
+func (cs *contractStore) Exists(id types.ElementID) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	_, ok := cs.contracts[id]
+	return ok
+}
+
+func (cs *contractStore) Get(id types.ElementID) (rhp.Contract, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	c, ok := cs.contracts[id]
+	if !ok {
+		return rhp.Contract{}, errors.New("contract not found")
+	}
+	return c, nil
+}
+
+func (cs *contractStore) Add(c rhp.Contract, txn types.Transaction) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.contracts[c.ID] = c
+	return nil
+}
+
+func (cs *contractStore) Revise(c rhp.Contract) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if _, ok := cs.contracts[c.ID]; !ok {
+		return errors.New("contract not found")
+	}
+	cs.contracts[c.ID] = c
+	return nil
+}
+
+func (cs *contractStore) Roots(id types.ElementID) ([]types.Hash256, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return append([]types.Hash256(nil), cs.roots[id]...), nil
+}
+
+func (cs *contractStore) SetRoots(id types.ElementID, roots []types.Hash256) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.roots[id] = append([]types.Hash256(nil), roots...)
+	return nil
+}
+
+func newContractStore() *contractStore {
+	return &contractStore{
+		contracts: make(map[types.ElementID]rhp.Contract),
+		roots:     make(map[types.ElementID][]types.Hash256),
+	}
+}
+
+// errNoFunds is returned when a renter asks the Host to fund a contract.
+var errNoFunds = errors.New("rhptest: host cannot fund contracts")
+
+type noWallet struct{}
+
+func (noWallet) Address() types.Address                              { return types.VoidAddress }
+func (noWallet) SpendPolicy(types.Address) (types.SpendPolicy, bool) { return nil, false }
+func (noWallet) SignTransaction(consensus.ValidationContext, *types.Transaction, []types.ElementID) error {
+	return errNoFunds
+}
+func (noWallet) FundTransaction(*types.Transaction, types.Currency, []types.Transaction) ([]types.ElementID, func(), error) {
+	return nil, nil, errNoFunds
+}
+
+type noTransactionPool struct{}
+
+func (noTransactionPool) AddTransaction(types.Transaction) error { return errNoFunds }
+func (noTransactionPool) RecommendedFee() types.Currency         { return types.ZeroCurrency }
@@ -0,0 +1,230 @@
+package rhptest
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+var testSettings = rhp.HostSettings{
+	AcceptingContracts:     true,
+	Version:                "1.0.0",
+	SectorSize:             rhp.SectorSize,
+	TotalStorage:           100,
+	RemainingStorage:       100,
+	WindowSize:             10,
+	MaxDuration:            1000,
+	MaxCollateral:          types.Siacoins(100),
+	Collateral:             types.NewCurrency64(1),
+	StoragePrice:           types.NewCurrency64(1),
+	UploadBandwidthPrice:   types.NewCurrency64(1),
+	DownloadBandwidthPrice: types.NewCurrency64(1),
+}
+
+// newTestSession starts an honest Host with a single contract, connects to it,
+// locks the contract, and uploads a sector, returning the sector.
+func newTestSession(t *testing.T) (*Host, *rhp.Session, *[rhp.SectorSize]byte) {
+	var vc consensus.ValidationContext
+	hostKey := types.GeneratePrivateKey()
+	renterKey := types.GeneratePrivateKey()
+	fc := types.FileContract{
+		WindowStart:     100,
+		WindowEnd:       200,
+		RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Value: types.Siacoins(5)},
+		MissedHostValue: types.Siacoins(5),
+		TotalCollateral: types.Siacoins(5),
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   hostKey.PublicKey(),
+	}
+	hash := vc.ContractSigHash(fc)
+	fc.RenterSignature = renterKey.SignHash(hash)
+	fc.HostSignature = hostKey.SignHash(hash)
+	c := rhp.Contract{ID: types.ElementID{Index: 1}, Revision: fc}
+
+	h := NewHost(hostKey, vc, testSettings, Persona{})
+	if err := h.AddContract(c); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	t.Cleanup(func() { h.Close() })
+	go h.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess, err := rhp.DialSession(conn, h.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sess.Close() })
+
+	ctx := context.Background()
+	if _, err := sess.Lock(ctx, vc, c.ID, renterKey, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var sector [rhp.SectorSize]byte
+	types.ReadEntropy(sector[:256])
+	actions := []rhp.RPCWriteAction{{Type: rhp.RPCWriteActionAppend, Data: sector[:]}}
+	if err := sess.Write(ctx, testSettings, actions, nil); err != nil {
+		t.Fatal(err)
+	}
+	return h, sess, &sector
+}
+
+func TestPersonas(t *testing.T) {
+	tests := []struct {
+		name    string
+		persona Persona
+		errText string
+	}{
+		{"honest", Persona{}, ""},
+		{"withhold proofs", Persona{WithholdProofs: true}, "invalid proof"},
+		{"corrupt data", Persona{CorruptData: true}, "invalid proof"},
+		{"stall", Persona{StallAfter: 100}, context.DeadlineExceeded.Error()},
+		{"overcharge", Persona{Overcharge: 2}, "invalid payment revision"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h, sess, sector := newTestSession(t)
+			before, _ := sess.LockedContract()
+			h.SetPersona(test.persona)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+			defer cancel()
+			root := rhp.SectorRoot(sector)
+			sections := []rhp.RPCReadRequestSection{{MerkleRoot: root, Offset: 64, Length: 128}}
+			var buf bytes.Buffer
+			err := sess.Read(ctx, testSettings, sections, &buf)
+			after, _ := sess.LockedContract()
+			if test.errText == "" {
+				if err != nil {
+					t.Fatal(err)
+				} else if !bytes.Equal(buf.Bytes(), sector[64:192]) {
+					t.Fatal("read returned wrong data")
+				} else if after.Revision.RevisionNumber != before.Revision.RevisionNumber+1 {
+					t.Fatal("revision was not updated")
+				}
+				return
+			}
+
+			// the renter must reject the RPC without accepting any data or
+			// adopting the revision
+			if err == nil || !strings.Contains(err.Error(), test.errText) {
+				t.Fatalf("expected error containing %q, got %v", test.errText, err)
+			} else if buf.Len() != 0 {
+				t.Fatal("renter accepted data from a misbehaving host")
+			} else if after != before {
+				t.Fatal("renter adopted a revision from a misbehaving host")
+			}
+		})
+	}
+}
+
+func TestInterceptWithholdProofs(t *testing.T) {
+	h := NewHost(types.GeneratePrivateKey(), consensus.ValidationContext{}, testSettings, Persona{WithholdProofs: true})
+	sections := []rhp.RPCReadRequestSection{{Offset: 64, Length: 128}}
+	var rw bytes.Buffer
+	if err := rpc.WriteObject(&rw, &rhp.RPCReadRequest{Sections: sections, MerkleProof: true}); err != nil {
+		t.Fatal(err)
+	}
+	irw, err := h.Intercept(rhp.RPCReadID, &rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req rhp.RPCReadRequest
+	if err := rpc.ReadRequest(irw, &req); err != nil {
+		t.Fatal(err)
+	} else if req.MerkleProof {
+		t.Fatal("host should not see the MerkleProof flag")
+	} else if len(req.Sections) != 1 || req.Sections[0] != sections[0] {
+		t.Fatal("request was not preserved")
+	}
+
+	// other RPCs are unaffected
+	rw.Reset()
+	rw.WriteString("foo")
+	if irw, err := h.Intercept(rhp.RPCLockID, &rw); err != nil {
+		t.Fatal(err)
+	} else if irw != &rw {
+		t.Fatal("Lock RPC should not be intercepted")
+	}
+}
+
+func TestInterceptStall(t *testing.T) {
+	h := NewHost(types.GeneratePrivateKey(), consensus.ValidationContext{}, testSettings, Persona{StallAfter: 10})
+	var rw bytes.Buffer
+	irw, err := h.Intercept(rhp.RPCReadID, &rw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := irw.Write(make([]byte, 20))
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatal("write should stall, got", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	h.Close()
+	if err := <-errCh; err != ErrStalled {
+		t.Fatal("expected ErrStalled, got", err)
+	} else if rw.Len() != 10 {
+		t.Fatal("expected 10 bytes to be written before stalling, got", rw.Len())
+	}
+}
+
+func TestCorruptData(t *testing.T) {
+	h := NewHost(types.GeneratePrivateKey(), consensus.ValidationContext{}, testSettings, Persona{})
+	var sector [rhp.SectorSize]byte
+	types.ReadEntropy(sector[:256])
+	root := rhp.SectorRoot(&sector)
+	ss := h.SectorStore()
+	if err := ss.Add(root, &sector); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := ss.Read(root, &buf, 64, 128); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf.Bytes(), sector[64:192]) {
+		t.Fatal("honest host returned wrong data")
+	}
+
+	h.SetPersona(Persona{CorruptData: true})
+	buf.Reset()
+	if _, err := ss.Read(root, &buf, 64, 128); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 128; i += 64 {
+		if bytes.Equal(buf.Bytes()[i:][:64], sector[64+i:][:64]) {
+			t.Fatal("segment", i/64, "was not corrupted")
+		}
+	}
+}
+
+func TestOverchargeSettings(t *testing.T) {
+	h := NewHost(types.GeneratePrivateKey(), consensus.ValidationContext{}, testSettings, Persona{Overcharge: 3})
+	if s := h.Settings(); s.DownloadBandwidthPrice != testSettings.DownloadBandwidthPrice.Mul64(3) {
+		t.Fatal("overcharging host should report multiplied prices")
+	}
+	h.SetPersona(Persona{})
+	if s := h.Settings(); s.DownloadBandwidthPrice != testSettings.DownloadBandwidthPrice {
+		t.Fatal("honest host should report base prices")
+	}
+}
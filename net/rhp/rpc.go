@@ -9,9 +9,6 @@ import (
 	"go.sia.tech/core/types"
 )
 
-const defaultMaxLen = 10e3 // for revisions, proofs, etc.
-const largeMaxLen = 1e6    // for transactions
-
 // ContractOutputs contains the output values for a FileContract. Because the
 // revisions negotiated by the renter and host typically do not modify the
 // output recipients, we can save some space by only sending the new values.
@@ -28,6 +25,14 @@ func (co ContractOutputs) Apply(fc *types.FileContract) {
 	fc.MissedHostValue = co.MissedHostValue
 }
 
+// minimum encoded lengths, used to bound allocations when decoding
+var (
+	minSiacoinInputLen   = types.EncodedLen(types.SiacoinInput{SpendPolicy: types.PolicyThreshold{}})
+	siacoinOutputLen     = types.EncodedLen(types.SiacoinOutput{})
+	minRPCWriteActionLen = types.EncodedLen(&RPCWriteAction{})
+	minInstructionLen    = len(rpc.Specifier{})
+)
+
 // RPC IDs
 var (
 	RPCLockID        = rpc.NewSpecifier("Lock")
@@ -41,6 +46,10 @@ var (
 	RPCFundAccountID    = rpc.NewSpecifier("FundAccount")
 	RPCFormContractID   = rpc.NewSpecifier("FormContract")
 	RPCLatestRevisionID = rpc.NewSpecifier("LatestRevision")
+	RPCPriceTableID     = rpc.NewSpecifier("PriceTable")
+	RPCReconcileID      = rpc.NewSpecifier("Reconcile")
+	RPCRegistryReadID   = rpc.NewSpecifier("RegistryRead")
+	RPCRegistryUpdateID = rpc.NewSpecifier("RegistryUpdate")
 	RPCRenewContractID  = rpc.NewSpecifier("RenewContract")
 	RPCSettingsID       = rpc.NewSpecifier("Settings")
 )
@@ -208,7 +217,7 @@ func writeMerkleProof(e *types.Encoder, proof []types.Hash256) {
 }
 
 func readMerkleProof(d *types.Decoder) (proof []types.Hash256) {
-	proof = make([]types.Hash256, d.ReadPrefix())
+	proof = make([]types.Hash256, d.ReadSlicePrefix(32))
 	for i := range proof {
 		proof[i].DecodeFrom(d)
 	}
@@ -247,11 +256,11 @@ func (r *RPCFormContractRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCFormContractRequest) DecodeFrom(d *types.Decoder) {
-	r.Inputs = make([]types.SiacoinInput, d.ReadPrefix())
+	r.Inputs = make([]types.SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 	for i := range r.Inputs {
 		r.Inputs[i].DecodeFrom(d)
 	}
-	r.Outputs = make([]types.SiacoinOutput, d.ReadPrefix())
+	r.Outputs = make([]types.SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 	for i := range r.Outputs {
 		r.Outputs[i].DecodeFrom(d)
 	}
@@ -261,7 +270,7 @@ func (r *RPCFormContractRequest) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCFormContractRequest) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -280,11 +289,11 @@ func (r *RPCRenewContractRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCRenewContractRequest) DecodeFrom(d *types.Decoder) {
-	r.Inputs = make([]types.SiacoinInput, d.ReadPrefix())
+	r.Inputs = make([]types.SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 	for i := range r.Inputs {
 		r.Inputs[i].DecodeFrom(d)
 	}
-	r.Outputs = make([]types.SiacoinOutput, d.ReadPrefix())
+	r.Outputs = make([]types.SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 	for i := range r.Outputs {
 		r.Outputs[i].DecodeFrom(d)
 	}
@@ -294,7 +303,7 @@ func (r *RPCRenewContractRequest) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCRenewContractRequest) MaxLen() int {
-	return largeMaxLen
+	return rpc.LargeMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -312,11 +321,11 @@ func (r *RPCFormContractHostAdditions) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCFormContractHostAdditions) DecodeFrom(d *types.Decoder) {
-	r.Inputs = make([]types.SiacoinInput, d.ReadPrefix())
+	r.Inputs = make([]types.SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 	for i := range r.Inputs {
 		r.Inputs[i].DecodeFrom(d)
 	}
-	r.Outputs = make([]types.SiacoinOutput, d.ReadPrefix())
+	r.Outputs = make([]types.SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 	for i := range r.Outputs {
 		r.Outputs[i].DecodeFrom(d)
 	}
@@ -325,7 +334,7 @@ func (r *RPCFormContractHostAdditions) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCFormContractHostAdditions) MaxLen() int {
-	return largeMaxLen
+	return rpc.LargeMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -346,11 +355,11 @@ func (r *RPCRenewContractHostAdditions) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCRenewContractHostAdditions) DecodeFrom(d *types.Decoder) {
-	r.Inputs = make([]types.SiacoinInput, d.ReadPrefix())
+	r.Inputs = make([]types.SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 	for i := range r.Inputs {
 		r.Inputs[i].DecodeFrom(d)
 	}
-	r.Outputs = make([]types.SiacoinOutput, d.ReadPrefix())
+	r.Outputs = make([]types.SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 	for i := range r.Outputs {
 		r.Outputs[i].DecodeFrom(d)
 	}
@@ -362,7 +371,7 @@ func (r *RPCRenewContractHostAdditions) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCRenewContractHostAdditions) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -378,9 +387,9 @@ func (r *RPCContractSignatures) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCContractSignatures) DecodeFrom(d *types.Decoder) {
-	r.SiacoinInputSignatures = make([][]types.Signature, d.ReadPrefix())
+	r.SiacoinInputSignatures = make([][]types.Signature, d.ReadSlicePrefix(8))
 	for i := range r.SiacoinInputSignatures {
-		r.SiacoinInputSignatures[i] = make([]types.Signature, d.ReadPrefix())
+		r.SiacoinInputSignatures[i] = make([]types.Signature, d.ReadSlicePrefix(64))
 		for j := range r.SiacoinInputSignatures[i] {
 			r.SiacoinInputSignatures[i][j].DecodeFrom(d)
 		}
@@ -389,7 +398,7 @@ func (r *RPCContractSignatures) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCContractSignatures) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -406,9 +415,9 @@ func (r *RPCRenewContractRenterSignatures) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCRenewContractRenterSignatures) DecodeFrom(d *types.Decoder) {
-	r.SiacoinInputSignatures = make([][]types.Signature, d.ReadPrefix())
+	r.SiacoinInputSignatures = make([][]types.Signature, d.ReadSlicePrefix(8))
 	for i := range r.SiacoinInputSignatures {
-		r.SiacoinInputSignatures[i] = make([]types.Signature, d.ReadPrefix())
+		r.SiacoinInputSignatures[i] = make([]types.Signature, d.ReadSlicePrefix(64))
 		for j := range r.SiacoinInputSignatures[i] {
 			r.SiacoinInputSignatures[i][j].DecodeFrom(d)
 		}
@@ -418,7 +427,7 @@ func (r *RPCRenewContractRenterSignatures) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCRenewContractRenterSignatures) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -456,7 +465,7 @@ func (r *RPCLockResponse) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCLockResponse) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -475,7 +484,7 @@ func (r *RPCReadRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCReadRequest) DecodeFrom(d *types.Decoder) {
-	r.Sections = make([]RPCReadRequestSection, d.ReadPrefix())
+	r.Sections = make([]RPCReadRequestSection, d.ReadSlicePrefix(32+8+8))
 	for i := range r.Sections {
 		r.Sections[i].MerkleRoot.DecodeFrom(d)
 		r.Sections[i].Offset = d.ReadUint64()
@@ -489,7 +498,7 @@ func (r *RPCReadRequest) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCReadRequest) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -504,16 +513,21 @@ func (r *RPCReadResponse) DecodeFrom(d *types.Decoder) {
 	r.Signature.DecodeFrom(d)
 
 	// r.Data will typically be large (4 MiB), so reuse the existing capacity if
-	// possible.
+	// possible. Otherwise, avoid a copy if d is decoding from a buffer; in that
+	// case r.Data aliases the buffer.
 	//
 	// NOTE: for maximum efficiency, we should be doing this for every slice,
 	// but in most cases the extra performance isn't worth the aliasing risk.
-	dataLen := d.ReadPrefix()
-	if cap(r.Data) < dataLen {
-		r.Data = make([]byte, dataLen)
+	if r.Data == nil {
+		r.Data = d.ReadBytesNoCopy()
+	} else {
+		dataLen := d.ReadPrefix()
+		if cap(r.Data) < dataLen {
+			r.Data = make([]byte, dataLen)
+		}
+		r.Data = r.Data[:dataLen]
+		d.Read(r.Data)
 	}
-	r.Data = r.Data[:dataLen]
-	d.Read(r.Data)
 
 	r.MerkleProof = readMerkleProof(d)
 }
@@ -562,7 +576,7 @@ func (r *RPCSectorRootsResponse) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCSectorRootsResponse) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -594,7 +608,7 @@ func (r *RPCWriteRequest) EncodeTo(e *types.Encoder) {
 
 // DecodeFrom implements rpc.Object.
 func (r *RPCWriteRequest) DecodeFrom(d *types.Decoder) {
-	r.Actions = make([]RPCWriteAction, d.ReadPrefix())
+	r.Actions = make([]RPCWriteAction, d.ReadSlicePrefix(minRPCWriteActionLen))
 	for i := range r.Actions {
 		r.Actions[i].DecodeFrom(d)
 	}
@@ -605,7 +619,8 @@ func (r *RPCWriteRequest) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCWriteRequest) MaxLen() int {
-	return defaultMaxLen
+	// large enough for several appended sectors
+	return 16*(1<<20) + rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -624,7 +639,7 @@ func (r *RPCWriteMerkleProof) DecodeFrom(d *types.Decoder) {
 
 // MaxLen implements rpc.Object.
 func (r *RPCWriteMerkleProof) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo implements rpc.Object.
@@ -642,27 +657,27 @@ func (r *RPCWriteResponse) MaxLen() int {
 	return 64
 }
 
-// RPCSettingsResponse contains the JSON-encoded settings for a host.
+// RPCSettingsResponse contains the settings for a host.
 type RPCSettingsResponse struct {
-	Settings []byte
+	Settings HostSettings
 }
 
 // MaxLen returns the maximum encoded length of an object. Implements
 // rpc.Object.
 func (r *RPCSettingsResponse) MaxLen() int {
-	return defaultMaxLen
+	return r.Settings.MaxLen()
 }
 
 // EncodeTo encodes a RPCSettingsResponse to an encoder. Implements
 // types.EncoderTo.
 func (r *RPCSettingsResponse) EncodeTo(e *types.Encoder) {
-	e.WriteBytes(r.Settings)
+	r.Settings.EncodeTo(e)
 }
 
 // DecodeFrom decodes a RPCSettingsResponse from a decoder. Implements
 // types.DecoderFrom.
 func (r *RPCSettingsResponse) DecodeFrom(d *types.Decoder) {
-	r.Settings = d.ReadBytes()
+	r.Settings.DecodeFrom(d)
 }
 
 // RPCLatestRevisionRequest requests the host send the latest revision of the
@@ -698,7 +713,7 @@ type RPCLatestRevisionResponse struct {
 // MaxLen returns the maximum encoded length of an object. Implements
 // rpc.Object.
 func (r *RPCLatestRevisionResponse) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo encodes a RPCLatestRevisionResponse to an encoder. Implements
@@ -713,6 +728,54 @@ func (r *RPCLatestRevisionResponse) DecodeFrom(d *types.Decoder) {
 	r.Revision.DecodeFrom(d)
 }
 
+// RPCReconcileRequest contains the renter's latest known revision of a
+// contract, sent when reconnecting to a host after a transient disconnect.
+type RPCReconcileRequest struct {
+	Revision Contract
+}
+
+// MaxLen returns the maximum encoded length of an object. Implements
+// rpc.Object.
+func (r *RPCReconcileRequest) MaxLen() int {
+	return rpc.DefaultMaxLen
+}
+
+// EncodeTo encodes a RPCReconcileRequest to an encoder. Implements
+// types.EncoderTo.
+func (r *RPCReconcileRequest) EncodeTo(e *types.Encoder) {
+	r.Revision.EncodeTo(e)
+}
+
+// DecodeFrom decodes a RPCReconcileRequest from a decoder. Implements
+// types.DecoderFrom.
+func (r *RPCReconcileRequest) DecodeFrom(d *types.Decoder) {
+	r.Revision.DecodeFrom(d)
+}
+
+// RPCReconcileResponse contains the host's latest revision of a contract,
+// after restoring the renter's revision if necessary.
+type RPCReconcileResponse struct {
+	Revision Contract
+}
+
+// MaxLen returns the maximum encoded length of an object. Implements
+// rpc.Object.
+func (r *RPCReconcileResponse) MaxLen() int {
+	return rpc.DefaultMaxLen
+}
+
+// EncodeTo encodes a RPCReconcileResponse to an encoder. Implements
+// types.EncoderTo.
+func (r *RPCReconcileResponse) EncodeTo(e *types.Encoder) {
+	r.Revision.EncodeTo(e)
+}
+
+// DecodeFrom decodes a RPCReconcileResponse from a decoder. Implements
+// types.DecoderFrom.
+func (r *RPCReconcileResponse) DecodeFrom(d *types.Decoder) {
+	r.Revision.DecodeFrom(d)
+}
+
 // RPCSettingsRegisteredResponse returns the settings ID to the renter to signal
 // success.
 type RPCSettingsRegisteredResponse struct {
@@ -793,7 +856,7 @@ type RPCExecuteProgramRequest struct {
 // MaxLen returns the maximum encoded length of an object. Implements
 // rpc.Object.
 func (req *RPCExecuteProgramRequest) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo encodes a RPCExecuteProgramRequest to an encoder. Implements
@@ -811,7 +874,7 @@ func (req *RPCExecuteProgramRequest) EncodeTo(e *types.Encoder) {
 // types.DecoderFrom.
 func (req *RPCExecuteProgramRequest) DecodeFrom(d *types.Decoder) {
 	req.FileContractID.DecodeFrom(d)
-	req.Instructions = make([]Instruction, d.ReadPrefix())
+	req.Instructions = make([]Instruction, d.ReadSlicePrefix(minInstructionLen))
 	for i := range req.Instructions {
 		req.Instructions[i] = readInstruction(d)
 	}
@@ -1104,7 +1167,7 @@ type RPCExecuteInstrResponse struct {
 // MaxLen returns the maximum length of the encoded object. Implements
 // rpc.Object.
 func (resp *RPCExecuteInstrResponse) MaxLen() int {
-	return defaultMaxLen
+	return rpc.DefaultMaxLen
 }
 
 // EncodeTo encodes a RPCExecuteInstrResponse to an encoder. Implements
@@ -2,20 +2,64 @@
 package rhp
 
 import (
+	"context"
 	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
 
 	"golang.org/x/crypto/blake2b"
-	"lukechampine.com/frand"
 )
 
-const protocolVersion = 1
+// Protocol versions. Peers first exchange a single version byte; from
+// handshakeVersion onwards, this is followed by an rpc.Handshake, which
+// negotiates the version and features used for the rest of the Session.
+// Version 1 renters are still accepted.
+const (
+	legacyProtocolVersion = 1
+	handshakeVersion      = 2
+	protocolVersion       = 2
+)
+
+// protocolMagic identifies the renter-host protocol in an rpc.Handshake.
+var protocolMagic = rpc.NewSpecifier("SiaRHP")
+
+// Optional protocol features, negotiated via rpc.Handshake.
+const (
+	// FeatureCompression indicates that RPC responses are written with
+	// rpc.WriteCompressedResponse; see (*Session).WriteResponse.
+	FeatureCompression uint64 = 1 << iota
+)
+
+func ourHandshake(opts SessionOptions) rpc.Handshake {
+	h := rpc.Handshake{
+		Magic:      protocolMagic,
+		MinVersion: handshakeVersion,
+		Version:    protocolVersion,
+	}
+	if opts.Compression {
+		h.Features |= FeatureCompression
+	}
+	h.MaxObjectLen = uint64(opts.MaxObjectLen)
+	return h
+}
+
+// sessionLimits returns the Limits for a Session, raised to the negotiated
+// maximum object length, if any.
+func sessionLimits(opts SessionOptions, protocol rpc.Handshake) rpc.Limits {
+	l := opts.Limits
+	if n := int(protocol.MaxObjectLen); n > l.Floor {
+		l.Floor = n
+	}
+	return l
+}
 
 // ErrRenterClosed is returned by (*Session).ReadID when the renter sends the
 // session termination signal.
@@ -23,15 +67,131 @@ var ErrRenterClosed = errors.New("renter has terminated session")
 
 func hashChallenge(challenge [16]byte) [32]byte {
 	c := make([]byte, 32)
-	copy(c[:16], "challenge")
+	copy(c[:16], types.DomainRHPChallenge)
 	copy(c[16:], challenge[:])
 	return blake2b.Sum256(c)
 }
 
+// SessionOptions configures a renter-host Session. The zero value of each
+// field selects its default.
+type SessionOptions struct {
+	// Limits is copied to the Session's Limits field.
+	Limits rpc.Limits
+	// HandshakeTimeout bounds the time spent establishing the Session. The
+	// default is one minute.
+	HandshakeTimeout time.Duration
+	// Mux configures the encrypted connection underlying the Session, e.g. how
+	// often it is rekeyed, whether a transcript is recorded, and how often
+	// keepalives are sent. Long-lived Sessions behind NATs should set a short
+	// KeepaliveInterval; setting an IdleTimeout closes the Session (with
+	// mux.ErrPeerTimedOut) if the peer goes silent.
+	Mux mux.Options
+	// Certificate, if set, is the host's current key certificate. It is only
+	// used when dialing a host: the certificate is verified against the host's
+	// identity key, and the host must then authenticate with the certified
+	// operational key. Hosts using an operational key simply accept sessions
+	// with it.
+	Certificate *KeyCertificate
+	// Compression offers FeatureCompression to the peer. Hosts that enable it
+	// must write RPC responses with (*Session).WriteResponse.
+	Compression bool
+	// MaxObjectLen, if set, is advertised to the peer as the largest object
+	// we are willing to read. If both peers advertise a MaxObjectLen, the
+	// Session's Limits are raised to the smaller of the two, allowing objects
+	// (e.g. large transaction sets) to exceed their standard MaxLen.
+	MaxObjectLen int
+	// ReadLimit and WriteLimit, if set, limit the throughput of the Session's
+	// underlying connection. A RateLimiter may be shared by many Sessions.
+	ReadLimit  *rpc.RateLimiter
+	WriteLimit *rpc.RateLimiter
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts SessionOptions) Validate() error {
+	if err := opts.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid limits: %w", err)
+	} else if opts.HandshakeTimeout < 0 {
+		return fmt.Errorf("negative handshake timeout (%v)", opts.HandshakeTimeout)
+	} else if opts.MaxObjectLen < 0 {
+		return fmt.Errorf("negative maximum object length (%v)", opts.MaxObjectLen)
+	} else if err := opts.Mux.Validate(); err != nil {
+		return fmt.Errorf("invalid mux options: %w", err)
+	}
+	return nil
+}
+
+func (opts SessionOptions) withDefaults() SessionOptions {
+	if opts.HandshakeTimeout == 0 {
+		opts.HandshakeTimeout = time.Minute
+	}
+	return opts
+}
+
+// bindHandshakeContext binds the deadline of conn to ctx, bounded by timeout,
+// for the duration of a handshake. The returned function unbinds conn; if the
+// handshake failed due to ctx, it wraps *err with the context error.
+func bindHandshakeContext(ctx context.Context, conn net.Conn, timeout time.Duration) func(err *error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	stop := rpc.WithContext(ctx, conn)
+	return func(err *error) {
+		ctxErr := stop()
+		cancel()
+		if *err != nil && ctxErr != nil {
+			*err = fmt.Errorf("%w (%v)", ctxErr, *err)
+		}
+	}
+}
+
 // A Session is an ongoing exchange of RPCs via the renter-host protocol.
 type Session struct {
 	*mux.Mux
+	// Limits bounds the size of objects read from the peer. Callers should
+	// read RPC objects via Limits (e.g. s.Limits.ReadRequest) rather than the
+	// package-level functions in rpc.
+	Limits rpc.Limits
+	// Protocol is the negotiated protocol version and features. RPC handlers
+	// should consult it before using any version-dependent encoding.
+	Protocol  rpc.Handshake
+	conn      *rpc.MeteredConn
 	challenge [16]byte
+
+	// renter state, set by Lock
+	renterMu sync.Mutex
+	locked   *lockedContract
+}
+
+// WriteResponse writes an RPC response to w, compressing it if the Session
+// negotiated FeatureCompression.
+func (s *Session) WriteResponse(w io.Writer, resp rpc.Object) error {
+	if s.Protocol.HasFeature(FeatureCompression) {
+		return rpc.WriteCompressedResponse(w, resp)
+	}
+	return rpc.WriteResponse(w, resp)
+}
+
+// WriteResponseErr is like WriteResponse, but writes an RPC error.
+func (s *Session) WriteResponseErr(w io.Writer, err error) error {
+	if s.Protocol.HasFeature(FeatureCompression) {
+		return rpc.WriteCompressedResponseErr(w, err)
+	}
+	return rpc.WriteResponseErr(w, err)
+}
+
+// ReadResponse reads an RPC response written by WriteResponse, subject to
+// s.Limits. If the response is an error, it is returned directly.
+func (s *Session) ReadResponse(r io.Reader, resp rpc.Object) error {
+	if s.Protocol.HasFeature(FeatureCompression) {
+		return s.Limits.ReadCompressedResponse(r, resp)
+	}
+	return s.Limits.ReadResponse(r, resp)
+}
+
+// Bandwidth returns the number of bytes read from and written to the
+// Session's underlying connection, including handshake, framing, and
+// encryption overhead. To meter an individual RPC, wrap its stream with
+// rpc.NewMeteredConn.
+func (s *Session) Bandwidth() (ingress, egress uint64) {
+	return s.conn.Bandwidth()
 }
 
 // SetChallenge sets the current session challenge. Challenges allow the host to
@@ -53,8 +213,28 @@ func (s *Session) VerifyChallenge(sig types.Signature, pub types.PublicKey) bool
 
 // AcceptSession conducts the host's half of the renter-host protocol handshake,
 // returning a Session that can be used to handle RPC requests.
-func AcceptSession(conn net.Conn, priv types.PrivateKey) (_ *Session, err error) {
-	m, err := mux.Accept(conn, ed25519.PrivateKey(priv))
+func AcceptSession(conn net.Conn, priv types.PrivateKey) (*Session, error) {
+	return AcceptSessionWithOptions(conn, priv, SessionOptions{})
+}
+
+// AcceptSessionWithOptions is like AcceptSession, but configures the Session
+// with the provided options.
+func AcceptSessionWithOptions(conn net.Conn, priv types.PrivateKey, opts SessionOptions) (*Session, error) {
+	return AcceptSessionContext(context.Background(), conn, priv, opts)
+}
+
+// AcceptSessionContext is like AcceptSessionWithOptions, but aborts the
+// handshake if ctx is canceled or its deadline passes. The HandshakeTimeout
+// still applies.
+func AcceptSessionContext(ctx context.Context, conn net.Conn, priv types.PrivateKey, opts SessionOptions) (_ *Session, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+	defer bindHandshakeContext(ctx, conn, opts.HandshakeTimeout)(&err)
+
+	mc := rpc.NewMeteredConn(conn, opts.ReadLimit, opts.WriteLimit)
+	m, err := mux.AcceptWithOptions(mc, ed25519.PrivateKey(priv), opts.Mux)
 	if err != nil {
 		return nil, err
 	}
@@ -72,25 +252,67 @@ func AcceptSession(conn net.Conn, priv types.PrivateKey) (_ *Session, err error)
 	var buf [1]byte
 	if _, err := s.Read(buf[:]); err != nil {
 		return nil, fmt.Errorf("could not read peer version: %w", err)
-	} else if _, err := s.Write([]byte{protocolVersion}); err != nil {
+	}
+	version := buf[0]
+	if version > protocolVersion {
+		version = protocolVersion
+	}
+	if _, err := s.Write([]byte{version}); err != nil {
 		return nil, fmt.Errorf("could not write our version: %w", err)
-	} else if version := buf[0]; version != protocolVersion {
+	} else if version < legacyProtocolVersion {
 		return nil, fmt.Errorf("incompatible versions (ours = %v, theirs = %v)", protocolVersion, version)
 	}
-	challenge := frand.Entropy128()
+	protocol := rpc.Handshake{Magic: protocolMagic, MinVersion: version, Version: version}
+	if version >= handshakeVersion {
+		if protocol, err = rpc.AcceptHandshake(s, ourHandshake(opts)); err != nil {
+			return nil, fmt.Errorf("protocol handshake failed: %w", err)
+		}
+	}
+	challenge := types.Entropy128()
 	if _, err := s.Write(challenge[:]); err != nil {
 		return nil, fmt.Errorf("couldn't write challenge: %w", err)
 	}
 	return &Session{
 		Mux:       m,
+		Limits:    sessionLimits(opts, protocol),
+		Protocol:  protocol,
+		conn:      mc,
 		challenge: challenge,
 	}, nil
 }
 
 // DialSession conducts the renter's half of the renter-host protocol handshake,
-// returning a Session that can be used to make RPC requests.
-func DialSession(conn net.Conn, pub types.PublicKey) (_ *Session, err error) {
-	m, err := mux.Dial(conn, pub[:])
+// returning a Session that can be used to make RPC requests. pub is the host's
+// identity key; see SessionOptions.Certificate for hosts that authenticate with
+// a separate operational key.
+func DialSession(conn net.Conn, pub types.PublicKey) (*Session, error) {
+	return DialSessionWithOptions(conn, pub, SessionOptions{})
+}
+
+// DialSessionWithOptions is like DialSession, but configures the Session with
+// the provided options.
+func DialSessionWithOptions(conn net.Conn, pub types.PublicKey, opts SessionOptions) (*Session, error) {
+	return DialSessionContext(context.Background(), conn, pub, opts)
+}
+
+// DialSessionContext is like DialSessionWithOptions, but aborts the handshake
+// if ctx is canceled or its deadline passes. The HandshakeTimeout still
+// applies.
+func DialSessionContext(ctx context.Context, conn net.Conn, pub types.PublicKey, opts SessionOptions) (_ *Session, err error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+	if opts.Certificate != nil {
+		if err := opts.Certificate.Verify(pub, time.Now()); err != nil {
+			return nil, fmt.Errorf("invalid host key certificate: %w", err)
+		}
+		pub = opts.Certificate.OperationalKey
+	}
+	defer bindHandshakeContext(ctx, conn, opts.HandshakeTimeout)(&err)
+
+	mc := rpc.NewMeteredConn(conn, opts.ReadLimit, opts.WriteLimit)
+	m, err := mux.DialWithOptions(mc, pub[:], opts.Mux)
 	if err != nil {
 		return nil, err
 	}
@@ -110,9 +332,13 @@ func DialSession(conn net.Conn, pub types.PublicKey) (_ *Session, err error) {
 		return nil, fmt.Errorf("could not write our version: %w", err)
 	} else if _, err := s.Read(buf[:]); err != nil {
 		return nil, fmt.Errorf("could not read peer version: %w", err)
-	} else if version := buf[0]; version != protocolVersion {
+	} else if version := buf[0]; version < handshakeVersion || version > protocolVersion {
 		return nil, fmt.Errorf("incompatible versions (ours = %v, theirs = %v)", protocolVersion, version)
 	}
+	protocol, err := rpc.InitiateHandshake(s, ourHandshake(opts))
+	if err != nil {
+		return nil, fmt.Errorf("protocol handshake failed: %w", err)
+	}
 	var challenge [16]byte
 	if _, err := io.ReadFull(s, challenge[:]); err != nil {
 		return nil, fmt.Errorf("couldn't read host challenge: %w", err)
@@ -121,6 +347,9 @@ func DialSession(conn net.Conn, pub types.PublicKey) (_ *Session, err error) {
 	}
 	return &Session{
 		Mux:       m,
+		Limits:    sessionLimits(opts, protocol),
+		Protocol:  protocol,
+		conn:      mc,
 		challenge: challenge,
 	}, nil
 }
@@ -2,16 +2,19 @@ package rhp
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"math"
 	"math/rand"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
 
+	"go.sia.tech/core/net/mux"
 	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
 
@@ -111,6 +114,9 @@ func TestSession(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer sess.Close()
+	if sess.Protocol.Version != protocolVersion || sess.Protocol.Magic != protocolMagic {
+		t.Fatal("wrong negotiated protocol:", sess.Protocol)
+	}
 	stream, err := sess.DialStream()
 	if err != nil {
 		t.Fatal(err)
@@ -125,6 +131,210 @@ func TestSession(t *testing.T) {
 	if err := <-peerErr; err != nil {
 		t.Fatal(err)
 	}
+	if in, out := sess.Bandwidth(); in == 0 || out < uint64(len(sig)) {
+		t.Fatalf("implausible bandwidth: %v in, %v out", in, out)
+	}
+}
+
+func TestSessionLegacyVersion(t *testing.T) {
+	hostPrivKey := types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	protocolCh := make(chan rpc.Handshake, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sess, err := AcceptSession(conn, hostPrivKey)
+		if err != nil {
+			close(protocolCh)
+			return
+		}
+		defer sess.Close()
+		protocolCh <- sess.Protocol
+	}()
+
+	// mimic a version 1 renter, which exchanges only a version byte
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pub := hostPrivKey.PublicKey()
+	m, err := mux.Dial(conn, pub[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var buf [1 + 16]byte
+	if _, err := s.Write([]byte{legacyProtocolVersion}); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(s, buf[:]); err != nil {
+		t.Fatal(err)
+	} else if buf[0] != legacyProtocolVersion {
+		t.Fatal("host did not downgrade to legacy version:", buf[0])
+	} else if p, ok := <-protocolCh; !ok {
+		t.Fatal("host rejected legacy renter")
+	} else if p.Version != legacyProtocolVersion || p.Features != 0 {
+		t.Fatal("wrong negotiated protocol:", p)
+	}
+}
+
+func TestDialSessionContext(t *testing.T) {
+	// a stalled host: accept connections, but never complete the handshake
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := DialSessionContext(ctx, conn, types.GeneratePrivateKey().PublicKey(), SessionOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected context.DeadlineExceeded, got", err)
+	} else if time.Since(start) > 5*time.Second {
+		t.Fatal("handshake was not aborted promptly")
+	}
+}
+
+func TestSessionMaxObjectLen(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, test := range []struct {
+		host, renter, exp int
+	}{
+		{0, 0, 0},
+		{4e6, 0, 0},
+		{4e6, 2e6, 2e6},
+	} {
+		hostFloor := make(chan int, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				hostFloor <- -1
+				return
+			}
+			defer conn.Close()
+			sess, err := AcceptSessionWithOptions(conn, hostKey, SessionOptions{MaxObjectLen: test.host})
+			if err != nil {
+				hostFloor <- -1
+				return
+			}
+			defer sess.Close()
+			hostFloor <- sess.Limits.Floor
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sess, err := DialSessionWithOptions(conn, hostKey.PublicKey(), SessionOptions{MaxObjectLen: test.renter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sess.Limits.Floor != test.exp {
+			t.Fatalf("expected renter floor %v, got %v", test.exp, sess.Limits.Floor)
+		} else if floor := <-hostFloor; floor != test.exp {
+			t.Fatalf("expected host floor %v, got %v", test.exp, floor)
+		} else if test.exp != 0 && sess.Limits.MaxLen(new(rpc.Handshake)) != test.exp {
+			t.Fatal("floor was not applied to limits")
+		}
+		sess.Close()
+		conn.Close()
+	}
+}
+
+func TestSessionCompression(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	settings := HostSettings{AcceptingContracts: true, Version: "1.0.0", NetAddress: strings.Repeat("a", 200) + ".com:9982", SectorSize: SectorSize}
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for _, test := range []struct {
+		host, renter bool
+	}{
+		{true, true},
+		{true, false},
+		{false, true},
+	} {
+		hostErr := make(chan error, 1)
+		go func() {
+			hostErr <- func() error {
+				conn, err := l.Accept()
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				sess, err := AcceptSessionWithOptions(conn, hostKey, SessionOptions{Compression: test.host})
+				if err != nil {
+					return err
+				}
+				defer sess.Close()
+				stream, err := sess.AcceptStream()
+				if err != nil {
+					return err
+				}
+				defer stream.Close()
+				if _, err := rpc.ReadID(stream); err != nil {
+					return err
+				}
+				return sess.WriteResponse(stream, &settings)
+			}()
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sess, err := DialSessionWithOptions(conn, hostKey.PublicKey(), SessionOptions{Compression: test.renter})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sess.Protocol.HasFeature(FeatureCompression) != (test.host && test.renter) {
+			t.Fatal("compression was negotiated incorrectly")
+		} else if got, err := sess.Settings(); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(got, settings) {
+			t.Fatal("wrong settings", got)
+		} else if err := <-hostErr; err != nil {
+			t.Fatal(err)
+		}
+		sess.Close()
+		conn.Close()
+	}
 }
 
 func TestChallenge(t *testing.T) {
@@ -136,6 +346,12 @@ func TestChallenge(t *testing.T) {
 	if !s.VerifyChallenge(sig, pubkey) {
 		t.Fatal("challenge was not signed/verified correctly")
 	}
+
+	// check against test vector
+	challenge := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	if h := hashChallenge(challenge); types.Hash256(h).String() != "h:535bce2156ab8cc0c24eebdeb8d3eaad76113e0931377a50e7909a16512f257f" {
+		t.Fatal("challenge hash does not match test vector:", types.Hash256(h))
+	}
 }
 
 func TestEncoding(t *testing.T) {
@@ -213,7 +429,14 @@ func TestEncoding(t *testing.T) {
 			Signature:   randSignature(),
 		},
 		&RPCSettingsResponse{
-			Settings: frand.Bytes(128),
+			Settings: HostSettings{
+				NetAddress:                "host.example.com:9982",
+				Version:                   "1.0.0",
+				SectorSize:                SectorSize,
+				StoragePrice:              types.NewCurrency64(frand.Uint64n(math.MaxUint64)),
+				InstrUpdateSectorBaseCost: types.NewCurrency64(frand.Uint64n(math.MaxUint64)),
+				Mode:                      HostModeReadOnly,
+			},
 		},
 		&RPCWriteRequest{
 			Actions:           []RPCWriteAction{{Data: frand.Bytes(8)}},
@@ -268,6 +491,32 @@ func TestEncoding(t *testing.T) {
 				MissedHostValue: types.NewCurrency64(frand.Uint64n(math.MaxUint64)),
 			},
 		},
+		&RPCReconcileRequest{
+			Revision: Contract{
+				ID:       types.ElementID{Source: types.Hash256(randPubKey()), Index: frand.Uint64n(100)},
+				Revision: randomTxn.FileContracts[0],
+			},
+		},
+		&RPCReconcileResponse{
+			Revision: Contract{
+				ID:       types.ElementID{Source: types.Hash256(randPubKey()), Index: frand.Uint64n(100)},
+				Revision: randomTxn.FileContracts[0],
+			},
+		},
+		&RPCRegistryReadRequest{
+			PublicKey: randPubKey(),
+			Tweak:     types.Hash256(randPubKey()),
+		},
+		&RPCRegistryUpdateRequest{
+			Value: RegistryValue{
+				Tweak:     types.Hash256(randPubKey()),
+				Data:      frand.Bytes(MaxValueDataSize),
+				Revision:  frand.Uint64n(100),
+				Type:      EntryTypeArbitrary,
+				PublicKey: randPubKey(),
+				Signature: randSignature(),
+			},
+		},
 	}
 	for _, o := range objs {
 		var b bytes.Buffer
@@ -284,3 +533,90 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestSettingsNotification(t *testing.T) {
+	hostPrivKey := types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	notifications := []SettingsNotification{
+		{
+			Type:        NotifySettingsChange,
+			EffectiveAt: time.Unix(1234567890, 0),
+			Settings:    HostSettings{StoragePrice: types.Siacoins(1), Version: "1.0.0"},
+		},
+		{
+			Type:        NotifyMaintenance,
+			EffectiveAt: time.Unix(1234567900, 0),
+			Reason:      "disk replacement",
+		},
+	}
+	done := make(chan struct{})
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sess, err := AcceptSession(conn, hostPrivKey)
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+
+			// streams other than notifications should be ignored
+			stream, err := sess.DialStream()
+			if err != nil {
+				return err
+			} else if err := rpc.WriteRequest(stream, RPCSettingsID, nil); err != nil {
+				return err
+			}
+			stream.Close()
+			for _, n := range notifications {
+				if err := sess.Notify(n); err != nil {
+					return err
+				}
+			}
+			<-done
+			return nil
+		}()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := DialSession(conn, hostPrivKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan SettingsNotification)
+	handleErr := make(chan error, 1)
+	go func() {
+		handleErr <- sess.HandleNotifications(func(n SettingsNotification) { received <- n })
+	}()
+	for _, exp := range notifications {
+		select {
+		case n := <-received:
+			if !deepEqual(&n, &exp) {
+				t.Fatalf("wrong notification: expected %+v, got %+v", exp, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for notification")
+		}
+	}
+	close(done)
+	if err := <-peerErr; err != nil {
+		t.Fatal(err)
+	}
+	sess.Close()
+	if err := <-handleErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,8 +1,12 @@
 package rhp
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"go.sia.tech/core/net/rpc"
 	"go.sia.tech/core/types"
 )
 
@@ -25,6 +29,9 @@ func (id *SettingsID) DecodeFrom(d *types.Decoder) {
 	d.Read(id[:])
 }
 
+// MaxVersionLen is the maximum length of the Version field of HostSettings.
+const MaxVersionLen = 32
+
 // HostSettings are the settings and prices used when interacting with a host.
 type HostSettings struct {
 	AcceptingContracts         bool           `json:"acceptingContracts"`
@@ -86,6 +93,9 @@ type HostSettings struct {
 	InstrUpdateRegistryBaseCost types.Currency `json:"instrUpdateRegistryBaseCost"`
 	InstrUpdateSectorBaseCost   types.Currency `json:"instrUpdateSectorBaseCost"`
 	InstrWriteBaseCost          types.Currency `json:"instrWriteBaseCost"`
+
+	// Mode indicates which RPCs the host is currently serving; see HostMode.
+	Mode HostMode `json:"mode,omitempty"`
 }
 
 // EncodeTo encodes host settings to the encoder; implements types.EncoderTo.
@@ -127,6 +137,19 @@ func (p *HostSettings) EncodeTo(e *types.Encoder) {
 	p.InstrSwapSectorBaseCost.EncodeTo(e)
 	p.InstrRevisionBaseCost.EncodeTo(e)
 	p.InstrWriteBaseCost.EncodeTo(e)
+	p.InstrAppendSectorBaseCost.EncodeTo(e)
+	p.InstrReadRegistryBaseCost.EncodeTo(e)
+	p.InstrSectorRootsBaseCost.EncodeTo(e)
+	p.InstrUpdateRegistryBaseCost.EncodeTo(e)
+	p.InstrUpdateSectorBaseCost.EncodeTo(e)
+	e.WriteUint8(uint8(p.Mode))
+}
+
+// readBoundedString reads a string of at most maxLen bytes.
+func readBoundedString(d *types.Decoder, maxLen int) string {
+	b := make([]byte, d.ReadPrefixMax(maxLen))
+	d.Read(b)
+	return string(b)
 }
 
 // DecodeFrom decodes host settings from the decoder; implements types.DecoderFrom.
@@ -138,14 +161,14 @@ func (p *HostSettings) DecodeFrom(d *types.Decoder) {
 	p.MaxCollateral.DecodeFrom(d)
 	p.MaxDuration = d.ReadUint64()
 	p.MaxEphemeralAccountBalance.DecodeFrom(d)
-	p.NetAddress = d.ReadString()
+	p.NetAddress = readBoundedString(d, MaxNetAddressLen)
 	p.RemainingStorage = d.ReadUint64()
 	p.TotalStorage = d.ReadUint64()
 	p.RemainingRegistryEntries = d.ReadUint64()
 	p.TotalRegistryEntries = d.ReadUint64()
 	p.SectorSize = d.ReadUint64()
 	p.Address.DecodeFrom(d)
-	p.Version = d.ReadString()
+	p.Version = readBoundedString(d, MaxVersionLen)
 	p.WindowSize = d.ReadUint64()
 	p.ContractFee.DecodeFrom(d)
 	p.Collateral.DecodeFrom(d)
@@ -168,11 +191,70 @@ func (p *HostSettings) DecodeFrom(d *types.Decoder) {
 	p.InstrSwapSectorBaseCost.DecodeFrom(d)
 	p.InstrRevisionBaseCost.DecodeFrom(d)
 	p.InstrWriteBaseCost.DecodeFrom(d)
+	p.InstrAppendSectorBaseCost.DecodeFrom(d)
+	p.InstrReadRegistryBaseCost.DecodeFrom(d)
+	p.InstrSectorRootsBaseCost.DecodeFrom(d)
+	p.InstrUpdateRegistryBaseCost.DecodeFrom(d)
+	p.InstrUpdateSectorBaseCost.DecodeFrom(d)
+	p.Mode = HostMode(d.ReadUint8())
 }
 
 // MaxLen implements rpc.Object.
 func (p *HostSettings) MaxLen() int {
-	// UUID + bool + 25 types.Currency fields + 9 uint64 fields + version string + netaddress string
-	// netaddress maximum is based on RFC 1035 https://www.freesoft.org/CIE/RFC/1035/9.htm.
-	return 16 + 1 + (25 * 16) + (9 * 8) + 10 + 256
+	// bool + time + 9 uint64 fields + 28 types.Currency fields + address +
+	// netaddress string + version string + mode
+	return 1 + 8 + (9 * 8) + (28 * 16) + 32 + (8 + MaxNetAddressLen) + (8 + MaxVersionLen) + 1
+}
+
+// Validate returns an error if the settings are malformed. It does not judge
+// whether the host's prices are reasonable.
+func (p *HostSettings) Validate() error {
+	switch {
+	case p.Version == "":
+		return errors.New("missing version")
+	case len(p.Version) > MaxVersionLen:
+		return fmt.Errorf("version is too long (%v > %v bytes)", len(p.Version), MaxVersionLen)
+	case p.SectorSize != SectorSize:
+		return fmt.Errorf("unsupported sector size (%v)", p.SectorSize)
+	case p.RemainingStorage > p.TotalStorage:
+		return fmt.Errorf("remaining storage exceeds total storage (%v > %v)", p.RemainingStorage, p.TotalStorage)
+	case p.RemainingRegistryEntries > p.TotalRegistryEntries:
+		return fmt.Errorf("remaining registry entries exceed total registry entries (%v > %v)", p.RemainingRegistryEntries, p.TotalRegistryEntries)
+	case p.Mode > HostModeDraining:
+		return fmt.Errorf("unknown host mode (%v)", uint8(p.Mode))
+	}
+	if p.NetAddress != "" {
+		if err := ValidateNetAddress(p.NetAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Settings requests the host's current settings via the Settings RPC.
+func (s *Session) Settings() (HostSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.SettingsContext(ctx)
+}
+
+// SettingsContext is like Settings, but aborts the RPC if ctx is canceled or
+// its deadline passes.
+func (s *Session) SettingsContext(ctx context.Context) (HostSettings, error) {
+	stream, err := s.DialStream()
+	if err != nil {
+		return HostSettings{}, err
+	}
+	defer stream.Close()
+	var settings HostSettings
+	c := rpc.Client{
+		Limits:              s.Limits,
+		CompressedResponses: s.Protocol.HasFeature(FeatureCompression),
+	}
+	if err := c.CallContext(ctx, stream, RPCSettingsID, nil, &settings); err != nil {
+		return HostSettings{}, err
+	} else if err := settings.Validate(); err != nil {
+		return HostSettings{}, fmt.Errorf("host sent invalid settings: %w", err)
+	}
+	return settings, nil
 }
@@ -0,0 +1,70 @@
+package rhp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestHostSettingsValidate(t *testing.T) {
+	valid := HostSettings{
+		NetAddress:       "host.example.com:9982",
+		Version:          "1.0.0",
+		SectorSize:       SectorSize,
+		TotalStorage:     100,
+		RemainingStorage: 50,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []func(*HostSettings){
+		func(s *HostSettings) { s.Version = "" },
+		func(s *HostSettings) { s.Version = strings.Repeat("1", MaxVersionLen+1) },
+		func(s *HostSettings) { s.SectorSize = 1 << 20 },
+		func(s *HostSettings) { s.RemainingStorage = 101 },
+		func(s *HostSettings) { s.RemainingRegistryEntries = 1 },
+		func(s *HostSettings) { s.Mode = HostModeDraining + 1 },
+		func(s *HostSettings) { s.NetAddress = "no-port" },
+	} {
+		s := valid
+		fn(&s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected invalid settings to be rejected: %+v", s)
+		}
+	}
+
+	// every field should be encoded, within MaxLen
+	s := valid
+	s.NetAddress = strings.Repeat("a", MaxNetAddressLen-5) + ":9982"
+	s.Version = strings.Repeat("1", MaxVersionLen)
+	s.InstrUpdateSectorBaseCost = types.Siacoins(1)
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	s.EncodeTo(e)
+	e.Flush()
+	if buf.Len() > s.MaxLen() {
+		t.Fatalf("encoded settings exceed MaxLen (%v > %v)", buf.Len(), s.MaxLen())
+	}
+	decode := func(b []byte) (s HostSettings, err error) {
+		d := types.NewBufDecoder(b)
+		s.DecodeFrom(d)
+		return s, d.Err()
+	}
+	if decoded, err := decode(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	} else if decoded.InstrUpdateSectorBaseCost != s.InstrUpdateSectorBaseCost || decoded.NetAddress != s.NetAddress {
+		t.Fatal("settings did not survive round trip")
+	}
+
+	// oversized strings are rejected while decoding
+	buf.Reset()
+	s.Version = strings.Repeat("1", MaxVersionLen+1)
+	e = types.NewEncoder(&buf)
+	s.EncodeTo(e)
+	e.Flush()
+	if _, err := decode(buf.Bytes()); err == nil {
+		t.Fatal("expected oversized version to be rejected")
+	}
+}
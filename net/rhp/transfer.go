@@ -0,0 +1,232 @@
+package rhp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// MaxTransferSectors is the maximum number of sectors that a single
+// TransferAuthorization may cover.
+const MaxTransferSectors = 1 << 14 // 64 GiB
+
+// RPCTransferSectorsID is the ID of the TransferSectors RPC, which a
+// destination host calls on a source host to download sectors on behalf of a
+// renter, e.g. when migrating or repairing a contract's data.
+var RPCTransferSectorsID = rpc.NewSpecifier("TransferSectors")
+
+// Errors returned when validating a transfer.
+var (
+	ErrTransferExpired       = errors.New("transfer authorization has expired")
+	ErrTransferWrongHost     = errors.New("transfer authorization is for a different host")
+	ErrTransferInvalidSig    = errors.New("transfer authorization has an invalid signature")
+	ErrTransferSectorMissing = errors.New("transfer authorization includes a sector not stored by the contract")
+	ErrTransferRootMismatch  = errors.New("transferred sector does not match its authorized root")
+)
+
+// A TransferAuthorization permits a destination host to download specific
+// sectors of a renter's contract directly from the contract's host, sparing
+// the renter from relaying the data itself. It is signed by the renter's
+// contract key, and is valid only until ExpirationHeight.
+type TransferAuthorization struct {
+	ContractID       types.ElementID
+	SourceHost       types.PublicKey
+	DestinationHost  types.PublicKey
+	Roots            []types.Hash256
+	ExpirationHeight uint64
+	Signature        types.Signature
+}
+
+// SigHash returns the hash of the authorization that is signed by the renter.
+func (ta *TransferAuthorization) SigHash() types.Hash256 {
+	h := types.NewHasher()
+	h.E.WriteString(types.DomainRHPTransfer)
+	ta.ContractID.EncodeTo(h.E)
+	ta.SourceHost.EncodeTo(h.E)
+	ta.DestinationHost.EncodeTo(h.E)
+	h.E.WritePrefix(len(ta.Roots))
+	for _, root := range ta.Roots {
+		root.EncodeTo(h.E)
+	}
+	h.E.WriteUint64(ta.ExpirationHeight)
+	return h.Sum()
+}
+
+// EncodeTo implements types.EncoderTo.
+func (ta *TransferAuthorization) EncodeTo(e *types.Encoder) {
+	ta.ContractID.EncodeTo(e)
+	ta.SourceHost.EncodeTo(e)
+	ta.DestinationHost.EncodeTo(e)
+	e.WritePrefix(len(ta.Roots))
+	for _, root := range ta.Roots {
+		root.EncodeTo(e)
+	}
+	e.WriteUint64(ta.ExpirationHeight)
+	ta.Signature.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (ta *TransferAuthorization) DecodeFrom(d *types.Decoder) {
+	ta.ContractID.DecodeFrom(d)
+	ta.SourceHost.DecodeFrom(d)
+	ta.DestinationHost.DecodeFrom(d)
+	ta.Roots = make([]types.Hash256, d.ReadPrefixMax(MaxTransferSectors))
+	for i := range ta.Roots {
+		ta.Roots[i].DecodeFrom(d)
+	}
+	ta.ExpirationHeight = d.ReadUint64()
+	ta.Signature.DecodeFrom(d)
+}
+
+// MaxLen implements rpc.Object.
+func (ta *TransferAuthorization) MaxLen() int {
+	return 40 + 32 + 32 + 8 + MaxTransferSectors*32 + 8 + 64
+}
+
+// ValidateTransferAuthorization is called by the source host to validate a
+// transfer authorization against the contract it refers to.
+func ValidateTransferAuthorization(ta TransferAuthorization, fc types.FileContract, currentHeight uint64) error {
+	switch {
+	case ta.SourceHost != fc.HostPublicKey:
+		return ErrTransferWrongHost
+	case currentHeight >= ta.ExpirationHeight:
+		return ErrTransferExpired
+	case len(ta.Roots) == 0:
+		return errors.New("transfer authorization does not include any sectors")
+	case len(ta.Roots) > MaxTransferSectors:
+		return fmt.Errorf("transfer authorization includes too many sectors (%v > %v)", len(ta.Roots), MaxTransferSectors)
+	case !fc.RenterPublicKey.VerifyHash(ta.SigHash(), ta.Signature):
+		return ErrTransferInvalidSig
+	}
+	return nil
+}
+
+// ValidateTransferRoots is called by the source host to check that every
+// sector of a transfer authorization is stored by the contract.
+func ValidateTransferRoots(ta TransferAuthorization, contractRoots []types.Hash256) error {
+	stored := make(map[types.Hash256]struct{}, len(contractRoots))
+	for _, root := range contractRoots {
+		stored[root] = struct{}{}
+	}
+	for _, root := range ta.Roots {
+		if _, ok := stored[root]; !ok {
+			return fmt.Errorf("%w: %v", ErrTransferSectorMissing, root)
+		}
+	}
+	return nil
+}
+
+// RPCTransferSectorsRequest contains the request parameters for the
+// TransferSectors RPC. DestinationSignature is the destination host's
+// signature of the session challenge, proving that it is the host named in the
+// authorization.
+type RPCTransferSectorsRequest struct {
+	Authorization        TransferAuthorization
+	DestinationSignature types.Signature
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCTransferSectorsRequest) EncodeTo(e *types.Encoder) {
+	r.Authorization.EncodeTo(e)
+	r.DestinationSignature.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCTransferSectorsRequest) DecodeFrom(d *types.Decoder) {
+	r.Authorization.DecodeFrom(d)
+	r.DestinationSignature.DecodeFrom(d)
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCTransferSectorsRequest) MaxLen() int {
+	return r.Authorization.MaxLen() + 64
+}
+
+// RPCTransferSectorResponse precedes each sector sent by the source host in
+// the TransferSectors RPC. The sector data follows it on the stream.
+type RPCTransferSectorResponse struct {
+	Root types.Hash256
+}
+
+// EncodeTo implements types.EncoderTo.
+func (r *RPCTransferSectorResponse) EncodeTo(e *types.Encoder) {
+	r.Root.EncodeTo(e)
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (r *RPCTransferSectorResponse) DecodeFrom(d *types.Decoder) {
+	r.Root.DecodeFrom(d)
+}
+
+// MaxLen implements rpc.Object.
+func (r *RPCTransferSectorResponse) MaxLen() int {
+	return 32
+}
+
+// ReadTransferRequest is called by the source host, after reading the
+// TransferSectors RPC ID, to read the request and verify that it was sent by
+// the authorized destination host. The caller must also validate the
+// authorization with ValidateTransferAuthorization and ValidateTransferRoots
+// before sending any sectors with WriteTransferredSector.
+func (s *Session) ReadTransferRequest(r io.Reader) (TransferAuthorization, error) {
+	var req RPCTransferSectorsRequest
+	if err := s.Limits.ReadRequest(r, &req); err != nil {
+		return TransferAuthorization{}, err
+	} else if !s.VerifyChallenge(req.DestinationSignature, req.Authorization.DestinationHost) {
+		return TransferAuthorization{}, ErrTransferWrongHost
+	}
+	return req.Authorization, nil
+}
+
+// WriteTransferredSector is called by the source host to send one sector of a
+// transfer. Sectors must be sent in the order of the authorization's roots.
+func WriteTransferredSector(w io.Writer, root types.Hash256, sector *[SectorSize]byte) error {
+	if err := rpc.WriteResponse(w, &RPCTransferSectorResponse{Root: root}); err != nil {
+		return err
+	}
+	_, err := w.Write(sector[:])
+	return err
+}
+
+// TransferSectors is called by the destination host to download the sectors
+// of a transfer authorization from the source host. Each sector is verified
+// against its root before fn is called with it; if fn returns an error, the
+// transfer is aborted.
+func (s *Session) TransferSectors(ta TransferAuthorization, hostKey types.PrivateKey, fn func(root types.Hash256, sector *[SectorSize]byte) error) error {
+	stream, err := s.DialStream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(time.Minute))
+
+	req := &RPCTransferSectorsRequest{
+		Authorization:        ta,
+		DestinationSignature: s.SignChallenge(hostKey),
+	}
+	if err := rpc.WriteRequest(stream, RPCTransferSectorsID, req); err != nil {
+		return err
+	}
+	for _, root := range ta.Roots {
+		var resp RPCTransferSectorResponse
+		if err := s.Limits.ReadResponse(stream, &resp); err != nil {
+			return err
+		} else if resp.Root != root {
+			return fmt.Errorf("%w: expected %v, got %v", ErrTransferRootMismatch, root, resp.Root)
+		}
+		actual, sector, err := ReadSector(stream)
+		if err != nil {
+			return err
+		} else if actual != root {
+			return fmt.Errorf("%w: %v", ErrTransferRootMismatch, root)
+		} else if err := fn(root, sector); err != nil {
+			return err
+		}
+		stream.SetDeadline(time.Now().Add(time.Minute))
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package rhp
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+
+	"lukechampine.com/frand"
+)
+
+func TestValidateTransferAuthorization(t *testing.T) {
+	renterKey := types.GeneratePrivateKey()
+	sourceKey := types.GeneratePrivateKey()
+	fc := types.FileContract{
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   sourceKey.PublicKey(),
+	}
+	roots := []types.Hash256{frand.Entropy256(), frand.Entropy256()}
+	sign := func(ta TransferAuthorization) TransferAuthorization {
+		ta.Signature = renterKey.SignHash(ta.SigHash())
+		return ta
+	}
+	ta := sign(TransferAuthorization{
+		SourceHost:       fc.HostPublicKey,
+		DestinationHost:  types.GeneratePrivateKey().PublicKey(),
+		Roots:            roots[:1],
+		ExpirationHeight: 10,
+	})
+	if err := ValidateTransferAuthorization(ta, fc, 5); err != nil {
+		t.Fatal(err)
+	} else if err := ValidateTransferRoots(ta, roots); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc   string
+		modify func(*TransferAuthorization)
+		height uint64
+		err    error
+	}{
+		{"expired", func(ta *TransferAuthorization) {}, 10, ErrTransferExpired},
+		{"wrong source", func(ta *TransferAuthorization) { ta.SourceHost = ta.DestinationHost }, 5, ErrTransferWrongHost},
+		{"tampered", func(ta *TransferAuthorization) { ta.Roots = roots }, 5, ErrTransferInvalidSig},
+	}
+	for _, test := range tests {
+		bad := ta
+		test.modify(&bad)
+		if err := ValidateTransferAuthorization(bad, fc, test.height); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.desc, test.err, err)
+		}
+	}
+	if err := ValidateTransferRoots(ta, roots[1:]); !errors.Is(err, ErrTransferSectorMissing) {
+		t.Fatalf("expected %v, got %v", ErrTransferSectorMissing, err)
+	}
+}
+
+func TestTransferSectors(t *testing.T) {
+	renterKey := types.GeneratePrivateKey()
+	sourceKey := types.GeneratePrivateKey()
+	destKey := types.GeneratePrivateKey()
+
+	sectors := make([]*[SectorSize]byte, 3)
+	roots := make([]types.Hash256, len(sectors))
+	for i := range sectors {
+		sectors[i] = new([SectorSize]byte)
+		frand.Read(sectors[i][:256])
+		roots[i] = SectorRoot(sectors[i])
+	}
+	fc := types.FileContract{
+		RenterPublicKey: renterKey.PublicKey(),
+		HostPublicKey:   sourceKey.PublicKey(),
+	}
+	ta := TransferAuthorization{
+		SourceHost:       sourceKey.PublicKey(),
+		DestinationHost:  destKey.PublicKey(),
+		Roots:            roots[1:],
+		ExpirationHeight: 10,
+	}
+	ta.Signature = renterKey.SignHash(ta.SigHash())
+
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	peerErr := make(chan error, 1)
+	go func() {
+		peerErr <- func() error {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			sess, err := AcceptSession(conn, sourceKey)
+			if err != nil {
+				return err
+			}
+			defer sess.Close()
+
+			stream, err := sess.AcceptStream()
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			if id, err := rpc.ReadID(stream); err != nil {
+				return err
+			} else if id != RPCTransferSectorsID {
+				return errors.New("wrong RPC ID")
+			}
+			ta, err := sess.ReadTransferRequest(stream)
+			if err != nil {
+				return err
+			} else if err := ValidateTransferAuthorization(ta, fc, 5); err != nil {
+				return err
+			} else if err := ValidateTransferRoots(ta, roots); err != nil {
+				return err
+			}
+			for _, root := range ta.Roots {
+				for i := range roots {
+					if roots[i] == root {
+						if err := WriteTransferredSector(stream, root, sectors[i]); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			return nil
+		}()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sess, err := DialSession(conn, sourceKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	var received []types.Hash256
+	err = sess.TransferSectors(ta, destKey, func(root types.Hash256, sector *[SectorSize]byte) error {
+		received = append(received, root)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := <-peerErr; err != nil {
+		t.Fatal(err)
+	} else if len(received) != 2 || received[0] != roots[1] || received[1] != roots[2] {
+		t.Fatal("wrong sectors received", received)
+	}
+}
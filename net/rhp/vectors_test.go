@@ -0,0 +1,342 @@
+package rhp
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+var updateVectors = flag.Bool("update", false, "update test vectors in testdata")
+
+var maxCurrency = types.NewCurrency(math.MaxUint64, math.MaxUint64)
+
+// The vectors in testdata/vectors.json allow other implementations to verify
+// that their cost and revision math agrees exactly with this package. Each
+// vector refers to one of a handful of named host settings. Overflows are
+// recorded as errors.
+
+type costVector struct {
+	Function string   `json:"function"`
+	Settings string   `json:"settings"`
+	Args     []uint64 `json:"args"`
+
+	BaseCost             *types.Currency `json:"baseCost,omitempty"`
+	StorageCost          *types.Currency `json:"storageCost,omitempty"`
+	AdditionalCollateral *types.Currency `json:"additionalCollateral,omitempty"`
+	Memory               uint64          `json:"memory"`
+	Time                 uint64          `json:"time"`
+	Error                string          `json:"error,omitempty"`
+}
+
+type revisionVector struct {
+	Function string              `json:"function"`
+	Contract types.FileContract  `json:"contract"`
+	Amount   types.Currency      `json:"amount"`
+	Result   *types.FileContract `json:"result,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+type renewalVector struct {
+	Settings      string             `json:"settings"`
+	CurrentHeight uint64             `json:"currentHeight"`
+	Existing      types.FileContract `json:"existing"`
+	Renewal       types.FileContract `json:"renewal"`
+	Error         string             `json:"error,omitempty"`
+}
+
+type testVectors struct {
+	Settings  map[string]HostSettings `json:"settings"`
+	Costs     []costVector            `json:"costs"`
+	Revisions []revisionVector        `json:"revisions"`
+	Renewals  []renewalVector         `json:"renewals"`
+}
+
+// catch calls fn, converting a panic (e.g. from a Currency overflow) into an
+// error.
+func catch(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return fn()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func vectorSettings() map[string]HostSettings {
+	typical := HostSettings{
+		Address:       types.Address{1},
+		MaxCollateral: types.Siacoins(1000),
+		MaxDuration:   144 * 30 * 6,
+		WindowSize:    144,
+
+		ContractFee:            types.Siacoins(1).Div64(5),
+		Collateral:             types.NewCurrency64(2e9),
+		DownloadBandwidthPrice: types.NewCurrency64(25e12),
+		UploadBandwidthPrice:   types.NewCurrency64(1e12),
+		StoragePrice:           types.NewCurrency64(1e9),
+
+		ProgInitBaseCost:   types.NewCurrency64(1e15),
+		ProgMemoryTimeCost: types.NewCurrency64(1),
+		ProgReadCost:       types.NewCurrency64(1e9),
+		ProgWriteCost:      types.NewCurrency64(1e10),
+
+		InstrAppendSectorBaseCost:   types.NewCurrency64(1e16),
+		InstrDropSectorsBaseCost:    types.NewCurrency64(1e15),
+		InstrDropSectorsUnitCost:    types.NewCurrency64(1e14),
+		InstrHasSectorBaseCost:      types.NewCurrency64(1e14),
+		InstrReadBaseCost:           types.NewCurrency64(1e15),
+		InstrReadRegistryBaseCost:   types.NewCurrency64(1e15),
+		InstrRevisionBaseCost:       types.NewCurrency64(1e15),
+		InstrSectorRootsBaseCost:    types.NewCurrency64(1e15),
+		InstrSwapSectorBaseCost:     types.NewCurrency64(1e15),
+		InstrUpdateRegistryBaseCost: types.NewCurrency64(1e15),
+		InstrUpdateSectorBaseCost:   types.NewCurrency64(1e16),
+		InstrWriteBaseCost:          types.NewCurrency64(1e15),
+	}
+
+	// zero collateral, otherwise typical
+	noCollateral := typical
+	noCollateral.Collateral = types.ZeroCurrency
+	noCollateral.MaxCollateral = types.ZeroCurrency
+
+	// every price at its maximum value
+	maxed := typical
+	v := reflect.ValueOf(&maxed).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if c, ok := v.Field(i).Addr().Interface().(*types.Currency); ok {
+			*c = maxCurrency
+		}
+	}
+
+	return map[string]HostSettings{
+		"zero":         {},
+		"typical":      typical,
+		"noCollateral": noCollateral,
+		"max":          maxed,
+	}
+}
+
+func costVectors(settings map[string]HostSettings) (vectors []costVector) {
+	fns := []struct {
+		name string
+		args [][]uint64
+		fn   func(HostSettings, []uint64) ResourceUsage
+	}{
+		{"ExecutionCost", [][]uint64{{0, 0, 0}, {4096, 3, 1}, {1 << 22, 100, 1}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return ExecutionCost(s, a[0], a[1], a[2] == 1)
+		}},
+		{"AppendSectorCost", [][]uint64{{0}, {144}, {144 * 365 * 5}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return AppendSectorCost(s, a[0])
+		}},
+		{"UpdateSectorCost", [][]uint64{{0}, {4096}, {SectorSize}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return UpdateSectorCost(s, a[0])
+		}},
+		{"DropSectorsCost", [][]uint64{{0}, {1}, {1e6}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return DropSectorsCost(s, a[0])
+		}},
+		{"HasSectorCost", [][]uint64{{}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return HasSectorCost(s)
+		}},
+		{"ReadCost", [][]uint64{{0}, {64}, {SectorSize}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return ReadCost(s, a[0])
+		}},
+		{"RevisionCost", [][]uint64{{}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return RevisionCost(s)
+		}},
+		{"SectorRootsCost", [][]uint64{{0}, {1}, {1 << 20}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return SectorRootsCost(s, a[0])
+		}},
+		{"SwapSectorCost", [][]uint64{{}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return SwapSectorCost(s)
+		}},
+		{"UpdateRegistryCost", [][]uint64{{}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return UpdateRegistryCost(s)
+		}},
+		{"ReadRegistryCost", [][]uint64{{}}, func(s HostSettings, a []uint64) ResourceUsage {
+			return ReadRegistryCost(s)
+		}},
+	}
+	for _, f := range fns {
+		for _, name := range []string{"zero", "typical", "noCollateral", "max"} {
+			for _, args := range f.args {
+				v := costVector{Function: f.name, Settings: name, Args: args}
+				var r ResourceUsage
+				if err := catch(func() error { r = f.fn(settings[name], args); return nil }); err != nil {
+					v.Error = err.Error()
+				} else {
+					v.BaseCost, v.StorageCost, v.AdditionalCollateral = &r.BaseCost, &r.StorageCost, &r.AdditionalCollateral
+					v.Memory, v.Time = r.Memory, r.Time
+				}
+				vectors = append(vectors, v)
+			}
+		}
+	}
+	return
+}
+
+func revisionVectors() (vectors []revisionVector) {
+	typical := types.FileContract{
+		Filesize:        1 << 30,
+		WindowStart:     1000,
+		WindowEnd:       1144,
+		RenterOutput:    types.SiacoinOutput{Address: types.Address{2}, Value: types.Siacoins(100)},
+		HostOutput:      types.SiacoinOutput{Address: types.Address{1}, Value: types.Siacoins(50)},
+		MissedHostValue: types.Siacoins(40),
+		TotalCollateral: types.Siacoins(30),
+		RevisionNumber:  10,
+	}
+	noCollateral := typical
+	noCollateral.HostOutput.Value = types.ZeroCurrency
+	noCollateral.MissedHostValue = types.ZeroCurrency
+	noCollateral.TotalCollateral = types.ZeroCurrency
+	maxed := typical
+	maxed.RenterOutput.Value = maxCurrency
+	maxed.HostOutput.Value = maxCurrency
+	maxed.MissedHostValue = maxCurrency
+	maxed.TotalCollateral = maxCurrency
+
+	fns := []struct {
+		name string
+		fn   func(types.FileContract, types.Currency) (types.FileContract, error)
+	}{
+		{"PaymentRevision", PaymentRevision},
+		{"FinalizeProgramRevision", FinalizeProgramRevision},
+	}
+	for _, f := range fns {
+		for _, fc := range []types.FileContract{typical, noCollateral, maxed} {
+			plusOne := func(c types.Currency) types.Currency {
+				if c == maxCurrency {
+					return c
+				}
+				return c.Add(types.NewCurrency64(1))
+			}
+			amounts := []types.Currency{
+				types.ZeroCurrency,
+				types.NewCurrency64(1),
+				fc.RenterOutput.Value,
+				plusOne(fc.RenterOutput.Value),
+				fc.MissedHostValue,
+				plusOne(fc.MissedHostValue),
+			}
+			for _, amount := range amounts {
+				v := revisionVector{Function: f.name, Contract: fc, Amount: amount}
+				var rev types.FileContract
+				err := catch(func() (err error) { rev, err = f.fn(fc, amount); return })
+				if err != nil {
+					v.Error = err.Error()
+				} else {
+					v.Result = &rev
+				}
+				vectors = append(vectors, v)
+			}
+		}
+	}
+	return
+}
+
+func renewalVectors(settings map[string]HostSettings) (vectors []renewalVector) {
+	const height = 1000
+	existing := types.FileContract{
+		Filesize:        1 << 30,
+		FileMerkleRoot:  types.Hash256{3},
+		WindowStart:     height + 200,
+		WindowEnd:       height + 344,
+		RenterOutput:    types.SiacoinOutput{Address: types.Address{2}, Value: types.Siacoins(10)},
+		HostOutput:      types.SiacoinOutput{Address: types.Address{1}, Value: types.Siacoins(50)},
+		MissedHostValue: types.Siacoins(40),
+		TotalCollateral: types.Siacoins(30),
+		RevisionNumber:  10,
+	}
+	for _, name := range []string{"typical", "noCollateral", "max"} {
+		s := settings[name]
+		renewal := existing
+		renewal.RevisionNumber = 0
+		renewal.WindowStart = height + 4000
+		renewal.WindowEnd = renewal.WindowStart + s.WindowSize
+		renewal.RenterOutput.Value = types.Siacoins(100)
+		renewal.TotalCollateral = s.MaxCollateral
+		renewal.HostOutput.Value = types.ZeroCurrency
+		catch(func() error {
+			renewal.HostOutput.Value = s.ContractFee.Add(renewal.TotalCollateral)
+			return nil
+		})
+		renewal.MissedHostValue = renewal.HostOutput.Value
+
+		cases := []types.FileContract{renewal}
+		modify := func(fn func(*types.FileContract)) {
+			fc := renewal
+			fn(&fc)
+			cases = append(cases, fc)
+		}
+		modify(func(fc *types.FileContract) { fc.RevisionNumber = 1 })
+		modify(func(fc *types.FileContract) { fc.WindowEnd = existing.WindowEnd - 1 })
+		modify(func(fc *types.FileContract) { fc.WindowStart = height + s.WindowSize - 1 })
+		modify(func(fc *types.FileContract) { fc.WindowStart = height + s.MaxDuration + 1 })
+		modify(func(fc *types.FileContract) { fc.HostOutput.Value = types.ZeroCurrency })
+		if excess, overflow := s.MaxCollateral.AddWithOverflow(types.NewCurrency64(1)); !overflow {
+			modify(func(fc *types.FileContract) {
+				fc.TotalCollateral = excess
+				fc.HostOutput.Value = s.ContractFee.Add(excess)
+				fc.MissedHostValue = fc.HostOutput.Value
+			})
+		}
+		for _, fc := range cases {
+			vectors = append(vectors, renewalVector{
+				Settings:      name,
+				CurrentHeight: height,
+				Existing:      existing,
+				Renewal:       fc,
+				Error: errString(catch(func() error {
+					return ValidateContractRenewal(existing, fc, height, s)
+				})),
+			})
+		}
+	}
+	return
+}
+
+func TestVectors(t *testing.T) {
+	settings := vectorSettings()
+	vectors := testVectors{
+		Settings:  settings,
+		Costs:     costVectors(settings),
+		Revisions: revisionVectors(),
+		Renewals:  renewalVectors(settings),
+	}
+	js, err := json.MarshalIndent(vectors, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js = append(js, '\n')
+
+	path := filepath.Join("testdata", "vectors.json")
+	if *updateVectors {
+		if err := os.MkdirAll("testdata", 0777); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, js, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(js, golden) {
+		t.Fatal("computed vectors do not match testdata/vectors.json; run with -update if the change is intentional")
+	}
+}
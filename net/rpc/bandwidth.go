@@ -0,0 +1,131 @@
+package rpc
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A RateLimiter limits throughput using a token bucket: bytes may be
+// transferred in bursts of up to burst bytes, refilled at a constant rate. A
+// RateLimiter may be shared by many connections, limiting their combined
+// throughput.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64 // negative if callers are waiting
+	last   time.Time
+}
+
+// reserve withdraws n tokens from the bucket, returning how long the caller
+// must wait before the withdrawal is covered.
+func (rl *RateLimiter) reserve(n int) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	if rl.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes may be transferred. Wait is a no-op if rl is nil.
+func (rl *RateLimiter) Wait(n int) {
+	if rl == nil {
+		return
+	}
+	for n > 0 {
+		chunk := n
+		if max := int(rl.burst); chunk > max {
+			chunk = max
+		}
+		time.Sleep(rl.reserve(chunk))
+		n -= chunk
+	}
+}
+
+// NewRateLimiter returns a RateLimiter that allows bytesPerSecond bytes per
+// second, in bursts of up to burst bytes.
+func NewRateLimiter(bytesPerSecond, burst int) *RateLimiter {
+	if bytesPerSecond <= 0 || burst <= 0 {
+		panic("rate and burst must be positive") // developer error
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// A MeteredConn wraps a net.Conn, counting the bytes read from and written to
+// it, and optionally limiting its throughput. Wrapping the connection
+// underlying a session meters the session as a whole; wrapping an individual
+// stream (e.g. a *mux.Stream) meters a single RPC.
+type MeteredConn struct {
+	net.Conn
+	ingress, egress uint64 // accessed atomically
+	readLimit       *RateLimiter
+	writeLimit      *RateLimiter
+}
+
+// Read implements net.Conn.
+func (mc *MeteredConn) Read(p []byte) (int, error) {
+	if mc.readLimit != nil && len(p) > int(mc.readLimit.burst) {
+		p = p[:int(mc.readLimit.burst)]
+	}
+	n, err := mc.Conn.Read(p)
+	atomic.AddUint64(&mc.ingress, uint64(n))
+	// the bytes have already arrived; delay subsequent reads instead
+	mc.readLimit.Wait(n)
+	return n, err
+}
+
+// Write implements net.Conn.
+func (mc *MeteredConn) Write(p []byte) (int, error) {
+	if mc.writeLimit == nil {
+		n, err := mc.Conn.Write(p)
+		atomic.AddUint64(&mc.egress, uint64(n))
+		return n, err
+	}
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if max := int(mc.writeLimit.burst); len(chunk) > max {
+			chunk = chunk[:max]
+		}
+		mc.writeLimit.Wait(len(chunk))
+		n, err := mc.Conn.Write(chunk)
+		atomic.AddUint64(&mc.egress, uint64(n))
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Bandwidth returns the number of bytes read from and written to the
+// connection so far.
+func (mc *MeteredConn) Bandwidth() (ingress, egress uint64) {
+	return atomic.LoadUint64(&mc.ingress), atomic.LoadUint64(&mc.egress)
+}
+
+// NewMeteredConn returns a MeteredConn wrapping conn. Either limiter may be
+// nil, in which case that direction is not limited.
+func NewMeteredConn(conn net.Conn, readLimit, writeLimit *RateLimiter) *MeteredConn {
+	return &MeteredConn{
+		Conn:       conn,
+		readLimit:  readLimit,
+		writeLimit: writeLimit,
+	}
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMeteredConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	const rate, burst = 1 << 20, 1 << 16
+	mc1 := NewMeteredConn(c1, nil, NewRateLimiter(rate, burst))
+	mc2 := NewMeteredConn(c2, nil, nil)
+
+	data := make([]byte, 5*burst)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := mc1.Write(data)
+		errCh <- err
+	}()
+	start := time.Now()
+	if _, err := io.ReadFull(mc2, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	} else if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	// the first burst is free; the remainder is limited
+	if elapsed, min := time.Since(start), time.Duration(4*burst)*time.Second/rate; elapsed < min*9/10 {
+		t.Fatalf("transfer took %v, expected at least %v", elapsed, min)
+	}
+
+	if in, out := mc1.Bandwidth(); in != 0 || out != uint64(len(data)) {
+		t.Fatalf("wrong writer bandwidth: %v in, %v out", in, out)
+	} else if in, out := mc2.Bandwidth(); in != uint64(len(data)) || out != 0 {
+		t.Fatalf("wrong reader bandwidth: %v in, %v out", in, out)
+	}
+}
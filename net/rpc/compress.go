@@ -0,0 +1,117 @@
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/types"
+)
+
+// Objects whose encoding is shorter than minCompressLen are never compressed,
+// since the zstd frame overhead would outweigh any savings.
+const minCompressLen = 256
+
+// compressed object encodings
+const (
+	encodingRaw  = 0
+	encodingZstd = 1
+)
+
+// WriteCompressedObject writes obj to w, compressing it if doing so reduces
+// its size. The object is prefixed with its encoding and length, so that the
+// reader knows where it ends. Both peers must have agreed to use compressed
+// objects, e.g. via a Handshake feature.
+func WriteCompressedObject(w io.Writer, obj Object) error {
+	var raw bytes.Buffer
+	e := types.NewEncoder(&raw)
+	obj.EncodeTo(e)
+	e.Flush()
+	encoding, body := uint8(encodingRaw), raw.Bytes()
+	if len(body) >= minCompressLen {
+		var compressed bytes.Buffer
+		if err := types.EncodeCompressed(&compressed, obj); err != nil {
+			return err
+		} else if compressed.Len() < len(body) {
+			encoding, body = encodingZstd, compressed.Bytes()
+		}
+	}
+	e = types.NewEncoder(w)
+	e.WriteUint8(encoding)
+	e.WriteBytes(body)
+	return e.Flush()
+}
+
+// readCompressedObject reads an object written by WriteCompressedObject. The
+// decompressed encoding may not exceed maxLen bytes.
+func readCompressedObject(r io.Reader, obj Object, maxLen int) error {
+	// the body is never longer than the raw encoding
+	d := types.NewDecoder(io.LimitedReader{R: r, N: int64(1 + 8 + maxLen)})
+	encoding := d.ReadUint8()
+	n := d.ReadPrefixMax(maxLen)
+	if err := d.Err(); err != nil {
+		return err
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(d, body); err != nil {
+		return err
+	}
+	switch encoding {
+	case encodingRaw:
+		return types.DecodeFromStrict(body, obj)
+	case encodingZstd:
+		return types.DecodeCompressed(bytes.NewReader(body), obj, maxLen)
+	default:
+		return fmt.Errorf("unknown object encoding %v", encoding)
+	}
+}
+
+// ReadCompressedObject reads obj from r, subject to l. The limit applies to the
+// decompressed encoding of obj.
+func (l Limits) ReadCompressedObject(r io.Reader, obj Object) error {
+	return readCompressedObject(r, obj, l.MaxLen(obj))
+}
+
+// WriteCompressedRequest is like WriteRequest, but writes req with
+// WriteCompressedObject.
+func WriteCompressedRequest(w io.Writer, id Specifier, req Object) error {
+	if err := WriteObject(w, &id); err != nil {
+		return fmt.Errorf("couldn't write request ID: %w", err)
+	}
+	if req != nil {
+		if err := WriteCompressedObject(w, req); err != nil {
+			return fmt.Errorf("couldn't write request object: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadCompressedRequest reads a request object written by
+// WriteCompressedRequest, subject to l.
+func (l Limits) ReadCompressedRequest(r io.Reader, req Object) error {
+	return l.ReadCompressedObject(r, req)
+}
+
+// WriteCompressedResponse is like WriteResponse, but writes resp with
+// WriteCompressedObject.
+func WriteCompressedResponse(w io.Writer, resp Object) error {
+	return WriteCompressedObject(w, &rpcResponse{obj: resp})
+}
+
+// WriteCompressedResponseErr is like WriteResponseErr, but writes the error
+// with WriteCompressedObject.
+func WriteCompressedResponseErr(w io.Writer, err error) error {
+	return WriteCompressedObject(w, &rpcResponse{err: toError(err)})
+}
+
+// ReadCompressedResponse reads a response written by WriteCompressedResponse,
+// subject to l. If the response is an error, it is returned directly.
+func (l Limits) ReadCompressedResponse(r io.Reader, resp Object) error {
+	rr := rpcResponse{obj: resp}
+	if err := readCompressedObject(r, &rr, l.responseMaxLen(resp)); err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	} else if rr.err != nil {
+		return fmt.Errorf("response error: %w", rr.err)
+	}
+	return nil
+}
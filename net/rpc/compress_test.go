@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+type objBytes []byte
+
+func (b *objBytes) EncodeTo(e *types.Encoder)   { e.WriteBytes(*b) }
+func (b *objBytes) DecodeFrom(d *types.Decoder) { *b = d.ReadBytes() }
+func (b *objBytes) MaxLen() int                 { return 1 << 20 }
+
+func TestCompressedObject(t *testing.T) {
+	roundTrip := func(l Limits, obj objBytes) (int, error) {
+		var buf bytes.Buffer
+		if err := WriteCompressedResponse(&buf, &obj); err != nil {
+			t.Fatal(err)
+		}
+		n := buf.Len()
+		var resp objBytes
+		if err := l.ReadCompressedResponse(&buf, &resp); err != nil {
+			return n, err
+		} else if !bytes.Equal(resp, obj) {
+			t.Fatal("response mismatch")
+		} else if buf.Len() != 0 {
+			t.Fatal("response was not fully consumed")
+		}
+		return n, nil
+	}
+
+	// small objects are sent uncompressed
+	if n, err := roundTrip(Limits{}, objBytes("foo")); err != nil {
+		t.Fatal(err)
+	} else if n != 1+8+1+8+3 {
+		t.Fatal("small object should not be compressed:", n)
+	}
+	// compressible objects are compressed
+	compressible := objBytes(strings.Repeat("a", 100000))
+	if n, err := roundTrip(Limits{}, compressible); err != nil {
+		t.Fatal(err)
+	} else if n >= len(compressible)/10 {
+		t.Fatal("compressible object was not compressed:", n)
+	}
+	// limits apply to the decompressed size
+	var l Limits
+	l.Override(&compressible, 50000)
+	if _, err := roundTrip(l, compressible); !errors.Is(err, types.ErrCompressedTooLarge) {
+		t.Fatal("expected ErrCompressedTooLarge, got", err)
+	}
+	// objects larger than 1 MiB use a window of the same size
+	large := objBytes(strings.Repeat("abcdefgh", 3<<17))
+	l.Override(&large, 4<<20)
+	if _, err := roundTrip(l, large); err != nil {
+		t.Fatal(err)
+	}
+	// errors are returned directly
+	var buf bytes.Buffer
+	WriteCompressedResponseErr(&buf, errors.New("foo"))
+	var resp objBytes
+	if err := (Limits{}).ReadCompressedResponse(&buf, &resp); err == nil || !strings.Contains(err.Error(), "foo") {
+		t.Fatal("expected error response, got", err)
+	}
+}
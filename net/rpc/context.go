@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Stream is a duplex connection whose deadline can be set, such as a
+// net.Conn or *mux.Stream. Setting a deadline must interrupt pending I/O.
+type Stream interface {
+	io.ReadWriter
+	SetDeadline(t time.Time) error
+}
+
+// WithContext binds the deadline of s to ctx: the deadline of ctx, if any, is
+// applied to s, and if ctx is canceled, the deadline of s is set to the past,
+// interrupting any pending I/O. The returned function unbinds s and clears its
+// deadline; it returns ctx.Err(), so that callers can report cancellation in
+// place of the resulting I/O error.
+func WithContext(ctx context.Context, s Stream) (stop func() error) {
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+	if ctx.Done() == nil {
+		return func() error {
+			s.SetDeadline(time.Time{})
+			return nil
+		}
+	}
+	unbind := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			s.SetDeadline(time.Now())
+		case <-unbind:
+		}
+	}()
+	return func() error {
+		close(unbind)
+		<-exited
+		s.SetDeadline(time.Time{})
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if !deadline.IsZero() && !time.Now().Before(deadline) {
+			// the deadline of s may expire slightly before ctx's timer fires
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+}
+
+// contextErr returns err, wrapped with the error of ctx if ctx is done.
+func contextErr(ctxErr, err error) error {
+	if err != nil && ctxErr != nil {
+		return fmt.Errorf("%w (%v)", ctxErr, err)
+	}
+	return err
+}
+
+// CallContext is like Call, but aborts the RPC if ctx is canceled or its
+// deadline passes.
+func (c *Client) CallContext(ctx context.Context, s Stream, id Specifier, req, resp Object) error {
+	stop := WithContext(ctx, s)
+	err := c.Call(s, id, req, resp)
+	return contextErr(stop(), err)
+}
+
+// ServeContext is like Serve, but aborts the RPC if ctx is canceled or its
+// deadline passes.
+func (srv *Server) ServeContext(ctx context.Context, s Stream) error {
+	stop := WithContext(ctx, s)
+	err := srv.Serve(s)
+	return contextErr(stop(), err)
+}
@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"go.sia.tech/core/types"
+)
+
+// ErrIncompatibleVersion is returned when two peers do not support any common
+// protocol version.
+var ErrIncompatibleVersion = errors.New("no common protocol version")
+
+// A Handshake describes the protocol versions and optional features supported
+// by a peer. Peers exchange Handshakes at the start of a session; the result
+// of Negotiate is then used to decide how subsequent objects are encoded.
+type Handshake struct {
+	// Magic identifies the protocol; peers with different Magic values are
+	// not speaking the same protocol.
+	Magic Specifier
+	// MinVersion and Version are the lowest and highest supported versions.
+	MinVersion uint8
+	Version    uint8
+	// Features is a bitmask of optional protocol features.
+	Features uint64
+	// MaxObjectLen is the largest encoded object that the peer is willing to
+	// read. Zero indicates that the peer uses the standard MaxLen of each
+	// object.
+	MaxObjectLen uint64
+}
+
+// EncodeTo implements Object.
+func (h *Handshake) EncodeTo(e *types.Encoder) {
+	h.Magic.EncodeTo(e)
+	e.WriteUint8(h.MinVersion)
+	e.WriteUint8(h.Version)
+	e.WriteUint64(h.Features)
+	e.WriteUint64(h.MaxObjectLen)
+}
+
+// DecodeFrom implements Object.
+func (h *Handshake) DecodeFrom(d *types.Decoder) {
+	h.Magic.DecodeFrom(d)
+	h.MinVersion = d.ReadUint8()
+	h.Version = d.ReadUint8()
+	h.Features = d.ReadUint64()
+	h.MaxObjectLen = d.ReadUint64()
+}
+
+// MaxLen implements Object.
+func (h *Handshake) MaxLen() int {
+	return 16 + 1 + 1 + 8 + 8
+}
+
+// HasFeature reports whether all of the specified feature bits are set.
+func (h Handshake) HasFeature(features uint64) bool {
+	return h.Features&features == features
+}
+
+// Negotiate returns the Handshake agreed upon by two peers: the highest
+// version supported by both, and the features supported by both. The
+// returned MinVersion and Version are equal. If both peers advertise a
+// MaxObjectLen, the smaller is used; otherwise, it is zero, and the standard
+// limits apply.
+func Negotiate(ours, theirs Handshake) (Handshake, error) {
+	if ours.Magic != theirs.Magic {
+		return Handshake{}, fmt.Errorf("peer is speaking a different protocol (%q)", theirs.Magic)
+	}
+	version := ours.Version
+	if theirs.Version < version {
+		version = theirs.Version
+	}
+	if version < ours.MinVersion || version < theirs.MinVersion {
+		return Handshake{}, fmt.Errorf("%w (ours = %v-%v, theirs = %v-%v)", ErrIncompatibleVersion,
+			ours.MinVersion, ours.Version, theirs.MinVersion, theirs.Version)
+	}
+	maxObjectLen := ours.MaxObjectLen
+	if theirs.MaxObjectLen < maxObjectLen {
+		maxObjectLen = theirs.MaxObjectLen
+	}
+	return Handshake{
+		Magic:        ours.Magic,
+		MinVersion:   version,
+		Version:      version,
+		Features:     ours.Features & theirs.Features,
+		MaxObjectLen: maxObjectLen,
+	}, nil
+}
+
+// InitiateHandshake writes ours to rw, reads the peer's Handshake, and returns
+// the negotiated result. The peer must call AcceptHandshake.
+func InitiateHandshake(rw io.ReadWriter, ours Handshake) (Handshake, error) {
+	var theirs Handshake
+	if err := WriteObject(rw, &ours); err != nil {
+		return Handshake{}, fmt.Errorf("couldn't write our handshake: %w", err)
+	} else if err := ReadObject(rw, &theirs); err != nil {
+		return Handshake{}, fmt.Errorf("couldn't read peer handshake: %w", err)
+	}
+	return Negotiate(ours, theirs)
+}
+
+// AcceptHandshake reads the peer's Handshake from rw, writes ours, and returns
+// the negotiated result. The peer must call InitiateHandshake.
+func AcceptHandshake(rw io.ReadWriter, ours Handshake) (Handshake, error) {
+	var theirs Handshake
+	if err := ReadObject(rw, &theirs); err != nil {
+		return Handshake{}, fmt.Errorf("couldn't read peer handshake: %w", err)
+	} else if err := WriteObject(rw, &ours); err != nil {
+		return Handshake{}, fmt.Errorf("couldn't write our handshake: %w", err)
+	}
+	return Negotiate(ours, theirs)
+}
@@ -0,0 +1,71 @@
+package rpc
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	magic := NewSpecifier("test")
+	exchange := func(a, b Handshake) (Handshake, Handshake, error, error) {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		type result struct {
+			h   Handshake
+			err error
+		}
+		ch := make(chan result, 1)
+		go func() {
+			h, err := AcceptHandshake(c2, b)
+			ch <- result{h, err}
+		}()
+		ha, errA := InitiateHandshake(c1, a)
+		r := <-ch
+		return ha, r.h, errA, r.err
+	}
+
+	// overlapping versions
+	a := Handshake{Magic: magic, MinVersion: 1, Version: 3, Features: 0b0111}
+	b := Handshake{Magic: magic, MinVersion: 2, Version: 4, Features: 0b1101}
+	ha, hb, errA, errB := exchange(a, b)
+	if errA != nil || errB != nil {
+		t.Fatal(errA, errB)
+	} else if ha != hb {
+		t.Fatal("peers negotiated different results")
+	} else if ha.Version != 3 || ha.MinVersion != 3 {
+		t.Fatal("wrong negotiated version:", ha.Version)
+	} else if ha.Features != 0b0101 || !ha.HasFeature(0b0100) || ha.HasFeature(0b0010) {
+		t.Fatalf("wrong negotiated features: %b", ha.Features)
+	}
+
+	// maximum object lengths
+	for _, test := range []struct{ a, b, exp uint64 }{
+		{0, 0, 0},
+		{1e6, 0, 0},
+		{0, 1e6, 0},
+		{1e6, 2e6, 1e6},
+		{3e6, 2e6, 2e6},
+	} {
+		a.MaxObjectLen, b.MaxObjectLen = test.a, test.b
+		if ha, hb, errA, errB := exchange(a, b); errA != nil || errB != nil {
+			t.Fatal(errA, errB)
+		} else if ha.MaxObjectLen != test.exp || hb.MaxObjectLen != test.exp {
+			t.Fatalf("expected max object length %v, got %v/%v", test.exp, ha.MaxObjectLen, hb.MaxObjectLen)
+		}
+	}
+
+	// disjoint versions
+	b.MinVersion = 4
+	if _, _, errA, errB := exchange(a, b); !errors.Is(errA, ErrIncompatibleVersion) || !errors.Is(errB, ErrIncompatibleVersion) {
+		t.Fatal("expected ErrIncompatibleVersion, got", errA, errB)
+	}
+
+	// different protocols
+	b = a
+	b.Magic = NewSpecifier("other")
+	if _, _, errA, errB := exchange(a, b); errA == nil || errB == nil {
+		t.Fatal("expected magic mismatch to be rejected")
+	}
+}
@@ -0,0 +1,182 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+// idempotentRequestID prefixes a request that carries an IdempotencyKey.
+var idempotentRequestID = NewSpecifier("Idempotent")
+
+// ErrKeyReused is returned by (*ReplayCache).Serve if an IdempotencyKey is
+// reused for a different request.
+var ErrKeyReused = errors.New("idempotency key was already used for a different request")
+
+// ErrReplayCacheFull is returned by (*ReplayCache).Serve if every entry in the
+// cache belongs to a request that is still in progress.
+var ErrReplayCacheFull = errors.New("too many idempotent requests in progress")
+
+// An IdempotencyKey identifies a request, allowing it to be retried without
+// being executed twice. Keys must be chosen randomly, e.g. via
+// NewIdempotencyKey.
+type IdempotencyKey [16]byte
+
+// EncodeTo implements Object.
+func (k *IdempotencyKey) EncodeTo(e *types.Encoder) { e.Write(k[:]) }
+
+// DecodeFrom implements Object.
+func (k *IdempotencyKey) DecodeFrom(d *types.Decoder) { d.Read(k[:]) }
+
+// MaxLen implements Object.
+func (k *IdempotencyKey) MaxLen() int { return 16 }
+
+// String implements fmt.Stringer.
+func (k IdempotencyKey) String() string { return hex.EncodeToString(k[:]) }
+
+// NewIdempotencyKey returns a random IdempotencyKey.
+func NewIdempotencyKey() (k IdempotencyKey) {
+	types.ReadEntropy(k[:])
+	return
+}
+
+// WriteIdempotentRequest sends an RPC request tagged with an idempotency key.
+// If the request times out, the client may send it again with the same key; if
+// the server has already processed it, the server replays its original response
+// rather than executing the RPC again.
+func WriteIdempotentRequest(w io.Writer, id Specifier, key IdempotencyKey, req Object) error {
+	if err := WriteObject(w, &idempotentRequestID); err != nil {
+		return fmt.Errorf("couldn't write request ID: %w", err)
+	} else if err := WriteObject(w, &key); err != nil {
+		return fmt.Errorf("couldn't write idempotency key: %w", err)
+	}
+	return WriteRequest(w, id, req)
+}
+
+// ReadIdempotentID reads an RPC request ID, along with its idempotency key, if
+// the request has one.
+func ReadIdempotentID(r io.Reader) (id Specifier, key IdempotencyKey, ok bool, err error) {
+	if id, err = ReadID(r); err != nil || id != idempotentRequestID {
+		return
+	} else if err = ReadObject(r, &key); err != nil {
+		return
+	}
+	id, err = ReadID(r)
+	return id, key, err == nil, err
+}
+
+type replayEntry struct {
+	id      Specifier
+	reqHash types.Hash256
+	done    chan struct{}
+	resp    []byte
+	expires time.Time
+}
+
+// A ReplayCache records the responses to idempotent requests for a fixed
+// window, so that servers can replay them when a client retries.
+//
+// Since a response is buffered in full before it is sent, a ReplayCache is only
+// suitable for RPCs consisting of a single request and response.
+type ReplayCache struct {
+	window     time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[IdempotencyKey]*replayEntry
+	order   []IdempotencyKey // in order of expiration
+}
+
+// evict removes completed entries that have expired. If room is true, it also
+// removes the oldest completed entries until there is room for a new one,
+// reporting whether it succeeded. Entries that are still in progress are never
+// removed. It must be called with rc.mu held.
+func (rc *ReplayCache) evict(now time.Time, room bool) bool {
+	n := len(rc.order)
+	order := rc.order[:0]
+	for _, key := range rc.order {
+		e := rc.entries[key]
+		select {
+		case <-e.done:
+			if now.After(e.expires) || (room && n >= rc.maxEntries) {
+				delete(rc.entries, key)
+				n--
+				continue
+			}
+		default:
+		}
+		order = append(order, key)
+	}
+	rc.order = order
+	return !room || n < rc.maxEntries
+}
+
+// Serve handles a request with the given ID, idempotency key, and request
+// hash. If a response to the same key was recorded within the window, it is
+// written to w and fn is not called. Otherwise, fn is called to handle the
+// request, and the response it writes is recorded before being sent to w.
+// Concurrent requests with the same key wait for the first to complete.
+//
+// If the key was recorded for a different ID or request hash, Serve returns
+// ErrKeyReused; if the cache is full of requests that are still in progress,
+// it returns ErrReplayCacheFull. In both cases, nothing is written to w.
+func (rc *ReplayCache) Serve(w io.Writer, id Specifier, key IdempotencyKey, reqHash types.Hash256, fn func(w io.Writer) error) error {
+	rc.mu.Lock()
+	now := time.Now()
+	rc.evict(now, false)
+	e, ok := rc.entries[key]
+	if !ok {
+		if !rc.evict(now, true) {
+			rc.mu.Unlock()
+			return ErrReplayCacheFull
+		}
+		e = &replayEntry{
+			id:      id,
+			reqHash: reqHash,
+			done:    make(chan struct{}),
+			expires: now.Add(rc.window),
+		}
+		rc.entries[key] = e
+		rc.order = append(rc.order, key)
+	}
+	rc.mu.Unlock()
+
+	if ok {
+		if e.id != id || e.reqHash != reqHash {
+			return fmt.Errorf("%w (%v)", ErrKeyReused, key)
+		}
+		<-e.done
+		_, err := w.Write(e.resp)
+		return err
+	}
+
+	// buffer the response, so that it is recorded even if the client has
+	// disconnected
+	var buf bytes.Buffer
+	err := fn(&buf)
+	e.resp = buf.Bytes()
+	close(e.done)
+	if _, werr := w.Write(e.resp); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// NewReplayCache returns a ReplayCache that records responses for the given
+// window, holding at most maxEntries of them.
+func NewReplayCache(window time.Duration, maxEntries int) *ReplayCache {
+	if window <= 0 || maxEntries <= 0 {
+		panic("window and maxEntries must be positive") // developer error
+	}
+	return &ReplayCache{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[IdempotencyKey]*replayEntry),
+	}
+}
@@ -0,0 +1,118 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestReplayCache(t *testing.T) {
+	id := NewSpecifier("Foo")
+	key := NewIdempotencyKey()
+	rc := NewReplayCache(time.Hour, 2)
+
+	// simulate a client that retries a request after a timeout
+	var executions int
+	call := func(key IdempotencyKey, in objString) (string, error) {
+		var req bytes.Buffer
+		if err := WriteIdempotentRequest(&req, id, key, &in); err != nil {
+			return "", err
+		}
+		var resp bytes.Buffer
+		readID, readKey, ok, err := ReadIdempotentID(&req)
+		if err != nil {
+			return "", err
+		} else if !ok || readID != id || readKey != key {
+			t.Fatal("request ID or key mismatch")
+		}
+		var s objString
+		if err := ReadRequest(&req, &s); err != nil {
+			return "", err
+		}
+		reqHash := types.HashBytes([]byte(s))
+		err = rc.Serve(&resp, readID, readKey, reqHash, func(w io.Writer) error {
+			executions++
+			out := objString(string(s) + string(rune('0'+executions)))
+			return WriteResponse(w, &out)
+		})
+		if err != nil {
+			return "", err
+		}
+		var out objString
+		err = ReadResponse(&resp, &out)
+		return string(out), err
+	}
+
+	if resp, err := call(key, "foo"); err != nil {
+		t.Fatal(err)
+	} else if resp != "foo1" {
+		t.Fatalf("expected %q, got %q", "foo1", resp)
+	}
+	if resp, err := call(key, "foo"); err != nil {
+		t.Fatal(err)
+	} else if resp != "foo1" || executions != 1 {
+		t.Fatal("retried request was executed again")
+	}
+	if resp, err := call(NewIdempotencyKey(), "foo"); err != nil {
+		t.Fatal(err)
+	} else if resp != "foo2" {
+		t.Fatalf("expected %q, got %q", "foo2", resp)
+	}
+
+	// reusing a key for a different RPC or request is an error
+	if err := rc.Serve(io.Discard, NewSpecifier("Bar"), key, types.HashBytes([]byte("foo")), nil); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("expected %v, got %v", ErrKeyReused, err)
+	} else if _, err := call(key, "bar"); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("expected %v, got %v", ErrKeyReused, err)
+	}
+
+	// once the cache is full, the oldest entry is evicted
+	if _, err := call(NewIdempotencyKey(), "foo"); err != nil {
+		t.Fatal(err)
+	} else if resp, err := call(key, "foo"); err != nil {
+		t.Fatal(err)
+	} else if resp != "foo4" {
+		t.Fatalf("expected evicted request to be executed again, got %q", resp)
+	}
+
+	// in-progress requests are never evicted; once they fill the cache, new
+	// requests are rejected
+	rc = NewReplayCache(time.Hour, 2)
+	release := make(chan struct{})
+	started, finished := make(chan struct{}), make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			finished <- rc.Serve(io.Discard, id, NewIdempotencyKey(), types.Hash256{}, func(w io.Writer) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+		<-started
+	}
+	if err := rc.Serve(io.Discard, id, NewIdempotencyKey(), types.Hash256{}, nil); !errors.Is(err, ErrReplayCacheFull) {
+		t.Fatalf("expected %v, got %v", ErrReplayCacheFull, err)
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-finished; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := call(NewIdempotencyKey(), "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// requests without a key are read as usual
+	var req bytes.Buffer
+	WriteRequest(&req, id, nil)
+	if readID, _, ok, err := ReadIdempotentID(&req); err != nil {
+		t.Fatal(err)
+	} else if ok || readID != id {
+		t.Fatal("expected request without idempotency key")
+	}
+}
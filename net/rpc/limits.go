@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"go.sia.tech/core/types"
+)
+
+// Standard maximum encoded lengths for RPC objects.
+const (
+	DefaultMaxLen = 10e3 // for requests, revisions, proofs, etc.
+	LargeMaxLen   = 1e6  // for transactions, headers, and blocks
+)
+
+// ErrObjectTooLarge is returned when writing an object whose encoded size
+// exceeds the limits of the peer.
+var ErrObjectTooLarge = errors.New("object exceeds maximum length")
+
+// Limits bounds the encoded size of objects read from a peer. The zero value
+// uses each object's MaxLen.
+type Limits struct {
+	// Ceiling, if non-zero, caps the encoded size of every object, including
+	// those with overrides and RPC errors.
+	Ceiling int
+	// Floor, if non-zero, raises the maximum encoded size of every object to
+	// at least Floor, e.g. to honor a limit negotiated via Handshake. Ceiling
+	// takes precedence over Floor.
+	Floor int
+	// Overrides replaces the MaxLen of particular object types. It is keyed by
+	// the dynamic type of the object, e.g. reflect.TypeOf(&rhp.RPCReadResponse{}).
+	Overrides map[reflect.Type]int
+}
+
+// Validate returns an error if any of l's limits are negative or zero. (The
+// zero value of Ceiling is permitted, since it means "no ceiling".)
+func (l Limits) Validate() error {
+	if l.Ceiling < 0 {
+		return fmt.Errorf("negative ceiling (%v)", l.Ceiling)
+	} else if l.Floor < 0 {
+		return fmt.Errorf("negative floor (%v)", l.Floor)
+	}
+	for t, n := range l.Overrides {
+		if n <= 0 {
+			return fmt.Errorf("non-positive override for %v (%v)", t, n)
+		}
+	}
+	return nil
+}
+
+// Override sets the maximum encoded length of objects with the same type as
+// obj.
+func (l *Limits) Override(obj Object, maxLen int) {
+	if l.Overrides == nil {
+		l.Overrides = make(map[reflect.Type]int)
+	}
+	l.Overrides[reflect.TypeOf(obj)] = maxLen
+}
+
+// MaxLen returns the maximum encoded length of obj under l.
+func (l Limits) MaxLen(obj Object) int {
+	n, ok := l.Overrides[reflect.TypeOf(obj)]
+	if !ok {
+		n = obj.MaxLen()
+	}
+	if n < l.Floor {
+		n = l.Floor
+	}
+	if l.Ceiling > 0 && n > l.Ceiling {
+		n = l.Ceiling
+	}
+	return n
+}
+
+func readObject(r io.Reader, obj Object, maxLen int) error {
+	d := types.NewDecoder(io.LimitedReader{R: r, N: int64(maxLen)})
+	obj.DecodeFrom(d)
+	return d.Err()
+}
+
+func writeObject(w io.Writer, obj Object, maxLen int) error {
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	obj.EncodeTo(e)
+	if err := e.Flush(); err != nil {
+		return err
+	} else if buf.Len() > maxLen {
+		return fmt.Errorf("%w (%v > %v bytes)", ErrObjectTooLarge, buf.Len(), maxLen)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteObject writes obj to w. If the encoded object exceeds l.MaxLen(obj),
+// nothing is written, and ErrObjectTooLarge is returned. Since a peer using the
+// same limits would reject such an object, this allows the error to be handled
+// locally, rather than as a dropped connection.
+func (l Limits) WriteObject(w io.Writer, obj Object) error {
+	return writeObject(w, obj, l.MaxLen(obj))
+}
+
+// WriteResponse writes an RPC response object to w, subject to l; see
+// WriteObject.
+func (l Limits) WriteResponse(w io.Writer, resp Object) error {
+	return writeObject(w, &rpcResponse{obj: resp}, l.responseMaxLen(resp))
+}
+
+// ReadObject reads obj from r, subject to l.
+func (l Limits) ReadObject(r io.Reader, obj Object) error {
+	return readObject(r, obj, l.MaxLen(obj))
+}
+
+// ReadRequest reads an RPC request, subject to l.
+func (l Limits) ReadRequest(r io.Reader, req Object) error {
+	return l.ReadObject(r, req)
+}
+
+// responseMaxLen returns the maximum encoded length of a response containing
+// resp, under l.
+func (l Limits) responseMaxLen(resp Object) int {
+	// a response contains either an error or an object, never both
+	maxLen := (*Error)(nil).MaxLen()
+	if n := l.MaxLen(resp); n > maxLen {
+		maxLen = n
+	}
+	maxLen++
+	if l.Ceiling > 0 && maxLen > l.Ceiling {
+		maxLen = l.Ceiling
+	}
+	return maxLen
+}
+
+// ReadResponse reads an RPC response, subject to l. If the response is an
+// error, it is returned directly.
+func (l Limits) ReadResponse(r io.Reader, resp Object) error {
+	rr := rpcResponse{obj: resp}
+	if err := readObject(r, &rr, l.responseMaxLen(resp)); err != nil {
+		return fmt.Errorf("failed to read message: %w", err)
+	} else if rr.err != nil {
+		return fmt.Errorf("response error: %w", rr.err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+type objString string
+
+func (s *objString) EncodeTo(e *types.Encoder)   { e.WriteString(string(*s)) }
+func (s *objString) DecodeFrom(d *types.Decoder) { *s = objString(d.ReadString()) }
+func (s *objString) MaxLen() int                 { return 100 }
+
+func TestLimits(t *testing.T) {
+	roundTrip := func(l Limits, s string) error {
+		var buf bytes.Buffer
+		obj := objString(s)
+		if err := WriteResponse(&buf, &obj); err != nil {
+			return err
+		}
+		var resp objString
+		if err := l.ReadResponse(&buf, &resp); err != nil {
+			return err
+		} else if resp != obj {
+			t.Fatal("response mismatch")
+		}
+		return nil
+	}
+
+	short, long := "foo", strings.Repeat("a", 2000)
+	var l Limits
+	if err := roundTrip(l, short); err != nil {
+		t.Fatal(err)
+	} else if err := roundTrip(l, long); err == nil {
+		t.Fatal("expected object exceeding MaxLen to be rejected")
+	}
+
+	l.Override((*objString)(nil), 3000)
+	if l.MaxLen(new(objString)) != 3000 {
+		t.Fatal("override was not applied")
+	} else if err := roundTrip(l, long); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Ceiling = 10
+	if l.MaxLen(new(objString)) != 10 {
+		t.Fatal("ceiling was not applied")
+	} else if err := roundTrip(l, short); err == nil {
+		t.Fatal("expected object exceeding ceiling to be rejected")
+	}
+
+	l = Limits{Floor: 5000}
+	if l.MaxLen(new(objString)) != 5000 {
+		t.Fatal("floor was not applied")
+	} else if err := roundTrip(l, long); err != nil {
+		t.Fatal(err)
+	}
+	l.Ceiling = 10
+	if l.MaxLen(new(objString)) != 10 {
+		t.Fatal("ceiling should take precedence over floor")
+	}
+}
+
+func TestLimitsWriteObject(t *testing.T) {
+	var buf bytes.Buffer
+	obj := objString(strings.Repeat("a", 2000))
+	var l Limits
+	if err := l.WriteObject(&buf, &obj); !errors.Is(err, ErrObjectTooLarge) {
+		t.Fatal("expected ErrObjectTooLarge, got", err)
+	} else if err := l.WriteResponse(&buf, &obj); !errors.Is(err, ErrObjectTooLarge) {
+		t.Fatal("expected ErrObjectTooLarge, got", err)
+	} else if buf.Len() != 0 {
+		t.Fatal("oversized object should not have been written")
+	}
+
+	l.Floor = 3000
+	if err := l.WriteResponse(&buf, &obj); err != nil {
+		t.Fatal(err)
+	}
+	var resp objString
+	if err := l.ReadResponse(&buf, &resp); err != nil {
+		t.Fatal(err)
+	} else if resp != obj {
+		t.Fatal("response mismatch")
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	var l Limits
+	if err := l.Validate(); err != nil {
+		t.Fatal("zero value should be valid:", err)
+	}
+	l.Override((*objString)(nil), 0)
+	if err := l.Validate(); err == nil {
+		t.Fatal("expected zero override to be rejected")
+	}
+	l.Override((*objString)(nil), 10)
+	l.Ceiling = -1
+	if err := l.Validate(); err == nil {
+		t.Fatal("expected negative ceiling to be rejected")
+	}
+}
@@ -1,7 +1,10 @@
+// Package rpc implements the encoding and framing of RPC objects, and an
+// encrypted, authenticated Transport over which to exchange them.
 package rpc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -44,10 +47,22 @@ func NewSpecifier(str string) Specifier {
 
 // An Error may be sent instead of a response object to any RPC.
 type Error struct {
-	Type        Specifier
-	Data        []byte // structure depends on Type
-	Description string // human-readable error string
-}
+	Type        Specifier // error code; zero if unspecified
+	Data        []byte    // structure depends on Type
+	Description string    // human-readable error string
+}
+
+// Standard RPC errors. Their Type identifies the cause of a failure, allowing
+// clients to handle it programmatically via errors.Is. Handlers may wrap them
+// with additional context, e.g. fmt.Errorf("%w: %v", rpc.ErrLocked, id);
+// WriteResponseErr preserves the Type of a wrapped Error.
+var (
+	ErrInsufficientFunds = &Error{Type: NewSpecifier("InsufficientFund"), Description: "insufficient funds"}
+	ErrBadRevisionNumber = &Error{Type: NewSpecifier("BadRevision"), Description: "bad revision number"}
+	ErrLocked            = &Error{Type: NewSpecifier("Locked"), Description: "resource is locked"}
+	ErrNotFound          = &Error{Type: NewSpecifier("NotFound"), Description: "not found"}
+	ErrInvalidRequest    = &Error{Type: NewSpecifier("InvalidRequest"), Description: "invalid request"}
+)
 
 // EncodeTo implements types.EncoderTo.
 func (err *Error) EncodeTo(e *types.Encoder) {
@@ -73,11 +88,32 @@ func (err *Error) Error() string {
 	return err.Description
 }
 
-// Is reports whether this error matches target.
+// Is reports whether this error matches target. If target is an *Error with a
+// non-zero Type, the Types are compared; otherwise, err matches if its
+// Description contains target's Error string.
 func (err *Error) Is(target error) bool {
+	if t, ok := target.(*Error); ok && t.Type != (Specifier{}) {
+		return err.Type == t.Type
+	}
 	return strings.Contains(err.Description, target.Error())
 }
 
+// toError converts err to an *Error. If err wraps an *Error, its Type and Data
+// are retained, and its Description is replaced with err's Error string.
+func toError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var re *Error
+	if errors.As(err, &re) {
+		if re == err {
+			return re
+		}
+		return &Error{Type: re.Type, Data: re.Data, Description: err.Error()}
+	}
+	return &Error{Description: err.Error()}
+}
+
 // rpcResponse is a helper type for encoding and decoding RPC responses.
 type rpcResponse struct {
 	err *Error
@@ -108,16 +144,15 @@ func (resp *rpcResponse) MaxLen() int {
 
 // WriteObject writes obj to w.
 func WriteObject(w io.Writer, obj Object) error {
-	e := types.NewEncoder(w)
+	e := types.NewPooledEncoder(w)
+	defer e.Release()
 	obj.EncodeTo(e)
 	return e.Flush()
 }
 
 // ReadObject reads obj from r.
 func ReadObject(r io.Reader, obj Object) error {
-	d := types.NewDecoder(io.LimitedReader{R: r, N: int64(obj.MaxLen())})
-	obj.DecodeFrom(d)
-	return d.Err()
+	return Limits{}.ReadObject(r, obj)
 }
 
 // WriteRequest sends an RPC request, comprising an RPC ID and an optional
@@ -142,7 +177,7 @@ func ReadID(r io.Reader) (id Specifier, err error) {
 
 // ReadRequest reads an RPC request.
 func ReadRequest(r io.Reader, req Object) error {
-	return ReadObject(r, req)
+	return Limits{}.ReadRequest(r, req)
 }
 
 // WriteResponse writes an RPC response object to w.
@@ -150,24 +185,16 @@ func WriteResponse(w io.Writer, resp Object) error {
 	return WriteObject(w, &rpcResponse{obj: resp})
 }
 
-// WriteResponseErr writes an RPC error to w. If err is an *rpc.Error, it is sent directly; otherwise, a generic
-// rpc.Error is created from err's Error string.
+// WriteResponseErr writes an RPC error to w. If err is an *rpc.Error, it is
+// sent directly; if err wraps an *rpc.Error, its Type and Data are sent along
+// with err's Error string; otherwise, a generic rpc.Error is created from err's
+// Error string.
 func WriteResponseErr(w io.Writer, err error) error {
-	re, ok := err.(*Error)
-	if err != nil && !ok {
-		re = &Error{Description: err.Error()}
-	}
-	return WriteObject(w, &rpcResponse{err: re})
+	return WriteObject(w, &rpcResponse{err: toError(err)})
 }
 
 // ReadResponse reads an RPC response. If the response is an error, it is
 // returned directly.
 func ReadResponse(r io.Reader, resp Object) error {
-	rr := rpcResponse{obj: resp}
-	if err := ReadObject(r, &rr); err != nil {
-		return fmt.Errorf("failed to read message: %w", err)
-	} else if rr.err != nil {
-		return fmt.Errorf("response error: %w", rr.err)
-	}
-	return nil
+	return Limits{}.ReadResponse(r, resp)
 }
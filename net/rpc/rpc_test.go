@@ -0,0 +1,49 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	roundTrip := func(err error) error {
+		var buf bytes.Buffer
+		if err := WriteResponseErr(&buf, err); err != nil {
+			t.Fatal(err)
+		}
+		var resp objString
+		return ReadResponse(&buf, &resp)
+	}
+
+	// a wrapped code survives the round trip, along with the full message
+	err := roundTrip(fmt.Errorf("contract %v: %w", 7, ErrLocked))
+	if !errors.Is(err, ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	} else if errors.Is(err, ErrNotFound) {
+		t.Fatal("error should not match a different code")
+	} else if err.Error() != "response error: contract 7: resource is locked" {
+		t.Fatal("wrong error message:", err)
+	}
+	var re *Error
+	if !errors.As(err, &re) || re.Type != ErrLocked.Type {
+		t.Fatal("expected error to carry ErrLocked code, got", err)
+	}
+
+	// codes are matched regardless of description
+	err = roundTrip(&Error{Type: ErrInsufficientFunds.Type, Data: []byte{1}, Description: "need more"})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatal("expected ErrInsufficientFunds, got", err)
+	} else if !errors.As(err, &re) || !bytes.Equal(re.Data, []byte{1}) {
+		t.Fatal("error data was not preserved")
+	}
+
+	// errors without a code are still matched by description
+	err = roundTrip(errors.New("host is busy"))
+	if !errors.Is(err, errors.New("busy")) {
+		t.Fatal("expected description match, got", err)
+	} else if errors.Is(err, ErrLocked) {
+		t.Fatal("uncoded error should not match a code")
+	}
+}
@@ -0,0 +1,118 @@
+// Package rpctest provides utilities for testing RPC code under adverse
+// network conditions.
+package rpctest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrInjectedReset is returned by a Conn when it simulates a connection reset.
+var ErrInjectedReset = errors.New("rpctest: injected connection reset")
+
+// Faults describes the faults that a Conn injects into its underlying
+// connection.
+type Faults struct {
+	// Latency is added to each Read and Write.
+	Latency time.Duration
+	// Bandwidth limits throughput to the given number of bytes per second,
+	// in each direction. Zero means unlimited.
+	Bandwidth int
+	// ResetProbability is the probability that a Read or Write closes the
+	// connection instead of transferring any data.
+	ResetProbability float64
+	// PartialWriteProbability is the probability that a Write transfers only a
+	// prefix of its data before closing the connection.
+	PartialWriteProbability float64
+	// Seed seeds the random number generator that decides when resets and
+	// partial writes occur. Conns with the same Seed inject the same sequence
+	// of faults.
+	Seed int64
+}
+
+// A Conn is a net.Conn that injects faults into an underlying connection.
+type Conn struct {
+	net.Conn
+	faults Faults
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (c *Conn) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Float64() < p
+}
+
+func (c *Conn) intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rng.Intn(n)
+}
+
+// delay sleeps for the configured latency, plus however long it takes to
+// transfer n bytes at the configured bandwidth.
+func (c *Conn) delay(n int) {
+	d := c.faults.Latency
+	if c.faults.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(c.faults.Bandwidth)
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (c *Conn) reset() error {
+	c.Conn.Close()
+	return ErrInjectedReset
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	if c.roll(c.faults.ResetProbability) {
+		return 0, c.reset()
+	}
+	n, err := c.Conn.Read(p)
+	c.delay(n)
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+	if c.roll(c.faults.ResetProbability) {
+		return 0, c.reset()
+	}
+	if len(p) > 0 && c.roll(c.faults.PartialWriteProbability) {
+		p = p[:c.intn(len(p))]
+		c.delay(len(p))
+		n, _ := c.Conn.Write(p)
+		return n, c.reset()
+	}
+	c.delay(len(p))
+	return c.Conn.Write(p)
+}
+
+// NewConn returns a Conn that injects the specified faults into conn.
+func NewConn(conn net.Conn, faults Faults) *Conn {
+	return &Conn{
+		Conn:   conn,
+		faults: faults,
+		rng:    rand.New(rand.NewSource(faults.Seed)),
+	}
+}
+
+// Pipe is like net.Pipe, but injects the specified faults into both ends of
+// the connection. The two ends use different (but deterministic) seeds.
+func Pipe(faults Faults) (*Conn, *Conn) {
+	c1, c2 := net.Pipe()
+	f2 := faults
+	f2.Seed = ^faults.Seed
+	return NewConn(c1, faults), NewConn(c2, f2)
+}
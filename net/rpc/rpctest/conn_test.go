@@ -0,0 +1,96 @@
+package rpctest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+type objString string
+
+func (s *objString) EncodeTo(e *types.Encoder)   { e.WriteString(string(*s)) }
+func (s *objString) DecodeFrom(d *types.Decoder) { *s = objString(d.ReadString()) }
+func (s *objString) MaxLen() int                 { return 100 }
+
+var rpcGreet = rpc.NewSpecifier("greet")
+
+// greet performs a single RPC over a pipe with the given faults, returning the
+// renter-side and host-side errors.
+func greet(faults Faults) (error, error) {
+	c1, c2 := Pipe(faults)
+	defer c1.Close()
+	defer c2.Close()
+	hostErr := make(chan error, 1)
+	go func() {
+		hostErr <- func() error {
+			c2.SetDeadline(time.Now().Add(time.Second))
+			var name objString
+			if _, err := rpc.ReadID(c2); err != nil {
+				return err
+			} else if err := rpc.ReadRequest(c2, &name); err != nil {
+				return err
+			}
+			greeting := "Hello, " + name
+			return rpc.WriteResponse(c2, &greeting)
+		}()
+	}()
+	c1.SetDeadline(time.Now().Add(time.Second))
+	name := objString("foo")
+	var greeting objString
+	err := rpc.WriteRequest(c1, rpcGreet, &name)
+	if err == nil {
+		err = rpc.ReadResponse(c1, &greeting)
+	}
+	if err == nil && greeting != "Hello, foo" {
+		err = errors.New("unexpected greeting: " + string(greeting))
+	}
+	return err, <-hostErr
+}
+
+func TestConn(t *testing.T) {
+	// no faults
+	if rerr, herr := greet(Faults{}); rerr != nil || herr != nil {
+		t.Fatal(rerr, herr)
+	}
+
+	// latency and bandwidth should slow the RPC down without breaking it
+	start := time.Now()
+	if rerr, herr := greet(Faults{Latency: 10 * time.Millisecond, Bandwidth: 1000}); rerr != nil || herr != nil {
+		t.Fatal(rerr, herr)
+	} else if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("faults did not slow down RPC")
+	}
+
+	// resets should always fail
+	rerr, herr := greet(Faults{ResetProbability: 1})
+	if !errors.Is(rerr, ErrInjectedReset) || herr == nil {
+		t.Fatal("expected injected reset, got", rerr, herr)
+	}
+
+	// partial writes should cause the host to fail while reading the request
+	if rerr, herr := greet(Faults{PartialWriteProbability: 1}); !errors.Is(rerr, ErrInjectedReset) || herr == nil {
+		t.Fatal("expected injected reset, got", rerr, herr)
+	}
+
+	// identical seeds should inject identical faults
+	firstReset := func(seed int64) int {
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		go io.Copy(io.Discard, c2)
+		c := NewConn(c1, Faults{ResetProbability: 0.1, Seed: seed})
+		defer c.Close()
+		for i := 0; ; i++ {
+			if _, err := c.Write([]byte("foo")); err != nil {
+				return i
+			}
+		}
+	}
+	if firstReset(1) != firstReset(1) {
+		t.Fatal("faults were not deterministic")
+	}
+}
@@ -0,0 +1,212 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"go.sia.tech/core/types"
+)
+
+// ErrUnknownRPC is returned by (*Server).Serve when no Handler is registered
+// for the requested RPC.
+var ErrUnknownRPC = errors.New("unknown RPC")
+
+// ErrNotIdempotent is returned by (*Server).Serve when a request carries an
+// IdempotencyKey, but the Server cannot replay responses to the requested RPC.
+var ErrNotIdempotent = errors.New("RPC does not accept idempotency keys")
+
+// A Handler conducts one side of an RPC over a stream.
+type Handler func(rw io.ReadWriter) error
+
+// An Interceptor wraps the Handler for the RPC with the given ID, e.g. to add
+// logging, metrics, or authorization checks. It may call next zero or more
+// times.
+type Interceptor func(id Specifier, next Handler) Handler
+
+// chain wraps h with the given interceptors, such that the first interceptor
+// is outermost.
+func chain(id Specifier, h Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = interceptors[i](id, h)
+	}
+	return h
+}
+
+// An ObjectHandler handles an RPC with a typed request and response. If it
+// returns an error, the error is sent in place of the response.
+type ObjectHandler func(req Object) (resp Object, err error)
+
+// A Server dispatches incoming RPCs to their registered Handlers. Handle and
+// Use must not be called concurrently with Serve.
+type Server struct {
+	// Limits bounds the size of requests read on behalf of ObjectHandlers.
+	Limits Limits
+	// Replay, if non-nil, records the responses to idempotent requests for
+	// RPCs registered with HandleObject, so that they can be replayed when a
+	// client retries. Idempotent requests are rejected if Replay is nil.
+	Replay       *ReplayCache
+	handlers     map[Specifier]Handler
+	idempotent   map[Specifier]func(IdempotencyKey) Handler
+	interceptors []Interceptor
+}
+
+// Handle registers the Handler for the RPC with the given ID. The Handler is
+// called after the ID has been read from the stream.
+func (s *Server) Handle(id Specifier, h Handler) {
+	if s.handlers == nil {
+		s.handlers = make(map[Specifier]Handler)
+	}
+	s.handlers[id] = h
+	delete(s.idempotent, id)
+}
+
+// HandleObject registers an ObjectHandler for the RPC with the given ID. For
+// each request, a new object with the same type as req (which must be a
+// non-nil pointer type, such as (*T)(nil)) is read from the stream, subject to
+// s.Limits, and passed to h. If req is nil, the RPC has no request object, and
+// h is passed nil. If h returns a nil response and a nil error, no response is
+// written.
+//
+// Requests for the RPC may carry an IdempotencyKey, in which case they are
+// served through s.Replay.
+func (s *Server) HandleObject(id Specifier, req Object, h ObjectHandler) {
+	var typ reflect.Type
+	if req != nil {
+		if typ = reflect.TypeOf(req); typ.Kind() != reflect.Ptr {
+			panic("request object must be a pointer") // developer error
+		}
+		typ = typ.Elem()
+	}
+	handle := func(rw io.ReadWriter, key *IdempotencyKey) error {
+		var req Object
+		if typ != nil {
+			req = reflect.New(typ).Interface().(Object)
+			if err := s.Limits.ReadRequest(rw, req); err != nil {
+				err = fmt.Errorf("couldn't read %v request: %w", id, err)
+				WriteResponseErr(rw, err)
+				return err
+			}
+		}
+		respond := func(w io.Writer) error {
+			resp, err := h(req)
+			if err != nil {
+				WriteResponseErr(w, err)
+				return err
+			} else if resp == nil {
+				return nil
+			}
+			return WriteResponse(w, resp)
+		}
+		if key == nil {
+			return respond(rw)
+		}
+		var buf bytes.Buffer
+		if req != nil {
+			e := types.NewEncoder(&buf)
+			req.EncodeTo(e)
+			e.Flush()
+		}
+		err := s.Replay.Serve(rw, id, *key, types.HashBytes(buf.Bytes()), respond)
+		if errors.Is(err, ErrKeyReused) || errors.Is(err, ErrReplayCacheFull) {
+			WriteResponseErr(rw, err)
+		}
+		return err
+	}
+	s.Handle(id, func(rw io.ReadWriter) error { return handle(rw, nil) })
+	if s.idempotent == nil {
+		s.idempotent = make(map[Specifier]func(IdempotencyKey) Handler)
+	}
+	s.idempotent[id] = func(key IdempotencyKey) Handler {
+		return func(rw io.ReadWriter) error { return handle(rw, &key) }
+	}
+}
+
+// Use appends interceptors to the Server's chain. Interceptors also wrap
+// requests for unknown RPCs, so that e.g. logging observes every request.
+func (s *Server) Use(interceptors ...Interceptor) {
+	s.interceptors = append(s.interceptors, interceptors...)
+}
+
+// Serve reads an RPC ID from rw and calls the corresponding Handler, wrapped
+// by the Server's interceptors. If no Handler is registered for the ID, an
+// error response is sent, and ErrUnknownRPC is returned. If the request
+// carries an IdempotencyKey, it is served through s.Replay, provided that the
+// RPC was registered with HandleObject; otherwise, an error response is sent,
+// and ErrNotIdempotent is returned.
+func (s *Server) Serve(rw io.ReadWriter) error {
+	id, key, idempotent, err := ReadIdempotentID(rw)
+	if err != nil {
+		return fmt.Errorf("couldn't read request ID: %w", err)
+	}
+	h, ok := s.handlers[id]
+	if ok && idempotent {
+		if withKey, ok := s.idempotent[id]; ok && s.Replay != nil {
+			h = withKey(key)
+		} else {
+			h = func(rw io.ReadWriter) error {
+				err := fmt.Errorf("%w (%q)", ErrNotIdempotent, id)
+				WriteResponseErr(rw, err)
+				return err
+			}
+		}
+	} else if !ok {
+		h = func(rw io.ReadWriter) error {
+			err := fmt.Errorf("%w %q", ErrUnknownRPC, id)
+			WriteResponseErr(rw, err)
+			return err
+		}
+	}
+	return chain(id, h, s.interceptors)(rw)
+}
+
+// A Client issues RPCs, wrapping each one with its interceptors.
+type Client struct {
+	// Limits bounds the size of responses read by Call.
+	Limits Limits
+	// CompressedResponses causes Call to read responses written with
+	// WriteCompressedResponse. Both peers must have agreed to use compression.
+	CompressedResponses bool
+	interceptors        []Interceptor
+}
+
+// Use appends interceptors to the Client's chain.
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// Call writes an RPC request to rw and, if resp is non-nil, reads the
+// response into resp. The exchange is wrapped by the Client's interceptors.
+func (c *Client) Call(rw io.ReadWriter, id Specifier, req, resp Object) error {
+	return c.call(rw, id, nil, req, resp)
+}
+
+// CallIdempotent is like Call, but tags the request with key. If the call
+// fails, it may be retried with the same key and request; a Server with a
+// ReplayCache will then return its original response rather than handling the
+// request again.
+func (c *Client) CallIdempotent(rw io.ReadWriter, id Specifier, key IdempotencyKey, req, resp Object) error {
+	return c.call(rw, id, &key, req, resp)
+}
+
+func (c *Client) call(rw io.ReadWriter, id Specifier, key *IdempotencyKey, req, resp Object) error {
+	h := func(rw io.ReadWriter) error {
+		var err error
+		if key != nil {
+			err = WriteIdempotentRequest(rw, id, *key, req)
+		} else {
+			err = WriteRequest(rw, id, req)
+		}
+		if err != nil {
+			return err
+		} else if resp == nil {
+			return nil
+		} else if c.CompressedResponses {
+			return c.Limits.ReadCompressedResponse(rw, resp)
+		}
+		return c.Limits.ReadResponse(rw, resp)
+	}
+	return chain(id, h, c.interceptors)(rw)
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInterceptors(t *testing.T) {
+	echoID, otherID := NewSpecifier("Echo"), NewSpecifier("Other")
+	var log []string
+	record := func(name string) Interceptor {
+		return func(id Specifier, next Handler) Handler {
+			return func(rw io.ReadWriter) error {
+				log = append(log, name+" "+id.String())
+				return next(rw)
+			}
+		}
+	}
+
+	var s Server
+	s.Use(record("outer"), record("inner"))
+	s.Handle(echoID, func(rw io.ReadWriter) error {
+		var req objString
+		if err := ReadRequest(rw, &req); err != nil {
+			return err
+		}
+		log = append(log, "handler")
+		return WriteResponse(rw, &req)
+	})
+	var c Client
+	c.Use(record("client"))
+
+	// NOTE: net.Pipe is unsuitable here, since it is unbuffered: a Server
+	// that rejects an RPC would block writing its response while the Client
+	// is still writing the request
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	call := func(id Specifier, req string) (string, error, error) {
+		c1, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c1.Close()
+		c2, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Close()
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.Serve(c2) }()
+		obj, resp := objString(req), objString("")
+		err = c.Call(c1, id, &obj, &resp)
+		return string(resp), err, <-serveErr
+	}
+
+	if resp, err, serr := call(echoID, "foo"); err != nil || serr != nil {
+		t.Fatal(err, serr)
+	} else if resp != "foo" {
+		t.Fatal("wrong response:", resp)
+	}
+	exp := []string{"client Echo", "outer Echo", "inner Echo", "handler"}
+	if !reflect.DeepEqual(log, exp) {
+		t.Fatalf("expected %v, got %v", exp, log)
+	}
+
+	// unknown RPCs are intercepted and rejected
+	log = nil
+	if _, err, serr := call(otherID, "foo"); err == nil || !errors.Is(serr, ErrUnknownRPC) {
+		t.Fatal("expected unknown RPC to be rejected, got", err, serr)
+	}
+	exp = []string{"client Other", "outer Other", "inner Other"}
+	if !reflect.DeepEqual(log, exp) {
+		t.Fatalf("expected %v, got %v", exp, log)
+	}
+
+	// an interceptor can short-circuit the chain
+	s.Use(func(id Specifier, next Handler) Handler {
+		return func(rw io.ReadWriter) error {
+			err := errors.New("denied")
+			WriteResponseErr(rw, err)
+			return err
+		}
+	})
+	log = nil
+	if _, err, serr := call(echoID, "foo"); err == nil || serr == nil || serr.Error() != "denied" {
+		t.Fatal("expected RPC to be denied, got", err, serr)
+	} else if len(log) != 3 {
+		t.Fatal("handler should not have been called:", log)
+	}
+}
+
+func TestHandleObject(t *testing.T) {
+	echoID, failID := NewSpecifier("Echo"), NewSpecifier("Fail")
+	var s Server
+	s.HandleObject(echoID, (*objString)(nil), func(req Object) (Object, error) {
+		return req, nil
+	})
+	s.HandleObject(failID, nil, func(req Object) (Object, error) {
+		if req != nil {
+			t.Error("expected nil request")
+		}
+		return nil, errors.New("failed")
+	})
+	var c Client
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	call := func(id Specifier, req Object) (string, error, error) {
+		c1, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c1.Close()
+		c2, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Close()
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.Serve(c2) }()
+		var resp objString
+		err = c.Call(c1, id, req, &resp)
+		return string(resp), err, <-serveErr
+	}
+
+	req := objString("foo")
+	if resp, err, serr := call(echoID, &req); err != nil || serr != nil {
+		t.Fatal(err, serr)
+	} else if resp != "foo" {
+		t.Fatal("wrong response:", resp)
+	}
+	if _, err, serr := call(failID, nil); err == nil || err.Error() != "response error: failed" || serr == nil {
+		t.Fatal("expected handler error to be sent, got", err, serr)
+	}
+
+	// requests exceeding the server's limits are rejected
+	s.Limits.Override(&req, 2)
+	if _, err, serr := call(echoID, &req); err == nil || serr == nil {
+		t.Fatal("expected oversized request to be rejected, got", err, serr)
+	}
+}
+
+func TestServerIdempotent(t *testing.T) {
+	incrID, rawID := NewSpecifier("Incr"), NewSpecifier("Raw")
+	var count int
+	var s Server
+	s.HandleObject(incrID, (*objString)(nil), func(req Object) (Object, error) {
+		count++
+		resp := objString(string(*req.(*objString)) + strconv.Itoa(count))
+		return &resp, nil
+	})
+	s.Handle(rawID, func(rw io.ReadWriter) error { return nil })
+	var c Client
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	call := func(id Specifier, key IdempotencyKey, req string) (string, error, error) {
+		c1, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c1.Close()
+		c2, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c2.Close()
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.Serve(c2) }()
+		obj, resp := objString(req), objString("")
+		err = c.CallIdempotent(c1, id, key, &obj, &resp)
+		return string(resp), err, <-serveErr
+	}
+
+	// without a ReplayCache, idempotent requests are rejected
+	key := NewIdempotencyKey()
+	if _, err, serr := call(incrID, key, "foo"); err == nil || !errors.Is(serr, ErrNotIdempotent) {
+		t.Fatal("expected idempotent request to be rejected, got", err, serr)
+	}
+
+	s.Replay = NewReplayCache(time.Hour, 10)
+	for i := 0; i < 2; i++ {
+		if resp, err, serr := call(incrID, key, "foo"); err != nil || serr != nil {
+			t.Fatal(err, serr)
+		} else if resp != "foo1" || count != 1 {
+			t.Fatal("expected original response to be replayed, got", resp, count)
+		}
+	}
+	// a different request with the same key is rejected
+	if _, err, serr := call(incrID, key, "bar"); err == nil || !errors.Is(serr, ErrKeyReused) {
+		t.Fatal("expected reused key to be rejected, got", err, serr)
+	}
+	// RPCs registered with Handle cannot be replayed
+	if _, err, serr := call(rawID, NewIdempotencyKey(), "foo"); err == nil || !errors.Is(serr, ErrNotIdempotent) {
+		t.Fatal("expected idempotent request to be rejected, got", err, serr)
+	}
+}
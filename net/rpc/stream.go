@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkSize is the default size of the chunks written by a ChunkWriter.
+const DefaultChunkSize = 1 << 16
+
+// ErrStreamTooLarge is returned by a ChunkReader when the stream exceeds its
+// maximum length.
+var ErrStreamTooLarge = errors.New("stream exceeds maximum length")
+
+// A ChunkWriter writes a stream of length-prefixed chunks, terminated by an
+// empty chunk. This allows large payloads, such as sectors, to be sent without
+// buffering them entirely or knowing their length in advance. Close must be
+// called to terminate the stream.
+type ChunkWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (cw *ChunkWriter) writeChunk(p []byte) error {
+	var prefix [8]byte
+	binary.LittleEndian.PutUint64(prefix[:], uint64(len(p)))
+	if _, err := cw.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(p)
+	return err
+}
+
+// Write implements io.Writer.
+func (cw *ChunkWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		// write full chunks directly, rather than copying them into buf
+		if len(cw.buf) == 0 && len(p) >= cap(cw.buf) {
+			if err := cw.writeChunk(p[:cap(cw.buf)]); err != nil {
+				return n, err
+			}
+			n += cap(cw.buf)
+			p = p[cap(cw.buf):]
+			continue
+		}
+		c := copy(cw.buf[len(cw.buf):cap(cw.buf)], p)
+		cw.buf = cw.buf[:len(cw.buf)+c]
+		n += c
+		p = p[c:]
+		if len(cw.buf) == cap(cw.buf) {
+			if err := cw.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush writes any buffered data as a chunk.
+func (cw *ChunkWriter) Flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	err := cw.writeChunk(cw.buf)
+	cw.buf = cw.buf[:0]
+	return err
+}
+
+// Close flushes any buffered data and terminates the stream. It does not close
+// the underlying writer.
+func (cw *ChunkWriter) Close() error {
+	if err := cw.Flush(); err != nil {
+		return err
+	}
+	return cw.writeChunk(nil)
+}
+
+// NewChunkWriter returns a ChunkWriter that writes chunks of at most chunkSize
+// bytes to w.
+func NewChunkWriter(w io.Writer, chunkSize int) *ChunkWriter {
+	if chunkSize <= 0 {
+		panic("chunk size must be positive") // developer error
+	}
+	return &ChunkWriter{
+		w:   w,
+		buf: make([]byte, 0, chunkSize),
+	}
+}
+
+// A ChunkReader reads a stream written by a ChunkWriter. It enforces the
+// maximum length of the stream as each chunk arrives, rather than after the
+// entire stream has been read. After returning io.EOF, the underlying reader
+// is positioned immediately after the stream.
+type ChunkReader struct {
+	r         io.Reader
+	remaining int // in current chunk
+	left      int // of maxLen
+	err       error
+}
+
+// Read implements io.Reader.
+func (cr *ChunkReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+	if cr.remaining == 0 {
+		var prefix [8]byte
+		if _, err := io.ReadFull(cr.r, prefix[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			cr.err = fmt.Errorf("couldn't read chunk length: %w", err)
+			return 0, cr.err
+		}
+		n := binary.LittleEndian.Uint64(prefix[:])
+		if n == 0 {
+			cr.err = io.EOF
+			return 0, cr.err
+		} else if n > uint64(cr.left) {
+			cr.err = fmt.Errorf("%w (%v bytes)", ErrStreamTooLarge, cr.left)
+			return 0, cr.err
+		}
+		cr.remaining = int(n)
+		cr.left -= int(n)
+	}
+	if len(p) > cr.remaining {
+		p = p[:cr.remaining]
+	}
+	n, err := cr.r.Read(p)
+	cr.remaining -= n
+	if err == io.EOF && cr.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		cr.err = err
+	}
+	return n, cr.err
+}
+
+// NewChunkReader returns a ChunkReader that reads a stream of at most maxLen
+// bytes from r.
+func NewChunkReader(r io.Reader, maxLen int) *ChunkReader {
+	return &ChunkReader{
+		r:    r,
+		left: maxLen,
+	}
+}
+
+// WriteStreamResponse begins a streamed RPC response, returning a ChunkWriter
+// for its payload. The caller must Close the ChunkWriter to complete the
+// response. To send an error instead, call WriteResponseErr without calling
+// WriteStreamResponse.
+func WriteStreamResponse(w io.Writer, chunkSize int) (*ChunkWriter, error) {
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, fmt.Errorf("couldn't write response header: %w", err)
+	}
+	return NewChunkWriter(w, chunkSize), nil
+}
+
+// ReadStreamResponse reads the beginning of a streamed RPC response, returning
+// a ChunkReader for its payload, which may contain at most maxLen bytes
+// (subject to l.Ceiling). If the response is an error, it is returned
+// directly. The caller must read the payload until io.EOF before reading any
+// subsequent objects from r.
+func (l Limits) ReadStreamResponse(r io.Reader, maxLen int) (*ChunkReader, error) {
+	if l.Ceiling > 0 && maxLen > l.Ceiling {
+		maxLen = l.Ceiling
+	}
+	var isErr [1]byte
+	if _, err := io.ReadFull(r, isErr[:]); err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	} else if isErr[0] > 1 {
+		return nil, fmt.Errorf("failed to read message: invalid bool value (%v)", isErr[0])
+	} else if isErr[0] == 1 {
+		re := new(Error)
+		if err := l.ReadObject(r, re); err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		return nil, fmt.Errorf("response error: %w", re)
+	}
+	return NewChunkReader(r, maxLen), nil
+}
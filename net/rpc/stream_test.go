@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestChunkedStream(t *testing.T) {
+	payload := make([]byte, 3*DefaultChunkSize+123)
+	types.ReadEntropy(payload)
+
+	writeStream := func(w io.Writer, data []byte) {
+		cw, err := WriteStreamResponse(w, DefaultChunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// write in uneven pieces to exercise buffering
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := cw.Write(data[:n]); err != nil {
+				t.Fatal(err)
+			}
+			data = data[n:]
+		}
+		if err := cw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeStream(&buf, payload)
+	trailer := objString("trailer")
+	if err := WriteObject(&buf, &trailer); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := Limits{}.ReadStreamResponse(&buf, len(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(cr); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(data, payload) {
+		t.Fatal("payload did not survive round trip")
+	}
+	// the underlying reader should be positioned after the stream
+	var s objString
+	if err := ReadObject(&buf, &s); err != nil || s != trailer {
+		t.Fatal("couldn't read trailing object:", s, err)
+	}
+
+	// oversized streams are rejected as soon as the limit is exceeded
+	buf.Reset()
+	writeStream(&buf, payload)
+	cr, err = Limits{}.ReadStreamResponse(&buf, DefaultChunkSize+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(cr); !errors.Is(err, ErrStreamTooLarge) {
+		t.Fatal("expected ErrStreamTooLarge, got", err)
+	} else if len(data) != DefaultChunkSize {
+		t.Fatal("expected first chunk to be read, got", len(data))
+	}
+
+	// truncated streams are detected
+	buf.Reset()
+	writeStream(&buf, payload)
+	buf.Truncate(buf.Len() - 9)
+	cr, _ = Limits{}.ReadStreamResponse(&buf, len(payload))
+	if _, err := io.ReadAll(cr); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal("expected io.ErrUnexpectedEOF, got", err)
+	}
+
+	// errors are sent in place of the stream
+	buf.Reset()
+	WriteResponseErr(&buf, ErrNotFound)
+	if _, err := (Limits{}).ReadStreamResponse(&buf, len(payload)); !errors.Is(err, ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+}
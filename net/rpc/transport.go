@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/types"
+)
+
+// A StreamConn is a connection to a peer that multiplexes streams, each of
+// which carries a single RPC. A *Transport is a StreamConn; transports with
+// native stream multiplexing can implement it directly.
+type StreamConn interface {
+	DialStream() (net.Conn, error)
+	AcceptStream() (net.Conn, error)
+	Close() error
+}
+
+// CallStream conducts an RPC with c on a new stream of sc. See (*Client).Call.
+func CallStream(sc StreamConn, c *Client, id Specifier, req, resp Object) error {
+	s, err := sc.DialStream()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return c.Call(s, id, req, resp)
+}
+
+// ServeStreams accepts streams from the peer and serves an RPC on each with s,
+// until sc is closed. Errors returned by individual RPCs are not propagated;
+// they have already been sent to the peer.
+func ServeStreams(sc StreamConn, s *Server) error {
+	for {
+		stream, err := sc.AcceptStream()
+		if errors.Is(err, mux.ErrClosedConn) || errors.Is(err, mux.ErrPeerClosedConn) || errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go func() {
+			defer stream.Close()
+			s.Serve(stream)
+		}()
+	}
+}
+
+// A Transport carries RPCs over an encrypted, authenticated connection. It
+// performs an X25519 key exchange, signed by the accepting peer's ed25519 key,
+// and encrypts all subsequent frames with ChaCha20-Poly1305. Each RPC is
+// conducted on its own multiplexed stream.
+type Transport struct {
+	m *mux.Mux
+}
+
+// DialStream opens a new stream on the Transport.
+func (t *Transport) DialStream() (net.Conn, error) {
+	return t.m.DialStream()
+}
+
+// AcceptStream waits for and returns the next stream opened by the peer.
+func (t *Transport) AcceptStream() (net.Conn, error) {
+	return t.m.AcceptStream()
+}
+
+// Close closes the Transport and its underlying connection.
+func (t *Transport) Close() error {
+	return t.m.Close()
+}
+
+// Call conducts an RPC with c on a new stream. See (*Client).Call.
+func (t *Transport) Call(c *Client, id Specifier, req, resp Object) error {
+	return CallStream(t, c, id, req, resp)
+}
+
+// Serve accepts streams from the peer and serves an RPC on each with s, until
+// the Transport is closed. See ServeStreams.
+func (t *Transport) Serve(s *Server) error {
+	return ServeStreams(t, s)
+}
+
+// DialTransport initiates an encrypted transport on conn, authenticating the
+// peer with the given public key.
+func DialTransport(conn net.Conn, theirKey types.PublicKey) (*Transport, error) {
+	m, err := mux.Dial(conn, theirKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{m: m}, nil
+}
+
+// AcceptTransport reciprocates an encrypted transport on conn, authenticating
+// ourselves with the given private key.
+func AcceptTransport(conn net.Conn, ourKey types.PrivateKey) (*Transport, error) {
+	m, err := mux.Accept(conn, ed25519.PrivateKey(ourKey))
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{m: m}, nil
+}
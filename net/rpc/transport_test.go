@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func TestTransport(t *testing.T) {
+	echoID := NewSpecifier("Echo")
+	var s Server
+	s.Handle(echoID, func(rw io.ReadWriter) error {
+		var req objString
+		if err := ReadRequest(rw, &req); err != nil {
+			return err
+		}
+		return WriteResponse(rw, &req)
+	})
+	hostKey := types.GeneratePrivateKey()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- func() error {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return nil
+				}
+				tr, err := AcceptTransport(conn, hostKey)
+				if err != nil {
+					conn.Close()
+					continue
+				}
+				defer tr.Close()
+				go tr.Serve(&s)
+			}
+		}()
+	}()
+
+	dial := func(pub types.PublicKey) (*Transport, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := DialTransport(conn, pub)
+		if err != nil {
+			conn.Close()
+		}
+		return tr, err
+	}
+
+	tr, err := dial(hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	var c Client
+	for _, str := range []string{"foo", "bar"} {
+		req, resp := objString(str), objString("")
+		if err := tr.Call(&c, echoID, &req, &resp); err != nil {
+			t.Fatal(err)
+		} else if resp != req {
+			t.Fatal("wrong response:", resp)
+		}
+	}
+
+	// the host must prove possession of the expected key
+	if _, err := dial(types.GeneratePrivateKey().PublicKey()); err == nil {
+		t.Fatal("expected handshake with wrong host key to fail")
+	}
+
+	l.Close()
+	if err := <-serveErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,450 @@
+// Package websocket adapts WebSocket connections to net.Conn and net.Listener,
+// allowing the renter-host protocol to be carried over HTTP(S) in environments
+// where raw TCP on a custom port is blocked.
+//
+// Only the subset of RFC 6455 needed for a byte stream is implemented: data is
+// sent as binary frames, message boundaries are ignored, and extensions and
+// subprotocols are not supported.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxFramePayload is the largest payload written in a single frame; larger
+// writes are split across multiple frames.
+const maxFramePayload = 1 << 16
+
+// acceptGUID is appended to the client's key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrProtocol is returned when the peer violates the WebSocket protocol.
+var ErrProtocol = errors.New("websocket protocol violation")
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains reports whether the comma-separated header value contains
+// token, ignoring case.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A Conn is a WebSocket connection. It implements net.Conn; deadlines and
+// addresses are those of the underlying connection.
+type Conn struct {
+	net.Conn
+	br     *bufio.Reader
+	client bool // clients mask their frames; servers do not
+
+	rmu       sync.Mutex
+	remaining uint64 // in current data frame
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	rerr      error
+
+	wmu    sync.Mutex
+	wbuf   []byte
+	closed bool
+}
+
+// writeFrame writes a single frame. c.wmu must be held.
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+	buf := append(c.wbuf[:0], 0x80|op) // always FIN
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126, byte(n>>8), byte(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = append(buf, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		types.ReadEntropy(mask[:])
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+	c.wbuf = buf
+	_, err := c.Conn.Write(buf)
+	return err
+}
+
+// Write implements net.Conn.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var n int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxFramePayload {
+			chunk = chunk[:maxFramePayload]
+		}
+		if err := c.writeFrame(opBinary, chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// readHeader reads a frame header, returning its opcode and payload length.
+// c.rmu must be held.
+func (c *Conn) readHeader() (op byte, n uint64, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return 0, 0, err
+	}
+	op = hdr[0] & 0x0F
+	if hdr[0]&0x70 != 0 {
+		return 0, 0, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	c.masked = hdr[1]&0x80 != 0
+	if c.masked == c.client {
+		return 0, 0, fmt.Errorf("%w: incorrect masking", ErrProtocol)
+	}
+	switch n = uint64(hdr[1] & 0x7F); n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, 0, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return 0, 0, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if c.masked {
+		if _, err := io.ReadFull(c.br, c.mask[:]); err != nil {
+			return 0, 0, err
+		}
+	}
+	c.maskPos = 0
+	if op >= opClose && (n > 125 || hdr[0]&0x80 == 0) {
+		return 0, 0, fmt.Errorf("%w: invalid control frame", ErrProtocol)
+	}
+	return op, n, nil
+}
+
+func (c *Conn) unmask(p []byte) {
+	if !c.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= c.mask[c.maskPos%4]
+		c.maskPos++
+	}
+}
+
+// handleControl reads the payload of a control frame and responds to it. c.rmu
+// must be held.
+func (c *Conn) handleControl(op byte, n uint64) error {
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return err
+	}
+	c.unmask(payload)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	switch op {
+	case opPing:
+		return c.writeFrame(opPong, payload)
+	case opPong:
+		return nil
+	case opClose:
+		// echo the status code, if any, then report EOF
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.writeFrame(opClose, payload)
+		c.closed = true
+		return io.EOF
+	default:
+		return fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, op)
+	}
+}
+
+// Read implements net.Conn.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.rerr != nil {
+		return 0, c.rerr
+	}
+	for c.remaining == 0 {
+		op, n, err := c.readHeader()
+		if err != nil {
+			c.rerr = err
+			return 0, err
+		}
+		switch op {
+		case opContinuation, opText, opBinary:
+			c.remaining = n
+		default:
+			if err := c.handleControl(op, n); err != nil {
+				c.rerr = err
+				return 0, err
+			}
+		}
+	}
+	if uint64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.br.Read(p)
+	c.unmask(p[:n])
+	c.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.rerr = err
+	}
+	return n, err
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	if !c.closed {
+		c.writeFrame(opClose, []byte{0x03, 0xE8}) // 1000: normal closure
+		c.closed = true
+	}
+	c.wmu.Unlock()
+	return c.Conn.Close()
+}
+
+// Dial establishes a WebSocket connection to the given ws:// or wss:// URL.
+func Dial(ctx context.Context, rawURL string) (_ *Conn, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	var secure bool
+	switch u.Scheme {
+	case "ws":
+	case "wss":
+		secure = true
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	stop := rpc.WithContext(ctx, conn)
+	defer func() {
+		if ctxErr := stop(); err != nil {
+			if ctxErr != nil {
+				err = fmt.Errorf("%w (%v)", ctxErr, err)
+			}
+			conn.Close()
+		}
+	}()
+	if secure {
+		tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = tc
+	}
+
+	var nonce [16]byte
+	types.ReadEntropy(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+		Host: u.Host,
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("couldn't write upgrade request: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read upgrade response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("server refused upgrade: %v", resp.Status)
+	} else if !headerContains(resp.Header, "Upgrade", "websocket") || !headerContains(resp.Header, "Connection", "upgrade") {
+		return nil, fmt.Errorf("%w: invalid upgrade response headers", ErrProtocol)
+	} else if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrProtocol)
+	}
+	return &Conn{Conn: conn, br: br, client: true}, nil
+}
+
+// Upgrade upgrades an HTTP request to a WebSocket connection. If the request is
+// not a valid WebSocket handshake, an error response is written and an error is
+// returned. The returned Conn is no longer managed by the HTTP server.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, msg string) (*Conn, error) {
+		http.Error(w, msg, status)
+		return nil, fmt.Errorf("%w: %v", ErrProtocol, msg)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet:
+		return fail(http.StatusMethodNotAllowed, "method must be GET")
+	case !headerContains(r.Header, "Upgrade", "websocket") || !headerContains(r.Header, "Connection", "upgrade"):
+		return fail(http.StatusBadRequest, "not a websocket upgrade request")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	case key == "":
+		return fail(http.StatusBadRequest, "missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "connection cannot be hijacked")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("couldn't write upgrade response: %w", err)
+	}
+	return &Conn{Conn: conn, br: brw.Reader, client: false}, nil
+}
+
+// A Listener is a net.Listener whose connections are upgraded from HTTP
+// requests. It implements http.Handler, and only receives connections once it
+// has been registered with an HTTP server; this allows a host to serve the
+// renter-host protocol alongside its other HTTP endpoints.
+type Listener struct {
+	addr      net.Addr
+	conns     chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ServeHTTP implements http.Handler. It blocks until the upgraded connection is
+// returned by Accept or the Listener is closed.
+func (l *Listener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.closed:
+		http.Error(w, "listener closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	conn, err := Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// Accept implements net.Listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener. It does not close connections that have
+// already been accepted.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+// Addr implements net.Listener.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
+
+// NewListener returns a Listener that reports addr as its address, typically
+// the address of the HTTP server it is registered with.
+func NewListener(addr net.Addr) *Listener {
+	return &Listener{
+		addr:   addr,
+		conns:  make(chan *Conn),
+		closed: make(chan struct{}),
+	}
+}
@@ -0,0 +1,173 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.sia.tech/core/net/mux"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+func TestEcho(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, err := Dial(context.Background(), wsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// large enough to span multiple frames and use the 64-bit length
+	data := make([]byte, 3*maxFramePayload+7)
+	types.ReadEntropy(data)
+	go conn.Write(data)
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf, data) {
+		t.Fatal("data did not survive round trip")
+	}
+
+	// pings are answered transparently
+	conn.wmu.Lock()
+	conn.writeFrame(opPing, []byte("ping"))
+	conn.wmu.Unlock()
+	conn.Write([]byte("after"))
+	buf = make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "after" {
+		t.Fatal("unexpected read after ping:", string(buf), err)
+	}
+
+	// plain HTTP requests are rejected
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400, got", resp.Status)
+	}
+}
+
+func TestMuxOverWebSocket(t *testing.T) {
+	serverKey := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		m, err := mux.Accept(conn, serverKey)
+		if err != nil {
+			return
+		}
+		defer m.Close()
+		s, err := m.AcceptStream()
+		if err != nil {
+			return
+		}
+		defer s.Close()
+		io.CopyN(s, s, 5)
+	}))
+	defer srv.Close()
+
+	conn, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := mux.Dial(conn, serverKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	s, err := m.DialStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(s, buf); err != nil || string(buf) != "hello" {
+		t.Fatal("unexpected echo:", string(buf), err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	echoID := rpc.NewSpecifier("Echo")
+	var s rpc.Server
+	s.Handle(echoID, func(rw io.ReadWriter) error {
+		var req rpc.IdempotencyKey
+		if err := rpc.ReadRequest(rw, &req); err != nil {
+			return err
+		}
+		return rpc.WriteResponse(rw, &req)
+	})
+	hostKey := types.GeneratePrivateKey()
+
+	srv := httptest.NewUnstartedServer(nil)
+	l := NewListener(srv.Listener.Addr())
+	srv.Config.Handler = l
+	srv.Start()
+	defer srv.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				tr, err := rpc.AcceptTransport(conn, hostKey)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				defer tr.Close()
+				rpc.ServeStreams(tr, &s)
+			}()
+		}
+	}()
+
+	conn, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := rpc.DialTransport(conn, hostKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	req := rpc.IdempotencyKey{1, 2, 3}
+	var resp rpc.IdempotencyKey
+	if err := rpc.CallStream(tr, &rpc.Client{}, echoID, &req, &resp); err != nil {
+		t.Fatal(err)
+	} else if resp != req {
+		t.Fatal("unexpected echo:", resp)
+	}
+
+	// after the listener is closed, further upgrades are refused
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatal("expected net.ErrClosed, got", err)
+	} else if _, err := Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")); err == nil {
+		t.Fatal("expected dial to fail after close")
+	}
+}
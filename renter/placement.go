@@ -0,0 +1,168 @@
+// Package renter provides helpers for renter implementations that are not part
+// of the renter-host protocol itself, but on which renters benefit from
+// agreeing.
+package renter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"go.sia.tech/core/internal/blake2b"
+	"go.sia.tech/core/types"
+)
+
+// ErrNotEnoughHosts is returned when the candidate hosts cannot satisfy a
+// placement's constraints.
+var ErrNotEnoughHosts = errors.New("not enough hosts to satisfy placement constraints")
+
+// A Host is a candidate for shard placement.
+type Host struct {
+	PublicKey  types.PublicKey
+	NetAddress string
+}
+
+// PlacementOptions constrain shard placement. The zero value of each field
+// selects its default.
+type PlacementOptions struct {
+	// Group assigns each host to a group, e.g. a subnet or geographic region,
+	// and MaxPerGroup limits the number of an object's shards placed within a
+	// single group. The default groups hosts by their /24 (IPv4) or /48 (IPv6)
+	// subnet, as determined by Subnet, and limits each group to one shard.
+	Group       func(Host) string
+	MaxPerGroup int
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts PlacementOptions) Validate() error {
+	if opts.MaxPerGroup < 0 {
+		return fmt.Errorf("negative MaxPerGroup (%v)", opts.MaxPerGroup)
+	}
+	return nil
+}
+
+func (opts PlacementOptions) withDefaults() PlacementOptions {
+	if opts.Group == nil {
+		opts.Group = func(h Host) string { return Subnet(h.NetAddress, 24, 48) }
+	}
+	if opts.MaxPerGroup == 0 {
+		opts.MaxPerGroup = 1
+	}
+	return opts
+}
+
+// Subnet returns the subnet of a host's network address, using the specified
+// prefix lengths for IPv4 and IPv6 addresses. Addresses with a hostname rather
+// than an IP are not resolved, since resolution can differ between renters;
+// instead, the hostname (without its port) is returned.
+func Subnet(netAddress string, ipv4Bits, ipv6Bits int) string {
+	host, _, err := net.SplitHostPort(netAddress)
+	if err != nil {
+		host = netAddress
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.ToLower(host)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(ipv4Bits, 32)), Mask: net.CIDRMask(ipv4Bits, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6Bits, 128)), Mask: net.CIDRMask(ipv6Bits, 128)}).String()
+}
+
+// rank orders hosts by their rendezvous score for key, highest first,
+// discarding duplicates.
+func rank(key types.Hash256, hosts []Host) []Host {
+	type scoredHost struct {
+		Host
+		score [32]byte
+	}
+	seen := make(map[types.PublicKey]bool, len(hosts))
+	scored := make([]scoredHost, 0, len(hosts))
+	for _, h := range hosts {
+		if !seen[h.PublicKey] {
+			seen[h.PublicKey] = true
+			scored = append(scored, scoredHost{h, blake2b.SumPair(key, h.PublicKey)})
+		}
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		return bytes.Compare(scored[i].score[:], scored[j].score[:]) > 0
+	})
+	ranked := make([]Host, len(scored))
+	for i := range scored {
+		ranked[i] = scored[i].Host
+	}
+	return ranked
+}
+
+// PlaceShards deterministically assigns n shards of the object identified by
+// key to distinct hosts, subject to the constraints in opts. Placement uses
+// rendezvous hashing: hosts are ranked by the hash of key and their public key,
+// and each shard is assigned to the highest-ranked host that does not violate
+// a constraint. Consequently, renters with the same candidate hosts produce the
+// same placement, and adding or removing a host affects the placement of few
+// objects.
+func PlaceShards(key types.Hash256, n int, hosts []Host, opts PlacementOptions) ([]types.PublicKey, error) {
+	placement, _, err := Rebalance(key, make([]types.PublicKey, n), hosts, opts)
+	return placement, err
+}
+
+// A ShardMove describes a shard that must be migrated from one host to another.
+// From is the zero key if the shard is not currently stored on any host.
+type ShardMove struct {
+	Shard int
+	From  types.PublicKey
+	To    types.PublicKey
+}
+
+// Rebalance updates the placement of an object's shards after the set of
+// candidate hosts has changed. current[i] is the host currently storing shard
+// i, or the zero key if shard i is not stored. Shards whose host remains a
+// candidate stay where they are, unless their host violates a constraint;
+// the remaining shards are placed as in PlaceShards. Rebalance returns the new
+// placement, along with the moves required to achieve it.
+func Rebalance(key types.Hash256, current []types.PublicKey, hosts []Host, opts PlacementOptions) ([]types.PublicKey, []ShardMove, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+
+	candidates := make(map[types.PublicKey]Host, len(hosts))
+	for _, h := range hosts {
+		candidates[h.PublicKey] = h
+	}
+	used := make(map[types.PublicKey]bool)
+	groups := make(map[string]int)
+	placement := make([]types.PublicKey, len(current))
+	for i, pk := range current {
+		h, ok := candidates[pk]
+		if !ok || used[pk] || groups[opts.Group(h)] >= opts.MaxPerGroup {
+			continue
+		}
+		placement[i] = pk
+		used[pk] = true
+		groups[opts.Group(h)]++
+	}
+
+	var moves []ShardMove
+	ranked := rank(key, hosts)
+	for i := range placement {
+		if placement[i] != (types.PublicKey{}) {
+			continue
+		}
+		for len(ranked) > 0 && (used[ranked[0].PublicKey] || groups[opts.Group(ranked[0])] >= opts.MaxPerGroup) {
+			ranked = ranked[1:]
+		}
+		if len(ranked) == 0 {
+			return nil, nil, fmt.Errorf("%w: placed %v of %v shards", ErrNotEnoughHosts, len(used), len(placement))
+		}
+		h := ranked[0]
+		placement[i] = h.PublicKey
+		used[h.PublicKey] = true
+		groups[opts.Group(h)]++
+		moves = append(moves, ShardMove{Shard: i, From: current[i], To: h.PublicKey})
+	}
+	return placement, moves, nil
+}
@@ -0,0 +1,131 @@
+package renter
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+func testHosts(n int) []Host {
+	hosts := make([]Host, n)
+	for i := range hosts {
+		hosts[i] = Host{
+			PublicKey:  types.GeneratePrivateKey().PublicKey(),
+			NetAddress: fmt.Sprintf("10.0.%d.1:9982", i),
+		}
+	}
+	return hosts
+}
+
+func TestSubnet(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"1.2.3.4:9982", "1.2.3.0/24"},
+		{"[2001:db8::1]:9982", "2001:db8::/48"},
+		{"Host.Example.com:9982", "host.example.com"},
+		{"1.2.3.4", "1.2.3.0/24"},
+	}
+	for _, test := range tests {
+		if got := Subnet(test.addr, 24, 48); got != test.want {
+			t.Errorf("Subnet(%q): expected %q, got %q", test.addr, test.want, got)
+		}
+	}
+}
+
+func TestPlaceShards(t *testing.T) {
+	hosts := testHosts(20)
+	key := types.HashBytes([]byte("object"))
+
+	placement, err := PlaceShards(key, 10, hosts, PlacementOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[types.PublicKey]bool)
+	for _, pk := range placement {
+		if seen[pk] {
+			t.Fatal("host used twice")
+		}
+		seen[pk] = true
+	}
+
+	// placement should not depend on the order of the candidates
+	reversed := make([]Host, len(hosts))
+	for i := range hosts {
+		reversed[len(hosts)-i-1] = hosts[i]
+	}
+	if p, err := PlaceShards(key, 10, reversed, PlacementOptions{}); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(p, placement) {
+		t.Fatal("placement depends on host order")
+	}
+
+	// at most one host per subnet by default
+	for i := range hosts {
+		hosts[i].NetAddress = fmt.Sprintf("10.0.%d.%d:9982", i%5, i)
+	}
+	if _, err := PlaceShards(key, 6, hosts, PlacementOptions{}); !errors.Is(err, ErrNotEnoughHosts) {
+		t.Fatalf("expected %v, got %v", ErrNotEnoughHosts, err)
+	} else if _, err := PlaceShards(key, 10, hosts, PlacementOptions{MaxPerGroup: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// custom groups
+	region := func(h Host) string { return string(h.PublicKey[0] % 2) }
+	if _, err := PlaceShards(key, 3, hosts, PlacementOptions{Group: region}); !errors.Is(err, ErrNotEnoughHosts) {
+		t.Fatalf("expected %v, got %v", ErrNotEnoughHosts, err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	hosts := testHosts(20)
+	key := types.HashBytes([]byte("object"))
+	placement, err := PlaceShards(key, 10, hosts, PlacementOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unchanged hosts should require no moves
+	if p, moves, err := Rebalance(key, placement, hosts, PlacementOptions{}); err != nil {
+		t.Fatal(err)
+	} else if len(moves) != 0 || !reflect.DeepEqual(p, placement) {
+		t.Fatal("expected no moves", moves)
+	}
+
+	// removing a host should move only its shard
+	var remaining []Host
+	for _, h := range hosts {
+		if h.PublicKey != placement[3] {
+			remaining = append(remaining, h)
+		}
+	}
+	p, moves, err := Rebalance(key, placement, remaining, PlacementOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(moves) != 1 || moves[0].Shard != 3 || moves[0].From != placement[3] || moves[0].To != p[3] {
+		t.Fatal("expected one move", moves)
+	}
+	for i := range p {
+		if i != 3 && p[i] != placement[i] {
+			t.Fatal("unexpected move of shard", i)
+		}
+	}
+
+	// hosts that now share a subnet should be split up
+	for i := range remaining {
+		if remaining[i].PublicKey == p[1] {
+			remaining[i].NetAddress = "10.0.99.1:9982"
+		} else if remaining[i].PublicKey == p[2] {
+			remaining[i].NetAddress = "10.0.99.2:9982"
+		}
+	}
+	if _, moves, err := Rebalance(key, p, remaining, PlacementOptions{}); err != nil {
+		t.Fatal(err)
+	} else if len(moves) != 1 || moves[0].Shard != 2 {
+		t.Fatal("expected shard 2 to move", moves)
+	}
+}
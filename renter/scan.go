@@ -0,0 +1,283 @@
+package renter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+type announcement struct {
+	height     uint64
+	netAddress string
+}
+
+// Announcements tracks the most recent network address announced by each host
+// on the best chain. It implements chain.Subscriber.
+type Announcements struct {
+	mu    sync.Mutex
+	hosts map[types.PublicKey][]announcement // oldest first
+}
+
+// ProcessChainApplyUpdate implements chain.Subscriber.
+func (a *Announcements) ProcessChainApplyUpdate(cau *chain.ApplyUpdate, _ bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.hosts == nil {
+		a.hosts = make(map[types.PublicKey][]announcement)
+	}
+	for _, ha := range rhp.AppliedHostAnnouncements(cau.ApplyUpdate) {
+		a.hosts[ha.PublicKey] = append(a.hosts[ha.PublicKey], announcement{cau.Block.Header.Height, ha.NetAddress})
+	}
+	return nil
+}
+
+// ProcessChainRevertUpdate implements chain.Subscriber.
+func (a *Announcements) ProcessChainRevertUpdate(cru *chain.RevertUpdate) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	height := cru.Block.Header.Height
+	for _, att := range cru.Attestations {
+		ha, ok := rhp.ParseHostAnnouncement(att)
+		if !ok {
+			continue
+		}
+		anns := a.hosts[ha.PublicKey]
+		for len(anns) > 0 && anns[len(anns)-1].height >= height {
+			anns = anns[:len(anns)-1]
+		}
+		if len(anns) == 0 {
+			delete(a.hosts, ha.PublicKey)
+		} else {
+			a.hosts[ha.PublicKey] = anns
+		}
+	}
+	return nil
+}
+
+// Hosts returns the latest announcement of each host, ordered by public key.
+func (a *Announcements) Hosts() []rhp.HostAnnouncement {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	has := make([]rhp.HostAnnouncement, 0, len(a.hosts))
+	for pk, anns := range a.hosts {
+		has = append(has, rhp.HostAnnouncement{PublicKey: pk, NetAddress: anns[len(anns)-1].netAddress})
+	}
+	sort.Slice(has, func(i, j int) bool {
+		return bytes.Compare(has[i].PublicKey[:], has[j].PublicKey[:]) < 0
+	})
+	return has
+}
+
+// ScanHost connects to an announced host and requests its settings and a
+// signed price table. Hosts that do not support the PriceTable RPC are still
+// scanned successfully, with a nil price table.
+func ScanHost(ha rhp.HostAnnouncement, timeout time.Duration) (rhp.HostSettings, *rhp.PriceTable, error) {
+	conn, err := net.DialTimeout("tcp", ha.NetAddress, timeout)
+	if err != nil {
+		return rhp.HostSettings{}, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	sess, err := rhp.DialSessionWithOptions(conn, ha.PublicKey, rhp.SessionOptions{HandshakeTimeout: timeout})
+	if err != nil {
+		return rhp.HostSettings{}, nil, err
+	}
+	defer sess.Close()
+	settings, err := sess.Settings()
+	if err != nil {
+		return rhp.HostSettings{}, nil, err
+	}
+	pt, err := sess.PriceTable(ha.PublicKey)
+	var re *rpc.Error
+	if errors.As(err, &re) {
+		// the host rejected the RPC, most likely because it does not support it
+		return settings, nil, nil
+	} else if err != nil {
+		return rhp.HostSettings{}, nil, fmt.Errorf("couldn't fetch price table: %w", err)
+	}
+	return settings, &pt, nil
+}
+
+// ScanOptions configures a network scan. The zero value of each field selects
+// its default.
+type ScanOptions struct {
+	// Workers is the maximum number of hosts scanned concurrently. The default
+	// is 10.
+	Workers int
+	// Interval is the minimum time between starting successive scans, limiting
+	// the rate at which hosts are contacted. The default is no limit.
+	Interval time.Duration
+	// Timeout bounds the time spent scanning each host with ScanHost. The
+	// default is 30 seconds. It is ignored if ScanFunc is set.
+	Timeout time.Duration
+	// ScanFunc fetches a host's settings and, if available, its price table.
+	// The default is ScanHost.
+	ScanFunc func(rhp.HostAnnouncement) (rhp.HostSettings, *rhp.PriceTable, error)
+}
+
+// Validate returns an error if opts contains invalid values.
+func (opts ScanOptions) Validate() error {
+	if opts.Workers < 0 {
+		return fmt.Errorf("negative worker count (%v)", opts.Workers)
+	} else if opts.Interval < 0 {
+		return fmt.Errorf("negative interval (%v)", opts.Interval)
+	} else if opts.Timeout < 0 {
+		return fmt.Errorf("negative timeout (%v)", opts.Timeout)
+	}
+	return nil
+}
+
+func (opts ScanOptions) withDefaults() ScanOptions {
+	if opts.Workers == 0 {
+		opts.Workers = 10
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.ScanFunc == nil {
+		timeout := opts.Timeout
+		opts.ScanFunc = func(ha rhp.HostAnnouncement) (rhp.HostSettings, *rhp.PriceTable, error) {
+			return ScanHost(ha, timeout)
+		}
+	}
+	return opts
+}
+
+// A HostScan is the result of scanning a host. Settings and PriceTable are valid
+// only if Err is nil; PriceTable is nil if the host did not provide one.
+type HostScan struct {
+	rhp.HostAnnouncement
+	Settings   rhp.HostSettings
+	PriceTable *rhp.PriceTable
+	Err        error
+	Duration   time.Duration
+}
+
+// Scan fetches the settings and price table of each host concurrently,
+// returning the results in the same order as hosts.
+func Scan(hosts []rhp.HostAnnouncement, opts ScanOptions) ([]HostScan, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+	opts = opts.withDefaults()
+
+	scans := make([]HostScan, len(hosts))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range work {
+				start := time.Now()
+				settings, pt, err := opts.ScanFunc(hosts[j])
+				scans[j] = HostScan{
+					HostAnnouncement: hosts[j],
+					Settings:         settings,
+					PriceTable:       pt,
+					Err:              err,
+					Duration:         time.Since(start),
+				}
+			}
+		}()
+	}
+	for i := range hosts {
+		if i > 0 && opts.Interval > 0 {
+			time.Sleep(opts.Interval)
+		}
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return scans, nil
+}
+
+// NetworkStats are aggregate statistics computed from a network scan. Prices
+// are medians over the hosts that are accepting contracts, taken from each
+// host's price table if it provided one. Storage totals
+// saturate at math.MaxUint64.
+type NetworkStats struct {
+	Hosts              int
+	Online             int
+	AcceptingContracts int
+
+	TotalStorage     uint64
+	RemainingStorage uint64
+
+	ContractFee            types.Currency
+	Collateral             types.Currency
+	StoragePrice           types.Currency
+	UploadBandwidthPrice   types.Currency
+	DownloadBandwidthPrice types.Currency
+}
+
+func medianCurrency(cs []types.Currency) types.Currency {
+	if len(cs) == 0 {
+		return types.ZeroCurrency
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Cmp(cs[j]) < 0 })
+	if len(cs)%2 == 1 {
+		return cs[len(cs)/2]
+	}
+	// halve each value before adding them, since prices reported by hosts are
+	// untrusted and their sum may overflow
+	a, b := cs[len(cs)/2-1], cs[len(cs)/2]
+	m := a.Div64(2).Add(b.Div64(2))
+	if a.Lo&1 == 1 && b.Lo&1 == 1 {
+		m = m.Add(types.NewCurrency64(1))
+	}
+	return m
+}
+
+// addSaturating returns a+b, or math.MaxUint64 if the sum overflows.
+func addSaturating(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+// AggregateScans computes network-wide statistics from a set of host scans.
+func AggregateScans(scans []HostScan) NetworkStats {
+	stats := NetworkStats{Hosts: len(scans)}
+	var contractFees, collaterals, storage, upload, download []types.Currency
+	for _, s := range scans {
+		if s.Err != nil {
+			continue
+		}
+		stats.Online++
+		stats.TotalStorage = addSaturating(stats.TotalStorage, s.Settings.TotalStorage)
+		stats.RemainingStorage = addSaturating(stats.RemainingStorage, s.Settings.RemainingStorage)
+		if !s.Settings.AcceptingContracts {
+			continue
+		}
+		stats.AcceptingContracts++
+		prices := s.Settings
+		if s.PriceTable != nil {
+			prices = s.PriceTable.Settings
+		}
+		contractFees = append(contractFees, prices.ContractFee)
+		collaterals = append(collaterals, prices.Collateral)
+		storage = append(storage, prices.StoragePrice)
+		upload = append(upload, prices.UploadBandwidthPrice)
+		download = append(download, prices.DownloadBandwidthPrice)
+	}
+	stats.ContractFee = medianCurrency(contractFees)
+	stats.Collateral = medianCurrency(collaterals)
+	stats.StoragePrice = medianCurrency(storage)
+	stats.UploadBandwidthPrice = medianCurrency(upload)
+	stats.DownloadBandwidthPrice = medianCurrency(download)
+	return stats
+}
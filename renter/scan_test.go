@@ -0,0 +1,190 @@
+package renter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/net/rhp"
+	"go.sia.tech/core/net/rpc"
+	"go.sia.tech/core/types"
+)
+
+func TestAnnouncements(t *testing.T) {
+	vc := consensus.ValidationContext{}
+	k1, k2 := types.GeneratePrivateKey(), types.GeneratePrivateKey()
+	announce := func(priv types.PrivateKey, addr string) types.Attestation {
+		a, err := rhp.NewHostAnnouncement(vc, priv, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	blocks := [][]types.Attestation{
+		{announce(k1, "foo.com:9982")},
+		{announce(k2, "bar.com:9982"), {Key: "other"}},
+		{announce(k1, "baz.com:9982")},
+	}
+	var a Announcements
+	for i, atts := range blocks {
+		cau := &chain.ApplyUpdate{
+			ApplyUpdate: consensus.ApplyUpdate{Attestations: atts},
+			Block:       types.Block{Header: types.BlockHeader{Height: uint64(i + 1)}},
+		}
+		if err := a.ProcessChainApplyUpdate(cau, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addrs := func() map[types.PublicKey]string {
+		m := make(map[types.PublicKey]string)
+		for _, ha := range a.Hosts() {
+			m[ha.PublicKey] = ha.NetAddress
+		}
+		return m
+	}
+	exp := map[types.PublicKey]string{k1.PublicKey(): "baz.com:9982", k2.PublicKey(): "bar.com:9982"}
+	if got := addrs(); !reflect.DeepEqual(got, exp) {
+		t.Fatal("wrong announcements", got)
+	}
+
+	// reverting the last two blocks should restore the first announcement
+	for i := len(blocks); i > 1; i-- {
+		cru := &chain.RevertUpdate{
+			RevertUpdate: consensus.RevertUpdate{Attestations: blocks[i-1]},
+			Block:        types.Block{Header: types.BlockHeader{Height: uint64(i)}},
+		}
+		if err := a.ProcessChainRevertUpdate(cru); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exp = map[types.PublicKey]string{k1.PublicKey(): "foo.com:9982"}
+	if got := addrs(); !reflect.DeepEqual(got, exp) {
+		t.Fatal("wrong announcements after revert", got)
+	}
+}
+
+func TestScanHost(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	settings := rhp.HostSettings{AcceptingContracts: true, StoragePrice: types.Siacoins(1), Version: "1.0.0", SectorSize: rhp.SectorSize}
+	ptSettings := settings
+	ptSettings.StoragePrice = types.Siacoins(2)
+	ptSettings.ValidUntil = time.Unix(time.Now().Add(time.Hour).Unix(), 0)
+	listen := func(priceTables bool) rhp.HostAnnouncement {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			sess, err := rhp.AcceptSession(conn, hostKey)
+			if err != nil {
+				return
+			}
+			defer sess.Close()
+			for {
+				stream, err := sess.AcceptStream()
+				if err != nil {
+					return
+				}
+				switch id, _ := rpc.ReadID(stream); {
+				case id == rhp.RPCSettingsID:
+					rpc.WriteResponse(stream, &settings)
+				case id == rhp.RPCPriceTableID && priceTables:
+					pt := rhp.NewPriceTable(hostKey, ptSettings)
+					rpc.WriteResponse(stream, &pt)
+				default:
+					rpc.WriteResponseErr(stream, fmt.Errorf("unknown RPC ID %q", id))
+				}
+				stream.Close()
+			}
+		}()
+		return rhp.HostAnnouncement{PublicKey: hostKey.PublicKey(), NetAddress: l.Addr().String()}
+	}
+
+	scans, err := Scan([]rhp.HostAnnouncement{listen(true)}, ScanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if scans[0].Err != nil {
+		t.Fatal(scans[0].Err)
+	} else if !reflect.DeepEqual(scans[0].Settings, settings) {
+		t.Fatal("wrong settings", scans[0].Settings)
+	} else if pt := scans[0].PriceTable; pt == nil || !pt.Settings.ValidUntil.Equal(ptSettings.ValidUntil) {
+		t.Fatal("wrong price table", scans[0].PriceTable)
+	} else if stats := AggregateScans(scans); !stats.StoragePrice.Equals(ptSettings.StoragePrice) {
+		t.Fatal("expected prices to be taken from price table", stats.StoragePrice)
+	}
+
+	// a host that does not support price tables should still be scanned
+	if s, pt, err := ScanHost(listen(false), 10*time.Second); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(s, settings) || pt != nil {
+		t.Fatal("wrong scan result", s, pt)
+	}
+}
+
+func TestAggregateScans(t *testing.T) {
+	hosts := make([]rhp.HostAnnouncement, 6)
+	for i := range hosts {
+		hosts[i].PublicKey[0] = byte(i)
+	}
+	scan := func(ha rhp.HostAnnouncement) (rhp.HostSettings, *rhp.PriceTable, error) {
+		i := int(ha.PublicKey[0])
+		if i == 0 {
+			return rhp.HostSettings{}, nil, errors.New("offline")
+		}
+		return rhp.HostSettings{
+			AcceptingContracts: i != 1,
+			TotalStorage:       100,
+			RemainingStorage:   uint64(i),
+			StoragePrice:       types.Siacoins(uint32(i)),
+		}, nil, nil
+	}
+	scans, err := Scan(hosts, ScanOptions{Workers: 2, ScanFunc: scan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range scans {
+		if s.PublicKey != hosts[i].PublicKey {
+			t.Fatal("scans out of order")
+		}
+	}
+	stats := AggregateScans(scans)
+	switch {
+	case stats.Hosts != 6 || stats.Online != 5 || stats.AcceptingContracts != 4:
+		t.Fatal("wrong host counts", stats)
+	case stats.TotalStorage != 500 || stats.RemainingStorage != 15:
+		t.Fatal("wrong storage totals", stats)
+	case !stats.StoragePrice.Equals(types.Siacoins(7).Div64(2)):
+		t.Fatal("wrong median storage price", stats.StoragePrice)
+	}
+
+	// a host reporting huge values must not crash or wrap the aggregation
+	maxCurrency := types.NewCurrency(math.MaxUint64, math.MaxUint64)
+	scans = append(scans[:0], HostScan{Settings: rhp.HostSettings{
+		AcceptingContracts: true,
+		TotalStorage:       math.MaxUint64,
+		StoragePrice:       maxCurrency,
+	}}, HostScan{Settings: rhp.HostSettings{
+		AcceptingContracts: true,
+		TotalStorage:       100,
+		StoragePrice:       maxCurrency.Sub(types.NewCurrency64(2)),
+	}})
+	stats = AggregateScans(scans)
+	switch {
+	case stats.TotalStorage != math.MaxUint64:
+		t.Fatal("storage total should saturate", stats.TotalStorage)
+	case !stats.StoragePrice.Equals(maxCurrency.Sub(types.NewCurrency64(1))):
+		t.Fatal("wrong median storage price", stats.StoragePrice)
+	}
+}
@@ -0,0 +1,127 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// This file implements the bech32m encoding described in BIP-350, which is
+// used for the human-facing form of addresses. The checksum guarantees
+// detection of any error affecting at most 4 characters, and makes it very
+// unlikely that other typos go unnoticed.
+
+const (
+	bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	bech32mConst  = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range gen {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	exp := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		exp = append(exp, hrp[i]>>5)
+	}
+	exp = append(exp, 0)
+	for i := 0; i < len(hrp); i++ {
+		exp = append(exp, hrp[i]&31)
+	}
+	return exp
+}
+
+// convertBits regroups data from groups of fromBits bits into groups of toBits
+// bits. If pad is false, any leftover bits must be zero, and fewer than
+// fromBits in number.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, v := range data {
+		if uint(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
+
+// bech32mEncode encodes data using the bech32m format with the given
+// human-readable prefix.
+func bech32mEncode(hrp string, data []byte) string {
+	values, _ := convertBits(data, 8, 5, true)
+	poly := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ bech32mConst
+	var sb strings.Builder
+	sb.Grow(len(hrp) + 1 + len(values) + 6)
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(poly>>(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// bech32mDecode decodes a bech32m string, returning its human-readable prefix
+// and data.
+func bech32mDecode(s string) (string, []byte, error) {
+	if len(s) > 90 {
+		return "", nil, errors.New("string too long")
+	} else if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("string has mixed case")
+	}
+	s = strings.ToLower(s)
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", nil, errors.New("invalid separator position")
+	}
+	hrp := s[:sep]
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", nil, fmt.Errorf("invalid character %q in prefix", hrp[i])
+		}
+	}
+	values := make([]byte, len(s)-sep-1)
+	for i := range values {
+		c := strings.IndexByte(bech32Charset, s[sep+1+i])
+		if c < 0 {
+			return "", nil, fmt.Errorf("invalid character %q", s[sep+1+i])
+		}
+		values[i] = byte(c)
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != bech32mConst {
+		return "", nil, errors.New("bad checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
@@ -0,0 +1,448 @@
+// Package cbor implements a CBOR (RFC 8949) encoding of core types, so that
+// chain data can be consumed with off-the-shelf tooling rather than a
+// reimplementation of the binary encoding used by the types package.
+//
+// Structs are encoded as maps, keyed by the same names used in their JSON
+// encoding, with keys sorted in the RFC 8949 deterministic order. Fixed-size
+// arrays (hashes, addresses, keys, signatures) and byte slices are encoded as
+// byte strings. Currency values are encoded as unsigned integers, or as
+// bignums (tag 2) if they do not fit in 64 bits. Timestamps are encoded as
+// epoch-based date/times (tag 1), and spend policies as text strings in the
+// policy language accepted by types.ParseSpendPolicy.
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.sia.tech/core/types"
+)
+
+// CBOR major types.
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// CBOR tags and simple values.
+const (
+	tagEpochTime = 1
+	tagBignum    = 2
+
+	simpleFalse = 20
+	simpleTrue  = 21
+	simpleNull  = 22
+)
+
+var (
+	currencyType = reflect.TypeOf(types.Currency{})
+	timeType     = reflect.TypeOf(time.Time{})
+	policyType   = reflect.TypeOf((*types.SpendPolicy)(nil)).Elem()
+)
+
+// A field is a struct field, possibly promoted from an embedded struct.
+type field struct {
+	name  string
+	index []int
+}
+
+// fieldName returns the name of a struct field in the encoding: its JSON name
+// if it has one, and otherwise its Go name with leading capitals lowercased.
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	if strings.ToUpper(f.Name) == f.Name {
+		return strings.ToLower(f.Name)
+	}
+	r := []rune(f.Name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// fields returns the fields of t in encoding order.
+func fields(t reflect.Type) []field {
+	var fs []field
+	var visit func(t reflect.Type, index []int)
+	visit = func(t reflect.Type, index []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			idx := append(append([]int(nil), index...), i)
+			if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				continue
+			} else if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+				visit(f.Type, idx)
+				continue
+			}
+			fs = append(fs, field{fieldName(f), idx})
+		}
+	}
+	visit(t, nil)
+	// deterministic order: shorter keys first, then lexicographic
+	sort.Slice(fs, func(i, j int) bool {
+		if len(fs[i].name) != len(fs[j].name) {
+			return len(fs[i].name) < len(fs[j].name)
+		}
+		return fs[i].name < fs[j].name
+	})
+	return fs
+}
+
+// An encoder writes CBOR to a buffer.
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeHead(major byte, n uint64) {
+	var buf [9]byte
+	switch {
+	case n < 24:
+		buf[0] = major<<5 | byte(n)
+		e.buf.Write(buf[:1])
+	case n <= math.MaxUint8:
+		buf[0], buf[1] = major<<5|24, byte(n)
+		e.buf.Write(buf[:2])
+	case n <= math.MaxUint16:
+		buf[0] = major<<5 | 25
+		binary.BigEndian.PutUint16(buf[1:], uint16(n))
+		e.buf.Write(buf[:3])
+	case n <= math.MaxUint32:
+		buf[0] = major<<5 | 26
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.buf.Write(buf[:5])
+	default:
+		buf[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(buf[1:], n)
+		e.buf.Write(buf[:9])
+	}
+}
+
+func (e *encoder) writeBytes(major byte, b []byte) {
+	e.writeHead(major, uint64(len(b)))
+	e.buf.Write(b)
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	switch v.Type() {
+	case currencyType:
+		c := v.Interface().(types.Currency)
+		if c.Hi == 0 {
+			e.writeHead(majorUint, c.Lo)
+			return nil
+		}
+		var b [16]byte
+		binary.BigEndian.PutUint64(b[:8], c.Hi)
+		binary.BigEndian.PutUint64(b[8:], c.Lo)
+		e.writeHead(majorTag, tagBignum)
+		e.writeBytes(majorBytes, bytes.TrimLeft(b[:], "\x00"))
+		return nil
+	case timeType:
+		e.writeHead(majorTag, tagEpochTime)
+		if secs := v.Interface().(time.Time).Unix(); secs < 0 {
+			e.writeHead(majorNegInt, uint64(-1-secs))
+		} else {
+			e.writeHead(majorUint, uint64(secs))
+		}
+		return nil
+	case policyType:
+		if v.IsNil() {
+			e.writeHead(majorSimple, simpleNull)
+			return nil
+		}
+		e.writeBytes(majorText, []byte(v.Interface().(types.SpendPolicy).String()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeHead(majorSimple, simpleTrue)
+		} else {
+			e.writeHead(majorSimple, simpleFalse)
+		}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		e.writeHead(majorUint, v.Uint())
+	case reflect.String:
+		e.writeBytes(majorText, []byte(v.String()))
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot encode %v", v.Type())
+		}
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		e.writeBytes(majorBytes, b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(majorBytes, v.Bytes())
+			return nil
+		}
+		e.writeHead(majorArray, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fs := fields(v.Type())
+		e.writeHead(majorMap, uint64(len(fs)))
+		for _, f := range fs {
+			e.writeBytes(majorText, []byte(f.name))
+			if err := e.encode(v.FieldByIndex(f.index)); err != nil {
+				return fmt.Errorf("%v: %w", f.name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("cannot encode %v", v.Type())
+	}
+	return nil
+}
+
+// Marshal returns the CBOR encoding of v, which must be a core type such as a
+// types.Block, types.Transaction, or element, or a slice of such types.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+// ErrTrailingData is returned by Unmarshal when bytes remain after decoding a
+// value.
+var ErrTrailingData = errors.New("trailing data after CBOR value")
+
+// A decoder reads CBOR from a buffer.
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) readHead() (major byte, n uint64, err error) {
+	if len(d.buf) == 0 {
+		return 0, 0, errors.New("unexpected end of input")
+	}
+	major, info := d.buf[0]>>5, d.buf[0]&0x1f
+	d.buf = d.buf[1:]
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	default:
+		return 0, 0, fmt.Errorf("unsupported additional info %v", info)
+	}
+	if len(d.buf) < size {
+		return 0, 0, errors.New("unexpected end of input")
+	}
+	for _, b := range d.buf[:size] {
+		n = n<<8 | uint64(b)
+	}
+	d.buf = d.buf[size:]
+	return major, n, nil
+}
+
+func (d *decoder) expectHead(major byte) (uint64, error) {
+	m, n, err := d.readHead()
+	if err != nil {
+		return 0, err
+	} else if m != major {
+		return 0, fmt.Errorf("expected major type %v, got %v", major, m)
+	}
+	return n, nil
+}
+
+// readLen reads a length of the given major type, ensuring that at least
+// n*minItemLen bytes remain, so that a malicious length cannot cause a large
+// allocation.
+func (d *decoder) readLen(major byte, minItemLen int) (int, error) {
+	n, err := d.expectHead(major)
+	if err != nil {
+		return 0, err
+	} else if n > uint64(len(d.buf)/minItemLen) {
+		return 0, fmt.Errorf("length %v exceeds remaining input", n)
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readBytes(major byte) ([]byte, error) {
+	n, err := d.readLen(major, 1)
+	if err != nil {
+		return nil, err
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	switch v.Type() {
+	case currencyType:
+		m, n, err := d.readHead()
+		if err != nil {
+			return err
+		}
+		switch {
+		case m == majorUint:
+			v.Set(reflect.ValueOf(types.NewCurrency64(n)))
+		case m == majorTag && n == tagBignum:
+			b, err := d.readBytes(majorBytes)
+			if err != nil {
+				return err
+			} else if len(b) > 16 {
+				return errors.New("currency overflows 128 bits")
+			}
+			var buf [16]byte
+			copy(buf[16-len(b):], b)
+			v.Set(reflect.ValueOf(types.NewCurrency(binary.BigEndian.Uint64(buf[8:]), binary.BigEndian.Uint64(buf[:8]))))
+		default:
+			return errors.New("expected unsigned integer or bignum")
+		}
+		return nil
+	case timeType:
+		if n, err := d.expectHead(majorTag); err != nil {
+			return err
+		} else if n != tagEpochTime {
+			return fmt.Errorf("expected tag %v, got %v", tagEpochTime, n)
+		}
+		m, n, err := d.readHead()
+		if err != nil {
+			return err
+		} else if m != majorUint && m != majorNegInt {
+			return errors.New("expected integer timestamp")
+		} else if n > math.MaxInt64 {
+			return errors.New("timestamp overflows int64")
+		}
+		secs := int64(n)
+		if m == majorNegInt {
+			secs = -1 - secs
+		}
+		v.Set(reflect.ValueOf(time.Unix(secs, 0).UTC()))
+		return nil
+	case policyType:
+		if len(d.buf) > 0 && d.buf[0] == majorSimple<<5|simpleNull {
+			d.buf = d.buf[1:]
+			v.Set(reflect.Zero(policyType))
+			return nil
+		}
+		s, err := d.readBytes(majorText)
+		if err != nil {
+			return err
+		}
+		p, err := types.ParseSpendPolicy(string(s))
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(&p).Elem())
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		switch n, err := d.expectHead(majorSimple); {
+		case err != nil:
+			return err
+		case n == simpleFalse, n == simpleTrue:
+			v.SetBool(n == simpleTrue)
+		default:
+			return errors.New("expected boolean")
+		}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := d.expectHead(majorUint)
+		if err != nil {
+			return err
+		} else if v.OverflowUint(n) {
+			return fmt.Errorf("value %v overflows %v", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.String:
+		s, err := d.readBytes(majorText)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(s))
+	case reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("cannot decode %v", v.Type())
+		}
+		b, err := d.readBytes(majorBytes)
+		if err != nil {
+			return err
+		} else if len(b) != v.Len() {
+			return fmt.Errorf("expected %v bytes, got %v", v.Len(), len(b))
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.readBytes(majorBytes)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...)) // nil if empty
+			return nil
+		}
+		n, err := d.readLen(majorArray, 1)
+		if err != nil {
+			return err
+		} else if n == 0 {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+		for i := 0; i < n; i++ {
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		fs := fields(v.Type())
+		n, err := d.readLen(majorMap, 2)
+		if err != nil {
+			return err
+		} else if n != len(fs) {
+			return fmt.Errorf("expected %v fields for %v, got %v", len(fs), v.Type(), n)
+		}
+		for _, f := range fs {
+			if name, err := d.readBytes(majorText); err != nil {
+				return err
+			} else if string(name) != f.name {
+				return fmt.Errorf("expected field %q, got %q", f.name, name)
+			} else if err := d.decode(v.FieldByIndex(f.index)); err != nil {
+				return fmt.Errorf("%v: %w", f.name, err)
+			}
+		}
+	default:
+		return fmt.Errorf("cannot decode %v", v.Type())
+	}
+	return nil
+}
+
+// Unmarshal decodes the CBOR encoding of a core type, as produced by Marshal,
+// into v, which must be a pointer. Maps must contain exactly the fields of the
+// corresponding struct, in deterministic order.
+func Unmarshal(b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal requires a non-nil pointer")
+	}
+	d := decoder{buf: b}
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	} else if len(d.buf) != 0 {
+		return fmt.Errorf("%w (%v bytes)", ErrTrailingData, len(d.buf))
+	}
+	return nil
+}
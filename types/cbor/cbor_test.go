@@ -0,0 +1,89 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/internal/chainutil"
+	"go.sia.tech/core/types"
+)
+
+func TestMarshal(t *testing.T) {
+	sco := types.SiacoinOutput{
+		Value:   types.NewCurrency64(1000),
+		Address: types.Address{1},
+	}
+	b, err := Marshal(sco)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {"value": 1000, "address": h'01000000...'}
+	exp := "a26576616c75651903e86761646472657373582001" + hex.EncodeToString(make([]byte, 31))
+	if hex.EncodeToString(b) != exp {
+		t.Fatalf("expected %v, got %x", exp, b)
+	}
+
+	tests := []interface{}{
+		types.NewCurrency(1, 1),
+		types.NewCurrency(1<<63, 1<<63),
+		time.Time{},
+		time.Unix(1234, 0).UTC(),
+		types.SiacoinElement{
+			StateElement:   types.StateElement{ID: types.ElementID{Index: 7}, MerkleProof: []types.Hash256{{1}, {2}}},
+			SiacoinOutput:  sco,
+			MaturityHeight: 100,
+		},
+		types.SiacoinInput{
+			SpendPolicy: types.AnyOf(types.PolicyAbove(10), types.PolicyPublicKey{3}),
+			Signatures:  []types.Signature{{4}},
+			Preimages:   [][32]byte{{5}},
+			SigHash:     types.SigHashSingle,
+		},
+	}
+	for _, v := range tests {
+		b, err := Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ptr := reflect.New(reflect.TypeOf(v))
+		if err := Unmarshal(b, ptr.Interface()); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(ptr.Elem().Interface(), v) {
+			t.Fatalf("%T did not survive roundtrip: expected %v, got %v", v, v, ptr.Elem().Interface())
+		}
+		if err := Unmarshal(append(b, 0), ptr.Interface()); !errors.Is(err, ErrTrailingData) {
+			t.Fatalf("expected %v, got %v", ErrTrailingData, err)
+		} else if err := Unmarshal(b[:len(b)-1], ptr.Interface()); err == nil {
+			t.Fatal("expected error when decoding truncated value")
+		}
+	}
+}
+
+func TestMarshalBlocks(t *testing.T) {
+	sim := chainutil.NewChainSim()
+	for _, b := range sim.MineBlocks(10) {
+		buf, err := Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var read types.Block
+		if err := Unmarshal(buf, &read); err != nil {
+			t.Fatal(err)
+		} else if read.ID() != b.ID() {
+			t.Fatal("block did not survive roundtrip")
+		}
+		for i := range b.Transactions {
+			if read.Transactions[i].ID() != b.Transactions[i].ID() {
+				t.Fatal("transaction did not survive roundtrip")
+			}
+		}
+		if buf2, err := Marshal(read); err != nil {
+			t.Fatal(err)
+		} else if string(buf2) != string(buf) {
+			t.Fatal("encoding is not deterministic")
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package compat converts the types used by Sia before the hardfork (as
+// defined by siad) to and from their current equivalents.
+//
+// Legacy inputs identify their parent outputs by ID alone, whereas current
+// inputs contain the full parent element; converting them therefore requires
+// a Resolver. Legacy signatures cover different data than current signatures,
+// so they are never converted: a converted transaction must be re-signed.
+package compat
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/types"
+)
+
+// SpecifierEd25519 is the algorithm of a legacy ed25519 public key.
+var SpecifierEd25519 = [16]byte{'e', 'd', '2', '5', '5', '1', '9'}
+
+// A SiaPublicKey is a legacy public key, tagged with its algorithm.
+type SiaPublicKey struct {
+	Algorithm [16]byte
+	Key       []byte
+}
+
+// UnlockConditions are the legacy conditions under which an output may be
+// spent.
+type UnlockConditions struct {
+	Timelock           uint64
+	PublicKeys         []SiaPublicKey
+	SignaturesRequired uint64
+}
+
+// UnlockHash returns the legacy address of uc, which is the same as the
+// address of its equivalent spend policy.
+func (uc UnlockConditions) UnlockHash() (types.Address, error) {
+	p, err := SpendPolicy(uc)
+	if err != nil {
+		return types.VoidAddress, err
+	}
+	return types.PolicyAddress(p), nil
+}
+
+// A SiacoinOutput is a legacy siacoin output.
+type SiacoinOutput struct {
+	Value      types.Currency
+	UnlockHash types.Address
+}
+
+// A SiafundOutput is a legacy siafund output.
+type SiafundOutput struct {
+	Value      types.Currency
+	UnlockHash types.Address
+	ClaimStart types.Currency
+}
+
+// A SiacoinInput is a legacy siacoin input.
+type SiacoinInput struct {
+	ParentID         types.Hash256
+	UnlockConditions UnlockConditions
+}
+
+// A SiafundInput is a legacy siafund input.
+type SiafundInput struct {
+	ParentID         types.Hash256
+	UnlockConditions UnlockConditions
+	ClaimUnlockHash  types.Address
+}
+
+// A FileContract is a legacy file contract. By convention, the first valid and
+// missed outputs pay the renter, the second pay the host, and the third missed
+// output is burned.
+type FileContract struct {
+	FileSize           uint64
+	FileMerkleRoot     types.Hash256
+	WindowStart        uint64
+	WindowEnd          uint64
+	Payout             types.Currency
+	ValidProofOutputs  []SiacoinOutput
+	MissedProofOutputs []SiacoinOutput
+	UnlockHash         types.Address
+	RevisionNumber     uint64
+}
+
+// A FileContractRevision is a legacy file contract revision.
+type FileContractRevision struct {
+	ParentID              types.Hash256
+	UnlockConditions      UnlockConditions
+	NewRevisionNumber     uint64
+	NewFileSize           uint64
+	NewFileMerkleRoot     types.Hash256
+	NewWindowStart        uint64
+	NewWindowEnd          uint64
+	NewValidProofOutputs  []SiacoinOutput
+	NewMissedProofOutputs []SiacoinOutput
+	NewUnlockHash         types.Address
+}
+
+// A StorageProof is a legacy storage proof.
+type StorageProof struct {
+	ParentID types.Hash256
+	Segment  [64]byte
+	HashSet  []types.Hash256
+}
+
+// CoveredFields indicates which fields of a legacy transaction are covered by
+// a signature.
+type CoveredFields struct {
+	WholeTransaction      bool
+	SiacoinInputs         []uint64
+	SiacoinOutputs        []uint64
+	FileContracts         []uint64
+	FileContractRevisions []uint64
+	StorageProofs         []uint64
+	SiafundInputs         []uint64
+	SiafundOutputs        []uint64
+	MinerFees             []uint64
+	ArbitraryData         []uint64
+	TransactionSignatures []uint64
+}
+
+// A TransactionSignature is a legacy signature.
+type TransactionSignature struct {
+	ParentID       types.Hash256
+	PublicKeyIndex uint64
+	Timelock       uint64
+	CoveredFields  CoveredFields
+	Signature      []byte
+}
+
+// A Transaction is a legacy transaction.
+type Transaction struct {
+	SiacoinInputs         []SiacoinInput
+	SiacoinOutputs        []SiacoinOutput
+	FileContracts         []FileContract
+	FileContractRevisions []FileContractRevision
+	StorageProofs         []StorageProof
+	SiafundInputs         []SiafundInput
+	SiafundOutputs        []SiafundOutput
+	MinerFees             []types.Currency
+	ArbitraryData         [][]byte
+	TransactionSignatures []TransactionSignature
+}
+
+// ErrUnsupported is returned when a legacy object has no current equivalent,
+// or vice versa.
+var ErrUnsupported = errors.New("no equivalent type")
+
+// A Resolver looks up the elements corresponding to legacy output and contract
+// IDs.
+type Resolver interface {
+	SiacoinElement(id types.Hash256) (types.SiacoinElement, error)
+	SiafundElement(id types.Hash256) (types.SiafundElement, error)
+	FileContractElement(id types.Hash256) (types.FileContractElement, error)
+}
+
+// SpendPolicy converts legacy unlock conditions to a spend policy. Only
+// ed25519 keys are supported.
+func SpendPolicy(uc UnlockConditions) (types.PolicyUnlockConditions, error) {
+	if uc.SignaturesRequired > 255 {
+		return types.PolicyUnlockConditions{}, fmt.Errorf("%w: too many required signatures (%v)", ErrUnsupported, uc.SignaturesRequired)
+	}
+	p := types.PolicyUnlockConditions{
+		Timelock:           uc.Timelock,
+		PublicKeys:         make([]types.PublicKey, len(uc.PublicKeys)),
+		SignaturesRequired: uint8(uc.SignaturesRequired),
+	}
+	for i, pk := range uc.PublicKeys {
+		if pk.Algorithm != SpecifierEd25519 || len(pk.Key) != len(p.PublicKeys[i]) {
+			return types.PolicyUnlockConditions{}, fmt.Errorf("%w: public key %v is not an ed25519 key", ErrUnsupported, i)
+		}
+		copy(p.PublicKeys[i][:], pk.Key)
+	}
+	return p, nil
+}
+
+// LegacyUnlockConditions converts a spend policy to legacy unlock conditions.
+func LegacyUnlockConditions(p types.PolicyUnlockConditions) UnlockConditions {
+	uc := UnlockConditions{
+		Timelock:           p.Timelock,
+		PublicKeys:         make([]SiaPublicKey, len(p.PublicKeys)),
+		SignaturesRequired: uint64(p.SignaturesRequired),
+	}
+	for i, pk := range p.PublicKeys {
+		uc.PublicKeys[i] = SiaPublicKey{
+			Algorithm: SpecifierEd25519,
+			Key:       append([]byte(nil), pk[:]...),
+		}
+	}
+	return uc
+}
+
+// ConvertSiacoinOutput converts a legacy siacoin output.
+func ConvertSiacoinOutput(sco SiacoinOutput) types.SiacoinOutput {
+	return types.SiacoinOutput{Value: sco.Value, Address: sco.UnlockHash}
+}
+
+// LegacySiacoinOutput converts a siacoin output to its legacy form.
+func LegacySiacoinOutput(sco types.SiacoinOutput) SiacoinOutput {
+	return SiacoinOutput{Value: sco.Value, UnlockHash: sco.Address}
+}
+
+// ConvertSiafundOutput converts a legacy siafund output. The claim start is
+// discarded, since it is tracked by the output's element instead.
+func ConvertSiafundOutput(sfo SiafundOutput) (types.SiafundOutput, error) {
+	if sfo.Value.Hi != 0 {
+		return types.SiafundOutput{}, fmt.Errorf("siafund value %v overflows uint64", sfo.Value)
+	}
+	return types.SiafundOutput{Value: sfo.Value.Lo, Address: sfo.UnlockHash}, nil
+}
+
+// LegacySiafundOutput converts a siafund output to its legacy form, with the
+// given claim start.
+func LegacySiafundOutput(sfo types.SiafundOutput, claimStart types.Currency) SiafundOutput {
+	return SiafundOutput{
+		Value:      types.NewCurrency64(sfo.Value),
+		UnlockHash: sfo.Address,
+		ClaimStart: claimStart,
+	}
+}
+
+// ConvertFileContract converts a legacy file contract, whose unlock hash must
+// match uc. The unlock conditions must require both of exactly two keys, the
+// renter's and the host's, in that order. Legacy contracts do not record the
+// host's collateral, so TotalCollateral is left zero, and the payout, which is
+// implied by the outputs, is discarded.
+func ConvertFileContract(fc FileContract, uc UnlockConditions) (types.FileContract, error) {
+	p, err := SpendPolicy(uc)
+	if err != nil {
+		return types.FileContract{}, err
+	} else if types.PolicyAddress(p) != fc.UnlockHash {
+		return types.FileContract{}, errors.New("unlock conditions do not match contract unlock hash")
+	} else if len(p.PublicKeys) != 2 || p.SignaturesRequired != 2 {
+		return types.FileContract{}, fmt.Errorf("%w: contract unlock conditions are not 2-of-2", ErrUnsupported)
+	} else if len(fc.ValidProofOutputs) != 2 || len(fc.MissedProofOutputs) < 2 {
+		return types.FileContract{}, fmt.Errorf("%w: contract has nonstandard outputs", ErrUnsupported)
+	}
+	return types.FileContract{
+		Filesize:        fc.FileSize,
+		FileMerkleRoot:  fc.FileMerkleRoot,
+		WindowStart:     fc.WindowStart,
+		WindowEnd:       fc.WindowEnd,
+		RenterOutput:    ConvertSiacoinOutput(fc.ValidProofOutputs[0]),
+		HostOutput:      ConvertSiacoinOutput(fc.ValidProofOutputs[1]),
+		MissedHostValue: fc.MissedProofOutputs[1].Value,
+		RenterPublicKey: p.PublicKeys[0],
+		HostPublicKey:   p.PublicKeys[1],
+		RevisionNumber:  fc.RevisionNumber,
+	}, nil
+}
+
+// ConvertTransaction converts a legacy transaction, using r to look up the
+// parents of its inputs and revisions. Since current transactions have a
+// single miner fee and arbitrary data field, multiple fees are summed and
+// multiple data fields are concatenated. Storage proofs cannot be converted,
+// as they lack the proof window index.
+func ConvertTransaction(txn Transaction, r Resolver) (types.Transaction, error) {
+	if len(txn.StorageProofs) != 0 {
+		return types.Transaction{}, fmt.Errorf("%w: transaction contains storage proofs", ErrUnsupported)
+	}
+	var t types.Transaction
+	for i, in := range txn.SiacoinInputs {
+		parent, err := r.SiacoinElement(in.ParentID)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("siacoin input %v: %w", i, err)
+		}
+		p, err := SpendPolicy(in.UnlockConditions)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("siacoin input %v: %w", i, err)
+		}
+		t.SiacoinInputs = append(t.SiacoinInputs, types.SiacoinInput{
+			Parent:      parent,
+			SpendPolicy: p,
+		})
+	}
+	for _, sco := range txn.SiacoinOutputs {
+		t.SiacoinOutputs = append(t.SiacoinOutputs, ConvertSiacoinOutput(sco))
+	}
+	for i, in := range txn.SiafundInputs {
+		parent, err := r.SiafundElement(in.ParentID)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("siafund input %v: %w", i, err)
+		}
+		p, err := SpendPolicy(in.UnlockConditions)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("siafund input %v: %w", i, err)
+		}
+		t.SiafundInputs = append(t.SiafundInputs, types.SiafundInput{
+			Parent:       parent,
+			ClaimAddress: in.ClaimUnlockHash,
+			SpendPolicy:  p,
+		})
+	}
+	for i, sfo := range txn.SiafundOutputs {
+		out, err := ConvertSiafundOutput(sfo)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("siafund output %v: %w", i, err)
+		}
+		t.SiafundOutputs = append(t.SiafundOutputs, out)
+	}
+	for i, fc := range txn.FileContracts {
+		// a new contract's unlock conditions are not revealed until it is
+		// revised, so look for a revision in the same transaction
+		var uc *UnlockConditions
+		for _, rev := range txn.FileContractRevisions {
+			if h, err := rev.UnlockConditions.UnlockHash(); err == nil && h == fc.UnlockHash {
+				uc = &rev.UnlockConditions
+			}
+		}
+		if uc == nil {
+			return types.Transaction{}, fmt.Errorf("%w: file contract %v has unknown unlock conditions", ErrUnsupported, i)
+		}
+		c, err := ConvertFileContract(fc, *uc)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("file contract %v: %w", i, err)
+		}
+		t.FileContracts = append(t.FileContracts, c)
+	}
+	for i, rev := range txn.FileContractRevisions {
+		parent, err := r.FileContractElement(rev.ParentID)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("file contract revision %v: %w", i, err)
+		}
+		revision, err := ConvertFileContract(FileContract{
+			FileSize:           rev.NewFileSize,
+			FileMerkleRoot:     rev.NewFileMerkleRoot,
+			WindowStart:        rev.NewWindowStart,
+			WindowEnd:          rev.NewWindowEnd,
+			ValidProofOutputs:  rev.NewValidProofOutputs,
+			MissedProofOutputs: rev.NewMissedProofOutputs,
+			UnlockHash:         rev.NewUnlockHash,
+			RevisionNumber:     rev.NewRevisionNumber,
+		}, rev.UnlockConditions)
+		if err != nil {
+			return types.Transaction{}, fmt.Errorf("file contract revision %v: %w", i, err)
+		}
+		revision.TotalCollateral = parent.TotalCollateral
+		t.FileContractRevisions = append(t.FileContractRevisions, types.FileContractRevision{
+			Parent:   parent,
+			Revision: revision,
+		})
+	}
+	for _, fee := range txn.MinerFees {
+		var overflow bool
+		if t.MinerFee, overflow = t.MinerFee.AddWithOverflow(fee); overflow {
+			return types.Transaction{}, errors.New("miner fees overflow")
+		}
+	}
+	for _, data := range txn.ArbitraryData {
+		t.ArbitraryData = append(t.ArbitraryData, data...)
+	}
+	return t, nil
+}
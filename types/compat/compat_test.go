@@ -0,0 +1,165 @@
+package compat
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.sia.tech/core/types"
+)
+
+type mapResolver struct {
+	sces map[types.Hash256]types.SiacoinElement
+	fces map[types.Hash256]types.FileContractElement
+}
+
+func (r mapResolver) SiacoinElement(id types.Hash256) (types.SiacoinElement, error) {
+	sce, ok := r.sces[id]
+	if !ok {
+		return types.SiacoinElement{}, errors.New("unknown siacoin output")
+	}
+	return sce, nil
+}
+
+func (r mapResolver) SiafundElement(id types.Hash256) (types.SiafundElement, error) {
+	return types.SiafundElement{}, errors.New("unknown siafund output")
+}
+
+func (r mapResolver) FileContractElement(id types.Hash256) (types.FileContractElement, error) {
+	fce, ok := r.fces[id]
+	if !ok {
+		return types.FileContractElement{}, errors.New("unknown file contract")
+	}
+	return fce, nil
+}
+
+func TestUnlockConditions(t *testing.T) {
+	p := types.PolicyUnlockConditions{
+		Timelock:           10,
+		PublicKeys:         []types.PublicKey{{1}, {2}},
+		SignaturesRequired: 1,
+	}
+	uc := LegacyUnlockConditions(p)
+	if addr, err := uc.UnlockHash(); err != nil {
+		t.Fatal(err)
+	} else if addr != types.PolicyAddress(p) {
+		t.Fatal("unlock hash does not match policy address")
+	}
+	if p2, err := SpendPolicy(uc); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(p, p2) {
+		t.Fatal("policy did not survive roundtrip")
+	}
+
+	uc.PublicKeys[0].Algorithm = [16]byte{'e', 'n', 't', 'r', 'o', 'p', 'y'}
+	if _, err := SpendPolicy(uc); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+	uc = LegacyUnlockConditions(p)
+	uc.SignaturesRequired = 256
+	if _, err := SpendPolicy(uc); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+}
+
+func TestConvertTransaction(t *testing.T) {
+	renterPolicy := types.PolicyUnlockConditions{PublicKeys: []types.PublicKey{{1}}, SignaturesRequired: 1}
+	contractPolicy := types.PolicyUnlockConditions{PublicKeys: []types.PublicKey{{1}, {2}}, SignaturesRequired: 2}
+	renterAddr := types.PolicyAddress(renterPolicy)
+	hostAddr := types.Address{3}
+	contractAddr := types.PolicyAddress(contractPolicy)
+
+	parentID := types.Hash256{4}
+	contractID := types.Hash256{5}
+	r := mapResolver{
+		sces: map[types.Hash256]types.SiacoinElement{
+			parentID: {SiacoinOutput: types.SiacoinOutput{Value: types.Siacoins(10), Address: renterAddr}},
+		},
+		fces: map[types.Hash256]types.FileContractElement{
+			contractID: {FileContract: types.FileContract{TotalCollateral: types.Siacoins(7)}},
+		},
+	}
+
+	outputs := func(renter, host uint32) []SiacoinOutput {
+		return []SiacoinOutput{
+			{Value: types.Siacoins(renter), UnlockHash: renterAddr},
+			{Value: types.Siacoins(host), UnlockHash: hostAddr},
+		}
+	}
+	txn := Transaction{
+		SiacoinInputs: []SiacoinInput{{
+			ParentID:         parentID,
+			UnlockConditions: LegacyUnlockConditions(renterPolicy),
+		}},
+		SiacoinOutputs: []SiacoinOutput{{Value: types.Siacoins(7), UnlockHash: renterAddr}},
+		FileContractRevisions: []FileContractRevision{{
+			ParentID:              contractID,
+			UnlockConditions:      LegacyUnlockConditions(contractPolicy),
+			NewRevisionNumber:     2,
+			NewFileSize:           64,
+			NewFileMerkleRoot:     types.Hash256{6},
+			NewWindowStart:        100,
+			NewWindowEnd:          200,
+			NewValidProofOutputs:  outputs(1, 2),
+			NewMissedProofOutputs: append(outputs(1, 1), SiacoinOutput{Value: types.Siacoins(1)}),
+			NewUnlockHash:         contractAddr,
+		}},
+		MinerFees:     []types.Currency{types.Siacoins(1), types.Siacoins(2)},
+		ArbitraryData: [][]byte{[]byte("foo"), []byte("bar")},
+		TransactionSignatures: []TransactionSignature{{
+			ParentID:  parentID,
+			Signature: make([]byte, 64),
+		}},
+	}
+
+	exp := types.Transaction{
+		SiacoinInputs: []types.SiacoinInput{{
+			Parent:      r.sces[parentID],
+			SpendPolicy: renterPolicy,
+		}},
+		SiacoinOutputs: []types.SiacoinOutput{{Value: types.Siacoins(7), Address: renterAddr}},
+		FileContractRevisions: []types.FileContractRevision{{
+			Parent: r.fces[contractID],
+			Revision: types.FileContract{
+				Filesize:        64,
+				FileMerkleRoot:  types.Hash256{6},
+				WindowStart:     100,
+				WindowEnd:       200,
+				RenterOutput:    types.SiacoinOutput{Value: types.Siacoins(1), Address: renterAddr},
+				HostOutput:      types.SiacoinOutput{Value: types.Siacoins(2), Address: hostAddr},
+				MissedHostValue: types.Siacoins(1),
+				TotalCollateral: types.Siacoins(7),
+				RenterPublicKey: types.PublicKey{1},
+				HostPublicKey:   types.PublicKey{2},
+				RevisionNumber:  2,
+			},
+		}},
+		MinerFee:      types.Siacoins(3),
+		ArbitraryData: []byte("foobar"),
+	}
+	if got, err := ConvertTransaction(txn, r); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	// unknown parent
+	bad := txn
+	bad.SiacoinInputs = []SiacoinInput{{ParentID: types.Hash256{9}}}
+	if _, err := ConvertTransaction(bad, r); err == nil {
+		t.Fatal("expected error for unknown parent")
+	}
+	// storage proofs are unsupported
+	bad = txn
+	bad.StorageProofs = []StorageProof{{ParentID: contractID}}
+	if _, err := ConvertTransaction(bad, r); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+	// new contract without matching revision
+	bad = txn
+	bad.FileContractRevisions = nil
+	bad.FileContracts = []FileContract{{UnlockHash: contractAddr}}
+	if _, err := ConvertTransaction(bad, r); !errors.Is(err, ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+}
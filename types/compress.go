@@ -0,0 +1,69 @@
+package types
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrCompressedTooLarge is returned by DecodeCompressed if an object
+// decompresses to more than the allowed number of bytes.
+var ErrCompressedTooLarge = errors.New("compressed object exceeds size limit")
+
+// zstdEncoder compresses objects in a single-segment frame, whose window is
+// exactly the length of the object; this allows DecodeCompressed to bound the
+// window by the decoded-length limit. EncodeAll is safe for concurrent use.
+var zstdEncoder, _ = zstd.NewWriter(nil,
+	zstd.WithEncoderConcurrency(1),
+	zstd.WithSingleSegment(true),
+)
+
+// EncodeCompressed writes the encoding of v to w as a single zstd frame.
+func EncodeCompressed(w io.Writer, v EncoderTo) error {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	v.EncodeTo(e)
+	if err := e.Flush(); err != nil {
+		return fmt.Errorf("could not encode object: %w", err)
+	}
+	_, err := w.Write(zstdEncoder.EncodeAll(buf.Bytes(), nil))
+	return err
+}
+
+// DecodeCompressed decodes v from a zstd frame written by EncodeCompressed.
+// The decompressed encoding of v may not exceed maxLen bytes, and no data may
+// follow it. Since the decompressor may read ahead, r should contain only the
+// compressed object.
+func DecodeCompressed(r io.Reader, v DecoderFrom, maxLen int) error {
+	// a single-segment frame's window is its decompressed length, so frames
+	// that declare more than maxLen bytes are rejected before decoding
+	maxWindow := uint64(maxLen)
+	if maxWindow < zstd.MinWindowSize {
+		maxWindow = zstd.MinWindowSize
+	}
+	zr, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(maxWindow),
+	)
+	if err != nil {
+		return fmt.Errorf("could not initialize decompressor: %w", err)
+	}
+	defer zr.Close()
+	d := NewDecoder(io.LimitedReader{R: zr, N: int64(maxLen)})
+	v.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		if d.lr.N == 0 || errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+			return fmt.Errorf("%w (%v bytes)", ErrCompressedTooLarge, maxLen)
+		}
+		return err
+	}
+	if n, err := io.CopyN(io.Discard, zr, 1); err != nil && err != io.EOF {
+		return fmt.Errorf("could not decompress object: %w", err)
+	} else if n != 0 {
+		return ErrTrailingData
+	}
+	return nil
+}
@@ -104,10 +104,43 @@ func (c Currency) SubWithUnderflow(v Currency) (Currency, bool) {
 	return Currency{lo, hi}, borrow != 0
 }
 
+// Mul returns c*v. If the result would overflow, Mul panics.
+func (c Currency) Mul(v Currency) Currency {
+	p, overflow := c.MulWithOverflow(v)
+	if overflow {
+		panic("overflow")
+	}
+	return p
+}
+
+// MulWithOverflow returns c*v, along with a boolean indicating whether the
+// result overflowed.
+func (c Currency) MulWithOverflow(v Currency) (Currency, bool) {
+	// NOTE: the cross term c.Hi*v.Hi is always shifted out of the result, so
+	// it only matters whether it is zero.
+	hi0, lo0 := bits.Mul64(c.Lo, v.Lo)
+	hi1, lo1 := bits.Mul64(c.Hi, v.Lo)
+	hi2, lo2 := bits.Mul64(c.Lo, v.Hi)
+	hi, c0 := bits.Add64(hi0, lo1, 0)
+	hi, c1 := bits.Add64(hi, lo2, 0)
+	overflow := (c.Hi != 0 && v.Hi != 0) || hi1 != 0 || hi2 != 0 || c0 != 0 || c1 != 0
+	return Currency{lo0, hi}, overflow
+}
+
 // Mul64 returns c*v. If the result would overflow, Mul64 panics.
 //
 // Note that it is safe to multiply any two Currency values that are below 2^64.
 func (c Currency) Mul64(v uint64) Currency {
+	p, overflow := c.Mul64WithOverflow(v)
+	if overflow {
+		panic("overflow")
+	}
+	return p
+}
+
+// Mul64WithOverflow returns c*v, along with a boolean indicating whether the
+// result overflowed.
+func (c Currency) Mul64WithOverflow(v uint64) (Currency, bool) {
 	// NOTE: this is the overflow-checked equivalent of:
 	//
 	//   hi, lo := bits.Mul64(c.Lo, v)
@@ -116,10 +149,7 @@ func (c Currency) Mul64(v uint64) Currency {
 	hi0, lo0 := bits.Mul64(c.Lo, v)
 	hi1, lo1 := bits.Mul64(c.Hi, v)
 	hi2, c0 := bits.Add64(hi0, lo1, 0)
-	if hi1 != 0 || c0 != 0 {
-		panic("overflow")
-	}
-	return Currency{lo0, hi2}
+	return Currency{lo0, hi2}, hi1 != 0 || c0 != 0
 }
 
 // Div returns c/v. If v == 0, Div panics.
@@ -197,11 +227,71 @@ func (c Currency) ExactString() string {
 	}
 }
 
-// String returns the base-10 representation of c as a string, in units of
-// HastingsPerSiacoin, rounded to three decimal places.
+// currencyUnits lists the units accepted by ParseCurrency and FormatUnit,
+// along with their size in hastings, as a power of 10.
+var currencyUnits = []struct {
+	name string
+	exp  int64
+}{
+	{"H", 0},
+	{"pS", 12},
+	{"nS", 15},
+	{"uS", 18},
+	{"mS", 21},
+	{"SC", 24},
+	{"KS", 27},
+	{"MS", 30},
+	{"GS", 33},
+	{"TS", 36},
+}
+
+func unitExp(unit string) (int64, bool) {
+	for _, u := range currencyUnits {
+		if u.name == unit {
+			return u.exp, true
+		}
+	}
+	return 0, false
+}
+
+func pow10(exp int64) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(exp), nil)
+}
+
+// String returns a human-readable representation of c, in the largest unit
+// (see FormatUnit) that c is not smaller than, rounded to three decimal places.
+// Values smaller than 1 pS are returned exactly, in hastings.
 func (c Currency) String() string {
-	fs := new(big.Rat).SetFrac(c.Big(), HastingsPerSiacoin.Big()).FloatString(3)
-	return strings.TrimSuffix(strings.TrimRight(fs, "0"), ".")
+	k := 0
+	for k+1 < len(currencyUnits) && c.Big().Cmp(pow10(currencyUnits[k+1].exp)) >= 0 {
+		k++
+	}
+	if k == 0 {
+		return c.ExactString() + " H"
+	}
+	fs := new(big.Rat).SetFrac(c.Big(), pow10(currencyUnits[k].exp)).FloatString(3)
+	if fs == "1000.000" && k+1 < len(currencyUnits) {
+		// rounding carried into the next unit
+		fs, k = "1", k+1
+	}
+	return strings.TrimSuffix(strings.TrimRight(fs, "0"), ".") + " " + currencyUnits[k].name
+}
+
+// FormatUnit returns the exact decimal representation of c in the specified
+// unit, which must be one of H, pS, nS, uS, mS, SC, KS, MS, GS, or TS.
+func (c Currency) FormatUnit(unit string) string {
+	exp, ok := unitExp(unit)
+	if !ok {
+		panic("unknown currency unit " + unit) // developer error
+	}
+	q, r := new(big.Int).QuoRem(c.Big(), pow10(exp), new(big.Int))
+	s := q.String()
+	if r.Sign() != 0 {
+		frac := r.String()
+		frac = strings.Repeat("0", int(exp)-len(frac)) + frac
+		s += "." + strings.TrimRight(frac, "0")
+	}
+	return s + " " + unit
 }
 
 // MarshalJSON implements json.Marshaler.
@@ -233,16 +323,80 @@ func NewCurrency64(c uint64) Currency {
 	return Currency{c, 0}
 }
 
-// ParseCurrency parses s as a Currency value. The format of s should match the
-// return value of the ExactString method, i.e. an unsigned base-10 integer.
-func ParseCurrency(s string) (Currency, error) {
-	i, ok := new(big.Int).SetString(s, 10)
-	if !ok {
-		return ZeroCurrency, errors.New("not an integer")
-	} else if i.Sign() < 0 {
+// FromBig converts i to a Currency value. It returns an error if i is
+// negative or does not fit in 128 bits.
+func FromBig(i *big.Int) (Currency, error) {
+	if i.Sign() < 0 {
 		return ZeroCurrency, errors.New("value cannot be negative")
 	} else if i.BitLen() > 128 {
 		return ZeroCurrency, errors.New("value overflows Currency representation")
 	}
 	return NewCurrency(i.Uint64(), new(big.Int).Rsh(i, 64).Uint64()), nil
 }
+
+// Rat returns c as a *big.Rat.
+func (c Currency) Rat() *big.Rat {
+	return new(big.Rat).SetInt(c.Big())
+}
+
+// FromRat converts r to a Currency value, rounding down to the nearest
+// hasting. It returns an error if r is negative or the result does not fit in
+// 128 bits.
+func FromRat(r *big.Rat) (Currency, error) {
+	if r.Sign() < 0 {
+		return ZeroCurrency, errors.New("value cannot be negative")
+	}
+	return FromBig(new(big.Int).Quo(r.Num(), r.Denom()))
+}
+
+// MulRat returns c*r, rounded down to the nearest hasting. Intermediate
+// values are computed exactly, so MulRat can be used to compute proportional
+// values, e.g. the cost of storing some number of bytes for some number of
+// blocks at a price per byte per block, without overflow or loss of precision.
+func (c Currency) MulRat(r *big.Rat) (Currency, error) {
+	return FromRat(new(big.Rat).Mul(c.Rat(), r))
+}
+
+// MulDiv64 returns c*num/den, rounded down to the nearest hasting. Unlike
+// c.Mul64(num).Div64(den), the intermediate product cannot overflow. It
+// returns an error if the result does not fit in 128 bits. If den == 0,
+// MulDiv64 panics.
+func (c Currency) MulDiv64(num, den uint64) (Currency, error) {
+	if den == 0 {
+		panic("division by zero")
+	}
+	return c.MulRat(new(big.Rat).SetFrac(new(big.Int).SetUint64(num), new(big.Int).SetUint64(den)))
+}
+
+// ParseCurrency parses s as a Currency value. If s is an unsigned base-10
+// integer, as returned by the ExactString method, it is interpreted as a
+// number of hastings. Otherwise, s must be a decimal number followed by a unit
+// (e.g. "1.5 SC" or "20mS"), as accepted by FormatUnit. The value must be
+// exactly representable in hastings.
+func ParseCurrency(s string) (Currency, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != '-' })
+	if i < 0 {
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return ZeroCurrency, errors.New("not an integer")
+		}
+		return FromBig(i)
+	}
+	num, unit := strings.TrimSpace(s[:i]), strings.TrimSpace(s[i:])
+	exp, ok := unitExp(unit)
+	if !ok {
+		return ZeroCurrency, fmt.Errorf("unknown unit %q", unit)
+	} else if strings.Count(num, ".") > 1 || strings.Trim(num, ".") == "" || strings.HasSuffix(num, ".") {
+		return ZeroCurrency, fmt.Errorf("invalid decimal %q", num)
+	}
+	r, ok := new(big.Rat).SetString(num)
+	if !ok {
+		return ZeroCurrency, fmt.Errorf("invalid decimal %q", num)
+	}
+	r.Mul(r, new(big.Rat).SetInt(pow10(exp)))
+	if !r.IsInt() {
+		return ZeroCurrency, errors.New("value is not a whole number of hastings")
+	}
+	return FromBig(r.Num())
+}
@@ -2,7 +2,10 @@ package types
 
 import (
 	"math"
+	"math/big"
 	"testing"
+
+	"lukechampine.com/frand"
 )
 
 var maxCurrency = NewCurrency(math.MaxUint64, math.MaxUint64)
@@ -304,6 +307,73 @@ func TestCurrencyMul64(t *testing.T) {
 	}
 }
 
+func TestCurrencyMulWithOverflow(t *testing.T) {
+	tests := []struct {
+		a, b, want Currency
+		overflows  bool
+	}{
+		{
+			ZeroCurrency,
+			maxCurrency,
+			ZeroCurrency,
+			false,
+		},
+		{
+			NewCurrency(math.MaxUint64, 0),
+			NewCurrency(math.MaxUint64, 0),
+			NewCurrency(1, math.MaxUint64-1),
+			false,
+		},
+		{
+			Siacoins(30),
+			NewCurrency64(50),
+			Siacoins(1500),
+			false,
+		},
+		{
+			NewCurrency(0, 1),
+			NewCurrency(0, 1),
+			ZeroCurrency,
+			true,
+		},
+		{
+			maxCurrency,
+			NewCurrency64(2),
+			NewCurrency(math.MaxUint64-1, math.MaxUint64),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		got, overflows := tt.a.MulWithOverflow(tt.b)
+		if tt.overflows != overflows {
+			t.Errorf("Currency.MulWithOverflow(%d, %d) overflow %t, want %t", tt.a, tt.b, overflows, tt.overflows)
+		} else if !got.Equals(tt.want) {
+			t.Errorf("Currency.MulWithOverflow(%d, %d) expected = %d, got %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+
+	// compare against big.Int
+	mod := new(big.Int).Lsh(big.NewInt(1), 128)
+	for i := 0; i < 1000; i++ {
+		a := NewCurrency(frand.Uint64n(math.MaxUint64), frand.Uint64n(1<<(i%64)))
+		b := NewCurrency(frand.Uint64n(math.MaxUint64), frand.Uint64n(1<<(63-i%64)))
+		prod := new(big.Int).Mul(a.Big(), b.Big())
+		got, overflows := a.MulWithOverflow(b)
+		if overflows != (prod.Cmp(mod) >= 0) {
+			t.Fatalf("Currency.MulWithOverflow(%d, %d) overflow %t, want %t", a, b, overflows, !overflows)
+		} else if got.Big().Cmp(prod.Mod(prod, mod)) != 0 {
+			t.Fatalf("Currency.MulWithOverflow(%d, %d) = %d, want %v", a, b, got, prod)
+		}
+		got, overflows = a.Mul64WithOverflow(b.Lo)
+		prod = new(big.Int).Mul(a.Big(), new(big.Int).SetUint64(b.Lo))
+		if overflows != (prod.Cmp(mod) >= 0) {
+			t.Fatalf("Currency.Mul64WithOverflow(%d, %d) overflow %t, want %t", a, b.Lo, overflows, !overflows)
+		} else if got.Big().Cmp(prod.Mod(prod, mod)) != 0 {
+			t.Fatalf("Currency.Mul64WithOverflow(%d, %d) = %d, want %v", a, b.Lo, got, prod)
+		}
+	}
+}
+
 func TestCurrencyDiv(t *testing.T) {
 	tests := []struct {
 		a, b, want Currency
@@ -435,19 +505,23 @@ func TestCurrencyString(t *testing.T) {
 	}{
 		{
 			ZeroCurrency,
-			"0",
+			"0 H",
 		},
 		{
 			NewCurrency64(10000),
-			"0",
+			"10000 H",
 		},
 		{
 			NewCurrency(8262254095159001088, 2742357),
-			"50.588",
+			"50.588 SC",
 		},
 		{
 			NewCurrency(2174395257947586975, 137),
-			"0.003",
+			"2.529 mS",
+		},
+		{
+			maxCurrency,
+			"340.282 TS",
 		},
 	}
 	for _, tt := range tests {
@@ -457,6 +531,31 @@ func TestCurrencyString(t *testing.T) {
 	}
 }
 
+func TestCurrencyFormatUnit(t *testing.T) {
+	tests := []struct {
+		val  Currency
+		unit string
+		want string
+	}{
+		{ZeroCurrency, "SC", "0 SC"},
+		{NewCurrency64(10000), "H", "10000 H"},
+		{NewCurrency64(10000), "pS", "0.00000001 pS"},
+		{Siacoins(3).Div64(2), "SC", "1.5 SC"},
+		{Siacoins(3).Div64(2), "mS", "1500 mS"},
+		{Siacoins(1).Add(NewCurrency64(1)), "SC", "1.000000000000000000000001 SC"},
+		{Siacoins(1500), "KS", "1.5 KS"},
+	}
+	for _, tt := range tests {
+		if got := tt.val.FormatUnit(tt.unit); got != tt.want {
+			t.Errorf("Currency.FormatUnit(%v) = %v, want %v", tt.unit, got, tt.want)
+		} else if c, err := ParseCurrency(got); err != nil {
+			t.Errorf("ParseCurrency(%v) error = %v", got, err)
+		} else if !c.Equals(tt.val) {
+			t.Errorf("ParseCurrency(%v) = %d, want %d", got, c, tt.val)
+		}
+	}
+}
+
 func TestCurrencyJSON(t *testing.T) {
 	tests := []struct {
 		val  Currency
@@ -545,6 +644,48 @@ func TestParseCurrency(t *testing.T) {
 			NewCurrency(2174395257947586975, 137),
 			false,
 		},
+		{
+			"units",
+			"1.5 SC",
+			Siacoins(3).Div64(2),
+			false,
+		},
+		{
+			"units without space",
+			"20mS",
+			Siacoins(1).Div64(50),
+			false,
+		},
+		{
+			"fractional hastings",
+			"0.5 H",
+			ZeroCurrency,
+			true,
+		},
+		{
+			"unknown unit",
+			"1 BTC",
+			ZeroCurrency,
+			true,
+		},
+		{
+			"unit without value",
+			"SC",
+			ZeroCurrency,
+			true,
+		},
+		{
+			"negative units",
+			"-1 SC",
+			ZeroCurrency,
+			true,
+		},
+		{
+			"unit overflow",
+			"1000000 TS",
+			ZeroCurrency,
+			true,
+		},
 	}
 	for _, tt := range tests {
 		got, err := ParseCurrency(tt.s)
@@ -555,3 +696,53 @@ func TestParseCurrency(t *testing.T) {
 		}
 	}
 }
+
+func TestCurrencyBig(t *testing.T) {
+	for _, c := range []Currency{ZeroCurrency, NewCurrency64(1), Siacoins(7), NewCurrency(0, 1), maxCurrency} {
+		if got, err := FromBig(c.Big()); err != nil || got != c {
+			t.Errorf("FromBig(%d) = %d, %v", c, got, err)
+		}
+		if got, err := FromRat(c.Rat()); err != nil || got != c {
+			t.Errorf("FromRat(%d) = %d, %v", c, got, err)
+		}
+	}
+	if _, err := FromBig(big.NewInt(-1)); err == nil {
+		t.Error("expected error for negative value")
+	} else if _, err := FromBig(new(big.Int).Lsh(big.NewInt(1), 128)); err == nil {
+		t.Error("expected error for overflowing value")
+	} else if _, err := FromRat(big.NewRat(-1, 2)); err == nil {
+		t.Error("expected error for negative value")
+	}
+	if got, err := FromRat(big.NewRat(7, 2)); err != nil || got != NewCurrency64(3) {
+		t.Errorf("FromRat(7/2) = %d, %v", got, err)
+	}
+}
+
+func TestCurrencyMulRat(t *testing.T) {
+	tests := []struct {
+		c        Currency
+		num, den uint64
+		want     Currency
+		err      bool
+	}{
+		{Siacoins(10), 1, 3, mustParseCurrency("3333333333333333333333333"), false},
+		{Siacoins(3), 2, 3, Siacoins(2), false},
+		{NewCurrency64(5), 0, 1, ZeroCurrency, false},
+		// the intermediate product exceeds 128 bits, but the result does not
+		{maxCurrency, math.MaxUint64, math.MaxUint64, maxCurrency, false},
+		{maxCurrency, 1 << 32, 1 << 33, NewCurrency(math.MaxUint64, math.MaxUint64>>1), false},
+		{maxCurrency, 3, 2, ZeroCurrency, true},
+	}
+	for _, tt := range tests {
+		got, err := tt.c.MulDiv64(tt.num, tt.den)
+		if (err != nil) != tt.err {
+			t.Errorf("Currency.MulDiv64(%d, %d, %d) error = %v", tt.c, tt.num, tt.den, err)
+		} else if got != tt.want {
+			t.Errorf("Currency.MulDiv64(%d, %d, %d) = %d, want %d", tt.c, tt.num, tt.den, got, tt.want)
+		}
+		got2, err := tt.c.MulRat(new(big.Rat).SetFrac(new(big.Int).SetUint64(tt.num), new(big.Int).SetUint64(tt.den)))
+		if got2 != got || (err != nil) != tt.err {
+			t.Errorf("Currency.MulRat(%d, %d/%d) = %d, %v, want %d", tt.c, tt.num, tt.den, got2, err, got)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package types
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Constants for hierarchical key derivation.
+const (
+	// HardenedOffset is added to a path component to make it hardened. Ed25519
+	// supports only hardened derivation, so every component is hardened.
+	HardenedOffset = 1 << 31
+
+	// PurposeBIP44 is the first component of a standard derivation path.
+	PurposeBIP44 = 44
+	// SiaCoinType is the SLIP-44 coin type registered for Sia.
+	SiaCoinType = 1991
+)
+
+// An ExtendedKey is a node in a SLIP-10 Ed25519 key hierarchy.
+type ExtendedKey struct {
+	Key       [32]byte
+	ChainCode [32]byte
+}
+
+func newExtendedKey(key, data []byte) (ek ExtendedKey) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	defer zero(sum)
+	copy(ek.Key[:], sum[:32])
+	copy(ek.ChainCode[:], sum[32:])
+	return
+}
+
+// NewMasterKey derives the root of a key hierarchy from a seed, per SLIP-10.
+func NewMasterKey(seed []byte) ExtendedKey {
+	return newExtendedKey([]byte("ed25519 seed"), seed)
+}
+
+// Child derives the hardened child key at index i. The hardened offset is
+// added to i if it is not already present.
+func (ek ExtendedKey) Child(i uint32) ExtendedKey {
+	var data [1 + 32 + 4]byte
+	defer zero(data[:])
+	copy(data[1:], ek.Key[:])
+	binary.BigEndian.PutUint32(data[33:], i|HardenedOffset)
+	return newExtendedKey(ek.ChainCode[:], data[:])
+}
+
+// Derive derives the key at the specified path, relative to ek.
+func (ek ExtendedKey) Derive(path []uint32) ExtendedKey {
+	for _, i := range path {
+		ek = ek.Child(i)
+	}
+	return ek
+}
+
+// PrivateKey returns the Ed25519 private key of ek.
+func (ek ExtendedKey) PrivateKey() PrivateKey {
+	return NewPrivateKeyFromSeed(ek.Key)
+}
+
+// AccountPath returns the standard derivation path for a Sia account,
+// m/44'/1991'/account'.
+func AccountPath(account uint32) []uint32 {
+	return []uint32{PurposeBIP44 | HardenedOffset, SiaCoinType | HardenedOffset, account | HardenedOffset}
+}
+
+// KeyPath returns the standard derivation path for the key at the specified
+// index of an account, m/44'/1991'/account'/0'/index'.
+func KeyPath(account, index uint32) []uint32 {
+	return append(AccountPath(account), HardenedOffset, index|HardenedOffset)
+}
+
+// FormatDerivationPath returns the conventional string form of a derivation
+// path, e.g. m/44'/1991'/0'/0'/5'.
+func FormatDerivationPath(path []uint32) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, i := range path {
+		if i >= HardenedOffset {
+			fmt.Fprintf(&sb, "/%d'", i-HardenedOffset)
+		} else {
+			fmt.Fprintf(&sb, "/%d", i)
+		}
+	}
+	return sb.String()
+}
+
+// DeriveKey derives the private key at the specified index of an account,
+// using the standard derivation path.
+func DeriveKey(seed []byte, account, index uint32) PrivateKey {
+	return NewMasterKey(seed).Derive(KeyPath(account, index)).PrivateKey()
+}
+
+// DerivePublicKeys derives the public keys at indices [start, start+n) of an
+// account, using the standard derivation path. It is intended for scanning
+// the chain for addresses that have been used, e.g. when recovering a wallet
+// from its seed.
+func DerivePublicKeys(seed []byte, account, start, n uint32) []PublicKey {
+	chain := NewMasterKey(seed).Derive(KeyPath(account, 0)[:4])
+	pks := make([]PublicKey, n)
+	for i := range pks {
+		priv := chain.Child(start + uint32(i)).PrivateKey()
+		pks[i] = priv.PublicKey()
+		priv.Close()
+	}
+	return pks
+}
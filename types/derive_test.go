@@ -0,0 +1,61 @@
+package types
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestKeyDerivation(t *testing.T) {
+	// SLIP-10 test vector 1 for ed25519
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	tests := []struct {
+		path      []uint32
+		chainCode string
+		key       string
+	}{
+		{
+			nil,
+			"90046a93de5380a72b5e45010748567d5ea02bbf6522f979e05c0d8d8ca9fffb",
+			"2b4be7f19ee27bbf30c667b642d5f4aa69fd169872f8fc3059c08ebae2eb19e7",
+		},
+		{
+			[]uint32{0 | HardenedOffset},
+			"8b59aa11380b624e81507a27fedda59fea6d0b779a778918a2fd3590e16e9c69",
+			"68e0fe46dfb67e368c75379acec591dad19df3cde26e63b93a8e704f1dade7a3",
+		},
+		{
+			[]uint32{0 | HardenedOffset, 1 | HardenedOffset},
+			"a320425f77d1b5c2505a6b1b27382b37368ee640e3557c315416801243552f14",
+			"b1d0bad404bf35da785a64ca1ac54b2617211d2777696fbffaf208f746ae84f2",
+		},
+	}
+	master := NewMasterKey(seed)
+	for _, test := range tests {
+		ek := master.Derive(test.path)
+		if hex.EncodeToString(ek.ChainCode[:]) != test.chainCode {
+			t.Errorf("%v: wrong chain code %x", FormatDerivationPath(test.path), ek.ChainCode)
+		} else if hex.EncodeToString(ek.Key[:]) != test.key {
+			t.Errorf("%v: wrong key %x", FormatDerivationPath(test.path), ek.Key)
+		}
+	}
+
+	// unhardened indices are hardened implicitly
+	if master.Child(7) != master.Child(7|HardenedOffset) {
+		t.Fatal("Child should harden its index")
+	}
+
+	if s := FormatDerivationPath(KeyPath(2, 5)); s != "m/44'/1991'/2'/0'/5'" {
+		t.Fatal("wrong key path:", s)
+	}
+
+	// batch derivation should match individual derivation
+	pks := DerivePublicKeys(seed, 1, 10, 5)
+	for i, pk := range pks {
+		if exp := DeriveKey(seed, 1, 10+uint32(i)).PublicKey(); pk != exp {
+			t.Fatalf("key %v: batch derivation does not match", 10+i)
+		}
+	}
+	if DeriveKey(seed, 0, 0).PublicKey() == DeriveKey(seed, 1, 0).PublicKey() {
+		t.Fatal("accounts should have distinct keys")
+	}
+}
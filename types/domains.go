@@ -0,0 +1,97 @@
+package types
+
+// Domain-separation prefixes for signed objects. Unless otherwise noted, the
+// prefix is written with Encoder.WriteString (i.e. as a length-prefixed
+// string) before the remainder of the object, and the resulting bytes are
+// hashed with BLAKE2b-256.
+const (
+	DomainTransactionInput        = "sia/sig/transactioninput"
+	DomainTransactionInputPartial = "sia/sig/transactioninputpartial"
+	DomainFileContract            = "sia/sig/filecontract"
+	DomainFileContractRenewal     = "sia/sig/filecontractrenewal"
+	DomainAttestation             = "sia/sig/attestation"
+	DomainRHPTransfer             = "sia/sig/rhptransfer"
+	DomainRHPKeyCertificate       = "sia/sig/rhpkeycertificate"
+	DomainRHPPriceTable           = "sia/sig/rhppricetable"
+
+	// DomainRHPChallenge is written as raw bytes, zero-padded to 16 bytes,
+	// followed by the 16-byte challenge.
+	DomainRHPChallenge = "challenge"
+)
+
+// A SigningDomain describes how the hash of a signed object is computed.
+type SigningDomain struct {
+	// Object is a human-readable name for the signed object.
+	Object string
+	// Prefix is the domain-separation prefix. It is empty for objects that
+	// are hashed without domain separation, for compatibility with earlier
+	// versions of the protocol.
+	Prefix string
+	// Layout describes the bytes that are hashed.
+	Layout string
+}
+
+// SigningDomains returns a description of every object signed within the Sia
+// protocol. Test vectors for each domain can be found in the consensus and rhp
+// packages.
+func SigningDomains() []SigningDomain {
+	return []SigningDomain{
+		{
+			Object: "transaction input",
+			Prefix: DomainTransactionInput,
+			Layout: "prefix | chain ID | siacoin input parent IDs | siacoin outputs | siafund input parent IDs | siafund outputs | file contracts | (parent ID, revision) of each revision | (parent ID, renewal, storage proof window start, finalization) of each resolution | attestations | arbitrary data | new foundation address | miner fee",
+		},
+		{
+			Object: "transaction input (with SigHash flags other than SigHashAll)",
+			Prefix: DomainTransactionInputPartial,
+			Layout: "prefix | chain ID | flags | (type, parent ID) of the signed input, or siacoin and siafund input parent IDs | output of the same type and index as the signed input, or siacoin and siafund outputs | file contracts | (parent ID, revision) of each revision | (parent ID, renewal, storage proof window start, finalization) of each resolution | attestations | arbitrary data | new foundation address | miner fee, unless SigHashAnyoneCanPay is set",
+		},
+		{
+			Object: "file contract",
+			Prefix: DomainFileContract,
+			Layout: "prefix | chain ID | filesize | file merkle root | window start | window end | renter output | host output | missed host value | renter public key | host public key | revision number",
+		},
+		{
+			Object: "file contract renewal",
+			Prefix: DomainFileContractRenewal,
+			Layout: "prefix | chain ID | final revision | initial revision | renter rollover | host rollover",
+		},
+		{
+			Object: "attestation",
+			Prefix: DomainAttestation,
+			Layout: "prefix | chain ID | public key | key | value",
+		},
+		{
+			Object: "rhp session challenge",
+			Prefix: DomainRHPChallenge,
+			Layout: "prefix (raw, zero-padded to 16 bytes) | challenge (16 bytes)",
+		},
+		{
+			Object: "rhp transfer authorization",
+			Prefix: DomainRHPTransfer,
+			Layout: "prefix | contract ID | source host | destination host | sector roots | expiration height",
+		},
+		{
+			Object: "rhp key certificate",
+			Prefix: DomainRHPKeyCertificate,
+			Layout: "prefix | identity key | operational key | valid from | valid until",
+		},
+		{
+			Object: "rhp price table",
+			Prefix: DomainRHPPriceTable,
+			Layout: "prefix | UID | host settings",
+		},
+		{
+			Object: "rhp withdrawal message",
+			Layout: "account ID | expiry | amount | nonce",
+		},
+		{
+			Object: "rhp receipt",
+			Layout: "account | host | amount | timestamp",
+		},
+		{
+			Object: "rhp registry value",
+			Layout: "tweak (raw) | data | revision | type (as uint64)",
+		},
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/blake2b"
@@ -89,15 +90,75 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// Reset discards any pending data and clears the Encoder's error, allowing it
+// to be reused with a new stream.
+func (e *Encoder) Reset(w io.Writer) {
+	e.w = w
+	e.n = 0
+	e.err = nil
+}
+
+var encoderPool = sync.Pool{New: func() interface{} { return new(Encoder) }}
+
+// NewPooledEncoder is like NewEncoder, but obtains the Encoder from a pool,
+// avoiding an allocation. Callers should return it to the pool with Release.
+func NewPooledEncoder(w io.Writer) *Encoder {
+	e := encoderPool.Get().(*Encoder)
+	e.Reset(w)
+	return e
+}
+
+// Release returns the Encoder to the pool used by NewPooledEncoder. Any pending
+// data is discarded, so callers should call Flush first. The Encoder must not
+// be used after calling Release; in particular, Encoders belonging to a Hasher
+// or CountingEncoder must not be released.
+func (e *Encoder) Release() {
+	e.Reset(nil)
+	encoderPool.Put(e)
+}
+
 // An EncoderTo can encode itself to a stream via an Encoder.
 type EncoderTo interface {
 	EncodeTo(e *Encoder)
 }
 
+// A CountingEncoder discards the objects written to it, counting only their
+// encoded length. It is useful for computing exact sizes (e.g. for fee
+// calculation) without allocating a buffer.
+type CountingEncoder struct {
+	n int
+	E *Encoder
+}
+
+// Write implements io.Writer.
+func (ce *CountingEncoder) Write(p []byte) (int, error) {
+	ce.n += len(p)
+	return len(p), nil
+}
+
+// Reset resets the count to zero.
+func (ce *CountingEncoder) Reset() {
+	_ = ce.E.Flush() // no error possible
+	ce.n = 0
+}
+
+// Len returns the number of bytes written to the CountingEncoder.
+func (ce *CountingEncoder) Len() int {
+	_ = ce.E.Flush() // no error possible
+	return ce.n
+}
+
+// NewCountingEncoder returns a new CountingEncoder.
+func NewCountingEncoder() *CountingEncoder {
+	ce := new(CountingEncoder)
+	ce.E = NewEncoder(ce)
+	return ce
+}
+
 // EncodedLen returns the length of v when encoded.
 func EncodedLen(v interface{}) int {
-	var buf bytes.Buffer
-	e := NewEncoder(&buf)
+	ce := NewCountingEncoder()
+	e := ce.E
 	if et, ok := v.(EncoderTo); ok {
 		et.EncodeTo(e)
 	} else {
@@ -117,8 +178,7 @@ func EncodedLen(v interface{}) int {
 			panic(fmt.Sprintf("cannot encode type %T", v))
 		}
 	}
-	_ = e.Flush() // no error possible
-	return buf.Len()
+	return ce.Len()
 }
 
 // A Decoder reads values from an underlying stream. Callers MUST check
@@ -127,6 +187,7 @@ type Decoder struct {
 	lr  io.LimitedReader
 	buf [64]byte
 	err error
+	src []byte // set by NewBufDecoder
 }
 
 // SetErr sets the Decoder's error if it has not already been set. SetErr should
@@ -145,6 +206,12 @@ func (d *Decoder) Err() error { return d.err }
 // Read implements the io.Reader interface. It always returns an error if fewer
 // than len(p) bytes were read.
 func (d *Decoder) Read(p []byte) (int, error) {
+	if len(p) > len(d.buf) && d.err == nil {
+		// large reads go directly into p rather than through d.buf
+		var n int
+		n, d.err = io.ReadFull(&d.lr, p)
+		return n, d.err
+	}
 	n := 0
 	for len(p[n:]) > 0 && d.err == nil {
 		want := len(p[n:])
@@ -196,6 +263,35 @@ func (d *Decoder) ReadPrefix() int {
 	return int(n)
 }
 
+// ReadPrefixMax reads a length prefix from the underlying stream. If the
+// length exceeds max, or the number of bytes remaining in the stream,
+// ReadPrefixMax sets d.Err and returns 0.
+func (d *Decoder) ReadPrefixMax(max int) int {
+	n := d.ReadPrefix()
+	if n > max {
+		d.SetErr(fmt.Errorf("encoded object contains invalid length prefix (%v elems > %v max)", n, max))
+		return 0
+	}
+	return n
+}
+
+// ReadSlicePrefix reads the length prefix of a slice whose elements each
+// occupy at least elemLen bytes in the stream. If the elements could not
+// possibly fit in the bytes remaining in the stream, ReadSlicePrefix sets d.Err
+// and returns 0. Unlike ReadPrefix, this bounds the size of the slice allocated
+// by the caller, not merely its length.
+func (d *Decoder) ReadSlicePrefix(elemLen int) int {
+	n := d.ReadUint64()
+	if elemLen < 1 {
+		elemLen = 1
+	}
+	if n > uint64(d.lr.N)/uint64(elemLen) {
+		d.SetErr(fmt.Errorf("encoded object contains invalid length prefix (%v elems * %v bytes > %v bytes left in stream)", n, elemLen, d.lr.N))
+		return 0
+	}
+	return int(n)
+}
+
 // ReadTime reads a time.Time from the underlying stream.
 func (d *Decoder) ReadTime() time.Time { return time.Unix(int64(d.ReadUint64()), 0).UTC() }
 
@@ -206,6 +302,32 @@ func (d *Decoder) ReadBytes() []byte {
 	return b
 }
 
+// ReadBytesNoCopy reads a length-prefixed []byte from the underlying stream.
+// If the Decoder was created by NewBufDecoder, the returned slice aliases the
+// Decoder's buffer instead of being copied from it; otherwise, ReadBytesNoCopy
+// is equivalent to ReadBytes.
+//
+// Aliasing is only safe if the buffer is not modified while the returned slice
+// is in use, and vice versa. The capacity of the returned slice is limited to
+// its length, so appending to it will not overwrite the rest of the buffer.
+func (d *Decoder) ReadBytesNoCopy() []byte {
+	if d.src == nil {
+		return d.ReadBytes()
+	}
+	n := d.ReadPrefix()
+	if d.err != nil {
+		return nil
+	}
+	off := len(d.src) - int(d.lr.N)
+	b := d.src[off : off+n : off+n]
+	if _, err := d.lr.R.(*bytes.Reader).Seek(int64(n), io.SeekCurrent); err != nil {
+		d.SetErr(err)
+		return nil
+	}
+	d.lr.N -= int64(n)
+	return b
+}
+
 // ReadString reads a length-prefixed string from the underlying stream.
 func (d *Decoder) ReadString() string {
 	return string(d.ReadBytes())
@@ -218,17 +340,47 @@ func NewDecoder(lr io.LimitedReader) *Decoder {
 	}
 }
 
+// Reset clears the Decoder's error, allowing it to be reused with a new
+// stream.
+func (d *Decoder) Reset(lr io.LimitedReader) {
+	d.lr = lr
+	d.buf = [len(d.buf)]byte{}
+	d.err = nil
+	d.src = nil
+}
+
 // A DecoderFrom can decode itself from a stream via a Decoder.
 type DecoderFrom interface {
 	DecodeFrom(d *Decoder)
 }
 
+// ErrTrailingData is returned by DecodeFromStrict when bytes remain after
+// decoding an object.
+var ErrTrailingData = errors.New("trailing data after encoded object")
+
+// DecodeFromStrict decodes v from buf, which must contain exactly one encoded
+// object. Unlike decoding from a NewBufDecoder, it returns ErrTrailingData if
+// any bytes remain after v is decoded, so that a given object has only one
+// valid encoding.
+func DecodeFromStrict(buf []byte, v DecoderFrom) error {
+	d := NewBufDecoder(buf)
+	v.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		return err
+	} else if d.lr.N != 0 {
+		return fmt.Errorf("%w (%v bytes)", ErrTrailingData, d.lr.N)
+	}
+	return nil
+}
+
 // NewBufDecoder returns a Decoder for the provided byte slice.
 func NewBufDecoder(buf []byte) *Decoder {
-	return NewDecoder(io.LimitedReader{
+	d := NewDecoder(io.LimitedReader{
 		R: bytes.NewReader(buf),
 		N: int64(len(buf)),
 	})
+	d.src = buf
+	return d
 }
 
 // A Hasher streams objects into an instance of Sia's hash function.
@@ -339,6 +491,11 @@ func (in SiacoinInput) EncodeTo(e *Encoder) {
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
 	}
+	e.WritePrefix(len(in.Preimages))
+	for _, p := range in.Preimages {
+		e.Write(p[:])
+	}
+	e.WriteUint8(uint8(in.SigHash))
 }
 
 // EncodeTo implements types.EncoderTo.
@@ -357,6 +514,11 @@ func (in SiafundInput) EncodeTo(e *Encoder) {
 	for _, sig := range in.Signatures {
 		sig.EncodeTo(e)
 	}
+	e.WritePrefix(len(in.Preimages))
+	for _, p := range in.Preimages {
+		e.Write(p[:])
+	}
+	e.WriteUint8(uint8(in.SigHash))
 }
 
 // EncodeTo implements types.EncoderTo.
@@ -435,6 +597,8 @@ const (
 	opPublicKey
 	opThreshold
 	opUnlockConditions
+	opHash
+	opOpaque
 )
 
 // WritePolicy writes a SpendPolicy to the underlying stream.
@@ -465,6 +629,12 @@ func (e *Encoder) WritePolicy(p SpendPolicy) {
 				p.PublicKeys[i].EncodeTo(e)
 			}
 			writeUint8(p.SignaturesRequired)
+		case PolicyHash:
+			writeUint8(opHash)
+			Hash256(p).EncodeTo(e)
+		case PolicyOpaque:
+			writeUint8(opOpaque)
+			Address(p).EncodeTo(e)
 		default:
 			panic(fmt.Sprintf("unhandled policy type, %T", p))
 		}
@@ -663,6 +833,14 @@ func (d *Decoder) ReadPolicy() (p SpendPolicy) {
 			}
 			uc.SignaturesRequired = readUint8()
 			return uc, nil
+		case opHash:
+			var h Hash256
+			h.DecodeFrom(d)
+			return PolicyHash(h), nil
+		case opOpaque:
+			var addr Address
+			addr.DecodeFrom(d)
+			return PolicyOpaque(addr), nil
 		default:
 			return nil, fmt.Errorf("unknown policy (opcode %v)", op)
 		}
@@ -677,8 +855,23 @@ func (d *Decoder) ReadPolicy() (p SpendPolicy) {
 	return p
 }
 
+// Minimum encoded lengths of objects that appear in slices, used to bound
+// allocations when decoding untrusted length prefixes.
+const (
+	minStateElementLen           = 40 + 8 + 8
+	minSiacoinInputLen           = minStateElementLen + 48 + 8 + 2 + 8 + 8 + 1
+	siacoinOutputLen             = 16 + 32
+	minSiafundInputLen           = minStateElementLen + 40 + 16 + 32 + 2 + 8 + 8 + 1
+	siafundOutputLen             = 8 + 32
+	fileContractLen              = 8 + 32 + 8 + 8 + 2*siacoinOutputLen + 16 + 16 + 32 + 32 + 8 + 64 + 64
+	minFileContractElementLen    = minStateElementLen + fileContractLen
+	minFileContractRevisionLen   = minFileContractElementLen + fileContractLen
+	minFileContractResolutionLen = minFileContractElementLen
+	minAttestationLen            = 32 + 8 + 8 + 64
+)
+
 func (d *Decoder) readMerkleProof() []Hash256 {
-	proof := make([]Hash256, d.ReadPrefix())
+	proof := make([]Hash256, d.ReadSlicePrefix(len(Hash256{})))
 	for i := range proof {
 		proof[i].DecodeFrom(d)
 	}
@@ -696,10 +889,15 @@ func (se *StateElement) DecodeFrom(d *Decoder) {
 func (in *SiacoinInput) DecodeFrom(d *Decoder) {
 	in.Parent.DecodeFrom(d)
 	in.SpendPolicy = d.ReadPolicy()
-	in.Signatures = make([]Signature, d.ReadPrefix())
+	in.Signatures = make([]Signature, d.ReadSlicePrefix(len(Signature{})))
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
 	}
+	in.Preimages = make([][32]byte, d.ReadSlicePrefix(32))
+	for i := range in.Preimages {
+		d.Read(in.Preimages[i][:])
+	}
+	in.SigHash = SigHashFlags(d.ReadUint8())
 }
 
 // DecodeFrom implements types.DecoderFrom.
@@ -714,10 +912,15 @@ func (in *SiafundInput) DecodeFrom(d *Decoder) {
 	in.Parent.DecodeFrom(d)
 	in.ClaimAddress.DecodeFrom(d)
 	in.SpendPolicy = d.ReadPolicy()
-	in.Signatures = make([]Signature, d.ReadPrefix())
+	in.Signatures = make([]Signature, d.ReadSlicePrefix(len(Signature{})))
 	for i := range in.Signatures {
 		in.Signatures[i].DecodeFrom(d)
 	}
+	in.Preimages = make([][32]byte, d.ReadSlicePrefix(32))
+	for i := range in.Preimages {
+		d.Read(in.Preimages[i][:])
+	}
+	in.SigHash = SigHashFlags(d.ReadUint8())
 }
 
 // DecodeFrom implements types.DecoderFrom.
@@ -795,49 +998,49 @@ func (txn *Transaction) DecodeFrom(d *Decoder) {
 	fields := d.ReadUint64()
 
 	if fields&(1<<0) != 0 {
-		txn.SiacoinInputs = make([]SiacoinInput, d.ReadPrefix())
+		txn.SiacoinInputs = make([]SiacoinInput, d.ReadSlicePrefix(minSiacoinInputLen))
 		for i := range txn.SiacoinInputs {
 			txn.SiacoinInputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<1) != 0 {
-		txn.SiacoinOutputs = make([]SiacoinOutput, d.ReadPrefix())
+		txn.SiacoinOutputs = make([]SiacoinOutput, d.ReadSlicePrefix(siacoinOutputLen))
 		for i := range txn.SiacoinOutputs {
 			txn.SiacoinOutputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<2) != 0 {
-		txn.SiafundInputs = make([]SiafundInput, d.ReadPrefix())
+		txn.SiafundInputs = make([]SiafundInput, d.ReadSlicePrefix(minSiafundInputLen))
 		for i := range txn.SiafundInputs {
 			txn.SiafundInputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<3) != 0 {
-		txn.SiafundOutputs = make([]SiafundOutput, d.ReadPrefix())
+		txn.SiafundOutputs = make([]SiafundOutput, d.ReadSlicePrefix(siafundOutputLen))
 		for i := range txn.SiafundOutputs {
 			txn.SiafundOutputs[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<4) != 0 {
-		txn.FileContracts = make([]FileContract, d.ReadPrefix())
+		txn.FileContracts = make([]FileContract, d.ReadSlicePrefix(fileContractLen))
 		for i := range txn.FileContracts {
 			txn.FileContracts[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<5) != 0 {
-		txn.FileContractRevisions = make([]FileContractRevision, d.ReadPrefix())
+		txn.FileContractRevisions = make([]FileContractRevision, d.ReadSlicePrefix(minFileContractRevisionLen))
 		for i := range txn.FileContractRevisions {
 			txn.FileContractRevisions[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<6) != 0 {
-		txn.FileContractResolutions = make([]FileContractResolution, d.ReadPrefix())
+		txn.FileContractResolutions = make([]FileContractResolution, d.ReadSlicePrefix(minFileContractResolutionLen))
 		for i := range txn.FileContractResolutions {
 			txn.FileContractResolutions[i].DecodeFrom(d)
 		}
 	}
 	if fields&(1<<7) != 0 {
-		txn.Attestations = make([]Attestation, d.ReadPrefix())
+		txn.Attestations = make([]Attestation, d.ReadSlicePrefix(minAttestationLen))
 		for i := range txn.Attestations {
 			txn.Attestations[i].DecodeFrom(d)
 		}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"math"
 	"math/rand"
@@ -122,13 +123,186 @@ func TestEncoderCompleteness(t *testing.T) {
 		e.Flush()
 		var decTxn Transaction
 		decTxn.DecodeFrom(NewBufDecoder(buf.Bytes()))
-		return reflect.DeepEqual(txn, decTxn)
+		return reflect.DeepEqual(txn, decTxn) && EncodedLen(txn) == buf.Len()
 	}
 	if quick.Check(checkFn, cfg) != nil {
 		t.Fatalf("roundtrip test failed; did you forget to update transaction encoder? (seed = %v)", seed)
 	}
 }
 
+func TestCountingEncoder(t *testing.T) {
+	txn := quickValue(reflect.TypeOf(Transaction{}), rand.New(rand.NewSource(0))).Interface().(Transaction)
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	txn.EncodeTo(e)
+	e.Flush()
+
+	ce := NewCountingEncoder()
+	txn.EncodeTo(ce.E)
+	if ce.Len() != buf.Len() {
+		t.Fatalf("expected length %v, got %v", buf.Len(), ce.Len())
+	}
+	txn.EncodeTo(ce.E)
+	if ce.Len() != 2*buf.Len() {
+		t.Fatalf("expected length %v, got %v", 2*buf.Len(), ce.Len())
+	}
+	ce.Reset()
+	ce.E.WriteUint64(0)
+	if ce.Len() != 8 {
+		t.Fatalf("expected length 8 after reset, got %v", ce.Len())
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	e := NewPooledEncoder(&buf1)
+	e.WriteUint64(1)
+	e.Reset(&buf2)
+	e.WriteUint64(2)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	} else if buf1.Len() != 0 {
+		t.Fatal("expected pending data to be discarded by Reset")
+	}
+	e.Release()
+
+	// a released Encoder should be reusable, even after an error
+	e = NewPooledEncoder(errWriter{})
+	e.WriteUint64(3)
+	if e.Flush() == nil {
+		t.Fatal("expected write error")
+	}
+	e.Release()
+	e = NewPooledEncoder(&buf1)
+	e.WriteUint64(3)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	e.Release()
+
+	d := NewBufDecoder(buf2.Bytes())
+	if d.ReadUint64() != 2 || d.Err() != nil {
+		t.Fatal("wrong value decoded")
+	} else if d.ReadUint64(); d.Err() == nil {
+		t.Fatal("expected EOF")
+	}
+	d.Reset(io.LimitedReader{R: &buf1, N: 8})
+	if d.ReadUint64() != 3 || d.Err() != nil {
+		t.Fatal("wrong value decoded after Reset")
+	}
+}
+
+func TestDecodeFromStrict(t *testing.T) {
+	id := BlockID{1, 2, 3}
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	id.EncodeTo(e)
+	e.Flush()
+
+	var decoded BlockID
+	if err := DecodeFromStrict(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	} else if decoded != id {
+		t.Fatal("wrong value decoded")
+	} else if err := DecodeFromStrict(append(buf.Bytes(), 0), &decoded); !errors.Is(err, ErrTrailingData) {
+		t.Fatalf("expected %v, got %v", ErrTrailingData, err)
+	} else if err := DecodeFromStrict(buf.Bytes()[:16], &decoded); err == nil || errors.Is(err, ErrTrailingData) {
+		t.Fatal("expected EOF, got", err)
+	}
+}
+
+func TestReadSlicePrefix(t *testing.T) {
+	// a transaction claiming 1000 file contracts in 1000 bytes should be
+	// rejected before anything is allocated
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WriteUint64(1 << 4)
+	e.WritePrefix(1000)
+	e.Write(make([]byte, 1000))
+	e.Flush()
+	var txn Transaction
+	d := NewBufDecoder(buf.Bytes())
+	txn.DecodeFrom(d)
+	if d.Err() == nil {
+		t.Fatal("expected invalid prefix to be rejected")
+	} else if len(txn.FileContracts) != 0 {
+		t.Fatal("expected no file contracts to be allocated")
+	}
+
+	d = NewBufDecoder(make([]byte, 8+10))
+	if n := d.ReadPrefixMax(1); n != 0 || d.Err() != nil {
+		t.Fatal("unexpected result", n, d.Err())
+	}
+	buf.Reset()
+	e.WritePrefix(2)
+	e.Flush()
+	d = NewBufDecoder(append(buf.Bytes(), make([]byte, 10)...))
+	if n := d.ReadPrefixMax(1); n != 0 || d.Err() == nil {
+		t.Fatal("expected prefix exceeding max to be rejected")
+	}
+
+	// the minimum lengths must never exceed the actual encoded lengths
+	minLens := []struct {
+		v   interface{}
+		min int
+	}{
+		{SiacoinInput{SpendPolicy: PolicyThreshold{}}, minSiacoinInputLen},
+		{SiacoinOutput{}, siacoinOutputLen},
+		{SiafundInput{SpendPolicy: PolicyThreshold{}}, minSiafundInputLen},
+		{SiafundOutput{}, siafundOutputLen},
+		{FileContract{}, fileContractLen},
+		{FileContractRevision{}, minFileContractRevisionLen},
+		{FileContractResolution{}, minFileContractResolutionLen},
+		{Attestation{}, minAttestationLen},
+	}
+	for _, ml := range minLens {
+		if n := EncodedLen(ml.v); n < ml.min {
+			t.Errorf("%T: minimum length %v exceeds encoded length %v", ml.v, ml.min, n)
+		}
+	}
+}
+
+func TestReadBytesNoCopy(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WriteBytes([]byte("foo"))
+	e.WriteBytes(bytes.Repeat([]byte{1}, 100))
+	e.WriteUint64(7)
+	e.Flush()
+	b := buf.Bytes()
+
+	d := NewBufDecoder(b)
+	foo := d.ReadBytesNoCopy()
+	ones := d.ReadBytesNoCopy()
+	seven := d.ReadUint64()
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	} else if string(foo) != "foo" || !bytes.Equal(ones, bytes.Repeat([]byte{1}, 100)) || seven != 7 {
+		t.Fatal("wrong values decoded")
+	} else if &foo[0] != &b[8] {
+		t.Fatal("expected decoded bytes to alias buffer")
+	} else if cap(foo) != len(foo) {
+		t.Fatal("expected capacity to be limited")
+	}
+
+	// should copy when not decoding from a buffer
+	d = NewDecoder(io.LimitedReader{R: bytes.NewReader(b), N: int64(len(b))})
+	if foo := d.ReadBytesNoCopy(); string(foo) != "foo" || &foo[0] == &b[8] {
+		t.Fatal("expected decoded bytes to be copied")
+	}
+
+	// invalid prefix
+	d = NewBufDecoder(b[:20])
+	d.ReadBytesNoCopy()
+	if d.ReadBytesNoCopy() != nil || d.Err() == nil {
+		t.Fatal("expected invalid prefix to be rejected")
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
 func BenchmarkEncoding(b *testing.B) {
 	txn := quickValue(reflect.TypeOf(Transaction{}), rand.New(rand.NewSource(0))).Interface().(Transaction)
 	e := NewEncoder(io.Discard)
@@ -139,3 +313,16 @@ func BenchmarkEncoding(b *testing.B) {
 		txn.EncodeTo(e)
 	}
 }
+
+func BenchmarkPooledEncoder(b *testing.B) {
+	txn := quickValue(reflect.TypeOf(Transaction{}), rand.New(rand.NewSource(0))).Interface().(Transaction)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e := NewPooledEncoder(io.Discard)
+		txn.EncodeTo(e)
+		e.Flush()
+		e.Release()
+	}
+}
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"lukechampine.com/frand"
+)
+
+// Randomness consumed by core that tests may need to reproduce (key generation
+// helpers, handshake challenges, shuffles, etc.) is read via ReadEntropy. By
+// default, entropy is drawn from frand, which is seeded by the operating
+// system's CSPRNG. Transport encryption keys and nonces (see net/mux) are
+// always drawn from frand directly, and are unaffected by SetEntropySource.
+
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return io.ReadFull(lr.r, p)
+}
+
+type entropyHolder struct{ r io.Reader }
+
+var entropySource atomic.Value
+
+// SetEntropySource replaces the source of randomness used throughout core.
+// Reads from r are serialized, so r need not be safe for concurrent use.
+// Passing nil restores the default source.
+//
+// This is primarily intended for tests that require reproducible output, e.g.
+// by passing a deterministic generator such as frand.NewCustom. Never use a
+// deterministic source in production.
+func SetEntropySource(r io.Reader) {
+	if r != nil {
+		r = &lockedReader{r: r}
+	}
+	entropySource.Store(entropyHolder{r})
+}
+
+// ReadEntropy fills b with random bytes from the current entropy source. It
+// panics if the source returns an error.
+func ReadEntropy(b []byte) {
+	h, _ := entropySource.Load().(entropyHolder)
+	if h.r == nil {
+		frand.Read(b)
+		return
+	}
+	if _, err := h.r.Read(b); err != nil {
+		panic("types: entropy source failed: " + err.Error())
+	}
+}
+
+// Entropy128 returns 128 random bits from the current entropy source.
+func Entropy128() (b [16]byte) {
+	ReadEntropy(b[:])
+	return
+}
+
+// Entropy256 returns 256 random bits from the current entropy source.
+func Entropy256() (b [32]byte) {
+	ReadEntropy(b[:])
+	return
+}
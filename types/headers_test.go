@@ -0,0 +1,51 @@
+package types
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompactHeaders(t *testing.T) {
+	hs := make(CompactHeaders, 100)
+	hs[0] = BlockHeader{Height: 1000, ParentID: BlockID{1}, Timestamp: time.Unix(1234, 0).UTC()}
+	for i := 1; i < len(hs); i++ {
+		hs[i] = BlockHeader{
+			Height:       hs[i-1].Height + 1,
+			ParentID:     hs[i-1].ID(),
+			Nonce:        uint64(i),
+			Timestamp:    hs[i-1].Timestamp.Add(time.Duration(i) * time.Second),
+			MinerAddress: Address{byte(i)},
+			Commitment:   Hash256{byte(i)},
+		}
+	}
+
+	for _, n := range []int{0, 1, len(hs)} {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		hs[:n].EncodeTo(e)
+		e.Flush()
+		if buf.Len() != hs[:n].EncodedLen() {
+			t.Fatalf("expected %v bytes, got %v", hs[:n].EncodedLen(), buf.Len())
+		}
+		var decoded CompactHeaders
+		if err := DecodeFromStrict(buf.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(decoded, hs[:n]) {
+			t.Fatal("headers did not survive roundtrip")
+		}
+	}
+
+	if EncodedLen(hs) >= EncodedLen(hs[0])*len(hs) {
+		t.Fatal("compact encoding is not compact")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic when encoding non-chain")
+		}
+	}()
+	hs[50].Nonce++
+	EncodedLen(hs)
+}
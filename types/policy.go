@@ -2,12 +2,21 @@ package types
 
 import (
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // A SpendPolicy describes the conditions under which an input may be spent.
+//
+// The String method of each policy returns its form in a small textual
+// language, which can be parsed with ParseSpendPolicy.
 type SpendPolicy interface {
 	isPolicy()
+	String() string
 }
 
 // AnyoneCanSpend returns a policy that has no requirements.
@@ -25,19 +34,295 @@ type PolicyThreshold struct {
 	Of []SpendPolicy
 }
 
+// PolicyHash requires the input to reveal a 32-byte preimage whose hash (as
+// computed by HashBytes) is the given value. It can be used to construct
+// hash-locked contracts, e.g. for atomic swaps.
+type PolicyHash Hash256
+
+// PolicyOpaque is the opaque form of a policy: the address of the policy it
+// stands in for. Since a threshold policy's address commits only to the
+// addresses of its sub-policies, any sub-policy may be replaced by its opaque
+// form without changing the address. A spender therefore only needs to reveal
+// the sub-policies they actually satisfy; the rest remain hidden. An opaque
+// policy can never be satisfied directly.
+type PolicyOpaque Address
+
 // PolicyUnlockConditions reproduces the requirements imposed by Sia's original
 // "UnlockConditions" type. It exists for compatibility purposes and should not
 // be used to construct new policies.
 type PolicyUnlockConditions struct {
-	Timelock           uint64
-	PublicKeys         []PublicKey
-	SignaturesRequired uint8
+	Timelock           uint64      `json:"timelock"`
+	PublicKeys         []PublicKey `json:"publicKeys"`
+	SignaturesRequired uint8       `json:"signaturesRequired"`
+}
+
+// AllOf returns a policy that requires all of the given policies to be
+// satisfied.
+func AllOf(ps ...SpendPolicy) PolicyThreshold {
+	if len(ps) > 255 {
+		panic("too many sub-policies") // developer error
+	}
+	return PolicyThreshold{N: uint8(len(ps)), Of: ps}
+}
+
+// AnyOf returns a policy that requires at least one of the given policies to
+// be satisfied.
+func AnyOf(ps ...SpendPolicy) PolicyThreshold {
+	if len(ps) > 255 {
+		panic("too many sub-policies") // developer error
+	}
+	return PolicyThreshold{N: 1, Of: ps}
+}
+
+// MultisigPolicy returns a policy that requires signatures from at least m of
+// the given keys. As with any threshold policy, the signatures must appear in
+// the same order as their keys.
+func MultisigPolicy(m int, keys ...PublicKey) PolicyThreshold {
+	if m < 0 || m > len(keys) || len(keys) > 255 {
+		panic(fmt.Sprintf("invalid multisig parameters (%v of %v)", m, len(keys))) // developer error
+	}
+	of := make([]SpendPolicy, len(keys))
+	for i := range keys {
+		of[i] = PolicyPublicKey(keys[i])
+	}
+	return PolicyThreshold{N: uint8(m), Of: of}
+}
+
+// PolicyCost returns the minimum number of signatures and preimages that must
+// accompany p in order to satisfy it, ignoring height requirements. When
+// sub-policies can be satisfied in multiple ways, the cheapest (by encoded
+// size) is chosen. If p cannot be satisfied at all, e.g. because it contains a
+// threshold that exceeds its number of sub-policies, ok is false.
+func PolicyCost(p SpendPolicy) (sigs, preimages int, ok bool) {
+	switch p := p.(type) {
+	case PolicyAbove:
+		return 0, 0, true
+	case PolicyPublicKey:
+		return 1, 0, true
+	case PolicyHash:
+		return 0, 1, true
+	case PolicyOpaque:
+		return 0, 0, false
+	case PolicyUnlockConditions:
+		return int(p.SignaturesRequired), 0, int(p.SignaturesRequired) <= len(p.PublicKeys)
+	case PolicyThreshold:
+		type cost struct{ sigs, preimages int }
+		size := func(c cost) int { return c.sigs*64 + c.preimages*32 }
+		var costs []cost
+		for _, sub := range p.Of {
+			if s, pi, ok := PolicyCost(sub); ok {
+				costs = append(costs, cost{s, pi})
+			}
+		}
+		if len(costs) < int(p.N) {
+			return 0, 0, false
+		}
+		sort.Slice(costs, func(i, j int) bool { return size(costs[i]) < size(costs[j]) })
+		for _, c := range costs[:p.N] {
+			sigs += c.sigs
+			preimages += c.preimages
+		}
+		return sigs, preimages, true
+	}
+	panic(fmt.Sprintf("unhandled policy type, %T", p)) // developer error
 }
 
 func (PolicyAbove) isPolicy()            {}
 func (PolicyPublicKey) isPolicy()        {}
 func (PolicyThreshold) isPolicy()        {}
 func (PolicyUnlockConditions) isPolicy() {}
+func (PolicyHash) isPolicy()             {}
+func (PolicyOpaque) isPolicy()           {}
+
+// String implements SpendPolicy.
+func (p PolicyAbove) String() string { return fmt.Sprintf("above(%d)", uint64(p)) }
+
+// String implements SpendPolicy.
+func (p PolicyPublicKey) String() string { return fmt.Sprintf("pk(%v)", PublicKey(p)) }
+
+// String implements SpendPolicy.
+func (p PolicyThreshold) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "thresh(%d", p.N)
+	for _, sub := range p.Of {
+		sb.WriteString(", ")
+		if sub == nil {
+			sb.WriteString("<nil>")
+		} else {
+			sb.WriteString(sub.String())
+		}
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// String implements SpendPolicy.
+func (p PolicyUnlockConditions) String() string {
+	keys := make([]string, len(p.PublicKeys))
+	for i := range p.PublicKeys {
+		keys[i] = p.PublicKeys[i].String()
+	}
+	return fmt.Sprintf("uc(%d, [%v], %d)", p.Timelock, strings.Join(keys, ", "), p.SignaturesRequired)
+}
+
+// String implements SpendPolicy.
+func (p PolicyHash) String() string { return fmt.Sprintf("hash(%v)", Hash256(p)) }
+
+// String implements SpendPolicy.
+func (p PolicyOpaque) String() string { return fmt.Sprintf("opaque(%v)", Address(p)) }
+
+// A policyParser is a recursive-descent parser for the policy language. Like
+// Decoder, it records the first error encountered, after which all methods
+// return zero values.
+type policyParser struct {
+	s   string
+	i   int
+	err error
+}
+
+func (p *policyParser) setErr(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("at offset %d: %v", p.i, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *policyParser) skipSpace() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *policyParser) peek(c byte) bool {
+	p.skipSpace()
+	return p.err == nil && p.i < len(p.s) && p.s[p.i] == c
+}
+
+func (p *policyParser) expect(c byte) {
+	if !p.peek(c) {
+		p.setErr("expected %q", c)
+		return
+	}
+	p.i++
+}
+
+// token returns the next run of characters that are not whitespace or
+// punctuation.
+func (p *policyParser) token() string {
+	p.skipSpace()
+	if p.err != nil {
+		return ""
+	}
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n(),[]", p.s[p.i]) < 0 {
+		p.i++
+	}
+	if p.i == start {
+		p.setErr("expected token")
+	}
+	return p.s[start:p.i]
+}
+
+func (p *policyParser) uint(bitSize int) uint64 {
+	start := p.i
+	t := p.token()
+	if p.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseUint(t, 10, bitSize)
+	if err != nil {
+		p.i = start
+		p.setErr("invalid integer %q", t)
+	}
+	return n
+}
+
+func (p *policyParser) text(v interface{ UnmarshalText([]byte) error }) {
+	start := p.i
+	t := p.token()
+	if p.err != nil {
+		return
+	}
+	if err := v.UnmarshalText([]byte(t)); err != nil {
+		p.i = start
+		p.setErr("%v", err)
+	}
+}
+
+func (p *policyParser) policy() SpendPolicy {
+	start := p.i
+	name := p.token()
+	p.expect('(')
+	if p.err != nil {
+		return nil
+	}
+	var sp SpendPolicy
+	switch name {
+	case "above":
+		sp = PolicyAbove(p.uint(64))
+	case "pk":
+		var pk PublicKey
+		p.text(&pk)
+		sp = PolicyPublicKey(pk)
+	case "thresh":
+		thresh := PolicyThreshold{N: uint8(p.uint(8))}
+		for p.peek(',') {
+			p.i++
+			thresh.Of = append(thresh.Of, p.policy())
+		}
+		sp = thresh
+	case "uc":
+		var uc PolicyUnlockConditions
+		uc.Timelock = p.uint(64)
+		p.expect(',')
+		p.expect('[')
+		for !p.peek(']') && p.err == nil {
+			if len(uc.PublicKeys) > 0 {
+				p.expect(',')
+			}
+			var pk PublicKey
+			p.text(&pk)
+			uc.PublicKeys = append(uc.PublicKeys, pk)
+		}
+		p.expect(']')
+		p.expect(',')
+		uc.SignaturesRequired = uint8(p.uint(8))
+		sp = uc
+	case "hash":
+		var h Hash256
+		p.text(&h)
+		sp = PolicyHash(h)
+	case "opaque":
+		var addr Address
+		p.text(&addr)
+		sp = PolicyOpaque(addr)
+	default:
+		p.i = start
+		p.setErr("unknown policy type %q", name)
+	}
+	p.expect(')')
+	if p.err != nil {
+		return nil
+	}
+	return sp
+}
+
+// ParseSpendPolicy parses a policy from its textual form, as returned by its
+// String method. For example:
+//
+//	thresh(1, pk(ed25519:...), thresh(2, above(100000), hash(h:...)))
+//
+// Whitespace between tokens is ignored.
+func ParseSpendPolicy(s string) (SpendPolicy, error) {
+	p := &policyParser{s: s}
+	sp := p.policy()
+	if p.skipSpace(); p.err == nil && p.i != len(p.s) {
+		p.setErr("unexpected trailing characters")
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", p.err)
+	}
+	return sp, nil
+}
 
 func unlockConditionsRoot(uc PolicyUnlockConditions) Hash256 {
 	buf := make([]byte, 65)
@@ -90,10 +375,21 @@ func unlockConditionsRoot(uc PolicyUnlockConditions) Hash256 {
 
 // PolicyAddress computes the opaque address for a given policy.
 func PolicyAddress(p SpendPolicy) Address {
-	if uc, ok := p.(PolicyUnlockConditions); ok {
+	switch pt := p.(type) {
+	case PolicyUnlockConditions:
 		// NOTE: to preserve compatibility, we use the original address
 		// derivation code for these policies
-		return Address(unlockConditionsRoot(uc))
+		return Address(unlockConditionsRoot(pt))
+	case PolicyOpaque:
+		return Address(pt)
+	case PolicyThreshold:
+		// commit to the sub-policies via their addresses, so that they can be
+		// hidden behind PolicyOpaque
+		of := make([]SpendPolicy, len(pt.Of))
+		for i := range pt.Of {
+			of[i] = PolicyOpaque(PolicyAddress(pt.Of[i]))
+		}
+		p = PolicyThreshold{N: pt.N, Of: of}
 	}
 	h := hasherPool.Get().(*Hasher)
 	defer hasherPool.Put(h)
@@ -107,3 +403,111 @@ func PolicyAddress(p SpendPolicy) Address {
 func StandardAddress(pk PublicKey) Address {
 	return PolicyAddress(PolicyPublicKey(pk))
 }
+
+// policyJSON wraps a SpendPolicy for JSON encoding. Policies are encoded as an
+// object containing the policy type ("above", "pk", "thresh", "uc", "hash", or
+// "opaque") and the policy itself.
+type policyJSON struct {
+	Policy SpendPolicy
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p policyJSON) MarshalJSON() ([]byte, error) {
+	var typ string
+	var v interface{} = p.Policy
+	switch p := p.Policy.(type) {
+	case nil:
+		return []byte("null"), nil
+	case PolicyAbove:
+		typ = "above"
+	case PolicyPublicKey:
+		typ, v = "pk", PublicKey(p)
+	case PolicyThreshold:
+		typ = "thresh"
+	case PolicyUnlockConditions:
+		typ = "uc"
+	case PolicyHash:
+		typ, v = "hash", Hash256(p)
+	case PolicyOpaque:
+		typ, v = "opaque", Address(p)
+	default:
+		return nil, fmt.Errorf("unhandled policy type, %T", p)
+	}
+	return json.Marshal(struct {
+		Type   string      `json:"type"`
+		Policy interface{} `json:"policy"`
+	}{typ, v})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *policyJSON) UnmarshalJSON(b []byte) (err error) {
+	if string(b) == "null" {
+		p.Policy = nil
+		return nil
+	}
+	var v struct {
+		Type   string          `json:"type"`
+		Policy json.RawMessage `json:"policy"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v.Type {
+	case "above":
+		var height uint64
+		err = json.Unmarshal(v.Policy, &height)
+		p.Policy = PolicyAbove(height)
+	case "pk":
+		var pk PublicKey
+		err = json.Unmarshal(v.Policy, &pk)
+		p.Policy = PolicyPublicKey(pk)
+	case "thresh":
+		var thresh PolicyThreshold
+		err = json.Unmarshal(v.Policy, &thresh)
+		p.Policy = thresh
+	case "uc":
+		var uc PolicyUnlockConditions
+		err = json.Unmarshal(v.Policy, &uc)
+		p.Policy = uc
+	case "hash":
+		var h Hash256
+		err = json.Unmarshal(v.Policy, &h)
+		p.Policy = PolicyHash(h)
+	case "opaque":
+		var addr Address
+		err = json.Unmarshal(v.Policy, &addr)
+		p.Policy = PolicyOpaque(addr)
+	default:
+		return fmt.Errorf("unknown policy type %q", v.Type)
+	}
+	return
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p PolicyThreshold) MarshalJSON() ([]byte, error) {
+	of := make([]policyJSON, len(p.Of))
+	for i := range p.Of {
+		of[i].Policy = p.Of[i]
+	}
+	return json.Marshal(struct {
+		N  uint8        `json:"n"`
+		Of []policyJSON `json:"of"`
+	}{p.N, of})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *PolicyThreshold) UnmarshalJSON(b []byte) error {
+	var v struct {
+		N  uint8        `json:"n"`
+		Of []policyJSON `json:"of"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	p.N = v.N
+	p.Of = nil
+	for _, sub := range v.Of {
+		p.Of = append(p.Of, sub.Policy)
+	}
+	return nil
+}
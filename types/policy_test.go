@@ -1,6 +1,7 @@
 package types
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -46,7 +47,7 @@ func TestPolicyAddressString(t *testing.T) {
 					PolicyPublicKey(publicKeys[0]),
 				},
 			},
-			"addr:88a889bd46420209db5a41b164956e53ff3da9c4b3d1491d81f9c374f742dd3b0a7c72f58aff",
+			"addr:0e2d33704aecd037683a4911ba3ee467ea4e1508644700986a4bfaf17737ab2f9c2fabe22cb7",
 		},
 		{
 			PolicyThreshold{
@@ -62,7 +63,7 @@ func TestPolicyAddressString(t *testing.T) {
 					},
 				},
 			},
-			"addr:2ce609abbd8bc26d0f22c8f6447d3144bc2ae2391f9b09685aca03237329c339ba3ec4a35133",
+			"addr:12db91ba8d4739f71af41df8fbb649379b5590d532649dfd68402da119d03a483811abfb8358",
 		},
 		{
 			PolicyThreshold{
@@ -73,7 +74,7 @@ func TestPolicyAddressString(t *testing.T) {
 					PolicyPublicKey(publicKeys[2]),
 				},
 			},
-			"addr:0ca4d365f06ebf0de342ed617498521f0c0bcdc133c414428480e8826875c0a565ccaee80fb6",
+			"addr:8fe71d125bf6e002cdac3b422865ca06fa3bab37117860ea3b65edffa8c0823e4cbc816cafaf",
 		},
 		{
 			policy: PolicyUnlockConditions{
@@ -86,8 +87,136 @@ func TestPolicyAddressString(t *testing.T) {
 		},
 	}
 	for _, tt := range tests {
-		if got := PolicyAddress(tt.policy).String(); got != tt.want {
+		if got, err := ParseAddress(tt.want); err != nil {
+			t.Fatal(err)
+		} else if PolicyAddress(tt.policy) != got {
 			t.Errorf("wrong address for %T(%v)", tt.policy, tt.policy)
 		}
 	}
 }
+
+func TestPolicyOpaque(t *testing.T) {
+	pk := PolicyPublicKey(mustParsePublicKey("ed25519:42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282"))
+	sub := PolicyThreshold{
+		N:  2,
+		Of: []SpendPolicy{PolicyAbove(50), PolicyHash{1, 2, 3}},
+	}
+	full := PolicyThreshold{N: 1, Of: []SpendPolicy{pk, sub}}
+	addr := PolicyAddress(full)
+
+	// hiding any combination of sub-policies should not change the address
+	hidden := []SpendPolicy{
+		PolicyOpaque(addr),
+		PolicyThreshold{N: 1, Of: []SpendPolicy{PolicyOpaque(PolicyAddress(pk)), sub}},
+		PolicyThreshold{N: 1, Of: []SpendPolicy{pk, PolicyOpaque(PolicyAddress(sub))}},
+		PolicyThreshold{N: 1, Of: []SpendPolicy{pk, PolicyThreshold{
+			N:  2,
+			Of: []SpendPolicy{PolicyOpaque(PolicyAddress(PolicyAbove(50))), PolicyHash{1, 2, 3}},
+		}}},
+	}
+	for _, p := range hidden {
+		if PolicyAddress(p) != addr {
+			t.Errorf("address of %v does not match", policyString(p))
+		}
+	}
+
+	// changing N should change the address
+	if PolicyAddress(PolicyThreshold{N: 2, Of: full.Of}) == addr {
+		t.Error("address should commit to threshold")
+	}
+}
+
+func TestPolicyString(t *testing.T) {
+	pk0 := mustParsePublicKey("ed25519:42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282")
+	pk1 := mustParsePublicKey("ed25519:b908477c624679a2dc934a662e43c22844595902f1c8dc29b7f8caf2e0369cc9")
+	policies := []SpendPolicy{
+		PolicyAbove(100000),
+		PolicyPublicKey(pk0),
+		PolicyHash{1, 2, 3},
+		PolicyOpaque(StandardAddress(pk0)),
+		AnyoneCanSpend(),
+		PolicyThreshold{
+			N: 1,
+			Of: []SpendPolicy{
+				PolicyPublicKey(pk0),
+				PolicyThreshold{
+					N:  2,
+					Of: []SpendPolicy{PolicyAbove(50), PolicyPublicKey(pk1), PolicyHash{4}},
+				},
+			},
+		},
+		PolicyUnlockConditions{},
+		PolicyUnlockConditions{Timelock: 10, PublicKeys: []PublicKey{pk0, pk1}, SignaturesRequired: 1},
+	}
+	for _, p := range policies {
+		if q, err := ParseSpendPolicy(p.String()); err != nil {
+			t.Errorf("failed to parse %v: %v", p, err)
+		} else if !reflect.DeepEqual(p, q) {
+			t.Errorf("policy did not round-trip: expected %v, got %v", p, q)
+		}
+	}
+
+	const s = "thresh(2, pk(ed25519:42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282), above(100000))"
+	exp := PolicyThreshold{N: 2, Of: []SpendPolicy{PolicyPublicKey(pk0), PolicyAbove(100000)}}
+	if p, err := ParseSpendPolicy(s); err != nil {
+		t.Fatal(err)
+	} else if p.String() != s || !reflect.DeepEqual(p, exp) {
+		t.Fatal("wrong policy:", p)
+	}
+	if p, err := ParseSpendPolicy(" thresh ( 2 ,pk( ed25519:42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282 ),\n\tabove(100000) ) "); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(p, exp) {
+		t.Fatal("whitespace should be ignored:", p)
+	}
+
+	for _, s := range []string{
+		"",
+		"above",
+		"above()",
+		"above(-1)",
+		"above(1",
+		"above(1))",
+		"above(1) above(2)",
+		"thresh(256)",
+		"thresh(1 above(1))",
+		"thresh(1, above(1),)",
+		"pk(ed25519:42d3)",
+		"uc(0, [ed25519:42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282,], 1)",
+		"hash(42d33219eb9e7d52d4a4edff215e36535d9d82c9439497a05ab7712193d43282)",
+		"opaque(sia1qqqq)",
+		"after(100)",
+	} {
+		if _, err := ParseSpendPolicy(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestPolicyCost(t *testing.T) {
+	pk := func(b byte) SpendPolicy { return PolicyPublicKey{b} }
+	tests := []struct {
+		policy          SpendPolicy
+		sigs, preimages int
+		ok              bool
+	}{
+		{PolicyAbove(10), 0, 0, true},
+		{pk(0), 1, 0, true},
+		{PolicyHash{}, 0, 1, true},
+		{PolicyOpaque{}, 0, 0, false},
+		{AnyoneCanSpend(), 0, 0, true},
+		{MultisigPolicy(2, PublicKey{0}, PublicKey{1}, PublicKey{2}), 2, 0, true},
+		{AllOf(pk(0), PolicyHash{}, PolicyAbove(10)), 1, 1, true},
+		{AnyOf(pk(0), PolicyHash{}), 0, 1, true},
+		{AnyOf(PolicyOpaque{}, AllOf(pk(0), pk(1))), 2, 0, true},
+		{PolicyThreshold{N: 2, Of: []SpendPolicy{pk(0), PolicyOpaque{}}}, 0, 0, false},
+		{AnyOf(AllOf(pk(0), pk(1), pk(2)), AllOf(MultisigPolicy(1, PublicKey{3}), PolicyHash{})), 1, 1, true},
+		{PolicyUnlockConditions{PublicKeys: []PublicKey{{0}, {1}}, SignaturesRequired: 2}, 2, 0, true},
+		{PolicyUnlockConditions{PublicKeys: []PublicKey{{0}}, SignaturesRequired: 2}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		sigs, preimages, ok := PolicyCost(tt.policy)
+		if ok != tt.ok || (ok && (sigs != tt.sigs || preimages != tt.preimages)) {
+			t.Errorf("PolicyCost(%v): expected (%v, %v, %v), got (%v, %v, %v)", tt.policy, tt.sigs, tt.preimages, tt.ok, sigs, preimages, ok)
+		}
+	}
+}
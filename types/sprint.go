@@ -0,0 +1,199 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A printer accumulates an indented, human-readable dump of a value.
+type printer struct {
+	sb     strings.Builder
+	indent int
+}
+
+func (p *printer) linef(format string, args ...interface{}) {
+	p.sb.WriteString(strings.Repeat("  ", p.indent))
+	fmt.Fprintf(&p.sb, format, args...)
+	p.sb.WriteByte('\n')
+}
+
+func (p *printer) section(format string, args ...interface{}) func() {
+	p.linef(format, args...)
+	p.indent++
+	return func() { p.indent-- }
+}
+
+func sc(c Currency) string { return c.FormatUnit("SC") }
+
+func policyString(p SpendPolicy) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return p.String()
+}
+
+func (p *printer) stateElement(se StateElement, prefix string) {
+	p.linef("id:      %v", se.ID.PrefixedString(prefix))
+	p.linef("leaf:    %d (proof: %d hashes)", se.LeafIndex, len(se.MerkleProof))
+}
+
+func (p *printer) siacoinElement(sce SiacoinElement) {
+	p.stateElement(sce.StateElement, SiacoinElementIDPrefix)
+	p.linef("value:   %v", sc(sce.Value))
+	p.linef("address: %v", sce.Address)
+	p.linef("matures: %d", sce.MaturityHeight)
+}
+
+func (p *printer) siafundElement(sfe SiafundElement) {
+	p.stateElement(sfe.StateElement, SiafundElementIDPrefix)
+	p.linef("value:   %d SF", sfe.Value)
+	p.linef("address: %v", sfe.Address)
+	p.linef("claim:   %v", sc(sfe.ClaimStart))
+}
+
+func (p *printer) fileContract(fc FileContract) {
+	p.linef("filesize:   %d (root %v)", fc.Filesize, fc.FileMerkleRoot)
+	p.linef("window:     [%d, %d)", fc.WindowStart, fc.WindowEnd)
+	p.linef("renter:     %v to %v (key %v)", sc(fc.RenterOutput.Value), fc.RenterOutput.Address, fc.RenterPublicKey)
+	p.linef("host:       %v to %v (key %v)", sc(fc.HostOutput.Value), fc.HostOutput.Address, fc.HostPublicKey)
+	p.linef("missed:     %v", sc(fc.MissedHostValue))
+	p.linef("collateral: %v", sc(fc.TotalCollateral))
+	p.linef("revision:   %d", fc.RevisionNumber)
+}
+
+func (p *printer) fileContractElement(fce FileContractElement) {
+	p.stateElement(fce.StateElement, FileContractElementIDPrefix)
+	p.fileContract(fce.FileContract)
+}
+
+func (p *printer) transaction(txn Transaction) {
+	defer p.section("transaction %v", txn.ID())()
+	for i, in := range txn.SiacoinInputs {
+		end := p.section("siacoin input %d", i)
+		p.siacoinElement(in.Parent)
+		p.linef("policy:  %v", policyString(in.SpendPolicy))
+		p.linef("sigs:    %d", len(in.Signatures))
+		if len(in.Preimages) > 0 {
+			p.linef("preimages: %d", len(in.Preimages))
+		}
+		if in.SigHash != SigHashAll {
+			p.linef("sighash: %d", in.SigHash)
+		}
+		end()
+	}
+	for i, out := range txn.SiacoinOutputs {
+		p.linef("siacoin output %d: %v to %v", i, sc(out.Value), out.Address)
+	}
+	for i, in := range txn.SiafundInputs {
+		end := p.section("siafund input %d", i)
+		p.siafundElement(in.Parent)
+		p.linef("claim address: %v", in.ClaimAddress)
+		p.linef("policy:  %v", policyString(in.SpendPolicy))
+		p.linef("sigs:    %d", len(in.Signatures))
+		if len(in.Preimages) > 0 {
+			p.linef("preimages: %d", len(in.Preimages))
+		}
+		if in.SigHash != SigHashAll {
+			p.linef("sighash: %d", in.SigHash)
+		}
+		end()
+	}
+	for i, out := range txn.SiafundOutputs {
+		p.linef("siafund output %d: %d SF to %v", i, out.Value, out.Address)
+	}
+	for i, fc := range txn.FileContracts {
+		end := p.section("file contract %d", i)
+		p.fileContract(fc)
+		end()
+	}
+	for i, fcr := range txn.FileContractRevisions {
+		end := p.section("file contract revision %d", i)
+		p.linef("parent:     %v (leaf %d, proof: %d hashes)", fcr.Parent.ID.PrefixedString(FileContractElementIDPrefix), fcr.Parent.LeafIndex, len(fcr.Parent.MerkleProof))
+		p.fileContract(fcr.Revision)
+		end()
+	}
+	for i, fcr := range txn.FileContractResolutions {
+		end := p.section("file contract resolution %d", i)
+		p.linef("parent:     %v (leaf %d, proof: %d hashes)", fcr.Parent.ID.PrefixedString(FileContractElementIDPrefix), fcr.Parent.LeafIndex, len(fcr.Parent.MerkleProof))
+		switch {
+		case fcr.HasRenewal():
+			p.linef("renewal:    rollover %v (renter), %v (host)", sc(fcr.Renewal.RenterRollover), sc(fcr.Renewal.HostRollover))
+		case fcr.HasStorageProof():
+			sp := fcr.StorageProof
+			p.linef("proof:      window %v (proof: %d hashes), segment proof: %d hashes", sp.WindowStart, len(sp.WindowProof), len(sp.SegmentProof))
+		case fcr.HasFinalization():
+			p.linef("finalization")
+		default:
+			p.linef("missed")
+		}
+		end()
+	}
+	for i, a := range txn.Attestations {
+		p.linef("attestation %d: %q = %d bytes (key %v)", i, a.Key, len(a.Value), a.PublicKey)
+	}
+	if len(txn.ArbitraryData) > 0 {
+		p.linef("arbitrary data: %d bytes", len(txn.ArbitraryData))
+	}
+	if txn.NewFoundationAddress != VoidAddress {
+		p.linef("new foundation address: %v", txn.NewFoundationAddress)
+	}
+	p.linef("miner fee: %v", sc(txn.MinerFee))
+}
+
+func (p *printer) header(h BlockHeader) {
+	p.linef("parent:     %v", h.ParentID)
+	p.linef("nonce:      %d", h.Nonce)
+	p.linef("timestamp:  %v", h.Timestamp)
+	p.linef("miner:      %v", h.MinerAddress)
+	p.linef("commitment: %v", h.Commitment)
+}
+
+// Sprint returns a readable, multi-line dump of v, intended for debugging and
+// test failure messages. Blocks, headers, transactions, elements, and spend
+// policies are printed field by field, with IDs in their text form, siacoin
+// values in SC, and Merkle proofs summarized by their length. Other values are
+// formatted with their String method if they have one, and with %+v
+// otherwise.
+func Sprint(v interface{}) string {
+	var p printer
+	switch v := v.(type) {
+	case Block:
+		end := p.section("block %v", v.Index())
+		p.header(v.Header)
+		for _, txn := range v.Transactions {
+			p.transaction(txn)
+		}
+		end()
+	case *Block:
+		return Sprint(*v)
+	case BlockHeader:
+		end := p.section("header %v", v.Index())
+		p.header(v)
+		end()
+	case *BlockHeader:
+		return Sprint(*v)
+	case Transaction:
+		p.transaction(v)
+	case *Transaction:
+		return Sprint(*v)
+	case SiacoinElement:
+		end := p.section("siacoin element")
+		p.siacoinElement(v)
+		end()
+	case SiafundElement:
+		end := p.section("siafund element")
+		p.siafundElement(v)
+		end()
+	case FileContractElement:
+		end := p.section("file contract element")
+		p.fileContractElement(v)
+		end()
+	case SpendPolicy:
+		p.linef("%v", policyString(v))
+	case fmt.Stringer:
+		p.linef("%v", v)
+	default:
+		p.linef("%+v", v)
+	}
+	return p.sb.String()
+}
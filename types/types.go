@@ -1,24 +1,31 @@
 // Package types defines the essential types of the Sia blockchain.
+//
+// In addition to their binary encoding, most types can be encoded as JSON.
+// Struct fields use camelCase names; hashes, IDs, keys, and signatures are
+// encoded as prefixed hex strings (e.g. "h:..."); addresses are encoded in the
+// bech32m format (e.g. "sia1..."); Currency and Work values are encoded as
+// base-10 strings; and spend policies are encoded as an object
+// containing a "type" ("above", "pk", "thresh", or "uc") and a "policy".
 package types
 
 import (
 	"bytes"
 	"crypto/ed25519"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math"
 	"math/big"
 	"math/bits"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/crypto/blake2b"
-	"lukechampine.com/frand"
 )
 
 var (
@@ -86,7 +93,49 @@ func NewPrivateKeyFromSeed(seed [32]byte) PrivateKey {
 
 // GeneratePrivateKey creates a new private key from a secure entropy source.
 func GeneratePrivateKey() PrivateKey {
-	return NewPrivateKeyFromSeed(frand.Entropy256())
+	seed := Entropy256()
+	defer zero(seed[:])
+	return NewPrivateKeyFromSeed(seed)
+}
+
+// zero overwrites b with zeros.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Close zeroes the memory backing priv. The key must not be used afterwards.
+// Close always returns nil; it exists so that keys can be managed alongside
+// other io.Closers.
+func (priv PrivateKey) Close() error {
+	zero(priv)
+	return nil
+}
+
+// Equal reports whether priv and other are the same key. The comparison is
+// performed in constant time.
+func (priv PrivateKey) Equal(other PrivateKey) bool {
+	return subtle.ConstantTimeCompare(priv, other) == 1
+}
+
+// String implements fmt.Stringer. To prevent accidental leakage via logs, it
+// does not reveal the key.
+func (priv PrivateKey) String() string { return "ed25519:[redacted]" }
+
+// GoString implements fmt.GoStringer. Like String, it does not reveal the key.
+func (priv PrivateKey) GoString() string { return "types.PrivateKey{redacted}" }
+
+// MarshalText implements encoding.TextMarshaler. It always returns an error,
+// since private keys should never be serialized inadvertently.
+func (priv PrivateKey) MarshalText() ([]byte, error) {
+	return nil, errors.New("refusing to marshal private key")
+}
+
+// MarshalJSON implements json.Marshaler. It always returns an error, since
+// private keys should never be serialized inadvertently.
+func (priv PrivateKey) MarshalJSON() ([]byte, error) {
+	return nil, errors.New("refusing to marshal private key")
 }
 
 // A Signature is an Ed25519 signature.
@@ -106,15 +155,15 @@ func (pk PublicKey) VerifyHash(h Hash256, s Signature) bool {
 // A SiacoinOutput is the recipient of some of the siacoins spent in a
 // transaction.
 type SiacoinOutput struct {
-	Value   Currency
-	Address Address
+	Value   Currency `json:"value"`
+	Address Address  `json:"address"`
 }
 
 // A SiafundOutput is the recipient of some of the siafunds spent in a
 // transaction.
 type SiafundOutput struct {
-	Value   uint64
-	Address Address
+	Value   uint64  `json:"value"`
+	Address Address `json:"address"`
 }
 
 // A FileContract is a storage agreement between a renter and a host. It
@@ -122,21 +171,21 @@ type SiafundOutput struct {
 // or "missed" depending on whether a valid StorageProof is submitted for the
 // contract.
 type FileContract struct {
-	Filesize        uint64
-	FileMerkleRoot  Hash256
-	WindowStart     uint64
-	WindowEnd       uint64
-	RenterOutput    SiacoinOutput
-	HostOutput      SiacoinOutput
-	MissedHostValue Currency
-	TotalCollateral Currency
-	RenterPublicKey PublicKey
-	HostPublicKey   PublicKey
-	RevisionNumber  uint64
+	Filesize        uint64        `json:"filesize"`
+	FileMerkleRoot  Hash256       `json:"fileMerkleRoot"`
+	WindowStart     uint64        `json:"windowStart"`
+	WindowEnd       uint64        `json:"windowEnd"`
+	RenterOutput    SiacoinOutput `json:"renterOutput"`
+	HostOutput      SiacoinOutput `json:"hostOutput"`
+	MissedHostValue Currency      `json:"missedHostValue"`
+	TotalCollateral Currency      `json:"totalCollateral"`
+	RenterPublicKey PublicKey     `json:"renterPublicKey"`
+	HostPublicKey   PublicKey     `json:"hostPublicKey"`
+	RevisionNumber  uint64        `json:"revisionNumber"`
 
 	// signatures cover above fields
-	RenterSignature Signature
-	HostSignature   Signature
+	RenterSignature Signature `json:"renterSignature"`
+	HostSignature   Signature `json:"hostSignature"`
 }
 
 // MissedHostOutput returns the host output that will be created if the contract
@@ -148,12 +197,42 @@ func (fc FileContract) MissedHostOutput() SiacoinOutput {
 	}
 }
 
+// SigHashFlags specify which fields of a transaction are covered by the
+// signatures of an input. The zero value, SigHashAll, covers every field that
+// is covered by the transaction ID. Other flags allow transactions to be
+// assembled collaboratively, e.g. in coinjoins, or to have their fee increased
+// by a third party.
+type SigHashFlags uint8
+
+// SigHash flags.
+const (
+	SigHashAll SigHashFlags = 0
+	// SigHashAnyoneCanPay restricts the covered inputs to the input being
+	// signed, and leaves the miner fee uncovered, so that other parties may
+	// add inputs and increase the fee.
+	SigHashAnyoneCanPay SigHashFlags = 1 << 0
+	// SigHashSingle restricts the covered outputs to the output (of the same
+	// type) at the same index as the input being signed, so that other
+	// parties may add outputs. Such an output must exist.
+	SigHashSingle SigHashFlags = 1 << 1
+)
+
+// Valid returns true if f is a valid combination of flags.
+func (f SigHashFlags) Valid() bool {
+	return f&^(SigHashAnyoneCanPay|SigHashSingle) == 0
+}
+
 // A SiacoinInput spends an unspent SiacoinElement in the state accumulator by
-// revealing its public key and signing the transaction.
+// revealing its public key and signing the transaction. Inputs whose policies
+// include a PolicyHash must also reveal the corresponding preimages. Any
+// sub-policies that are not needed to satisfy the policy may be hidden by
+// replacing them with PolicyOpaque.
 type SiacoinInput struct {
-	Parent      SiacoinElement
-	SpendPolicy SpendPolicy
-	Signatures  []Signature
+	Parent      SiacoinElement `json:"parent"`
+	SpendPolicy SpendPolicy    `json:"spendPolicy"`
+	Signatures  []Signature    `json:"signatures"`
+	Preimages   [][32]byte     `json:"preimages"`
+	SigHash     SigHashFlags   `json:"sigHash,omitempty"`
 }
 
 // A SiafundInput spends an unspent SiafundElement in the state accumulator by
@@ -161,16 +240,18 @@ type SiacoinInput struct {
 // ClaimAddress, specifying the recipient of the siacoins that were earned by
 // the SiafundElement.
 type SiafundInput struct {
-	Parent       SiafundElement
-	ClaimAddress Address
-	SpendPolicy  SpendPolicy
-	Signatures   []Signature
+	Parent       SiafundElement `json:"parent"`
+	ClaimAddress Address        `json:"claimAddress"`
+	SpendPolicy  SpendPolicy    `json:"spendPolicy"`
+	Signatures   []Signature    `json:"signatures"`
+	Preimages    [][32]byte     `json:"preimages"`
+	SigHash      SigHashFlags   `json:"sigHash,omitempty"`
 }
 
 // A FileContractRevision updates the state of an existing file contract.
 type FileContractRevision struct {
-	Parent   FileContractElement
-	Revision FileContract
+	Parent   FileContractElement `json:"parent"`
+	Revision FileContract        `json:"revision"`
 }
 
 // A FileContractResolution closes a file contract's payment channel. There are
@@ -191,10 +272,10 @@ type FileContractRevision struct {
 // with no storage proof or finalization. This is considered a "missed"
 // resolution.
 type FileContractResolution struct {
-	Parent       FileContractElement
-	Renewal      FileContractRenewal
-	StorageProof StorageProof
-	Finalization FileContract
+	Parent       FileContractElement `json:"parent"`
+	Renewal      FileContractRenewal `json:"renewal"`
+	StorageProof StorageProof        `json:"storageProof"`
+	Finalization FileContract        `json:"finalization"`
 }
 
 // HasRenewal returns true if the resolution contains a renewal.
@@ -217,14 +298,14 @@ func (fcr *FileContractResolution) HasFinalization() bool {
 
 // A FileContractRenewal renews a file contract.
 type FileContractRenewal struct {
-	FinalRevision   FileContract
-	InitialRevision FileContract
-	RenterRollover  Currency
-	HostRollover    Currency
+	FinalRevision   FileContract `json:"finalRevision"`
+	InitialRevision FileContract `json:"initialRevision"`
+	RenterRollover  Currency     `json:"renterRollover"`
+	HostRollover    Currency     `json:"hostRollover"`
 
 	// signatures cover above fields
-	RenterSignature Signature
-	HostSignature   Signature
+	RenterSignature Signature `json:"renterSignature"`
+	HostSignature   Signature `json:"hostSignature"`
 }
 
 // A StorageProof asserts the presence of a small segment of data within a
@@ -239,11 +320,11 @@ type StorageProof struct {
 	// Consequently, WindowStart.Height MUST match the WindowStart field of the
 	// contract's final revision; otherwise, the prover could use any
 	// WindowStart, giving them control over the segment index.
-	WindowStart ChainIndex
-	WindowProof []Hash256
+	WindowStart ChainIndex `json:"windowStart"`
+	WindowProof []Hash256  `json:"windowProof"`
 	// The segment is always 64 bytes, extended with zeros if necessary.
-	DataSegment  [64]byte
-	SegmentProof []Hash256
+	DataSegment  [64]byte  `json:"dataSegment"`
+	SegmentProof []Hash256 `json:"segmentProof"`
 }
 
 // An ElementID uniquely identifies a StateElement.
@@ -254,9 +335,9 @@ type ElementID struct {
 
 // A StateElement is a generic element within the state accumulator.
 type StateElement struct {
-	ID          ElementID
-	LeafIndex   uint64
-	MerkleProof []Hash256
+	ID          ElementID `json:"id"`
+	LeafIndex   uint64    `json:"leafIndex"`
+	MerkleProof []Hash256 `json:"merkleProof"`
 }
 
 // A SiacoinElement is a volume of siacoins that is created and spent as an
@@ -264,7 +345,7 @@ type StateElement struct {
 type SiacoinElement struct {
 	StateElement
 	SiacoinOutput
-	MaturityHeight uint64
+	MaturityHeight uint64 `json:"maturityHeight"`
 }
 
 // A SiafundElement is a volume of siafunds that is created and spent as an
@@ -272,7 +353,7 @@ type SiacoinElement struct {
 type SiafundElement struct {
 	StateElement
 	SiafundOutput
-	ClaimStart Currency // value of SiafundPool when element was created
+	ClaimStart Currency `json:"claimStart"` // value of SiafundPool when element was created
 }
 
 // A FileContractElement is a storage agreement between a renter and a host.
@@ -281,33 +362,41 @@ type FileContractElement struct {
 	FileContract
 }
 
+// HostAnnouncementKey is the Attestation key used by hosts to announce their
+// network address.
+const HostAnnouncementKey = "HostAnnouncement"
+
+// HostKeyCertificateKey is the Attestation key used by hosts to announce the
+// operational key certified by their identity key.
+const HostKeyCertificateKey = "HostKeyCertificate"
+
 // An Attestation associates a key-value pair with an identity. For example,
-// hosts attest to their network address by setting Key to "HostAnnouncement"
+// hosts attest to their network address by setting Key to HostAnnouncementKey
 // and Value to their address, thereby allowing renters to discover them.
 // Generally, an attestation for a particular key is considered to overwrite any
 // previous attestations with the same key. (This allows hosts to announce a new
 // network address, for example.)
 type Attestation struct {
-	PublicKey PublicKey
-	Key       string
-	Value     []byte
-	Signature Signature
+	PublicKey PublicKey `json:"publicKey"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	Signature Signature `json:"signature"`
 }
 
 // A Transaction transfers value by consuming existing Outputs and creating new
 // Outputs.
 type Transaction struct {
-	SiacoinInputs           []SiacoinInput
-	SiacoinOutputs          []SiacoinOutput
-	SiafundInputs           []SiafundInput
-	SiafundOutputs          []SiafundOutput
-	FileContracts           []FileContract
-	FileContractRevisions   []FileContractRevision
-	FileContractResolutions []FileContractResolution
-	Attestations            []Attestation
-	ArbitraryData           []byte
-	NewFoundationAddress    Address
-	MinerFee                Currency
+	SiacoinInputs           []SiacoinInput           `json:"siacoinInputs"`
+	SiacoinOutputs          []SiacoinOutput          `json:"siacoinOutputs"`
+	SiafundInputs           []SiafundInput           `json:"siafundInputs"`
+	SiafundOutputs          []SiafundOutput          `json:"siafundOutputs"`
+	FileContracts           []FileContract           `json:"fileContracts"`
+	FileContractRevisions   []FileContractRevision   `json:"fileContractRevisions"`
+	FileContractResolutions []FileContractResolution `json:"fileContractResolutions"`
+	Attestations            []Attestation            `json:"attestations"`
+	ArbitraryData           []byte                   `json:"arbitraryData"`
+	NewFoundationAddress    Address                  `json:"newFoundationAddress"`
+	MinerFee                Currency                 `json:"minerFee"`
 }
 
 // ID returns the "semantic hash" of the transaction, covering all of the
@@ -315,6 +404,8 @@ type Transaction struct {
 // proofs. This ensures that the ID will remain stable (i.e. non-malleable).
 //
 // To hash all of the data in a transaction, use the EncodeTo method.
+//
+//core:consensus
 func (txn *Transaction) ID() TransactionID {
 	// NOTE: In general, it is not possible to change a transaction's ID without
 	// causing it to become invalid, but an exception exists for non-standard
@@ -377,12 +468,14 @@ func (txn *Transaction) DeepCopy() Transaction {
 	for i := range c.SiacoinInputs {
 		c.SiacoinInputs[i].Parent.MerkleProof = append([]Hash256(nil), c.SiacoinInputs[i].Parent.MerkleProof...)
 		c.SiacoinInputs[i].Signatures = append([]Signature(nil), c.SiacoinInputs[i].Signatures...)
+		c.SiacoinInputs[i].Preimages = append([][32]byte(nil), c.SiacoinInputs[i].Preimages...)
 	}
 	c.SiacoinOutputs = append([]SiacoinOutput(nil), c.SiacoinOutputs...)
 	c.SiafundInputs = append([]SiafundInput(nil), c.SiafundInputs...)
 	for i := range c.SiafundInputs {
 		c.SiafundInputs[i].Parent.MerkleProof = append([]Hash256(nil), c.SiafundInputs[i].Parent.MerkleProof...)
 		c.SiafundInputs[i].Signatures = append([]Signature(nil), c.SiafundInputs[i].Signatures...)
+		c.SiafundInputs[i].Preimages = append([][32]byte(nil), c.SiafundInputs[i].Preimages...)
 	}
 	c.SiafundOutputs = append([]SiafundOutput(nil), c.SiafundOutputs...)
 	c.FileContracts = append([]FileContract(nil), c.FileContracts...)
@@ -450,12 +543,12 @@ func (txn *Transaction) EphemeralSiacoinElement(i int) SiacoinElement {
 
 // A BlockHeader contains a Block's non-transaction data.
 type BlockHeader struct {
-	Height       uint64
-	ParentID     BlockID
-	Nonce        uint64
-	Timestamp    time.Time
-	MinerAddress Address
-	Commitment   Hash256
+	Height       uint64    `json:"height"`
+	ParentID     BlockID   `json:"parentID"`
+	Nonce        uint64    `json:"nonce"`
+	Timestamp    time.Time `json:"timestamp"`
+	MinerAddress Address   `json:"minerAddress"`
+	Commitment   Hash256   `json:"commitment"`
 }
 
 // Index returns the header's chain index.
@@ -475,6 +568,8 @@ func (h BlockHeader) ParentIndex() ChainIndex {
 }
 
 // ID returns a hash that uniquely identifies a block.
+//
+//core:consensus
 func (h BlockHeader) ID() BlockID {
 	// NOTE: although in principle we only need to hash 48 bytes of data, we
 	// must ensure compatibility with existing Sia mining hardware, which
@@ -493,8 +588,8 @@ func CurrentTimestamp() time.Time { return time.Now().Round(time.Second).UTC() }
 
 // A Block is a set of transactions grouped under a header.
 type Block struct {
-	Header       BlockHeader
-	Transactions []Transaction
+	Header       BlockHeader   `json:"header"`
+	Transactions []Transaction `json:"transactions"`
 }
 
 // ID returns a hash that uniquely identifies a block. It is equivalent to
@@ -586,6 +681,72 @@ func (w Work) Cmp(v Work) int {
 	return bytes.Compare(w.NumHashes[:], v.NumHashes[:])
 }
 
+// Big returns w as a *big.Int.
+func (w Work) Big() *big.Int {
+	return new(big.Int).SetBytes(w.NumHashes[:])
+}
+
+// WorkFromBig converts i to a Work value. It returns an error if i is negative
+// or does not fit in 256 bits.
+func WorkFromBig(i *big.Int) (Work, error) {
+	if i.Sign() < 0 {
+		return Work{}, errors.New("value cannot be negative")
+	} else if i.BitLen() > 256 {
+		return Work{}, errors.New("value overflows Work representation")
+	}
+	var w Work
+	i.FillBytes(w.NumHashes[:])
+	return w, nil
+}
+
+// Hashrate returns the hashrate, in hashes per second, at which w is expected
+// to be performed within the given interval. For example, if w is the current
+// difficulty and interval is the block interval, Hashrate estimates the total
+// hashrate of the network.
+func (w Work) Hashrate(interval time.Duration) float64 {
+	if interval <= 0 {
+		panic("interval must be positive") // developer error
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(w.Big()), big.NewFloat(interval.Seconds())).Float64()
+	return f
+}
+
+// WorkForHashrate returns the Work expected to be performed within the given
+// interval at a hashrate of h hashes per second. It is the inverse of
+// Hashrate.
+func WorkForHashrate(h float64, interval time.Duration) (Work, error) {
+	if interval <= 0 {
+		panic("interval must be positive") // developer error
+	} else if math.IsNaN(h) || math.IsInf(h, 0) || h < 0 {
+		return Work{}, fmt.Errorf("invalid hashrate %v", h)
+	}
+	i, _ := new(big.Float).Mul(big.NewFloat(h), big.NewFloat(interval.Seconds())).Int(nil)
+	return WorkFromBig(i)
+}
+
+// hashrateUnits lists the units used by FormatHashrate, in increasing powers of
+// 1000.
+var hashrateUnits = []string{"H/s", "kH/s", "MH/s", "GH/s", "TH/s", "PH/s", "EH/s", "ZH/s", "YH/s"}
+
+// FormatHashrate returns a human-readable representation of h hashes per
+// second, e.g. "3.2 TH/s".
+func FormatHashrate(h float64) string {
+	k := 0
+	for k+1 < len(hashrateUnits) && h >= 1000 {
+		h /= 1000
+		k++
+	}
+	s := strconv.FormatFloat(h, 'f', 3, 64)
+	if s == "1000.000" && k+1 < len(hashrateUnits) {
+		// rounding carried into the next unit
+		s, k = "1", k+1
+	}
+	if strings.Contains(s, ".") {
+		s = strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	}
+	return s + " " + hashrateUnits[k]
+}
+
 // WorkRequiredForHash estimates how much work was required to produce the given
 // id. Note that the mapping is not injective; many different ids may require
 // the same expected amount of Work.
@@ -660,12 +821,22 @@ func marshalHex(prefix string, data []byte) ([]byte, error) {
 	return []byte(stringerHex(prefix, data)), nil
 }
 
-func unmarshalHex(dst []byte, prefix string, data []byte) error {
-	n, err := hex.Decode(dst, bytes.TrimPrefix(data, []byte(prefix+":")))
-	if n < len(dst) {
-		err = io.EOF
+// decodeHex strictly decodes lowercase hex data into dst, which it must fill
+// exactly.
+func decodeHex(dst []byte, data []byte) error {
+	if len(data) != hex.EncodedLen(len(dst)) {
+		return fmt.Errorf("wrong length (expected %v hex digits, got %v)", hex.EncodedLen(len(dst)), len(data))
+	} else if !bytes.Equal(data, bytes.ToLower(data)) {
+		return errors.New("hex must be lowercase")
 	}
-	if err != nil {
+	_, err := hex.Decode(dst, data)
+	return err
+}
+
+func unmarshalHex(dst []byte, prefix string, data []byte) error {
+	if !bytes.HasPrefix(data, []byte(prefix+":")) {
+		return fmt.Errorf("decoding %v:<hex> failed: missing %q prefix", prefix, prefix+":")
+	} else if err := decodeHex(dst, data[len(prefix)+1:]); err != nil {
 		return fmt.Errorf("decoding %v:<hex> failed: %w", prefix, err)
 	}
 	return nil
@@ -686,7 +857,7 @@ func (h Hash256) String() string { return stringerHex("h", h[:]) }
 func (h Hash256) MarshalText() ([]byte, error) { return marshalHex("h", h[:]) }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (h Hash256) UnmarshalText(b []byte) error { return unmarshalHex(h[:], "h", b) }
+func (h *Hash256) UnmarshalText(b []byte) error { return unmarshalHex(h[:], "h", b) }
 
 // MarshalJSON implements json.Marshaler.
 func (h Hash256) MarshalJSON() ([]byte, error) { return marshalJSONHex("h", h[:]) }
@@ -707,16 +878,14 @@ func (ci ChainIndex) MarshalText() ([]byte, error) {
 }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (ci ChainIndex) UnmarshalText(b []byte) (err error) {
+func (ci *ChainIndex) UnmarshalText(b []byte) (err error) {
 	parts := bytes.Split(b, []byte("::"))
 	if len(parts) != 2 {
 		return fmt.Errorf("decoding <height>::<id> failed: wrong number of separators")
 	} else if ci.Height, err = strconv.ParseUint(string(parts[0]), 10, 64); err != nil {
 		return fmt.Errorf("decoding <height>::<id> failed: %w", err)
-	} else if n, err := hex.Decode(ci.ID[:], parts[1]); err != nil {
+	} else if err := decodeHex(ci.ID[:], parts[1]); err != nil {
 		return fmt.Errorf("decoding <height>::<id> failed: %w", err)
-	} else if n < len(ci.ID) {
-		return fmt.Errorf("decoding <height>::<id> failed: %w", io.EOF)
 	}
 	return nil
 }
@@ -727,31 +896,184 @@ func ParseChainIndex(s string) (ci ChainIndex, err error) {
 	return
 }
 
+// Prefixes for the text form of ElementIDs. An ElementID does not record the
+// type of element it identifies, so String and MarshalText use the generic
+// "h" prefix; the typed prefixes can be used via PrefixedString when the type
+// is known, e.g. in logs and explorers. UnmarshalText accepts any of them.
+const (
+	ElementIDPrefix             = "h"
+	SiacoinElementIDPrefix      = "scoid"
+	SiafundElementIDPrefix      = "sfoid"
+	FileContractElementIDPrefix = "fcid"
+)
+
+func isElementIDPrefix(prefix string) bool {
+	switch prefix {
+	case ElementIDPrefix, SiacoinElementIDPrefix, SiafundElementIDPrefix, FileContractElementIDPrefix:
+		return true
+	}
+	return false
+}
+
 // String implements fmt.Stringer.
 func (eid ElementID) String() string {
-	return fmt.Sprintf("%v:%v", eid.Source, eid.Index)
+	return eid.PrefixedString(ElementIDPrefix)
+}
+
+// PrefixedString returns the text form of eid using the specified prefix,
+// which must be one of the ElementID prefix constants.
+func (eid ElementID) PrefixedString(prefix string) string {
+	if !isElementIDPrefix(prefix) {
+		panic(fmt.Sprintf("unknown ElementID prefix %q", prefix)) // developer error
+	}
+	return fmt.Sprintf("%v:%x:%v", prefix, eid.Source[:], eid.Index)
 }
 
 // MarshalText implements encoding.TextMarshaler.
 func (eid ElementID) MarshalText() ([]byte, error) { return []byte(eid.String()), nil }
 
-// UnmarshalText implements encoding.TextUnmarshaler.
-func (eid ElementID) UnmarshalText(b []byte) (err error) {
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts any of the
+// ElementID prefix constants.
+func (eid *ElementID) UnmarshalText(b []byte) (err error) {
 	parts := bytes.Split(b, []byte(":"))
-	if len(parts) != 2 {
-		return fmt.Errorf("decoding <hex>:<index> failed: wrong number of separators")
-	} else if n, err := hex.Decode(eid.Source[:], parts[1]); err != nil {
-		return fmt.Errorf("decoding <hex>::<index> failed: %w", err)
-	} else if n < len(eid.Source) {
-		return fmt.Errorf("decoding <hex>::<index> failed: %w", io.EOF)
-	} else if eid.Index, err = strconv.ParseUint(string(parts[0]), 10, 64); err != nil {
-		return fmt.Errorf("decoding <hex>::<index> failed: %w", err)
+	if len(parts) != 3 {
+		return fmt.Errorf("decoding <prefix>:<hex>:<index> failed: wrong number of separators")
+	} else if !isElementIDPrefix(string(parts[0])) {
+		return fmt.Errorf("decoding <prefix>:<hex>:<index> failed: unknown prefix %q", parts[0])
+	} else if err := decodeHex(eid.Source[:], parts[1]); err != nil {
+		return fmt.Errorf("decoding <prefix>:<hex>:<index> failed: %w", err)
+	} else if eid.Index, err = strconv.ParseUint(string(parts[2]), 10, 64); err != nil {
+		return fmt.Errorf("decoding <prefix>:<hex>:<index> failed: %w", err)
+	} else if strconv.FormatUint(eid.Index, 10) != string(parts[2]) {
+		return fmt.Errorf("decoding <prefix>:<hex>:<index> failed: non-canonical index %q", parts[2])
 	}
 	return nil
 }
 
-// String implements fmt.Stringer.
+// ParseElementID parses an ElementID from its text form.
+func ParseElementID(s string) (eid ElementID, err error) {
+	err = eid.UnmarshalText([]byte(s))
+	return
+}
+
+// preimages are encoded as hex strings
+func marshalPreimages(ps [][32]byte) []string {
+	if ps == nil {
+		return nil
+	}
+	strs := make([]string, len(ps))
+	for i := range ps {
+		strs[i] = hex.EncodeToString(ps[i][:])
+	}
+	return strs
+}
+
+func unmarshalPreimages(strs []string) ([][32]byte, error) {
+	if strs == nil {
+		return nil, nil
+	}
+	ps := make([][32]byte, len(strs))
+	for i := range strs {
+		if err := decodeHex(ps[i][:], []byte(strs[i])); err != nil {
+			return nil, fmt.Errorf("decoding preimage failed: %w", err)
+		}
+	}
+	return ps, nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (in SiacoinInput) MarshalJSON() ([]byte, error) {
+	type siacoinInput SiacoinInput
+	return json.Marshal(struct {
+		siacoinInput
+		SpendPolicy policyJSON `json:"spendPolicy"`
+		Preimages   []string   `json:"preimages"`
+	}{siacoinInput(in), policyJSON{in.SpendPolicy}, marshalPreimages(in.Preimages)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (in *SiacoinInput) UnmarshalJSON(b []byte) (err error) {
+	type siacoinInput SiacoinInput
+	var v struct {
+		*siacoinInput
+		SpendPolicy policyJSON `json:"spendPolicy"`
+		Preimages   []string   `json:"preimages"`
+	}
+	v.siacoinInput = (*siacoinInput)(in)
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	in.SpendPolicy = v.SpendPolicy.Policy
+	in.Preimages, err = unmarshalPreimages(v.Preimages)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (in SiafundInput) MarshalJSON() ([]byte, error) {
+	type siafundInput SiafundInput
+	return json.Marshal(struct {
+		siafundInput
+		SpendPolicy policyJSON `json:"spendPolicy"`
+		Preimages   []string   `json:"preimages"`
+	}{siafundInput(in), policyJSON{in.SpendPolicy}, marshalPreimages(in.Preimages)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (in *SiafundInput) UnmarshalJSON(b []byte) (err error) {
+	type siafundInput SiafundInput
+	var v struct {
+		*siafundInput
+		SpendPolicy policyJSON `json:"spendPolicy"`
+		Preimages   []string   `json:"preimages"`
+	}
+	v.siafundInput = (*siafundInput)(in)
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	in.SpendPolicy = v.SpendPolicy.Policy
+	in.Preimages, err = unmarshalPreimages(v.Preimages)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler. The data segment is encoded as hex.
+func (sp StorageProof) MarshalJSON() ([]byte, error) {
+	type storageProof StorageProof
+	return json.Marshal(struct {
+		storageProof
+		DataSegment string `json:"dataSegment"`
+	}{storageProof(sp), hex.EncodeToString(sp.DataSegment[:])})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (sp *StorageProof) UnmarshalJSON(b []byte) error {
+	type storageProof StorageProof
+	var v struct {
+		*storageProof
+		DataSegment string `json:"dataSegment"`
+	}
+	v.storageProof = (*storageProof)(sp)
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	} else if len(v.DataSegment) != hex.EncodedLen(len(sp.DataSegment)) {
+		return errors.New("decoding data segment failed: wrong length")
+	} else if _, err := hex.Decode(sp.DataSegment[:], []byte(v.DataSegment)); err != nil {
+		return fmt.Errorf("decoding data segment failed: %w", err)
+	}
+	return nil
+}
+
+// AddressPrefix is the human-readable prefix of the bech32m address format.
+const AddressPrefix = "sia"
+
+// String implements fmt.Stringer. Addresses are encoded in the bech32m format,
+// with AddressPrefix as the human-readable prefix.
 func (a Address) String() string {
+	return bech32mEncode(AddressPrefix, a[:])
+}
+
+// LegacyString returns the legacy form of a, i.e. "addr:" followed by the
+// hex-encoded address and a 6-byte checksum.
+func (a Address) LegacyString() string {
 	checksum := HashBytes(a[:])
 	return stringerHex("addr", append(a[:], checksum[:6]...))
 }
@@ -759,26 +1081,38 @@ func (a Address) String() string {
 // MarshalText implements encoding.TextMarshaler.
 func (a Address) MarshalText() ([]byte, error) { return []byte(a.String()), nil }
 
-// UnmarshalText implements encoding.TextUnmarshaler.
+// UnmarshalText implements encoding.TextUnmarshaler. It accepts both the
+// bech32m and legacy address formats.
 func (a *Address) UnmarshalText(b []byte) (err error) {
-	withChecksum := make([]byte, 32+6)
-	n, err := hex.Decode(withChecksum, bytes.TrimPrefix(b, []byte("addr:")))
-	fmt.Println(string(b), n)
+	if bytes.HasPrefix(b, []byte("addr:")) {
+		return a.unmarshalLegacy(b)
+	}
+	hrp, data, err := bech32mDecode(string(b))
 	if err != nil {
-		err = fmt.Errorf("decoding addr:<hex> failed: %w", err)
-	} else if n != len(withChecksum) {
-		err = fmt.Errorf("decoding addr:<hex> failed: %w", io.EOF)
+		return fmt.Errorf("decoding bech32m address failed: %w", err)
+	} else if hrp != AddressPrefix {
+		return fmt.Errorf("wrong address prefix (expected %q, got %q)", AddressPrefix, hrp)
+	} else if len(data) != len(a) {
+		return fmt.Errorf("wrong address length (expected %v bytes, got %v)", len(a), len(data))
+	}
+	copy(a[:], data)
+	return nil
+}
+
+func (a *Address) unmarshalLegacy(b []byte) error {
+	withChecksum := make([]byte, 32+6)
+	if err := unmarshalHex(withChecksum, "addr", b); err != nil {
+		return err
 	} else if checksum := HashBytes(withChecksum[:32]); !bytes.Equal(checksum[:6], withChecksum[32:]) {
-		err = errors.New("bad checksum")
+		return errors.New("bad checksum")
 	}
 	copy(a[:], withChecksum[:32])
-	return
+	return nil
 }
 
 // MarshalJSON implements json.Marshaler.
 func (a Address) MarshalJSON() ([]byte, error) {
-	checksum := HashBytes(a[:])
-	return marshalJSONHex("addr", append(a[:], checksum[:6]...))
+	return []byte(`"` + a.String() + `"`), nil
 }
 
 // UnmarshalJSON implements json.Unmarshaler.
@@ -786,7 +1120,8 @@ func (a *Address) UnmarshalJSON(b []byte) (err error) {
 	return a.UnmarshalText(bytes.Trim(b, `"`))
 }
 
-// ParseAddress parses an address from a prefixed hex encoded string.
+// ParseAddress parses an address from its bech32m encoding, as returned by
+// String, or from the legacy format, as returned by LegacyString.
 func ParseAddress(s string) (a Address, err error) {
 	err = a.UnmarshalText([]byte(s))
 	return
@@ -799,7 +1134,7 @@ func (bid BlockID) String() string { return stringerHex("bid", bid[:]) }
 func (bid BlockID) MarshalText() ([]byte, error) { return marshalHex("bid", bid[:]) }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (bid BlockID) UnmarshalText(b []byte) error { return unmarshalHex(bid[:], "bid", b) }
+func (bid *BlockID) UnmarshalText(b []byte) error { return unmarshalHex(bid[:], "bid", b) }
 
 // MarshalJSON implements json.Marshaler.
 func (bid BlockID) MarshalJSON() ([]byte, error) { return marshalJSONHex("bid", bid[:]) }
@@ -814,7 +1149,7 @@ func (pk PublicKey) String() string { return stringerHex("ed25519", pk[:]) }
 func (pk PublicKey) MarshalText() ([]byte, error) { return marshalHex("ed25519", pk[:]) }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (pk PublicKey) UnmarshalText(b []byte) error { return unmarshalHex(pk[:], "ed25519", b) }
+func (pk *PublicKey) UnmarshalText(b []byte) error { return unmarshalHex(pk[:], "ed25519", b) }
 
 // MarshalJSON implements json.Marshaler.
 func (pk PublicKey) MarshalJSON() ([]byte, error) { return marshalJSONHex("ed25519", pk[:]) }
@@ -829,7 +1164,7 @@ func (tid TransactionID) String() string { return stringerHex("txid", tid[:]) }
 func (tid TransactionID) MarshalText() ([]byte, error) { return marshalHex("txid", tid[:]) }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (tid TransactionID) UnmarshalText(b []byte) error { return unmarshalHex(tid[:], "txid", b) }
+func (tid *TransactionID) UnmarshalText(b []byte) error { return unmarshalHex(tid[:], "txid", b) }
 
 // MarshalJSON implements json.Marshaler.
 func (tid TransactionID) MarshalJSON() ([]byte, error) { return marshalJSONHex("txid", tid[:]) }
@@ -844,7 +1179,7 @@ func (sig Signature) String() string { return stringerHex("sig", sig[:]) }
 func (sig Signature) MarshalText() ([]byte, error) { return marshalHex("sig", sig[:]) }
 
 // UnmarshalText implements encoding.TextUnmarshaler.
-func (sig Signature) UnmarshalText(b []byte) error { return unmarshalHex(sig[:], "sig", b) }
+func (sig *Signature) UnmarshalText(b []byte) error { return unmarshalHex(sig[:], "sig", b) }
 
 // MarshalJSON implements json.Marshaler.
 func (sig Signature) MarshalJSON() ([]byte, error) { return marshalJSONHex("sig", sig[:]) }
@@ -853,7 +1188,7 @@ func (sig Signature) MarshalJSON() ([]byte, error) { return marshalJSONHex("sig"
 func (sig *Signature) UnmarshalJSON(b []byte) error { return unmarshalJSONHex(sig[:], "sig", b) }
 
 // String implements fmt.Stringer.
-func (w Work) String() string { return new(big.Int).SetBytes(w.NumHashes[:]).String() }
+func (w Work) String() string { return w.Big().String() }
 
 // MarshalJSON implements json.Marshaler.
 func (w Work) MarshalJSON() ([]byte, error) {
@@ -869,8 +1204,9 @@ func (w *Work) UnmarshalJSON(b []byte) error {
 		return err
 	} else if i.Sign() < 0 {
 		return errors.New("value cannot be negative")
-	} else if i.BitLen() > 128 {
+	} else if i.BitLen() > 256 {
 		return errors.New("value overflows Work representation")
 	}
+	i.FillBytes(w.NumHashes[:])
 	return nil
 }
@@ -1,8 +1,18 @@
 package types
 
 import (
+	"bytes"
+	"encoding"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"lukechampine.com/frand"
 )
 
 func TestWork(t *testing.T) {
@@ -30,18 +40,53 @@ func TestWork(t *testing.T) {
 	}
 }
 
+func TestHashrate(t *testing.T) {
+	w := WorkRequiredForHash(BlockID{10: 1}) // 2^88
+	if h := w.Hashrate(10 * time.Minute); math.Abs(h-float64(1<<88)/600) > 1 {
+		t.Fatal("wrong hashrate:", h)
+	}
+	if w2, err := WorkForHashrate(w.Hashrate(time.Minute), time.Minute); err != nil {
+		t.Fatal(err)
+	} else if w2 != w {
+		t.Fatalf("expected %v, got %v", w, w2)
+	}
+	for _, h := range []float64{-1, math.NaN(), math.Inf(1), math.Ldexp(1, 260)} {
+		if _, err := WorkForHashrate(h, time.Second); err == nil {
+			t.Error("expected error for hashrate", h)
+		}
+	}
+
+	tests := []struct {
+		h   float64
+		exp string
+	}{
+		{0, "0 H/s"},
+		{999, "999 H/s"},
+		{1000, "1 kH/s"},
+		{3.2e12, "3.2 TH/s"},
+		{123456789, "123.457 MH/s"},
+		{999999.9999, "1 MH/s"},
+		{5e30, "5000000 YH/s"},
+	}
+	for _, test := range tests {
+		if got := FormatHashrate(test.h); got != test.exp {
+			t.Errorf("expected %v, got %v", test.exp, got)
+		}
+	}
+}
+
 func TestCurrencyMarshalling(t *testing.T) {
 	tests := []struct {
 		value, str string
 	}{
-		{"0", "0"},
-		{"123000000000000000000999", "0.123"},
-		{"120000000000000000000999", "0.12"},
-		{"100000000000000000000999", "0.1"},
-		{"99999999999999999999999", "0.1"},
-		{"999999999999999999999999", "1"},
-		{"1999999999999999999999999", "2"},
-		{"12345678901234567890123456789123456", "12345678901.235"},
+		{"0", "0 H"},
+		{"123000000000000000000999", "123 mS"},
+		{"120000000000000000000999", "120 mS"},
+		{"100000000000000000000999", "100 mS"},
+		{"99999999999999999999999", "100 mS"},
+		{"999999999999999999999999", "1 SC"},
+		{"1999999999999999999999999", "2 SC"},
+		{"12345678901234567890123456789123456", "12.346 GS"},
 	}
 	for _, test := range tests {
 		c, err := ParseCurrency(test.value)
@@ -60,6 +105,95 @@ func TestCurrencyMarshalling(t *testing.T) {
 	}
 }
 
+func TestBlockJSON(t *testing.T) {
+	pk := GeneratePrivateKey().PublicKey()
+	id := func(b byte) ElementID { return ElementID{Source: Hash256{b}, Index: uint64(b)} }
+	fc := FileContract{
+		Filesize:        4096,
+		WindowStart:     10,
+		WindowEnd:       20,
+		RenterOutput:    SiacoinOutput{Value: Siacoins(3), Address: StandardAddress(pk)},
+		RenterPublicKey: pk,
+		RevisionNumber:  1,
+		RenterSignature: Signature{1},
+	}
+	b := Block{
+		Header: BlockHeader{
+			Height:     7,
+			ParentID:   BlockID{1},
+			Nonce:      1234,
+			Timestamp:  CurrentTimestamp(),
+			Commitment: Hash256{2},
+		},
+		Transactions: []Transaction{{
+			SiacoinInputs: []SiacoinInput{{
+				Parent: SiacoinElement{
+					StateElement:  StateElement{ID: id(1), LeafIndex: 3, MerkleProof: []Hash256{{4}}},
+					SiacoinOutput: SiacoinOutput{Value: Siacoins(5)},
+				},
+				SpendPolicy: PolicyThreshold{
+					N: 1,
+					Of: []SpendPolicy{
+						PolicyAbove(100),
+						PolicyPublicKey(pk),
+						PolicyUnlockConditions{PublicKeys: []PublicKey{pk}, SignaturesRequired: 1},
+					},
+				},
+				Signatures: []Signature{{5}},
+			}},
+			SiafundInputs: []SiafundInput{{
+				Parent:      SiafundElement{StateElement: StateElement{ID: id(2)}, ClaimStart: Siacoins(1)},
+				SpendPolicy: AnyoneCanSpend(),
+			}},
+			SiafundOutputs:        []SiafundOutput{{Value: 9}},
+			FileContracts:         []FileContract{fc},
+			FileContractRevisions: []FileContractRevision{{Parent: FileContractElement{StateElement{ID: id(3)}, fc}, Revision: fc}},
+			FileContractResolutions: []FileContractResolution{{
+				Parent: FileContractElement{StateElement{ID: id(4)}, fc},
+				StorageProof: StorageProof{
+					WindowStart:  ChainIndex{Height: 10, ID: BlockID{6}},
+					DataSegment:  [64]byte{7, 63: 8},
+					SegmentProof: []Hash256{{9}},
+				},
+			}},
+			Attestations:  []Attestation{{PublicKey: pk, Key: "foo", Value: []byte("bar")}},
+			ArbitraryData: []byte("baz"),
+			MinerFee:      Siacoins(1),
+		}},
+	}
+	js, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"siacoinInputs"`, `"spendPolicy":{"type":"thresh"`, `"id":"h:01`, `"minerFee":"`, `"dataSegment":"07`} {
+		if !bytes.Contains(js, []byte(field)) {
+			t.Errorf("JSON is missing %v", field)
+		}
+	}
+	var b2 Block
+	if err := json.Unmarshal(js, &b2); err != nil {
+		t.Fatal(err)
+	}
+	var buf1, buf2 bytes.Buffer
+	e1, e2 := NewEncoder(&buf1), NewEncoder(&buf2)
+	b.Header.EncodeTo(e1)
+	b.Transactions[0].EncodeTo(e1)
+	b2.Header.EncodeTo(e2)
+	b2.Transactions[0].EncodeTo(e2)
+	e1.Flush()
+	e2.Flush()
+	if !bytes.Equal(buf1.Bytes(), buf2.Bytes()) {
+		t.Fatal("block did not survive JSON round-trip")
+	}
+
+	var w Work
+	if err := json.Unmarshal([]byte(`"1618"`), &w); err != nil {
+		t.Fatal(err)
+	} else if w.String() != "1618" {
+		t.Fatal("Work did not survive JSON round-trip:", w)
+	}
+}
+
 func BenchmarkWork(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
@@ -93,3 +227,200 @@ func BenchmarkBlockHeaderID(b *testing.B) {
 		_ = bh.ID()
 	}
 }
+
+func TestSprint(t *testing.T) {
+	pk := GeneratePrivateKey().PublicKey()
+	b := Block{
+		Header: BlockHeader{Height: 7, ParentID: BlockID{1}},
+		Transactions: []Transaction{{
+			SiacoinInputs: []SiacoinInput{{
+				Parent: SiacoinElement{
+					StateElement:  StateElement{MerkleProof: make([]Hash256, 12)},
+					SiacoinOutput: SiacoinOutput{Value: Siacoins(5)},
+				},
+				SpendPolicy: PolicyThreshold{N: 1, Of: []SpendPolicy{PolicyAbove(100), PolicyPublicKey(pk)}},
+			}},
+			SiacoinOutputs: []SiacoinOutput{{Value: Siacoins(3).Div64(2)}},
+			MinerFee:       Siacoins(1),
+		}},
+	}
+	s := Sprint(&b)
+	for _, exp := range []string{
+		b.Index().String(),
+		b.Header.ParentID.String(),
+		b.Transactions[0].ID().String(),
+		"value:   5 SC",
+		"siacoin output 0: 1.5 SC",
+		"(proof: 12 hashes)",
+		"policy:  thresh(1, above(100), pk(" + pk.String() + "))",
+		"miner fee: 1 SC",
+	} {
+		if !strings.Contains(s, exp) {
+			t.Errorf("expected output to contain %q:\n%v", exp, s)
+		}
+	}
+	if s := Sprint(ChainIndex{Height: 1}); s != (ChainIndex{Height: 1}).String()+"\n" {
+		t.Error("expected Sprint to fall back to String method, got", s)
+	}
+}
+
+func TestBech32m(t *testing.T) {
+	// test vectors from BIP-350
+	for _, s := range []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	} {
+		if _, _, err := bech32mDecode(s); err != nil {
+			t.Errorf("expected %q to be valid, got %v", s, err)
+		}
+	}
+	for _, s := range []string{
+		"A1G7SGD8",     // checksum computed with uppercase prefix
+		"a12uel5l",     // bech32, not bech32m
+		"1xj0phk",      // empty prefix
+		"M1VUXWEZ",     // checksum computed with uppercase prefix
+		"16plkw9",      // empty prefix
+		"qyrz8wqd2c9m", // no separator
+		"1qyrz8wqd2c9m",
+		"y1b0jsk6g",  // invalid character
+		"lt1igcx5c0", // invalid character
+		"in1muywd",   // checksum too short
+		"mm1crxm3i",  // invalid character in checksum
+		"au1s5cgom",
+		"Abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx", // mixed case
+	} {
+		if _, _, err := bech32mDecode(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+func TestAddressString(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		var a Address
+		frand.Read(a[:])
+		s := a.String()
+		if !strings.HasPrefix(s, AddressPrefix+"1") {
+			t.Fatal("address missing prefix:", s)
+		} else if b, err := ParseAddress(s); err != nil || b != a {
+			t.Fatal("address did not round-trip:", s, err)
+		} else if b, err := ParseAddress(strings.ToUpper(s)); err != nil || b != a {
+			t.Fatal("uppercase address did not round-trip:", s, err)
+		} else if b, err := ParseAddress(a.LegacyString()); err != nil || b != a {
+			t.Fatal("legacy address did not round-trip:", a.LegacyString(), err)
+		}
+
+		// corrupt a single character
+		j := len(AddressPrefix) + 1 + frand.Intn(len(s)-len(AddressPrefix)-1)
+		c := strings.IndexByte(bech32Charset, s[j])
+		typo := s[:j] + string(bech32Charset[(c+1+frand.Intn(31))%32]) + s[j+1:]
+		if _, err := ParseAddress(typo); err == nil {
+			t.Fatal("typo not detected:", typo)
+		}
+	}
+
+	if _, err := ParseAddress(bech32mEncode("btc", make([]byte, 32))); err == nil {
+		t.Fatal("expected error for wrong prefix")
+	} else if _, err := ParseAddress(bech32mEncode(AddressPrefix, make([]byte, 20))); err == nil {
+		t.Fatal("expected error for wrong length")
+	}
+}
+
+func TestIDText(t *testing.T) {
+	ids := []struct {
+		v   encoding.TextMarshaler
+		dst encoding.TextUnmarshaler
+	}{
+		{Hash256{0xab, 0xcd}, new(Hash256)},
+		{BlockID{0xab, 0xcd}, new(BlockID)},
+		{TransactionID{0xab, 0xcd}, new(TransactionID)},
+		{PublicKey{0xab, 0xcd}, new(PublicKey)},
+		{Signature{0xab, 0xcd}, new(Signature)},
+		{ElementID{Source: Hash256{0xab, 0xcd}, Index: 7}, new(ElementID)},
+		{ChainIndex{Height: 7, ID: BlockID{0xab, 0xcd}}, new(ChainIndex)},
+	}
+	for _, id := range ids {
+		text, _ := id.v.MarshalText()
+		if err := id.dst.UnmarshalText(text); err != nil {
+			t.Fatalf("%T: %v", id.v, err)
+		} else if reflect.ValueOf(id.dst).Elem().Interface() != id.v {
+			t.Fatalf("%T did not round-trip through %q", id.v, text)
+		}
+		// strict parsing
+		for _, bad := range [][]byte{
+			text[:len(text)-2],
+			append(append([]byte(nil), text...), "ff"...),
+			bytes.ToUpper(text),
+			bytes.Replace(text, []byte(":"), []byte("x:"), 1),
+			text[bytes.IndexByte(text, ':')+1:],
+		} {
+			if err := id.dst.UnmarshalText(bad); err == nil {
+				t.Errorf("%T: expected error for %q", id.v, bad)
+			}
+		}
+	}
+
+	eid := ElementID{Source: Hash256{0xab, 0xcd}, Index: 7}
+	for _, prefix := range []string{ElementIDPrefix, SiacoinElementIDPrefix, SiafundElementIDPrefix, FileContractElementIDPrefix} {
+		s := eid.PrefixedString(prefix)
+		if !strings.HasPrefix(s, prefix+":") {
+			t.Fatal("missing prefix:", s)
+		} else if got, err := ParseElementID(s); err != nil || got != eid {
+			t.Fatal("ElementID did not round-trip:", s, err)
+		}
+	}
+	if _, err := ParseElementID(strings.Replace(eid.String(), ":7", ":07", 1)); err == nil {
+		t.Fatal("expected error for non-canonical index")
+	}
+}
+
+func TestEntropySource(t *testing.T) {
+	defer SetEntropySource(nil)
+
+	SetEntropySource(frand.NewCustom(make([]byte, 32), 1024, 12))
+	k1 := GeneratePrivateKey()
+	SetEntropySource(frand.NewCustom(make([]byte, 32), 1024, 12))
+	k2 := GeneratePrivateKey()
+	if !bytes.Equal(k1, k2) {
+		t.Fatal("deterministic source produced different keys")
+	}
+
+	SetEntropySource(nil)
+	if bytes.Equal(GeneratePrivateKey(), k1) {
+		t.Fatal("default source reproduced deterministic key")
+	}
+}
+
+func TestPrivateKeyGuards(t *testing.T) {
+	priv := GeneratePrivateKey()
+	if !priv.Equal(append(PrivateKey(nil), priv...)) {
+		t.Fatal("key should equal its copy")
+	} else if priv.Equal(GeneratePrivateKey()) {
+		t.Fatal("distinct keys should not be equal")
+	}
+
+	hexKey := hex.EncodeToString(priv)
+	for _, s := range []string{
+		fmt.Sprint(priv),
+		fmt.Sprintf("%v %+v %#v %s %x", priv, priv, priv, priv, priv),
+	} {
+		if strings.Contains(s, hexKey[:16]) {
+			t.Fatal("formatted key leaks secret material:", s)
+		}
+	}
+	if _, err := json.Marshal(priv); err == nil {
+		t.Fatal("expected JSON marshalling to fail")
+	} else if _, err := json.Marshal(struct{ Key PrivateKey }{priv}); err == nil {
+		t.Fatal("expected JSON marshalling of embedded key to fail")
+	}
+
+	priv.Close()
+	for _, b := range priv {
+		if b != 0 {
+			t.Fatal("key was not zeroed")
+		}
+	}
+}
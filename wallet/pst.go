@@ -0,0 +1,254 @@
+package wallet
+
+import (
+	"errors"
+	"fmt"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// pstMagic identifies the binary encoding of a PartiallySignedTransaction.
+var pstMagic = [4]byte{'s', 'p', 's', 't'}
+
+const pstVersion = 1
+
+// A SignerHint helps a signer locate the private key for a public key, e.g. by
+// specifying a derivation path or hardware wallet fingerprint. The format of
+// the hint is application-defined.
+type SignerHint struct {
+	PublicKey types.PublicKey `json:"publicKey"`
+	Hint      string          `json:"hint"`
+}
+
+// A PartialSignature is a signature of a transaction by a particular key.
+type PartialSignature struct {
+	PublicKey types.PublicKey `json:"publicKey"`
+	Signature types.Signature `json:"signature"`
+}
+
+// A PartiallySignedTransaction accumulates the signatures for a transaction
+// from multiple signers, e.g. the participants in a multisig wallet, or a
+// hardware wallet that cannot see the full transaction. Each signer adds their
+// signatures (via Sign or AddSignature), and the resulting transactions are
+// combined with Merge. Once enough signatures have been collected, Finalize
+// assembles them into a valid transaction.
+//
+// Since every input of a transaction is signed with the same hash, a single
+// signature per key suffices for all of the inputs whose policies reference
+// that key. Consequently, inputs must use the default SigHash flags
+// (SigHashAll).
+type PartiallySignedTransaction struct {
+	// Transaction is the transaction being signed. Its inputs specify the
+	// spend policies (and any preimages) that must be satisfied; their
+	// signatures are ignored.
+	Transaction types.Transaction  `json:"transaction"`
+	Signatures  []PartialSignature `json:"signatures"`
+	Hints       []SignerHint       `json:"hints"`
+}
+
+func policyKeys(p types.SpendPolicy, fn func(types.PublicKey)) {
+	switch p := p.(type) {
+	case types.PolicyPublicKey:
+		fn(types.PublicKey(p))
+	case types.PolicyThreshold:
+		for _, sub := range p.Of {
+			policyKeys(sub, fn)
+		}
+	case types.PolicyUnlockConditions:
+		for _, pk := range p.PublicKeys {
+			fn(pk)
+		}
+	}
+}
+
+// Keys returns the public keys referenced by the spend policies of the
+// transaction's inputs, in order of first appearance. Not all keys will
+// necessarily be required to sign.
+func (pst *PartiallySignedTransaction) Keys() []types.PublicKey {
+	var keys []types.PublicKey
+	seen := make(map[types.PublicKey]bool)
+	add := func(pk types.PublicKey) {
+		if !seen[pk] {
+			seen[pk] = true
+			keys = append(keys, pk)
+		}
+	}
+	for _, in := range pst.Transaction.SiacoinInputs {
+		policyKeys(in.SpendPolicy, add)
+	}
+	for _, in := range pst.Transaction.SiafundInputs {
+		policyKeys(in.SpendPolicy, add)
+	}
+	return keys
+}
+
+func (pst *PartiallySignedTransaction) signature(pk types.PublicKey) (types.Signature, bool) {
+	for _, ps := range pst.Signatures {
+		if ps.PublicKey == pk {
+			return ps.Signature, true
+		}
+	}
+	return types.Signature{}, false
+}
+
+// AddHint adds a hint for the specified key.
+func (pst *PartiallySignedTransaction) AddHint(pk types.PublicKey, hint string) {
+	h := SignerHint{pk, hint}
+	for _, existing := range pst.Hints {
+		if existing == h {
+			return
+		}
+	}
+	pst.Hints = append(pst.Hints, h)
+}
+
+// AddSignature adds a signature by pk, which must be valid for the
+// transaction under vc. It is intended for signatures produced externally,
+// e.g. by a hardware wallet signing the hash returned by vc.InputSigHash.
+func (pst *PartiallySignedTransaction) AddSignature(vc consensus.ValidationContext, pk types.PublicKey, sig types.Signature) error {
+	if !pk.VerifyHash(vc.InputSigHash(pst.Transaction), sig) {
+		return fmt.Errorf("invalid signature for key %v", pk)
+	} else if _, ok := pst.signature(pk); !ok {
+		pst.Signatures = append(pst.Signatures, PartialSignature{pk, sig})
+	}
+	return nil
+}
+
+// Sign adds a signature for each key in keys that is referenced by the
+// transaction's spend policies and has not already signed. It returns the
+// number of signatures added.
+func (pst *PartiallySignedTransaction) Sign(vc consensus.ValidationContext, keys map[types.PublicKey]types.PrivateKey) int {
+	sigHash := vc.InputSigHash(pst.Transaction)
+	var n int
+	for _, pk := range pst.Keys() {
+		priv, ok := keys[pk]
+		if _, signed := pst.signature(pk); ok && !signed {
+			pst.Signatures = append(pst.Signatures, PartialSignature{pk, priv.SignHash(sigHash)})
+			n++
+		}
+	}
+	return n
+}
+
+// sameInput reports whether two inputs have the same spend policy and
+// preimages.
+func sameInput(p1, p2 types.SpendPolicy, pre1, pre2 [][32]byte) bool {
+	if len(pre1) != len(pre2) {
+		return false
+	}
+	for i := range pre1 {
+		if pre1[i] != pre2[i] {
+			return false
+		}
+	}
+	if p1 == nil || p2 == nil {
+		return p1 == p2
+	}
+	return p1.String() == p2.String()
+}
+
+// Merge adds the signatures and hints of other, which must contain the same
+// transaction, to pst.
+func (pst *PartiallySignedTransaction) Merge(other PartiallySignedTransaction) error {
+	if pst.Transaction.ID() != other.Transaction.ID() {
+		return errors.New("transactions differ")
+	}
+	for i := range pst.Transaction.SiacoinInputs {
+		a, b := pst.Transaction.SiacoinInputs[i], other.Transaction.SiacoinInputs[i]
+		if !sameInput(a.SpendPolicy, b.SpendPolicy, a.Preimages, b.Preimages) {
+			return fmt.Errorf("siacoin input %v differs", i)
+		}
+	}
+	for i := range pst.Transaction.SiafundInputs {
+		a, b := pst.Transaction.SiafundInputs[i], other.Transaction.SiafundInputs[i]
+		if !sameInput(a.SpendPolicy, b.SpendPolicy, a.Preimages, b.Preimages) {
+			return fmt.Errorf("siafund input %v differs", i)
+		}
+	}
+	for _, ps := range other.Signatures {
+		if _, ok := pst.signature(ps.PublicKey); !ok {
+			pst.Signatures = append(pst.Signatures, ps)
+		}
+	}
+	for _, h := range other.Hints {
+		pst.AddHint(h.PublicKey, h.Hint)
+	}
+	return nil
+}
+
+// Finalize returns the transaction, with each input's signatures assembled
+// from the collected signatures in the order expected by consensus validation.
+// If any input's policy cannot be satisfied with the collected signatures,
+// Finalize returns an error.
+func (pst *PartiallySignedTransaction) Finalize(vc consensus.ValidationContext) (types.Transaction, error) {
+	txn := pst.Transaction.DeepCopy()
+	sigHash := vc.InputSigHash(txn)
+	s := &signer{
+		vc: vc,
+		sign: func(pk types.PublicKey, h types.Hash256) (types.Signature, bool) {
+			if h != sigHash {
+				return types.Signature{}, false
+			}
+			return pst.signature(pk)
+		},
+	}
+	if err := s.signInputs(&txn); err != nil {
+		return types.Transaction{}, err
+	}
+	return txn, nil
+}
+
+// EncodeTo implements types.EncoderTo.
+func (pst PartiallySignedTransaction) EncodeTo(e *types.Encoder) {
+	e.Write(pstMagic[:])
+	e.WriteUint8(pstVersion)
+	pst.Transaction.EncodeTo(e)
+	e.WritePrefix(len(pst.Signatures))
+	for _, ps := range pst.Signatures {
+		ps.PublicKey.EncodeTo(e)
+		ps.Signature.EncodeTo(e)
+	}
+	e.WritePrefix(len(pst.Hints))
+	for _, h := range pst.Hints {
+		h.PublicKey.EncodeTo(e)
+		e.WriteString(h.Hint)
+	}
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (pst *PartiallySignedTransaction) DecodeFrom(d *types.Decoder) {
+	var magic [4]byte
+	d.Read(magic[:])
+	if magic != pstMagic {
+		d.SetErr(errors.New("not a partially-signed transaction"))
+		return
+	} else if v := d.ReadUint8(); v != pstVersion {
+		d.SetErr(fmt.Errorf("unsupported partially-signed transaction version (%v)", v))
+		return
+	}
+	pst.Transaction.DecodeFrom(d)
+	pst.Signatures = make([]PartialSignature, d.ReadSlicePrefix(32+64))
+	for i := range pst.Signatures {
+		pst.Signatures[i].PublicKey.DecodeFrom(d)
+		pst.Signatures[i].Signature.DecodeFrom(d)
+	}
+	pst.Hints = make([]SignerHint, d.ReadSlicePrefix(32+8))
+	for i := range pst.Hints {
+		pst.Hints[i].PublicKey.DecodeFrom(d)
+		pst.Hints[i].Hint = d.ReadString()
+	}
+}
+
+// NewPartiallySignedTransaction returns a PartiallySignedTransaction for txn.
+// Any existing signatures on txn are discarded.
+func NewPartiallySignedTransaction(txn types.Transaction) *PartiallySignedTransaction {
+	txn = txn.DeepCopy()
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].Signatures = nil
+	}
+	for i := range txn.SiafundInputs {
+		txn.SiafundInputs[i].Signatures = nil
+	}
+	return &PartiallySignedTransaction{Transaction: txn}
+}
@@ -0,0 +1,118 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestPartiallySignedTransaction(t *testing.T) {
+	privs := make([]types.PrivateKey, 3)
+	pubkeys := make([]types.PublicKey, 3)
+	for i := range privs {
+		privs[i] = types.NewPrivateKeyFromSeed([32]byte{byte(i)})
+		pubkeys[i] = privs[i].PublicKey()
+	}
+	preimage := [32]byte{1, 2, 3}
+	policies := []types.SpendPolicy{
+		types.MultisigPolicy(2, pubkeys...),
+		types.AllOf(types.PolicyPublicKey(pubkeys[2]), types.PolicyHash(types.HashBytes(preimage[:]))),
+	}
+	var outputs []types.SiacoinOutput
+	for _, p := range policies {
+		outputs = append(outputs, types.SiacoinOutput{
+			Address: types.PolicyAddress(p),
+			Value:   types.Siacoins(1),
+		})
+	}
+	genesis := types.Block{
+		Header:       types.BlockHeader{Timestamp: time.Unix(734600000, 0)},
+		Transactions: []types.Transaction{{SiacoinOutputs: outputs}},
+	}
+	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 1}})
+	vc := sau.Context
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   types.Siacoins(uint32(len(policies))),
+		}},
+	}
+	for i, p := range policies {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			Parent:      sau.NewSiacoinElements[i+1],
+			SpendPolicy: p,
+		})
+	}
+	txn.SiacoinInputs[1].Preimages = [][32]byte{preimage}
+	pst := NewPartiallySignedTransaction(txn)
+	if keys := pst.Keys(); len(keys) != 3 || keys[0] != pubkeys[0] || keys[2] != pubkeys[2] {
+		t.Fatal("wrong keys:", keys)
+	}
+	pst.AddHint(pubkeys[2], "m/44'/1991'/0'/0/2")
+
+	// each party signs their own copy; one is shared via the binary encoding,
+	// and the other via JSON
+	var buf bytes.Buffer
+	e := types.NewEncoder(&buf)
+	pst.EncodeTo(e)
+	e.Flush()
+	var pstA PartiallySignedTransaction
+	d := types.NewBufDecoder(buf.Bytes())
+	pstA.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	js, _ := json.Marshal(pst)
+	var pstB PartiallySignedTransaction
+	if err := json.Unmarshal(js, &pstB); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := pstA.Sign(vc, map[types.PublicKey]types.PrivateKey{pubkeys[0]: privs[0]}); n != 1 {
+		t.Fatal("expected 1 signature, got", n)
+	} else if _, err := pstA.Finalize(vc); err == nil {
+		t.Fatal("expected finalization to fail with a single signature")
+	}
+	// party B signs externally, e.g. with a hardware wallet
+	sig := privs[2].SignHash(vc.InputSigHash(pstB.Transaction))
+	if err := pstB.AddSignature(vc, pubkeys[1], sig); err == nil {
+		t.Fatal("expected invalid signature to be rejected")
+	} else if err := pstB.AddSignature(vc, pubkeys[2], sig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pstA.Merge(pstB); err != nil {
+		t.Fatal(err)
+	} else if len(pstA.Signatures) != 2 || len(pstA.Hints) != 1 {
+		t.Fatal("merge did not combine signatures and hints")
+	}
+	final, err := pstA.Finalize(vc)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := vc.ValidateTransaction(final); err != nil {
+		t.Fatal(err)
+	}
+
+	// merging a different transaction should fail
+	other := NewPartiallySignedTransaction(txn)
+	other.Transaction.SiacoinOutputs[0].Address = types.Address{1}
+	if err := pstA.Merge(*other); err == nil {
+		t.Fatal("expected merge of different transactions to fail")
+	}
+
+	// decoding a transaction as a PST should fail
+	buf.Reset()
+	e = types.NewEncoder(&buf)
+	txn.EncodeTo(e)
+	e.Flush()
+	d = types.NewBufDecoder(buf.Bytes())
+	(&PartiallySignedTransaction{}).DecodeFrom(d)
+	if d.Err() == nil {
+		t.Fatal("expected decoding to fail")
+	}
+}
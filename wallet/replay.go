@@ -0,0 +1,88 @@
+package wallet
+
+import (
+	"sort"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+// A ProofUpdater updates the Merkle proofs of state elements. It is
+// implemented by *chain.Manager.
+type ProofUpdater interface {
+	UpdateElementProof(e *types.StateElement, a, b types.ChainIndex) error
+}
+
+// PrepareReplay identifies the transactions in reverted blocks that are still
+// valid under vc, and prepares them for rebroadcast. Only transactions for
+// which relevant returns true are considered.
+//
+// Each returned transaction has its proofs updated to vc.Index, via pu. If a
+// transaction spends an output created by another replayed transaction, the
+// corresponding input is converted to spend an ephemeral output, so the
+// returned transactions should be broadcast together, in order. Transactions
+// whose inputs have been spent or no longer exist, and transactions containing
+// storage proofs, are not replayed.
+//
+// Since signatures do not cover Merkle proofs, the returned transactions do
+// not need to be re-signed.
+func PrepareReplay(vc consensus.ValidationContext, pu ProofUpdater, reverted []types.Block, relevant func(types.Transaction) bool) []types.Transaction {
+	// replay in the order the transactions originally appeared
+	blocks := append([]types.Block(nil), reverted...)
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Header.Height < blocks[j].Header.Height
+	})
+
+	var replay []types.Transaction
+	created := make(map[types.ElementID]bool)
+	for _, b := range blocks {
+	txnLoop:
+		for _, txn := range b.Transactions {
+			if !relevant(txn) {
+				continue
+			}
+			txn = txn.DeepCopy()
+			for _, fcr := range txn.FileContractResolutions {
+				if fcr.HasStorageProof() {
+					continue txnLoop
+				}
+			}
+			updateProof := func(e *types.StateElement) bool {
+				return pu.UpdateElementProof(e, b.Header.ParentIndex(), vc.Index) == nil
+			}
+			for i := range txn.SiacoinInputs {
+				in := &txn.SiacoinInputs[i]
+				if created[in.Parent.ID] {
+					in.Parent.LeafIndex = types.EphemeralLeafIndex
+					in.Parent.MerkleProof = nil
+				} else if in.Parent.LeafIndex != types.EphemeralLeafIndex && !updateProof(&in.Parent.StateElement) {
+					continue txnLoop
+				}
+			}
+			for i := range txn.SiafundInputs {
+				if !updateProof(&txn.SiafundInputs[i].Parent.StateElement) {
+					continue txnLoop
+				}
+			}
+			for i := range txn.FileContractRevisions {
+				if !updateProof(&txn.FileContractRevisions[i].Parent.StateElement) {
+					continue txnLoop
+				}
+			}
+			for i := range txn.FileContractResolutions {
+				if !updateProof(&txn.FileContractResolutions[i].Parent.StateElement) {
+					continue txnLoop
+				}
+			}
+			if vc.ValidateTransactionSet(append(replay, txn)) != nil {
+				continue
+			}
+			replay = append(replay, txn)
+			txid := txn.ID()
+			for i := range txn.SiacoinOutputs {
+				created[types.ElementID{Source: types.Hash256(txid), Index: uint64(i)}] = true
+			}
+		}
+	}
+	return replay
+}
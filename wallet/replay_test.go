@@ -0,0 +1,143 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/chain"
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/internal/chainutil"
+	"go.sia.tech/core/types"
+)
+
+type revertRecorder struct {
+	reverted []types.Block
+}
+
+func (rr *revertRecorder) ProcessChainApplyUpdate(*chain.ApplyUpdate, bool) error { return nil }
+
+func (rr *revertRecorder) ProcessChainRevertUpdate(cru *chain.RevertUpdate) error {
+	rr.reverted = append(rr.reverted, cru.Block)
+	return nil
+}
+
+func TestPrepareReplay(t *testing.T) {
+	priv := types.NewPrivateKeyFromSeed([32]byte{1})
+	pk := priv.PublicKey()
+	addr := types.StandardAddress(pk)
+	keys := map[types.PublicKey]types.PrivateKey{pk: priv}
+
+	genesis := types.Block{
+		Header: types.BlockHeader{Timestamp: time.Unix(734600000, 0)},
+		Transactions: []types.Transaction{{SiacoinOutputs: []types.SiacoinOutput{
+			{Address: addr, Value: types.Siacoins(1)},
+			{Address: addr, Value: types.Siacoins(2)},
+		}}},
+	}
+	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 1}})
+	checkpoint := consensus.Checkpoint{Block: genesis, Context: sau.Context}
+	fs, _, err := chainutil.NewFlatStore(t.TempDir(), checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := chain.NewManager(fs, sau.Context)
+	defer cm.Close()
+	rr := new(revertRecorder)
+	if err := cm.AddSubscriber(rr, cm.Tip()); err != nil {
+		t.Fatal(err)
+	}
+
+	mine := func(vc consensus.ValidationContext, parent types.BlockHeader, nonce uint64, txns ...types.Transaction) (types.Block, consensus.ApplyUpdate) {
+		b := types.Block{
+			Header: types.BlockHeader{
+				Height:       parent.Height + 1,
+				ParentID:     parent.ID(),
+				Nonce:        nonce,
+				Timestamp:    parent.Timestamp.Add(time.Second),
+				MinerAddress: types.VoidAddress,
+			},
+			Transactions: txns,
+		}
+		b.Header.Commitment = vc.Commitment(b.Header.MinerAddress, b.Transactions)
+		chainutil.FindBlockNonce(&b.Header, types.HashRequiringWork(vc.Difficulty))
+		au := consensus.ApplyBlock(vc, b)
+		return b, au
+	}
+	spend := func(vc consensus.ValidationContext, sce types.SiacoinElement) types.Transaction {
+		txn := types.Transaction{
+			SiacoinInputs:  []types.SiacoinInput{{Parent: sce, SpendPolicy: types.PolicyPublicKey(pk)}},
+			SiacoinOutputs: []types.SiacoinOutput{{Address: addr, Value: sce.Value}},
+		}
+		if err := SignTransaction(vc, &txn, keys); err != nil {
+			t.Fatal(err)
+		}
+		return txn
+	}
+	o1, o2 := sau.NewSiacoinElements[1], sau.NewSiacoinElements[2]
+
+	// mine a chain containing:
+	//
+	//   A: o1 -> x
+	//   B: x -> y (in a later block)
+	//   C: o2 -> z
+	vc, parent := sau.Context, genesis.Header
+	txnA := spend(vc, o1)
+	b1, au := mine(vc, parent, 0, txnA)
+	if err := cm.AddTipBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	var x types.SiacoinElement
+	for _, sce := range au.NewSiacoinElements {
+		if sce.Address == addr {
+			x = sce
+		}
+	}
+	vc, parent = au.Context, b1.Header
+	txnB := spend(vc, x)
+	o2c := o2
+	o2c.MerkleProof = append([]types.Hash256(nil), o2.MerkleProof...)
+	au.UpdateElementProof(&o2c.StateElement)
+	txnC := spend(vc, o2c)
+	b2, _ := mine(vc, parent, 0, txnB, txnC)
+	if err := cm.AddTipBlock(b2); err != nil {
+		t.Fatal(err)
+	}
+
+	// mine a longer fork that double-spends o2
+	vc, parent = sau.Context, genesis.Header
+	fb, fau := mine(vc, parent, 1<<48, spend(vc, o2))
+	fork := []types.Block{fb}
+	for i := 0; i < 2; i++ {
+		vc, parent = fau.Context, fb.Header
+		fb, fau = mine(vc, parent, 1<<48)
+		fork = append(fork, fb)
+	}
+	if _, err := cm.AddHeaders(chainutil.JustHeaders(fork)); err != nil {
+		t.Fatal(err)
+	} else if _, err := cm.AddBlocks(fork); err != nil {
+		t.Fatal(err)
+	} else if cm.Tip() != fork[len(fork)-1].Index() {
+		t.Fatal("expected reorg to fork")
+	} else if len(rr.reverted) != 2 {
+		t.Fatal("expected 2 reverted blocks, got", len(rr.reverted))
+	}
+
+	// A and B should be replayed, with B spending A's output ephemerally; C
+	// conflicts with the fork and should not be
+	tip := cm.TipContext()
+	replay := PrepareReplay(tip, cm, rr.reverted, func(types.Transaction) bool { return true })
+	if len(replay) != 2 {
+		t.Fatal("expected 2 replayed transactions, got", len(replay))
+	} else if replay[0].ID() != txnA.ID() || replay[1].ID() != txnB.ID() {
+		t.Fatal("wrong transactions replayed")
+	} else if replay[1].SiacoinInputs[0].Parent.LeafIndex != types.EphemeralLeafIndex {
+		t.Fatal("expected B to spend an ephemeral output")
+	} else if err := tip.ValidateTransactionSet(replay); err != nil {
+		t.Fatal(err)
+	}
+
+	// irrelevant transactions are ignored
+	if replay := PrepareReplay(tip, cm, rr.reverted, func(txn types.Transaction) bool { return txn.ID() != txnA.ID() }); len(replay) != 0 {
+		t.Fatal("expected no replayed transactions, got", len(replay))
+	}
+}
@@ -0,0 +1,127 @@
+// Package seed implements BIP-39 mnemonic phrases, which encode the entropy
+// from which a wallet's keys are derived.
+//
+// Phrases are converted to a master key for the derivation API in the types
+// package, so a given phrase (and passphrase) always produces the same
+// addresses. Legacy 28- and 29-word seeds are not supported.
+package seed
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.sia.tech/core/types"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrUnknownWord is returned when a phrase contains a word that is not in
+	// the wordlist.
+	ErrUnknownWord = errors.New("unknown word")
+	// ErrChecksum is returned when a phrase's checksum is invalid, typically
+	// because a word was mistyped or the words are out of order.
+	ErrChecksum = errors.New("invalid checksum")
+)
+
+var wordIndex = func() map[string]int {
+	m := make(map[string]int, len(wordlist))
+	for i, w := range wordlist {
+		m[w] = i
+	}
+	return m
+}()
+
+func validEntropyLen(n int) bool {
+	return 16 <= n && n <= 32 && n%4 == 0
+}
+
+// NewMnemonic encodes entropy as a mnemonic phrase. The entropy must be 16,
+// 20, 24, 28, or 32 bytes long, yielding 12, 15, 18, 21, or 24 words,
+// respectively.
+func NewMnemonic(entropy []byte) (string, error) {
+	if !validEntropyLen(len(entropy)) {
+		return "", fmt.Errorf("invalid entropy length (%v bytes)", len(entropy))
+	}
+	checksum := sha256.Sum256(entropy)
+	bits := append(append([]byte(nil), entropy...), checksum[0])
+	bit := func(i int) int { return int(bits[i/8]>>(7-i%8)) & 1 }
+
+	words := make([]string, len(entropy)*3/4)
+	for i := range words {
+		var idx int
+		for j := 0; j < 11; j++ {
+			idx = idx<<1 | bit(i*11+j)
+		}
+		words[i] = wordlist[idx]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// GenerateMnemonic returns a random mnemonic phrase of the specified length,
+// which must be 12, 15, 18, 21, or 24 words.
+func GenerateMnemonic(words int) string {
+	entropy := make([]byte, words*4/3)
+	if words%3 != 0 || !validEntropyLen(len(entropy)) {
+		panic("invalid mnemonic length") // developer error
+	}
+	types.ReadEntropy(entropy)
+	phrase, _ := NewMnemonic(entropy)
+	return phrase
+}
+
+// MnemonicToEntropy decodes a mnemonic phrase, returning its entropy. Words
+// are matched case-insensitively, and may be separated by any whitespace.
+func MnemonicToEntropy(phrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words)%3 != 0 || !validEntropyLen(len(words)*4/3) {
+		return nil, fmt.Errorf("invalid mnemonic length (%v words)", len(words))
+	}
+	bits := make([]byte, (len(words)*11+7)/8)
+	for i, w := range words {
+		idx, ok := wordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownWord, w)
+		}
+		for j := 0; j < 11; j++ {
+			if idx&(1<<(10-j)) != 0 {
+				n := i*11 + j
+				bits[n/8] |= 1 << (7 - n%8)
+			}
+		}
+	}
+	entropy := bits[:len(words)*4/3]
+	checksumBits := uint(len(words) / 3)
+	checksum := sha256.Sum256(entropy)
+	if checksum[0]>>(8-checksumBits) != bits[len(entropy)]>>(8-checksumBits) {
+		return nil, ErrChecksum
+	}
+	return entropy, nil
+}
+
+// ValidateMnemonic returns an error if phrase is not a valid mnemonic phrase.
+func ValidateMnemonic(phrase string) error {
+	_, err := MnemonicToEntropy(phrase)
+	return err
+}
+
+// ToSeed converts a mnemonic phrase and optional passphrase into a 64-byte
+// seed, as specified by BIP-39. The phrase is not validated. The passphrase
+// must already be in Unicode normalization form NFKD; ASCII passphrases
+// always are.
+func ToSeed(phrase, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(strings.ToLower(phrase)), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
+
+// MasterKey validates a mnemonic phrase and returns the root of its key
+// hierarchy, from which keys are derived with the types package's derivation
+// API.
+func MasterKey(phrase, passphrase string) (types.ExtendedKey, error) {
+	if err := ValidateMnemonic(phrase); err != nil {
+		return types.ExtendedKey{}, err
+	}
+	return types.NewMasterKey(ToSeed(phrase, passphrase)), nil
+}
@@ -0,0 +1,99 @@
+package seed
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMnemonicVectors(t *testing.T) {
+	// test vectors from the BIP-39 reference implementation, using the
+	// passphrase "TREZOR"
+	tests := []struct {
+		entropy string
+		phrase  string
+		seed    string
+	}{
+		{
+			"00000000000000000000000000000000",
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f",
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			"80808080808080808080808080808080",
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+		},
+		{
+			"ffffffffffffffffffffffffffffffff",
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+		},
+	}
+	for _, test := range tests {
+		entropy, _ := hex.DecodeString(test.entropy)
+		if phrase, err := NewMnemonic(entropy); err != nil {
+			t.Fatal(err)
+		} else if phrase != test.phrase {
+			t.Errorf("wrong phrase for %v: %q", test.entropy, phrase)
+		} else if dec, err := MnemonicToEntropy(phrase); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(dec, entropy) {
+			t.Errorf("wrong entropy for %q: %x", phrase, dec)
+		} else if seed := hex.EncodeToString(ToSeed(phrase, "TREZOR")); seed != test.seed {
+			t.Errorf("wrong seed for %q: %v", phrase, seed)
+		}
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	for _, n := range []int{12, 15, 18, 21, 24} {
+		phrase := GenerateMnemonic(n)
+		if len(strings.Fields(phrase)) != n {
+			t.Fatalf("expected %v words, got %q", n, phrase)
+		} else if err := ValidateMnemonic(phrase); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	phrase := GenerateMnemonic(12)
+	mk, err := MasterKey(phrase, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// case and whitespace are not significant
+	if mk2, err := MasterKey(" "+strings.ToUpper(strings.ReplaceAll(phrase, " ", "\n\t"))+" ", ""); err != nil {
+		t.Fatal(err)
+	} else if mk2 != mk {
+		t.Fatal("normalized phrase produced a different key")
+	} else if mk3, _ := MasterKey(phrase, "passphrase"); mk3 == mk {
+		t.Fatal("passphrase did not affect key")
+	}
+
+	words := strings.Fields(phrase)
+	invalid := []struct {
+		phrase string
+		err    error
+	}{
+		{strings.Join(words[:11], " "), nil},
+		{strings.Join(append(words[:11:11], "notaword"), " "), ErrUnknownWord},
+		{"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", ErrChecksum},
+	}
+	for _, test := range invalid {
+		if err := ValidateMnemonic(test.phrase); err == nil {
+			t.Errorf("expected error for %q", test.phrase)
+		} else if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("expected %v for %q, got %v", test.err, test.phrase, err)
+		}
+	}
+	if _, err := NewMnemonic(make([]byte, 15)); err == nil {
+		t.Fatal("expected error for invalid entropy length")
+	}
+}
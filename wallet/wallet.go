@@ -0,0 +1,126 @@
+// Package wallet implements transaction signing.
+package wallet
+
+import (
+	"fmt"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+type signer struct {
+	vc   consensus.ValidationContext
+	sign func(types.PublicKey, types.Hash256) (types.Signature, bool)
+	// the sighash and preimages of the input being signed
+	sigHash   types.Hash256
+	preimages [][32]byte
+}
+
+// satisfy returns the signatures required to satisfy p, in the order expected
+// by consensus validation. If p cannot be satisfied with the available keys,
+// satisfy returns false. Since validation consumes signatures and preimages
+// even when a sub-policy fails, satisfy mirrors validSpendPolicies exactly: the
+// signatures of a failed sub-policy are still returned, and preimages are
+// consumed in order.
+func (s *signer) satisfy(p types.SpendPolicy) ([]types.Signature, bool) {
+	switch p := p.(type) {
+	case types.PolicyAbove:
+		return nil, s.vc.Index.Height > uint64(p)
+	case types.PolicyPublicKey:
+		sig, ok := s.sign(types.PublicKey(p), s.sigHash)
+		if !ok {
+			return nil, false
+		}
+		return []types.Signature{sig}, true
+	case types.PolicyThreshold:
+		var sigs []types.Signature
+		n := p.N
+		for i := 0; i < len(p.Of) && n > 0 && len(p.Of[i:]) >= int(n); i++ {
+			subSigs, ok := s.satisfy(p.Of[i])
+			sigs = append(sigs, subSigs...)
+			if ok {
+				n--
+			}
+		}
+		return sigs, n == 0
+	case types.PolicyUnlockConditions:
+		if _, ok := s.satisfy(types.PolicyAbove(p.Timelock)); !ok {
+			return nil, false
+		}
+		thresh := types.PolicyThreshold{
+			N:  p.SignaturesRequired,
+			Of: make([]types.SpendPolicy, len(p.PublicKeys)),
+		}
+		for i, pk := range p.PublicKeys {
+			thresh.Of[i] = types.PolicyPublicKey(pk)
+		}
+		return s.satisfy(thresh)
+	case types.PolicyHash:
+		// preimages are not signatures, and must be supplied by the caller
+		for i, pre := range s.preimages {
+			if types.HashBytes(pre[:]) == types.Hash256(p) {
+				s.preimages = s.preimages[i+1:]
+				return nil, true
+			}
+		}
+		return nil, false
+	case types.PolicyOpaque:
+		return nil, false
+	}
+	panic("invalid policy type") // developer error
+}
+
+// signInputs assembles the signatures for every siacoin and siafund input of
+// txn, replacing any existing signatures. If any input's policy cannot be
+// satisfied, txn is left unmodified.
+func (s *signer) signInputs(txn *types.Transaction) error {
+	sciSigs := make([][]types.Signature, len(txn.SiacoinInputs))
+	for i, in := range txn.SiacoinInputs {
+		s.sigHash = s.vc.SiacoinInputSigHash(*txn, i)
+		s.preimages = in.Preimages
+		sigs, ok := s.satisfy(in.SpendPolicy)
+		if !ok {
+			return fmt.Errorf("cannot satisfy spend policy of siacoin input %v", i)
+		}
+		sciSigs[i] = sigs
+	}
+	sfiSigs := make([][]types.Signature, len(txn.SiafundInputs))
+	for i, in := range txn.SiafundInputs {
+		s.sigHash = s.vc.SiafundInputSigHash(*txn, i)
+		s.preimages = in.Preimages
+		sigs, ok := s.satisfy(in.SpendPolicy)
+		if !ok {
+			return fmt.Errorf("cannot satisfy spend policy of siafund input %v", i)
+		}
+		sfiSigs[i] = sigs
+	}
+	for i := range txn.SiacoinInputs {
+		txn.SiacoinInputs[i].Signatures = sciSigs[i]
+	}
+	for i := range txn.SiafundInputs {
+		txn.SiafundInputs[i].Signatures = sfiSigs[i]
+	}
+	return nil
+}
+
+// SignTransaction signs every siacoin and siafund input of txn, replacing any
+// existing signatures. For each input, it determines which keys are required
+// to satisfy the input's spend policy, and assembles the corresponding
+// signatures in the order expected by consensus validation. Any preimages
+// required by PolicyHash must already be present in the input, in the order
+// expected by consensus validation. Each input is signed according to its
+// SigHash flags. If any input's policy cannot be satisfied with the provided
+// keys, SignTransaction returns an error and txn is left unmodified.
+func SignTransaction(vc consensus.ValidationContext, txn *types.Transaction, keys map[types.PublicKey]types.PrivateKey) error {
+	s := &signer{
+		vc: vc,
+		sign: func(pk types.PublicKey, sigHash types.Hash256) (types.Signature, bool) {
+			priv, ok := keys[pk]
+			if !ok {
+				return types.Signature{}, false
+			}
+			return priv.SignHash(sigHash), true
+		},
+	}
+	return s.signInputs(txn)
+}
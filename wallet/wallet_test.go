@@ -0,0 +1,121 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+)
+
+func TestSignTransaction(t *testing.T) {
+	keys := make(map[types.PublicKey]types.PrivateKey)
+	var pubkeys []types.PublicKey
+	for i := 0; i < 4; i++ {
+		priv := types.NewPrivateKeyFromSeed([32]byte{byte(i)})
+		pubkeys = append(pubkeys, priv.PublicKey())
+		keys[priv.PublicKey()] = priv
+	}
+	unknown := types.NewPrivateKeyFromSeed([32]byte{255}).PublicKey()
+
+	policies := []types.SpendPolicy{
+		types.PolicyPublicKey(pubkeys[0]),
+		types.PolicyThreshold{
+			N: 2,
+			Of: []types.SpendPolicy{
+				types.PolicyPublicKey(unknown),
+				types.PolicyPublicKey(pubkeys[1]),
+				types.PolicyPublicKey(pubkeys[2]),
+			},
+		},
+		types.PolicyThreshold{
+			N: 1,
+			Of: []types.SpendPolicy{
+				types.PolicyAbove(100),
+				types.PolicyThreshold{
+					N:  1,
+					Of: []types.SpendPolicy{types.PolicyPublicKey(unknown), types.PolicyPublicKey(pubkeys[3])},
+				},
+			},
+		},
+		types.PolicyUnlockConditions{
+			PublicKeys:         []types.PublicKey{pubkeys[0], unknown, pubkeys[2]},
+			SignaturesRequired: 2,
+		},
+		// the failed first branch still consumes a signature during
+		// validation, so pubkeys[0] must sign twice
+		types.AnyOf(
+			types.AllOf(types.PolicyPublicKey(pubkeys[0]), types.PolicyPublicKey(unknown)),
+			types.AllOf(types.PolicyPublicKey(pubkeys[0])),
+		),
+		types.AnyoneCanSpend(),
+	}
+
+	var outputs []types.SiacoinOutput
+	for _, p := range policies {
+		outputs = append(outputs, types.SiacoinOutput{
+			Address: types.PolicyAddress(p),
+			Value:   types.Siacoins(1),
+		})
+	}
+	genesis := types.Block{
+		Header:       types.BlockHeader{Timestamp: time.Unix(734600000, 0)},
+		Transactions: []types.Transaction{{SiacoinOutputs: outputs}},
+	}
+	sau := consensus.GenesisUpdate(genesis, types.Work{NumHashes: [32]byte{31: 1}})
+
+	// apply an empty block so that zero-height timelocks are satisfied
+	au := consensus.ApplyBlock(sau.Context, types.Block{
+		Header: types.BlockHeader{Height: 1, ParentID: genesis.ID()},
+	})
+	vc := au.Context
+	for i := range sau.NewSiacoinElements {
+		au.UpdateElementProof(&sau.NewSiacoinElements[i].StateElement)
+	}
+
+	txn := types.Transaction{
+		SiacoinOutputs: []types.SiacoinOutput{{
+			Address: types.VoidAddress,
+			Value:   types.Siacoins(uint32(len(policies))),
+		}},
+	}
+	for i, p := range policies {
+		txn.SiacoinInputs = append(txn.SiacoinInputs, types.SiacoinInput{
+			Parent:      sau.NewSiacoinElements[i+1],
+			SpendPolicy: p,
+		})
+	}
+	if err := SignTransaction(vc, &txn, keys); err != nil {
+		t.Fatal(err)
+	} else if err := vc.ValidateTransaction(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	// inputs should be signed according to their sighash flags
+	flagged := txn.DeepCopy()
+	flagged.SiacoinInputs[0].SigHash = types.SigHashAnyoneCanPay
+	if err := SignTransaction(vc, &flagged, keys); err != nil {
+		t.Fatal(err)
+	} else if err := vc.ValidateTransaction(flagged); err != nil {
+		t.Fatal(err)
+	}
+
+	// signing without a required key should fail and leave txn unmodified
+	delete(keys, pubkeys[2])
+	signed := txn.DeepCopy()
+	if err := SignTransaction(vc, &signed, keys); err == nil {
+		t.Fatal("expected error when required key is missing")
+	} else if signed.ID() != txn.ID() || len(signed.SiacoinInputs[0].Signatures) != 1 {
+		t.Fatal("transaction was modified")
+	}
+
+	// unsatisfiable timelocks should also fail
+	txn.SiacoinInputs = txn.SiacoinInputs[:1]
+	txn.SiacoinInputs[0].SpendPolicy = types.PolicyThreshold{
+		N:  2,
+		Of: []types.SpendPolicy{types.PolicyAbove(10), types.PolicyPublicKey(pubkeys[0])},
+	}
+	if err := SignTransaction(vc, &txn, keys); err == nil {
+		t.Fatal("expected error for unsatisfiable timelock")
+	}
+}